@@ -3,11 +3,14 @@ module github.com/stoik/vigil
 go 1.21
 
 require (
+	github.com/cespare/xxhash/v2 v2.3.0
 	github.com/gin-gonic/gin v1.9.1
 	github.com/google/uuid v1.6.0
 	github.com/jackc/pgx/v5 v5.5.1
+	github.com/klauspost/compress v1.17.4
 	github.com/spf13/cobra v1.8.0
 	github.com/spf13/viper v1.18.2
+	lukechampine.com/blake3 v1.2.1
 )
 
 require (
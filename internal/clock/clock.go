@@ -0,0 +1,218 @@
+// Package clock abstracts time, so time-dependent behavior (staggered delays, periodic polls,
+// retention cutoffs, generation cycles) can be tested with a fake clock instead of real sleeps
+package clock
+
+import (
+	"sort"
+	"sync"
+	"time"
+)
+
+// Clock tells the time and creates timers and tickers
+type Clock interface {
+	Now() time.Time
+	Since(t time.Time) time.Duration
+	// After is NewTimer(d).C(), for a wait that is never stopped
+	After(d time.Duration) <-chan time.Time
+	NewTimer(d time.Duration) Timer
+	NewTicker(d time.Duration) Ticker
+}
+
+// Timer is a *time.Timer of a Clock
+type Timer interface {
+	C() <-chan time.Time
+	Stop() bool
+	Reset(d time.Duration) bool
+}
+
+// Ticker is a *time.Ticker of a Clock
+type Ticker interface {
+	C() <-chan time.Time
+	Stop()
+	Reset(d time.Duration)
+}
+
+// Real is the system clock
+var Real Clock = realClock{}
+
+type realClock struct{}
+
+func (realClock) Now() time.Time                         { return time.Now() }
+func (realClock) Since(t time.Time) time.Duration        { return time.Since(t) }
+func (realClock) After(d time.Duration) <-chan time.Time { return time.After(d) }
+func (realClock) NewTimer(d time.Duration) Timer         { return realTimer{time.NewTimer(d)} }
+func (realClock) NewTicker(d time.Duration) Ticker       { return realTicker{time.NewTicker(d)} }
+
+type realTimer struct{ t *time.Timer }
+
+func (t realTimer) C() <-chan time.Time        { return t.t.C }
+func (t realTimer) Stop() bool                 { return t.t.Stop() }
+func (t realTimer) Reset(d time.Duration) bool { return t.t.Reset(d) }
+
+type realTicker struct{ t *time.Ticker }
+
+func (t realTicker) C() <-chan time.Time   { return t.t.C }
+func (t realTicker) Stop()                 { t.t.Stop() }
+func (t realTicker) Reset(d time.Duration) { t.t.Reset(d) }
+
+// Fake is a Clock whose time only moves with Advance or Set
+//
+// Timers and tickers fire, in order, as time passes their deadline. Like the real ones, their
+// channel holds one tick and a ticker drops ticks nobody received. Code under test usually
+// creates its timers from another goroutine: BlockUntil waits for them before time is advanced.
+type Fake struct {
+	mu      sync.Mutex
+	cond    *sync.Cond
+	now     time.Time
+	waiters []*fakeWaiter // Active timers and tickers
+}
+
+// NewFake returns a fake clock set to now
+func NewFake(now time.Time) *Fake {
+	f := &Fake{now: now}
+	f.cond = sync.NewCond(&f.mu)
+	return f
+}
+
+func (f *Fake) Now() time.Time {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	return f.now
+}
+
+func (f *Fake) Since(t time.Time) time.Duration {
+	return f.Now().Sub(t)
+}
+
+func (f *Fake) After(d time.Duration) <-chan time.Time {
+	return f.NewTimer(d).C()
+}
+
+func (f *Fake) NewTimer(d time.Duration) Timer {
+	t := fakeTimer{&fakeWaiter{f: f, c: make(chan time.Time, 1)}}
+	t.Reset(d)
+	return t
+}
+
+func (f *Fake) NewTicker(d time.Duration) Ticker {
+	if d <= 0 {
+		panic("clock: non-positive interval for NewTicker")
+	}
+	t := fakeTicker{&fakeWaiter{f: f, c: make(chan time.Time, 1)}}
+	t.Reset(d)
+	return t
+}
+
+// Advance moves the time forward by d, firing the timers and tickers due on the way
+func (f *Fake) Advance(d time.Duration) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	f.advanceLocked(f.now.Add(d))
+}
+
+// Set moves the time to t (forward only: it does nothing for a time in the past)
+func (f *Fake) Set(t time.Time) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	if t.After(f.now) {
+		f.advanceLocked(t)
+	}
+}
+
+func (f *Fake) advanceLocked(target time.Time) {
+	for {
+		sort.SliceStable(f.waiters, func(i, j int) bool { return f.waiters[i].at.Before(f.waiters[j].at) })
+		if len(f.waiters) == 0 || f.waiters[0].at.After(target) {
+			break
+		}
+		w := f.waiters[0]
+		f.now = w.at
+		w.fireLocked()
+	}
+	f.now = target
+}
+
+// Waiters returns how many timers and tickers are waiting to fire
+func (f *Fake) Waiters() int {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	return len(f.waiters)
+}
+
+// BlockUntil waits until at least n timers and tickers are waiting to fire
+func (f *Fake) BlockUntil(n int) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	for len(f.waiters) < n {
+		f.cond.Wait()
+	}
+}
+
+func (f *Fake) addLocked(w *fakeWaiter) {
+	f.waiters = append(f.waiters, w)
+	f.cond.Broadcast()
+}
+
+// removeLocked drops w from the waiters, returning false if it was not waiting
+func (f *Fake) removeLocked(w *fakeWaiter) bool {
+	for i, other := range f.waiters {
+		if other == w {
+			f.waiters = append(f.waiters[:i], f.waiters[i+1:]...)
+			return true
+		}
+	}
+	return false
+}
+
+// fakeWaiter is a timer (period 0) or ticker of a Fake
+type fakeWaiter struct {
+	f      *Fake
+	c      chan time.Time
+	at     time.Time // Next deadline
+	period time.Duration
+}
+
+func (w *fakeWaiter) C() <-chan time.Time { return w.c }
+
+func (w *fakeWaiter) fireLocked() {
+	select {
+	case w.c <- w.at:
+	default: // Not received yet: the tick is dropped
+	}
+	if w.period > 0 {
+		w.at = w.at.Add(w.period)
+	} else {
+		w.f.removeLocked(w)
+	}
+}
+
+// stop removes w from the waiters, returning false if it was not waiting
+func (w *fakeWaiter) stop() bool {
+	w.f.mu.Lock()
+	defer w.f.mu.Unlock()
+	return w.f.removeLocked(w)
+}
+
+// reset schedules w d from now, then every period for a ticker
+func (w *fakeWaiter) reset(d, period time.Duration) bool {
+	w.f.mu.Lock()
+	defer w.f.mu.Unlock()
+	active := w.f.removeLocked(w)
+	w.at, w.period = w.f.now.Add(d), period
+	if d <= 0 {
+		w.fireLocked()
+	} else {
+		w.f.addLocked(w)
+	}
+	return active
+}
+
+type fakeTimer struct{ *fakeWaiter }
+
+func (t fakeTimer) Stop() bool                 { return t.stop() }
+func (t fakeTimer) Reset(d time.Duration) bool { return t.reset(d, 0) }
+
+type fakeTicker struct{ *fakeWaiter }
+
+func (t fakeTicker) Stop()                 { t.stop() }
+func (t fakeTicker) Reset(d time.Duration) { t.reset(d, d) }
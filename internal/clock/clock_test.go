@@ -0,0 +1,90 @@
+package clock
+
+import (
+	"testing"
+	"time"
+)
+
+var epoch = time.Date(2024, 3, 1, 12, 0, 0, 0, time.UTC)
+
+// received returns the tick waiting on c, if any
+func received(c <-chan time.Time) (time.Time, bool) {
+	select {
+	case t := <-c:
+		return t, true
+	default:
+		return time.Time{}, false
+	}
+}
+
+func TestFakeTimer(t *testing.T) {
+	f := NewFake(epoch)
+	timer := f.NewTimer(10 * time.Second)
+
+	f.Advance(9 * time.Second)
+	if _, ok := received(timer.C()); ok {
+		t.Fatal("timer fired early")
+	}
+	f.Advance(5 * time.Second)
+	if at, ok := received(timer.C()); !ok || !at.Equal(epoch.Add(10*time.Second)) {
+		t.Fatalf("timer fired at %v, %v; want its deadline", at, ok)
+	}
+	if !f.Now().Equal(epoch.Add(14*time.Second)) || f.Waiters() != 0 {
+		t.Errorf("now = %v with %d waiters", f.Now(), f.Waiters())
+	}
+
+	if timer.Reset(time.Second) {
+		t.Error("Reset of a fired timer reported it active")
+	}
+	if !timer.Stop() {
+		t.Error("Stop of a pending timer reported it inactive")
+	}
+	f.Advance(time.Minute)
+	if _, ok := received(timer.C()); ok {
+		t.Error("stopped timer fired")
+	}
+
+	if _, ok := received(f.After(0)); !ok {
+		t.Error("After(0) did not fire right away")
+	}
+}
+
+func TestFakeTicker(t *testing.T) {
+	f := NewFake(epoch)
+	ticker := f.NewTicker(30 * time.Second)
+	defer ticker.Stop()
+
+	var ticks []time.Time
+	for i := 0; i < 3; i++ {
+		f.Advance(30 * time.Second)
+		if at, ok := received(ticker.C()); ok {
+			ticks = append(ticks, at)
+		}
+	}
+	if len(ticks) != 3 || !ticks[2].Equal(epoch.Add(90*time.Second)) {
+		t.Fatalf("ticks = %v, want one every 30s", ticks)
+	}
+
+	// Ticks nobody receives are dropped, as with time.Ticker
+	f.Advance(5 * time.Minute)
+	if _, ok := received(ticker.C()); !ok {
+		t.Fatal("no tick after 5 minutes")
+	}
+	if _, ok := received(ticker.C()); ok {
+		t.Error("more than one tick buffered")
+	}
+}
+
+func TestFakeBlockUntil(t *testing.T) {
+	f := NewFake(epoch)
+	done := make(chan time.Time)
+	go func() {
+		done <- <-f.After(time.Hour)
+	}()
+
+	f.BlockUntil(1)
+	f.Advance(time.Hour)
+	if at := <-done; !at.Equal(epoch.Add(time.Hour)) {
+		t.Errorf("woke at %v", at)
+	}
+}
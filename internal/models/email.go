@@ -16,6 +16,9 @@ type ProviderEmail struct {
 	Snippet    string    `json:"snippet"`
 	ReceivedAt time.Time `json:"received_at"`
 	Body       string    `json:"body,omitempty"` // Full content, optional
+	// Raw message headers (Content-Type, Received, Authentication-Results, ...), optional
+	// Body is encoded as described by Content-Type / Content-Transfer-Encoding
+	Headers map[string][]string `json:"headers,omitempty"`
 }
 
 // GoogleEmail is an alias for ProviderEmail (backward compatibility)
@@ -25,10 +28,15 @@ type GoogleEmail = ProviderEmail
 // id is the message_id from the provider API (parsed as UUID)
 // fingerprint is a hash of email body content for identification
 // Full content is not stored - fetch from provider API when needed
+// sender_domain is the From domain only, detected_at is set once analysis flags the email
 type Email struct {
-	ID          uuid.UUID `db:"id"`
-	Fingerprint string    `db:"fingerprint"`
-	ReceivedAt  time.Time `db:"received_at"`
+	ID           uuid.UUID  `db:"id"`
+	Fingerprint  string     `db:"fingerprint"`
+	ReceivedAt   time.Time  `db:"received_at"`
+	SenderDomain *string    `db:"sender_domain"`
+	DetectedAt   *time.Time `db:"detected_at"`
+	Subject      *string    `db:"subject"` // Only stored when full-text search is enabled
+	Snippet      *string    `db:"snippet"`
 }
 
 type UserEmail struct {
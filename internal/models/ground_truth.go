@@ -0,0 +1,27 @@
+package models
+
+import (
+	"time"
+
+	"github.com/google/uuid"
+)
+
+// GroundTruthEmail records an email the mock provider generated, for reconciliation
+// GeneratedAt is when the email became visible to pollers; ReceivedAt may be earlier
+// for backdated (late-arriving) emails. CampaignID is set for bulk emails whose body
+// was delivered identically to many users
+type GroundTruthEmail struct {
+	MessageID   string    `json:"message_id"`
+	ReceivedAt  time.Time `json:"received_at"`
+	GeneratedAt time.Time `json:"generated_at"`
+	Backdated   bool      `json:"backdated"`
+	CampaignID  string    `json:"campaign_id,omitempty"`
+}
+
+// GroundTruth lists the emails generated for a user within [From, To) by generation time
+type GroundTruth struct {
+	UserID uuid.UUID          `json:"user_id"`
+	From   time.Time          `json:"from"`
+	To     time.Time          `json:"to"`
+	Emails []GroundTruthEmail `json:"emails"`
+}
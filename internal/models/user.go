@@ -14,6 +14,11 @@ type ProviderUser struct {
 	TenantID  uuid.UUID `json:"tenant_id"`
 	Active    bool      `json:"active"`
 	CreatedAt time.Time `json:"created_at"`
+	// Directory attributes (optional, not every provider exposes all of them)
+	OrgUnit string   `json:"org_unit,omitempty"` // Org unit path, e.g. "/Finance"
+	Title   string   `json:"title,omitempty"`
+	Manager string   `json:"manager,omitempty"` // Manager's email address
+	Groups  []string `json:"groups,omitempty"`  // Group email addresses
 }
 
 // GoogleUser is an alias for ProviderUser (backward compatibility)
@@ -0,0 +1,11 @@
+// Command vigil is the contributor entry point: `vigil dev` runs the mock server, migrations
+// and discovery in one process. The other discovery commands are available under it too.
+package main
+
+import (
+	"github.com/stoik/vigil/services/discovery-service/internal/app"
+)
+
+func main() {
+	app.ExecuteVigil()
+}
@@ -0,0 +1,205 @@
+package api
+
+import (
+	"context"
+	"crypto/subtle"
+	"errors"
+	"fmt"
+	"log"
+	"net/http"
+	"strings"
+
+	"github.com/gin-gonic/gin"
+	"github.com/spf13/viper"
+	"github.com/stoik/vigil/services/discovery-service/internal/audit"
+)
+
+// Role grants access to API routes; each role includes the ones below it
+type Role int
+
+const (
+	RoleReporter Role = iota + 1 // Report suspicious emails only (report-phish buttons and add-ins)
+	RoleViewer                   // Read-only queries (search, events)
+	RoleOperator                 // Sensitive reads and operational actions (email content, state dumps)
+	RoleAdmin                    // Configuration and remediation
+)
+
+func (r Role) String() string {
+	switch r {
+	case RoleReporter:
+		return "reporter"
+	case RoleViewer:
+		return "viewer"
+	case RoleOperator:
+		return "operator"
+	case RoleAdmin:
+		return "admin"
+	default:
+		return "none"
+	}
+}
+
+// ParseRole parses a role name
+func ParseRole(s string) (Role, error) {
+	switch strings.ToLower(strings.TrimSpace(s)) {
+	case "reporter":
+		return RoleReporter, nil
+	case "viewer":
+		return RoleViewer, nil
+	case "operator":
+		return RoleOperator, nil
+	case "admin":
+		return RoleAdmin, nil
+	default:
+		return 0, fmt.Errorf("unknown role %q (want reporter, viewer, operator or admin)", s)
+	}
+}
+
+// Principal is an authenticated API caller
+type Principal struct {
+	Name   string
+	Role   Role
+	Method string // "api_key" or "oidc"
+}
+
+var (
+	errNoCredentials      = errors.New("missing bearer token")
+	errInvalidCredentials = errors.New("invalid token")
+)
+
+const principalKey = "principal"
+
+// auditFunc records an audit entry (audit.Record, replaced in tests)
+type auditFunc func(ctx context.Context, actor, action, target, outcome string) error
+
+// authenticator resolves bearer tokens to principals, from static API keys or OIDC ID/access tokens
+type authenticator struct {
+	keys  map[string]Principal
+	oidc  *oidcVerifier
+	audit auditFunc
+}
+
+// newAuthenticator creates an authenticator from the api.* configuration
+func newAuthenticator() *authenticator {
+	a := &authenticator{
+		keys:  parseAPIKeys(viper.GetStringSlice("api.tokens")),
+		audit: audit.Record,
+	}
+	if issuer := viper.GetString("api.oidc.issuer"); issuer != "" {
+		a.oidc = newOIDCVerifier(issuer, viper.GetString("api.oidc.audience"), viper.GetString("api.oidc.roles_claim"))
+	}
+	return a
+}
+
+// parseAPIKeys parses "name:token[:role]" entries into a token -> principal map
+// The role defaults to viewer
+func parseAPIKeys(entries []string) map[string]Principal {
+	keys := make(map[string]Principal)
+	for _, entry := range entries {
+		parts := strings.SplitN(entry, ":", 3)
+		if len(parts) < 2 || parts[0] == "" || parts[1] == "" {
+			log.Printf("Ignoring malformed API token entry (want name:token[:role])")
+			continue
+		}
+		role := RoleViewer
+		if len(parts) == 3 {
+			var err error
+			if role, err = ParseRole(parts[2]); err != nil {
+				log.Printf("Ignoring API token %q: %v", parts[0], err)
+				continue
+			}
+		}
+		keys[parts[1]] = Principal{Name: parts[0], Role: role, Method: "api_key"}
+	}
+	return keys
+}
+
+// enabled reports whether any authentication method is configured
+func (a *authenticator) enabled() bool {
+	return len(a.keys) > 0 || a.oidc != nil
+}
+
+// authenticate resolves the request's bearer token
+func (a *authenticator) authenticate(c *gin.Context) (Principal, error) {
+	presented, ok := strings.CutPrefix(c.GetHeader("Authorization"), "Bearer ")
+	if !ok || presented == "" {
+		return Principal{}, errNoCredentials
+	}
+
+	// JWTs go to the OIDC verifier, anything else is looked up as an API key
+	if a.oidc != nil && strings.Count(presented, ".") == 2 {
+		principal, err := a.oidc.verify(c.Request.Context(), presented)
+		if err != nil {
+			log.Printf("OIDC token rejected: %v", err)
+			return Principal{}, errInvalidCredentials
+		}
+		return principal, nil
+	}
+
+	for token, principal := range a.keys {
+		if subtle.ConstantTimeCompare([]byte(presented), []byte(token)) == 1 {
+			return principal, nil
+		}
+	}
+	return Principal{}, errInvalidCredentials
+}
+
+// require authenticates the caller and enforces the minimum role for a route
+// Rejected credentials and insufficient roles are audited; a missing token is not
+func (a *authenticator) require(role Role) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		if !a.enabled() {
+			c.AbortWithStatusJSON(http.StatusForbidden, gin.H{"error": "endpoint disabled: no API authentication configured"})
+			return
+		}
+
+		principal, err := a.authenticate(c)
+		if err != nil {
+			if errors.Is(err, errInvalidCredentials) {
+				a.audit(c.Request.Context(), "unknown", "api.authenticate", c.FullPath(), audit.OutcomeDenied)
+			}
+			c.AbortWithStatusJSON(http.StatusUnauthorized, gin.H{"error": err.Error()})
+			return
+		}
+
+		if principal.Role < role {
+			a.audit(c.Request.Context(), principal.Name, "api.authorize", c.FullPath(), audit.OutcomeDenied)
+			c.AbortWithStatusJSON(http.StatusForbidden, gin.H{"error": fmt.Sprintf("requires %s role", role)})
+			return
+		}
+
+		c.Set(principalKey, principal)
+		c.Next()
+	}
+}
+
+// audited records a privileged action as attempted before running the handler, failing closed
+// if the audit entry cannot be written, then records its outcome from the response status
+func (a *authenticator) audited(action string) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		name, target := actor(c), c.Request.URL.Path
+		if err := a.audit(c.Request.Context(), name, action, target, audit.OutcomeAttempted); err != nil {
+			log.Printf("Error writing audit entry for %s: %v", action, err)
+			c.AbortWithStatusJSON(http.StatusInternalServerError, gin.H{"error": "audit logging failed"})
+			return
+		}
+		c.Next()
+
+		outcome := audit.OutcomeSuccess
+		if c.Writer.Status() >= http.StatusBadRequest {
+			outcome = audit.OutcomeFailure
+		}
+		// The request may be done; the attempted entry already covers the action
+		if err := a.audit(context.WithoutCancel(c.Request.Context()), name, action, target, outcome); err != nil {
+			log.Printf("Error writing audit outcome for %s: %v", action, err)
+		}
+	}
+}
+
+// actor returns the authenticated caller name
+func actor(c *gin.Context) string {
+	if v, ok := c.Get(principalKey); ok {
+		return v.(Principal).Name
+	}
+	return "unknown"
+}
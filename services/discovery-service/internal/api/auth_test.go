@@ -0,0 +1,237 @@
+package api
+
+import (
+	"context"
+	"crypto"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"math/big"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/stoik/vigil/services/discovery-service/internal/audit"
+)
+
+type auditEntry struct{ actor, action, outcome string }
+
+func testAuthenticator(entries ...string) (*authenticator, *[]auditEntry) {
+	var log []auditEntry
+	return &authenticator{
+		keys: parseAPIKeys(entries),
+		audit: func(_ context.Context, actor, action, _, outcome string) error {
+			log = append(log, auditEntry{actor, action, outcome})
+			return nil
+		},
+	}, &log
+}
+
+func TestParseAPIKeys(t *testing.T) {
+	keys := parseAPIKeys([]string{"soc:tok1", "ops:tok2:operator", "root:tok3:ADMIN", "bad", "x:tok4:superuser", ":tok5", "phish-button:tok6:reporter"})
+	want := map[string]Principal{
+		"tok1": {Name: "soc", Role: RoleViewer, Method: "api_key"},
+		"tok2": {Name: "ops", Role: RoleOperator, Method: "api_key"},
+		"tok3": {Name: "root", Role: RoleAdmin, Method: "api_key"},
+		"tok6": {Name: "phish-button", Role: RoleReporter, Method: "api_key"},
+	}
+	if len(keys) != len(want) {
+		t.Fatalf("keys = %v, want %v", keys, want)
+	}
+	for token, p := range want {
+		if keys[token] != p {
+			t.Errorf("keys[%s] = %+v, want %+v", token, keys[token], p)
+		}
+	}
+}
+
+func TestRequireRole(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+	a, log := testAuthenticator("soc:viewer-token", "ops:operator-token:operator")
+
+	r := gin.New()
+	r.GET("/read", a.require(RoleViewer), func(c *gin.Context) { c.String(http.StatusOK, actor(c)) })
+	r.GET("/operate", a.require(RoleOperator), func(c *gin.Context) { c.String(http.StatusOK, actor(c)) })
+
+	tests := []struct {
+		path, token string
+		status      int
+	}{
+		{"/read", "", http.StatusUnauthorized},
+		{"/read", "wrong", http.StatusUnauthorized},
+		{"/read", "viewer-token", http.StatusOK},
+		{"/read", "operator-token", http.StatusOK},
+		{"/operate", "viewer-token", http.StatusForbidden},
+		{"/operate", "operator-token", http.StatusOK},
+	}
+	for _, tt := range tests {
+		req := httptest.NewRequest(http.MethodGet, tt.path, nil)
+		if tt.token != "" {
+			req.Header.Set("Authorization", "Bearer "+tt.token)
+		}
+		w := httptest.NewRecorder()
+		r.ServeHTTP(w, req)
+		if w.Code != tt.status {
+			t.Errorf("GET %s with %q = %d, want %d", tt.path, tt.token, w.Code, tt.status)
+		}
+	}
+
+	// The invalid token and the insufficient role are audited, the missing token is not
+	want := []auditEntry{
+		{"unknown", "api.authenticate", audit.OutcomeDenied},
+		{"soc", "api.authorize", audit.OutcomeDenied},
+	}
+	if len(*log) != len(want) || (*log)[0] != want[0] || (*log)[1] != want[1] {
+		t.Errorf("audit log = %v, want %v", *log, want)
+	}
+}
+
+func TestAuditedRecordsOutcome(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+	a, log := testAuthenticator("root:admin-token:admin")
+
+	r := gin.New()
+	r.POST("/ok", a.require(RoleAdmin), a.audited("job.submit"), func(c *gin.Context) { c.Status(http.StatusAccepted) })
+	r.POST("/fail", a.require(RoleAdmin), a.audited("job.cancel"), func(c *gin.Context) {
+		c.JSON(http.StatusConflict, gin.H{"error": "job already finished"})
+	})
+	for _, path := range []string{"/ok", "/fail"} {
+		req := httptest.NewRequest(http.MethodPost, path, nil)
+		req.Header.Set("Authorization", "Bearer admin-token")
+		r.ServeHTTP(httptest.NewRecorder(), req)
+	}
+
+	// Attempted before the handler, then the outcome of the response: a failed handler is not a success
+	want := []auditEntry{
+		{"root", "job.submit", audit.OutcomeAttempted},
+		{"root", "job.submit", audit.OutcomeSuccess},
+		{"root", "job.cancel", audit.OutcomeAttempted},
+		{"root", "job.cancel", audit.OutcomeFailure},
+	}
+	if fmt.Sprint(*log) != fmt.Sprint(want) {
+		t.Errorf("audit log = %v, want %v", *log, want)
+	}
+}
+
+func TestRequireWithoutAuthConfigured(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+	a, _ := testAuthenticator()
+
+	r := gin.New()
+	r.GET("/read", a.require(RoleViewer), func(c *gin.Context) { c.Status(http.StatusOK) })
+
+	w := httptest.NewRecorder()
+	r.ServeHTTP(w, httptest.NewRequest(http.MethodGet, "/read", nil))
+	if w.Code != http.StatusForbidden {
+		t.Errorf("status = %d, want %d", w.Code, http.StatusForbidden)
+	}
+}
+
+// testIssuer serves OIDC discovery and a JWKS with one RSA key
+func testIssuer(t *testing.T) (*httptest.Server, *rsa.PrivateKey) {
+	t.Helper()
+	key, err := rsa.GenerateKey(rand.Reader, 2048)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	mux := http.NewServeMux()
+	srv := httptest.NewServer(mux)
+	mux.HandleFunc("/.well-known/openid-configuration", func(w http.ResponseWriter, r *http.Request) {
+		json.NewEncoder(w).Encode(map[string]string{"issuer": srv.URL, "jwks_uri": srv.URL + "/jwks"})
+	})
+	mux.HandleFunc("/jwks", func(w http.ResponseWriter, r *http.Request) {
+		json.NewEncoder(w).Encode(map[string]any{"keys": []map[string]string{{
+			"kty": "RSA",
+			"kid": "k1",
+			"use": "sig",
+			"n":   base64.RawURLEncoding.EncodeToString(key.N.Bytes()),
+			"e":   base64.RawURLEncoding.EncodeToString(big.NewInt(int64(key.E)).Bytes()),
+		}}})
+	})
+	t.Cleanup(srv.Close)
+	return srv, key
+}
+
+func signJWT(t *testing.T, key *rsa.PrivateKey, kid string, claims map[string]any) string {
+	t.Helper()
+	header, _ := json.Marshal(map[string]string{"alg": "RS256", "typ": "JWT", "kid": kid})
+	payload, _ := json.Marshal(claims)
+	signed := base64.RawURLEncoding.EncodeToString(header) + "." + base64.RawURLEncoding.EncodeToString(payload)
+	digest := sha256.Sum256([]byte(signed))
+	sig, err := rsa.SignPKCS1v15(rand.Reader, key, crypto.SHA256, digest[:])
+	if err != nil {
+		t.Fatal(err)
+	}
+	return signed + "." + base64.RawURLEncoding.EncodeToString(sig)
+}
+
+func TestOIDCVerify(t *testing.T) {
+	srv, key := testIssuer(t)
+	v := newOIDCVerifier(srv.URL, "vigil", "")
+	now := time.Now()
+
+	claims := func(overrides map[string]any) map[string]any {
+		c := map[string]any{
+			"iss":   srv.URL,
+			"aud":   []string{"vigil", "other"},
+			"exp":   now.Add(time.Hour).Unix(),
+			"sub":   "user-1",
+			"email": "analyst@example.com",
+			"roles": []string{"viewer", "operator"},
+		}
+		for k, val := range overrides {
+			c[k] = val
+		}
+		return c
+	}
+
+	principal, err := v.verify(context.Background(), signJWT(t, key, "k1", claims(nil)))
+	if err != nil {
+		t.Fatalf("verify: %v", err)
+	}
+	if principal.Name != "analyst@example.com" || principal.Role != RoleOperator || principal.Method != "oidc" {
+		t.Errorf("principal = %+v", principal)
+	}
+
+	otherKey, err := rsa.GenerateKey(rand.Reader, 2048)
+	if err != nil {
+		t.Fatal(err)
+	}
+	rejected := map[string]string{
+		"expired":        signJWT(t, key, "k1", claims(map[string]any{"exp": now.Add(-time.Hour).Unix()})),
+		"wrong audience": signJWT(t, key, "k1", claims(map[string]any{"aud": "someone-else"})),
+		"wrong issuer":   signJWT(t, key, "k1", claims(map[string]any{"iss": "https://evil.example.com"})),
+		"unknown kid":    signJWT(t, key, "k2", claims(nil)),
+		"bad signature":  signJWT(t, otherKey, "k1", claims(nil)),
+	}
+	for name, token := range rejected {
+		if _, err := v.verify(context.Background(), token); err == nil {
+			t.Errorf("%s: token accepted", name)
+		}
+	}
+
+	// Tokens without a known role authenticate but carry no role
+	principal, err = v.verify(context.Background(), signJWT(t, key, "k1", claims(map[string]any{"roles": "auditor"})))
+	if err != nil || principal.Role != 0 {
+		t.Errorf("principal = %+v, err = %v; want no role", principal, err)
+	}
+}
+
+func TestOIDCRejectsSymmetricAlgorithms(t *testing.T) {
+	srv, key := testIssuer(t)
+	v := newOIDCVerifier(srv.URL, "", "")
+
+	token := signJWT(t, key, "k1", map[string]any{"iss": srv.URL, "exp": time.Now().Add(time.Hour).Unix()})
+	header := base64.RawURLEncoding.EncodeToString([]byte(`{"alg":"HS256","kid":"k1"}`))
+	forged := header + token[len(base64.RawURLEncoding.EncodeToString([]byte(`{"alg":"RS256","typ":"JWT","kid":"k1"}`))):]
+
+	if _, err := v.verify(context.Background(), forged); err == nil {
+		t.Error("HS256 token accepted")
+	}
+}
@@ -0,0 +1,56 @@
+package api
+
+import (
+	"errors"
+	"log"
+	"net/http"
+	"strconv"
+
+	"github.com/gin-gonic/gin"
+	"github.com/google/uuid"
+	"github.com/stoik/vigil/services/discovery-service/internal/discovery"
+)
+
+// handleCampaigns lists campaigns, most recently seen first
+// Query params: min_emails (default 2), limit
+func (s *Server) handleCampaigns(c *gin.Context) {
+	minEmails, err := strconv.Atoi(c.DefaultQuery("min_emails", "2"))
+	if err != nil || minEmails < 0 {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "invalid min_emails"})
+		return
+	}
+	limit := 0
+	if v := c.Query("limit"); v != "" {
+		if limit, err = strconv.Atoi(v); err != nil || limit < 1 {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "invalid limit"})
+			return
+		}
+	}
+
+	campaigns, err := s.service.Campaigns(c.Request.Context(), minEmails, limit)
+	if err != nil {
+		log.Printf("Error listing campaigns: %v", err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "failed to list campaigns"})
+		return
+	}
+	c.JSON(http.StatusOK, gin.H{"campaigns": campaigns})
+}
+
+func (s *Server) handleCampaign(c *gin.Context) {
+	id, err := uuid.Parse(c.Param("id"))
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "invalid campaign id"})
+		return
+	}
+	campaign, err := s.service.Campaign(c.Request.Context(), id)
+	if err != nil {
+		if errors.Is(err, discovery.ErrCampaignNotFound) {
+			c.JSON(http.StatusNotFound, gin.H{"error": "campaign not found"})
+			return
+		}
+		log.Printf("Error getting campaign %s: %v", id, err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "failed to get campaign"})
+		return
+	}
+	c.JSON(http.StatusOK, campaign)
+}
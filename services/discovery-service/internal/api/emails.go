@@ -0,0 +1,167 @@
+package api
+
+import (
+	"errors"
+	"log"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/google/uuid"
+	"github.com/stoik/vigil/services/discovery-service/internal/audit"
+	"github.com/stoik/vigil/services/discovery-service/internal/discovery"
+)
+
+const actionEmailContent = "email.content"
+
+// handleEmailContent fetches a stored email's full content from the provider on demand
+// Every access attempt is audited; content is only returned once the audit entry is written
+func (s *Server) handleEmailContent(c *gin.Context) {
+	emailID, err := uuid.Parse(c.Param("id"))
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "invalid email id"})
+		return
+	}
+
+	ctx := c.Request.Context()
+	email, err := s.service.FetchEmailContent(ctx, emailID)
+	if err != nil {
+		s.auth.audit(ctx, actor(c), actionEmailContent, emailID.String(), audit.OutcomeFailure)
+		if errors.Is(err, discovery.ErrEmailNotFound) {
+			c.JSON(http.StatusNotFound, gin.H{"error": "email not found"})
+			return
+		}
+		log.Printf("Error fetching content for email %s: %v", emailID, err)
+		c.JSON(http.StatusBadGateway, gin.H{"error": "failed to fetch email from provider"})
+		return
+	}
+
+	if err := s.auth.audit(ctx, actor(c), actionEmailContent, emailID.String(), audit.OutcomeSuccess); err != nil {
+		log.Printf("Error writing audit entry for email %s: %v", emailID, err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "audit logging failed"})
+		return
+	}
+
+	c.JSON(http.StatusOK, email)
+}
+
+// handleSearchEmails queries stored email metadata
+// Query params: q (full-text), user, sender_domain, from, to (RFC3339), has_detection, fingerprint,
+// campaign, subject (exact, matched by hash), sort (received_at | -received_at), limit, cursor
+func (s *Server) handleSearchEmails(c *gin.Context) {
+	q := discovery.EmailQuery{
+		User:         c.Query("user"),
+		SenderDomain: c.Query("sender_domain"),
+		Fingerprint:  c.Query("fingerprint"),
+		Subject:      c.Query("subject"),
+		Text:         c.Query("q"),
+		Cursor:       c.Query("cursor"),
+	}
+
+	var err error
+	if v := c.Query("campaign"); v != "" {
+		campaign, err := uuid.Parse(v)
+		if err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "invalid campaign"})
+			return
+		}
+		q.Campaign = &campaign
+	}
+	if v := c.Query("from"); v != "" {
+		if q.From, err = time.Parse(time.RFC3339, v); err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "invalid from (want RFC3339)"})
+			return
+		}
+	}
+	if v := c.Query("to"); v != "" {
+		if q.To, err = time.Parse(time.RFC3339, v); err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "invalid to (want RFC3339)"})
+			return
+		}
+	}
+	if v := c.Query("has_detection"); v != "" {
+		hasDetection, err := strconv.ParseBool(v)
+		if err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "invalid has_detection"})
+			return
+		}
+		q.HasDetection = &hasDetection
+	}
+	if v := c.Query("limit"); v != "" {
+		if q.Limit, err = strconv.Atoi(v); err != nil || q.Limit < 1 {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "invalid limit"})
+			return
+		}
+	}
+	switch c.DefaultQuery("sort", "-received_at") {
+	case "-received_at":
+	case "received_at":
+		q.Ascending = true
+	default:
+		c.JSON(http.StatusBadRequest, gin.H{"error": "invalid sort (want received_at or -received_at)"})
+		return
+	}
+
+	page, err := s.service.SearchEmails(c.Request.Context(), q)
+	if err != nil {
+		if errors.Is(err, discovery.ErrInvalidCursor) {
+			c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+			return
+		}
+		log.Printf("Error searching emails: %v", err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "search failed"})
+		return
+	}
+	c.JSON(http.StatusOK, page)
+}
+
+// handleEmailRecipients lists the mailboxes holding a copy of an email and whether each copy
+// was remediated
+// Query params: outstanding=true lists only copies not remediated yet
+func (s *Server) handleEmailRecipients(c *gin.Context) {
+	outstanding, _ := strconv.ParseBool(c.Query("outstanding"))
+	impact, err := s.service.EmailImpact(c.Request.Context(), c.Param("id"), outstanding)
+	if err != nil {
+		if errors.Is(err, discovery.ErrEmailNotFound) {
+			c.JSON(http.StatusNotFound, gin.H{"error": "email not found"})
+			return
+		}
+		log.Printf("Error listing recipients of email %s: %v", c.Param("id"), err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "failed to list recipients"})
+		return
+	}
+	c.JSON(http.StatusOK, impact)
+}
+
+// handleRemediation records that a user's copy of an email was remediated
+// Body: {"action": "deleted"}; :user is a user ID or mailbox address
+func (s *Server) handleRemediation(c *gin.Context) {
+	emailID, err := uuid.Parse(c.Param("id"))
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "invalid email id"})
+		return
+	}
+	var body struct {
+		Action string `json:"action"`
+	}
+	if err := c.ShouldBindJSON(&body); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "invalid body (want {\"action\": \"...\"})"})
+		return
+	}
+
+	recipient, err := s.service.RecordRemediation(c.Request.Context(), emailID, c.Param("user"), body.Action)
+	if err != nil {
+		switch {
+		case errors.Is(err, discovery.ErrInvalidRemediation):
+			c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		case errors.Is(err, discovery.ErrEmailNotFound):
+			c.JSON(http.StatusNotFound, gin.H{"error": "the user holds no copy of this email"})
+		default:
+			log.Printf("Error recording remediation of email %s: %v", emailID, err)
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "failed to record remediation"})
+		}
+		return
+	}
+	c.JSON(http.StatusOK, recipient)
+}
@@ -0,0 +1,36 @@
+package api
+
+import (
+	"errors"
+	"log"
+	"net/http"
+	"strconv"
+
+	"github.com/gin-gonic/gin"
+	"github.com/stoik/vigil/services/discovery-service/internal/events"
+)
+
+// handleEvents returns events after the given cursor
+// Query params: cursor (from the previous response, empty to start from the beginning), limit
+func (s *Server) handleEvents(c *gin.Context) {
+	limit := 0
+	if v := c.Query("limit"); v != "" {
+		var err error
+		if limit, err = strconv.Atoi(v); err != nil || limit < 1 {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "invalid limit"})
+			return
+		}
+	}
+
+	page, err := events.List(c.Request.Context(), c.Query("cursor"), limit)
+	if err != nil {
+		if errors.Is(err, events.ErrInvalidCursor) {
+			c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+			return
+		}
+		log.Printf("Error listing events: %v", err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "failed to list events"})
+		return
+	}
+	c.JSON(http.StatusOK, page)
+}
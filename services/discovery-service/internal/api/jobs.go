@@ -0,0 +1,103 @@
+package api
+
+import (
+	"errors"
+	"log"
+	"net/http"
+	"strconv"
+
+	"github.com/gin-gonic/gin"
+	"github.com/google/uuid"
+	"github.com/stoik/vigil/services/discovery-service/internal/discovery"
+	"github.com/stoik/vigil/services/discovery-service/internal/jobs"
+)
+
+// handleSubmitJob queues an admin bulk operation and returns its job ID right away
+// Body: {"operation": "pause_users", "users": ["alice@acme.com", ...]} or
+// {"operation": "refingerprint", "from": "2024-03-01T00:00:00Z", "to": "2024-03-02T00:00:00Z"}
+func (s *Server) handleSubmitJob(c *gin.Context) {
+	var r discovery.JobRequest
+	if err := c.ShouldBindJSON(&r); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "invalid body: " + err.Error()})
+		return
+	}
+
+	job, err := s.service.SubmitJob(c.Request.Context(), r, actor(c))
+	if err != nil {
+		switch {
+		case errors.Is(err, discovery.ErrInvalidJob):
+			c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		case errors.Is(err, discovery.ErrTooManyJobs):
+			c.JSON(http.StatusServiceUnavailable, gin.H{"error": "too many jobs queued, retry once some finish"})
+		default:
+			log.Printf("Error submitting %s job: %v", r.Operation, err)
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "failed to submit job"})
+		}
+		return
+	}
+	c.Header("Location", "/jobs/"+job.ID.String())
+	c.JSON(http.StatusAccepted, job)
+}
+
+// handleJobs lists jobs (bulk operations, CLI purges and exports), most recent first
+// Query params: limit
+func (s *Server) handleJobs(c *gin.Context) {
+	limit := 0
+	if v := c.Query("limit"); v != "" {
+		var err error
+		if limit, err = strconv.Atoi(v); err != nil || limit < 1 {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "invalid limit"})
+			return
+		}
+	}
+	list, err := jobs.List(c.Request.Context(), limit)
+	if err != nil {
+		log.Printf("Error listing jobs: %v", err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "failed to list jobs"})
+		return
+	}
+	c.JSON(http.StatusOK, gin.H{"jobs": list})
+}
+
+// handleJob returns a job's status, progress and ETA
+func (s *Server) handleJob(c *gin.Context) {
+	id, err := uuid.Parse(c.Param("id"))
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "invalid job id"})
+		return
+	}
+	job, err := jobs.Get(c.Request.Context(), id)
+	if err != nil {
+		if errors.Is(err, jobs.ErrNotFound) {
+			c.JSON(http.StatusNotFound, gin.H{"error": "job not found"})
+			return
+		}
+		log.Printf("Error getting job %s: %v", id, err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "failed to get job"})
+		return
+	}
+	c.JSON(http.StatusOK, job)
+}
+
+// handleCancelJob cancels a job: a queued one right away, a running one within a few seconds
+func (s *Server) handleCancelJob(c *gin.Context) {
+	id, err := uuid.Parse(c.Param("id"))
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "invalid job id"})
+		return
+	}
+	job, err := jobs.Cancel(c.Request.Context(), id)
+	if err != nil {
+		switch {
+		case errors.Is(err, jobs.ErrNotFound):
+			c.JSON(http.StatusNotFound, gin.H{"error": "job not found"})
+		case errors.Is(err, jobs.ErrFinished):
+			c.JSON(http.StatusConflict, gin.H{"error": "job is " + job.Status})
+		default:
+			log.Printf("Error cancelling job %s: %v", id, err)
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "failed to cancel job"})
+		}
+		return
+	}
+	c.JSON(http.StatusAccepted, job)
+}
@@ -0,0 +1,58 @@
+package api
+
+import (
+	"net/http"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/stoik/vigil/services/discovery-service/internal/logging"
+)
+
+// loggingSettings are the log settings adjustable at runtime
+type loggingSettings struct {
+	Level           string `json:"level"`
+	MetricsInterval string `json:"metrics_interval"` // Go duration, "0s" when the metrics log is off
+}
+
+func (s *Server) loggingSettings() loggingSettings {
+	return loggingSettings{Level: logging.CurrentLevel().String(), MetricsInterval: s.service.MetricsLogInterval().String()}
+}
+
+func (s *Server) handleLogging(c *gin.Context) {
+	c.JSON(http.StatusOK, s.loggingSettings())
+}
+
+// handleUpdateLogging changes the log level and/or the metrics log interval until the next restart
+// Body: {"level": "debug"}, {"metrics_interval": "1m"} or {"metrics_interval": "0s"} to stop it
+func (s *Server) handleUpdateLogging(c *gin.Context) {
+	var r struct {
+		Level           *string `json:"level"`
+		MetricsInterval *string `json:"metrics_interval"`
+	}
+	if err := c.ShouldBindJSON(&r); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "invalid body: " + err.Error()})
+		return
+	}
+
+	// Both are validated before either is applied
+	level := logging.CurrentLevel()
+	if r.Level != nil {
+		var err error
+		if level, err = logging.ParseLevel(*r.Level); err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+			return
+		}
+	}
+	interval := s.service.MetricsLogInterval()
+	if r.MetricsInterval != nil {
+		var err error
+		if interval, err = time.ParseDuration(*r.MetricsInterval); err != nil || interval < 0 {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "invalid metrics_interval, use a duration such as 30s (0s disables)"})
+			return
+		}
+	}
+
+	s.service.SetMetricsLogInterval(interval)
+	logging.SetLevelf(level, "🔧 Logging changed by %s | level=%s | metrics interval=%s", actor(c), level, interval)
+	c.JSON(http.StatusOK, s.loggingSettings())
+}
@@ -0,0 +1,326 @@
+package api
+
+import (
+	"context"
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rsa"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"math/big"
+	"net/http"
+	"strings"
+	"sync"
+	"time"
+)
+
+const (
+	jwksRefreshInterval = time.Hour        // Keys are re-fetched at least this often
+	jwksMinRefresh      = time.Minute      // Unknown key IDs trigger a re-fetch at most this often
+	clockSkew           = 30 * time.Second // Leeway for exp/nbf checks
+)
+
+// oidcVerifier validates JWT bearer tokens issued by an OIDC provider
+// Signing keys are discovered from the issuer's openid-configuration and cached
+type oidcVerifier struct {
+	issuer     string
+	audience   string
+	rolesClaim string
+	client     *http.Client
+	now        func() time.Time
+
+	mu        sync.Mutex
+	keys      map[string]crypto.PublicKey // kid -> key
+	fetchedAt time.Time
+}
+
+func newOIDCVerifier(issuer, audience, rolesClaim string) *oidcVerifier {
+	if rolesClaim == "" {
+		rolesClaim = "roles"
+	}
+	return &oidcVerifier{
+		issuer:     strings.TrimRight(issuer, "/"),
+		audience:   audience,
+		rolesClaim: rolesClaim,
+		client:     &http.Client{Timeout: 10 * time.Second},
+		now:        time.Now,
+	}
+}
+
+type jwtHeader struct {
+	Alg string `json:"alg"`
+	Kid string `json:"kid"`
+}
+
+// verify checks the token signature and standard claims, and maps it to a principal
+// The principal's role is the highest role listed in the roles claim (0 if none)
+func (v *oidcVerifier) verify(ctx context.Context, raw string) (Principal, error) {
+	parts := strings.Split(raw, ".")
+	if len(parts) != 3 {
+		return Principal{}, errors.New("malformed token")
+	}
+
+	var header jwtHeader
+	if err := decodeSegment(parts[0], &header); err != nil {
+		return Principal{}, fmt.Errorf("invalid header: %w", err)
+	}
+	key, err := v.key(ctx, header.Kid)
+	if err != nil {
+		return Principal{}, err
+	}
+	sig, err := base64.RawURLEncoding.DecodeString(parts[2])
+	if err != nil {
+		return Principal{}, fmt.Errorf("invalid signature encoding: %w", err)
+	}
+	if err := verifySignature(header.Alg, key, parts[0]+"."+parts[1], sig); err != nil {
+		return Principal{}, err
+	}
+
+	var claims map[string]any
+	if err := decodeSegment(parts[1], &claims); err != nil {
+		return Principal{}, fmt.Errorf("invalid claims: %w", err)
+	}
+	if err := v.validateClaims(claims); err != nil {
+		return Principal{}, err
+	}
+
+	principal := Principal{Method: "oidc"}
+	for _, name := range []string{"email", "preferred_username", "sub"} {
+		if s, ok := claims[name].(string); ok && s != "" {
+			principal.Name = s
+			break
+		}
+	}
+	for _, r := range stringList(claims[v.rolesClaim]) {
+		if role, err := ParseRole(r); err == nil && role > principal.Role {
+			principal.Role = role
+		}
+	}
+	return principal, nil
+}
+
+func (v *oidcVerifier) validateClaims(claims map[string]any) error {
+	if iss, _ := claims["iss"].(string); strings.TrimRight(iss, "/") != v.issuer {
+		return fmt.Errorf("unexpected issuer %q", iss)
+	}
+	if v.audience != "" {
+		found := false
+		for _, aud := range stringList(claims["aud"]) {
+			if aud == v.audience {
+				found = true
+				break
+			}
+		}
+		if !found {
+			return errors.New("token not issued for this audience")
+		}
+	}
+
+	now := v.now()
+	exp, ok := claims["exp"].(float64)
+	if !ok {
+		return errors.New("missing exp claim")
+	}
+	if now.After(time.Unix(int64(exp), 0).Add(clockSkew)) {
+		return errors.New("token expired")
+	}
+	if nbf, ok := claims["nbf"].(float64); ok && now.Add(clockSkew).Before(time.Unix(int64(nbf), 0)) {
+		return errors.New("token not yet valid")
+	}
+	return nil
+}
+
+// key returns the signing key for kid, re-fetching the key set when it is stale or kid is unknown
+func (v *oidcVerifier) key(ctx context.Context, kid string) (crypto.PublicKey, error) {
+	v.mu.Lock()
+	defer v.mu.Unlock()
+
+	key, ok := v.keys[kid]
+	stale := v.now().Sub(v.fetchedAt) > jwksRefreshInterval
+	if ok && !stale {
+		return key, nil
+	}
+	if !ok && !stale && v.now().Sub(v.fetchedAt) < jwksMinRefresh {
+		return nil, fmt.Errorf("unknown signing key %q", kid)
+	}
+
+	keys, err := v.fetchKeys(ctx)
+	if err != nil {
+		if ok {
+			// Keep using the cached key while the issuer is unreachable
+			return key, nil
+		}
+		return nil, fmt.Errorf("failed to fetch signing keys: %w", err)
+	}
+	v.keys = keys
+	v.fetchedAt = v.now()
+
+	if key, ok = v.keys[kid]; !ok {
+		return nil, fmt.Errorf("unknown signing key %q", kid)
+	}
+	return key, nil
+}
+
+type jwk struct {
+	Kty string `json:"kty"`
+	Kid string `json:"kid"`
+	Use string `json:"use"`
+	N   string `json:"n"`
+	E   string `json:"e"`
+	Crv string `json:"crv"`
+	X   string `json:"x"`
+	Y   string `json:"y"`
+}
+
+func (v *oidcVerifier) fetchKeys(ctx context.Context) (map[string]crypto.PublicKey, error) {
+	var config struct {
+		JWKSURI string `json:"jwks_uri"`
+	}
+	if err := v.getJSON(ctx, v.issuer+"/.well-known/openid-configuration", &config); err != nil {
+		return nil, err
+	}
+	if config.JWKSURI == "" {
+		return nil, errors.New("openid-configuration has no jwks_uri")
+	}
+
+	var set struct {
+		Keys []jwk `json:"keys"`
+	}
+	if err := v.getJSON(ctx, config.JWKSURI, &set); err != nil {
+		return nil, err
+	}
+
+	keys := make(map[string]crypto.PublicKey)
+	for _, k := range set.Keys {
+		if k.Use != "" && k.Use != "sig" {
+			continue
+		}
+		key, err := k.publicKey()
+		if err != nil {
+			continue
+		}
+		keys[k.Kid] = key
+	}
+	return keys, nil
+}
+
+func (v *oidcVerifier) getJSON(ctx context.Context, url string, out any) error {
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, url, nil)
+	if err != nil {
+		return err
+	}
+	resp, err := v.client.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return fmt.Errorf("GET %s returned %d", url, resp.StatusCode)
+	}
+	return json.NewDecoder(resp.Body).Decode(out)
+}
+
+func (k jwk) publicKey() (crypto.PublicKey, error) {
+	switch k.Kty {
+	case "RSA":
+		n, err := base64.RawURLEncoding.DecodeString(k.N)
+		if err != nil {
+			return nil, err
+		}
+		e, err := base64.RawURLEncoding.DecodeString(k.E)
+		if err != nil {
+			return nil, err
+		}
+		return &rsa.PublicKey{N: new(big.Int).SetBytes(n), E: int(new(big.Int).SetBytes(e).Int64())}, nil
+	case "EC":
+		var curve elliptic.Curve
+		switch k.Crv {
+		case "P-256":
+			curve = elliptic.P256()
+		case "P-384":
+			curve = elliptic.P384()
+		default:
+			return nil, fmt.Errorf("unsupported curve %q", k.Crv)
+		}
+		x, err := base64.RawURLEncoding.DecodeString(k.X)
+		if err != nil {
+			return nil, err
+		}
+		y, err := base64.RawURLEncoding.DecodeString(k.Y)
+		if err != nil {
+			return nil, err
+		}
+		return &ecdsa.PublicKey{Curve: curve, X: new(big.Int).SetBytes(x), Y: new(big.Int).SetBytes(y)}, nil
+	default:
+		return nil, fmt.Errorf("unsupported key type %q", k.Kty)
+	}
+}
+
+// verifySignature checks a JWS signature; only asymmetric algorithms are accepted
+func verifySignature(alg string, key crypto.PublicKey, signed string, sig []byte) error {
+	var hash crypto.Hash
+	switch alg {
+	case "RS256", "ES256":
+		hash = crypto.SHA256
+	case "RS384", "ES384":
+		hash = crypto.SHA384
+	case "RS512":
+		hash = crypto.SHA512
+	default:
+		return fmt.Errorf("unsupported algorithm %q", alg)
+	}
+	h := hash.New()
+	h.Write([]byte(signed))
+	digest := h.Sum(nil)
+
+	switch k := key.(type) {
+	case *rsa.PublicKey:
+		if !strings.HasPrefix(alg, "RS") {
+			return fmt.Errorf("algorithm %q does not match RSA key", alg)
+		}
+		if err := rsa.VerifyPKCS1v15(k, hash, digest, sig); err != nil {
+			return errors.New("invalid signature")
+		}
+	case *ecdsa.PublicKey:
+		size := (k.Curve.Params().BitSize + 7) / 8
+		if !strings.HasPrefix(alg, "ES") || len(sig) != 2*size {
+			return fmt.Errorf("algorithm %q does not match EC key", alg)
+		}
+		r, s := new(big.Int).SetBytes(sig[:size]), new(big.Int).SetBytes(sig[size:])
+		if !ecdsa.Verify(k, digest, r, s) {
+			return errors.New("invalid signature")
+		}
+	default:
+		return errors.New("unsupported key")
+	}
+	return nil
+}
+
+func decodeSegment(seg string, out any) error {
+	raw, err := base64.RawURLEncoding.DecodeString(seg)
+	if err != nil {
+		return err
+	}
+	return json.Unmarshal(raw, out)
+}
+
+// stringList reads a claim that may be a string or a list of strings
+func stringList(v any) []string {
+	switch t := v.(type) {
+	case string:
+		return []string{t}
+	case []any:
+		out := make([]string, 0, len(t))
+		for _, item := range t {
+			if s, ok := item.(string); ok {
+				out = append(out, s)
+			}
+		}
+		return out
+	default:
+		return nil
+	}
+}
@@ -0,0 +1,43 @@
+package api
+
+import (
+	"errors"
+	"log"
+	"net/http"
+
+	"github.com/gin-gonic/gin"
+	"github.com/stoik/vigil/services/discovery-service/internal/discovery"
+)
+
+// MaxReportBytes bounds a report request body (submitted content includes the full email)
+const MaxReportBytes = 10 << 20
+
+// handleReport accepts an email reported by a user (report-phish button, mailbox add-in) and
+// returns the stored copy it was linked to
+func (s *Server) handleReport(c *gin.Context) {
+	c.Request.Body = http.MaxBytesReader(c.Writer, c.Request.Body, MaxReportBytes)
+	var report discovery.Report
+	if err := c.ShouldBindJSON(&report); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "invalid report: " + err.Error()})
+		return
+	}
+
+	result, err := s.service.SubmitReport(c.Request.Context(), report)
+	if err != nil {
+		switch {
+		case errors.Is(err, discovery.ErrInvalidReport):
+			c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		case errors.Is(err, discovery.ErrReporterNotFound):
+			c.JSON(http.StatusUnprocessableEntity, gin.H{"error": err.Error()})
+		case errors.Is(err, discovery.ErrEmailNotFound):
+			c.JSON(http.StatusNotFound, gin.H{"error": "email not found in the reporter's mailbox"})
+		default:
+			log.Printf("Error submitting report from %s: %v", actor(c), err)
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "report failed"})
+		}
+		return
+	}
+
+	// Accepted: analysis of the reported email is asynchronous
+	c.JSON(http.StatusAccepted, result)
+}
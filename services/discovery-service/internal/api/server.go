@@ -0,0 +1,177 @@
+package api
+
+import (
+	"context"
+	"errors"
+	"log"
+	"net/http"
+	"strconv"
+
+	"github.com/gin-gonic/gin"
+	"github.com/stoik/vigil/services/discovery-service/internal/discovery"
+)
+
+// Server exposes the discovery service's HTTP API (health and diagnostics)
+type Server struct {
+	service *discovery.Service
+	auth    *authenticator
+	http    *http.Server
+}
+
+// NewServer creates an API server for the given discovery service, listening on addr
+func NewServer(addr string, service *discovery.Service) *Server {
+	gin.SetMode(gin.ReleaseMode)
+	r := gin.New()
+	r.Use(gin.Recovery())
+
+	s := &Server{
+		service: service,
+		auth:    newAuthenticator(),
+		http:    &http.Server{Addr: addr, Handler: r},
+	}
+	s.routes(r)
+
+	return s
+}
+
+// routes registers the API routes with the minimum role each requires
+// Health and aggregate counters stay public for probes and load tests
+func (s *Server) routes(r *gin.Engine) {
+	viewer, operator, admin := s.auth.require(RoleViewer), s.auth.require(RoleOperator), s.auth.require(RoleAdmin)
+	reporter := s.auth.require(RoleReporter)
+
+	r.GET("/health", s.handleHealth)
+	r.GET("/ready", s.handleReady)
+
+	// Built-in alert rules, for deployments without Prometheus (Alertmanager API v2 format)
+	r.GET("/alerts", s.handleAlerts)
+	r.GET("/alerts/rules", s.handleAlertRules)
+
+	debug := r.Group("/debug")
+	{
+		debug.GET("/stats", s.handleStats)
+		// Lists mailboxes and internal state
+		debug.GET("/state", operator, s.auth.audited("debug.state"), s.handleState)
+		// Log level and metrics log interval, changed at runtime until the next restart
+		debug.GET("/logging", operator, s.handleLogging)
+		debug.PUT("/logging", admin, s.auth.audited("logging.update"), s.handleUpdateLogging)
+	}
+
+	emails := r.Group("/emails")
+	{
+		emails.GET("", viewer, s.handleSearchEmails)
+		// Full content access is audited by the handler (fails closed)
+		emails.GET("/:id/content", operator, s.handleEmailContent)
+		// Blast radius: mailboxes holding a copy (:id is an email ID or fingerprint)
+		emails.GET("/:id/recipients", viewer, s.handleEmailRecipients)
+		emails.POST("/:id/recipients/:user/remediation", admin, s.auth.audited("email.remediate"), s.handleRemediation)
+	}
+
+	r.GET("/events", viewer, s.handleEvents)
+
+	// Clusters of similar emails (campaigns.enabled); their emails are listed by /emails?campaign=
+	r.GET("/campaigns", viewer, s.handleCampaigns)
+	r.GET("/campaigns/:id", viewer, s.handleCampaign)
+
+	// User-reported suspicious emails (report-phish buttons, mailbox add-ins)
+	r.POST("/reports", reporter, s.auth.audited("email.report"), s.handleReport)
+
+	// Provider directory vs monitored mailboxes, lists mailbox addresses
+	r.GET("/coverage", viewer, s.handleCoverage)
+
+	// Mailboxes not polled successfully within the per-user SLA, lists mailbox addresses
+	r.GET("/sla", viewer, s.handleMailboxSLA)
+
+	// Maintenance windows, the one in force and the next one
+	r.GET("/maintenance", viewer, s.handleMaintenance)
+
+	// Monitoring policies and how many users each one covers
+	r.GET("/policies", viewer, s.handlePolicies)
+
+	// Admin bulk operations, run asynchronously: pause/resume/poll users, refingerprint, requeue failed emails
+	// Listing, status and cancellation also cover jobs run by CLI commands (purges, exports)
+	jobs := r.Group("/jobs")
+	{
+		jobs.POST("", admin, s.auth.audited("job.submit"), s.handleSubmitJob)
+		jobs.GET("", admin, s.handleJobs)
+		jobs.GET("/:id", admin, s.handleJob)
+		jobs.POST("/:id/cancel", admin, s.auth.audited("job.cancel"), s.handleCancelJob)
+	}
+
+	// Data-subject access export, audited by the handler (fails closed)
+	r.GET("/users/:id/export", admin, s.handleUserExport)
+}
+
+// Start serves the API in the background
+func (s *Server) Start() {
+	go func() {
+		log.Printf("Starting discovery API server on %s", s.http.Addr)
+		if err := s.http.ListenAndServe(); err != nil && !errors.Is(err, http.ErrServerClosed) {
+			log.Printf("Discovery API server error: %v", err)
+		}
+	}()
+}
+
+// Shutdown stops the server, waiting for in-flight requests until ctx expires
+func (s *Server) Shutdown(ctx context.Context) error {
+	return s.http.Shutdown(ctx)
+}
+
+func (s *Server) handleHealth(c *gin.Context) {
+	c.JSON(http.StatusOK, gin.H{"status": "ok"})
+}
+
+// handleReady reports 503 while the database is unavailable and emails are being spilled
+// /health stays 200: the process is alive and recovers by itself
+func (s *Server) handleReady(c *gin.Context) {
+	readiness := s.service.Readiness()
+	status := http.StatusOK
+	if readiness.Status != "ready" {
+		status = http.StatusServiceUnavailable
+	}
+	c.JSON(status, readiness)
+}
+
+// handleAlerts returns the firing alerts and those resolved in the last 15 minutes
+// The body can be POSTed to Alertmanager's /api/v2/alerts as is
+func (s *Server) handleAlerts(c *gin.Context) {
+	c.JSON(http.StatusOK, s.service.Alerts())
+}
+
+func (s *Server) handleAlertRules(c *gin.Context) {
+	c.JSON(http.StatusOK, gin.H{"rules": s.service.AlertRules()})
+}
+
+func (s *Server) handleStats(c *gin.Context) {
+	c.JSON(http.StatusOK, s.service.Stats())
+}
+
+// handleCoverage returns the latest coverage report
+// Query params: refresh=true evaluates it now (queries the provider directory)
+func (s *Server) handleCoverage(c *gin.Context) {
+	refresh, _ := strconv.ParseBool(c.Query("refresh"))
+	report, err := s.service.Coverage(c.Request.Context(), refresh)
+	if err != nil {
+		log.Printf("Error evaluating coverage: %v", err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "coverage evaluation failed"})
+		return
+	}
+	c.JSON(http.StatusOK, report)
+}
+
+func (s *Server) handleMailboxSLA(c *gin.Context) {
+	c.JSON(http.StatusOK, s.service.MailboxSLA())
+}
+
+func (s *Server) handleMaintenance(c *gin.Context) {
+	c.JSON(http.StatusOK, s.service.Maintenance())
+}
+
+func (s *Server) handlePolicies(c *gin.Context) {
+	c.JSON(http.StatusOK, s.service.Policies())
+}
+
+func (s *Server) handleState(c *gin.Context) {
+	c.Header("Content-Type", "text/plain; charset=utf-8")
+	s.service.DumpState(c.Writer)
+}
@@ -0,0 +1,41 @@
+package api
+
+import (
+	"errors"
+	"log"
+	"net/http"
+
+	"github.com/gin-gonic/gin"
+	"github.com/stoik/vigil/services/discovery-service/internal/audit"
+	"github.com/stoik/vigil/services/discovery-service/internal/privacy"
+	"github.com/stoik/vigil/services/discovery-service/internal/telemetry"
+)
+
+const actionUserExport = "user.export"
+
+// handleUserExport returns everything held about a mailbox user (data-subject access request)
+// :id is a user ID or email address. The export is only returned once the audit entry is written
+func (s *Server) handleUserExport(c *gin.Context) {
+	user := c.Param("id")
+	ctx := c.Request.Context()
+
+	export, err := privacy.ExportUser(ctx, user)
+	if err != nil {
+		s.auth.audit(ctx, actor(c), actionUserExport, user, audit.OutcomeFailure)
+		if errors.Is(err, privacy.ErrUserNotFound) {
+			c.JSON(http.StatusNotFound, gin.H{"error": "user not found"})
+			return
+		}
+		log.Printf("Error exporting user %s: %v", telemetry.Redact(user), err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "export failed"})
+		return
+	}
+
+	if err := s.auth.audit(ctx, actor(c), actionUserExport, export.User.ID.String(), audit.OutcomeSuccess); err != nil {
+		log.Printf("Error writing audit entry for user %s: %v", export.User.ID, err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "audit logging failed"})
+		return
+	}
+
+	c.JSON(http.StatusOK, export)
+}
@@ -0,0 +1,188 @@
+package app
+
+import (
+	"fmt"
+	"net/url"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"text/tabwriter"
+
+	"github.com/spf13/cobra"
+	"github.com/spf13/viper"
+)
+
+// configDirs are searched in order for config files
+var configDirs = []string{".", "./services/discovery-service"}
+
+// configLayer is a config file merged over the flag defaults. Layers apply in order:
+// defaults (config.yaml), environment profile (config.<profile>.yaml), tenant overrides
+// (tenants/<tenant_id>.yaml). Env vars override every file and set flags override env vars.
+type configLayer struct {
+	name string
+	path string // Empty when the file does not exist
+	v    *viper.Viper
+}
+
+var (
+	configLayers []configLayer
+	configErr    error // Reported before the command runs (initConfig cannot fail)
+)
+
+func initConfig() {
+	// Env vars use underscores for nested keys (e.g. POLLING_LOOKBACK for polling.lookback)
+	viper.SetEnvKeyReplacer(strings.NewReplacer(".", "_"))
+	viper.AutomaticEnv()
+
+	configLayers, configErr = loadConfigLayers()
+	for _, layer := range configLayers {
+		if layer.path != "" {
+			fmt.Fprintf(os.Stderr, "Using %s config: %s\n", layer.name, layer.path)
+		}
+	}
+}
+
+// loadConfigLayers reads the config files and merges them into viper, lowest layer first.
+// The tenant layer is picked after the profile is merged, so a profile can set tenant_id.
+func loadConfigLayers() ([]configLayer, error) {
+	var layers []configLayer
+	merge := func(name, file string, required bool) error {
+		layer := configLayer{name: name, path: findConfigFile(file)}
+		if layer.path == "" {
+			if required {
+				return fmt.Errorf("%s config %s not found in %s", name, file, strings.Join(configDirs, ", "))
+			}
+			layers = append(layers, layer)
+			return nil
+		}
+		layer.v = viper.New()
+		layer.v.SetConfigFile(layer.path)
+		if err := layer.v.ReadInConfig(); err != nil {
+			return fmt.Errorf("failed to read %s config: %w", name, err)
+		}
+		if err := viper.MergeConfigMap(layer.v.AllSettings()); err != nil {
+			return fmt.Errorf("failed to merge %s config: %w", name, err)
+		}
+		layers = append(layers, layer)
+		return nil
+	}
+
+	if err := merge("defaults", "config.yaml", false); err != nil {
+		return layers, err
+	}
+	// An explicitly requested profile or tenant file must exist: silently running
+	// with the defaults is how production ends up pointed at staging
+	if profile := viper.GetString("profile"); profile != "" {
+		if err := merge("profile", fmt.Sprintf("config.%s.yaml", profile), true); err != nil {
+			return layers, err
+		}
+	}
+	if tenantID := viper.GetString("tenant_id"); tenantID != "" {
+		if err := merge("tenant", filepath.Join("tenants", tenantID+".yaml"), false); err != nil {
+			return layers, err
+		}
+	}
+	return layers, nil
+}
+
+// findConfigFile returns the first config dir's copy of file, or "" if there is none
+func findConfigFile(file string) string {
+	for _, dir := range configDirs {
+		path := filepath.Join(dir, file)
+		if _, err := os.Stat(path); err == nil {
+			return path
+		}
+	}
+	return ""
+}
+
+// configSource names the layer a key's effective value comes from
+func configSource(cmd *cobra.Command, key string) string {
+	if f := cmd.Flags().Lookup(key); f != nil && f.Changed {
+		return "flag"
+	}
+	env := strings.ToUpper(strings.ReplaceAll(key, ".", "_"))
+	if _, ok := os.LookupEnv(env); ok {
+		return "env " + env
+	}
+	for i := len(configLayers) - 1; i >= 0; i-- {
+		if layer := configLayers[i]; layer.v != nil && layer.v.IsSet(key) {
+			return layer.name + " " + layer.path
+		}
+	}
+	return "default"
+}
+
+// secretKeySuffixes mark keys whose values are never printed
+var secretKeySuffixes = []string{"token", "tokens", "key", "secret", "password"}
+
+const masked = "****"
+
+// maskConfigValue hides secrets: whole values of secret keys, passwords in URLs (as xxxxx)
+func maskConfigValue(key string, value any) any {
+	last := key[strings.LastIndex(key, ".")+1:]
+	for _, suffix := range secretKeySuffixes {
+		if last == suffix || strings.HasSuffix(last, "_"+suffix) {
+			if s := fmt.Sprint(value); s == "" || s == "[]" {
+				return value // Unset is worth seeing
+			}
+			return masked
+		}
+	}
+	if s, ok := value.(string); ok && strings.Contains(s, "://") {
+		if u, err := url.Parse(s); err == nil {
+			return u.Redacted()
+		}
+	}
+	return value
+}
+
+var configCmd = &cobra.Command{
+	Use:   "config",
+	Short: "Inspect the configuration",
+}
+
+var configShowCmd = &cobra.Command{
+	Use:   "show",
+	Short: "Show the config layers, or with --resolved the effective configuration",
+	Long: "Config is layered: flag defaults, then config.yaml, then config.<profile>.yaml (--profile), " +
+		"then tenants/<tenant_id>.yaml, then env vars, then flags set on the command line. " +
+		"--resolved prints every effective value with the layer it comes from. Secrets are masked.",
+	RunE: func(cmd *cobra.Command, args []string) error {
+		resolved, _ := cmd.Flags().GetBool("resolved")
+
+		tw := tabwriter.NewWriter(os.Stdout, 0, 0, 2, ' ', 0)
+		defer tw.Flush()
+
+		if !resolved {
+			fmt.Fprintln(tw, "LAYER\tFILE")
+			fmt.Fprintln(tw, "flag defaults\t-")
+			for _, layer := range configLayers {
+				path := layer.path
+				if path == "" {
+					path = "(not found)"
+				}
+				fmt.Fprintf(tw, "%s\t%s\n", layer.name, path)
+			}
+			fmt.Fprintln(tw, "env vars\t-")
+			fmt.Fprintln(tw, "flags\t-")
+			return nil
+		}
+
+		keys := viper.AllKeys()
+		sort.Strings(keys)
+		fmt.Fprintln(tw, "KEY\tVALUE\tSOURCE")
+		for _, key := range keys {
+			fmt.Fprintf(tw, "%s\t%v\t%s\n", key, maskConfigValue(key, viper.Get(key)), configSource(cmd, key))
+		}
+		return nil
+	},
+}
+
+func init() {
+	configShowCmd.Flags().Bool("resolved", false, "Print the effective value of every setting")
+
+	configCmd.AddCommand(configShowCmd)
+	rootCmd.AddCommand(configCmd)
+}
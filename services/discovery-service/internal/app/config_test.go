@@ -0,0 +1,82 @@
+package app
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/spf13/viper"
+)
+
+func TestLoadConfigLayers(t *testing.T) {
+	dir := t.TempDir()
+	write := func(name, content string) {
+		t.Helper()
+		path := filepath.Join(dir, name)
+		if err := os.MkdirAll(filepath.Dir(path), 0o755); err != nil {
+			t.Fatal(err)
+		}
+		if err := os.WriteFile(path, []byte(content), 0o644); err != nil {
+			t.Fatal(err)
+		}
+	}
+	write("config.yaml", "tenant_id: t1\nquota:\n  weight: 1\n  max_in_flight: 10\ncache:\n  user_ttl: 1m\n")
+	write("config.staging.yaml", "quota:\n  weight: 2\n")
+	write("tenants/t1.yaml", "quota:\n  max_in_flight: 20\n")
+
+	oldDirs := configDirs
+	configDirs = []string{dir}
+	t.Cleanup(func() {
+		configDirs = oldDirs
+		viper.Reset()
+	})
+
+	viper.Reset()
+	viper.Set("profile", "staging")
+	layers, err := loadConfigLayers()
+	if err != nil {
+		t.Fatalf("loadConfigLayers: %v", err)
+	}
+	if len(layers) != 3 {
+		t.Fatalf("loaded %d layers, want 3", len(layers))
+	}
+	// Each layer overrides only the keys it sets
+	if got := viper.GetInt("quota.weight"); got != 2 {
+		t.Errorf("quota.weight = %d, want 2 (profile)", got)
+	}
+	if got := viper.GetInt("quota.max_in_flight"); got != 20 {
+		t.Errorf("quota.max_in_flight = %d, want 20 (tenant)", got)
+	}
+	if got := viper.GetString("cache.user_ttl"); got != "1m" {
+		t.Errorf("cache.user_ttl = %q, want 1m (defaults)", got)
+	}
+
+	// A requested profile must exist
+	viper.Reset()
+	viper.Set("profile", "production")
+	if _, err := loadConfigLayers(); err == nil {
+		t.Error("loadConfigLayers() with a missing profile succeeded")
+	}
+}
+
+func TestMaskConfigValue(t *testing.T) {
+	tests := []struct {
+		key   string
+		value any
+		want  string
+	}{
+		{"siem.token", "abc", masked},
+		{"telemetry.hmac_key", "abc", masked},
+		{"api.tokens", []string{"alice:abc"}, masked},
+		{"siem.token", "", ""},
+		{"database.url", "postgres://vigil:secret@db:5432/vigil", "postgres://vigil:xxxxx@db:5432/vigil"},
+		{"provider.api_url", "http://localhost:8080", "http://localhost:8080"},
+		{"quota.weight", 3, "3"},
+	}
+	for _, tt := range tests {
+		if got := fmt.Sprint(maskConfigValue(tt.key, tt.value)); got != tt.want {
+			t.Errorf("maskConfigValue(%q, %v) = %s, want %s", tt.key, tt.value, got, tt.want)
+		}
+	}
+}
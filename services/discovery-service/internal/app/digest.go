@@ -0,0 +1,98 @@
+package app
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/spf13/cobra"
+	"github.com/spf13/viper"
+	"github.com/stoik/vigil/services/discovery-service/internal/db"
+	"github.com/stoik/vigil/services/discovery-service/internal/digest"
+	"github.com/stoik/vigil/services/discovery-service/internal/schedule"
+)
+
+var digestCmd = &cobra.Command{
+	Use:   "digest",
+	Short: "Generate a detection summary digest",
+	Long: "Builds the tenant digest of the last complete day or week in the tenant's time zone (--timezone): top risky sender domains, " +
+		"detection counts, monitored-user coverage and ingest health. Prints it as HTML or JSON, or with " +
+		"--send delivers it through the configured webhook and SMTP deliverers. Scheduled delivery is " +
+		"enabled on 'run' with digest.schedule.",
+	RunE: func(cmd *cobra.Command, args []string) error {
+		ctx := context.Background()
+
+		periodFlag, _ := cmd.Flags().GetString("period")
+		format, _ := cmd.Flags().GetString("format")
+		output, _ := cmd.Flags().GetString("output")
+		send, _ := cmd.Flags().GetBool("send")
+
+		period, err := digest.ParsePeriod(periodFlag)
+		if err != nil {
+			return err
+		}
+		loc, err := schedule.Location()
+		if err != nil {
+			return err
+		}
+		if format != digest.FormatHTML && format != digest.FormatJSON {
+			return fmt.Errorf("invalid --format %q (want %q or %q)", format, digest.FormatHTML, digest.FormatJSON)
+		}
+		tenantID, err := uuid.Parse(viper.GetString("tenant_id"))
+		if err != nil {
+			return fmt.Errorf("invalid tenant_id: %w", err)
+		}
+
+		var deliverers []digest.Deliverer
+		if send {
+			if deliverers, err = digest.NewDeliverers(); err != nil {
+				return err
+			}
+			if len(deliverers) == 0 {
+				return fmt.Errorf("--send needs digest.webhook_url or digest.smtp.addr")
+			}
+		}
+
+		// Initialize database
+		if err := openDB(ctx); err != nil {
+			return err
+		}
+		defer db.Close()
+
+		from, to := period.Window(time.Now(), loc)
+		d, err := digest.Build(ctx, tenantID, period, from, to, digest.NewOptions())
+		if err != nil {
+			return err
+		}
+
+		if send {
+			if err := digest.Deliver(ctx, d, deliverers); err != nil {
+				return err
+			}
+			fmt.Fprintf(os.Stderr, "✓ %s digest for %s delivered\n", period, from.Format(time.DateOnly))
+			return nil
+		}
+
+		out := os.Stdout
+		if output != "" {
+			f, err := os.OpenFile(output, os.O_WRONLY|os.O_CREATE|os.O_TRUNC, 0600)
+			if err != nil {
+				return fmt.Errorf("failed to create digest file: %w", err)
+			}
+			defer f.Close()
+			out = f
+		}
+		return digest.Render(out, d, format)
+	},
+}
+
+func init() {
+	digestCmd.Flags().String("period", string(digest.PeriodDaily), "Digest period: 'daily' or 'weekly'")
+	digestCmd.Flags().String("format", digest.FormatHTML, "Output format: 'html' or 'json'")
+	digestCmd.Flags().String("output", "", "Write the digest to this file instead of stdout")
+	digestCmd.Flags().Bool("send", false, "Deliver the digest through the configured deliverers instead of printing it")
+
+	rootCmd.AddCommand(digestCmd)
+}
@@ -0,0 +1,110 @@
+package app
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"strings"
+	"text/tabwriter"
+
+	"github.com/spf13/cobra"
+	"github.com/stoik/vigil/services/discovery-service/internal/audit"
+	"github.com/stoik/vigil/services/discovery-service/internal/db"
+	"github.com/stoik/vigil/services/discovery-service/internal/fsck"
+)
+
+var fsckCmd = &cobra.Command{
+	Use:   "fsck",
+	Short: "Check the database for orphaned rows and future cursors, optionally repairing them",
+	Long: "Scans for user_emails links and reports whose user or email is missing, emails no user holds, and " +
+		"users whose polling cursor is in the future (polls would skip emails until the clock catches up). " +
+		"Read-only unless --repair is set; repairs run in batches, are safe to run alongside the service " +
+		"and are recorded in the audit log. Exits non-zero when problems remain.",
+	RunE: func(cmd *cobra.Command, args []string) error {
+		ctx := context.Background()
+		repair, _ := cmd.Flags().GetBool("repair")
+		batchSize, _ := cmd.Flags().GetInt("batch-size")
+		grace, _ := cmd.Flags().GetDuration("grace")
+		skew, _ := cmd.Flags().GetDuration("skew")
+		actor, _ := cmd.Flags().GetString("actor")
+		reportPath, _ := cmd.Flags().GetString("report")
+
+		if repair {
+			if actor == "" {
+				actor = os.Getenv("USER")
+			}
+			if actor == "" {
+				return fmt.Errorf("--actor is required with --repair (recorded in the audit log)")
+			}
+		}
+
+		// Initialize database
+		if err := openDB(ctx); err != nil {
+			return err
+		}
+		defer db.Close()
+
+		report, err := fsck.Run(ctx, fsck.Options{Repair: repair, BatchSize: batchSize, Grace: grace, Skew: skew})
+		if repair {
+			outcome := audit.OutcomeSuccess
+			if err != nil {
+				outcome = audit.OutcomeFailure
+			}
+			if auditErr := audit.Record(ctx, actor, "fsck.repair", fsckRepaired(report), outcome); auditErr != nil && err == nil {
+				err = fmt.Errorf("failed to record audit entry: %w", auditErr)
+			}
+		}
+
+		tw := tabwriter.NewWriter(os.Stdout, 0, 0, 2, ' ', 0)
+		fmt.Fprintln(tw, "CHECK\tFOUND\tREPAIRED\tDESCRIPTION")
+		for _, f := range report.Findings {
+			fmt.Fprintf(tw, "%s\t%d\t%d\t%s\n", f.Check, f.Found, f.Repaired, f.Description)
+			if len(f.Examples) > 0 {
+				fmt.Fprintf(tw, "\t\t\te.g. %s\n", strings.Join(f.Examples, ", "))
+			}
+		}
+		tw.Flush()
+
+		if reportPath != "" {
+			if writeErr := writeJSONReport(reportPath, report); writeErr != nil && err == nil {
+				err = writeErr
+			}
+		}
+		if err != nil {
+			return err
+		}
+		if problems := report.Problems(); problems > 0 {
+			if !repair {
+				return fmt.Errorf("%d problems found (run with --repair to fix them)", problems)
+			}
+			return fmt.Errorf("%d problems left unrepaired", problems)
+		}
+		fmt.Fprintln(os.Stderr, "✓ No inconsistencies left")
+		return nil
+	},
+}
+
+// fsckRepaired summarizes the repairs as the audit entry's target, e.g. "unlinked_emails=12"
+func fsckRepaired(report fsck.Report) string {
+	var repaired []string
+	for _, f := range report.Findings {
+		if f.Repaired > 0 {
+			repaired = append(repaired, fmt.Sprintf("%s=%d", f.Check, f.Repaired))
+		}
+	}
+	if len(repaired) == 0 {
+		return "none"
+	}
+	return strings.Join(repaired, ",")
+}
+
+func init() {
+	fsckCmd.Flags().Bool("repair", false, "Repair what is found (default: report only)")
+	fsckCmd.Flags().Int("batch-size", fsck.DefaultBatchSize, "Rows repaired per statement")
+	fsckCmd.Flags().Duration("grace", fsck.DefaultGrace, "Ignore emails received this recently (a running instance may still be linking them)")
+	fsckCmd.Flags().Duration("skew", fsck.DefaultSkew, "Tolerate cursors this far in the future (provider clock skew)")
+	fsckCmd.Flags().String("actor", "", "Operator recorded in the audit log with --repair (default $USER)")
+	fsckCmd.Flags().String("report", "", "Also write the findings as JSON to this file")
+
+	rootCmd.AddCommand(fsckCmd)
+}
@@ -0,0 +1,152 @@
+package app
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"os"
+	"text/tabwriter"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/spf13/cobra"
+	"github.com/stoik/vigil/services/discovery-service/internal/audit"
+	"github.com/stoik/vigil/services/discovery-service/internal/db"
+	"github.com/stoik/vigil/services/discovery-service/internal/jobs"
+)
+
+// Jobs run by CLI commands (bulk operations are listed in discovery)
+const (
+	jobTenantPurge = "tenant_purge"
+	jobUserExport  = "user_export"
+)
+
+var jobsCmd = &cobra.Command{
+	Use:   "jobs",
+	Short: "Inspect and cancel long-running jobs",
+	Long: "Jobs are bulk operations submitted to POST /jobs and purges and exports run by this CLI. " +
+		"Their progress is saved every few seconds, so they can be followed and cancelled from anywhere.",
+}
+
+var jobsListCmd = &cobra.Command{
+	Use:   "list",
+	Short: "List jobs, most recent first",
+	RunE: func(cmd *cobra.Command, args []string) error {
+		ctx := context.Background()
+		limit, _ := cmd.Flags().GetInt("limit")
+
+		// Initialize database
+		if err := openDB(ctx); err != nil {
+			return err
+		}
+		defer db.Close()
+
+		list, err := jobs.List(ctx, limit)
+		if err != nil {
+			return err
+		}
+
+		tw := tabwriter.NewWriter(os.Stdout, 0, 0, 2, ' ', 0)
+		defer tw.Flush()
+		fmt.Fprintln(tw, "ID\tOPERATION\tSTATUS\tPROGRESS\tFAILED\tETA\tCREATED\tBY")
+		for _, job := range list {
+			fmt.Fprintf(tw, "%s\t%s\t%s\t%s\t%d\t%s\t%s\t%s\n", job.ID, job.Operation, job.Status, jobProgress(job),
+				job.Failed, jobETA(job), job.CreatedAt.Local().Format(time.DateTime), job.CreatedBy)
+		}
+		return nil
+	},
+}
+
+var jobsStatusCmd = &cobra.Command{
+	Use:   "status <id>",
+	Short: "Print a job's status, progress, ETA and item errors as JSON",
+	Args:  cobra.ExactArgs(1),
+	RunE: func(cmd *cobra.Command, args []string) error {
+		ctx := context.Background()
+		id, err := uuid.Parse(args[0])
+		if err != nil {
+			return fmt.Errorf("invalid job id: %w", err)
+		}
+
+		// Initialize database
+		if err := openDB(ctx); err != nil {
+			return err
+		}
+		defer db.Close()
+
+		job, err := jobs.Get(ctx, id)
+		if err != nil {
+			return err
+		}
+		return writeJSONReport("", job)
+	},
+}
+
+var jobsCancelCmd = &cobra.Command{
+	Use:   "cancel <id>",
+	Short: "Cancel a queued or running job",
+	Long: "A queued job is cancelled right away. A running job stops at its next progress save, within " +
+		"a few seconds; items already processed are not rolled back. The cancellation is recorded in the audit log.",
+	Args: cobra.ExactArgs(1),
+	RunE: func(cmd *cobra.Command, args []string) error {
+		ctx := context.Background()
+		actor, _ := cmd.Flags().GetString("actor")
+
+		id, err := uuid.Parse(args[0])
+		if err != nil {
+			return fmt.Errorf("invalid job id: %w", err)
+		}
+		if actor == "" {
+			actor = os.Getenv("USER")
+		}
+		if actor == "" {
+			return fmt.Errorf("--actor is required (recorded in the audit log)")
+		}
+
+		// Initialize database
+		if err := openDB(ctx); err != nil {
+			return err
+		}
+		defer db.Close()
+
+		job, err := jobs.Cancel(ctx, id)
+		if err != nil {
+			if errors.Is(err, jobs.ErrFinished) {
+				return fmt.Errorf("job %s is %s", id, job.Status)
+			}
+			return err
+		}
+		if err := audit.Record(ctx, actor, "job.cancel", id.String(), audit.OutcomeSuccess); err != nil {
+			return fmt.Errorf("failed to record audit entry: %w", err)
+		}
+		if job.Status == jobs.StatusCancelled {
+			fmt.Fprintf(os.Stderr, "✓ Job %s cancelled\n", id)
+		} else {
+			fmt.Fprintf(os.Stderr, "✓ Cancellation of job %s requested, it stops within %v\n", id, jobs.Heartbeat)
+		}
+		return nil
+	},
+}
+
+// jobProgress formats a job's processed items, and the total once known
+func jobProgress(job jobs.Job) string {
+	if job.Total == nil {
+		return fmt.Sprintf("%d", job.Processed)
+	}
+	return fmt.Sprintf("%d/%d", job.Processed, *job.Total)
+}
+
+func jobETA(job jobs.Job) string {
+	if job.ETA == nil {
+		return "-"
+	}
+	return job.ETA.Local().Format(time.TimeOnly)
+}
+
+func init() {
+	jobsListCmd.Flags().Int("limit", jobs.DefaultLimit, "Jobs to list")
+	jobsCancelCmd.Flags().String("actor", "", "Operator recorded in the audit log (default $USER)")
+
+	jobsCmd.AddCommand(jobsListCmd, jobsStatusCmd, jobsCancelCmd)
+	rootCmd.AddCommand(jobsCmd)
+}
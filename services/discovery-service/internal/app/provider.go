@@ -0,0 +1,65 @@
+package app
+
+import (
+	"fmt"
+	"os"
+
+	"github.com/spf13/cobra"
+	"github.com/stoik/vigil/services/discovery-service/internal/provider"
+)
+
+var providerCmd = &cobra.Command{
+	Use:   "provider",
+	Short: "Provider capture helpers",
+}
+
+var providerSanitizeCmd = &cobra.Command{
+	Use:   "sanitize <capture.jsonl>...",
+	Short: "Turn provider HTTP captures into test fixtures",
+	Long: "Reads captures written with provider.capture_dir and writes one fixture file per exchange to --out. " +
+		"Secrets are dropped, email addresses are replaced by stable pseudonyms (consistent across all the captures " +
+		"given) and subjects, snippets, bodies and names are redacted, unless --keep-domains or --keep-content.",
+	Args: cobra.MinimumNArgs(1),
+	RunE: func(cmd *cobra.Command, args []string) error {
+		out, _ := cmd.Flags().GetString("out")
+		keepDomains, _ := cmd.Flags().GetBool("keep-domains")
+		keepContent, _ := cmd.Flags().GetBool("keep-content")
+		if out == "" {
+			return fmt.Errorf("--out is required")
+		}
+
+		sanitizer := provider.NewSanitizer(provider.SanitizeOptions{KeepDomains: keepDomains, KeepContent: keepContent})
+		var fixtures []provider.Fixture
+		for _, path := range args {
+			f, err := os.Open(path)
+			if err != nil {
+				return err
+			}
+			exchanges, err := provider.ReadCapture(f)
+			f.Close()
+			if err != nil {
+				return fmt.Errorf("%s: %w", path, err)
+			}
+			for _, ex := range exchanges {
+				fixture, err := sanitizer.Fixture(ex)
+				if err != nil {
+					return fmt.Errorf("%s: %w", path, err)
+				}
+				fixtures = append(fixtures, fixture)
+			}
+		}
+		if err := provider.WriteFixtures(out, fixtures); err != nil {
+			return err
+		}
+		fmt.Printf("Wrote %d fixtures to %s\n", len(fixtures), out)
+		return nil
+	},
+}
+
+func init() {
+	providerSanitizeCmd.Flags().String("out", "", "Directory the fixtures are written to")
+	providerSanitizeCmd.Flags().Bool("keep-domains", false, "Keep the domains of email addresses (local parts are still replaced)")
+	providerSanitizeCmd.Flags().Bool("keep-content", false, "Keep subjects, snippets, bodies and names")
+	providerCmd.AddCommand(providerSanitizeCmd)
+	rootCmd.AddCommand(providerCmd)
+}
@@ -0,0 +1,37 @@
+package app
+
+import (
+	"fmt"
+
+	"github.com/spf13/cobra"
+	"github.com/spf13/viper"
+	"github.com/stoik/vigil/services/discovery-service/internal/telemetry"
+)
+
+var telemetryCmd = &cobra.Command{
+	Use:   "telemetry",
+	Short: "Anonymized telemetry helpers",
+}
+
+var telemetryHashCmd = &cobra.Command{
+	Use:   "hash <value>...",
+	Short: "Print the log token of an email address or subject",
+	Long: "Computes the token that replaces a value in logs when telemetry.anonymize is on, " +
+		"so an operator who is given an address can search the logs for it. Requires telemetry.hmac_key.",
+	Args: cobra.MinimumNArgs(1),
+	RunE: func(cmd *cobra.Command, args []string) error {
+		key := viper.GetString("telemetry.hmac_key")
+		if key == "" {
+			return fmt.Errorf("telemetry.hmac_key is not set")
+		}
+		for _, value := range args {
+			fmt.Printf("%s\t%s\n", telemetry.Hash([]byte(key), value), value)
+		}
+		return nil
+	},
+}
+
+func init() {
+	telemetryCmd.AddCommand(telemetryHashCmd)
+	rootCmd.AddCommand(telemetryCmd)
+}
@@ -0,0 +1,146 @@
+package app
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/spf13/cobra"
+	"github.com/spf13/viper"
+	"github.com/stoik/vigil/services/discovery-service/internal/audit"
+	"github.com/stoik/vigil/services/discovery-service/internal/db"
+	"github.com/stoik/vigil/services/discovery-service/internal/jobs"
+	"github.com/stoik/vigil/services/discovery-service/internal/privacy"
+)
+
+var tenantCmd = &cobra.Command{
+	Use:   "tenant",
+	Short: "Manage the tenant",
+}
+
+var tenantRemoveCmd = &cobra.Command{
+	Use:   "remove",
+	Short: "Offboard the tenant, optionally erasing its data",
+	Long: "Stops monitoring the tenant (running instances shut down on their next user discovery cycle). " +
+		"With --purge, then deletes its users, emails, links, detections, events and audit entries in batches " +
+		"and prints a JSON deletion report. A purge can be re-run to finish an interrupted one.",
+	RunE: func(cmd *cobra.Command, args []string) error {
+		ctx := context.Background()
+
+		purge, _ := cmd.Flags().GetBool("purge")
+		grace, _ := cmd.Flags().GetDuration("grace")
+		batchSize, _ := cmd.Flags().GetInt("batch-size")
+		auditFlag, _ := cmd.Flags().GetString("audit")
+		actor, _ := cmd.Flags().GetString("actor")
+		reportPath, _ := cmd.Flags().GetString("report")
+
+		tenantID, err := uuid.Parse(viper.GetString("tenant_id"))
+		if err != nil {
+			return fmt.Errorf("invalid tenant_id: %w", err)
+		}
+		auditMode, err := privacy.ParseAuditMode(auditFlag)
+		if err != nil {
+			return err
+		}
+		if actor == "" {
+			actor = os.Getenv("USER")
+		}
+		if actor == "" {
+			return fmt.Errorf("--actor is required (recorded in the audit log)")
+		}
+
+		// Initialize database
+		if err := openDB(ctx); err != nil {
+			return err
+		}
+		defer db.Close()
+
+		if err := privacy.MarkOffboarded(ctx, tenantID); err != nil {
+			if errors.Is(err, privacy.ErrTenantNotFound) {
+				return fmt.Errorf("tenant %s not found", tenantID)
+			}
+			return err
+		}
+		if err := audit.Record(ctx, actor, "tenant.offboard", tenantID.String(), audit.OutcomeSuccess); err != nil {
+			return fmt.Errorf("failed to record audit entry: %w", err)
+		}
+		fmt.Fprintf(os.Stderr, "✓ Tenant %s offboarded, running instances stop within a minute\n", tenantID)
+
+		if !purge {
+			return nil
+		}
+
+		// Let running instances stop before their rows are deleted
+		if grace > 0 {
+			fmt.Fprintf(os.Stderr, "Waiting %v for running instances to stop...\n", grace)
+			time.Sleep(grace)
+		}
+
+		request := map[string]any{"tenant_id": tenantID, "batch_size": batchSize, "audit": auditMode}
+		run, err := jobs.Create(ctx, jobTenantPurge, request, actor)
+		if err != nil {
+			return err
+		}
+		fmt.Fprintf(os.Stderr, "Purging tenant data (batches of %d, audit entries: %s) as job %s...\n", batchSize, auditMode, run.ID)
+		var report privacy.PurgeReport
+		err = run.Execute(ctx, func(ctx context.Context, run *jobs.Run) error {
+			var err error
+			report, err = privacy.Purge(ctx, tenantID, privacy.PurgeOptions{BatchSize: batchSize, Audit: auditMode, Job: run})
+			return err
+		})
+		outcome := audit.OutcomeSuccess
+		if err != nil {
+			outcome = audit.OutcomeFailure
+		}
+		// Recorded after the purge, so the erasure itself stays on record
+		if auditErr := audit.Record(ctx, actor, "tenant.purge", tenantID.String(), outcome); auditErr != nil && err == nil {
+			err = fmt.Errorf("failed to record audit entry: %w", auditErr)
+		}
+
+		if writeErr := writeJSONReport(reportPath, report); writeErr != nil && err == nil {
+			err = writeErr
+		}
+		if err != nil {
+			return fmt.Errorf("purge incomplete (re-run to finish): %w", err)
+		}
+		fmt.Fprintf(os.Stderr, "✓ Tenant %s purged\n", tenantID)
+		return nil
+	},
+}
+
+// writeJSONReport writes v as indented JSON to path, or stdout when path is empty
+// Files are created owner-only: reports and exports contain personal data
+func writeJSONReport(path string, v any) error {
+	out := os.Stdout
+	if path != "" {
+		f, err := os.OpenFile(path, os.O_CREATE|os.O_WRONLY|os.O_TRUNC, 0o600)
+		if err != nil {
+			return fmt.Errorf("failed to create report: %w", err)
+		}
+		defer f.Close()
+		out = f
+	}
+
+	enc := json.NewEncoder(out)
+	enc.SetIndent("", "  ")
+	if err := enc.Encode(v); err != nil {
+		return fmt.Errorf("failed to write report: %w", err)
+	}
+	return nil
+}
+
+func init() {
+	tenantRemoveCmd.Flags().Bool("purge", false, "Erase the tenant's data after offboarding it")
+	tenantRemoveCmd.Flags().Duration("grace", 2*time.Minute, "Wait before purging so running instances notice the offboarding and stop")
+	tenantRemoveCmd.Flags().Int("batch-size", privacy.DefaultBatchSize, "Rows deleted per statement")
+	tenantRemoveCmd.Flags().String("audit", string(privacy.AuditDelete), "Audit entries: 'delete' or 'anonymize' (keep actions, redact actors and targets)")
+	tenantRemoveCmd.Flags().String("actor", "", "Operator recorded in the audit log (default $USER)")
+	tenantRemoveCmd.Flags().String("report", "", "Write the deletion report to this file instead of stdout")
+
+	tenantCmd.AddCommand(tenantRemoveCmd)
+	rootCmd.AddCommand(tenantCmd)
+}
@@ -0,0 +1,47 @@
+package app
+
+import (
+	"context"
+	"os"
+
+	"github.com/spf13/viper"
+	"github.com/stoik/vigil/services/discovery-service/internal/discovery"
+	"github.com/stoik/vigil/services/discovery-service/internal/logging"
+)
+
+// cycleVerbosity steps through the verbosity presets on each signal: normal (log.level and
+// metrics.log_interval as configured), verbose (debug level) and quiet (warnings and errors,
+// no metrics log). The API's PUT /debug/logging sets them one by one instead.
+func cycleVerbosity(ctx context.Context, signals <-chan os.Signal, service *discovery.Service) {
+	normalLevel, err := logging.ParseLevel(viper.GetString("log.level"))
+	if err != nil {
+		normalLevel = logging.LevelInfo
+	}
+	normalInterval := service.MetricsLogInterval()
+
+	presets := []struct {
+		name    string
+		level   logging.Level
+		metrics bool // Metrics logged at the configured interval, or not at all
+	}{
+		{"normal", normalLevel, true},
+		{"verbose", logging.LevelDebug, true},
+		{"quiet", logging.LevelWarn, false},
+	}
+	current := 0
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-signals:
+		}
+		current = (current + 1) % len(presets)
+		p := presets[current]
+		interval := normalInterval
+		if !p.metrics {
+			interval = 0
+		}
+		service.SetMetricsLogInterval(interval)
+		logging.SetLevelf(p.level, "🔧 Verbosity %s (SIGUSR2) | level=%s | metrics interval=%s", p.name, p.level, interval)
+	}
+}
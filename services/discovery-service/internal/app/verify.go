@@ -0,0 +1,103 @@
+package app
+
+import (
+	"context"
+	"fmt"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/spf13/cobra"
+	"github.com/stoik/vigil/services/discovery-service/internal/db"
+	"github.com/stoik/vigil/services/discovery-service/internal/discovery"
+	"github.com/stoik/vigil/services/discovery-service/internal/telemetry"
+)
+
+var verifyCmd = &cobra.Command{
+	Use:   "verify",
+	Short: "Audit stored emails against the provider",
+	Long: "Re-queries the provider for each user's emails received in a time range and cross-checks them " +
+		"against stored fingerprints and user_emails links, reporting missing and extra emails",
+	RunE: func(cmd *cobra.Command, args []string) error {
+		ctx := context.Background()
+
+		now := time.Now()
+		since, _ := cmd.Flags().GetDuration("since")
+		settle, _ := cmd.Flags().GetDuration("settle")
+		userIDStr, _ := cmd.Flags().GetString("user")
+		maxReport, _ := cmd.Flags().GetInt("max-report")
+
+		from := now.Add(-since)
+		// Emails received in the last poll cycle may legitimately not be stored yet
+		to := now.Add(-settle)
+		if !from.Before(to) {
+			return fmt.Errorf("empty time range: --since must be greater than --settle")
+		}
+
+		var userID *uuid.UUID
+		if userIDStr != "" {
+			id, err := uuid.Parse(userIDStr)
+			if err != nil {
+				return fmt.Errorf("invalid --user: %w", err)
+			}
+			userID = &id
+		}
+
+		// Initialize database
+		if err := openDB(ctx); err != nil {
+			return err
+		}
+		defer db.Close()
+
+		fmt.Printf("Verifying emails received between %s and %s...\n", from.Format(time.RFC3339), to.Format(time.RFC3339))
+
+		service, err := discovery.NewService()
+		if err != nil {
+			return fmt.Errorf("invalid discovery configuration: %w", err)
+		}
+		results, err := service.Verify(ctx, from, to, userID)
+		if err != nil {
+			return err
+		}
+
+		var providerTotal, storedTotal, missingTotal, extraTotal, failedUsers int
+		for _, result := range results {
+			providerTotal += result.Provider
+			storedTotal += result.Stored
+			missingTotal += len(result.Missing)
+			extraTotal += len(result.Extra)
+			if result.OK() {
+				continue
+			}
+
+			failedUsers++
+			if failedUsers <= maxReport {
+				fmt.Printf("✗ %s (%s): provider %d, stored %d, missing %d, extra %d\n",
+					telemetry.Redact(result.Email), result.UserID, result.Provider, result.Stored, len(result.Missing), len(result.Extra))
+				for _, messageID := range result.Missing {
+					fmt.Printf("    missing message %s\n", messageID)
+				}
+				for _, fingerprint := range result.Extra {
+					fmt.Printf("    extra fingerprint %s\n", fingerprint)
+				}
+			}
+		}
+
+		fmt.Printf("\nUsers: %d (%d with discrepancies) | Provider emails: %d | Stored: %d | Missing: %d | Extra: %d\n",
+			len(results), failedUsers, providerTotal, storedTotal, missingTotal, extraTotal)
+
+		if failedUsers > 0 {
+			return fmt.Errorf("verification failed: %d missing, %d extra emails", missingTotal, extraTotal)
+		}
+		fmt.Println("✓ All stored emails match the provider")
+		return nil
+	},
+}
+
+func init() {
+	verifyCmd.Flags().Duration("since", 24*time.Hour, "Start of the time range, relative to now")
+	verifyCmd.Flags().Duration("settle", 2*time.Minute, "Exclude emails received in this most recent window (not yet polled)")
+	verifyCmd.Flags().String("user", "", "Verify a single user ID (default: all users)")
+	verifyCmd.Flags().Int("max-report", 20, "Maximum number of users with discrepancies to detail")
+
+	rootCmd.AddCommand(verifyCmd)
+}
@@ -0,0 +1,28 @@
+package audit
+
+import (
+	"context"
+	"log"
+
+	"github.com/stoik/vigil/services/discovery-service/internal/db"
+)
+
+// Outcomes recorded with each audit entry
+const (
+	OutcomeSuccess   = "success"
+	OutcomeDenied    = "denied"
+	OutcomeFailure   = "failure"
+	OutcomeAttempted = "attempted" // Written before a privileged action, then success or failure
+)
+
+// Record writes an audit entry to the audit_log table and the service log
+// Callers guarding sensitive data must fail closed when Record returns an error
+func Record(ctx context.Context, actor, action, target, outcome string) error {
+	log.Printf("AUDIT actor=%s action=%s target=%s outcome=%s", actor, action, target, outcome)
+
+	_, err := db.Pool.Exec(ctx,
+		`INSERT INTO audit_log (actor, action, target, outcome) VALUES ($1, $2, $3, $4)`,
+		actor, action, target, outcome,
+	)
+	return err
+}
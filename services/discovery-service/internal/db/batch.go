@@ -0,0 +1,22 @@
+package db
+
+import "context"
+
+// InBatches runs a statement taking the batch size as $1 until it affects fewer rows than a batch
+// Returns the rows affected; deletes and updates stay short, keeping locks and WAL bursts small.
+func InBatches(ctx context.Context, batchSize int, query string, args ...any) (int64, error) {
+	var total int64
+	for {
+		tag, err := Pool.Exec(ctx, query, append([]any{batchSize}, args...)...)
+		if err != nil {
+			return total, err
+		}
+		total += tag.RowsAffected()
+		if tag.RowsAffected() < int64(batchSize) {
+			return total, nil
+		}
+		if err := ctx.Err(); err != nil {
+			return total, err
+		}
+	}
+}
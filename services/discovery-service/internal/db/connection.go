@@ -8,8 +8,14 @@ import (
 	"github.com/spf13/viper"
 )
 
+// Pool is the primary pool, used for all writes
 var Pool *pgxpool.Pool
 
+// ReadPool serves read-only queries (user lookups, heavy reporting queries)
+// It points at the read replica when database.read_url is set, otherwise it is the primary Pool
+// Replica lag is tolerable: a stale cursor only re-fetches emails that dedup absorbs
+var ReadPool *pgxpool.Pool
+
 func Init(ctx context.Context) error {
 	connString := viper.GetString("database.url")
 	if connString == "" {
@@ -27,12 +33,29 @@ func Init(ctx context.Context) error {
 		return fmt.Errorf("failed to ping database: %w", err)
 	}
 
+	readConnString := viper.GetString("database.read_url")
+	if readConnString == "" {
+		ReadPool = Pool
+		return nil
+	}
+
+	ReadPool, err = pgxpool.New(ctx, readConnString)
+	if err != nil {
+		return fmt.Errorf("failed to create read connection pool: %w", err)
+	}
+
+	if err := ReadPool.Ping(ctx); err != nil {
+		return fmt.Errorf("failed to ping read replica: %w", err)
+	}
+
 	return nil
 }
 
 func Close() {
+	if ReadPool != nil && ReadPool != Pool {
+		ReadPool.Close()
+	}
 	if Pool != nil {
 		Pool.Close()
 	}
 }
-
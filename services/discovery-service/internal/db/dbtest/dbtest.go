@@ -0,0 +1,46 @@
+// Package dbtest connects tests to the database in VIGIL_TEST_DATABASE_URL
+package dbtest
+
+import (
+	"context"
+	"os"
+	"strings"
+	"testing"
+
+	"github.com/jackc/pgx/v5/pgxpool"
+	"github.com/stoik/vigil/services/discovery-service/internal/db"
+)
+
+// EnvURL names the variable holding the test database URL
+const EnvURL = "VIGIL_TEST_DATABASE_URL"
+
+// Open connects db.Pool and db.ReadPool to the database in VIGIL_TEST_DATABASE_URL, migrates it
+// and truncates tables (with CASCADE); the pool is closed when the test ends
+// Tests are skipped when it is not set (the database is wiped, never point it at real data)
+func Open(t testing.TB, tables ...string) context.Context {
+	t.Helper()
+
+	url := os.Getenv(EnvURL)
+	if url == "" {
+		t.Skip(EnvURL + " not set")
+	}
+
+	ctx := context.Background()
+	pool, err := pgxpool.New(ctx, url)
+	if err != nil {
+		t.Fatalf("failed to connect: %v", err)
+	}
+	db.Pool = pool
+	db.ReadPool = pool
+	t.Cleanup(pool.Close)
+
+	if err := db.Migrate(ctx); err != nil {
+		t.Fatalf("failed to migrate: %v", err)
+	}
+	if len(tables) > 0 {
+		if _, err := pool.Exec(ctx, "TRUNCATE "+strings.Join(tables, ", ")+" CASCADE"); err != nil {
+			t.Fatalf("failed to reset tables: %v", err)
+		}
+	}
+	return ctx
+}
@@ -0,0 +1,325 @@
+package db
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log"
+
+	"github.com/jackc/pgx/v5"
+	"github.com/jackc/pgx/v5/pgconn"
+	"github.com/spf13/viper"
+)
+
+// SchemaVersion is the version of schemaSQL, recorded in schema_version by Migrate
+// Bump it with every schema change. Changes are expected to be additive (tables, columns and
+// indexes older builds ignore); raise schemaCompatibleFrom to SchemaVersion when one is not.
+const SchemaVersion = 1
+
+// schemaCompatibleFrom is the oldest SchemaVersion whose code still runs against this schema
+const schemaCompatibleFrom = 1
+
+var (
+	// ErrSchemaOutdated is returned when the database was not migrated for this build
+	ErrSchemaOutdated = errors.New("database schema is older than this build")
+	// ErrSchemaTooNew is returned when the database was migrated by a build this one cannot run with
+	ErrSchemaTooNew = errors.New("database schema is newer than this build supports")
+)
+
+// schemaSQL creates the discovery tables (idempotent)
+const schemaSQL = `
+	-- Tenant table (single record per database)
+	CREATE TABLE IF NOT EXISTS tenant (
+	    id UUID PRIMARY KEY,
+	    name VARCHAR(255),
+	    provider VARCHAR(2)
+	);
+
+	-- Offboarding: running instances stop once offboarded_at is set, purged_at records erasure
+	ALTER TABLE tenant ADD COLUMN IF NOT EXISTS offboarded_at TIMESTAMP WITH TIME ZONE;
+	ALTER TABLE tenant ADD COLUMN IF NOT EXISTS purged_at TIMESTAMP WITH TIME ZONE;
+
+	-- Users table
+	CREATE TABLE IF NOT EXISTS users (
+	    id UUID PRIMARY KEY,
+	    email VARCHAR(255) NOT NULL UNIQUE,
+	    last_email_check TIMESTAMP WITH TIME ZONE,
+	    last_email_received TIMESTAMP WITH TIME ZONE
+	);
+
+	CREATE INDEX IF NOT EXISTS idx_users_last_email_received ON users(last_email_received);
+
+	-- Emails table (stores minimal metadata only - zero copy principle)
+	CREATE TABLE IF NOT EXISTS emails (
+	    id UUID PRIMARY KEY,
+	    fingerprint VARCHAR(64) NOT NULL UNIQUE,
+	    received_at TIMESTAMP WITH TIME ZONE NOT NULL
+	);
+
+	CREATE INDEX IF NOT EXISTS idx_emails_received_at ON emails(received_at);
+	CREATE INDEX IF NOT EXISTS idx_emails_fingerprint ON emails(fingerprint);
+
+	-- Search metadata: sender domain (not the address) and when analysis flagged the email
+	ALTER TABLE emails ADD COLUMN IF NOT EXISTS sender_domain VARCHAR(255);
+	ALTER TABLE emails ADD COLUMN IF NOT EXISTS detected_at TIMESTAMP WITH TIME ZONE;
+
+	CREATE INDEX IF NOT EXISTS idx_emails_received_at_id ON emails(received_at, id);
+	CREATE INDEX IF NOT EXISTS idx_emails_sender_domain ON emails(sender_domain, received_at);
+	CREATE INDEX IF NOT EXISTS idx_emails_detected_at ON emails(detected_at) WHERE detected_at IS NOT NULL;
+
+	-- Full-text search over subject and snippet (only populated with search.store_text)
+	ALTER TABLE emails ADD COLUMN IF NOT EXISTS subject TEXT;
+	ALTER TABLE emails ADD COLUMN IF NOT EXISTS snippet TEXT;
+	ALTER TABLE emails ADD COLUMN IF NOT EXISTS search_vector TSVECTOR
+	    GENERATED ALWAYS AS (
+	        setweight(to_tsvector('english', COALESCE(subject, '')), 'A') ||
+	        setweight(to_tsvector('english', COALESCE(snippet, '')), 'B')
+	    ) STORED;
+
+	CREATE INDEX IF NOT EXISTS idx_emails_search_vector ON emails USING GIN(search_vector);
+
+	-- Extended metadata for investigation and reporting (only populated with storage.metadata)
+	ALTER TABLE emails ADD COLUMN IF NOT EXISTS subject_hash VARCHAR(64);
+	ALTER TABLE emails ADD COLUMN IF NOT EXISTS size_bytes INTEGER;
+
+	CREATE INDEX IF NOT EXISTS idx_emails_subject_hash ON emails(subject_hash, received_at) WHERE subject_hash IS NOT NULL;
+
+	-- User to Emails junction table (many-to-many relationship)
+	CREATE TABLE IF NOT EXISTS user_emails (
+	    user_id UUID NOT NULL REFERENCES users(id) ON DELETE CASCADE,
+	    email_id UUID NOT NULL REFERENCES emails(id) ON DELETE CASCADE,
+	    PRIMARY KEY (user_id, email_id)
+	);
+
+	CREATE INDEX IF NOT EXISTS idx_user_emails_user_id ON user_emails(user_id);
+	CREATE INDEX IF NOT EXISTS idx_user_emails_email_id ON user_emails(email_id);
+
+	-- Remediation of each copy (set by remediation tooling or POST /emails/:id/recipients/:user/remediation)
+	ALTER TABLE user_emails ADD COLUMN IF NOT EXISTS remediated_at TIMESTAMP WITH TIME ZONE;
+	ALTER TABLE user_emails ADD COLUMN IF NOT EXISTS remediation VARCHAR(32);
+
+	-- Audit log for access to sensitive data and admin operations
+	CREATE TABLE IF NOT EXISTS audit_log (
+	    id BIGSERIAL PRIMARY KEY,
+	    at TIMESTAMP WITH TIME ZONE NOT NULL DEFAULT NOW(),
+	    actor VARCHAR(255) NOT NULL,
+	    action VARCHAR(64) NOT NULL,
+	    target VARCHAR(255) NOT NULL,
+	    outcome VARCHAR(16) NOT NULL
+	);
+
+	CREATE INDEX IF NOT EXISTS idx_audit_log_at ON audit_log(at);
+
+	-- Discovery/detection events for cursor-based consumers (see events.List)
+	-- txid orders events by inserting transaction so consumers never skip a late commit
+	CREATE TABLE IF NOT EXISTS events (
+	    id BIGSERIAL PRIMARY KEY,
+	    txid XID8 NOT NULL DEFAULT pg_current_xact_id(),
+	    at TIMESTAMP WITH TIME ZONE NOT NULL DEFAULT NOW(),
+	    type VARCHAR(64) NOT NULL,
+	    user_id UUID,
+	    email_id UUID,
+	    data JSONB
+	);
+
+	CREATE INDEX IF NOT EXISTS idx_events_txid_id ON events(txid, id);
+	CREATE INDEX IF NOT EXISTS idx_events_user_id ON events(user_id) WHERE user_id IS NOT NULL;
+	CREATE INDEX IF NOT EXISTS idx_audit_log_target ON audit_log(target);
+
+	-- Events cursor of each SIEM export
+	CREATE TABLE IF NOT EXISTS siem_cursors (
+	    name VARCHAR(64) PRIMARY KEY,
+	    cursor TEXT NOT NULL,
+	    updated_at TIMESTAMP WITH TIME ZONE NOT NULL
+	);
+
+	-- Analysis queue publications within the dedup window (see discovery.dedupPublisher)
+	CREATE TABLE IF NOT EXISTS queue_dedup (
+	    tenant_id UUID NOT NULL,
+	    idempotency_key VARCHAR(64) NOT NULL,
+	    published_at TIMESTAMP WITH TIME ZONE NOT NULL,
+	    PRIMARY KEY (tenant_id, idempotency_key)
+	);
+
+	CREATE INDEX IF NOT EXISTS idx_queue_dedup_published_at ON queue_dedup(published_at);
+
+	-- Fingerprints of algorithms other than SHA256 are prefixed ("blake3:<hex>"), widened once
+	DO $$
+	BEGIN
+	    IF (SELECT character_maximum_length FROM information_schema.columns
+	        WHERE table_schema = current_schema() AND table_name = 'emails' AND column_name = 'fingerprint') < 80 THEN
+	        ALTER TABLE emails ALTER COLUMN fingerprint TYPE VARCHAR(80);
+	        ALTER TABLE queue_dedup ALTER COLUMN idempotency_key TYPE VARCHAR(80);
+	    END IF;
+	END $$;
+
+	-- Digest periods already delivered (see digest.Scheduler)
+	CREATE TABLE IF NOT EXISTS digest_runs (
+	    period VARCHAR(16) NOT NULL,
+	    period_start TIMESTAMP WITH TIME ZONE NOT NULL,
+	    sent_at TIMESTAMP WITH TIME ZONE NOT NULL,
+	    PRIMARY KEY (period, period_start)
+	);
+
+	-- Campaigns: emails with similar content (see discovery.campaignJob, campaigns.enabled)
+	CREATE TABLE IF NOT EXISTS campaigns (
+	    id UUID PRIMARY KEY,
+	    first_seen TIMESTAMP WITH TIME ZONE NOT NULL,
+	    last_seen TIMESTAMP WITH TIME ZONE NOT NULL,
+	    emails INTEGER NOT NULL DEFAULT 0
+	);
+
+	CREATE INDEX IF NOT EXISTS idx_campaigns_last_seen ON campaigns(last_seen);
+
+	-- MinHash signature of new emails, and their campaign once clustered
+	ALTER TABLE emails ADD COLUMN IF NOT EXISTS minhash BYTEA;
+	ALTER TABLE emails ADD COLUMN IF NOT EXISTS clustered_at TIMESTAMP WITH TIME ZONE;
+	ALTER TABLE emails ADD COLUMN IF NOT EXISTS campaign_id UUID REFERENCES campaigns(id) ON DELETE SET NULL;
+
+	CREATE INDEX IF NOT EXISTS idx_emails_unclustered ON emails(received_at, id) WHERE minhash IS NOT NULL AND clustered_at IS NULL;
+	CREATE INDEX IF NOT EXISTS idx_emails_campaign_id ON emails(campaign_id) WHERE campaign_id IS NOT NULL;
+
+	-- LSH band keys of clustered emails: emails sharing a key are compared
+	CREATE TABLE IF NOT EXISTS email_minhash_bands (
+	    band_key BIGINT NOT NULL,
+	    email_id UUID NOT NULL REFERENCES emails(id) ON DELETE CASCADE,
+	    PRIMARY KEY (band_key, email_id)
+	);
+
+	CREATE INDEX IF NOT EXISTS idx_email_minhash_bands_email_id ON email_minhash_bands(email_id);
+
+	-- Emails reported by users (report-phish buttons), linked to the stored copy
+	CREATE TABLE IF NOT EXISTS reports (
+	    id UUID PRIMARY KEY,
+	    reported_at TIMESTAMP WITH TIME ZONE NOT NULL DEFAULT NOW(),
+	    user_id UUID NOT NULL REFERENCES users(id) ON DELETE CASCADE,
+	    email_id UUID NOT NULL REFERENCES emails(id) ON DELETE CASCADE,
+	    source VARCHAR(64),
+	    comment TEXT,
+	    already_discovered BOOLEAN NOT NULL
+	);
+
+	CREATE INDEX IF NOT EXISTS idx_reports_email_id ON reports(email_id);
+	CREATE INDEX IF NOT EXISTS idx_reports_user_id ON reports(user_id, reported_at);
+
+	-- Users paused by an operator are not polled (see discovery bulk jobs)
+	ALTER TABLE users ADD COLUMN IF NOT EXISTS paused_at TIMESTAMP WITH TIME ZONE;
+
+	-- Emails whose analysis queue publication failed, cleared once requeued
+	ALTER TABLE emails ADD COLUMN IF NOT EXISTS queue_failed_at TIMESTAMP WITH TIME ZONE;
+
+	CREATE INDEX IF NOT EXISTS idx_emails_queue_failed ON emails(received_at, id) WHERE queue_failed_at IS NOT NULL;
+
+	-- Long-running operations and their progress (see package jobs), first named bulk_jobs
+	DO $$
+	BEGIN
+	    IF to_regclass('bulk_jobs') IS NOT NULL AND to_regclass('jobs') IS NULL THEN
+	        ALTER TABLE bulk_jobs RENAME TO jobs;
+	        ALTER INDEX idx_bulk_jobs_created_at RENAME TO idx_jobs_created_at;
+	    END IF;
+	END $$;
+
+	CREATE TABLE IF NOT EXISTS jobs (
+	    id UUID PRIMARY KEY,
+	    operation VARCHAR(32) NOT NULL,
+	    request JSONB NOT NULL,
+	    status VARCHAR(16) NOT NULL,
+	    created_by VARCHAR(255),
+	    created_at TIMESTAMP WITH TIME ZONE NOT NULL DEFAULT NOW(),
+	    started_at TIMESTAMP WITH TIME ZONE,
+	    finished_at TIMESTAMP WITH TIME ZONE,
+	    updated_at TIMESTAMP WITH TIME ZONE NOT NULL DEFAULT NOW(),
+	    total INTEGER,
+	    processed INTEGER NOT NULL DEFAULT 0,
+	    succeeded INTEGER NOT NULL DEFAULT 0,
+	    skipped INTEGER NOT NULL DEFAULT 0,
+	    failed INTEGER NOT NULL DEFAULT 0,
+	    errors JSONB,
+	    error TEXT
+	);
+
+	ALTER TABLE jobs ADD COLUMN IF NOT EXISTS cancel_requested_at TIMESTAMP WITH TIME ZONE;
+
+	CREATE INDEX IF NOT EXISTS idx_jobs_created_at ON jobs(created_at);
+
+	-- Version of this schema and the oldest build version that runs against it (see CheckSchema)
+	CREATE TABLE IF NOT EXISTS schema_version (
+	    id BOOLEAN PRIMARY KEY DEFAULT TRUE CHECK (id),
+	    version INTEGER NOT NULL,
+	    compatible_from INTEGER NOT NULL,
+	    migrated_at TIMESTAMP WITH TIME ZONE NOT NULL DEFAULT NOW()
+	);
+`
+
+// Migrate creates database tables and indexes if they don't exist, and records SchemaVersion
+// A database migrated by a newer build keeps its version; one this build cannot run with is
+// left untouched.
+func Migrate(ctx context.Context) error {
+	version, compatibleFrom, err := schemaVersion(ctx)
+	if err != nil {
+		return err
+	}
+	if compatibleFrom > SchemaVersion {
+		return checkSchemaVersion(version, compatibleFrom)
+	}
+
+	if _, err := Pool.Exec(ctx, schemaSQL); err != nil {
+		return err
+	}
+	_, err = Pool.Exec(ctx, `
+		INSERT INTO schema_version (version, compatible_from) VALUES ($1, $2)
+		ON CONFLICT (id) DO UPDATE SET version = EXCLUDED.version, compatible_from = EXCLUDED.compatible_from, migrated_at = NOW()
+		WHERE schema_version.version <= EXCLUDED.version`,
+		SchemaVersion, schemaCompatibleFrom,
+	)
+	if err != nil {
+		return fmt.Errorf("failed to record schema version: %w", err)
+	}
+	return nil
+}
+
+// CheckSchema refuses to run against a database this build does not match, instead of
+// failing later on missing columns: one not migrated since an older build, or one migrated by a
+// newer build with breaking changes. Skipped when schema.check is false.
+func CheckSchema(ctx context.Context) error {
+	if !viper.GetBool("schema.check") {
+		return nil
+	}
+	version, compatibleFrom, err := schemaVersion(ctx)
+	if err != nil {
+		return err
+	}
+	if err := checkSchemaVersion(version, compatibleFrom); err != nil {
+		return err
+	}
+	if version > SchemaVersion {
+		log.Printf("Database schema version %d is newer than this build's %d, compatible", version, SchemaVersion)
+	}
+	return nil
+}
+
+// schemaVersion reads the recorded schema version (0 before versioning)
+func schemaVersion(ctx context.Context) (version, compatibleFrom int, err error) {
+	err = Pool.QueryRow(ctx, `SELECT version, compatible_from FROM schema_version`).Scan(&version, &compatibleFrom)
+	var pgErr *pgconn.PgError
+	if errors.Is(err, pgx.ErrNoRows) || errors.As(err, &pgErr) && pgErr.Code == "42P01" { // undefined_table
+		return 0, 0, nil
+	}
+	if err != nil {
+		return 0, 0, fmt.Errorf("failed to read schema version: %w", err)
+	}
+	return version, compatibleFrom, nil
+}
+
+// checkSchemaVersion compares a database's schema version with this build's
+func checkSchemaVersion(version, compatibleFrom int) error {
+	switch {
+	case version < SchemaVersion:
+		return fmt.Errorf("%w: database at version %d, this build needs %d; run `discovery setup` with this build to migrate (safe on live data)",
+			ErrSchemaOutdated, version, SchemaVersion)
+	case compatibleFrom > SchemaVersion:
+		return fmt.Errorf("%w: database at version %d needs a build of version %d or later, this build is %d; deploy the newer build",
+			ErrSchemaTooNew, version, compatibleFrom, SchemaVersion)
+	}
+	return nil
+}
@@ -0,0 +1,26 @@
+package db
+
+import (
+	"errors"
+	"testing"
+)
+
+func TestCheckSchemaVersion(t *testing.T) {
+	tests := []struct {
+		name           string
+		version        int
+		compatibleFrom int
+		want           error
+	}{
+		{"unversioned", 0, 0, ErrSchemaOutdated},
+		{"outdated", SchemaVersion - 1, 1, ErrSchemaOutdated},
+		{"current", SchemaVersion, schemaCompatibleFrom, nil},
+		{"newer, additive", SchemaVersion + 1, SchemaVersion, nil},
+		{"newer, breaking", SchemaVersion + 1, SchemaVersion + 1, ErrSchemaTooNew},
+	}
+	for _, tt := range tests {
+		if err := checkSchemaVersion(tt.version, tt.compatibleFrom); !errors.Is(err, tt.want) || (err == nil) != (tt.want == nil) {
+			t.Errorf("%s: checkSchemaVersion(%d, %d) = %v, want %v", tt.name, tt.version, tt.compatibleFrom, err, tt.want)
+		}
+	}
+}
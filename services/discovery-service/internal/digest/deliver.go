@@ -0,0 +1,123 @@
+package digest
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"io"
+	"mime"
+	"mime/quotedprintable"
+	"net"
+	"net/http"
+	"net/smtp"
+	"strings"
+	"time"
+)
+
+// Deliverer sends a digest to its recipients
+type Deliverer interface {
+	Name() string
+	Deliver(ctx context.Context, d Digest) error
+}
+
+// WebhookDeliverer posts the JSON digest to a URL
+type WebhookDeliverer struct {
+	Client *http.Client
+	URL    string
+	Token  string // Sent as a bearer token when set
+}
+
+func (w *WebhookDeliverer) Name() string { return "webhook" }
+
+func (w *WebhookDeliverer) Deliver(ctx context.Context, d Digest) error {
+	var body bytes.Buffer
+	if err := Render(&body, d, FormatJSON); err != nil {
+		return err
+	}
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, w.URL, &body)
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", "application/json")
+	if w.Token != "" {
+		req.Header.Set("Authorization", "Bearer "+w.Token)
+	}
+
+	resp, err := w.Client.Do(req)
+	if err != nil {
+		return fmt.Errorf("digest webhook request failed: %w", err)
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode < 200 || resp.StatusCode > 299 {
+		msg, _ := io.ReadAll(io.LimitReader(resp.Body, 1024))
+		return fmt.Errorf("digest webhook returned %d: %s", resp.StatusCode, msg)
+	}
+	return nil
+}
+
+// SMTPDeliverer emails the HTML digest
+// Authentication uses PLAIN when a username is set, which net/smtp only allows over TLS or to localhost
+type SMTPDeliverer struct {
+	Addr     string // host:port
+	Username string
+	Password string
+	From     string
+	To       []string
+}
+
+func (s *SMTPDeliverer) Name() string { return "smtp" }
+
+func (s *SMTPDeliverer) Deliver(ctx context.Context, d Digest) error {
+	var html bytes.Buffer
+	if err := Render(&html, d, FormatHTML); err != nil {
+		return err
+	}
+	msg, err := buildMessage(s.From, s.To, d.Title(), html.Bytes(), time.Now())
+	if err != nil {
+		return err
+	}
+
+	var auth smtp.Auth
+	if s.Username != "" {
+		host, _, err := net.SplitHostPort(s.Addr)
+		if err != nil {
+			return fmt.Errorf("invalid digest.smtp.addr %q: %w", s.Addr, err)
+		}
+		auth = smtp.PlainAuth("", s.Username, s.Password, host)
+	}
+
+	// net/smtp has no context support, so the send runs to completion or its own timeouts
+	if err := smtp.SendMail(s.Addr, auth, s.From, s.To, msg); err != nil {
+		return fmt.Errorf("digest email failed: %w", err)
+	}
+	return nil
+}
+
+// buildMessage formats an HTML email (quoted-printable, so long template lines stay within SMTP limits)
+func buildMessage(from string, to []string, subject string, html []byte, date time.Time) ([]byte, error) {
+	for _, header := range append([]string{from, subject}, to...) {
+		if strings.ContainsAny(header, "\r\n") {
+			return nil, fmt.Errorf("invalid digest email header %q", header)
+		}
+	}
+
+	var msg bytes.Buffer
+	fmt.Fprintf(&msg, "From: %s\r\n", from)
+	fmt.Fprintf(&msg, "To: %s\r\n", strings.Join(to, ", "))
+	fmt.Fprintf(&msg, "Subject: %s\r\n", mime.QEncoding.Encode("utf-8", subject))
+	fmt.Fprintf(&msg, "Date: %s\r\n", date.Format(time.RFC1123Z))
+	msg.WriteString("MIME-Version: 1.0\r\n")
+	msg.WriteString("Content-Type: text/html; charset=utf-8\r\n")
+	msg.WriteString("Content-Transfer-Encoding: quoted-printable\r\n\r\n")
+
+	qp := quotedprintable.NewWriter(&msg)
+	if _, err := qp.Write(html); err != nil {
+		return nil, err
+	}
+	if err := qp.Close(); err != nil {
+		return nil, err
+	}
+	return msg.Bytes(), nil
+}
@@ -0,0 +1,198 @@
+package digest
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/jackc/pgx/v5"
+	"github.com/stoik/vigil/services/discovery-service/internal/db"
+	"github.com/stoik/vigil/services/discovery-service/internal/schedule"
+)
+
+// Periods
+const (
+	PeriodDaily  Period = "daily"
+	PeriodWeekly Period = "weekly"
+)
+
+const (
+	DefaultTopSenders = 10
+	DefaultStaleAfter = time.Hour
+)
+
+// Period is how much time a digest covers
+type Period string
+
+// ParsePeriod validates a digest period name
+func ParsePeriod(s string) (Period, error) {
+	switch p := Period(s); p {
+	case PeriodDaily, PeriodWeekly:
+		return p, nil
+	}
+	return "", fmt.Errorf("invalid digest period %q (want %q or %q)", s, PeriodDaily, PeriodWeekly)
+}
+
+// Window returns the last complete period before now as [from, to), in the tenant's time zone
+// Days start at local midnight, weeks on Monday, so a day spanning a daylight saving change
+// lasts 23 or 25 hours
+func (p Period) Window(now time.Time, loc *time.Location) (time.Time, time.Time) {
+	to := schedule.StartOfDay(now, loc)
+	if p == PeriodWeekly {
+		// time.Weekday counts from Sunday
+		to = schedule.StartOfDay(to.AddDate(0, 0, -((int(to.Weekday())+6)%7)), loc)
+		return schedule.StartOfDay(to.AddDate(0, 0, -7), loc), to
+	}
+	return schedule.StartOfDay(to.AddDate(0, 0, -1), loc), to
+}
+
+// Options tune what a digest reports
+type Options struct {
+	TopSenders int           // Risky sender domains listed (default DefaultTopSenders)
+	StaleAfter time.Duration // Users not polled for this long at the end of the period are stale (default DefaultStaleAfter)
+}
+
+// Digest summarizes detections, coverage and ingest health of a tenant over a period
+type Digest struct {
+	TenantID    uuid.UUID `json:"tenant_id"`
+	TenantName  string    `json:"tenant_name,omitempty"`
+	Period      Period    `json:"period"`
+	From        time.Time `json:"from"`
+	To          time.Time `json:"to"`
+	Timezone    string    `json:"timezone"` // Time zone days and weeks are counted in
+	GeneratedAt time.Time `json:"generated_at"`
+
+	Detections Detections    `json:"detections"`
+	TopSenders []RiskySender `json:"top_senders"`
+	Coverage   Coverage      `json:"coverage"`
+	Ingest     IngestHealth  `json:"ingest"`
+}
+
+// Detections counts emails flagged by analysis in the period
+type Detections struct {
+	Emails        int64 `json:"emails"`
+	UsersAffected int64 `json:"users_affected"`
+}
+
+// RiskySender is a sender domain ranked by detections in the period
+type RiskySender struct {
+	Domain     string `json:"domain"`
+	Detections int64  `json:"detections"`
+	Emails     int64  `json:"emails"` // All emails received from the domain in the period
+}
+
+// Coverage reports how many monitored users were actually polled
+type Coverage struct {
+	Users       int64   `json:"users"`
+	Polled      int64   `json:"polled"` // Polled since StaleAfter before the end of the period
+	Stale       int64   `json:"stale"`  // Last polled before that
+	NeverPolled int64   `json:"never_polled"`
+	Percent     float64 `json:"percent"` // Share of users polled, 0-100
+}
+
+// IngestHealth describes discovery volume over the period
+type IngestHealth struct {
+	EmailsDiscovered int64      `json:"emails_discovered"`
+	UsersWithEmail   int64      `json:"users_with_email"`
+	LastPollAt       *time.Time `json:"last_poll_at,omitempty"`
+	LastEmailAt      *time.Time `json:"last_email_at,omitempty"`
+}
+
+// Build computes the digest of [from, to) on the read pool
+func Build(ctx context.Context, tenantID uuid.UUID, period Period, from, to time.Time, opts Options) (Digest, error) {
+	if opts.TopSenders <= 0 {
+		opts.TopSenders = DefaultTopSenders
+	}
+	if opts.StaleAfter <= 0 {
+		opts.StaleAfter = DefaultStaleAfter
+	}
+
+	d := Digest{
+		TenantID:    tenantID,
+		Period:      period,
+		From:        from,
+		To:          to,
+		Timezone:    from.Location().String(),
+		GeneratedAt: time.Now(),
+		TopSenders:  []RiskySender{},
+	}
+
+	var name *string
+	err := db.ReadPool.QueryRow(ctx, `SELECT name FROM tenant WHERE id = $1`, tenantID).Scan(&name)
+	if err != nil && !errors.Is(err, pgx.ErrNoRows) {
+		return d, fmt.Errorf("failed to get tenant: %w", err)
+	}
+	if name != nil {
+		d.TenantName = *name
+	}
+
+	if err := db.ReadPool.QueryRow(ctx, `
+		SELECT COUNT(DISTINCT e.id), COUNT(DISTINCT ue.user_id)
+		FROM emails e
+		LEFT JOIN user_emails ue ON ue.email_id = e.id
+		WHERE e.detected_at >= $1 AND e.detected_at < $2`,
+		from, to,
+	).Scan(&d.Detections.Emails, &d.Detections.UsersAffected); err != nil {
+		return d, fmt.Errorf("failed to count detections: %w", err)
+	}
+
+	// Ranked by detections, then by volume: a domain with one detection among thousands
+	// of emails is a compromised sender rather than a campaign, but both are worth a look
+	rows, err := db.ReadPool.Query(ctx, `
+		SELECT sender_domain,
+			COUNT(*) FILTER (WHERE detected_at >= $1 AND detected_at < $2),
+			COUNT(*) FILTER (WHERE received_at >= $1 AND received_at < $2)
+		FROM emails
+		WHERE sender_domain IS NOT NULL
+			AND ((detected_at >= $1 AND detected_at < $2) OR (received_at >= $1 AND received_at < $2))
+		GROUP BY sender_domain
+		HAVING COUNT(*) FILTER (WHERE detected_at >= $1 AND detected_at < $2) > 0
+		ORDER BY 2 DESC, 3 DESC, 1
+		LIMIT $3`,
+		from, to, opts.TopSenders,
+	)
+	if err != nil {
+		return d, fmt.Errorf("failed to rank senders: %w", err)
+	}
+	for rows.Next() {
+		var s RiskySender
+		if err := rows.Scan(&s.Domain, &s.Detections, &s.Emails); err != nil {
+			rows.Close()
+			return d, fmt.Errorf("failed to scan sender: %w", err)
+		}
+		d.TopSenders = append(d.TopSenders, s)
+	}
+	rows.Close()
+	if err := rows.Err(); err != nil {
+		return d, fmt.Errorf("failed to rank senders: %w", err)
+	}
+
+	if err := db.ReadPool.QueryRow(ctx, `
+		SELECT COUNT(*),
+			COUNT(*) FILTER (WHERE last_email_check >= $1),
+			COUNT(*) FILTER (WHERE last_email_check < $1),
+			COUNT(*) FILTER (WHERE last_email_check IS NULL),
+			MAX(last_email_check)
+		FROM users`,
+		to.Add(-opts.StaleAfter),
+	).Scan(&d.Coverage.Users, &d.Coverage.Polled, &d.Coverage.Stale, &d.Coverage.NeverPolled, &d.Ingest.LastPollAt); err != nil {
+		return d, fmt.Errorf("failed to count users: %w", err)
+	}
+	if d.Coverage.Users > 0 {
+		d.Coverage.Percent = float64(d.Coverage.Polled) * 100 / float64(d.Coverage.Users)
+	}
+
+	if err := db.ReadPool.QueryRow(ctx, `
+		SELECT COUNT(DISTINCT e.id), COUNT(DISTINCT ue.user_id), MAX(e.received_at)
+		FROM emails e
+		LEFT JOIN user_emails ue ON ue.email_id = e.id
+		WHERE e.received_at >= $1 AND e.received_at < $2`,
+		from, to,
+	).Scan(&d.Ingest.EmailsDiscovered, &d.Ingest.UsersWithEmail, &d.Ingest.LastEmailAt); err != nil {
+		return d, fmt.Errorf("failed to count discovered emails: %w", err)
+	}
+
+	return d, nil
+}
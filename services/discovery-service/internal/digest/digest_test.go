@@ -0,0 +1,278 @@
+package digest
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"io"
+	"mime/quotedprintable"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/stoik/vigil/services/discovery-service/internal/db"
+	"github.com/stoik/vigil/services/discovery-service/internal/db/dbtest"
+	"github.com/stoik/vigil/services/discovery-service/internal/schedule"
+)
+
+func TestWindow(t *testing.T) {
+	// Wednesday
+	now := time.Date(2024, 3, 6, 15, 30, 0, 0, time.UTC)
+	day := func(d int) time.Time { return time.Date(2024, 3, d, 0, 0, 0, 0, time.UTC) }
+
+	if from, to := PeriodDaily.Window(now, time.UTC); !from.Equal(day(5)) || !to.Equal(day(6)) {
+		t.Errorf("daily window = [%v, %v)", from, to)
+	}
+	if from, to := PeriodWeekly.Window(now, time.UTC); !from.Equal(day(-3)) || !to.Equal(day(4)) {
+		t.Errorf("weekly window = [%v, %v), want the week starting Monday Feb 26", from, to)
+	}
+	// On Monday the week that just ended is reported
+	if from, to := PeriodWeekly.Window(day(11).Add(time.Minute), time.UTC); !from.Equal(day(4)) || !to.Equal(day(11)) {
+		t.Errorf("weekly window on Monday = [%v, %v)", from, to)
+	}
+	// Windows are computed in the tenant's time zone: 23:30 UTC is already the next day in Paris
+	paris, _ := time.LoadLocation("Europe/Paris")
+	local := func(m time.Month, d, h int) time.Time { return time.Date(2024, m, d, h, 0, 0, 0, paris) }
+	if from, to := PeriodDaily.Window(time.Date(2024, 3, 6, 23, 30, 0, 0, time.UTC), paris); !from.Equal(local(3, 6, 0)) || !to.Equal(local(3, 7, 0)) {
+		t.Errorf("daily window = [%v, %v), want Paris March 6", from, to)
+	}
+	// Days spanning a daylight saving change last 23 or 25 hours
+	if from, to := PeriodDaily.Window(local(4, 1, 8), paris); !from.Equal(local(3, 31, 0)) || to.Sub(from) != 23*time.Hour {
+		t.Errorf("spring forward window = [%v, %v), want 23 hours from March 31", from, to)
+	}
+	if from, to := PeriodDaily.Window(local(10, 28, 8), paris); !from.Equal(local(10, 27, 0)) || to.Sub(from) != 25*time.Hour {
+		t.Errorf("fall back window = [%v, %v), want 25 hours from October 27", from, to)
+	}
+	if from, to := PeriodWeekly.Window(local(4, 1, 8), paris); !from.Equal(local(3, 25, 0)) || !to.Equal(local(4, 1, 0)) || to.Sub(from) != 7*24*time.Hour-time.Hour {
+		t.Errorf("weekly window across spring forward = [%v, %v)", from, to)
+	}
+
+	if _, err := ParsePeriod("hourly"); err == nil {
+		t.Error("ParsePeriod accepted an unknown period")
+	}
+}
+
+func TestSchedulerSendAt(t *testing.T) {
+	paris, _ := time.LoadLocation("Europe/Paris")
+	calls := 0
+	s := &Scheduler{period: PeriodDaily, loc: paris, sendAt: schedule.Clock{Hour: 7},
+		deliverers: []Deliverer{deliverFunc(func() error { calls++; return nil })}}
+
+	// 06:59 in Paris on the day clocks spring forward is 04:59 UTC: nothing is due (nor claimed) yet
+	if err := s.sendDue(context.Background(), time.Date(2024, 3, 31, 4, 59, 0, 0, time.UTC)); err != nil || calls != 0 {
+		t.Errorf("sendDue before send_at = %v, %d deliveries; want nothing sent", err, calls)
+	}
+}
+
+func testDigest() Digest {
+	lastPoll := time.Date(2024, 3, 6, 0, 0, 0, 0, time.UTC)
+	return Digest{
+		TenantID:    uuid.New(),
+		TenantName:  "Acme <Corp>",
+		Period:      PeriodDaily,
+		From:        time.Date(2024, 3, 5, 0, 0, 0, 0, time.UTC),
+		To:          time.Date(2024, 3, 6, 0, 0, 0, 0, time.UTC),
+		GeneratedAt: time.Date(2024, 3, 6, 0, 5, 0, 0, time.UTC),
+		Detections:  Detections{Emails: 3, UsersAffected: 2},
+		TopSenders:  []RiskySender{{Domain: "evil<script>.com", Detections: 2, Emails: 5}},
+		Coverage:    Coverage{Users: 4, Polled: 3, NeverPolled: 1, Percent: 75},
+		Ingest:      IngestHealth{EmailsDiscovered: 40, UsersWithEmail: 4, LastPollAt: &lastPoll},
+	}
+}
+
+func TestRender(t *testing.T) {
+	d := testDigest()
+
+	var html bytes.Buffer
+	if err := Render(&html, d, FormatHTML); err != nil {
+		t.Fatalf("render html: %v", err)
+	}
+	for _, want := range []string{"Daily vigil digest for Acme &lt;Corp&gt;: 3 detections", "evil&lt;script&gt;.com",
+		"75.0% of 4 users", "2024-03-05 to 2024-03-06", "Last email received: never"} {
+		if !strings.Contains(html.String(), want) {
+			t.Errorf("html missing %q:\n%s", want, html.String())
+		}
+	}
+
+	// Times are shown in the digest's time zone
+	paris := d
+	paris.Timezone = "Europe/Paris"
+	html.Reset()
+	if err := Render(&html, paris, FormatHTML); err != nil {
+		t.Fatalf("render html: %v", err)
+	}
+	for _, want := range []string{"(Europe/Paris, end exclusive)", "Last poll: 2024-03-06 01:00 CET"} {
+		if !strings.Contains(html.String(), want) {
+			t.Errorf("html missing %q:\n%s", want, html.String())
+		}
+	}
+
+	var raw bytes.Buffer
+	if err := Render(&raw, d, FormatJSON); err != nil {
+		t.Fatalf("render json: %v", err)
+	}
+	var decoded Digest
+	if err := json.Unmarshal(raw.Bytes(), &decoded); err != nil {
+		t.Fatalf("decode json: %v", err)
+	}
+	if decoded.Detections != d.Detections || len(decoded.TopSenders) != 1 || decoded.Coverage != d.Coverage {
+		t.Errorf("json round trip = %+v", decoded)
+	}
+
+	if err := Render(io.Discard, d, "pdf"); err == nil {
+		t.Error("Render accepted an unknown format")
+	}
+}
+
+func TestBuildMessage(t *testing.T) {
+	html := []byte("<p>" + strings.Repeat("détection ", 30) + "</p>")
+	date := time.Date(2024, 3, 6, 0, 5, 0, 0, time.UTC)
+	msg, err := buildMessage("vigil@acme.com", []string{"soc@acme.com", "ciso@acme.com"}, "Daily digest: 3 détections", html, date)
+	if err != nil {
+		t.Fatalf("buildMessage: %v", err)
+	}
+
+	header, body, ok := strings.Cut(string(msg), "\r\n\r\n")
+	if !ok {
+		t.Fatal("no header/body separator")
+	}
+	for _, want := range []string{"To: soc@acme.com, ciso@acme.com\r\n", "Subject: =?utf-8?q?", "Content-Type: text/html"} {
+		if !strings.Contains(header+"\r\n", want) {
+			t.Errorf("header missing %q:\n%s", want, header)
+		}
+	}
+	for _, line := range strings.Split(body, "\r\n") {
+		if len(line) > 76 {
+			t.Errorf("body line longer than 76 characters: %q", line)
+		}
+	}
+	decoded, err := io.ReadAll(quotedprintable.NewReader(strings.NewReader(body)))
+	if err != nil || !bytes.Equal(decoded, html) {
+		t.Errorf("decoded body = %q, %v", decoded, err)
+	}
+
+	if _, err := buildMessage("vigil@acme.com", []string{"soc@acme.com\r\nBcc: x@evil.com"}, "s", html, date); err == nil {
+		t.Error("buildMessage accepted a header with CRLF")
+	}
+}
+
+func TestWebhookDeliverer(t *testing.T) {
+	var got Digest
+	var auth string
+	status := http.StatusNoContent
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		auth = r.Header.Get("Authorization")
+		json.NewDecoder(r.Body).Decode(&got)
+		w.WriteHeader(status)
+	}))
+	defer srv.Close()
+
+	d := testDigest()
+	webhook := &WebhookDeliverer{Client: srv.Client(), URL: srv.URL, Token: "s3cret"}
+	if err := Deliver(context.Background(), d, []Deliverer{webhook}); err != nil {
+		t.Fatalf("deliver: %v", err)
+	}
+	if auth != "Bearer s3cret" || got.TenantID != d.TenantID || got.Detections.Emails != 3 {
+		t.Errorf("webhook received auth %q, digest %+v", auth, got)
+	}
+
+	status = http.StatusBadGateway
+	failing := &WebhookDeliverer{Client: srv.Client(), URL: srv.URL}
+	err := Deliver(context.Background(), d, []Deliverer{failing, webhook})
+	if err == nil || !strings.Contains(err.Error(), "webhook: digest webhook returned 502") {
+		t.Errorf("err = %v, want the failing deliverer's error", err)
+	}
+}
+
+// TestBuild needs VIGIL_TEST_DATABASE_URL (see dbtest.Open)
+func TestBuild(t *testing.T) {
+	ctx := dbtest.Open(t, "tenant", "user_emails", "emails", "users", "digest_runs")
+
+	tenantID := uuid.New()
+	from, to := PeriodDaily.Window(time.Now(), time.UTC)
+	in, before := from.Add(time.Hour), from.Add(-time.Hour)
+
+	mustExec := func(query string, args ...any) {
+		t.Helper()
+		if _, err := db.Pool.Exec(ctx, query, args...); err != nil {
+			t.Fatalf("%s: %v", query, err)
+		}
+	}
+	mustExec(`INSERT INTO tenant (id, name) VALUES ($1, 'Acme')`, tenantID)
+	alice, bob, carol := uuid.New(), uuid.New(), uuid.New()
+	mustExec(`INSERT INTO users (id, email, last_email_check) VALUES ($1, 'alice@acme.com', $4), ($2, 'bob@acme.com', $5), ($3, 'carol@acme.com', NULL)`,
+		alice, bob, carol, to, before)
+
+	emails := []struct {
+		domain     string
+		receivedAt time.Time
+		detectedAt *time.Time
+		users      []uuid.UUID
+	}{
+		{"evil.com", in, &in, []uuid.UUID{alice, bob}},
+		{"evil.com", in, &in, []uuid.UUID{alice}},
+		{"evil.com", in, nil, []uuid.UUID{alice}},
+		{"phish.net", before, &in, []uuid.UUID{bob}}, // Received earlier, flagged in the period
+		{"good.org", in, nil, []uuid.UUID{alice}},
+		{"old.com", before, &before, []uuid.UUID{alice}}, // Outside the period
+	}
+	for i, e := range emails {
+		id := uuid.New()
+		mustExec(`INSERT INTO emails (id, fingerprint, received_at, sender_domain, detected_at) VALUES ($1, $2, $3, $4, $5)`,
+			id, strings.Repeat(string(rune('a'+i)), 64), e.receivedAt, e.domain, e.detectedAt)
+		for _, u := range e.users {
+			mustExec(`INSERT INTO user_emails (user_id, email_id) VALUES ($1, $2)`, u, id)
+		}
+	}
+
+	d, err := Build(ctx, tenantID, PeriodDaily, from, to, Options{})
+	if err != nil {
+		t.Fatalf("Build: %v", err)
+	}
+	if d.TenantName != "Acme" || d.Detections != (Detections{Emails: 3, UsersAffected: 2}) {
+		t.Errorf("tenant %q, detections %+v", d.TenantName, d.Detections)
+	}
+	want := []RiskySender{{"evil.com", 2, 3}, {"phish.net", 1, 0}}
+	if len(d.TopSenders) != len(want) || d.TopSenders[0] != want[0] || d.TopSenders[1] != want[1] {
+		t.Errorf("top senders = %+v, want %+v", d.TopSenders, want)
+	}
+	if d.Coverage != (Coverage{Users: 3, Polled: 1, Stale: 1, NeverPolled: 1, Percent: 100.0 / 3}) {
+		t.Errorf("coverage = %+v", d.Coverage)
+	}
+	if d.Ingest.EmailsDiscovered != 4 || d.Ingest.UsersWithEmail != 2 {
+		t.Errorf("ingest = %+v", d.Ingest)
+	}
+
+	// A sent period is not delivered again
+	calls := 0
+	s := &Scheduler{tenantID: tenantID, period: PeriodDaily, loc: time.UTC, deliverers: []Deliverer{deliverFunc(func() error { calls++; return nil })}}
+	for i := 0; i < 2; i++ {
+		if err := s.sendDue(ctx, time.Now()); err != nil {
+			t.Fatalf("sendDue: %v", err)
+		}
+	}
+	if calls != 1 {
+		t.Errorf("delivered %d times, want 1", calls)
+	}
+
+	// A failed delivery releases the period for the next check
+	mustExec(`TRUNCATE digest_runs`)
+	s.deliverers = []Deliverer{deliverFunc(func() error { calls++; return errors.New("down") })}
+	if err := s.sendDue(ctx, time.Now()); err == nil {
+		t.Fatal("sendDue succeeded with a failing deliverer")
+	}
+	var runs int
+	db.Pool.QueryRow(ctx, `SELECT COUNT(*) FROM digest_runs`).Scan(&runs)
+	if runs != 0 {
+		t.Errorf("digest_runs has %d rows after a failed delivery, want 0", runs)
+	}
+}
+
+type deliverFunc func() error
+
+func (f deliverFunc) Name() string                                { return "func" }
+func (f deliverFunc) Deliver(ctx context.Context, _ Digest) error { return f() }
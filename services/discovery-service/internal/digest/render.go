@@ -0,0 +1,96 @@
+package digest
+
+import (
+	"encoding/json"
+	"fmt"
+	"html/template"
+	"io"
+	"time"
+)
+
+// Formats
+const (
+	FormatHTML = "html"
+	FormatJSON = "json"
+)
+
+// Dates and times are shown in the digest's time zone
+var htmlTemplate = template.Must(template.New("digest").Parse(`<!DOCTYPE html>
+<html>
+<head><meta charset="utf-8"><title>{{.Title}}</title></head>
+<body style="font-family: sans-serif; color: #222;">
+<h2>{{.Title}}</h2>
+<p>{{.Date .From}} to {{.Date .To}} ({{.Timezone}}, end exclusive)</p>
+
+<h3>Detections</h3>
+<p><strong>{{.Detections.Emails}}</strong> emails flagged, affecting <strong>{{.Detections.UsersAffected}}</strong> users.</p>
+
+<h3>Top risky senders</h3>
+{{if .TopSenders}}<table cellpadding="4" style="border-collapse: collapse;">
+<tr><th align="left">Sender domain</th><th align="right">Detections</th><th align="right">Emails</th></tr>
+{{range .TopSenders}}<tr><td>{{.Domain}}</td><td align="right">{{.Detections}}</td><td align="right">{{.Emails}}</td></tr>
+{{end}}</table>{{else}}<p>No detections in this period.</p>{{end}}
+
+<h3>Monitored-user coverage</h3>
+<p>{{printf "%.1f" .Coverage.Percent}}% of {{.Coverage.Users}} users polled recently: {{.Coverage.Polled}} polled, {{.Coverage.Stale}} stale, {{.Coverage.NeverPolled}} never polled.</p>
+
+<h3>Ingest health</h3>
+<p>{{.Ingest.EmailsDiscovered}} emails discovered for {{.Ingest.UsersWithEmail}} users.
+Last poll: {{.When .Ingest.LastPollAt}}. Last email received: {{.When .Ingest.LastEmailAt}}.</p>
+
+<p style="color: #888; font-size: small;">Generated by vigil at {{.When .GeneratedAt}}.</p>
+</body>
+</html>
+`))
+
+// location returns the digest's time zone (UTC if it cannot be loaded)
+func (d Digest) location() *time.Location {
+	if loc, err := time.LoadLocation(d.Timezone); err == nil {
+		return loc
+	}
+	return time.UTC
+}
+
+// Date formats t as a calendar date in the digest's time zone
+func (d Digest) Date(t time.Time) string {
+	return t.In(d.location()).Format("2006-01-02")
+}
+
+// When formats a time (a time.Time or *time.Time, nil for never) in the digest's time zone
+func (d Digest) When(t any) string {
+	switch t := t.(type) {
+	case time.Time:
+		return t.In(d.location()).Format("2006-01-02 15:04 MST")
+	case *time.Time:
+		if t != nil {
+			return d.When(*t)
+		}
+	}
+	return "never"
+}
+
+// Title is the digest headline, also used as the email subject
+func (d Digest) Title() string {
+	tenant := d.TenantName
+	if tenant == "" {
+		tenant = d.TenantID.String()
+	}
+	kind := "Daily"
+	if d.Period == PeriodWeekly {
+		kind = "Weekly"
+	}
+	return fmt.Sprintf("%s vigil digest for %s: %d detections", kind, tenant, d.Detections.Emails)
+}
+
+// Render writes the digest in format (FormatHTML or FormatJSON)
+func Render(w io.Writer, d Digest, format string) error {
+	switch format {
+	case FormatHTML:
+		return htmlTemplate.Execute(w, d)
+	case FormatJSON:
+		enc := json.NewEncoder(w)
+		enc.SetIndent("", "  ")
+		return enc.Encode(d)
+	}
+	return fmt.Errorf("invalid digest format %q (want %q or %q)", format, FormatHTML, FormatJSON)
+}
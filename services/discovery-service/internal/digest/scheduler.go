@@ -0,0 +1,162 @@
+package digest
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log"
+	"net/http"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/spf13/viper"
+	"github.com/stoik/vigil/services/discovery-service/internal/db"
+	"github.com/stoik/vigil/services/discovery-service/internal/schedule"
+)
+
+// CheckInterval is how often the scheduler looks for a period due for delivery
+const CheckInterval = 5 * time.Minute
+
+// Scheduler delivers a digest once per period, after the period ends
+// Periods are counted in the tenant's time zone, and delivery waits for digest.send_at local time
+// Sent periods are recorded in digest_runs, so restarts and other instances of the tenant do not resend
+type Scheduler struct {
+	tenantID   uuid.UUID
+	period     Period
+	loc        *time.Location
+	sendAt     schedule.Clock // Local time of day from which the digest is sent
+	opts       Options
+	deliverers []Deliverer
+}
+
+// NewScheduler creates a scheduler from the digest.* configuration
+// Returns nil when digest.schedule is not set
+func NewScheduler(tenantID uuid.UUID) (*Scheduler, error) {
+	periodName := viper.GetString("digest.schedule")
+	if periodName == "" {
+		return nil, nil
+	}
+	period, err := ParsePeriod(periodName)
+	if err != nil {
+		return nil, err
+	}
+	loc, err := schedule.Location()
+	if err != nil {
+		return nil, err
+	}
+	sendAt, err := schedule.ParseClock(viper.GetString("digest.send_at"))
+	if err != nil {
+		return nil, fmt.Errorf("digest.send_at: %w", err)
+	}
+	deliverers, err := NewDeliverers()
+	if err != nil {
+		return nil, err
+	}
+	if len(deliverers) == 0 {
+		return nil, fmt.Errorf("digest.schedule %q needs digest.webhook_url or digest.smtp.addr", periodName)
+	}
+	return &Scheduler{tenantID: tenantID, period: period, loc: loc, sendAt: sendAt, opts: NewOptions(), deliverers: deliverers}, nil
+}
+
+// NewOptions reads digest contents options from configuration
+func NewOptions() Options {
+	return Options{
+		TopSenders: viper.GetInt("digest.top_senders"),
+		StaleAfter: viper.GetDuration("digest.stale_after"),
+	}
+}
+
+// NewDeliverers creates the deliverers configured in digest.* (none when neither is set)
+func NewDeliverers() ([]Deliverer, error) {
+	var deliverers []Deliverer
+	if url := viper.GetString("digest.webhook_url"); url != "" {
+		deliverers = append(deliverers, &WebhookDeliverer{
+			Client: &http.Client{Timeout: 30 * time.Second},
+			URL:    url,
+			Token:  viper.GetString("digest.webhook_token"),
+		})
+	}
+	if addr := viper.GetString("digest.smtp.addr"); addr != "" {
+		from, to := viper.GetString("digest.smtp.from"), viper.GetStringSlice("digest.smtp.to")
+		if from == "" || len(to) == 0 {
+			return nil, fmt.Errorf("digest.smtp.addr needs digest.smtp.from and digest.smtp.to")
+		}
+		deliverers = append(deliverers, &SMTPDeliverer{
+			Addr:     addr,
+			Username: viper.GetString("digest.smtp.username"),
+			Password: viper.GetString("digest.smtp.password"),
+			From:     from,
+			To:       to,
+		})
+	}
+	return deliverers, nil
+}
+
+// Deliver sends d through every deliverer, attempting all of them
+func Deliver(ctx context.Context, d Digest, deliverers []Deliverer) error {
+	var errs []error
+	for _, deliverer := range deliverers {
+		if err := deliverer.Deliver(ctx, d); err != nil {
+			errs = append(errs, fmt.Errorf("%s: %w", deliverer.Name(), err))
+		}
+	}
+	return errors.Join(errs...)
+}
+
+// Run delivers digests until ctx is cancelled
+func (s *Scheduler) Run(ctx context.Context) {
+	log.Printf("📬 %s digest scheduled at %s %s", s.period, s.sendAt, s.loc)
+
+	ticker := time.NewTicker(CheckInterval)
+	defer ticker.Stop()
+
+	for {
+		if err := s.sendDue(ctx, time.Now()); err != nil && ctx.Err() == nil {
+			log.Printf("Digest delivery failed: %v", err)
+		}
+
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+	}
+}
+
+// sendDue delivers the digest of the last complete period if it has not been sent yet and
+// the send time of the day the period ended has passed
+// The period is claimed before delivery and released if delivery fails, so it is retried on the next check
+func (s *Scheduler) sendDue(ctx context.Context, now time.Time) error {
+	from, to := s.period.Window(now, s.loc)
+	if now.Before(s.sendAt.On(to, s.loc)) {
+		return nil
+	}
+
+	tag, err := db.Pool.Exec(ctx, `
+		INSERT INTO digest_runs (period, period_start, sent_at) VALUES ($1, $2, NOW())
+		ON CONFLICT (period, period_start) DO NOTHING`,
+		s.period, from,
+	)
+	if err != nil {
+		return fmt.Errorf("failed to claim digest: %w", err)
+	}
+	if tag.RowsAffected() == 0 {
+		return nil // Already sent
+	}
+
+	d, err := Build(ctx, s.tenantID, s.period, from, to, s.opts)
+	if err == nil {
+		err = Deliver(ctx, d, s.deliverers)
+	}
+	if err != nil {
+		if _, releaseErr := db.Pool.Exec(context.WithoutCancel(ctx),
+			`DELETE FROM digest_runs WHERE period = $1 AND period_start = $2`, s.period, from,
+		); releaseErr != nil {
+			log.Printf("Failed to release digest claim for %s: %v", from.Format(time.DateOnly), releaseErr)
+		}
+		return err
+	}
+
+	log.Printf("📬 %s digest for %s delivered (%d detections)", s.period, from.Format(time.DateOnly), d.Detections.Emails)
+	return nil
+}
@@ -0,0 +1,278 @@
+package discovery
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/spf13/viper"
+	"github.com/stoik/vigil/services/discovery-service/internal/db"
+	"github.com/stoik/vigil/services/discovery-service/internal/events"
+)
+
+const (
+	DefaultAlertInterval           = 30 * time.Second
+	DefaultAlertIngestStalledAfter = 10 * time.Minute
+	DefaultAlertProviderErrorRate  = 0.25
+	DefaultAlertShutdownWindow     = 24 * time.Hour
+	alertResolvedRetention         = 15 * time.Minute // Resolved alerts stay listed with endsAt, as Prometheus does
+)
+
+// Alert rule names (the alertname label)
+const (
+	AlertIngestStalled       = "DiscoveryIngestStalled"
+	AlertMailboxSLA          = "DiscoveryMailboxSLA"
+	AlertProviderErrorRate   = "DiscoveryProviderErrorRate"
+	AlertQueueLag            = "DiscoveryQueueLag"
+	AlertShutdownNotGraceful = "DiscoveryShutdownNotGraceful"
+)
+
+// AlertConfig holds the thresholds of the built-in alert rules
+type AlertConfig struct {
+	Interval           time.Duration // How often rules are evaluated
+	IngestStalledAfter time.Duration // No successful poll for this long while users are polled
+	MailboxSLA         time.Duration // A mailbox without a successful poll for this long
+	ProviderErrorRate  float64       // Share of polled users whose last poll failed (0-1)
+	QueueLag           time.Duration // p95 latency from provider received_at to queue publish
+	ShutdownWindow     time.Duration // How long a shutdown that timed out keeps firing
+}
+
+// AlertRule is a built-in alerting threshold
+type AlertRule struct {
+	Name      string `json:"name"`
+	Severity  string `json:"severity"` // critical or warning
+	Threshold string `json:"threshold"`
+	Summary   string `json:"summary"`
+}
+
+// Alert is a firing or recently resolved alert, in the Alertmanager API v2 format, so
+// GET /alerts can be forwarded to Alertmanager's POST /api/v2/alerts as is
+type Alert struct {
+	Labels      map[string]string `json:"labels"`
+	Annotations map[string]string `json:"annotations"`
+	StartsAt    time.Time         `json:"startsAt"`
+	EndsAt      *time.Time        `json:"endsAt,omitempty"` // Set once resolved
+}
+
+// alertInput is one evaluation's snapshot of the metric sources
+type alertInput struct {
+	now             time.Time
+	pollers         map[uuid.UUID]pollerState
+	failing         int  // Pollers whose last poll failed
+	pollingPaused   bool // Maintenance window pausing polls
+	mailboxSLA      MailboxSLA
+	queueP95        time.Duration
+	queueSampled    bool
+	uncleanShutdown int // Shutdowns that timed out within the window
+}
+
+// rules returns the built-in rules with the configured thresholds
+func (c AlertConfig) rules() []AlertRule {
+	return []AlertRule{
+		{AlertIngestStalled, "critical", fmt.Sprintf("no successful poll for %v", c.IngestStalledAfter),
+			"No mailbox was polled successfully recently"},
+		{AlertMailboxSLA, "warning", fmt.Sprintf("a mailbox not polled successfully for %v", c.MailboxSLA),
+			"Some mailboxes are not monitored: their poller is wedged or every poll fails"},
+		{AlertProviderErrorRate, "warning", fmt.Sprintf("%.0f%% of polled users failing", c.ProviderErrorRate*100),
+			"Polls are failing for a share of the mailboxes"},
+		{AlertQueueLag, "warning", fmt.Sprintf("p95 ingest latency over %v", c.QueueLag),
+			"Emails reach the analysis queue late"},
+		{AlertShutdownNotGraceful, "warning", fmt.Sprintf("a shutdown timed out in the last %v", c.ShutdownWindow),
+			"An instance stopped before its in-flight emails were processed"},
+	}
+}
+
+// evaluateAlerts returns the description of each firing rule
+func evaluateAlerts(in alertInput, c AlertConfig) map[string]string {
+	firing := map[string]string{}
+
+	if len(in.pollers) > 0 && !in.pollingPaused {
+		// The most recent successful poll; a poller that never polled counts from its start
+		var latest time.Time
+		for _, p := range in.pollers {
+			at := p.lastPoll
+			if at.IsZero() {
+				at = p.startedAt
+			}
+			if at.After(latest) {
+				latest = at
+			}
+		}
+		if stalled := in.now.Sub(latest); stalled > c.IngestStalledAfter {
+			firing[AlertIngestStalled] = fmt.Sprintf("%d users polled, none successfully for %v", len(in.pollers), stalled.Round(time.Second))
+		}
+	}
+
+	if sla := in.mailboxSLA; sla.Breaching > 0 && !in.pollingPaused {
+		worst := sla.Breaches[0]
+		firing[AlertMailboxSLA] = fmt.Sprintf("%d of %d mailboxes not polled successfully within %v, longest %s for %v",
+			sla.Breaching, sla.Mailboxes, c.MailboxSLA, worst.UserID, time.Duration(worst.SinceSeconds)*time.Second)
+	}
+
+	if len(in.pollers) > 0 && in.failing > 0 {
+		if rate := float64(in.failing) / float64(len(in.pollers)); rate >= c.ProviderErrorRate {
+			firing[AlertProviderErrorRate] = fmt.Sprintf("last poll failed for %d of %d users (%.0f%%)", in.failing, len(in.pollers), rate*100)
+		}
+	}
+
+	if in.queueSampled && in.queueP95 > c.QueueLag {
+		firing[AlertQueueLag] = fmt.Sprintf("p95 ingest latency %v exceeds %v", in.queueP95.Round(time.Millisecond), c.QueueLag)
+	}
+
+	if in.uncleanShutdown > 0 {
+		firing[AlertShutdownNotGraceful] = fmt.Sprintf("%d shutdowns timed out in the last %v", in.uncleanShutdown, c.ShutdownWindow)
+	}
+	return firing
+}
+
+// alertEvaluator evaluates the built-in rules every interval and keeps the alerts for the API
+type alertEvaluator struct {
+	s      *Service
+	config AlertConfig
+
+	mu     sync.Mutex
+	alerts map[string]*Alert // By rule name, firing or resolved within alertResolvedRetention
+}
+
+func newAlertConfig(ingestSLO time.Duration) AlertConfig {
+	config := AlertConfig{
+		Interval:           viper.GetDuration("alerts.interval"),
+		IngestStalledAfter: viper.GetDuration("alerts.ingest_stalled_after"),
+		MailboxSLA:         viper.GetDuration("alerts.mailbox_sla"),
+		ProviderErrorRate:  viper.GetFloat64("alerts.provider_error_rate"),
+		QueueLag:           viper.GetDuration("alerts.queue_lag"),
+		ShutdownWindow:     viper.GetDuration("alerts.shutdown_window"),
+	}
+	if config.Interval <= 0 {
+		config.Interval = DefaultAlertInterval
+	}
+	if config.IngestStalledAfter <= 0 {
+		config.IngestStalledAfter = DefaultAlertIngestStalledAfter
+	}
+	if config.MailboxSLA <= 0 {
+		config.MailboxSLA = DefaultAlertMailboxSLA
+	}
+	if config.ProviderErrorRate <= 0 || config.ProviderErrorRate > 1 {
+		config.ProviderErrorRate = DefaultAlertProviderErrorRate
+	}
+	if config.QueueLag <= 0 {
+		config.QueueLag = ingestSLO
+	}
+	if config.ShutdownWindow <= 0 {
+		config.ShutdownWindow = DefaultAlertShutdownWindow
+	}
+	return config
+}
+
+func newAlertEvaluator(s *Service, config AlertConfig) *alertEvaluator {
+	return &alertEvaluator{s: s, config: config, alerts: make(map[string]*Alert)}
+}
+
+// run evaluates the rules every interval until ctx is done
+func (e *alertEvaluator) run(ctx context.Context) {
+	ticker := time.NewTicker(e.config.Interval)
+	defer ticker.Stop()
+
+	for {
+		if err := e.evaluate(ctx); err != nil && ctx.Err() == nil {
+			log.Printf("Error evaluating alerts: %v", err)
+		}
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+	}
+}
+
+// evaluate snapshots the metric sources, evaluates the rules and updates the alerts
+func (e *alertEvaluator) evaluate(ctx context.Context) error {
+	s := e.s
+	in := alertInput{
+		now:           s.clk().Now(),
+		pollers:       s.pollerStates(),
+		pollingPaused: s.maintenance.paused(),
+	}
+	for id := range in.pollers {
+		if _, failed := s.pollFailures.Load(id); failed {
+			in.failing++
+		}
+	}
+	in.mailboxSLA = s.mailboxSLA(in.now)
+	if stats, ok := statsFor(s.latency.queue); ok {
+		in.queueP95, in.queueSampled = stats.P95, true
+	}
+	if err := db.ReadPool.QueryRow(ctx,
+		`SELECT COUNT(*) FROM events WHERE type = $1 AND at > $2 AND data->>'graceful' = 'false'`,
+		events.TypeServiceStopped, in.now.Add(-e.config.ShutdownWindow),
+	).Scan(&in.uncleanShutdown); err != nil {
+		return fmt.Errorf("failed to count shutdowns: %w", err)
+	}
+
+	e.update(in.now, evaluateAlerts(in, e.config))
+	return nil
+}
+
+// update starts, refreshes and resolves alerts from an evaluation's firing rules
+func (e *alertEvaluator) update(now time.Time, firing map[string]string) {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+
+	for _, rule := range e.config.rules() {
+		alert, known := e.alerts[rule.Name]
+		description, fires := firing[rule.Name]
+		switch {
+		case fires && (!known || alert.EndsAt != nil):
+			alert = &Alert{
+				Labels: map[string]string{
+					"alertname": rule.Name,
+					"severity":  rule.Severity,
+					"service":   "discovery",
+					"tenant_id": e.s.tenantID.String(),
+				},
+				Annotations: map[string]string{"summary": rule.Summary, "description": description},
+				StartsAt:    now,
+			}
+			e.alerts[rule.Name] = alert
+			log.Printf("🚨 Alert firing | %s (%s) | tenant=%s | %s", rule.Name, rule.Severity, e.s.tenantID, description)
+		case fires:
+			alert.Annotations["description"] = description
+		case known && alert.EndsAt == nil:
+			alert.EndsAt = &now
+			log.Printf("✓ Alert resolved | %s | tenant=%s | fired for %v", rule.Name, e.s.tenantID, now.Sub(alert.StartsAt).Round(time.Second))
+		case known && now.Sub(*alert.EndsAt) > alertResolvedRetention:
+			delete(e.alerts, rule.Name)
+		}
+	}
+}
+
+// Alerts returns the firing alerts and those resolved in the last 15 minutes, oldest first
+func (s *Service) Alerts() []Alert {
+	e := s.alerts
+	if e == nil {
+		return []Alert{}
+	}
+	e.mu.Lock()
+	defer e.mu.Unlock()
+
+	alerts := make([]Alert, 0, len(e.alerts))
+	for _, alert := range e.alerts {
+		a := *alert
+		a.Annotations = map[string]string{"summary": alert.Annotations["summary"], "description": alert.Annotations["description"]}
+		alerts = append(alerts, a)
+	}
+	sort.Slice(alerts, func(i, j int) bool { return alerts[i].StartsAt.Before(alerts[j].StartsAt) })
+	return alerts
+}
+
+// AlertRules returns the built-in alert rules and their configured thresholds
+func (s *Service) AlertRules() []AlertRule {
+	if s.alerts == nil {
+		return []AlertRule{}
+	}
+	return s.alerts.config.rules()
+}
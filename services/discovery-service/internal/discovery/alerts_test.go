@@ -0,0 +1,85 @@
+package discovery
+
+import (
+	"testing"
+	"time"
+
+	"github.com/google/uuid"
+)
+
+func TestEvaluateAlerts(t *testing.T) {
+	now := time.Date(2024, 3, 1, 12, 0, 0, 0, time.UTC)
+	config := AlertConfig{IngestStalledAfter: 10 * time.Minute, MailboxSLA: 20 * time.Minute, ProviderErrorRate: 0.25, QueueLag: 2 * time.Minute, ShutdownWindow: 24 * time.Hour}
+	pollers := func(states ...pollerState) map[uuid.UUID]pollerState {
+		m := make(map[uuid.UUID]pollerState)
+		for _, state := range states {
+			m[uuid.New()] = state
+		}
+		return m
+	}
+	started := now.Add(-time.Hour)
+
+	tests := []struct {
+		name string
+		in   alertInput
+		want []string
+	}{
+		{"healthy", alertInput{pollers: pollers(pollerState{started, now.Add(-time.Minute)}, pollerState{started, now.Add(-20 * time.Minute)})}, nil},
+		{"no users", alertInput{}, nil},
+		{"stalled", alertInput{pollers: pollers(pollerState{started, now.Add(-11 * time.Minute)}, pollerState{started, time.Time{}})},
+			[]string{AlertIngestStalled}},
+		{"new poller not polled yet", alertInput{pollers: pollers(pollerState{now.Add(-time.Minute), time.Time{}})}, nil},
+		{"stalled during a maintenance pause", alertInput{pollers: pollers(pollerState{started, time.Time{}}), pollingPaused: true}, nil},
+		{"provider errors", alertInput{pollers: pollers(pollerState{started, now}, pollerState{started, now}, pollerState{started, now}, pollerState{started, now}), failing: 1},
+			[]string{AlertProviderErrorRate}},
+		{"provider errors under the rate", alertInput{pollers: pollers(pollerState{started, now}, pollerState{started, now}, pollerState{started, now}, pollerState{started, now}, pollerState{started, now}), failing: 1}, nil},
+		{"mailbox past its SLA", alertInput{mailboxSLA: MailboxSLA{Mailboxes: 2, Breaching: 1, Breaches: []SLABreach{{UserID: uuid.New(), SinceSeconds: 1800}}}},
+			[]string{AlertMailboxSLA}},
+		{"mailbox past its SLA during a maintenance pause", alertInput{mailboxSLA: MailboxSLA{Mailboxes: 2, Breaching: 1, Breaches: []SLABreach{{UserID: uuid.New(), SinceSeconds: 1800}}}, pollingPaused: true}, nil},
+		{"queue lag", alertInput{queueP95: 3 * time.Minute, queueSampled: true}, []string{AlertQueueLag}},
+		{"unclean shutdown", alertInput{uncleanShutdown: 1}, []string{AlertShutdownNotGraceful}},
+	}
+	for _, tt := range tests {
+		tt.in.now = now
+		firing := evaluateAlerts(tt.in, config)
+		if len(firing) != len(tt.want) {
+			t.Errorf("%s: firing %v, want %v", tt.name, firing, tt.want)
+			continue
+		}
+		for _, name := range tt.want {
+			if _, ok := firing[name]; !ok {
+				t.Errorf("%s: firing %v, want %v", tt.name, firing, tt.want)
+			}
+		}
+	}
+}
+
+func TestAlertLifecycle(t *testing.T) {
+	s := &Service{tenantID: uuid.New()}
+	s.alerts = newAlertEvaluator(s, AlertConfig{QueueLag: time.Minute})
+	start := time.Date(2024, 3, 1, 12, 0, 0, 0, time.UTC)
+
+	s.alerts.update(start, map[string]string{AlertQueueLag: "p95 3m"})
+	s.alerts.update(start.Add(time.Minute), map[string]string{AlertQueueLag: "p95 4m"})
+	alerts := s.Alerts()
+	if len(alerts) != 1 || !alerts[0].StartsAt.Equal(start) || alerts[0].EndsAt != nil ||
+		alerts[0].Labels["alertname"] != AlertQueueLag || alerts[0].Annotations["description"] != "p95 4m" {
+		t.Fatalf("firing = %+v", alerts)
+	}
+
+	resolved := start.Add(2 * time.Minute)
+	s.alerts.update(resolved, nil)
+	if alerts := s.Alerts(); len(alerts) != 1 || alerts[0].EndsAt == nil || !alerts[0].EndsAt.Equal(resolved) {
+		t.Fatalf("resolved = %+v", alerts)
+	}
+	// Firing again starts a new alert
+	s.alerts.update(resolved.Add(time.Minute), map[string]string{AlertQueueLag: "p95 3m"})
+	if alerts := s.Alerts(); len(alerts) != 1 || alerts[0].EndsAt != nil || !alerts[0].StartsAt.Equal(resolved.Add(time.Minute)) {
+		t.Fatalf("refired = %+v", alerts)
+	}
+	s.alerts.update(resolved.Add(2*time.Minute), nil)
+	s.alerts.update(resolved.Add(20*time.Minute), nil)
+	if alerts := s.Alerts(); len(alerts) != 0 {
+		t.Errorf("resolved 18 minutes ago = %+v, want dropped", alerts)
+	}
+}
@@ -0,0 +1,116 @@
+package discovery
+
+import (
+	"context"
+	"log"
+	"sort"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/stoik/vigil/internal/models"
+	"github.com/stoik/vigil/services/discovery-service/internal/events"
+	discoverymodels "github.com/stoik/vigil/services/discovery-service/internal/models"
+)
+
+const (
+	DefaultMaxEmailsPerPoll = 5000    // Emails a single poll may hand to processing
+	DefaultBackfillBatch    = 1000    // Backfilled emails released per polling interval
+	MaxBackfillEmails       = 100_000 // Emails held per user; the rest are polled again after the backfill
+)
+
+// Backfill is a user's backlog from a capped poll, released in batches
+type Backfill struct {
+	UserID    uuid.UUID `json:"user_id"`
+	Polled    int       `json:"polled"`    // Emails returned by the capped poll
+	Remaining int       `json:"remaining"` // Emails still held
+	// Emails beyond MaxBackfillEmails, not held: the cursor stays before them and the poll after
+	// the backfill returns them
+	Truncated bool      `json:"truncated,omitempty"`
+	Since     time.Time `json:"since"`
+}
+
+// backfill holds the emails of a capped poll that were not handed to processing
+type backfill struct {
+	mu     sync.Mutex
+	emails []models.ProviderEmail
+	info   Backfill
+}
+
+// capPoll returns the emails of a poll that are processed now
+//
+// A poll returning more than maxEmailsPerPoll emails (a cursor reset, a provider bug, a mail
+// bomb) raises an alert (a 🚨 log line and a poll.capped event): only the oldest
+// maxEmailsPerPoll are processed now, and the rest is held as a backfill, released
+// backfillBatch emails per polling interval instead of the user's polls. Cursors advance as
+// emails are ingested in received order, so nothing is skipped, and a restart polls the backlog
+// again from the cursor.
+func (s *Service) capPoll(ctx context.Context, user discoverymodels.User, emails []models.ProviderEmail) []models.ProviderEmail {
+	if s.maxEmailsPerPoll <= 0 || len(emails) <= s.maxEmailsPerPoll {
+		return emails
+	}
+	now, b := splitPoll(user.ID, emails, s.maxEmailsPerPoll)
+	s.backfills.Store(user.ID, b)
+
+	atomic.AddInt64(&s.pollsCapped, 1)
+	log.Printf("🚨 Poll result explosion | user %s: %d emails in one poll (cap %d), backfilling %d at %d per %v",
+		user.ID, len(emails), s.maxEmailsPerPoll, len(b.emails), s.backfillBatch, PollingInterval)
+	data := map[string]any{"email": user.Email, "polled": len(emails), "cap": s.maxEmailsPerPoll, "backfill": len(b.emails)}
+	if err := events.Record(ctx, events.TypePollCapped, &user.ID, nil, data); err != nil {
+		log.Printf("Error recording %s event for user %s: %v", events.TypePollCapped, user.ID, err)
+	}
+	return now
+}
+
+// splitPoll sorts a poll's emails by received time and splits them into the oldest max and a
+// backfill of the rest
+func splitPoll(userID uuid.UUID, emails []models.ProviderEmail, max int) ([]models.ProviderEmail, *backfill) {
+	sort.SliceStable(emails, func(a, b int) bool { return emails[a].ReceivedAt.Before(emails[b].ReceivedAt) })
+
+	rest := emails[max:]
+	b := &backfill{info: Backfill{UserID: userID, Polled: len(emails), Since: time.Now()}}
+	if len(rest) > MaxBackfillEmails {
+		rest, b.info.Truncated = rest[:MaxBackfillEmails], true
+	}
+	// Copied so the poll's full result can be released
+	b.emails = append([]models.ProviderEmail(nil), rest...)
+	b.info.Remaining = len(b.emails)
+	return emails[:max], b
+}
+
+// nextBackfillBatch takes the next batch of a user's backfill
+// Returns false if the user has no backfill, so the user is polled
+func (s *Service) nextBackfillBatch(userID uuid.UUID) ([]models.ProviderEmail, bool) {
+	value, ok := s.backfills.Load(userID)
+	if !ok {
+		return nil, false
+	}
+	b := value.(*backfill)
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	n := min(s.backfillBatch, len(b.emails))
+	batch := b.emails[:n]
+	b.emails = b.emails[n:]
+	b.info.Remaining = len(b.emails)
+	if len(b.emails) == 0 {
+		s.backfills.Delete(userID)
+		log.Printf("✓ Backfill of user %s released after %v | %d emails, polling resumes", userID, time.Since(b.info.Since).Round(time.Second), b.info.Polled)
+	}
+	return batch, true
+}
+
+// Backfills returns the users whose capped poll is being backfilled
+func (s *Service) Backfills() []Backfill {
+	backfills := []Backfill{}
+	s.backfills.Range(func(_, value any) bool {
+		b := value.(*backfill)
+		b.mu.Lock()
+		backfills = append(backfills, b.info)
+		b.mu.Unlock()
+		return true
+	})
+	sort.Slice(backfills, func(a, b int) bool { return backfills[a].Since.Before(backfills[b].Since) })
+	return backfills
+}
@@ -0,0 +1,53 @@
+package discovery
+
+import (
+	"fmt"
+	"testing"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/stoik/vigil/internal/models"
+)
+
+func TestPollCapBackfill(t *testing.T) {
+	base := time.Date(2024, 3, 1, 12, 0, 0, 0, time.UTC)
+	userID := uuid.New()
+	var emails []models.ProviderEmail
+	for i := 9; i >= 0; i-- { // Out of order
+		emails = append(emails, models.ProviderEmail{MessageID: fmt.Sprintf("m%d", i), ReceivedAt: base.Add(time.Duration(i) * time.Minute)})
+	}
+
+	now, b := splitPoll(userID, emails, 4)
+	if len(now) != 4 || now[0].MessageID != "m0" || now[3].MessageID != "m3" {
+		t.Fatalf("processed now = %v, want the 4 oldest", now)
+	}
+	if b.info.Polled != 10 || b.info.Remaining != 6 || b.info.Truncated {
+		t.Fatalf("backfill = %+v, want 6 of 10 held", b.info)
+	}
+
+	// Released in batches instead of polls, in received order, then polling resumes
+	s := &Service{backfillBatch: 4}
+	s.backfills.Store(userID, b)
+	var released []string
+	for {
+		batch, ok := s.nextBackfillBatch(userID)
+		if !ok {
+			break
+		}
+		if len(batch) > s.backfillBatch {
+			t.Fatalf("batch of %d, want at most %d", len(batch), s.backfillBatch)
+		}
+		for _, e := range batch {
+			released = append(released, e.MessageID)
+		}
+		if got := s.Backfills(); len(released) < 6 && (len(got) != 1 || got[0].Remaining != 6-len(released)) {
+			t.Errorf("Backfills() = %+v after %d released", got, len(released))
+		}
+	}
+	if fmt.Sprint(released) != "[m4 m5 m6 m7 m8 m9]" {
+		t.Errorf("released %v, want m4..m9 in order", released)
+	}
+	if got := s.Backfills(); len(got) != 0 {
+		t.Errorf("Backfills() = %+v once released, want none", got)
+	}
+}
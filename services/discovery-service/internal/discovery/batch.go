@@ -0,0 +1,359 @@
+package discovery
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"strconv"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/klauspost/compress/zstd"
+	"github.com/spf13/viper"
+)
+
+const (
+	DefaultBatchSize   = 100
+	DefaultBatchLinger = 50 * time.Millisecond
+	batchFlushTimeout  = 10 * time.Second // Final flush at shutdown
+
+	CompressionNone = "none"
+	CompressionZstd = "zstd"
+)
+
+// batchSizeBuckets are the upper bounds of the batch size histogram
+var batchSizeBuckets = []int{1, 10, 50, 100, 500, 1000}
+
+// Batch is a set of analysis messages published in one broker request
+type Batch struct {
+	Messages []AnalysisMessage
+	Encoding string // Content encoding of Payload: "" or CompressionZstd
+	Payload  []byte // The messages as JSON lines, compressed with Encoding
+}
+
+// BatchPublisher is implemented by brokers that take several messages in one request
+type BatchPublisher interface {
+	PublishBatch(ctx context.Context, batch Batch) error
+}
+
+func (discardPublisher) PublishBatch(context.Context, Batch) error { return nil }
+
+// batchConfig tunes publish batching (queue.batch_size, queue.batch_linger, queue.compression)
+type batchConfig struct {
+	size        int           // Messages per batch; 1 publishes each message on its own
+	linger      time.Duration // Longest a message waits for its batch to fill
+	compression string
+}
+
+func newBatchConfig() (batchConfig, error) {
+	cfg := batchConfig{
+		size:        viper.GetInt("queue.batch_size"),
+		linger:      viper.GetDuration("queue.batch_linger"),
+		compression: viper.GetString("queue.compression"),
+	}
+	if cfg.size <= 0 {
+		cfg.size = DefaultBatchSize
+	}
+	if cfg.linger <= 0 {
+		cfg.linger = DefaultBatchLinger
+	}
+	switch cfg.compression {
+	case "":
+		cfg.compression = CompressionNone
+	case CompressionNone, CompressionZstd:
+	default:
+		return cfg, fmt.Errorf("unknown queue.compression %q (use %s or %s)", cfg.compression, CompressionNone, CompressionZstd)
+	}
+	return cfg, nil
+}
+
+// batchPublisher collects analysis messages into batches of up to size messages, published when
+// full, once every ingest that could still add a message is waiting on the batch, or once the
+// first message has waited linger, whichever comes first
+//
+// Publish returns once the message's batch is published, with the batch's error: an email is
+// only acknowledged (cursor advanced, journal entry removed) after its message reached the
+// broker, so a crash while a batch fills loses nothing. Concurrent ingests share batches.
+// Batches are published one at a time, in order.
+//
+// Only ingests holding a processing slot publish, and each user's emails are ingested one after
+// the other, so a batch holds at most one email per user and never more than the slots in use.
+// Waiting for the batch to fill beyond that would hold every slot for the linger: a user's
+// backlog of 1000 emails would spend 50s waiting alone. The batch is published as soon as every
+// slot holder is waiting on it, so linger only applies while other ingests are still storing
+// their email, and batch_size only caps batches when more slots than that are in use.
+type batchPublisher struct {
+	sink    BatchPublisher
+	cfg     batchConfig
+	encoder *zstd.Encoder // nil without compression
+	// publishers returns the ingests that may still add a message to the pending batch (the
+	// tenant's processing slots in use); nil publishes on size and linger only
+	publishers func() int
+
+	mu      sync.Mutex
+	pending *pendingBatch // nil until a message arrives
+	closed  bool          // Final flush done: messages are published on their own
+	wake    chan struct{} // Signalled when a batch starts filling
+
+	publishMu sync.Mutex // Serializes batches
+	counters  batchCounters
+}
+
+// batchCounters are the producer-side counters behind QueueStats
+type batchCounters struct {
+	batches, messages, full, idle int64
+	failedBatches, failedMessages int64
+	maxSize                       int64
+	bytes, compressedBytes        int64
+	sizes                         [7]int64 // Per batchSizeBuckets, then larger batches
+}
+
+// pendingBatch is a batch being filled, whose publishers wait for done
+type pendingBatch struct {
+	msgs []AnalysisMessage
+	done chan struct{} // Closed once published
+	err  error         // Publishing error, set before done is closed
+}
+
+func newBatchPublisher(sink BatchPublisher, cfg batchConfig) (*batchPublisher, error) {
+	b := &batchPublisher{sink: sink, cfg: cfg, wake: make(chan struct{}, 1)}
+	if cfg.compression == CompressionZstd {
+		encoder, err := zstd.NewWriter(nil, zstd.WithEncoderLevel(zstd.SpeedDefault))
+		if err != nil {
+			return nil, fmt.Errorf("failed to create zstd encoder: %w", err)
+		}
+		b.encoder = encoder
+	}
+	return b, nil
+}
+
+// Publish adds msg to the pending batch and waits until that batch is published
+// A full batch is published by the caller that filled it, which gives backpressure; it is
+// published without that caller's cancellation, since the batch carries other callers' emails
+func (b *batchPublisher) Publish(ctx context.Context, msg AnalysisMessage) error {
+	b.mu.Lock()
+	if b.closed {
+		b.mu.Unlock()
+		return b.publish(ctx, []AnalysisMessage{msg})
+	}
+	if b.pending == nil {
+		b.pending = &pendingBatch{done: make(chan struct{})}
+		select {
+		case b.wake <- struct{}{}:
+		default:
+		}
+	}
+	batch := b.pending
+	batch.msgs = append(batch.msgs, msg)
+	full := len(batch.msgs) >= b.cfg.size
+	idle := !full && b.idleLocked(batch)
+	if full || idle {
+		b.pending = nil
+	}
+	b.mu.Unlock()
+
+	switch {
+	case full:
+		atomic.AddInt64(&b.counters.full, 1)
+		b.complete(context.WithoutCancel(ctx), batch)
+	case idle:
+		atomic.AddInt64(&b.counters.idle, 1)
+		b.complete(context.WithoutCancel(ctx), batch)
+	}
+	// On ctx, the batch is still published by run or the final flush; the email is not
+	// acknowledged and is published again after a restart, deduplicated downstream
+	select {
+	case <-batch.done:
+		return batch.err
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
+
+// idleLocked reports whether every ingest that could add to batch is waiting on it; b.mu must be held
+func (b *batchPublisher) idleLocked(batch *pendingBatch) bool {
+	return b.publishers != nil && len(batch.msgs) >= b.publishers()
+}
+
+// settle publishes the pending batch if every ingest still holding a processing slot is waiting
+// on it; called once a slot is released, since that ingest may have finished without publishing
+func (b *batchPublisher) settle(ctx context.Context) {
+	b.mu.Lock()
+	batch := b.pending
+	if batch == nil || !b.idleLocked(batch) {
+		b.mu.Unlock()
+		return
+	}
+	b.pending = nil
+	b.mu.Unlock()
+	atomic.AddInt64(&b.counters.idle, 1)
+	b.complete(context.WithoutCancel(ctx), batch)
+}
+
+// complete publishes a batch and releases its publishers
+func (b *batchPublisher) complete(ctx context.Context, batch *pendingBatch) {
+	batch.err = b.publish(ctx, batch.msgs)
+	close(batch.done)
+}
+
+// run publishes batches that have waited linger, and what is pending once ctx is done
+func (b *batchPublisher) run(ctx context.Context) {
+	for {
+		select {
+		case <-ctx.Done():
+			b.flush()
+			return
+		case <-b.wake:
+		}
+
+		timer := time.NewTimer(b.cfg.linger)
+		select {
+		case <-ctx.Done():
+			timer.Stop()
+			b.flush()
+			return
+		case <-timer.C:
+		}
+		b.mu.Lock()
+		batch := b.pending
+		b.pending = nil
+		b.mu.Unlock()
+		if batch != nil {
+			b.complete(ctx, batch)
+		}
+	}
+}
+
+// flush publishes the pending batch on a context of its own (the service's is done); later
+// messages are published on their own
+func (b *batchPublisher) flush() {
+	b.mu.Lock()
+	batch := b.pending
+	b.pending = nil
+	b.closed = true
+	b.mu.Unlock()
+	if batch == nil {
+		return
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), batchFlushTimeout)
+	defer cancel()
+	b.complete(ctx, batch)
+}
+
+// publish encodes and publishes a batch
+func (b *batchPublisher) publish(ctx context.Context, msgs []AnalysisMessage) error {
+	b.publishMu.Lock()
+	defer b.publishMu.Unlock()
+
+	batch, err := b.encode(msgs)
+	if err == nil {
+		err = b.sink.PublishBatch(ctx, batch)
+	}
+	b.count(len(msgs), err)
+	return err
+}
+
+// encode serializes messages as JSON lines, compressed if configured
+func (b *batchPublisher) encode(msgs []AnalysisMessage) (Batch, error) {
+	var buf bytes.Buffer
+	enc := json.NewEncoder(&buf)
+	for _, msg := range msgs {
+		if err := enc.Encode(msg); err != nil {
+			return Batch{Messages: msgs}, fmt.Errorf("failed to encode analysis message %s: %w", msg.MessageID, err)
+		}
+	}
+	batch := Batch{Messages: msgs, Payload: buf.Bytes()}
+	atomic.AddInt64(&b.counters.bytes, int64(buf.Len()))
+	if b.encoder != nil {
+		batch.Encoding = CompressionZstd
+		batch.Payload = b.encoder.EncodeAll(buf.Bytes(), make([]byte, 0, buf.Len()/2))
+	}
+	atomic.AddInt64(&b.counters.compressedBytes, int64(len(batch.Payload)))
+	return batch, nil
+}
+
+func (b *batchPublisher) count(n int, err error) {
+	c := &b.counters
+	atomic.AddInt64(&c.batches, 1)
+	atomic.AddInt64(&c.messages, int64(n))
+	if err != nil {
+		atomic.AddInt64(&c.failedBatches, 1)
+		atomic.AddInt64(&c.failedMessages, int64(n))
+	}
+	for {
+		largest := atomic.LoadInt64(&c.maxSize)
+		if int64(n) <= largest || atomic.CompareAndSwapInt64(&c.maxSize, largest, int64(n)) {
+			break
+		}
+	}
+	bucket := len(batchSizeBuckets)
+	for i, le := range batchSizeBuckets {
+		if n <= le {
+			bucket = i
+			break
+		}
+	}
+	atomic.AddInt64(&c.sizes[bucket], 1)
+}
+
+// QueueStats measures analysis queue batches since start (producer side)
+type QueueStats struct {
+	BatchSize      int     `json:"batch_size"` // queue.batch_size
+	Compression    string  `json:"compression"`
+	Batches        int64   `json:"batches"`
+	Messages       int64   `json:"messages"`
+	FullBatches    int64   `json:"full_batches"` // Published at batch_size
+	IdleBatches    int64   `json:"idle_batches"` // Published once every ingest in flight waited on them; the others after batch_linger
+	FailedBatches  int64   `json:"failed_batches"`
+	FailedMessages int64   `json:"failed_messages"`
+	MeanBatchSize  float64 `json:"mean_batch_size"`
+	MaxBatchSize   int64   `json:"max_batch_size"`
+	// Batches per size, keyed by the bucket's upper bound ("+Inf" for the largest)
+	BatchSizes map[string]int64 `json:"batch_sizes"`
+	// Payload bytes: encoded JSON, then as published; ratio = published / encoded
+	Bytes            int64   `json:"bytes"`
+	PublishedBytes   int64   `json:"published_bytes"`
+	CompressionRatio float64 `json:"compression_ratio"`
+}
+
+// queueStats returns the analysis queue batching counters
+func (s *Service) queueStats() QueueStats {
+	if s.batcher == nil {
+		return QueueStats{BatchSize: 1, Compression: CompressionNone}
+	}
+	return s.batcher.stats()
+}
+
+func (b *batchPublisher) stats() QueueStats {
+	c := &b.counters
+	s := QueueStats{
+		BatchSize:      b.cfg.size,
+		Compression:    b.cfg.compression,
+		Batches:        atomic.LoadInt64(&c.batches),
+		Messages:       atomic.LoadInt64(&c.messages),
+		FullBatches:    atomic.LoadInt64(&c.full),
+		IdleBatches:    atomic.LoadInt64(&c.idle),
+		FailedBatches:  atomic.LoadInt64(&c.failedBatches),
+		FailedMessages: atomic.LoadInt64(&c.failedMessages),
+		MaxBatchSize:   atomic.LoadInt64(&c.maxSize),
+		Bytes:          atomic.LoadInt64(&c.bytes),
+		PublishedBytes: atomic.LoadInt64(&c.compressedBytes),
+		BatchSizes:     make(map[string]int64, len(c.sizes)),
+	}
+	for i := range c.sizes {
+		le := "+Inf"
+		if i < len(batchSizeBuckets) {
+			le = strconv.Itoa(batchSizeBuckets[i])
+		}
+		s.BatchSizes[le] = atomic.LoadInt64(&c.sizes[i])
+	}
+	if s.Batches > 0 {
+		s.MeanBatchSize = float64(s.Messages) / float64(s.Batches)
+	}
+	if s.Bytes > 0 {
+		s.CompressionRatio = float64(s.PublishedBytes) / float64(s.Bytes)
+	}
+	return s
+}
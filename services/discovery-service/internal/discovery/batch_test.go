@@ -0,0 +1,348 @@
+package discovery
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/klauspost/compress/zstd"
+	"github.com/spf13/viper"
+)
+
+// batchSink records published batches and fails while err is set, or on a cancelled ctx
+type batchSink struct {
+	mu      sync.Mutex
+	batches []Batch
+	err     error
+}
+
+func (p *batchSink) PublishBatch(ctx context.Context, batch Batch) error {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	if p.err != nil {
+		return p.err
+	}
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+	p.batches = append(p.batches, batch)
+	return nil
+}
+
+func (p *batchSink) sizes() []int {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	sizes := make([]int, len(p.batches))
+	for i, b := range p.batches {
+		sizes[i] = len(b.Messages)
+	}
+	return sizes
+}
+
+// decodeBatch reads a batch payload back into messages
+func decodeBatch(t *testing.T, batch Batch) []AnalysisMessage {
+	t.Helper()
+	payload := batch.Payload
+	if batch.Encoding == CompressionZstd {
+		dec, err := zstd.NewReader(nil)
+		if err != nil {
+			t.Fatalf("zstd.NewReader: %v", err)
+		}
+		defer dec.Close()
+		if payload, err = dec.DecodeAll(payload, nil); err != nil {
+			t.Fatalf("DecodeAll: %v", err)
+		}
+	}
+	var msgs []AnalysisMessage
+	scanner := bufio.NewScanner(bytes.NewReader(payload))
+	for scanner.Scan() {
+		var msg AnalysisMessage
+		if err := json.Unmarshal(scanner.Bytes(), &msg); err != nil {
+			t.Fatalf("batch line: %v", err)
+		}
+		msgs = append(msgs, msg)
+	}
+	return msgs
+}
+
+func newTestBatcher(t *testing.T, sink BatchPublisher, cfg batchConfig) *batchPublisher {
+	t.Helper()
+	b, err := newBatchPublisher(sink, cfg)
+	if err != nil {
+		t.Fatalf("newBatchPublisher: %v", err)
+	}
+	return b
+}
+
+// publishAll publishes msgs concurrently, as ingests do, and returns Publish's errors once all returned
+func publishAll(ctx context.Context, p Publisher, msgs []AnalysisMessage) []error {
+	errs := make([]error, len(msgs))
+	var wg sync.WaitGroup
+	for i, msg := range msgs {
+		wg.Add(1)
+		go func(i int, msg AnalysisMessage) {
+			defer wg.Done()
+			errs[i] = p.Publish(ctx, msg)
+		}(i, msg)
+	}
+	wg.Wait()
+	return errs
+}
+
+func testMessages(n int, body string) []AnalysisMessage {
+	msgs := make([]AnalysisMessage, n)
+	for i := range msgs {
+		msgs[i] = AnalysisMessage{MessageID: uuid.NewString(), Body: body}
+	}
+	return msgs
+}
+
+func TestBatchPublisherSizeAndShutdown(t *testing.T) {
+	sink := &batchSink{}
+	b := newTestBatcher(t, sink, batchConfig{size: 3, linger: time.Hour, compression: CompressionNone})
+	ctx, cancel := context.WithCancel(context.Background())
+	done := make(chan struct{})
+	go func() {
+		b.run(ctx)
+		close(done)
+	}()
+
+	published := make(chan []error)
+	// Ingests outlive run here, so they see their batch published by the final flush
+	go func() { published <- publishAll(context.Background(), b, testMessages(7, "")) }()
+	deadline := time.Now().Add(2 * time.Second)
+	for len(sink.sizes()) < 2 && time.Now().Before(deadline) {
+		time.Sleep(time.Millisecond)
+	}
+	if got := sink.sizes(); len(got) != 2 || got[0] != 3 || got[1] != 3 {
+		t.Fatalf("batches = %v, want two full batches", got)
+	}
+	// The last message waits for its batch, published at shutdown
+	select {
+	case <-published:
+		t.Fatal("Publish returned before its batch was published")
+	case <-time.After(20 * time.Millisecond):
+	}
+	cancel()
+	<-done
+	for _, err := range <-published {
+		if err != nil {
+			t.Errorf("Publish: %v", err)
+		}
+	}
+	if got := sink.sizes(); len(got) != 3 || got[2] != 1 {
+		t.Fatalf("batches = %v, want the pending message flushed", got)
+	}
+	if msgs := decodeBatch(t, sink.batches[0]); len(msgs) != 3 || msgs[0].MessageID != sink.batches[0].Messages[0].MessageID {
+		t.Errorf("payload = %v, want the batch's messages as JSON lines", msgs)
+	}
+
+	// After the final flush, messages are published on their own
+	if err := b.Publish(context.Background(), AnalysisMessage{MessageID: uuid.NewString()}); err != nil {
+		t.Fatalf("Publish after shutdown: %v", err)
+	}
+	if got := sink.sizes(); len(got) != 4 || got[3] != 1 {
+		t.Errorf("batches = %v, want the late message published alone", got)
+	}
+
+	stats := b.stats()
+	if stats.Batches != 4 || stats.Messages != 8 || stats.FullBatches != 2 || stats.MaxBatchSize != 3 || stats.BatchSizes["10"] != 2 || stats.BatchSizes["1"] != 2 {
+		t.Errorf("stats = %+v", stats)
+	}
+}
+
+func TestBatchPublisherLinger(t *testing.T) {
+	sink := &batchSink{}
+	b := newTestBatcher(t, sink, batchConfig{size: 100, linger: 10 * time.Millisecond, compression: CompressionNone})
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	go b.run(ctx)
+
+	// Publish returns once the batch is published, not when the message is queued
+	for _, err := range publishAll(ctx, b, testMessages(2, "")) {
+		if err != nil {
+			t.Fatalf("Publish: %v", err)
+		}
+	}
+	if got := sink.sizes(); len(got) != 1 || got[0] != 2 {
+		t.Errorf("batches = %v, want one batch of 2 after the linger", got)
+	}
+}
+
+func TestBatchPublisherIdle(t *testing.T) {
+	sink := &batchSink{}
+	b := newTestBatcher(t, sink, batchConfig{size: 100, linger: time.Hour, compression: CompressionNone})
+	var inFlight int64 = 1
+	b.publishers = func() int { return int(atomic.LoadInt64(&inFlight)) }
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	go b.run(ctx)
+
+	// A lone ingest, such as one user's backlog, does not wait for the linger
+	for _, msg := range testMessages(3, "") {
+		if err := b.Publish(ctx, msg); err != nil {
+			t.Fatalf("Publish: %v", err)
+		}
+	}
+	// Two slots in use: the batch waits for the second ingest
+	atomic.StoreInt64(&inFlight, 2)
+	for _, err := range publishAll(ctx, b, testMessages(2, "")) {
+		if err != nil {
+			t.Fatalf("Publish: %v", err)
+		}
+	}
+	if got := sink.sizes(); len(got) != 4 || got[3] != 2 {
+		t.Fatalf("batches = %v, want 3 alone then 2 together", got)
+	}
+
+	// The other slot holder finishes without publishing: the batch is published on release
+	published := make(chan error, 1)
+	go func() { published <- b.Publish(ctx, AnalysisMessage{MessageID: uuid.NewString()}) }()
+	for deadline := time.Now().Add(2 * time.Second); time.Now().Before(deadline); time.Sleep(time.Millisecond) {
+		b.mu.Lock()
+		waiting := b.pending != nil
+		b.mu.Unlock()
+		if waiting {
+			break
+		}
+	}
+	atomic.StoreInt64(&inFlight, 1)
+	b.settle(ctx)
+	select {
+	case err := <-published:
+		if err != nil {
+			t.Fatalf("Publish: %v", err)
+		}
+	case <-time.After(2 * time.Second):
+		t.Fatal("batch not published once the other slot was released")
+	}
+	if stats := b.stats(); stats.Batches != 5 || stats.IdleBatches != 5 || stats.FullBatches != 0 {
+		t.Errorf("stats = %+v, want 5 idle batches", stats)
+	}
+}
+
+func TestBatchPublishReturnsOnCancel(t *testing.T) {
+	sink := &batchSink{}
+	b := newTestBatcher(t, sink, batchConfig{size: 100, linger: time.Hour, compression: CompressionNone})
+	ctx, cancel := context.WithCancel(context.Background())
+	published := make(chan error, 1)
+	go func() { published <- b.Publish(ctx, AnalysisMessage{MessageID: uuid.NewString()}) }()
+	cancel()
+	select {
+	case err := <-published:
+		if !errors.Is(err, context.Canceled) {
+			t.Fatalf("Publish = %v, want context.Canceled", err)
+		}
+	case <-time.After(2 * time.Second):
+		t.Fatal("Publish still waiting for its batch after ctx was cancelled")
+	}
+
+	// The message is still published with its batch
+	b.flush()
+	if got := sink.sizes(); len(got) != 1 || got[0] != 1 {
+		t.Errorf("batches = %v, want the abandoned message flushed", got)
+	}
+}
+
+func TestBatchFilledByCancelledCaller(t *testing.T) {
+	sink := &batchSink{}
+	b := newTestBatcher(t, sink, batchConfig{size: 2, linger: time.Hour, compression: CompressionNone})
+	waiting := make(chan error, 1)
+	go func() { waiting <- b.Publish(context.Background(), AnalysisMessage{MessageID: uuid.NewString()}) }()
+	for deadline := time.Now().Add(2 * time.Second); time.Now().Before(deadline); time.Sleep(time.Millisecond) {
+		b.mu.Lock()
+		queued := b.pending != nil
+		b.mu.Unlock()
+		if queued {
+			break
+		}
+	}
+
+	// The caller that fills the batch is cancelled: the other waiter's email is still published
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	b.Publish(ctx, AnalysisMessage{MessageID: uuid.NewString()})
+	select {
+	case err := <-waiting:
+		if err != nil {
+			t.Fatalf("Publish = %v, want the batch published despite the filling caller's ctx", err)
+		}
+	case <-time.After(2 * time.Second):
+		t.Fatal("batch not published")
+	}
+	if got := sink.sizes(); len(got) != 1 || got[0] != 2 {
+		t.Errorf("batches = %v, want one batch of 2", got)
+	}
+}
+
+func TestBatchPublisherZstd(t *testing.T) {
+	sink := &batchSink{}
+	b := newTestBatcher(t, sink, batchConfig{size: 50, linger: time.Hour, compression: CompressionZstd})
+	body := strings.Repeat("Please find attached the invoice for last month. ", 20)
+	publishAll(context.Background(), b, testMessages(50, body))
+	if len(sink.batches) != 1 || sink.batches[0].Encoding != CompressionZstd {
+		t.Fatalf("batches = %v, want one zstd batch", sink.sizes())
+	}
+	if msgs := decodeBatch(t, sink.batches[0]); len(msgs) != 50 || msgs[49].Body != body {
+		t.Errorf("decoded %d messages, want 50 with their bodies", len(msgs))
+	}
+	if stats := b.stats(); stats.CompressionRatio <= 0 || stats.CompressionRatio > 0.2 {
+		t.Errorf("compression ratio = %.2f, want repeated bodies to compress", stats.CompressionRatio)
+	}
+}
+
+func TestBatchFailureReleasesDedupClaims(t *testing.T) {
+	brokerErr := errors.New("broker unavailable")
+	sink := &batchSink{err: brokerErr}
+	b := newTestBatcher(t, sink, batchConfig{size: 2, linger: time.Hour, compression: CompressionNone})
+	dedup := newDedupPublisher(b, newMemDedupStore(), time.Hour, 10)
+
+	ctx := context.Background()
+	tenantID := uuid.New()
+	msgs := []AnalysisMessage{
+		{TenantID: tenantID, MessageID: "a", IdempotencyKey: "fp-a"},
+		{TenantID: tenantID, MessageID: "b", IdempotencyKey: "fp-b"},
+	}
+	// Every message of the failed batch gets the error, as a single failed publish would
+	for i, err := range publishAll(ctx, dedup, msgs) {
+		if !errors.Is(err, brokerErr) {
+			t.Fatalf("Publish %s = %v, want the batch's error", msgs[i].MessageID, err)
+		}
+	}
+	if b.stats().FailedBatches != 1 {
+		t.Fatalf("stats = %+v, want the failed batch counted", b.stats())
+	}
+
+	// The claims were released: the requeued messages are not dropped as replays
+	sink.mu.Lock()
+	sink.err = nil
+	sink.mu.Unlock()
+	for i, err := range publishAll(ctx, dedup, msgs) {
+		if err != nil {
+			t.Errorf("republish %s: %v", msgs[i].MessageID, err)
+		}
+	}
+	if got := sink.sizes(); len(got) != 1 || got[0] != 2 {
+		t.Errorf("batches = %v, want the requeued batch", got)
+	}
+}
+
+func TestBatchConfigRejectsUnknownCompression(t *testing.T) {
+	t.Cleanup(viper.Reset)
+	viper.Set("queue.compression", "gzip")
+	if _, err := newBatchConfig(); err == nil {
+		t.Error("newBatchConfig accepted queue.compression gzip")
+	}
+	viper.Set("queue.compression", CompressionZstd)
+	if cfg, err := newBatchConfig(); err != nil || cfg.compression != CompressionZstd {
+		t.Errorf("newBatchConfig = %+v, %v, want zstd", cfg, err)
+	}
+}
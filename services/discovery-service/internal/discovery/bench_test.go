@@ -0,0 +1,129 @@
+package discovery
+
+import (
+	"context"
+	"fmt"
+	"strings"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/stoik/vigil/internal/models"
+)
+
+func BenchmarkFanIn(b *testing.B) {
+	for _, numChannels := range []int{1000, 10000} {
+		b.Run(fmt.Sprintf("channels=%d", numChannels), func(b *testing.B) {
+			channels := make([]chan EmailWithUser, numChannels)
+			readOnly := make([]<-chan EmailWithUser, numChannels)
+			for i := range channels {
+				channels[i] = make(chan EmailWithUser, ChannelBufferSize)
+				readOnly[i] = channels[i]
+			}
+
+			// Spread b.N emails across all producers, as per-user pollers would
+			for i, ch := range channels {
+				n := b.N / numChannels
+				if i < b.N%numChannels {
+					n++
+				}
+				go func(ch chan<- EmailWithUser, n int) {
+					defer close(ch)
+					for j := 0; j < n; j++ {
+						ch <- EmailWithUser{}
+					}
+				}(ch, n)
+			}
+
+			ctx, cancel := context.WithCancel(context.Background())
+			defer cancel()
+			group := newFanInGroup(nil)
+
+			b.ResetTimer()
+			group.sync(ctx, readOnly)
+			for i := 0; i < b.N; i++ {
+				<-group.out
+			}
+		})
+	}
+}
+
+// BenchmarkFingerprint compares the throughput of the fingerprint algorithms (MB/s)
+func BenchmarkFingerprint(b *testing.B) {
+	for _, alg := range []FingerprintAlgorithm{FingerprintSHA256, FingerprintBLAKE3, FingerprintXXHash} {
+		for _, size := range []int{256, 4 << 10, 64 << 10, 1 << 20} {
+			body := strings.Repeat("x", size)
+			b.Run(fmt.Sprintf("alg=%s/body=%dB", alg, size), func(b *testing.B) {
+				b.SetBytes(int64(size))
+				b.ReportAllocs()
+				for i := 0; i < b.N; i++ {
+					alg.Fingerprint(body)
+				}
+			})
+		}
+	}
+}
+
+func BenchmarkFingerprintEmailSnippet(b *testing.B) {
+	email := models.ProviderEmail{
+		From:    "Billing <billing@example.com>",
+		Subject: "Invoice 2024-001",
+		Snippet: strings.Repeat("Please find attached the invoice. ", 5),
+		Headers: map[string][]string{
+			"Message-ID": {"<1@example.com>"},
+			"Date":       {"Mon, 1 Jan 2024 00:00:00 +0000"},
+			"References": {"<a@example.com>", "<b@example.com>"},
+		},
+	}
+	b.ReportAllocs()
+	for i := 0; i < b.N; i++ {
+		FingerprintEmail(email, BodyModeSnippet)
+	}
+}
+
+// BenchmarkStoreEmail measures storeEmail ops/sec against VIGIL_TEST_DATABASE_URL
+func BenchmarkStoreEmail(b *testing.B) {
+	ctx := setupTestDB(b)
+	users := insertTestUsers(b, ctx, 10)
+	s := &Service{}
+
+	b.Run("unique", func(b *testing.B) {
+		var seq int64
+		b.RunParallel(func(pb *testing.PB) {
+			for pb.Next() {
+				i := atomic.AddInt64(&seq, 1)
+				email := models.ProviderEmail{
+					MessageID:  uuid.NewString(),
+					Body:       fmt.Sprintf("unique body %d %s", i, uuid.NewString()),
+					ReceivedAt: time.Now(),
+				}
+				if _, err := s.storeEmail(ctx, email, Fingerprint(email.Body), users[i%int64(len(users))]); err != nil {
+					b.Fatal(err)
+				}
+			}
+		})
+	})
+
+	b.Run("duplicate", func(b *testing.B) {
+		// Every delivery hits the fingerprint dedup path
+		email := models.ProviderEmail{
+			MessageID:  uuid.NewString(),
+			Body:       "duplicate body " + uuid.NewString(),
+			ReceivedAt: time.Now(),
+		}
+		if _, err := s.storeEmail(ctx, email, Fingerprint(email.Body), users[0]); err != nil {
+			b.Fatal(err)
+		}
+
+		var seq int64
+		b.RunParallel(func(pb *testing.PB) {
+			for pb.Next() {
+				i := atomic.AddInt64(&seq, 1)
+				if _, err := s.storeEmail(ctx, email, Fingerprint(email.Body), users[i%int64(len(users))]); err != nil {
+					b.Fatal(err)
+				}
+			}
+		})
+	})
+}
@@ -0,0 +1,252 @@
+package discovery
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/jackc/pgx/v5"
+	"github.com/stoik/vigil/services/discovery-service/internal/db"
+	"github.com/stoik/vigil/services/discovery-service/internal/jobs"
+)
+
+var (
+	errJobUserNotFound = errors.New("user not found")
+	errUserPaused      = errors.New("user is paused")
+	errUserNotPolled   = errors.New("user is not polled by this instance")
+)
+
+// jobUser finds a user by ID or mailbox address (case-insensitive)
+func jobUser(ctx context.Context, ref string) (uuid.UUID, *time.Time, error) {
+	query := `SELECT id, paused_at FROM users WHERE LOWER(email) = LOWER($1)`
+	var arg any = strings.TrimSpace(ref)
+	if id, err := uuid.Parse(ref); err == nil {
+		query, arg = `SELECT id, paused_at FROM users WHERE id = $1`, id
+	}
+	var id uuid.UUID
+	var pausedAt *time.Time
+	err := db.Pool.QueryRow(ctx, query, arg).Scan(&id, &pausedAt)
+	if errors.Is(err, pgx.ErrNoRows) {
+		return id, nil, errJobUserNotFound
+	}
+	return id, pausedAt, err
+}
+
+// runUserJob pauses, resumes or polls each user of the job
+// Pausing takes effect at the user's next poll; other instances see it once their user cache
+// entry expires (cache.user_ttl). Polls are only triggered for users polled by this instance.
+func (s *Service) runUserJob(ctx context.Context, run *jobs.Run, r JobRequest) error {
+	run.SetTotal(len(r.Users))
+	for _, ref := range r.Users {
+		if err := ctx.Err(); err != nil {
+			return err
+		}
+		skipped, err := s.applyUserJob(ctx, r.Operation, ref)
+		if err != nil && ctx.Err() != nil {
+			return ctx.Err()
+		}
+		run.Item(ref, skipped, err)
+	}
+	return nil
+}
+
+// applyUserJob applies a user operation, reporting whether there was nothing to do
+func (s *Service) applyUserJob(ctx context.Context, operation, ref string) (bool, error) {
+	userID, pausedAt, err := jobUser(ctx, ref)
+	if err != nil {
+		return false, err
+	}
+
+	switch operation {
+	case JobPauseUsers, JobResumeUsers:
+		pause := operation == JobPauseUsers
+		if (pausedAt != nil) == pause {
+			return true, nil
+		}
+		query := `UPDATE users SET paused_at = NULL WHERE id = $1`
+		if pause {
+			query = `UPDATE users SET paused_at = NOW() WHERE id = $1`
+		}
+		if _, err := db.Pool.Exec(ctx, query, userID); err != nil {
+			return false, err
+		}
+		s.userCache.invalidate(userID)
+		return false, nil
+	case JobPollUsers:
+		if pausedAt != nil {
+			return false, errUserPaused
+		}
+		value, ok := s.activeUsers.Load(userID)
+		if !ok {
+			return false, errUserNotPolled
+		}
+		select {
+		case value.(*userEmailDiscovery).pollNow <- struct{}{}:
+			return false, nil
+		default:
+			return true, nil // A forced poll is already pending
+		}
+	}
+	return false, fmt.Errorf("unknown user operation %q", operation)
+}
+
+// jobEmail is an email listed by an email operation
+type jobEmail struct {
+	id          uuid.UUID
+	fingerprint string
+	receivedAt  time.Time
+}
+
+// eachJobEmail calls fn with the emails matching filter (on received_at $1 and $2), oldest first,
+// until fn returns an error; emails changed or deleted by fn are not listed again
+func eachJobEmail(ctx context.Context, run *jobs.Run, r JobRequest, filter string, fn func(jobEmail) error) error {
+	var from, to any
+	if r.From != nil {
+		from = *r.From
+	}
+	if r.To != nil {
+		to = *r.To
+	}
+	where := `($1::TIMESTAMPTZ IS NULL OR received_at >= $1) AND ($2::TIMESTAMPTZ IS NULL OR received_at < $2) AND ` + filter
+
+	var total int
+	if err := db.ReadPool.QueryRow(ctx, `SELECT COUNT(*) FROM emails WHERE `+where, from, to).Scan(&total); err != nil {
+		return fmt.Errorf("failed to count emails: %w", err)
+	}
+	run.SetTotal(total)
+
+	var after jobEmail
+	for {
+		rows, err := db.ReadPool.Query(ctx, `
+			SELECT id, fingerprint, received_at FROM emails
+			WHERE `+where+` AND (received_at, id) > ($3, $4)
+			ORDER BY received_at, id
+			LIMIT $5`,
+			from, to, after.receivedAt, after.id, jobEmailBatchSize,
+		)
+		if err != nil {
+			return fmt.Errorf("failed to list emails: %w", err)
+		}
+		var batch []jobEmail
+		for rows.Next() {
+			var e jobEmail
+			if err := rows.Scan(&e.id, &e.fingerprint, &e.receivedAt); err != nil {
+				rows.Close()
+				return err
+			}
+			batch = append(batch, e)
+		}
+		rows.Close()
+		if err := rows.Err(); err != nil {
+			return err
+		}
+
+		for _, e := range batch {
+			if err := fn(e); err != nil {
+				return err
+			}
+		}
+		if len(batch) < jobEmailBatchSize {
+			return nil
+		}
+		after = batch[len(batch)-1]
+	}
+}
+
+// runRefingerprint refetches each email of the range from the provider and fingerprints it with
+// the current algorithm (ingest.fingerprint) and body mode. An email whose new fingerprint is
+// already stored is merged into that email: its mailbox links and reports move over, the earliest
+// received_at and detected_at are kept, and it is deleted.
+func (s *Service) runRefingerprint(ctx context.Context, run *jobs.Run, r JobRequest) error {
+	return eachJobEmail(ctx, run, r, `TRUE`, func(e jobEmail) error {
+		if err := ctx.Err(); err != nil {
+			return err
+		}
+		itemCtx, cancel := context.WithTimeout(ctx, jobProviderTimeout)
+		defer cancel()
+
+		email, _, err := s.fetchStoredEmail(itemCtx, e.id)
+		if err != nil {
+			run.Item(e.id.String(), false, err)
+			return ctx.Err()
+		}
+		fingerprint := s.fingerprintAlg.FingerprintEmail(email, s.fingerprintMode)
+		if fingerprint == e.fingerprint {
+			run.Item(e.id.String(), true, nil)
+			return nil
+		}
+		run.Item(e.id.String(), false, setFingerprint(itemCtx, e.id, fingerprint))
+		return ctx.Err()
+	})
+}
+
+// setFingerprint updates an email's fingerprint, merging it into the email already stored under it
+func setFingerprint(ctx context.Context, emailID uuid.UUID, fingerprint string) error {
+	tx, err := db.Pool.Begin(ctx)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback(ctx)
+
+	var existing uuid.UUID
+	err = tx.QueryRow(ctx, `SELECT id FROM emails WHERE fingerprint = $1 AND id <> $2 FOR UPDATE`, fingerprint, emailID).Scan(&existing)
+	switch {
+	case errors.Is(err, pgx.ErrNoRows):
+		if _, err := tx.Exec(ctx, `UPDATE emails SET fingerprint = $1 WHERE id = $2`, fingerprint, emailID); err != nil {
+			return fmt.Errorf("failed to update fingerprint: %w", err)
+		}
+	case err != nil:
+		return err
+	default:
+		merges := []string{
+			`UPDATE user_emails SET email_id = $1
+			WHERE email_id = $2 AND user_id NOT IN (SELECT user_id FROM user_emails WHERE email_id = $1)`,
+			`UPDATE reports SET email_id = $1 WHERE email_id = $2`,
+			`UPDATE emails e SET received_at = LEAST(e.received_at, o.received_at), detected_at = LEAST(e.detected_at, o.detected_at)
+			FROM emails o WHERE e.id = $1 AND o.id = $2`,
+			`DELETE FROM emails WHERE id = $2`,
+		}
+		for _, query := range merges {
+			if _, err := tx.Exec(ctx, query, existing, emailID); err != nil {
+				return fmt.Errorf("failed to merge into email %s: %w", existing, err)
+			}
+		}
+	}
+	return tx.Commit(ctx)
+}
+
+// runRequeueFailed publishes again the emails whose analysis queue publication failed, refetching
+// them from the provider; an email already published since (dedup) is only unflagged
+func (s *Service) runRequeueFailed(ctx context.Context, run *jobs.Run, r JobRequest) error {
+	return eachJobEmail(ctx, run, r, `queue_failed_at IS NOT NULL`, func(e jobEmail) error {
+		if err := ctx.Err(); err != nil {
+			return err
+		}
+		itemCtx, cancel := context.WithTimeout(ctx, jobProviderTimeout)
+		defer cancel()
+
+		email, userID, err := s.fetchStoredEmail(itemCtx, e.id)
+		if err != nil {
+			run.Item(e.id.String(), false, err)
+			return ctx.Err()
+		}
+		email.MessageID = e.id.String() // The stored ID, also used by reference payloads
+		ewu := &EmailWithUser{Email: email, UserID: userID, DiscoveredAt: time.Now(), Priority: s.prefilter.match(email)}
+		policy := s.policyFor(userID)
+		msg := buildAnalysisMessage(s.tenantID, ewu, e.fingerprint, policy.payloadMode(s.payloadMode), s.fetchBaseURL)
+		msg.AlertRoute = policy.AlertRoute
+
+		err = s.publishAnalysis(itemCtx, msg)
+		duplicate := errors.Is(err, ErrDuplicate)
+		if err == nil || duplicate {
+			if _, err = db.Pool.Exec(itemCtx, `UPDATE emails SET queue_failed_at = NULL WHERE id = $1`, e.id); err != nil {
+				err = fmt.Errorf("published, but failed to unflag: %w", err)
+			}
+		}
+		run.Item(e.id.String(), duplicate && err == nil, err)
+		return ctx.Err()
+	})
+}
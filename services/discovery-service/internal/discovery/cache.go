@@ -0,0 +1,65 @@
+package discovery
+
+import (
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/google/uuid"
+	discoverymodels "github.com/stoik/vigil/services/discovery-service/internal/models"
+)
+
+const DefaultUserCacheTTL = 2 * time.Minute // Upper bound on staleness for rows changed outside this process
+
+type cachedUser struct {
+	user      discoverymodels.User
+	expiresAt time.Time
+}
+
+// userCache keeps user rows between polls so each poll doesn't SELECT the user again
+// Entries are invalidated whenever this process updates the user's cursor columns,
+// the TTL only bounds staleness for changes made elsewhere
+type userCache struct {
+	mu      sync.RWMutex
+	entries map[uuid.UUID]cachedUser
+	ttl     time.Duration
+	hits    int64 // atomic counter
+	misses  int64 // atomic counter
+}
+
+func newUserCache(ttl time.Duration) *userCache {
+	return &userCache{
+		entries: make(map[uuid.UUID]cachedUser),
+		ttl:     ttl,
+	}
+}
+
+func (c *userCache) get(userID uuid.UUID) (discoverymodels.User, bool) {
+	c.mu.RLock()
+	entry, ok := c.entries[userID]
+	c.mu.RUnlock()
+
+	if !ok || time.Now().After(entry.expiresAt) {
+		atomic.AddInt64(&c.misses, 1)
+		return discoverymodels.User{}, false
+	}
+	atomic.AddInt64(&c.hits, 1)
+	return entry.user, true
+}
+
+func (c *userCache) put(user discoverymodels.User) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.entries[user.ID] = cachedUser{user: user, expiresAt: time.Now().Add(c.ttl)}
+}
+
+func (c *userCache) invalidate(userID uuid.UUID) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	delete(c.entries, userID)
+}
+
+// stats returns hit and miss counts since startup
+func (c *userCache) stats() (hits, misses int64) {
+	return atomic.LoadInt64(&c.hits), atomic.LoadInt64(&c.misses)
+}
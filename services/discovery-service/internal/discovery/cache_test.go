@@ -0,0 +1,44 @@
+package discovery
+
+import (
+	"testing"
+	"time"
+
+	"github.com/google/uuid"
+	discoverymodels "github.com/stoik/vigil/services/discovery-service/internal/models"
+)
+
+func TestUserCache(t *testing.T) {
+	c := newUserCache(time.Minute)
+	user := discoverymodels.User{ID: uuid.New(), Email: "a@example.com"}
+
+	if _, ok := c.get(user.ID); ok {
+		t.Fatal("expected miss on empty cache")
+	}
+
+	c.put(user)
+	got, ok := c.get(user.ID)
+	if !ok || got.Email != user.Email {
+		t.Fatalf("get() = %+v, %v, want cached user", got, ok)
+	}
+
+	c.invalidate(user.ID)
+	if _, ok := c.get(user.ID); ok {
+		t.Fatal("expected miss after invalidate")
+	}
+
+	if hits, misses := c.stats(); hits != 1 || misses != 2 {
+		t.Errorf("stats() = %d hits, %d misses, want 1, 2", hits, misses)
+	}
+}
+
+func TestUserCacheExpiry(t *testing.T) {
+	c := newUserCache(time.Millisecond)
+	user := discoverymodels.User{ID: uuid.New()}
+	c.put(user)
+
+	time.Sleep(5 * time.Millisecond)
+	if _, ok := c.get(user.ID); ok {
+		t.Fatal("expected expired entry to miss")
+	}
+}
@@ -0,0 +1,330 @@
+package discovery
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/jackc/pgx/v5"
+	"github.com/jackc/pgx/v5/pgxpool"
+	"github.com/spf13/viper"
+	"github.com/stoik/vigil/internal/models"
+	"github.com/stoik/vigil/services/discovery-service/internal/db"
+)
+
+const (
+	DefaultCampaignInterval   = time.Minute
+	DefaultCampaignSimilarity = 0.8
+	DefaultCampaignLimit      = 50
+	MaxCampaignLimit          = 500
+
+	campaignBatchSize     = 500 // Emails clustered per query
+	maxCampaignCandidates = 200 // Emails sharing a band compared with each new email
+	campaignLockID        = 0x7669676c_63616d70
+)
+
+// ErrCampaignNotFound is returned when a campaign ID is unknown
+var ErrCampaignNotFound = errors.New("campaign not found")
+
+// CampaignConfig configures campaign clustering
+type CampaignConfig struct {
+	Interval   time.Duration // How often new emails are clustered
+	Similarity float64       // Minimum estimated Jaccard similarity of two emails of a campaign
+}
+
+// Campaign is a group of emails with similar content, treated as one incident
+// Emails with identical content are already one stored email (fingerprint dedup)
+type Campaign struct {
+	ID            uuid.UUID `json:"id"`
+	FirstSeen     time.Time `json:"first_seen"` // Earliest received_at of its emails
+	LastSeen      time.Time `json:"last_seen"`
+	Emails        int       `json:"emails"`     // Distinct stored emails (variants)
+	Recipients    int       `json:"recipients"` // Mailboxes holding at least one of them
+	Detected      int       `json:"detected"`   // Emails flagged by analysis
+	SenderDomains []string  `json:"sender_domains"`
+}
+
+// campaignJob clusters new emails into campaigns by MinHash similarity
+//
+// At ingest, each new email's signature is stored with it. Every interval, emails not clustered
+// yet are compared with the stored emails sharing one of their LSH bands: an email at least as
+// similar as config.Similarity to one of them joins that email's campaign (the most similar
+// one's), creating the campaign when the match had none. Campaigns are not merged: an email
+// bridging two campaigns joins the closer one. One instance clusters at a time (advisory lock).
+type campaignJob struct {
+	config CampaignConfig
+}
+
+// newCampaignConfig reads the campaigns.* settings; returns false if clustering is disabled
+func newCampaignConfig() (CampaignConfig, bool) {
+	config := CampaignConfig{
+		Interval:   viper.GetDuration("campaigns.interval"),
+		Similarity: viper.GetFloat64("campaigns.similarity"),
+	}
+	if config.Interval <= 0 {
+		config.Interval = DefaultCampaignInterval
+	}
+	if config.Similarity <= 0 || config.Similarity > 1 {
+		config.Similarity = DefaultCampaignSimilarity
+	}
+	return config, viper.GetBool("campaigns.enabled")
+}
+
+// newCampaignJob returns nil when clustering is disabled
+func newCampaignJob(config CampaignConfig, enabled bool) *campaignJob {
+	if !enabled {
+		return nil
+	}
+	return &campaignJob{config: config}
+}
+
+// signature returns the encoded MinHash signature stored with a new email (nil when disabled)
+func (j *campaignJob) signature(email models.ProviderEmail) []byte {
+	if j == nil {
+		return nil
+	}
+	sig, ok := newMinhash(campaignText(email))
+	if !ok {
+		return nil
+	}
+	return sig.encode()
+}
+
+// run clusters new emails every interval until ctx is done
+func (j *campaignJob) run(ctx context.Context) {
+	if j == nil {
+		return
+	}
+	ticker := time.NewTicker(j.config.Interval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+			if err := j.cluster(ctx); err != nil && ctx.Err() == nil {
+				log.Printf("Error clustering campaigns: %v", err)
+			}
+		}
+	}
+}
+
+// cluster assigns every email not clustered yet, oldest first
+func (j *campaignJob) cluster(ctx context.Context) error {
+	conn, err := db.Pool.Acquire(ctx)
+	if err != nil {
+		return err
+	}
+	defer conn.Release()
+
+	var locked bool
+	if err := conn.QueryRow(ctx, `SELECT pg_try_advisory_lock($1)`, int64(campaignLockID)).Scan(&locked); err != nil {
+		return err
+	}
+	if !locked {
+		return nil // Another instance is clustering
+	}
+	defer conn.Exec(context.Background(), `SELECT pg_advisory_unlock($1)`, int64(campaignLockID))
+
+	start := time.Now()
+	clustered, joined, created := 0, 0, 0
+	for ctx.Err() == nil {
+		batch, err := unclusteredEmails(ctx, conn)
+		if err != nil {
+			return err
+		}
+		if len(batch) == 0 {
+			break
+		}
+		for _, e := range batch {
+			isNew, matched, err := j.clusterEmail(ctx, conn, e.id, e.minhash)
+			if err != nil {
+				return fmt.Errorf("failed to cluster email %s: %w", e.id, err)
+			}
+			clustered++
+			if matched {
+				joined++
+			}
+			if isNew {
+				created++
+			}
+		}
+	}
+	if clustered > 0 {
+		log.Printf("📊 Campaigns | clustered %d emails in %v: %d joined a campaign, %d new campaigns",
+			clustered, time.Since(start).Round(time.Millisecond), joined, created)
+	}
+	return nil
+}
+
+type unclusteredEmail struct {
+	id      uuid.UUID
+	minhash []byte
+}
+
+func unclusteredEmails(ctx context.Context, conn *pgxpool.Conn) ([]unclusteredEmail, error) {
+	rows, err := conn.Query(ctx, `
+		SELECT id, minhash FROM emails
+		WHERE minhash IS NOT NULL AND clustered_at IS NULL
+		ORDER BY received_at, id
+		LIMIT $1`,
+		campaignBatchSize,
+	)
+	if err != nil {
+		return nil, fmt.Errorf("failed to list emails to cluster: %w", err)
+	}
+	defer rows.Close()
+
+	var batch []unclusteredEmail
+	for rows.Next() {
+		var e unclusteredEmail
+		if err := rows.Scan(&e.id, &e.minhash); err != nil {
+			return nil, err
+		}
+		batch = append(batch, e)
+	}
+	return batch, rows.Err()
+}
+
+// clusterEmail compares an email with its LSH candidates, assigns its campaign and indexes its
+// bands; reports whether it matched an email and whether that created a campaign
+func (j *campaignJob) clusterEmail(ctx context.Context, conn *pgxpool.Conn, emailID uuid.UUID, encoded []byte) (created, matched bool, err error) {
+	sig, ok := decodeMinhash(encoded)
+	if !ok {
+		// Unreadable signature: marked clustered so it is not retried forever
+		_, err = conn.Exec(ctx, `UPDATE emails SET clustered_at = NOW() WHERE id = $1`, emailID)
+		return false, false, err
+	}
+	keys := sig.bandKeys()
+
+	rows, err := conn.Query(ctx, `
+		SELECT id, minhash, campaign_id FROM emails
+		WHERE id IN (SELECT email_id FROM email_minhash_bands WHERE band_key = ANY($1)) AND id <> $2
+		LIMIT $3`,
+		keys, emailID, maxCampaignCandidates,
+	)
+	if err != nil {
+		return false, false, fmt.Errorf("failed to find candidates: %w", err)
+	}
+	var bestID uuid.UUID
+	var bestCampaign *uuid.UUID
+	best := 0.0
+	for rows.Next() {
+		var id uuid.UUID
+		var raw []byte
+		var campaignID *uuid.UUID
+		if err := rows.Scan(&id, &raw, &campaignID); err != nil {
+			rows.Close()
+			return false, false, err
+		}
+		candidate, ok := decodeMinhash(raw)
+		if !ok {
+			continue
+		}
+		if similarity := sig.similarity(&candidate); similarity >= j.config.Similarity && similarity > best {
+			best, bestID, bestCampaign = similarity, id, campaignID
+		}
+	}
+	rows.Close()
+	if err := rows.Err(); err != nil {
+		return false, false, err
+	}
+
+	tx, err := conn.Begin(ctx)
+	if err != nil {
+		return false, false, err
+	}
+	defer tx.Rollback(ctx)
+
+	var campaignID *uuid.UUID
+	if bestID != uuid.Nil {
+		matched, campaignID = true, bestCampaign
+		if campaignID == nil {
+			id := uuid.New()
+			campaignID, created = &id, true
+			if _, err := tx.Exec(ctx, `INSERT INTO campaigns (id, first_seen, last_seen) VALUES ($1, NOW(), NOW())`, id); err != nil {
+				return false, false, fmt.Errorf("failed to create campaign: %w", err)
+			}
+			if _, err := tx.Exec(ctx, `UPDATE emails SET campaign_id = $1 WHERE id = $2`, id, bestID); err != nil {
+				return false, false, err
+			}
+		}
+	}
+	if _, err := tx.Exec(ctx, `UPDATE emails SET campaign_id = $1, clustered_at = NOW() WHERE id = $2`, campaignID, emailID); err != nil {
+		return false, false, err
+	}
+	if _, err := tx.Exec(ctx,
+		`INSERT INTO email_minhash_bands (band_key, email_id) SELECT unnest($1::BIGINT[]), $2 ON CONFLICT DO NOTHING`,
+		keys, emailID,
+	); err != nil {
+		return false, false, fmt.Errorf("failed to index bands: %w", err)
+	}
+	if campaignID != nil {
+		if _, err := tx.Exec(ctx, `
+			UPDATE campaigns c SET emails = s.n, first_seen = s.first, last_seen = s.last
+			FROM (SELECT COUNT(*) AS n, MIN(received_at) AS first, MAX(received_at) AS last FROM emails WHERE campaign_id = $1) s
+			WHERE c.id = $1`,
+			*campaignID,
+		); err != nil {
+			return false, false, fmt.Errorf("failed to update campaign: %w", err)
+		}
+	}
+	return created, matched, tx.Commit(ctx)
+}
+
+const campaignColumns = `c.id, c.first_seen, c.last_seen, c.emails,
+	(SELECT COUNT(DISTINCT ue.user_id) FROM emails e JOIN user_emails ue ON ue.email_id = e.id WHERE e.campaign_id = c.id),
+	(SELECT COUNT(*) FROM emails e WHERE e.campaign_id = c.id AND e.detected_at IS NOT NULL),
+	COALESCE(ARRAY(SELECT DISTINCT e.sender_domain FROM emails e WHERE e.campaign_id = c.id AND e.sender_domain IS NOT NULL ORDER BY 1), '{}')`
+
+func scanCampaign(row pgx.Row) (Campaign, error) {
+	var c Campaign
+	err := row.Scan(&c.ID, &c.FirstSeen, &c.LastSeen, &c.Emails, &c.Recipients, &c.Detected, &c.SenderDomains)
+	return c, err
+}
+
+// Campaigns lists campaigns of at least minEmails emails, most recently seen first
+func (s *Service) Campaigns(ctx context.Context, minEmails, limit int) ([]Campaign, error) {
+	if limit <= 0 {
+		limit = DefaultCampaignLimit
+	}
+	limit = min(limit, MaxCampaignLimit)
+
+	rows, err := db.ReadPool.Query(ctx, `SELECT `+campaignColumns+`
+		FROM campaigns c
+		WHERE c.emails >= $1
+		ORDER BY c.last_seen DESC, c.id
+		LIMIT $2`,
+		minEmails, limit,
+	)
+	if err != nil {
+		return nil, fmt.Errorf("failed to list campaigns: %w", err)
+	}
+	defer rows.Close()
+
+	campaigns := []Campaign{}
+	for rows.Next() {
+		c, err := scanCampaign(rows)
+		if err != nil {
+			return nil, fmt.Errorf("failed to scan campaign: %w", err)
+		}
+		campaigns = append(campaigns, c)
+	}
+	return campaigns, rows.Err()
+}
+
+// Campaign returns one campaign; its emails are listed by SearchEmails with EmailQuery.Campaign
+func (s *Service) Campaign(ctx context.Context, id uuid.UUID) (Campaign, error) {
+	c, err := scanCampaign(db.ReadPool.QueryRow(ctx, `SELECT `+campaignColumns+` FROM campaigns c WHERE c.id = $1`, id))
+	if errors.Is(err, pgx.ErrNoRows) {
+		return c, ErrCampaignNotFound
+	}
+	if err != nil {
+		return c, fmt.Errorf("failed to get campaign: %w", err)
+	}
+	return c, nil
+}
@@ -0,0 +1,52 @@
+package discovery
+
+import (
+	"slices"
+	"testing"
+
+	"github.com/google/uuid"
+	"github.com/stoik/vigil/internal/models"
+)
+
+func TestCampaignClustering(t *testing.T) {
+	ctx := setupTestDB(t)
+	users := insertTestUsers(t, ctx, 2)
+
+	s := &Service{campaigns: newCampaignJob(CampaignConfig{Similarity: DefaultCampaignSimilarity}, true)}
+	bodies := []string{
+		invoiceEmail("Alice", "20931", "1,250.00"),
+		invoiceEmail("Bob", "77310", "980.50"),
+		"Lunch on Friday? The new place near the office has a terrace.",
+	}
+	ids := make([]uuid.UUID, len(bodies))
+	for i, body := range bodies {
+		email := models.ProviderEmail{MessageID: uuid.NewString(), From: "ar@billing-c0mpany.example", Body: body}
+		if _, err := s.storeEmail(ctx, email, Fingerprint(body), users[i%2]); err != nil {
+			t.Fatalf("storeEmail() = %v", err)
+		}
+		ids[i] = uuid.MustParse(email.MessageID)
+	}
+	if err := s.campaigns.cluster(ctx); err != nil {
+		t.Fatalf("cluster() = %v", err)
+	}
+
+	campaignOf := func(id uuid.UUID) *uuid.UUID {
+		page, err := s.SearchEmails(ctx, EmailQuery{Fingerprint: Fingerprint(bodies[slices.Index(ids, id)])})
+		if err != nil || len(page.Emails) != 1 {
+			t.Fatalf("SearchEmails() = %+v, %v", page, err)
+		}
+		return page.Emails[0].CampaignID
+	}
+	first, second := campaignOf(ids[0]), campaignOf(ids[1])
+	if first == nil || second == nil || *first != *second {
+		t.Fatalf("variants in campaigns %v and %v, want one campaign", first, second)
+	}
+	if campaignOf(ids[2]) != nil {
+		t.Error("unrelated email joined a campaign")
+	}
+
+	campaign, err := s.Campaign(ctx, *first)
+	if err != nil || campaign.Emails != 2 || campaign.Recipients != 2 || !slices.Equal(campaign.SenderDomains, []string{"billing-c0mpany.example"}) {
+		t.Errorf("Campaign() = %+v, %v; want 2 emails to 2 recipients", campaign, err)
+	}
+}
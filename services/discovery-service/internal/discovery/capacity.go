@@ -0,0 +1,220 @@
+package discovery
+
+import (
+	"context"
+	"errors"
+	"log"
+	"math"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/spf13/viper"
+)
+
+const (
+	DefaultCapacityInterval          = 30 * time.Second
+	DefaultCapacitySustain           = 5 * time.Minute
+	DefaultCapacityTargetUtilization = 0.8
+	capacitySampleInterval           = time.Second // How often processing slot usage is sampled
+	minCapacityUtilization           = 0.05        // Below this, capacity estimates are noise
+)
+
+// ErrSaturated is returned by Run when capacity.exit_on_saturation is set and the instance
+// stayed saturated for capacity.sustain, so an orchestrator can restart it with more replicas
+var ErrSaturated = errors.New("instance saturated: ingest demand exceeds processing capacity")
+
+// CapacityConfig configures the capacity controller
+type CapacityConfig struct {
+	Interval          time.Duration // Evaluation interval
+	Sustain           time.Duration // How long saturation must last before it is reported as sustained
+	TargetUtilization float64       // Fraction of estimated capacity an instance should run at
+	PerUserRate       float64       // Expected emails/s per user (0 = use the observed arrival rate only)
+	ExitOnSaturation  bool          // Stop the service with ErrSaturated on sustained saturation
+}
+
+// CapacityReport is the controller's latest view of ingest throughput and capacity
+type CapacityReport struct {
+	At                  time.Time `json:"at"`
+	Users               int       `json:"users"`
+	ArrivalRate         float64   `json:"arrival_rate"`            // Emails/s fetched from the provider
+	ConfiguredRate      float64   `json:"configured_rate"`         // users × capacity.emails_per_user_per_hour
+	Throughput          float64   `json:"throughput"`              // Emails/s processed
+	Utilization         float64   `json:"utilization"`             // Mean share of processing slots in use
+	EstimatedCapacity   float64   `json:"estimated_capacity"`      // Emails/s at full utilization (0 = unknown)
+	Saturated           bool      `json:"saturated"`               // Demand exceeds target utilization of capacity
+	SaturatedFor        string    `json:"saturated_for,omitempty"` // How long saturation has lasted
+	RecommendedReplicas int       `json:"recommended_replicas"`    // Instances needed to serve demand at target utilization
+}
+
+// capacityInput is one evaluation interval's measurements
+type capacityInput struct {
+	elapsed    time.Duration
+	users      int
+	polled     int64   // Emails fetched during the interval
+	processed  int64   // Emails processed during the interval
+	meanSlots  float64 // Mean processing slots in use
+	slots      int     // Processing slots available to the tenant
+	perUser    float64 // Configured emails/s per user
+	targetUtil float64
+}
+
+// evaluateCapacity estimates capacity as throughput / utilization and compares it with demand,
+// the higher of the observed arrival rate and the configured users × per-user rate
+func evaluateCapacity(in capacityInput) CapacityReport {
+	seconds := in.elapsed.Seconds()
+	if seconds <= 0 {
+		return CapacityReport{Users: in.users}
+	}
+
+	report := CapacityReport{
+		Users:          in.users,
+		ArrivalRate:    float64(in.polled) / seconds,
+		ConfiguredRate: float64(in.users) * in.perUser,
+		Throughput:     float64(in.processed) / seconds,
+	}
+	if in.slots > 0 {
+		report.Utilization = math.Min(in.meanSlots/float64(in.slots), 1)
+	}
+	if report.Utilization >= minCapacityUtilization && report.Throughput > 0 {
+		report.EstimatedCapacity = report.Throughput / report.Utilization
+	}
+
+	demand := math.Max(report.ArrivalRate, report.ConfiguredRate)
+	usable := report.EstimatedCapacity * in.targetUtil
+	switch {
+	case usable > 0:
+		report.Saturated = demand > usable
+		report.RecommendedReplicas = max(1, int(math.Ceil(demand/usable)))
+	case report.Utilization >= in.targetUtil:
+		// Slots busy but nothing completes: stalled, not just under-provisioned
+		report.Saturated = true
+		report.RecommendedReplicas = 1
+	default:
+		report.RecommendedReplicas = 1
+	}
+	return report
+}
+
+// capacityController measures sustained ingest throughput against demand and signals
+// saturation (log, /debug/stats, optional exit) so orchestration can add replicas
+// before emails back up in the per-user buffers
+type capacityController struct {
+	s      *Service
+	config CapacityConfig
+
+	// Only accessed by run
+	slotSamples     int64 // Sum of sampled in-flight counts over the interval
+	slotSampleCount int64
+
+	mu             sync.Mutex
+	report         CapacityReport
+	saturatedSince time.Time
+}
+
+func newCapacityConfig() CapacityConfig {
+	config := CapacityConfig{
+		Interval:          viper.GetDuration("capacity.interval"),
+		Sustain:           viper.GetDuration("capacity.sustain"),
+		TargetUtilization: viper.GetFloat64("capacity.target_utilization"),
+		PerUserRate:       viper.GetFloat64("capacity.emails_per_user_per_hour") / 3600,
+		ExitOnSaturation:  viper.GetBool("capacity.exit_on_saturation"),
+	}
+	if config.Interval <= 0 {
+		config.Interval = DefaultCapacityInterval
+	}
+	if config.Sustain <= 0 {
+		config.Sustain = DefaultCapacitySustain
+	}
+	if config.TargetUtilization <= 0 || config.TargetUtilization > 1 {
+		config.TargetUtilization = DefaultCapacityTargetUtilization
+	}
+	return config
+}
+
+func newCapacityController(s *Service, config CapacityConfig) *capacityController {
+	return &capacityController{s: s, config: config}
+}
+
+// run evaluates capacity every interval until ctx is done
+// Returns ErrSaturated if exit on saturation is enabled and saturation was sustained
+func (c *capacityController) run(ctx context.Context) error {
+	evaluate := time.NewTicker(c.config.Interval)
+	defer evaluate.Stop()
+	sample := time.NewTicker(capacitySampleInterval)
+	defer sample.Stop()
+
+	last := time.Now()
+	lastPolled := atomic.LoadInt64(&c.s.emailsPolled)
+	lastProcessed := atomic.LoadInt64(&c.s.emailsProcessed)
+
+	for {
+		select {
+		case <-ctx.Done():
+			return nil
+		case <-sample.C:
+			c.slotSamples += atomic.LoadInt64(&c.s.processingInFlight)
+			c.slotSampleCount++
+		case now := <-evaluate.C:
+			polled := atomic.LoadInt64(&c.s.emailsPolled)
+			processed := atomic.LoadInt64(&c.s.emailsProcessed)
+			in := capacityInput{
+				elapsed:    now.Sub(last),
+				users:      c.s.activeUserCount(),
+				polled:     polled - lastPolled,
+				processed:  processed - lastProcessed,
+				slots:      c.s.processingSlots(),
+				perUser:    c.config.PerUserRate,
+				targetUtil: c.config.TargetUtilization,
+			}
+			if c.slotSampleCount > 0 {
+				in.meanSlots = float64(c.slotSamples) / float64(c.slotSampleCount)
+			}
+			last, lastPolled, lastProcessed = now, polled, processed
+			c.slotSamples, c.slotSampleCount = 0, 0
+
+			if c.record(evaluateCapacity(in), now) && c.config.ExitOnSaturation {
+				return ErrSaturated
+			}
+		}
+	}
+}
+
+// record stores a report and logs saturation; returns true once saturation is sustained
+func (c *capacityController) record(report CapacityReport, now time.Time) bool {
+	report.At = now
+
+	c.mu.Lock()
+	if !report.Saturated {
+		if !c.saturatedSince.IsZero() {
+			log.Printf("✅ Capacity recovered | tenant=%s | throughput %.1f/s, utilization %.0f%%",
+				c.s.tenantID, report.Throughput, report.Utilization*100)
+		}
+		c.saturatedSince = time.Time{}
+	} else if c.saturatedSince.IsZero() {
+		c.saturatedSince = now
+	}
+	var saturatedFor time.Duration
+	if !c.saturatedSince.IsZero() {
+		saturatedFor = now.Sub(c.saturatedSince)
+		report.SaturatedFor = saturatedFor.Round(time.Second).String()
+	}
+	c.report = report
+	c.mu.Unlock()
+
+	if !report.Saturated {
+		return false
+	}
+	sustained := saturatedFor >= c.config.Sustain
+	log.Printf("🚨 Capacity saturated | tenant=%s | users=%d demand %.1f/s (arrival %.1f/s, configured %.1f/s) | throughput %.1f/s, est. capacity %.1f/s, utilization %.0f%% | for %s (sustained=%t) | recommended replicas: %d",
+		c.s.tenantID, report.Users, math.Max(report.ArrivalRate, report.ConfiguredRate), report.ArrivalRate, report.ConfiguredRate,
+		report.Throughput, report.EstimatedCapacity, report.Utilization*100, report.SaturatedFor, sustained, report.RecommendedReplicas)
+	return sustained
+}
+
+// latest returns the most recent report (zero before the first evaluation)
+func (c *capacityController) latest() CapacityReport {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.report
+}
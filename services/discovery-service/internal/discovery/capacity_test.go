@@ -0,0 +1,92 @@
+package discovery
+
+import (
+	"testing"
+	"time"
+)
+
+func TestEvaluateCapacity(t *testing.T) {
+	tests := []struct {
+		name          string
+		in            capacityInput
+		wantSaturated bool
+		wantReplicas  int
+		wantCapacity  float64
+	}{
+		{
+			// 100/s at 25% of slots: capacity 400/s, usable 320/s
+			name:         "headroom",
+			in:           capacityInput{elapsed: 10 * time.Second, users: 1000, polled: 1000, processed: 1000, meanSlots: 64, slots: 256, targetUtil: 0.8},
+			wantReplicas: 1,
+			wantCapacity: 400,
+		},
+		{
+			// Arrivals outpace 100/s processed at full utilization
+			name:          "observed overload",
+			in:            capacityInput{elapsed: 10 * time.Second, users: 5000, polled: 5000, processed: 1000, meanSlots: 256, slots: 256, targetUtil: 0.8},
+			wantSaturated: true,
+			wantReplicas:  7, // 500/s over 80/s usable
+			wantCapacity:  100,
+		},
+		{
+			// Configured users × rate flags saturation before the volume shows up
+			name:          "configured demand",
+			in:            capacityInput{elapsed: 10 * time.Second, users: 10000, polled: 100, processed: 100, meanSlots: 16, slots: 256, perUser: 0.1, targetUtil: 0.8},
+			wantSaturated: true,
+			wantReplicas:  8, // 1000/s over 128/s usable
+			wantCapacity:  160,
+		},
+		{
+			name:          "stalled",
+			in:            capacityInput{elapsed: 10 * time.Second, users: 10, polled: 30, processed: 0, meanSlots: 256, slots: 256, targetUtil: 0.8},
+			wantSaturated: true,
+			wantReplicas:  1,
+		},
+		{
+			name:         "idle",
+			in:           capacityInput{elapsed: 10 * time.Second, users: 10, slots: 256, targetUtil: 0.8},
+			wantReplicas: 1,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got := evaluateCapacity(tt.in)
+			if got.Saturated != tt.wantSaturated {
+				t.Errorf("Saturated = %v, want %v (%+v)", got.Saturated, tt.wantSaturated, got)
+			}
+			if got.RecommendedReplicas != tt.wantReplicas {
+				t.Errorf("RecommendedReplicas = %d, want %d", got.RecommendedReplicas, tt.wantReplicas)
+			}
+			if got.EstimatedCapacity != tt.wantCapacity {
+				t.Errorf("EstimatedCapacity = %v, want %v", got.EstimatedCapacity, tt.wantCapacity)
+			}
+		})
+	}
+}
+
+func TestCapacityControllerSustain(t *testing.T) {
+	c := newCapacityController(&Service{}, CapacityConfig{Sustain: time.Minute})
+	start := time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC)
+
+	steps := []struct {
+		offset        time.Duration
+		saturated     bool
+		wantSustained bool
+	}{
+		{0, true, false},
+		{30 * time.Second, true, false},
+		{60 * time.Second, true, true},
+		{90 * time.Second, false, false}, // Recovery resets the clock
+		{120 * time.Second, true, false},
+	}
+	for _, step := range steps {
+		sustained := c.record(CapacityReport{Saturated: step.saturated}, start.Add(step.offset))
+		if sustained != step.wantSustained {
+			t.Errorf("at %v: sustained = %v, want %v", step.offset, sustained, step.wantSustained)
+		}
+	}
+	if got := c.latest(); !got.Saturated || got.SaturatedFor != "0s" {
+		t.Errorf("latest() = %+v, want saturated for 0s", got)
+	}
+}
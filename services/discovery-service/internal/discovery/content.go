@@ -0,0 +1,55 @@
+package discovery
+
+import (
+	"context"
+	"errors"
+	"fmt"
+
+	"github.com/google/uuid"
+	"github.com/stoik/vigil/internal/models"
+	"github.com/stoik/vigil/services/discovery-service/internal/db"
+	"github.com/stoik/vigil/services/discovery-service/internal/provider"
+)
+
+// ErrEmailNotFound is returned when an email is unknown or no longer available at the provider
+var ErrEmailNotFound = errors.New("email not found")
+
+// FetchEmailContent fetches the full email from the provider on demand (zero copy principle:
+// bodies are never stored). The stored ID is the provider message ID of the first copy seen,
+// so each linked mailbox is tried until the provider returns it.
+func (s *Service) FetchEmailContent(ctx context.Context, emailID uuid.UUID) (models.ProviderEmail, error) {
+	email, _, err := s.fetchStoredEmail(ctx, emailID)
+	return email, err
+}
+
+// fetchStoredEmail fetches a stored email from the provider, along with the user it was fetched for
+func (s *Service) fetchStoredEmail(ctx context.Context, emailID uuid.UUID) (models.ProviderEmail, uuid.UUID, error) {
+	rows, err := db.ReadPool.Query(ctx, `SELECT user_id FROM user_emails WHERE email_id = $1`, emailID)
+	if err != nil {
+		return models.ProviderEmail{}, uuid.Nil, fmt.Errorf("failed to get email recipients: %w", err)
+	}
+	var userIDs []uuid.UUID
+	for rows.Next() {
+		var userID uuid.UUID
+		if err := rows.Scan(&userID); err != nil {
+			rows.Close()
+			return models.ProviderEmail{}, uuid.Nil, err
+		}
+		userIDs = append(userIDs, userID)
+	}
+	rows.Close()
+	if err := rows.Err(); err != nil {
+		return models.ProviderEmail{}, uuid.Nil, err
+	}
+
+	for _, userID := range userIDs {
+		email, err := s.provider.GetEmail(userID, emailID.String())
+		if err == nil {
+			return email, userID, nil
+		}
+		if !errors.Is(err, provider.ErrEmailNotFound) && !errors.Is(err, provider.ErrUserNotFound) {
+			return models.ProviderEmail{}, uuid.Nil, err
+		}
+	}
+	return models.ProviderEmail{}, uuid.Nil, ErrEmailNotFound
+}
@@ -0,0 +1,285 @@
+package discovery
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/spf13/viper"
+	"github.com/stoik/vigil/internal/models"
+)
+
+const (
+	DefaultCoverageInterval   = 15 * time.Minute
+	DefaultCoverageStaleAfter = 5 * time.Minute
+)
+
+// Reasons a mailbox listed in the provider directory is not monitored
+const (
+	ExclusionNotStored   = "not_stored"   // No users row (the address belongs to another user ID, or the upsert fails)
+	ExclusionNotPolling  = "not_polling"  // Stored but no poller is running (stopped by a provider error, or not yet added)
+	ExclusionPollFailing = "poll_failing" // Poller running but its last poll failed (credentials, rate limits, provider errors)
+	ExclusionStale       = "stale"        // Poller running but no successful poll within coverage.stale_after
+)
+
+// CoverageConfig configures the coverage job
+type CoverageConfig struct {
+	Interval   time.Duration // How often coverage is evaluated
+	StaleAfter time.Duration // Pollers without a successful poll for this long do not count as monitoring
+	MinPercent float64       // Coverage below this is logged as an alert (0 disables)
+}
+
+// CoverageReport compares the provider's user directory with the mailboxes actually being polled
+type CoverageReport struct {
+	At             time.Time         `json:"at"`
+	DirectoryUsers int               `json:"directory_users"`
+	Monitored      int               `json:"monitored"`
+	Percent        float64           `json:"percent"`     // Monitored / DirectoryUsers, 0-100
+	Excluded       map[string]int    `json:"excluded"`    // Unmonitored mailboxes per reason
+	Unmonitored    []UnmonitoredUser `json:"unmonitored"` // Sorted by email
+	// Stored users no longer listed by the provider (their pollers stop on the next user discovery)
+	NotInDirectory int `json:"not_in_directory"`
+}
+
+// UnmonitoredUser is a directory mailbox that is not being watched, and why
+type UnmonitoredUser struct {
+	ID       uuid.UUID  `json:"id"`
+	Email    string     `json:"email"`
+	Reason   string     `json:"reason"`
+	Detail   string     `json:"detail,omitempty"`
+	FailedAt *time.Time `json:"failed_at,omitempty"` // Last failed poll, if any
+}
+
+// pollFailure is the last failed poll of a user, cleared by the next successful poll
+type pollFailure struct {
+	at  time.Time
+	err string
+}
+
+// pollerState is a running poller as seen by the coverage, SLA and alert evaluations
+type pollerState struct {
+	startedAt time.Time
+	lastPoll  time.Time // Zero before the first successful poll
+}
+
+// pollerStates snapshots the running pollers, keyed by user
+func (s *Service) pollerStates() map[uuid.UUID]pollerState {
+	pollers := make(map[uuid.UUID]pollerState)
+	s.activeUsers.Range(func(key, value interface{}) bool {
+		state := pollerState{startedAt: value.(*userEmailDiscovery).startedAt}
+		if at, ok := s.lastPollAt.Load(key); ok {
+			state.lastPoll = at.(time.Time)
+		}
+		pollers[key.(uuid.UUID)] = state
+		return true
+	})
+	return pollers
+}
+
+// coverageInput is one evaluation's snapshot of the directory, database and pollers
+type coverageInput struct {
+	now        time.Time
+	directory  []models.ProviderUser
+	stored     map[uuid.UUID]bool
+	pollers    map[uuid.UUID]pollerState
+	failures   map[uuid.UUID]pollFailure
+	staleAfter time.Duration
+}
+
+// evaluateCoverage classifies every directory user as monitored or excluded with a reason
+func evaluateCoverage(in coverageInput) CoverageReport {
+	report := CoverageReport{
+		At:             in.now,
+		DirectoryUsers: len(in.directory),
+		Excluded:       map[string]int{},
+		Unmonitored:    []UnmonitoredUser{},
+	}
+
+	listed := make(map[uuid.UUID]bool, len(in.directory))
+	for _, u := range in.directory {
+		listed[u.ID] = true
+
+		reason, detail := "", ""
+		failure, failed := in.failures[u.ID]
+		poller, polling := in.pollers[u.ID]
+		switch {
+		case !in.stored[u.ID]:
+			reason = ExclusionNotStored
+		case !polling:
+			reason = ExclusionNotPolling
+			if failed {
+				detail = failure.err
+			}
+		case failed:
+			reason, detail = ExclusionPollFailing, failure.err
+		default:
+			since := poller.lastPoll
+			if since.IsZero() {
+				since = poller.startedAt
+			}
+			if in.now.Sub(since) > in.staleAfter {
+				reason = ExclusionStale
+				if poller.lastPoll.IsZero() {
+					detail = fmt.Sprintf("not polled since started %s ago", in.now.Sub(since).Round(time.Second))
+				} else {
+					detail = fmt.Sprintf("last polled %s ago", in.now.Sub(since).Round(time.Second))
+				}
+			}
+		}
+
+		if reason == "" {
+			report.Monitored++
+			continue
+		}
+		report.Excluded[reason]++
+		unmonitored := UnmonitoredUser{ID: u.ID, Email: u.Email, Reason: reason, Detail: detail}
+		if failed && (reason == ExclusionNotPolling || reason == ExclusionPollFailing) {
+			unmonitored.FailedAt = &failure.at
+		}
+		report.Unmonitored = append(report.Unmonitored, unmonitored)
+	}
+
+	for id := range in.stored {
+		if !listed[id] {
+			report.NotInDirectory++
+		}
+	}
+
+	report.Percent = 100
+	if report.DirectoryUsers > 0 {
+		report.Percent = float64(report.Monitored) * 100 / float64(report.DirectoryUsers)
+	}
+	sort.Slice(report.Unmonitored, func(i, j int) bool { return report.Unmonitored[i].Email < report.Unmonitored[j].Email })
+	return report
+}
+
+// coverageJob periodically evaluates coverage and keeps the latest report for the API
+type coverageJob struct {
+	s      *Service
+	config CoverageConfig
+
+	mu     sync.Mutex
+	report *CoverageReport
+}
+
+func newCoverageConfig() CoverageConfig {
+	config := CoverageConfig{
+		Interval:   viper.GetDuration("coverage.interval"),
+		StaleAfter: viper.GetDuration("coverage.stale_after"),
+		MinPercent: viper.GetFloat64("coverage.min_percent"),
+	}
+	if config.Interval <= 0 {
+		config.Interval = DefaultCoverageInterval
+	}
+	if config.StaleAfter <= 0 {
+		config.StaleAfter = DefaultCoverageStaleAfter
+	}
+	return config
+}
+
+func newCoverageJob(s *Service, config CoverageConfig) *coverageJob {
+	return &coverageJob{s: s, config: config}
+}
+
+// run evaluates coverage every interval until ctx is done
+func (j *coverageJob) run(ctx context.Context) {
+	ticker := time.NewTicker(j.config.Interval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+			if j.s.maintenance.paused() {
+				continue
+			}
+			if _, err := j.evaluate(ctx); err != nil && ctx.Err() == nil {
+				logUserDiscoveryError("Error evaluating coverage", err)
+			}
+		}
+	}
+}
+
+// evaluate computes, stores and logs a coverage report
+func (j *coverageJob) evaluate(ctx context.Context) (CoverageReport, error) {
+	s := j.s
+	directory, err := s.provider.GetUsers(s.tenantID)
+	if err != nil {
+		return CoverageReport{}, fmt.Errorf("failed to get users from provider: %w", err)
+	}
+	dbUsers, err := s.getUsers(ctx)
+	if err != nil {
+		return CoverageReport{}, fmt.Errorf("failed to get users from database: %w", err)
+	}
+
+	in := coverageInput{
+		now:        s.clk().Now(),
+		directory:  directory,
+		stored:     make(map[uuid.UUID]bool, len(dbUsers)),
+		pollers:    s.pollerStates(),
+		failures:   make(map[uuid.UUID]pollFailure),
+		staleAfter: j.config.StaleAfter,
+	}
+	for _, u := range dbUsers {
+		in.stored[u.ID] = true
+	}
+	s.pollFailures.Range(func(key, value interface{}) bool {
+		in.failures[key.(uuid.UUID)] = value.(pollFailure)
+		return true
+	})
+
+	report := evaluateCoverage(in)
+
+	// Failures of users gone from the directory are no longer needed
+	listed := make(map[uuid.UUID]bool, len(directory))
+	for _, u := range directory {
+		listed[u.ID] = true
+	}
+	for id := range in.failures {
+		if !listed[id] {
+			s.pollFailures.Delete(id)
+		}
+	}
+
+	j.mu.Lock()
+	j.report = &report
+	j.mu.Unlock()
+
+	excluded := make([]string, 0, len(report.Excluded))
+	for reason, n := range report.Excluded {
+		excluded = append(excluded, fmt.Sprintf("%s=%d", reason, n))
+	}
+	sort.Strings(excluded)
+	if len(excluded) == 0 {
+		excluded = append(excluded, "none")
+	}
+	if j.config.MinPercent > 0 && report.Percent < j.config.MinPercent {
+		log.Printf("🚨 Coverage below %.1f%% | tenant=%s | %.1f%% (%d/%d mailboxes monitored) | excluded: %s",
+			j.config.MinPercent, s.tenantID, report.Percent, report.Monitored, report.DirectoryUsers, strings.Join(excluded, " "))
+	} else {
+		log.Printf("📋 Coverage | tenant=%s | %.1f%% (%d/%d mailboxes monitored) | excluded: %s",
+			s.tenantID, report.Percent, report.Monitored, report.DirectoryUsers, strings.Join(excluded, " "))
+	}
+	return report, nil
+}
+
+// latest returns the most recent report, or nil before the first evaluation
+func (j *coverageJob) latest() *CoverageReport {
+	j.mu.Lock()
+	defer j.mu.Unlock()
+	return j.report
+}
+
+// Coverage returns the latest coverage report, evaluating it now if refresh is set or none exists yet
+func (s *Service) Coverage(ctx context.Context, refresh bool) (CoverageReport, error) {
+	if report := s.coverage.latest(); report != nil && !refresh {
+		return *report, nil
+	}
+	return s.coverage.evaluate(ctx)
+}
@@ -0,0 +1,73 @@
+package discovery
+
+import (
+	"testing"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/stoik/vigil/internal/models"
+)
+
+func TestEvaluateCoverage(t *testing.T) {
+	now := time.Date(2024, 3, 1, 12, 0, 0, 0, time.UTC)
+	user := func(email string) models.ProviderUser { return models.ProviderUser{ID: uuid.New(), Email: email} }
+	healthy, fresh, conflict, stopped, failing, stale, neverPolled := user("a@acme.com"), user("b@acme.com"),
+		user("c@acme.com"), user("d@acme.com"), user("e@acme.com"), user("f@acme.com"), user("g@acme.com")
+	departed := uuid.New()
+
+	in := coverageInput{
+		now:        now,
+		directory:  []models.ProviderUser{neverPolled, stale, failing, stopped, conflict, fresh, healthy},
+		stored:     map[uuid.UUID]bool{healthy.ID: true, fresh.ID: true, stopped.ID: true, failing.ID: true, stale.ID: true, neverPolled.ID: true, departed: true},
+		staleAfter: 5 * time.Minute,
+		pollers: map[uuid.UUID]pollerState{
+			healthy.ID:     {startedAt: now.Add(-time.Hour), lastPoll: now.Add(-30 * time.Second)},
+			fresh.ID:       {startedAt: now.Add(-time.Minute)}, // Still in its initial jitter
+			failing.ID:     {startedAt: now.Add(-time.Hour), lastPoll: now.Add(-10 * time.Minute)},
+			stale.ID:       {startedAt: now.Add(-time.Hour), lastPoll: now.Add(-10 * time.Minute)},
+			neverPolled.ID: {startedAt: now.Add(-10 * time.Minute)},
+		},
+		failures: map[uuid.UUID]pollFailure{
+			stopped.ID: {at: now.Add(-20 * time.Minute), err: "user not found"},
+			failing.ID: {at: now.Add(-time.Minute), err: "unauthorized"},
+		},
+	}
+	report := evaluateCoverage(in)
+
+	if report.DirectoryUsers != 7 || report.Monitored != 2 || report.NotInDirectory != 1 {
+		t.Errorf("directory %d, monitored %d, not in directory %d", report.DirectoryUsers, report.Monitored, report.NotInDirectory)
+	}
+	if want := 200.0 / 7; report.Percent != want {
+		t.Errorf("percent = %v, want %v", report.Percent, want)
+	}
+	wantExcluded := map[string]int{ExclusionNotStored: 1, ExclusionNotPolling: 1, ExclusionPollFailing: 1, ExclusionStale: 2}
+	for reason, n := range wantExcluded {
+		if report.Excluded[reason] != n {
+			t.Errorf("excluded[%s] = %d, want %d", reason, report.Excluded[reason], n)
+		}
+	}
+
+	wantReasons := []struct {
+		email, reason, detail string
+		failed                bool
+	}{
+		{"c@acme.com", ExclusionNotStored, "", false},
+		{"d@acme.com", ExclusionNotPolling, "user not found", true},
+		{"e@acme.com", ExclusionPollFailing, "unauthorized", true},
+		{"f@acme.com", ExclusionStale, "last polled 10m0s ago", false},
+		{"g@acme.com", ExclusionStale, "not polled since started 10m0s ago", false},
+	}
+	if len(report.Unmonitored) != len(wantReasons) {
+		t.Fatalf("unmonitored = %+v", report.Unmonitored)
+	}
+	for i, want := range wantReasons {
+		got := report.Unmonitored[i]
+		if got.Email != want.email || got.Reason != want.reason || got.Detail != want.detail || (got.FailedAt != nil) != want.failed {
+			t.Errorf("unmonitored[%d] = %+v, want %+v", i, got, want)
+		}
+	}
+
+	if empty := evaluateCoverage(coverageInput{now: now}); empty.Percent != 100 || len(empty.Unmonitored) != 0 {
+		t.Errorf("empty directory = %+v, want full coverage", empty)
+	}
+}
@@ -0,0 +1,86 @@
+package discovery
+
+import (
+	"testing"
+	"testing/quick"
+	"time"
+
+	discoverymodels "github.com/stoik/vigil/services/discovery-service/internal/models"
+)
+
+var cursorBase = time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC)
+
+func TestReceivedAfterNeverPassesCursor(t *testing.T) {
+	// The poll window must always include the last received email
+	f := func(offsetSec int32, lookbackMs uint16) bool {
+		received := cursorBase.Add(time.Duration(offsetSec) * time.Second)
+		lookback := time.Duration(lookbackMs) * time.Millisecond
+		user := discoverymodels.User{LastEmailReceived: &received}
+
+		after := receivedAfterFor(user, lookback, time.Now())
+		return !after.After(received) && received.Sub(after) == lookback
+	}
+	if err := quick.Check(f, nil); err != nil {
+		t.Error(err)
+	}
+}
+
+func TestReceivedAfterMonotonic(t *testing.T) {
+	// A later cursor never yields an earlier poll window
+	f := func(a, b int32) bool {
+		ta := cursorBase.Add(time.Duration(a) * time.Second)
+		tb := cursorBase.Add(time.Duration(b) * time.Second)
+		if tb.Before(ta) {
+			ta, tb = tb, ta
+		}
+
+		afterA := receivedAfterFor(discoverymodels.User{LastEmailReceived: &ta}, DefaultLookback, time.Now())
+		afterB := receivedAfterFor(discoverymodels.User{LastEmailReceived: &tb}, DefaultLookback, time.Now())
+		return !afterB.Before(afterA)
+	}
+	if err := quick.Check(f, nil); err != nil {
+		t.Error(err)
+	}
+}
+
+func TestReceivedAfterPrefersLastReceived(t *testing.T) {
+	now := cursorBase.Add(time.Hour)
+	received := cursorBase.Add(10 * time.Minute)
+	checked := cursorBase.Add(50 * time.Minute)
+
+	tests := []struct {
+		name string
+		user discoverymodels.User
+		want time.Time
+	}{
+		{"never polled", discoverymodels.User{}, now.Add(-24 * time.Hour)},
+		{"checked only", discoverymodels.User{LastEmailCheck: &checked}, checked.Add(-DefaultLookback)},
+		{"received and checked", discoverymodels.User{LastEmailCheck: &checked, LastEmailReceived: &received}, received.Add(-DefaultLookback)},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := receivedAfterFor(tt.user, DefaultLookback, now); !got.Equal(tt.want) {
+				t.Errorf("receivedAfterFor() = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func FuzzReceivedAfter(f *testing.F) {
+	f.Add(int64(0), int64(time.Second))
+	f.Add(int64(-time.Hour), int64(10*time.Minute))
+
+	f.Fuzz(func(t *testing.T, offset, lookback int64) {
+		if lookback < 0 {
+			lookback = -lookback
+		}
+		received := cursorBase.Add(time.Duration(offset))
+		user := discoverymodels.User{LastEmailReceived: &received}
+
+		after := receivedAfterFor(user, time.Duration(lookback), time.Now())
+		if lookback >= 0 && after.After(received) {
+			t.Fatalf("receivedAfter %v is after cursor %v (lookback %v)", after, received, time.Duration(lookback))
+		}
+	})
+}
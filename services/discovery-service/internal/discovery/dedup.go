@@ -0,0 +1,230 @@
+package discovery
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/stoik/vigil/services/discovery-service/internal/db"
+)
+
+const (
+	DefaultDedupWindow    = 24 * time.Hour
+	DefaultDedupCacheSize = 100_000          // Recent keys kept in memory (the store holds the full window)
+	dedupPruneInterval    = 10 * time.Minute // How often expired keys are deleted from the store
+)
+
+// ErrDuplicate is returned by the dedup publisher for a message already published within the window
+var ErrDuplicate = errors.New("duplicate analysis message")
+
+// dedupStore persists publication claims so replays are caught across restarts and instances
+type dedupStore interface {
+	// claim records key as published now unless it was published within window
+	// Returns false if it was (the message is a duplicate)
+	claim(ctx context.Context, tenantID uuid.UUID, key string, window time.Duration) (bool, error)
+	// release drops a claim whose publication failed, so a retry is not suppressed
+	release(ctx context.Context, tenantID uuid.UUID, key string) error
+	// prune deletes claims published before cutoff
+	prune(ctx context.Context, cutoff time.Time) (int64, error)
+}
+
+// dedupPublisher suppresses analysis messages whose idempotency key was already published
+// within the window, so fan-in rebuilds and crash-recovery replays don't double-publish.
+// Recent keys are answered from a bounded in-memory cache; the rest from the store.
+// A key is claimed before publishing and released if publishing fails: a crash between
+// the two loses that message rather than publishing it twice
+type dedupPublisher struct {
+	next   Publisher
+	store  dedupStore // nil = in-memory only
+	window time.Duration
+
+	mu       sync.Mutex
+	seen     map[string]time.Time // key -> published at
+	order    []string             // Ring of keys in insertion order, for eviction
+	oldest   int                  // Ring position of the oldest key
+	capacity int
+}
+
+func newDedupPublisher(next Publisher, store dedupStore, window time.Duration, capacity int) *dedupPublisher {
+	if capacity < 1 {
+		capacity = DefaultDedupCacheSize
+	}
+	return &dedupPublisher{
+		next:     next,
+		store:    store,
+		window:   window,
+		seen:     make(map[string]time.Time),
+		order:    make([]string, 0, min(capacity, 1024)),
+		capacity: capacity,
+	}
+}
+
+func (d *dedupPublisher) Publish(ctx context.Context, msg AnalysisMessage) error {
+	key := dedupKey(msg)
+	if key == "" {
+		return d.next.Publish(ctx, msg)
+	}
+
+	now := time.Now()
+	if !d.claimLocal(key, now) {
+		return ErrDuplicate
+	}
+
+	if d.store != nil {
+		claimed, err := d.store.claim(ctx, msg.TenantID, key, d.window)
+		if err != nil {
+			d.forget(key)
+			return fmt.Errorf("failed to claim idempotency key: %w", err)
+		}
+		if !claimed {
+			return ErrDuplicate
+		}
+	}
+
+	if err := d.next.Publish(ctx, msg); err != nil {
+		d.release(ctx, msg)
+		return err
+	}
+	return nil
+}
+
+// release drops the claim of a message whose publication failed, so a retry is not suppressed
+func (d *dedupPublisher) release(ctx context.Context, msg AnalysisMessage) {
+	key := dedupKey(msg)
+	if key == "" {
+		return
+	}
+	d.forget(key)
+	if d.store != nil {
+		if err := d.store.release(ctx, msg.TenantID, key); err != nil {
+			log.Printf("Error releasing idempotency key %s: %v", key, err)
+		}
+	}
+}
+
+// dedupKey scopes the message's idempotency key to its tenant in memory
+func dedupKey(msg AnalysisMessage) string {
+	if msg.IdempotencyKey == "" {
+		return ""
+	}
+	return msg.TenantID.String() + "/" + msg.IdempotencyKey
+}
+
+// claimLocal records key in the cache, returning false if it was published within the window
+func (d *dedupPublisher) claimLocal(key string, now time.Time) bool {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+
+	if at, ok := d.seen[key]; ok && now.Sub(at) < d.window {
+		return false
+	}
+	if _, ok := d.seen[key]; !ok {
+		d.pushLocked(key)
+	}
+	d.seen[key] = now
+	return true
+}
+
+// pushLocked appends key to the ring, evicting the oldest key once at capacity
+func (d *dedupPublisher) pushLocked(key string) {
+	if len(d.order) < d.capacity {
+		d.order = append(d.order, key)
+		return
+	}
+	delete(d.seen, d.order[d.oldest])
+	d.order[d.oldest] = key
+	d.oldest = (d.oldest + 1) % d.capacity
+}
+
+// forget drops a claim from the cache; its ring slot is reclaimed on eviction
+func (d *dedupPublisher) forget(key string) {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	d.seen[key] = time.Time{}
+}
+
+// run periodically deletes claims older than the window from the store
+func (d *dedupPublisher) run(ctx context.Context) {
+	if d.store == nil {
+		return
+	}
+	ticker := time.NewTicker(dedupPruneInterval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+			if n, err := d.store.prune(ctx, time.Now().Add(-d.window)); err != nil {
+				log.Printf("Error pruning queue dedup keys: %v", err)
+			} else if n > 0 {
+				log.Printf("Pruned %d expired queue dedup keys", n)
+			}
+		}
+	}
+}
+
+// pgDedupStore keeps claims in the queue_dedup table
+type pgDedupStore struct{}
+
+func (pgDedupStore) claim(ctx context.Context, tenantID uuid.UUID, key string, window time.Duration) (bool, error) {
+	// Insert, or take over a claim that has expired; a live claim leaves the row untouched
+	now := time.Now()
+	tag, err := db.Pool.Exec(ctx,
+		`INSERT INTO queue_dedup (tenant_id, idempotency_key, published_at)
+		VALUES ($1, $2, $3)
+		ON CONFLICT (tenant_id, idempotency_key) DO UPDATE
+			SET published_at = EXCLUDED.published_at
+			WHERE queue_dedup.published_at < $4`,
+		tenantID, key, now, now.Add(-window),
+	)
+	if err != nil {
+		return false, err
+	}
+	return tag.RowsAffected() == 1, nil
+}
+
+func (pgDedupStore) release(ctx context.Context, tenantID uuid.UUID, key string) error {
+	_, err := db.Pool.Exec(ctx,
+		`DELETE FROM queue_dedup WHERE tenant_id = $1 AND idempotency_key = $2`,
+		tenantID, key,
+	)
+	return err
+}
+
+func (pgDedupStore) prune(ctx context.Context, cutoff time.Time) (int64, error) {
+	tag, err := db.Pool.Exec(ctx, `DELETE FROM queue_dedup WHERE published_at < $1`, cutoff)
+	if err != nil {
+		return 0, err
+	}
+	return tag.RowsAffected(), nil
+}
+
+// DedupStats measures deduplication of the tenant's deliveries, since start
+type DedupStats struct {
+	Stored             int64 `json:"stored"`              // Deliveries that stored a new email
+	Duplicates         int64 `json:"duplicates"`          // Deliveries of an email the user already had
+	FingerprintMatches int64 `json:"fingerprint_matches"` // Deliveries of an email stored for another user
+	QueueDuplicates    int64 `json:"queue_duplicates"`    // Analysis messages dropped as already published
+	// Share of deliveries that did not store a new email
+	Ratio float64 `json:"ratio"`
+}
+
+func (s *Service) dedupStats() DedupStats {
+	d := DedupStats{
+		Stored:             atomic.LoadInt64(&s.emailsDiscovered),
+		Duplicates:         atomic.LoadInt64(&s.emailsDuplicate),
+		FingerprintMatches: atomic.LoadInt64(&s.fingerprintMatches),
+		QueueDuplicates:    atomic.LoadInt64(&s.emailsDeduplicated),
+	}
+	if deliveries := d.Stored + d.Duplicates + d.FingerprintMatches; deliveries > 0 {
+		d.Ratio = float64(d.Duplicates+d.FingerprintMatches) / float64(deliveries)
+	}
+	return d
+}
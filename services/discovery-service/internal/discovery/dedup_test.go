@@ -0,0 +1,179 @@
+package discovery
+
+import (
+	"context"
+	"errors"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/google/uuid"
+)
+
+// countingPublisher records published messages and fails while err is set
+type countingPublisher struct {
+	mu        sync.Mutex
+	published []AnalysisMessage
+	err       error
+}
+
+func (p *countingPublisher) Publish(_ context.Context, msg AnalysisMessage) error {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	if p.err != nil {
+		return p.err
+	}
+	p.published = append(p.published, msg)
+	return nil
+}
+
+// memDedupStore is an in-memory dedupStore, shared between publishers to model a restart
+type memDedupStore struct {
+	mu     sync.Mutex
+	claims map[string]time.Time
+}
+
+func newMemDedupStore() *memDedupStore {
+	return &memDedupStore{claims: make(map[string]time.Time)}
+}
+
+func (m *memDedupStore) claim(_ context.Context, tenantID uuid.UUID, key string, window time.Duration) (bool, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	k := tenantID.String() + key
+	if at, ok := m.claims[k]; ok && time.Since(at) < window {
+		return false, nil
+	}
+	m.claims[k] = time.Now()
+	return true, nil
+}
+
+func (m *memDedupStore) release(_ context.Context, tenantID uuid.UUID, key string) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	delete(m.claims, tenantID.String()+key)
+	return nil
+}
+
+func (m *memDedupStore) prune(_ context.Context, cutoff time.Time) (int64, error) {
+	return 0, nil
+}
+
+func TestDedupPublisherSuppressesReplays(t *testing.T) {
+	ctx := context.Background()
+	tenant := uuid.New()
+	next := &countingPublisher{}
+	d := newDedupPublisher(next, nil, time.Hour, 10)
+
+	msg := AnalysisMessage{TenantID: tenant, IdempotencyKey: "fp-1"}
+	if err := d.Publish(ctx, msg); err != nil {
+		t.Fatalf("first publish: %v", err)
+	}
+	if err := d.Publish(ctx, msg); !errors.Is(err, ErrDuplicate) {
+		t.Fatalf("replay: got %v, want ErrDuplicate", err)
+	}
+
+	// Same key in another tenant, and messages without a key, are not duplicates
+	if err := d.Publish(ctx, AnalysisMessage{TenantID: uuid.New(), IdempotencyKey: "fp-1"}); err != nil {
+		t.Errorf("other tenant: %v", err)
+	}
+	for i := 0; i < 2; i++ {
+		if err := d.Publish(ctx, AnalysisMessage{TenantID: tenant}); err != nil {
+			t.Errorf("keyless publish: %v", err)
+		}
+	}
+	if len(next.published) != 4 {
+		t.Errorf("published %d messages, want 4", len(next.published))
+	}
+}
+
+func TestDedupPublisherWindowExpires(t *testing.T) {
+	ctx := context.Background()
+	next := &countingPublisher{}
+	d := newDedupPublisher(next, nil, 20*time.Millisecond, 10)
+
+	msg := AnalysisMessage{TenantID: uuid.New(), IdempotencyKey: "fp-1"}
+	if err := d.Publish(ctx, msg); err != nil {
+		t.Fatal(err)
+	}
+	time.Sleep(30 * time.Millisecond)
+	if err := d.Publish(ctx, msg); err != nil {
+		t.Errorf("publish after window: %v", err)
+	}
+}
+
+func TestDedupPublisherPersistedAcrossRestart(t *testing.T) {
+	ctx := context.Background()
+	store := newMemDedupStore()
+	msg := AnalysisMessage{TenantID: uuid.New(), IdempotencyKey: "fp-1"}
+
+	first := newDedupPublisher(&countingPublisher{}, store, time.Hour, 10)
+	if err := first.Publish(ctx, msg); err != nil {
+		t.Fatal(err)
+	}
+
+	// A fresh process has an empty cache, the store still knows the key
+	next := &countingPublisher{}
+	restarted := newDedupPublisher(next, store, time.Hour, 10)
+	if err := restarted.Publish(ctx, msg); !errors.Is(err, ErrDuplicate) {
+		t.Errorf("replay after restart: got %v, want ErrDuplicate", err)
+	}
+	if len(next.published) != 0 {
+		t.Errorf("replay was published")
+	}
+}
+
+func TestDedupPublisherReleasesFailedPublish(t *testing.T) {
+	ctx := context.Background()
+	next := &countingPublisher{err: errors.New("broker down")}
+	d := newDedupPublisher(next, newMemDedupStore(), time.Hour, 10)
+	msg := AnalysisMessage{TenantID: uuid.New(), IdempotencyKey: "fp-1"}
+
+	if err := d.Publish(ctx, msg); err == nil || errors.Is(err, ErrDuplicate) {
+		t.Fatalf("got %v, want publish error", err)
+	}
+
+	// The retry must go through once the broker is back
+	next.err = nil
+	if err := d.Publish(ctx, msg); err != nil {
+		t.Errorf("retry: %v", err)
+	}
+	if len(next.published) != 1 {
+		t.Errorf("published %d messages, want 1", len(next.published))
+	}
+}
+
+func TestDedupPublisherCacheBounded(t *testing.T) {
+	ctx := context.Background()
+	tenant := uuid.New()
+	d := newDedupPublisher(&countingPublisher{}, nil, time.Hour, 3)
+
+	for _, key := range []string{"a", "b", "c", "d"} {
+		if err := d.Publish(ctx, AnalysisMessage{TenantID: tenant, IdempotencyKey: key}); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if len(d.seen) != 3 {
+		t.Errorf("cache holds %d keys, want 3", len(d.seen))
+	}
+
+	// "a" was evicted (memory-only mode forgets it), "d" is still known
+	if err := d.Publish(ctx, AnalysisMessage{TenantID: tenant, IdempotencyKey: "a"}); err != nil {
+		t.Errorf("evicted key: %v", err)
+	}
+	if err := d.Publish(ctx, AnalysisMessage{TenantID: tenant, IdempotencyKey: "d"}); !errors.Is(err, ErrDuplicate) {
+		t.Errorf("cached key: got %v, want ErrDuplicate", err)
+	}
+}
+
+func TestDedupStats(t *testing.T) {
+	s := &Service{emailsDiscovered: 6, emailsDuplicate: 3, fingerprintMatches: 1, emailsDeduplicated: 2}
+	got := s.dedupStats()
+	want := DedupStats{Stored: 6, Duplicates: 3, FingerprintMatches: 1, QueueDuplicates: 2, Ratio: 0.4}
+	if got != want {
+		t.Errorf("dedupStats() = %+v, want %+v", got, want)
+	}
+	if got := (&Service{}).dedupStats(); got.Ratio != 0 {
+		t.Errorf("ratio without deliveries = %v, want 0", got.Ratio)
+	}
+}
@@ -0,0 +1,154 @@
+package discovery
+
+import (
+	"fmt"
+	"io"
+	"runtime"
+	"sort"
+	"sync/atomic"
+	"text/tabwriter"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/stoik/vigil/services/discovery-service/internal/telemetry"
+)
+
+// DumpState writes a point-in-time report of the pipeline's internal state
+// Intended for diagnosing a pipeline that appears stuck (triggered by SIGUSR1)
+func (s *Service) DumpState(w io.Writer) {
+	type userState struct {
+		id        uuid.UUID
+		email     string
+		lastPoll  time.Time
+		breached  bool // Past the mailbox SLA
+		buffered  int
+		bufferCap int
+	}
+
+	breached := make(map[uuid.UUID]bool)
+	for _, b := range s.MailboxSLA().Breaches {
+		breached[b.UserID] = true
+	}
+
+	var users []userState
+	bufferedTotal := 0
+	for id, poller := range s.pollerStates() {
+		value, ok := s.activeUsers.Load(id)
+		if !ok {
+			continue // Stopped since the snapshot
+		}
+		ued := value.(*userEmailDiscovery)
+		st := userState{
+			id:        id,
+			email:     ued.user.Email,
+			lastPoll:  poller.lastPoll,
+			breached:  breached[id],
+			buffered:  len(ued.channel),
+			bufferCap: cap(ued.channel),
+		}
+		bufferedTotal += st.buffered
+		users = append(users, st)
+	}
+
+	// Fullest buffers first, they are the likely stall points
+	sort.Slice(users, func(i, j int) bool {
+		if users[i].buffered != users[j].buffered {
+			return users[i].buffered > users[j].buffered
+		}
+		return users[i].email < users[j].email
+	})
+
+	now := time.Now()
+	fmt.Fprintf(w, "=== Vigil discovery state dump (%s) ===\n", now.Format(time.RFC3339))
+	fmt.Fprintf(w, "Tenant:               %s\n", s.tenantID)
+	fmt.Fprintf(w, "Active users:         %d\n", len(users))
+	fmt.Fprintf(w, "Fan-in channels:      %d\n", atomic.LoadInt64(&s.fanInSize))
+	fmt.Fprintf(w, "Buffered emails:      %d\n", bufferedTotal)
+	fmt.Fprintf(w, "Processing in flight: %d\n", atomic.LoadInt64(&s.processingInFlight))
+	orderedTasks, orderedUsers := s.ordered.pending()
+	fmt.Fprintf(w, "Waiting in user order: %d (%d users)\n", orderedTasks, orderedUsers)
+	fmt.Fprintf(w, "Goroutines:           %d\n", runtime.NumGoroutine())
+	fmt.Fprintf(w, "Discovered / Queued:  %d / %d\n",
+		atomic.LoadInt64(&s.emailsDiscovered), atomic.LoadInt64(&s.emailsToQueue))
+	fmt.Fprintln(w)
+
+	tw := tabwriter.NewWriter(w, 0, 0, 2, ' ', 0)
+	fmt.Fprintln(tw, "USER\tEMAIL\tLAST POLL\tSLA\tBUFFER")
+	for _, u := range users {
+		lastPoll := "never"
+		if !u.lastPoll.IsZero() {
+			lastPoll = fmt.Sprintf("%s ago", now.Sub(u.lastPoll).Round(time.Second))
+		}
+		sla := "ok"
+		if u.breached {
+			sla = "BREACHED"
+		}
+		fmt.Fprintf(tw, "%s\t%s\t%s\t%s\t%d/%d\n", u.id, telemetry.Redact(u.email), lastPoll, sla, u.buffered, u.bufferCap)
+	}
+	tw.Flush()
+}
+
+// Stats is a snapshot of pipeline counters, used by health/debug endpoints and soak tests
+type Stats struct {
+	TenantID           uuid.UUID `json:"tenant_id"`
+	ActiveUsers        int       `json:"active_users"`
+	FanInChannels      int64     `json:"fan_in_channels"`
+	BufferedEmails     int       `json:"buffered_emails"`
+	ProcessingInFlight int64     `json:"processing_in_flight"`
+	EmailsDiscovered   int64     `json:"emails_discovered"`
+	EmailsQueued       int64     `json:"emails_queued"`
+	EmailsPrioritized  int64     `json:"emails_prioritized"`
+	EmailsReported     int64     `json:"emails_reported"` // Submitted by users (POST /reports)
+	EmailsDeduplicated int64     `json:"emails_deduplicated"`
+	Goroutines         int       `json:"goroutines"`
+	// Latest capacity evaluation; saturated / recommended_replicas are the autoscaling hints
+	Capacity CapacityReport `json:"capacity"`
+	// Deliveries deduplicated at storage and at the analysis queue
+	Dedup DedupStats `json:"dedup"`
+	// Analysis queue batches published (sizes, failures, compression)
+	Queue QueueStats `json:"queue"`
+	// Database availability and emails spilled while it is down
+	Readiness Readiness `json:"readiness"`
+	// Maintenance windows and the one in force
+	Maintenance MaintenanceState `json:"maintenance"`
+	// Polls over polling.max_emails_per_poll since start, and the backlogs being released
+	PollsCapped int64      `json:"polls_capped"`
+	Backfills   []Backfill `json:"backfills"`
+	// Mailboxes past the per-user discovery SLA (counts only, GET /sla lists them)
+	MailboxSLA MailboxSLA `json:"mailbox_sla"`
+	// Per-stage p50/p95/p99 latency from provider received_at, over the last LatencyWindowSize
+	// emails (discovery, store, queue, priority); stages without samples are omitted
+	Latency map[string]LatencySummary `json:"latency"`
+}
+
+// Stats returns a point-in-time snapshot of pipeline counters
+func (s *Service) Stats() Stats {
+	stats := Stats{
+		TenantID:           s.tenantID,
+		FanInChannels:      atomic.LoadInt64(&s.fanInSize),
+		ProcessingInFlight: atomic.LoadInt64(&s.processingInFlight),
+		EmailsDiscovered:   atomic.LoadInt64(&s.emailsDiscovered),
+		EmailsQueued:       atomic.LoadInt64(&s.emailsToQueue),
+		EmailsPrioritized:  atomic.LoadInt64(&s.emailsPrioritized),
+		EmailsReported:     atomic.LoadInt64(&s.emailsReported),
+		EmailsDeduplicated: atomic.LoadInt64(&s.emailsDeduplicated),
+		Goroutines:         runtime.NumGoroutine(),
+		Capacity:           s.capacity.latest(),
+		Dedup:              s.dedupStats(),
+		Queue:              s.queueStats(),
+		Readiness:          s.Readiness(),
+		Maintenance:        s.Maintenance(),
+		PollsCapped:        atomic.LoadInt64(&s.pollsCapped),
+		Backfills:          s.Backfills(),
+		MailboxSLA:         s.MailboxSLA(),
+		Latency:            s.latency.summaries(),
+	}
+	stats.MailboxSLA.Breaches = nil
+	s.activeUsers.Range(func(key, value interface{}) bool {
+		ued := value.(*userEmailDiscovery)
+		stats.ActiveUsers++
+		stats.BufferedEmails += len(ued.channel)
+		return true
+	})
+	return stats
+}
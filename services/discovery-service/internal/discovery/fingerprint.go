@@ -0,0 +1,200 @@
+package discovery
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"hash"
+	"strings"
+	"sync"
+
+	"github.com/cespare/xxhash/v2"
+	"github.com/stoik/vigil/internal/models"
+	"lukechampine.com/blake3"
+)
+
+// BodyMode controls whether full email bodies are fetched from the provider
+type BodyMode string
+
+const (
+	BodyModeFull    BodyMode = "full"    // Fetch bodies, fingerprint the body
+	BodyModeSnippet BodyMode = "snippet" // Fetch metadata only, fingerprint headers + snippet
+)
+
+// ParseBodyMode parses an ingest.body_mode value (empty means full)
+func ParseBodyMode(value string) (BodyMode, error) {
+	switch BodyMode(value) {
+	case "", BodyModeFull:
+		return BodyModeFull, nil
+	case BodyModeSnippet:
+		return BodyModeSnippet, nil
+	default:
+		return "", fmt.Errorf("unknown body mode %q (use %q or %q)", value, BodyModeFull, BodyModeSnippet)
+	}
+}
+
+// metadataFingerprintHeaders identify a message independently of its delivery path
+// (Received, Authentication-Results... differ between copies of the same email)
+var metadataFingerprintHeaders = []string{"Message-ID", "Date", "In-Reply-To", "References"}
+
+// metadataFingerprintPrefixes are the hashed "<lowercased header>:" prefixes, computed once
+var metadataFingerprintPrefixes = func() []string {
+	prefixes := make([]string, len(metadataFingerprintHeaders))
+	for i, name := range metadataFingerprintHeaders {
+		prefixes[i] = strings.ToLower(name) + ":"
+	}
+	return prefixes
+}()
+
+// FingerprintAlgorithm is the hash function behind fingerprints (ingest.fingerprint)
+type FingerprintAlgorithm string
+
+const (
+	FingerprintSHA256 FingerprintAlgorithm = "sha256" // Bare hex digest, the original format
+	FingerprintBLAKE3 FingerprintAlgorithm = "blake3" // "blake3:<hex>", 256 bits, several times faster on large bodies
+	// "xxhash:<hex>", 64 bits and not collision resistant: a crafted email could share the
+	// fingerprint of an analyzed one and be deduplicated without analysis
+	FingerprintXXHash FingerprintAlgorithm = "xxhash"
+)
+
+// ParseFingerprintAlgorithm parses an ingest.fingerprint value (empty means sha256)
+func ParseFingerprintAlgorithm(value string) (FingerprintAlgorithm, error) {
+	switch a := FingerprintAlgorithm(strings.ToLower(value)); a {
+	case "":
+		return FingerprintSHA256, nil
+	case FingerprintSHA256, FingerprintBLAKE3, FingerprintXXHash:
+		return a, nil
+	default:
+		return "", fmt.Errorf("unknown fingerprint algorithm %q (use %q, %q or %q)", value, FingerprintSHA256, FingerprintBLAKE3, FingerprintXXHash)
+	}
+}
+
+// fingerprintHash pools the hashers of an algorithm
+// Fingerprints other than SHA256 carry an "<algorithm>:" prefix, so fingerprints of different
+// algorithms never collide in a table holding both
+type fingerprintHash struct {
+	prefix string
+	pool   sync.Pool
+}
+
+func newFingerprintHash(prefix string, newHash func() hash.Hash) *fingerprintHash {
+	f := &fingerprintHash{prefix: prefix}
+	f.pool.New = func() any { return &hasher{h: newHash(), owner: f} }
+	return f
+}
+
+var fingerprintHashes = map[FingerprintAlgorithm]*fingerprintHash{
+	FingerprintSHA256: newFingerprintHash("", sha256.New),
+	FingerprintBLAKE3: newFingerprintHash("blake3:", func() hash.Hash { return blake3.New(32, nil) }),
+	FingerprintXXHash: newFingerprintHash("xxhash:", func() hash.Hash { return xxhash.New() }),
+}
+
+// hasher gets a pooled hasher of the algorithm (SHA256 if unknown)
+func (a FingerprintAlgorithm) hasher() *hasher {
+	f, ok := fingerprintHashes[a]
+	if !ok {
+		f = fingerprintHashes[FingerprintSHA256]
+	}
+	return f.pool.Get().(*hasher)
+}
+
+// hasher streams strings into a digest through a fixed buffer, so hashing a body does not
+// copy it into a body-sized []byte. Hashers are pooled across emails.
+type hasher struct {
+	h     hash.Hash
+	owner *fingerprintHash
+	buf   [4 << 10]byte
+}
+
+func (h *hasher) writeString(s string) {
+	for len(s) > 0 {
+		n := copy(h.buf[:], s)
+		h.h.Write(h.buf[:n])
+		s = s[n:]
+	}
+}
+
+// sum returns the prefixed hex-encoded digest and puts the hasher back in the pool
+func (h *hasher) sum() string {
+	var sum [sha256.Size]byte
+	var encoded [2 * sha256.Size]byte
+	digest := h.h.Sum(sum[:0])
+	n := hex.Encode(encoded[:], digest)
+	h.h.Reset()
+	h.owner.pool.Put(h)
+	return h.owner.prefix + string(encoded[:n])
+}
+
+// Fingerprint identifies an email by its body content (hex-encoded SHA256)
+// Identical bodies delivered under different message IDs share a fingerprint,
+// which is what dedup relies on
+func Fingerprint(body string) string {
+	return FingerprintSHA256.Fingerprint(body)
+}
+
+// Fingerprint fingerprints a body with the algorithm
+func (a FingerprintAlgorithm) Fingerprint(body string) string {
+	h := a.hasher()
+	h.writeString(body)
+	return h.sum()
+}
+
+// FingerprintEmail fingerprints an email with SHA256 according to the ingest body mode
+func FingerprintEmail(email models.ProviderEmail, mode BodyMode) string {
+	return FingerprintSHA256.FingerprintEmail(email, mode)
+}
+
+// FingerprintEmail fingerprints an email according to the ingest body mode
+// In snippet mode there is no body: sender, subject, snippet and identity headers are used
+// instead, so re-deliveries of the same message still share a fingerprint
+func (a FingerprintAlgorithm) FingerprintEmail(email models.ProviderEmail, mode BodyMode) string {
+	if mode != BodyModeSnippet {
+		return a.Fingerprint(email.Body)
+	}
+
+	// Hashes "from:..\nsubject:..\nsnippet:..\n" then "<header>:<v1>,<v2>\n" per identity
+	// header, streamed piece by piece (fingerprints are persisted, the input must not change)
+	h := a.hasher()
+	for _, field := range [...][2]string{{"from:", email.From}, {"\nsubject:", email.Subject}, {"\nsnippet:", email.Snippet}} {
+		h.writeString(field[0])
+		h.writeString(field[1])
+	}
+	h.writeString("\n")
+	for i, name := range metadataFingerprintHeaders {
+		if values, ok := email.Headers[name]; ok {
+			h.writeString(metadataFingerprintPrefixes[i])
+			for j, value := range values {
+				if j > 0 {
+					h.writeString(",")
+				}
+				h.writeString(value)
+			}
+			h.writeString("\n")
+		}
+	}
+	return h.sum()
+}
+
+// fingerprints returns an email's fingerprint followed by its fingerprints under the previous
+// algorithms (ingest.fingerprint_previous), then under the previous mode
+// (ingest.fingerprint_mode_previous) with each algorithm
+//
+// Switching algorithms leaves a mixed table: stored emails keep the fingerprint they were stored
+// with (bodies are not kept; a refingerprint job refetches them to rehash). While earlier algorithms are listed,
+// dedup also matches their fingerprints, at the cost of hashing each email once per algorithm.
+// They can be dropped once emails stored before the switch no longer get delivered again.
+// Switching modes (snippet policies need snippet fingerprints) works the same way; emails fetched
+// without a body have no body fingerprint to match.
+func (s *Service) fingerprints(email models.ProviderEmail, fingerprint string) []string {
+	fingerprints := []string{fingerprint}
+	for _, alg := range s.previousFingerprints {
+		fingerprints = append(fingerprints, alg.FingerprintEmail(email, s.fingerprintMode))
+	}
+	if s.previousFingerprintMode == BodyModeSnippet || (s.previousFingerprintMode == BodyModeFull && email.Body != "") {
+		fingerprints = append(fingerprints, s.fingerprintAlg.FingerprintEmail(email, s.previousFingerprintMode))
+		for _, alg := range s.previousFingerprints {
+			fingerprints = append(fingerprints, alg.FingerprintEmail(email, s.previousFingerprintMode))
+		}
+	}
+	return fingerprints
+}
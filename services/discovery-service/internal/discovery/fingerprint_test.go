@@ -0,0 +1,187 @@
+package discovery
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"strings"
+	"testing"
+	"testing/quick"
+
+	"github.com/stoik/vigil/internal/models"
+)
+
+func TestFingerprintStable(t *testing.T) {
+	// Same body always yields the same fingerprint
+	f := func(body string) bool {
+		return Fingerprint(body) == Fingerprint(string([]byte(body)))
+	}
+	if err := quick.Check(f, nil); err != nil {
+		t.Error(err)
+	}
+}
+
+func TestFingerprintDistinguishesBodies(t *testing.T) {
+	f := func(a, b string) bool {
+		if a == b {
+			return Fingerprint(a) == Fingerprint(b)
+		}
+		return Fingerprint(a) != Fingerprint(b)
+	}
+	if err := quick.Check(f, nil); err != nil {
+		t.Error(err)
+	}
+}
+
+func TestFingerprintKnownValue(t *testing.T) {
+	// Fingerprints are persisted, so the encoding must never change
+	got := Fingerprint("hello")
+	want := "2cf24dba5fb0a30e26e83b2ac5b9e29e1b161e5c1fa7425e73043362938b9824"
+	if got != want {
+		t.Fatalf("Fingerprint(%q) = %s, want %s", "hello", got, want)
+	}
+}
+
+func FuzzFingerprint(f *testing.F) {
+	f.Add("")
+	f.Add("Dear John,\n\nBest regards,\nThe Mock Server")
+	f.Add("\x00\xffé")
+
+	f.Fuzz(func(t *testing.T, body string) {
+		fp := Fingerprint(body)
+		// Must fit the emails.fingerprint VARCHAR(64) column
+		if len(fp) != 64 {
+			t.Fatalf("fingerprint length = %d, want 64", len(fp))
+		}
+		if _, err := hex.DecodeString(fp); err != nil {
+			t.Fatalf("fingerprint is not hex: %v", err)
+		}
+		if Fingerprint(body) != fp {
+			t.Fatalf("fingerprint not stable for %q", body)
+		}
+	})
+}
+
+func TestFingerprintEmailSnippetMode(t *testing.T) {
+	email := models.ProviderEmail{
+		MessageID: "a",
+		From:      "sender@example.com",
+		Subject:   "Invoice",
+		Snippet:   "Please find attached",
+		Headers: map[string][]string{
+			"Message-ID": {"<1@example.com>"},
+			"Received":   {"from mx1"},
+		},
+	}
+
+	// Re-delivery under a new provider ID and path still dedups
+	redelivered := email
+	redelivered.MessageID = "b"
+	redelivered.Headers = map[string][]string{
+		"Message-ID": {"<1@example.com>"},
+		"Received":   {"from mx2"},
+	}
+	if FingerprintEmail(email, BodyModeSnippet) != FingerprintEmail(redelivered, BodyModeSnippet) {
+		t.Error("re-delivered email has a different snippet-mode fingerprint")
+	}
+
+	other := email
+	other.Snippet = "Please wire the funds"
+	if FingerprintEmail(email, BodyModeSnippet) == FingerprintEmail(other, BodyModeSnippet) {
+		t.Error("different snippets share a fingerprint")
+	}
+
+	// Full mode fingerprints the body only
+	email.Body = "body"
+	if FingerprintEmail(email, BodyModeFull) != Fingerprint("body") {
+		t.Error("full-mode fingerprint differs from body fingerprint")
+	}
+}
+
+func TestFingerprintEmailSnippetModeKnownInput(t *testing.T) {
+	// Snippet-mode fingerprints are persisted: the hashed input must stay byte-identical
+	email := models.ProviderEmail{
+		From:    "sender@example.com",
+		Subject: "Invoice",
+		Snippet: "Please find attached",
+		Headers: map[string][]string{
+			"Message-ID": {"<1@example.com>"},
+			"References": {"<a@example.com>", "<b@example.com>"},
+			"Received":   {"from mx1"},
+		},
+	}
+	want := Fingerprint("from:sender@example.com\nsubject:Invoice\nsnippet:Please find attached\n" +
+		"message-id:<1@example.com>\nreferences:<a@example.com>,<b@example.com>\n")
+	if got := FingerprintEmail(email, BodyModeSnippet); got != want {
+		t.Errorf("FingerprintEmail() = %s, want %s", got, want)
+	}
+}
+
+func TestFingerprintLargeBody(t *testing.T) {
+	// Bodies larger than the hashing buffer are streamed in chunks
+	body := strings.Repeat("0123456789abcdef", 1<<10) + "tail"
+	sum := sha256.Sum256([]byte(body))
+	if got, want := Fingerprint(body), hex.EncodeToString(sum[:]); got != want {
+		t.Errorf("Fingerprint() = %s, want %s", got, want)
+	}
+}
+
+func TestFingerprintAlgorithms(t *testing.T) {
+	// Fingerprints are persisted, so each algorithm's encoding must never change
+	tests := []struct {
+		alg  FingerprintAlgorithm
+		want string
+	}{
+		{FingerprintSHA256, "2cf24dba5fb0a30e26e83b2ac5b9e29e1b161e5c1fa7425e73043362938b9824"},
+		{FingerprintBLAKE3, "blake3:ea8f163db38682925e4491c5e58d4bb3506ef8c14eb78a86e908c5624a67200f"},
+		{FingerprintXXHash, "xxhash:26c7827d889f6da3"},
+	}
+	for _, tt := range tests {
+		if got := tt.alg.Fingerprint("hello"); got != tt.want {
+			t.Errorf("%s fingerprint = %s, want %s", tt.alg, got, tt.want)
+		}
+		// Streamed in chunks, pooled hashers are reset
+		body := strings.Repeat("0123456789abcdef", 1<<10)
+		if first, again := tt.alg.Fingerprint(body), tt.alg.Fingerprint(body); first != again || len(first) > 80 {
+			t.Errorf("%s fingerprint of a large body = %s then %s, want stable and at most 80 chars", tt.alg, first, again)
+		}
+		if parsed, err := ParseFingerprintAlgorithm(strings.ToUpper(string(tt.alg))); err != nil || parsed != tt.alg {
+			t.Errorf("ParseFingerprintAlgorithm(%q) = %q, %v", tt.alg, parsed, err)
+		}
+	}
+	if _, err := ParseFingerprintAlgorithm("md5"); err == nil {
+		t.Error("ParseFingerprintAlgorithm(md5) succeeded")
+	}
+}
+
+func TestFingerprintsWithPreviousAlgorithms(t *testing.T) {
+	// After a switch from SHA256, dedup still matches emails stored under SHA256
+	s := &Service{fingerprintAlg: FingerprintBLAKE3, previousFingerprints: []FingerprintAlgorithm{FingerprintSHA256}}
+	email := models.ProviderEmail{Body: "hello"}
+	got := s.fingerprints(email, s.fingerprintAlg.FingerprintEmail(email, s.fingerprintMode))
+	if len(got) != 2 || got[0] != FingerprintBLAKE3.Fingerprint("hello") || got[1] != Fingerprint("hello") {
+		t.Errorf("fingerprints() = %v, want the BLAKE3 then the SHA256 fingerprint", got)
+	}
+}
+
+func TestFingerprintsWithPreviousMode(t *testing.T) {
+	// After a switch from body to snippet fingerprints, dedup still matches emails stored by body
+	s := &Service{fingerprintAlg: FingerprintBLAKE3, previousFingerprints: []FingerprintAlgorithm{FingerprintSHA256},
+		fingerprintMode: BodyModeSnippet, previousFingerprintMode: BodyModeFull}
+	email := models.ProviderEmail{From: "a@example.com", Subject: "hi", Snippet: "hello", Body: "hello"}
+	got := s.fingerprints(email, s.fingerprintAlg.FingerprintEmail(email, s.fingerprintMode))
+	want := []string{
+		FingerprintBLAKE3.FingerprintEmail(email, BodyModeSnippet),
+		FingerprintSHA256.FingerprintEmail(email, BodyModeSnippet),
+		FingerprintBLAKE3.Fingerprint("hello"),
+		Fingerprint("hello"),
+	}
+	if strings.Join(got, ",") != strings.Join(want, ",") {
+		t.Errorf("fingerprints() = %v, want %v", got, want)
+	}
+
+	// A snippet email has no body fingerprint to match
+	email.Body = ""
+	if got := s.fingerprints(email, s.fingerprintAlg.FingerprintEmail(email, s.fingerprintMode)); len(got) != 2 {
+		t.Errorf("fingerprints() of a snippet email = %v, want the snippet fingerprints only", got)
+	}
+}
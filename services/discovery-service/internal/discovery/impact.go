@@ -0,0 +1,138 @@
+package discovery
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/jackc/pgx/v5"
+	"github.com/stoik/vigil/services/discovery-service/internal/db"
+)
+
+// MaxRemediationLength bounds a remediation action name
+const MaxRemediationLength = 32
+
+// ErrInvalidRemediation is returned when a remediation action is empty or too long
+var ErrInvalidRemediation = errors.New("invalid remediation")
+
+// Impact is the blast radius of a stored email: every monitored mailbox holding a copy
+// Emails are stored once per fingerprint, so one campaign delivered to many users is one email
+type Impact struct {
+	EmailID      uuid.UUID      `json:"email_id"`
+	Fingerprint  string         `json:"fingerprint"`
+	ReceivedAt   time.Time      `json:"received_at"`
+	SenderDomain string         `json:"sender_domain,omitempty"`
+	DetectedAt   *time.Time     `json:"detected_at,omitempty"`
+	Recipients   int            `json:"recipients"`
+	Remediated   int            `json:"remediated"` // Copies remediated
+	Reported     int            `json:"reported"`   // Recipients who reported it
+	Users        []ImpactedUser `json:"users"`
+}
+
+// ImpactedUser is a mailbox holding a copy of an email, and what was done about it
+type ImpactedUser struct {
+	UserID       uuid.UUID  `json:"user_id"`
+	Email        string     `json:"email"`
+	ReportedAt   *time.Time `json:"reported_at,omitempty"`
+	RemediatedAt *time.Time `json:"remediated_at,omitempty"`
+	Remediation  string     `json:"remediation,omitempty"` // e.g. "deleted", "quarantined"
+}
+
+// EmailImpact lists the users holding a copy of an email, by email ID or fingerprint
+// With outstanding, only copies not remediated yet are listed (the counts cover every copy)
+func (s *Service) EmailImpact(ctx context.Context, ref string, outstanding bool) (Impact, error) {
+	query := `SELECT id, fingerprint, received_at, sender_domain, detected_at FROM emails WHERE fingerprint = $1`
+	var arg any = strings.TrimSpace(ref)
+	if id, err := uuid.Parse(ref); err == nil {
+		query = `SELECT id, fingerprint, received_at, sender_domain, detected_at FROM emails WHERE id = $1`
+		arg = id
+	}
+	var impact Impact
+	var senderDomain *string
+	err := db.ReadPool.QueryRow(ctx, query, arg).Scan(&impact.EmailID, &impact.Fingerprint, &impact.ReceivedAt, &senderDomain, &impact.DetectedAt)
+	if errors.Is(err, pgx.ErrNoRows) {
+		return impact, ErrEmailNotFound
+	}
+	if err != nil {
+		return impact, fmt.Errorf("failed to get email: %w", err)
+	}
+	if senderDomain != nil {
+		impact.SenderDomain = *senderDomain
+	}
+
+	rows, err := db.ReadPool.Query(ctx, `
+		SELECT u.id, u.email, ue.remediated_at, ue.remediation,
+		       (SELECT MIN(r.reported_at) FROM reports r WHERE r.email_id = ue.email_id AND r.user_id = ue.user_id)
+		FROM user_emails ue
+		JOIN users u ON u.id = ue.user_id
+		WHERE ue.email_id = $1
+		ORDER BY u.email`,
+		impact.EmailID,
+	)
+	if err != nil {
+		return impact, fmt.Errorf("failed to list recipients: %w", err)
+	}
+	defer rows.Close()
+
+	impact.Users = []ImpactedUser{}
+	for rows.Next() {
+		var u ImpactedUser
+		var remediation *string
+		if err := rows.Scan(&u.UserID, &u.Email, &u.RemediatedAt, &remediation, &u.ReportedAt); err != nil {
+			return impact, fmt.Errorf("failed to scan recipient: %w", err)
+		}
+		if remediation != nil {
+			u.Remediation = *remediation
+		}
+		impact.Recipients++
+		if u.ReportedAt != nil {
+			impact.Reported++
+		}
+		if u.RemediatedAt != nil {
+			impact.Remediated++
+			if outstanding {
+				continue
+			}
+		}
+		impact.Users = append(impact.Users, u)
+	}
+	if err := rows.Err(); err != nil {
+		return impact, fmt.Errorf("failed to list recipients: %w", err)
+	}
+	return impact, nil
+}
+
+// RecordRemediation marks a user's copy of an email as remediated with the given action
+// user is a user ID or mailbox address; ErrEmailNotFound is returned if the user holds no copy
+func (s *Service) RecordRemediation(ctx context.Context, emailID uuid.UUID, user, action string) (ImpactedUser, error) {
+	action = strings.TrimSpace(action)
+	if action == "" || len(action) > MaxRemediationLength {
+		return ImpactedUser{}, fmt.Errorf("%w: action must be 1 to %d characters", ErrInvalidRemediation, MaxRemediationLength)
+	}
+
+	userFilter := `u.email = $3`
+	var userArg any = user
+	if id, err := uuid.Parse(user); err == nil {
+		userFilter, userArg = `u.id = $3`, id
+	}
+	// Remediating again keeps the first time, with the latest action
+	var u ImpactedUser
+	err := db.Pool.QueryRow(ctx, `
+		UPDATE user_emails ue
+		SET remediated_at = COALESCE(ue.remediated_at, NOW()), remediation = $2
+		FROM users u
+		WHERE u.id = ue.user_id AND ue.email_id = $1 AND `+userFilter+`
+		RETURNING u.id, u.email, ue.remediated_at, ue.remediation`,
+		emailID, action, userArg,
+	).Scan(&u.UserID, &u.Email, &u.RemediatedAt, &u.Remediation)
+	if errors.Is(err, pgx.ErrNoRows) {
+		return u, ErrEmailNotFound
+	}
+	if err != nil {
+		return u, fmt.Errorf("failed to record remediation: %w", err)
+	}
+	return u, nil
+}
@@ -0,0 +1,57 @@
+package discovery
+
+import (
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/stoik/vigil/internal/models"
+)
+
+func TestEmailImpact(t *testing.T) {
+	ctx := setupTestDB(t)
+	users := insertTestUsers(t, ctx, 3)
+
+	// One campaign delivered to every user is stored once
+	s := &Service{}
+	email := models.ProviderEmail{MessageID: uuid.NewString(), From: "billing@c0mpany.example", ReceivedAt: time.Now(), Body: "Invoice overdue"}
+	fingerprint := Fingerprint(email.Body)
+	for _, userID := range users {
+		if _, err := s.storeEmail(ctx, email, fingerprint, userID); err != nil {
+			t.Fatalf("storeEmail() = %v", err)
+		}
+	}
+	emailID := uuid.MustParse(email.MessageID)
+
+	if _, err := s.RecordRemediation(ctx, emailID, "user1@example.com", " "); !errors.Is(err, ErrInvalidRemediation) {
+		t.Errorf("RecordRemediation() with no action = %v, want ErrInvalidRemediation", err)
+	}
+	if _, err := s.RecordRemediation(ctx, emailID, "nobody@example.com", "deleted"); !errors.Is(err, ErrEmailNotFound) {
+		t.Errorf("RecordRemediation() for a user without a copy = %v, want ErrEmailNotFound", err)
+	}
+	remediated, err := s.RecordRemediation(ctx, emailID, "user1@example.com", "deleted")
+	if err != nil || remediated.UserID != users[1] || remediated.RemediatedAt == nil || remediated.Remediation != "deleted" {
+		t.Fatalf("RecordRemediation() = %+v, %v", remediated, err)
+	}
+
+	impact, err := s.EmailImpact(ctx, fingerprint, false)
+	if err != nil {
+		t.Fatalf("EmailImpact() = %v", err)
+	}
+	if impact.EmailID != emailID || impact.SenderDomain != "c0mpany.example" || impact.Recipients != 3 || impact.Remediated != 1 || len(impact.Users) != 3 {
+		t.Errorf("impact = %+v, want 3 recipients, 1 remediated", impact)
+	}
+
+	outstanding, err := s.EmailImpact(ctx, emailID.String(), true)
+	if err != nil {
+		t.Fatalf("EmailImpact(outstanding) = %v", err)
+	}
+	if outstanding.Recipients != 3 || len(outstanding.Users) != 2 || outstanding.Users[0].UserID != users[0] || outstanding.Users[1].UserID != users[2] {
+		t.Errorf("outstanding users = %+v, want user0 and user2", outstanding.Users)
+	}
+
+	if _, err := s.EmailImpact(ctx, uuid.NewString(), false); !errors.Is(err, ErrEmailNotFound) {
+		t.Errorf("EmailImpact() of an unknown email = %v, want ErrEmailNotFound", err)
+	}
+}
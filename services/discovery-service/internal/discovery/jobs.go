@@ -0,0 +1,178 @@
+package discovery
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log"
+	"sync"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/stoik/vigil/services/discovery-service/internal/jobs"
+)
+
+// Bulk operations
+const (
+	JobPauseUsers    = "pause_users"    // Stop polling users until resumed
+	JobResumeUsers   = "resume_users"   // Resume polling paused users
+	JobPollUsers     = "poll_users"     // Poll users now instead of at their next tick
+	JobRefingerprint = "refingerprint"  // Recompute the fingerprint of emails received in a range
+	JobRequeueFailed = "requeue_failed" // Publish again emails whose analysis queue publication failed
+)
+
+const (
+	MaxJobUsers = 10000 // Users per user operation
+
+	maxQueuedJobs      = 16               // Jobs waiting on one instance
+	jobEmailBatchSize  = 100              // Emails listed per query by email operations
+	jobProviderTimeout = 30 * time.Second // Bound on one item's provider calls
+)
+
+var (
+	// ErrInvalidJob is returned when a job request is malformed
+	ErrInvalidJob = errors.New("invalid job")
+	// ErrTooManyJobs is returned when this instance already has maxQueuedJobs waiting
+	ErrTooManyJobs = errors.New("too many jobs queued")
+)
+
+// JobRequest describes a bulk operation
+// User operations take Users (user IDs or mailbox addresses); email operations take the
+// [From, To) range of received_at, required by refingerprint and optional for requeue_failed
+type JobRequest struct {
+	Operation string     `json:"operation"`
+	Users     []string   `json:"users,omitempty"`
+	From      *time.Time `json:"from,omitempty"`
+	To        *time.Time `json:"to,omitempty"`
+}
+
+// validate checks the request of an operation
+func (r *JobRequest) validate() error {
+	switch r.Operation {
+	case JobPauseUsers, JobResumeUsers, JobPollUsers:
+		if len(r.Users) == 0 || len(r.Users) > MaxJobUsers {
+			return fmt.Errorf("%w: %s needs 1 to %d users", ErrInvalidJob, r.Operation, MaxJobUsers)
+		}
+		if r.From != nil || r.To != nil {
+			return fmt.Errorf("%w: %s takes users, not a range", ErrInvalidJob, r.Operation)
+		}
+	case JobRefingerprint, JobRequeueFailed:
+		if len(r.Users) > 0 {
+			return fmt.Errorf("%w: %s takes a range, not users", ErrInvalidJob, r.Operation)
+		}
+		if r.Operation == JobRefingerprint && (r.From == nil || r.To == nil) {
+			return fmt.Errorf("%w: %s needs from and to", ErrInvalidJob, r.Operation)
+		}
+		if r.From != nil && r.To != nil && !r.From.Before(*r.To) {
+			return fmt.Errorf("%w: from must be before to", ErrInvalidJob)
+		}
+	default:
+		return fmt.Errorf("%w: unknown operation %q", ErrInvalidJob, r.Operation)
+	}
+	return nil
+}
+
+// queuedJob is a bulk job waiting on this instance
+type queuedJob struct {
+	run     *jobs.Run
+	request JobRequest
+}
+
+// jobRunner runs this instance's bulk jobs one at a time, in submission order
+// Jobs are tracked in the jobs table (see package jobs), so their status can be read and
+// cancelled from any instance. A job whose instance stopped is reported as interrupted and is not
+// resumed.
+type jobRunner struct {
+	service *Service
+	queue   chan queuedJob
+
+	mu     sync.Mutex
+	queued map[uuid.UUID]*jobs.Run // Waiting here, kept alive by heartbeat
+}
+
+func newJobRunner(s *Service) *jobRunner {
+	return &jobRunner{service: s, queue: make(chan queuedJob, maxQueuedJobs), queued: make(map[uuid.UUID]*jobs.Run)}
+}
+
+// SubmitJob validates and queues a bulk operation, returning it in the queued state
+func (s *Service) SubmitJob(ctx context.Context, r JobRequest, createdBy string) (jobs.Job, error) {
+	if err := r.validate(); err != nil {
+		return jobs.Job{}, err
+	}
+	run, err := jobs.Create(ctx, r.Operation, r, createdBy)
+	if err != nil {
+		return jobs.Job{}, err
+	}
+
+	s.jobs.mu.Lock()
+	select {
+	case s.jobs.queue <- queuedJob{run: run, request: r}:
+		s.jobs.queued[run.ID] = run
+		s.jobs.mu.Unlock()
+	default:
+		s.jobs.mu.Unlock()
+		if err := run.Finish(ctx, ErrTooManyJobs); err != nil {
+			log.Printf("Error failing job %s: %v", run.ID, err)
+		}
+		return jobs.Job{}, ErrTooManyJobs
+	}
+	log.Printf("📋 Job %s queued: %s by %s", run.ID, run.Operation, createdBy)
+	return run.Snapshot(), nil
+}
+
+// run executes queued jobs until ctx is done
+func (r *jobRunner) run(ctx context.Context) {
+	go r.heartbeat(ctx)
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case job := <-r.queue:
+			r.mu.Lock()
+			delete(r.queued, job.run.ID)
+			r.mu.Unlock()
+			if err := job.run.Execute(ctx, func(ctx context.Context, run *jobs.Run) error {
+				return r.service.runJob(ctx, run, job.request)
+			}); errors.Is(err, jobs.ErrCancelled) && job.run.StartedAt == nil {
+				log.Printf("📋 Job %s cancelled before it started", job.run.ID)
+			}
+		}
+	}
+}
+
+// heartbeat saves the jobs waiting here, so they are not reported as interrupted
+func (r *jobRunner) heartbeat(ctx context.Context) {
+	ticker := time.NewTicker(jobs.Heartbeat)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+		r.mu.Lock()
+		queued := make([]*jobs.Run, 0, len(r.queued))
+		for _, run := range r.queued {
+			queued = append(queued, run)
+		}
+		r.mu.Unlock()
+		for _, run := range queued {
+			if _, err := run.Save(ctx); err != nil && ctx.Err() == nil {
+				log.Printf("Error saving job %s: %v", run.ID, err)
+			}
+		}
+	}
+}
+
+// runJob runs a bulk operation
+func (s *Service) runJob(ctx context.Context, run *jobs.Run, r JobRequest) error {
+	switch r.Operation {
+	case JobPauseUsers, JobResumeUsers, JobPollUsers:
+		return s.runUserJob(ctx, run, r)
+	case JobRefingerprint:
+		return s.runRefingerprint(ctx, run, r)
+	case JobRequeueFailed:
+		return s.runRequeueFailed(ctx, run, r)
+	}
+	return fmt.Errorf("unknown operation %q", r.Operation)
+}
@@ -0,0 +1,112 @@
+package discovery
+
+import (
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/stoik/vigil/internal/models"
+	"github.com/stoik/vigil/services/discovery-service/internal/db"
+	"github.com/stoik/vigil/services/discovery-service/internal/jobs"
+)
+
+func TestJobRequestValidate(t *testing.T) {
+	from, to := time.Date(2024, 3, 1, 0, 0, 0, 0, time.UTC), time.Date(2024, 3, 2, 0, 0, 0, 0, time.UTC)
+	tests := []struct {
+		name  string
+		r     JobRequest
+		valid bool
+	}{
+		{"pause", JobRequest{Operation: JobPauseUsers, Users: []string{"alice@acme.com"}}, true},
+		{"poll without users", JobRequest{Operation: JobPollUsers}, false},
+		{"too many users", JobRequest{Operation: JobResumeUsers, Users: make([]string, MaxJobUsers+1)}, false},
+		{"users with a range", JobRequest{Operation: JobPauseUsers, Users: []string{"a"}, From: &from}, false},
+		{"refingerprint", JobRequest{Operation: JobRefingerprint, From: &from, To: &to}, true},
+		{"refingerprint without range", JobRequest{Operation: JobRefingerprint, From: &from}, false},
+		{"reversed range", JobRequest{Operation: JobRefingerprint, From: &to, To: &from}, false},
+		{"requeue everything", JobRequest{Operation: JobRequeueFailed}, true},
+		{"requeue with users", JobRequest{Operation: JobRequeueFailed, Users: []string{"a"}}, false},
+		{"unknown", JobRequest{Operation: "delete_users", Users: []string{"a"}}, false},
+	}
+	for _, tt := range tests {
+		err := tt.r.validate()
+		if (err == nil) != tt.valid || err != nil && !errors.Is(err, ErrInvalidJob) {
+			t.Errorf("%s: validate() = %v, want valid %v", tt.name, err, tt.valid)
+		}
+	}
+}
+
+func TestUserJobs(t *testing.T) {
+	ctx := setupTestDB(t)
+	users := insertTestUsers(t, ctx, 2)
+	s := &Service{userCache: newUserCache(time.Minute)}
+
+	run := func(operation string, refs ...string) *jobs.Run {
+		t.Helper()
+		job := &jobs.Run{}
+		if err := s.runUserJob(ctx, job, JobRequest{Operation: operation, Users: refs}); err != nil {
+			t.Fatalf("%s: %v", operation, err)
+		}
+		return job
+	}
+
+	job := run(JobPauseUsers, "USER0@example.com", users[1].String(), "nobody@example.com")
+	if job.Succeeded != 2 || job.Failed != 1 || len(job.Errors) != 1 || job.Errors[0].Item != "nobody@example.com" {
+		t.Errorf("pause = %+v, want 2 paused and the unknown user failed", job.Job)
+	}
+	if user, _ := s.getUserByID(ctx, users[0]); user.PausedAt == nil {
+		t.Error("user0 not paused")
+	}
+	if job := run(JobPauseUsers, users[0].String()); job.Skipped != 1 {
+		t.Errorf("pausing again = %+v, want skipped", job.Job)
+	}
+
+	// Paused users are not polled; others only where this instance polls them
+	pollNow := make(chan struct{}, 1)
+	s.activeUsers.Store(users[1], &userEmailDiscovery{pollNow: pollNow})
+	if job := run(JobPollUsers, users[1].String()); job.Failed != 1 || job.Errors[0].Error != errUserPaused.Error() {
+		t.Errorf("poll of a paused user = %+v", job.Job)
+	}
+	run(JobResumeUsers, users[1].String())
+	if job := run(JobPollUsers, users[1].String(), users[1].String(), users[0].String()); job.Succeeded != 1 || job.Skipped != 1 || job.Failed != 1 {
+		t.Errorf("poll = %+v, want 1 triggered, 1 already pending, 1 paused", job.Job)
+	}
+	if len(pollNow) != 1 {
+		t.Error("poll not triggered")
+	}
+}
+
+func TestSetFingerprintMerges(t *testing.T) {
+	ctx := setupTestDB(t)
+	users := insertTestUsers(t, ctx, 3)
+	s := &Service{}
+
+	// The same email stored under two algorithms' fingerprints
+	old := models.ProviderEmail{MessageID: uuid.NewString(), ReceivedAt: time.Now().Add(-time.Hour), Body: "Invoice overdue"}
+	current := models.ProviderEmail{MessageID: uuid.NewString(), ReceivedAt: time.Now(), Body: "Invoice overdue"}
+	for _, userID := range users[:2] {
+		if _, err := s.storeEmail(ctx, old, Fingerprint(old.Body), userID); err != nil {
+			t.Fatal(err)
+		}
+	}
+	blake3 := FingerprintBLAKE3.Fingerprint(current.Body)
+	for _, userID := range users[1:] {
+		if _, err := s.storeEmail(ctx, current, blake3, userID); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	oldID, currentID := uuid.MustParse(old.MessageID), uuid.MustParse(current.MessageID)
+	if err := setFingerprint(ctx, oldID, blake3); err != nil {
+		t.Fatalf("setFingerprint() = %v", err)
+	}
+	var emails, links int
+	var receivedAt time.Time
+	db.Pool.QueryRow(ctx, `SELECT COUNT(*) FROM emails`).Scan(&emails)
+	db.Pool.QueryRow(ctx, `SELECT COUNT(*) FROM user_emails WHERE email_id = $1`, currentID).Scan(&links)
+	db.Pool.QueryRow(ctx, `SELECT received_at FROM emails WHERE id = $1`, currentID).Scan(&receivedAt)
+	if emails != 1 || links != 3 || !receivedAt.Equal(old.ReceivedAt.Truncate(time.Microsecond)) {
+		t.Errorf("after merge: %d emails, %d links, received_at %v; want 1 email linked to 3 users, first received_at", emails, links, receivedAt)
+	}
+}
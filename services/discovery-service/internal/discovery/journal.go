@@ -0,0 +1,258 @@
+package discovery
+
+import (
+	"bufio"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"os"
+	"sort"
+	"sync"
+
+	"github.com/google/uuid"
+)
+
+// DefaultJournalCompactSize is the journal size above which it is rewritten with only the
+// emails still in flight, when they take less than half of it
+const DefaultJournalCompactSize = 64 << 20
+
+// journalRecord is one line of the ingest journal: an email about to be ingested, or the
+// acknowledgment that it was (Email is nil)
+type journalRecord struct {
+	UserID    uuid.UUID     `json:"user_id"`
+	MessageID string        `json:"message_id"`
+	Email     *pendingEmail `json:"email,omitempty"`
+}
+
+// journaled is an email recorded in the journal and not acknowledged yet
+type journaled struct {
+	seq   uint64 // Recording order
+	email pendingEmail
+	size  int64 // Bytes of its record
+}
+
+// ingestJournal is a local write-ahead journal of emails pulled from the provider
+//
+// Every email is appended before it is stored or queued, and acknowledged once it is ingested
+// (stored, queued and its cursor advanced), or will be polled again. The file is truncated
+// whenever every recorded email has been acknowledged. After a crash, the emails still in the
+// journal are ingested again at startup, before polling resumes. Storing is idempotent and the
+// queue drops replays, so an email that was stored but not queued is queued, and nothing is
+// analyzed twice.
+//
+// A nil journal (ingest.journal unset) records nothing.
+type ingestJournal struct {
+	path        string
+	sync        bool  // fsync every record: survives OS crashes, at the cost of ingest throughput
+	compactSize int64 // Rewrite the file above this size
+
+	mu      sync.Mutex
+	file    *os.File
+	size    int64
+	live    int64 // Bytes of records not acknowledged yet
+	nextSeq uint64
+	pending map[emailKey]*journaled
+}
+
+// newIngestJournal returns the journal configured with ingest.journal, or nil if unset
+// It is opened by open
+func newIngestJournal(path string, sync bool) *ingestJournal {
+	if path == "" {
+		return nil
+	}
+	return &ingestJournal{path: path, sync: sync, compactSize: DefaultJournalCompactSize, pending: make(map[emailKey]*journaled)}
+}
+
+// open loads the journal and returns the emails it still holds, in recording order
+func (j *ingestJournal) open() ([]pendingEmail, error) {
+	if j == nil {
+		return nil, nil
+	}
+	// The journal holds email content
+	f, err := os.OpenFile(j.path, os.O_RDWR|os.O_CREATE|os.O_APPEND, 0600)
+	if err != nil {
+		return nil, fmt.Errorf("failed to open ingest journal: %w", err)
+	}
+	scanner := bufio.NewScanner(f)
+	scanner.Buffer(make([]byte, 0, 64*1024), 64*1024*1024) // Records hold bodies
+	for line := 1; scanner.Scan(); line++ {
+		var rec journalRecord
+		if err := json.Unmarshal(scanner.Bytes(), &rec); err != nil {
+			// Only the last record can be cut short by a crash, before its email was ingested
+			log.Printf("Skipping ingest journal line %d: %v", line, err)
+			continue
+		}
+		j.size += int64(len(scanner.Bytes()) + 1)
+		j.apply(rec, int64(len(scanner.Bytes())+1))
+	}
+	if err := scanner.Err(); err != nil {
+		f.Close()
+		return nil, fmt.Errorf("failed to read ingest journal: %w", err)
+	}
+	j.file = f
+
+	recovered := make([]*journaled, 0, len(j.pending))
+	for _, p := range j.pending {
+		recovered = append(recovered, p)
+	}
+	sort.Slice(recovered, func(a, b int) bool { return recovered[a].seq < recovered[b].seq })
+	emails := make([]pendingEmail, len(recovered))
+	for i, p := range recovered {
+		emails[i] = p.email
+		emails[i].Recovered = true
+	}
+	return emails, nil
+}
+
+// apply replays a record into the set of pending emails; j.mu must be held (or j not shared yet)
+func (j *ingestJournal) apply(rec journalRecord, size int64) {
+	key := emailKey{rec.UserID, rec.MessageID}
+	if rec.Email == nil {
+		if p, ok := j.pending[key]; ok {
+			j.live -= p.size
+			delete(j.pending, key)
+		}
+		return
+	}
+	if _, ok := j.pending[key]; ok {
+		return
+	}
+	j.nextSeq++
+	j.pending[key] = &journaled{seq: j.nextSeq, email: *rec.Email, size: size}
+	j.live += size
+}
+
+// record appends an email before it is ingested
+// An email already pending (delivered again while spilled) is not recorded twice
+func (j *ingestJournal) record(e pendingEmail) {
+	if j == nil {
+		return
+	}
+	j.mu.Lock()
+	defer j.mu.Unlock()
+	if _, ok := j.pending[e.key()]; ok {
+		return
+	}
+	e.Recovered = false
+	if err := j.write(journalRecord{UserID: e.UserID, MessageID: e.Email.MessageID, Email: &e}); err != nil {
+		log.Printf("Error writing ingest journal (email %s is not protected against a crash): %v", e.Email.MessageID, err)
+	}
+}
+
+// ack acknowledges an ingested email, truncating the journal once nothing is pending
+func (j *ingestJournal) ack(key emailKey) {
+	if j == nil {
+		return
+	}
+	j.mu.Lock()
+	defer j.mu.Unlock()
+	if _, ok := j.pending[key]; !ok {
+		return
+	}
+	if len(j.pending) == 1 {
+		delete(j.pending, key)
+		j.truncate()
+		return
+	}
+	if err := j.write(journalRecord{UserID: key.userID, MessageID: key.messageID}); err != nil {
+		log.Printf("Error writing ingest journal acknowledgment (email %s is ingested again after a crash): %v", key.messageID, err)
+	}
+	if j.size > j.compactSize && j.live < j.size/2 {
+		if err := j.compact(); err != nil {
+			log.Printf("Error compacting ingest journal: %v", err)
+		}
+	}
+}
+
+// write appends a record and applies it; j.mu must be held
+func (j *ingestJournal) write(rec journalRecord) error {
+	line, err := json.Marshal(rec)
+	if err != nil {
+		return err
+	}
+	line = append(line, '\n')
+	if _, err := j.file.Write(line); err != nil {
+		return err
+	}
+	if j.sync {
+		if err := j.file.Sync(); err != nil {
+			return err
+		}
+	}
+	j.size += int64(len(line))
+	j.apply(rec, int64(len(line)))
+	return nil
+}
+
+// truncate empties the journal once every email is acknowledged; j.mu must be held
+func (j *ingestJournal) truncate() {
+	if err := j.file.Truncate(0); err != nil {
+		log.Printf("Error truncating ingest journal: %v", err)
+		return
+	}
+	j.size, j.live = 0, 0
+}
+
+// compact rewrites the journal with only the pending emails, then swaps it in; j.mu must be held
+func (j *ingestJournal) compact() error {
+	pending := make([]*journaled, 0, len(j.pending))
+	for _, p := range j.pending {
+		pending = append(pending, p)
+	}
+	sort.Slice(pending, func(a, b int) bool { return pending[a].seq < pending[b].seq })
+
+	tmp := j.path + ".tmp"
+	f, err := os.OpenFile(tmp, os.O_WRONLY|os.O_CREATE|os.O_TRUNC, 0600)
+	if err != nil {
+		return err
+	}
+	renamed := false
+	defer func() {
+		if !renamed {
+			os.Remove(tmp)
+		}
+	}()
+	w := bufio.NewWriter(f)
+	sizes := make([]int64, len(pending))
+	var size int64
+	for i, p := range pending {
+		line, err := json.Marshal(journalRecord{UserID: p.email.UserID, MessageID: p.email.Email.MessageID, Email: &p.email})
+		if err != nil {
+			f.Close()
+			return err
+		}
+		w.Write(append(line, '\n'))
+		sizes[i] = int64(len(line) + 1)
+		size += sizes[i]
+	}
+	if err := errors.Join(w.Flush(), f.Sync(), f.Close()); err != nil {
+		return err
+	}
+	if err := os.Rename(tmp, j.path); err != nil {
+		return err
+	}
+	renamed = true
+
+	reopened, err := os.OpenFile(j.path, os.O_WRONLY|os.O_APPEND, 0600)
+	if err != nil {
+		return err
+	}
+	j.file.Close()
+	j.file = reopened
+	for i, p := range pending {
+		p.size = sizes[i]
+	}
+	j.size, j.live = size, size
+	return nil
+}
+
+// pendingCount returns the number of recorded emails not acknowledged yet
+func (j *ingestJournal) pendingCount() int {
+	if j == nil {
+		return 0
+	}
+	j.mu.Lock()
+	defer j.mu.Unlock()
+	return len(j.pending)
+}
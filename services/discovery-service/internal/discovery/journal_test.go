@@ -0,0 +1,92 @@
+package discovery
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/google/uuid"
+)
+
+func TestIngestJournal(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "journal.jsonl")
+	alice, bob := uuid.New(), uuid.New()
+
+	j := newIngestJournal(path, true)
+	if recovered, err := j.open(); err != nil || len(recovered) != 0 {
+		t.Fatalf("open new journal = %v, %v", recovered, err)
+	}
+	j.record(spilledEmail(alice, 1))
+	j.record(spilledEmail(bob, 2))
+	j.record(spilledEmail(alice, 3))
+	j.record(spilledEmail(alice, 1)) // Delivered again while pending
+	j.ack(spilledEmail(bob, 2).key())
+	if info, err := os.Stat(path); err != nil || info.Mode().Perm() != 0600 {
+		t.Fatalf("journal = %v, %v; want mode 0600", info, err)
+	}
+
+	// A crash: the emails not acknowledged are recovered in recording order
+	restarted := newIngestJournal(path, false)
+	recovered, err := restarted.open()
+	if err != nil {
+		t.Fatalf("reopen: %v", err)
+	}
+	if len(recovered) != 2 || recovered[0].Email.MessageID != "m1" || recovered[1].Email.MessageID != "m3" || !recovered[0].Recovered {
+		t.Fatalf("recovered %+v, want m1 then m3", recovered)
+	}
+
+	// Truncated once everything is acknowledged
+	for _, e := range recovered {
+		restarted.ack(e.key())
+	}
+	if info, _ := os.Stat(path); info.Size() != 0 || restarted.pendingCount() != 0 {
+		t.Errorf("journal is %d bytes with %d pending after every ack, want empty", info.Size(), restarted.pendingCount())
+	}
+
+	// Disabled journal
+	off := newIngestJournal("", false)
+	off.record(spilledEmail(alice, 4))
+	off.ack(spilledEmail(alice, 4).key())
+	if recovered, err := off.open(); off != nil || recovered != nil || err != nil {
+		t.Errorf("disabled journal = %v, %v, %v", off, recovered, err)
+	}
+}
+
+func TestIngestJournalCompaction(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "journal.jsonl")
+	j := newIngestJournal(path, false)
+	j.compactSize = 4 << 10
+	if _, err := j.open(); err != nil {
+		t.Fatalf("open: %v", err)
+	}
+
+	// One email stays in flight while many others come and go
+	stuck := uuid.New()
+	j.record(spilledEmail(stuck, 0))
+	other := uuid.New()
+	for i := 1; i <= 200; i++ {
+		j.record(spilledEmail(other, i))
+		j.ack(spilledEmail(other, i).key())
+	}
+	if info, _ := os.Stat(path); info.Size() > 2*j.compactSize {
+		t.Errorf("journal is %d bytes, want compacted below %d", info.Size(), 2*j.compactSize)
+	}
+
+	recovered, err := newIngestJournal(path, false).open()
+	if err != nil || len(recovered) != 1 || recovered[0].UserID != stuck {
+		t.Errorf("recovered after compaction = %+v, %v; want the in-flight email", recovered, err)
+	}
+
+	// A failed compaction leaves no temporary file behind
+	blocked := filepath.Join(t.TempDir(), "blocked")
+	if err := os.MkdirAll(filepath.Join(blocked, "dir"), 0700); err != nil {
+		t.Fatal(err)
+	}
+	j.path = blocked
+	if err := j.compact(); err == nil {
+		t.Error("compact over a directory succeeded, want an error")
+	}
+	if _, err := os.Stat(blocked + ".tmp"); !os.IsNotExist(err) {
+		t.Errorf("temporary file left after a failed compaction: %v", err)
+	}
+}
@@ -0,0 +1,161 @@
+package discovery
+
+import (
+	"sort"
+	"sync"
+	"time"
+)
+
+const (
+	LatencyWindowSize = 10000           // Number of recent samples kept per latency stage
+	DefaultIngestSLO  = 2 * time.Minute // Default p95 ingest latency objective
+)
+
+// latencyWindow keeps a fixed-size ring buffer of recent latency samples
+// Percentiles are computed over the window, so old samples age out naturally
+type latencyWindow struct {
+	mu      sync.Mutex
+	samples []time.Duration
+	next    int
+	full    bool
+}
+
+func newLatencyWindow(size int) *latencyWindow {
+	return &latencyWindow{samples: make([]time.Duration, size)}
+}
+
+// observe records a latency sample, overwriting the oldest one when the window is full
+func (w *latencyWindow) observe(d time.Duration) {
+	if d < 0 {
+		// Provider clock ahead of ours, clamp rather than skew percentiles
+		d = 0
+	}
+
+	w.mu.Lock()
+	defer w.mu.Unlock()
+
+	w.samples[w.next] = d
+	w.next++
+	if w.next == len(w.samples) {
+		w.next = 0
+		w.full = true
+	}
+}
+
+// percentiles returns the requested percentiles (0-100) over the current window
+// Returns nil if no samples have been recorded yet
+func (w *latencyWindow) percentiles(ps ...float64) []time.Duration {
+	w.mu.Lock()
+	n := w.next
+	if w.full {
+		n = len(w.samples)
+	}
+	sorted := make([]time.Duration, n)
+	copy(sorted, w.samples[:n])
+	w.mu.Unlock()
+
+	if n == 0 {
+		return nil
+	}
+
+	sort.Slice(sorted, func(i, j int) bool { return sorted[i] < sorted[j] })
+
+	result := make([]time.Duration, len(ps))
+	for i, p := range ps {
+		// Nearest-rank percentile
+		rank := int(p/100*float64(n)+0.5) - 1
+		if rank < 0 {
+			rank = 0
+		}
+		if rank >= n {
+			rank = n - 1
+		}
+		result[i] = sorted[rank]
+	}
+	return result
+}
+
+// LatencyStats holds end-to-end latency percentiles for one ingest stage
+type LatencyStats struct {
+	P50 time.Duration
+	P95 time.Duration
+	P99 time.Duration
+}
+
+// latencyTracker tracks per-email latency from provider received_at to each ingest stage:
+// discovery (polled from provider), store (persisted in DB) and queue (published for analysis).
+// priority tracks queue latency of priority-lane emails only.
+type latencyTracker struct {
+	discovery *latencyWindow
+	store     *latencyWindow
+	queue     *latencyWindow
+	priority  *latencyWindow
+}
+
+func newLatencyTracker() *latencyTracker {
+	return &latencyTracker{
+		discovery: newLatencyWindow(LatencyWindowSize),
+		store:     newLatencyWindow(LatencyWindowSize),
+		queue:     newLatencyWindow(LatencyWindowSize),
+		priority:  newLatencyWindow(LatencyWindowSize),
+	}
+}
+
+// record observes the latencies of a processed email
+// queuedAt is zero when the email was a duplicate and never published
+func (t *latencyTracker) record(receivedAt, discoveredAt, storedAt, queuedAt time.Time, priority bool) {
+	t.discovery.observe(discoveredAt.Sub(receivedAt))
+	t.store.observe(storedAt.Sub(receivedAt))
+	if !queuedAt.IsZero() {
+		t.queue.observe(queuedAt.Sub(receivedAt))
+		if priority {
+			t.priority.observe(queuedAt.Sub(receivedAt))
+		}
+	}
+}
+
+// latencyStage is a tracked stage, by the name it is reported under
+type latencyStage struct {
+	name   string
+	window *latencyWindow
+}
+
+// stages returns the tracked stages in reporting order
+func (t *latencyTracker) stages() []latencyStage {
+	return []latencyStage{
+		{"discovery", t.discovery},
+		{"store", t.store},
+		{"queue", t.queue},
+		{"priority", t.priority},
+	}
+}
+
+// LatencySummary is a stage's latency percentiles over the recent window, in seconds
+type LatencySummary struct {
+	P50Seconds float64 `json:"p50_seconds"`
+	P95Seconds float64 `json:"p95_seconds"`
+	P99Seconds float64 `json:"p99_seconds"`
+}
+
+// summaries returns the percentiles of every stage with samples, keyed by stage name
+func (t *latencyTracker) summaries() map[string]LatencySummary {
+	summaries := make(map[string]LatencySummary)
+	for _, stage := range t.stages() {
+		if stats, ok := statsFor(stage.window); ok {
+			summaries[stage.name] = LatencySummary{
+				P50Seconds: stats.P50.Seconds(),
+				P95Seconds: stats.P95.Seconds(),
+				P99Seconds: stats.P99.Seconds(),
+			}
+		}
+	}
+	return summaries
+}
+
+func statsFor(w *latencyWindow) (LatencyStats, bool) {
+	p := w.percentiles(50, 95, 99)
+	if p == nil {
+		return LatencyStats{}, false
+	}
+	return LatencyStats{P50: p[0], P95: p[1], P99: p[2]}, true
+}
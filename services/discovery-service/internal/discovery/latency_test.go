@@ -0,0 +1,56 @@
+package discovery
+
+import (
+	"testing"
+	"time"
+)
+
+func TestLatencyWindowPercentiles(t *testing.T) {
+	w := newLatencyWindow(100)
+	if p := w.percentiles(50); p != nil {
+		t.Fatalf("percentiles of an empty window = %v, want nil", p)
+	}
+	// 1ms..100ms, observed out of order
+	for i := 100; i >= 1; i-- {
+		w.observe(time.Duration(i) * time.Millisecond)
+	}
+	got := w.percentiles(0, 50, 95, 99, 100)
+	want := []time.Duration{1 * time.Millisecond, 50 * time.Millisecond, 95 * time.Millisecond, 99 * time.Millisecond, 100 * time.Millisecond}
+	for i := range want {
+		if got[i] != want[i] {
+			t.Errorf("percentiles = %v, want %v (nearest rank)", got, want)
+			break
+		}
+	}
+
+	// A single sample is every percentile; negative latencies (provider clock ahead) clamp to 0
+	single := newLatencyWindow(10)
+	single.observe(-time.Second)
+	if p := single.percentiles(50, 99); p[0] != 0 || p[1] != 0 {
+		t.Errorf("percentiles of one negative sample = %v, want 0", p)
+	}
+}
+
+func TestLatencyWindowAgesOutOldSamples(t *testing.T) {
+	w := newLatencyWindow(4)
+	for _, ms := range []int{1000, 1000, 1000, 1000, 1, 2, 3, 4} {
+		w.observe(time.Duration(ms) * time.Millisecond)
+	}
+	if p := w.percentiles(100); p[0] != 4*time.Millisecond {
+		t.Errorf("max over the window = %v, want 4ms once the slow samples were overwritten", p[0])
+	}
+}
+
+func TestLatencySummaries(t *testing.T) {
+	tracker := newLatencyTracker()
+	if s := tracker.summaries(); len(s) != 0 {
+		t.Fatalf("summaries without samples = %v, want none", s)
+	}
+	received := time.Now()
+	tracker.record(received, received.Add(time.Second), received.Add(2*time.Second), time.Time{}, false)
+
+	s := tracker.summaries()
+	if len(s) != 2 || s["discovery"].P50Seconds != 1 || s["store"].P99Seconds != 2 {
+		t.Errorf("summaries = %+v, want discovery and store only (never queued)", s)
+	}
+}
@@ -0,0 +1,368 @@
+package discovery
+
+import (
+	"fmt"
+	"log"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/spf13/viper"
+	"github.com/stoik/vigil/services/discovery-service/internal/schedule"
+)
+
+// Maintenance window actions
+const (
+	MaintenancePause = "pause" // No provider calls: email polling and user discovery stop
+	MaintenanceSlow  = "slow"  // Email polling runs every slow_factor polling intervals
+)
+
+const (
+	DefaultSlowFactor    = 4
+	maxMaintenanceLength = 7 * 24 * time.Hour
+	maintenanceLookahead = 366 // Days searched for the next window
+)
+
+// MaintenanceWindow is a recurring period during which polling pauses or slows down
+//
+// Spec is a 5-field cron expression for the start (minute hour day-of-month month day-of-week,
+// with *, lists, ranges, steps and names, e.g. "0 22 * * mon-fri"), followed by the length and
+// the action: "0 2 * * sun 4h pause", "0 22 * * mon-fri 9h slow=6". Times are in the
+// maintenance.timezone, the tenant's timezone by default. A start skipped by the clocks moving
+// forward happens after the gap (02:30 at 03:30), one repeated by the clocks moving back happens once.
+type MaintenanceWindow struct {
+	Spec       string        `json:"spec"`
+	Action     string        `json:"action"`
+	SlowFactor int           `json:"slow_factor,omitempty"`
+	Length     time.Duration `json:"length"`
+	start      cronSchedule
+}
+
+// ParseMaintenanceWindow parses a window spec (see MaintenanceWindow)
+func ParseMaintenanceWindow(spec string) (MaintenanceWindow, error) {
+	fields := strings.Fields(spec)
+	if len(fields) != 7 {
+		return MaintenanceWindow{}, fmt.Errorf("maintenance window %q: want \"<minute> <hour> <day> <month> <weekday> <length> <action>\"", spec)
+	}
+	w := MaintenanceWindow{Spec: strings.Join(fields, " ")}
+
+	var err error
+	if w.start, err = parseCron(fields[:5]); err != nil {
+		return MaintenanceWindow{}, fmt.Errorf("maintenance window %q: %w", spec, err)
+	}
+	if w.Length, err = time.ParseDuration(fields[5]); err != nil || w.Length <= 0 || w.Length > maxMaintenanceLength {
+		return MaintenanceWindow{}, fmt.Errorf("maintenance window %q: length must be a duration up to %v", spec, maxMaintenanceLength)
+	}
+
+	action, factor, hasFactor := strings.Cut(fields[6], "=")
+	switch {
+	case action == MaintenancePause && !hasFactor:
+		w.Action = MaintenancePause
+	case action == MaintenanceSlow:
+		w.Action, w.SlowFactor = MaintenanceSlow, DefaultSlowFactor
+		if hasFactor {
+			if w.SlowFactor, err = strconv.Atoi(factor); err != nil || w.SlowFactor < 2 {
+				return MaintenanceWindow{}, fmt.Errorf("maintenance window %q: slow factor must be an integer >= 2", spec)
+			}
+		}
+	default:
+		return MaintenanceWindow{}, fmt.Errorf("maintenance window %q: action must be %q or %q[=factor]", spec, MaintenancePause, MaintenanceSlow)
+	}
+	return w, nil
+}
+
+// activeAt returns the start of the run of w covering t, if any
+func (w MaintenanceWindow) activeAt(t time.Time) (time.Time, bool) {
+	t = t.Truncate(time.Minute)
+	earliest := t.Add(-w.Length)
+	var latest time.Time
+	w.start.each(earliest.Add(time.Minute), t, func(start time.Time) bool {
+		latest = start
+		return true
+	})
+	return latest, !latest.IsZero()
+}
+
+// nextStart returns the first start of w after t
+func (w MaintenanceWindow) nextStart(t time.Time) (time.Time, bool) {
+	var next time.Time
+	from := t.Truncate(time.Minute).Add(time.Minute)
+	w.start.each(from, from.AddDate(0, 0, maintenanceLookahead), func(start time.Time) bool {
+		next = start
+		return false
+	})
+	return next, !next.IsZero()
+}
+
+// cronSchedule matches start times, one bit per allowed value
+type cronSchedule struct {
+	minute, hour, dom, month, dow uint64
+	domAny, dowAny                bool // Unrestricted fields (cron matches day-of-month OR day-of-week otherwise)
+}
+
+var (
+	monthNames   = []string{"", "jan", "feb", "mar", "apr", "may", "jun", "jul", "aug", "sep", "oct", "nov", "dec"}
+	weekdayNames = []string{"sun", "mon", "tue", "wed", "thu", "fri", "sat"}
+)
+
+func parseCron(fields []string) (cronSchedule, error) {
+	var c cronSchedule
+	var err error
+	if c.minute, err = parseCronField(fields[0], 0, 59, nil); err != nil {
+		return c, fmt.Errorf("minute: %w", err)
+	}
+	if c.hour, err = parseCronField(fields[1], 0, 23, nil); err != nil {
+		return c, fmt.Errorf("hour: %w", err)
+	}
+	if c.dom, err = parseCronField(fields[2], 1, 31, nil); err != nil {
+		return c, fmt.Errorf("day of month: %w", err)
+	}
+	if c.month, err = parseCronField(fields[3], 1, 12, monthNames); err != nil {
+		return c, fmt.Errorf("month: %w", err)
+	}
+	if c.dow, err = parseCronField(fields[4], 0, 7, weekdayNames); err != nil {
+		return c, fmt.Errorf("day of week: %w", err)
+	}
+	if c.dow&(1<<7) != 0 {
+		c.dow |= 1 // 7 is Sunday too
+	}
+	c.domAny, c.dowAny = fields[2] == "*", fields[4] == "*"
+	return c, nil
+}
+
+// parseCronField parses a comma-separated list of *, values, ranges and /steps
+func parseCronField(field string, min, max int, names []string) (uint64, error) {
+	value := func(s string) (int, error) {
+		for i, name := range names {
+			if name != "" && strings.EqualFold(s, name) {
+				return i, nil
+			}
+		}
+		n, err := strconv.Atoi(s)
+		if err != nil || n < min || n > max {
+			return 0, fmt.Errorf("%q is not in %d-%d", s, min, max)
+		}
+		return n, nil
+	}
+
+	var bits uint64
+	for _, item := range strings.Split(field, ",") {
+		rng, stepStr, hasStep := strings.Cut(item, "/")
+		step := 1
+		if hasStep {
+			var err error
+			if step, err = strconv.Atoi(stepStr); err != nil || step < 1 {
+				return 0, fmt.Errorf("invalid step %q", stepStr)
+			}
+		}
+
+		lo, hi := min, max
+		if rng != "*" {
+			loStr, hiStr, isRange := strings.Cut(rng, "-")
+			var err error
+			if lo, err = value(loStr); err != nil {
+				return 0, err
+			}
+			hi = lo
+			if isRange {
+				if hi, err = value(hiStr); err != nil {
+					return 0, err
+				}
+			} else if hasStep {
+				hi = max // "5/15": from 5 to the end
+			}
+			if hi < lo {
+				return 0, fmt.Errorf("invalid range %q", rng)
+			}
+		}
+		for v := lo; v <= hi; v += step {
+			bits |= 1 << v
+		}
+	}
+	return bits, nil
+}
+
+func (c cronSchedule) matchesDay(t time.Time) bool {
+	if c.month&(1<<int(t.Month())) == 0 {
+		return false
+	}
+	dom, dow := c.dom&(1<<t.Day()) != 0, c.dow&(1<<int(t.Weekday())) != 0
+	if c.domAny || c.dowAny {
+		return dom && dow
+	}
+	return dom || dow
+}
+
+// each calls fn with every start in [from, to], in order, until fn returns false
+// from and to are in the schedule's location and truncated to the minute
+func (c cronSchedule) each(from, to time.Time, fn func(time.Time) bool) {
+	loc := from.Location()
+	for day := time.Date(from.Year(), from.Month(), from.Day(), 0, 0, 0, 0, loc); !day.After(to); day = day.AddDate(0, 0, 1) {
+		if !c.matchesDay(day) {
+			continue
+		}
+		for h := 0; h < 24; h++ {
+			if c.hour&(1<<h) == 0 {
+				continue
+			}
+			for m := 0; m < 60; m++ {
+				if c.minute&(1<<m) == 0 {
+					continue
+				}
+				t := time.Date(day.Year(), day.Month(), day.Day(), h, m, 0, 0, loc)
+				if t.Before(from) {
+					continue
+				}
+				if t.After(to) || !fn(t) {
+					return
+				}
+			}
+		}
+	}
+}
+
+// MaintenanceRun is one occurrence of a window
+type MaintenanceRun struct {
+	Window     string    `json:"window"`
+	Action     string    `json:"action"`
+	SlowFactor int       `json:"slow_factor,omitempty"`
+	Start      time.Time `json:"start"`
+	End        time.Time `json:"end"`
+}
+
+// MaintenanceState is the tenant's maintenance schedule and what it currently enforces
+type MaintenanceState struct {
+	Timezone string              `json:"timezone"`
+	Windows  []MaintenanceWindow `json:"windows"`
+	// Runs in progress; pause wins over slow, and the largest slow factor over smaller ones
+	Active []MaintenanceRun `json:"active"`
+	Action string           `json:"action,omitempty"` // Enforced action, empty outside windows
+	// While slowed, users are polled every slow_factor polling intervals
+	SlowFactor int             `json:"slow_factor,omitempty"`
+	Next       *MaintenanceRun `json:"next,omitempty"` // Next run to start
+}
+
+// maintenanceSchedule evaluates the tenant's maintenance windows, at most once per minute
+// A nil schedule has no windows
+type maintenanceSchedule struct {
+	windows []MaintenanceWindow
+	loc     *time.Location
+
+	mu        sync.Mutex
+	evaluated time.Time // Minute of the cached state
+	state     MaintenanceState
+}
+
+// newMaintenanceSchedule reads maintenance.windows and maintenance.timezone (per tenant in
+// tenants/<tenant_id>.yaml), falling back to the tenant's timezone. Invalid windows are logged and ignored
+func newMaintenanceSchedule() *maintenanceSchedule {
+	m := &maintenanceSchedule{loc: time.UTC}
+	tz := viper.GetString("maintenance.timezone")
+	if tz == "" {
+		tz = viper.GetString("timezone")
+	}
+	if tz != "" {
+		loc, err := schedule.LoadLocation(tz)
+		if err != nil {
+			log.Printf("Invalid maintenance time zone, using UTC: %v", err)
+		} else {
+			m.loc = loc
+		}
+	}
+	specs := viper.GetStringSlice("maintenance.windows")
+	if env, ok := viper.Get("maintenance.windows").(string); ok {
+		specs = strings.Split(env, ";") // Specs hold spaces and commas
+	}
+	for _, spec := range specs {
+		if strings.TrimSpace(spec) == "" {
+			continue
+		}
+		w, err := ParseMaintenanceWindow(spec)
+		if err != nil {
+			log.Printf("Ignoring %v", err)
+			continue
+		}
+		m.windows = append(m.windows, w)
+	}
+	return m
+}
+
+// at returns the state at t
+func (m *maintenanceSchedule) at(t time.Time) MaintenanceState {
+	if m == nil {
+		return MaintenanceState{Timezone: time.UTC.String(), Windows: []MaintenanceWindow{}, Active: []MaintenanceRun{}}
+	}
+	minute := t.In(m.loc).Truncate(time.Minute)
+
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	if minute.Equal(m.evaluated) {
+		return m.state
+	}
+	state := m.evaluate(minute)
+	if !m.evaluated.IsZero() && (state.Action != m.state.Action || state.SlowFactor != m.state.SlowFactor) {
+		switch state.Action {
+		case MaintenancePause:
+			log.Printf("🔧 Maintenance window started | polling paused until %s", state.Active[0].End.Format(time.RFC3339))
+		case MaintenanceSlow:
+			log.Printf("🔧 Maintenance window started | polling every %d intervals until %s", state.SlowFactor, state.Active[0].End.Format(time.RFC3339))
+		default:
+			log.Printf("🔧 Maintenance window ended | polling resumed")
+		}
+	}
+	m.evaluated, m.state = minute, state
+	return state
+}
+
+func (m *maintenanceSchedule) evaluate(t time.Time) MaintenanceState {
+	state := MaintenanceState{Timezone: m.loc.String(), Windows: append([]MaintenanceWindow{}, m.windows...), Active: []MaintenanceRun{}}
+	for _, w := range m.windows {
+		run := MaintenanceRun{Window: w.Spec, Action: w.Action, SlowFactor: w.SlowFactor}
+		if start, ok := w.activeAt(t); ok {
+			run.Start, run.End = start, start.Add(w.Length)
+			state.Active = append(state.Active, run)
+		}
+		if start, ok := w.nextStart(t); ok && (state.Next == nil || start.Before(state.Next.Start)) {
+			run.Start, run.End = start, start.Add(w.Length)
+			state.Next = &run
+		}
+	}
+
+	// The enforced run goes first
+	for i, run := range state.Active {
+		if state.Action == "" || run.Action == MaintenancePause && state.Action != MaintenancePause ||
+			run.Action == MaintenanceSlow && state.Action == MaintenanceSlow && run.SlowFactor > state.SlowFactor {
+			state.Action, state.SlowFactor = run.Action, run.SlowFactor
+			state.Active[0], state.Active[i] = state.Active[i], state.Active[0]
+		}
+	}
+	return state
+}
+
+// paused reports whether provider calls are paused now
+func (m *maintenanceSchedule) paused() bool {
+	return m.at(time.Now()).Action == MaintenancePause
+}
+
+// allowPoll reports whether a user's scheduled poll runs now; skipped counts the polls the
+// user skipped in a row while slowed
+func (m *maintenanceSchedule) allowPoll(skipped *int) bool {
+	state := m.at(time.Now())
+	switch state.Action {
+	case MaintenancePause:
+		return false
+	case MaintenanceSlow:
+		if *skipped+1 < state.SlowFactor {
+			*skipped++
+			return false
+		}
+	}
+	*skipped = 0
+	return true
+}
+
+// Maintenance returns the tenant's maintenance windows and what they enforce now
+func (s *Service) Maintenance() MaintenanceState {
+	return s.maintenance.at(time.Now())
+}
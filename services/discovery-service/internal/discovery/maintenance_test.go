@@ -0,0 +1,179 @@
+package discovery
+
+import (
+	"testing"
+	"time"
+
+	"github.com/spf13/viper"
+)
+
+func TestParseMaintenanceWindow(t *testing.T) {
+	tests := []struct {
+		spec   string
+		action string
+		factor int
+		length time.Duration
+		ok     bool
+	}{
+		{"0 2 * * sun 4h pause", MaintenancePause, 0, 4 * time.Hour, true},
+		{"0 22 * * mon-fri 9h slow=6", MaintenanceSlow, 6, 9 * time.Hour, true},
+		{"*/15 1,13 1-7 jan-jun 7 30m slow", MaintenanceSlow, DefaultSlowFactor, 30 * time.Minute, true},
+		{"0 2 * * sun 4h", "", 0, 0, false},
+		{"60 2 * * sun 4h pause", "", 0, 0, false},
+		{"0 2 * * funday 4h pause", "", 0, 0, false},
+		{"0 5-2 * * * 4h pause", "", 0, 0, false},
+		{"0 2 * * sun 8d pause", "", 0, 0, false},
+		{"0 2 * * sun 200h pause", "", 0, 0, false},
+		{"0 2 * * sun 4h slow=1", "", 0, 0, false},
+		{"0 2 * * sun 4h pause=2", "", 0, 0, false},
+		{"0 2 * * sun 4h stop", "", 0, 0, false},
+	}
+	for _, tt := range tests {
+		w, err := ParseMaintenanceWindow(tt.spec)
+		if (err == nil) != tt.ok {
+			t.Errorf("ParseMaintenanceWindow(%q) error = %v, want ok = %v", tt.spec, err, tt.ok)
+			continue
+		}
+		if tt.ok && (w.Action != tt.action || w.SlowFactor != tt.factor || w.Length != tt.length) {
+			t.Errorf("ParseMaintenanceWindow(%q) = %s x%d for %v, want %s x%d for %v",
+				tt.spec, w.Action, w.SlowFactor, w.Length, tt.action, tt.factor, tt.length)
+		}
+	}
+}
+
+func TestMaintenanceWindowSchedule(t *testing.T) {
+	at := func(s string) time.Time {
+		ts, err := time.Parse("2006-01-02 15:04", s)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return ts
+	}
+	// Weeknights from 22:00 to 07:00 (2024-03-01 is a Friday)
+	w, err := ParseMaintenanceWindow("0 22 * * mon-fri 9h slow=4")
+	if err != nil {
+		t.Fatal(err)
+	}
+	tests := []struct {
+		now       string
+		active    bool
+		start     string
+		nextStart string
+	}{
+		{"2024-03-01 21:59", false, "", "2024-03-01 22:00"},
+		{"2024-03-01 22:00", true, "2024-03-01 22:00", "2024-03-04 22:00"},
+		{"2024-03-02 06:59", true, "2024-03-01 22:00", "2024-03-04 22:00"},
+		{"2024-03-02 07:00", false, "", "2024-03-04 22:00"},
+		{"2024-03-02 23:00", false, "", "2024-03-04 22:00"}, // Saturday
+	}
+	for _, tt := range tests {
+		start, active := w.activeAt(at(tt.now))
+		if active != tt.active || active && !start.Equal(at(tt.start)) {
+			t.Errorf("activeAt(%s) = %v, %v; want %v, %s", tt.now, start, active, tt.active, tt.start)
+		}
+		if next, ok := w.nextStart(at(tt.now)); !ok || !next.Equal(at(tt.nextStart)) {
+			t.Errorf("nextStart(%s) = %v, %v; want %s", tt.now, next, ok, tt.nextStart)
+		}
+	}
+
+	// Day of month and day of week both restricted: either matches
+	w, _ = ParseMaintenanceWindow("0 0 15 * sat 1h pause")
+	if next, _ := w.nextStart(at("2024-03-01 12:00")); !next.Equal(at("2024-03-02 00:00")) {
+		t.Errorf("nextStart = %v, want the Saturday before the 15th", next)
+	}
+	if next, _ := w.nextStart(at("2024-03-14 12:00")); !next.Equal(at("2024-03-15 00:00")) {
+		t.Errorf("nextStart = %v, want the 15th (a Friday)", next)
+	}
+	// No start within a year
+	w, _ = ParseMaintenanceWindow("0 0 31 feb * 1h pause")
+	if next, ok := w.nextStart(at("2024-03-01 12:00")); ok {
+		t.Errorf("nextStart = %v, want none", next)
+	}
+}
+
+func TestMaintenanceSchedule(t *testing.T) {
+	window := func(spec string) MaintenanceWindow {
+		w, err := ParseMaintenanceWindow(spec)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return w
+	}
+	always := func(action string) MaintenanceWindow { return window("* * * * * 1h " + action) }
+
+	// Overlapping windows: pause wins, then the largest slow factor
+	m := &maintenanceSchedule{loc: time.UTC, windows: []MaintenanceWindow{always("slow=3"), always("slow=5"), window("0 0 1 1 * 1h pause")}}
+	state := m.at(time.Now())
+	if state.Action != MaintenanceSlow || state.SlowFactor != 5 || len(state.Active) != 2 || state.Active[0].SlowFactor != 5 || state.Next == nil {
+		t.Fatalf("state = %+v, want slowed 5x", state)
+	}
+	skipped, polls := 0, 0
+	for i := 0; i < 10; i++ {
+		if m.allowPoll(&skipped) {
+			polls++
+		}
+	}
+	if polls != 2 || m.paused() {
+		t.Errorf("slowed 5x: %d polls out of 10, paused = %v; want 2, false", polls, m.paused())
+	}
+
+	m = &maintenanceSchedule{loc: time.UTC, windows: []MaintenanceWindow{always("slow=3"), always("pause")}}
+	if !m.paused() || m.allowPoll(&skipped) {
+		t.Errorf("state = %+v, want paused", m.at(time.Now()))
+	}
+
+	var none *maintenanceSchedule
+	if none.paused() || !none.allowPoll(&skipped) || none.at(time.Now()).Action != "" {
+		t.Error("a nil schedule restricts polling")
+	}
+}
+
+func TestMaintenanceWindowDST(t *testing.T) {
+	paris, _ := time.LoadLocation("Europe/Paris")
+	utc := func(s string) time.Time {
+		ts, err := time.Parse("2006-01-02 15:04", s)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return ts
+	}
+	next := func(spec, from string) time.Time {
+		w, err := ParseMaintenanceWindow(spec)
+		if err != nil {
+			t.Fatal(err)
+		}
+		start, ok := w.nextStart(utc(from).In(paris))
+		if !ok {
+			t.Fatalf("%s: no start after %s", spec, from)
+		}
+		return start
+	}
+
+	// Daily at 07:00 Paris time: 06:00 UTC in winter, 05:00 UTC once clocks spring forward
+	if got := next("0 7 * * * 1h pause", "2024-03-30 00:00"); !got.Equal(utc("2024-03-30 06:00")) {
+		t.Errorf("winter start = %v", got.UTC())
+	}
+	if got := next("0 7 * * * 1h pause", "2024-03-30 07:00"); !got.Equal(utc("2024-03-31 05:00")) {
+		t.Errorf("summer start = %v", got.UTC())
+	}
+	// 02:30 does not exist on 2024-03-31: the window starts after the gap, at 03:30 CEST
+	if got := next("30 2 * * * 1h pause", "2024-03-30 12:00"); !got.Equal(utc("2024-03-31 01:30")) {
+		t.Errorf("start skipped by spring forward = %v", got.UTC())
+	}
+	// 02:30 happens twice on 2024-10-27: the window starts once
+	first := next("30 2 * * * 1h pause", "2024-10-26 12:00")
+	if second := next("30 2 * * * 1h pause", first.UTC().Format("2006-01-02 15:04")); second.Sub(first) < 23*time.Hour {
+		t.Errorf("window repeated on fall back: %v then %v", first.UTC(), second.UTC())
+	}
+
+	// The tenant's time zone applies unless maintenance.timezone is set
+	t.Cleanup(viper.Reset)
+	viper.Set("timezone", "Europe/Paris")
+	if m := newMaintenanceSchedule(); m.loc.String() != "Europe/Paris" {
+		t.Errorf("maintenance time zone = %v, want the tenant's", m.loc)
+	}
+	viper.Set("maintenance.timezone", "America/New_York")
+	if m := newMaintenanceSchedule(); m.loc.String() != "America/New_York" {
+		t.Errorf("maintenance time zone = %v, want maintenance.timezone", m.loc)
+	}
+}
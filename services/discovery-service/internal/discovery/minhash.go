@@ -0,0 +1,153 @@
+package discovery
+
+import (
+	"encoding/binary"
+	"regexp"
+	"strings"
+	"unicode"
+
+	"github.com/cespare/xxhash/v2"
+	"github.com/stoik/vigil/internal/models"
+)
+
+// MinHash parameters: signatures are persisted and compared across releases, so changing them
+// (or the normalization) requires recomputing every stored signature
+const (
+	minhashSize      = 64 // Hash functions per signature
+	minhashBands     = 16 // LSH bands: emails sharing a band are compared
+	minhashRows      = minhashSize / minhashBands
+	shingleWords     = 4 // Words per shingle
+	minhashSeed      = 0x5eed_c1a5_7e12_0001
+	minhashByteCount = minhashSize * 4
+)
+
+// minhashSignature summarizes an email's text: the fraction of equal values between two
+// signatures estimates the Jaccard similarity of their shingle sets
+// It cannot be turned back into the text, so it is stored under the zero copy principle
+type minhashSignature [minhashSize]uint32
+
+// minhashPermutations are the (a, b) pairs of the hash functions h(x) = a*x + b, a odd
+var minhashPermutations = func() [minhashSize][2]uint64 {
+	var perms [minhashSize][2]uint64
+	state := uint64(minhashSeed)
+	next := func() uint64 { // splitmix64
+		state += 0x9e3779b97f4a7c15
+		z := state
+		z = (z ^ (z >> 30)) * 0xbf58476d1ce4e5b9
+		z = (z ^ (z >> 27)) * 0x94d049bb133111eb
+		return z ^ (z >> 31)
+	}
+	for i := range perms {
+		perms[i] = [2]uint64{next() | 1, next()}
+	}
+	return perms
+}()
+
+var htmlTag = regexp.MustCompile(`<[^>]*>`)
+
+// campaignText returns the text emails are clustered on: the body, or subject and snippet
+// when bodies are not fetched
+func campaignText(email models.ProviderEmail) string {
+	if email.Body != "" {
+		return email.Body
+	}
+	return email.Subject + "\n" + email.Snippet
+}
+
+// shingleTokens normalizes text into words: markup dropped, lower-cased, punctuation ignored
+// and digit runs collapsed, so per-recipient names, amounts and tracking numbers matter less
+func shingleTokens(text string) []string {
+	text = htmlTag.ReplaceAllString(text, " ")
+	words := strings.FieldsFunc(strings.ToLower(text), func(r rune) bool {
+		return !unicode.IsLetter(r) && !unicode.IsDigit(r)
+	})
+	for i, w := range words {
+		if strings.IndexFunc(w, unicode.IsDigit) < 0 {
+			continue
+		}
+		var sb strings.Builder
+		digits := false
+		for _, r := range w {
+			if unicode.IsDigit(r) {
+				if !digits {
+					sb.WriteByte('#')
+				}
+				digits = true
+				continue
+			}
+			digits = false
+			sb.WriteRune(r)
+		}
+		words[i] = sb.String()
+	}
+	return words
+}
+
+// newMinhash computes the signature of a text's word shingles
+// Returns false for text without words
+func newMinhash(text string) (minhashSignature, bool) {
+	var sig minhashSignature
+	words := shingleTokens(text)
+	if len(words) == 0 {
+		return sig, false
+	}
+	for i := range sig {
+		sig[i] = ^uint32(0)
+	}
+	n := max(len(words)-shingleWords+1, 1)
+	for i := 0; i < n; i++ {
+		x := xxhash.Sum64String(strings.Join(words[i:min(i+shingleWords, len(words))], " "))
+		for j, p := range minhashPermutations {
+			if v := uint32((p[0]*x + p[1]) >> 32); v < sig[j] {
+				sig[j] = v
+			}
+		}
+	}
+	return sig, true
+}
+
+// similarity estimates the Jaccard similarity of the texts behind two signatures
+func (sig *minhashSignature) similarity(other *minhashSignature) float64 {
+	equal := 0
+	for i := range sig {
+		if sig[i] == other[i] {
+			equal++
+		}
+	}
+	return float64(equal) / minhashSize
+}
+
+// bandKeys returns one key per LSH band
+// Two emails of similarity s share at least one key with probability 1-(1-s^4)^16
+// (0.9998 at 0.8, 0.64 at 0.5)
+func (sig *minhashSignature) bandKeys() []int64 {
+	keys := make([]int64, minhashBands)
+	var buf [1 + minhashRows*4]byte
+	for b := range keys {
+		buf[0] = byte(b)
+		for r := 0; r < minhashRows; r++ {
+			binary.BigEndian.PutUint32(buf[1+r*4:], sig[b*minhashRows+r])
+		}
+		keys[b] = int64(xxhash.Sum64(buf[:]))
+	}
+	return keys
+}
+
+func (sig *minhashSignature) encode() []byte {
+	buf := make([]byte, minhashByteCount)
+	for i, v := range sig {
+		binary.BigEndian.PutUint32(buf[i*4:], v)
+	}
+	return buf
+}
+
+func decodeMinhash(buf []byte) (minhashSignature, bool) {
+	var sig minhashSignature
+	if len(buf) != minhashByteCount {
+		return sig, false
+	}
+	for i := range sig {
+		sig[i] = binary.BigEndian.Uint32(buf[i*4:])
+	}
+	return sig, true
+}
@@ -0,0 +1,49 @@
+package discovery
+
+import (
+	"fmt"
+	"slices"
+	"testing"
+)
+
+const invoiceTemplate = `<p>Dear %s,</p><p>Your invoice #%s of %s EUR is overdue. Our records show that the payment
+scheduled for last month was rejected by your bank. To avoid suspension of your account and late fees,
+please review the attached statement and confirm your billing details within 24 hours using the secure
+portal below. If you already paid this invoice, you can ignore this message. Thank you for your prompt
+attention to this matter. Accounts Receivable Department</p>`
+
+func invoiceEmail(name, number, amount string) string {
+	return fmt.Sprintf(invoiceTemplate, name, number, amount)
+}
+
+func TestShingleTokens(t *testing.T) {
+	got := shingleTokens("<b>Invoice</b> INV-20931, due 12/05: pay now!")
+	want := []string{"invoice", "inv", "#", "due", "#", "#", "pay", "now"}
+	if !slices.Equal(got, want) {
+		t.Errorf("shingleTokens() = %q, want %q", got, want)
+	}
+}
+
+func TestMinhashSimilarity(t *testing.T) {
+	a, _ := newMinhash(invoiceEmail("Alice", "20931", "1,250.00"))
+	b, _ := newMinhash(invoiceEmail("Bob", "77310", "980.50"))
+	other, _ := newMinhash("Hi team, the quarterly planning meeting moves to Thursday at 10am in room 4. Please update your calendars and bring the roadmap drafts.")
+
+	if s := a.similarity(&b); s < DefaultCampaignSimilarity {
+		t.Errorf("similarity of two variants of one blast = %.2f, want at least %.2f", s, DefaultCampaignSimilarity)
+	}
+	if s := a.similarity(&other); s > 0.1 {
+		t.Errorf("similarity of unrelated emails = %.2f, want close to 0", s)
+	}
+	if !slices.ContainsFunc(a.bandKeys(), func(k int64) bool { return slices.Contains(b.bandKeys(), k) }) {
+		t.Error("variants share no LSH band, they would never be compared")
+	}
+
+	decoded, ok := decodeMinhash(a.encode())
+	if !ok || decoded != a {
+		t.Error("signature does not survive encode/decode")
+	}
+	if _, ok := newMinhash("<p> -- </p>"); ok {
+		t.Error("signature of text without words")
+	}
+}
@@ -0,0 +1,96 @@
+package discovery
+
+import (
+	"context"
+	"sync"
+
+	"github.com/google/uuid"
+)
+
+// processingQueueDepth is how many emails may wait in the users' queues per processing slot
+const processingQueueDepth = 16
+
+// serialExecutor runs tasks submitted for the same user one at a time, in submission order,
+// while tasks for different users run concurrently. Each user with pending work gets its own
+// goroutine, which exits once the user's queue is drained.
+// Emails arrive from a user's poll in received_at order, so processing them in that order
+// means last_email_received only ever moves forward, and never past an email of the same
+// user that has not been stored yet
+type serialExecutor struct {
+	mu     sync.Mutex
+	queues map[uuid.UUID][]task // Present while the user's worker goroutine is running
+	slots  chan struct{}        // One per submitted task not yet run (nil = unbounded)
+}
+
+// task is a unit of work for serialExecutor (an interface, so pooled tasks queue without allocating)
+type task interface {
+	run()
+}
+
+// taskFunc adapts a function to task
+type taskFunc func()
+
+func (f taskFunc) run() { f() }
+
+// newSerialExecutor returns an executor holding up to maxQueued tasks (0 = unbounded)
+func newSerialExecutor(maxQueued int) *serialExecutor {
+	e := &serialExecutor{queues: make(map[uuid.UUID][]task)}
+	if maxQueued > 0 {
+		e.slots = make(chan struct{}, maxQueued)
+	}
+	return e
+}
+
+// submit queues t behind any task already submitted for the user
+// Blocks while the executor is full (backpressure on the caller), until ctx is done
+func (e *serialExecutor) submit(ctx context.Context, userID uuid.UUID, t task) error {
+	if e.slots != nil {
+		select {
+		case e.slots <- struct{}{}:
+		case <-ctx.Done():
+			return ctx.Err()
+		}
+	}
+
+	e.mu.Lock()
+	defer e.mu.Unlock()
+
+	queue, running := e.queues[userID]
+	e.queues[userID] = append(queue, t)
+	if !running {
+		go e.run(userID)
+	}
+	return nil
+}
+
+func (e *serialExecutor) run(userID uuid.UUID) {
+	for {
+		e.mu.Lock()
+		queue := e.queues[userID]
+		if len(queue) == 0 {
+			delete(e.queues, userID)
+			e.mu.Unlock()
+			return
+		}
+		next := queue[0]
+		queue[0] = nil // Let the task be collected once it has run
+		e.queues[userID] = queue[1:]
+		e.mu.Unlock()
+
+		next.run()
+		if e.slots != nil {
+			<-e.slots
+		}
+	}
+}
+
+// pending returns the number of queued tasks (not counting running ones) and users with work
+func (e *serialExecutor) pending() (tasks, users int) {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+
+	for _, queue := range e.queues {
+		tasks += len(queue)
+	}
+	return tasks, len(e.queues)
+}
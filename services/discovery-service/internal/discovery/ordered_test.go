@@ -0,0 +1,172 @@
+package discovery
+
+import (
+	"context"
+	"math/rand"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/google/uuid"
+)
+
+func TestSerialExecutorPreservesPerUserOrder(t *testing.T) {
+	exec := newSerialExecutor(0)
+	ctx := context.Background()
+	users := make([]uuid.UUID, 8)
+	for i := range users {
+		users[i] = uuid.New()
+	}
+
+	const perUser = 200
+	var (
+		mu      sync.Mutex
+		seen    = make(map[uuid.UUID][]int)
+		running = make(map[uuid.UUID]*int32)
+		wg      sync.WaitGroup
+	)
+	for _, u := range users {
+		running[u] = new(int32)
+	}
+
+	// Interleave submissions across users, as the fan-in does
+	for i := 0; i < perUser; i++ {
+		for _, u := range users {
+			u, i := u, i
+			wg.Add(1)
+			exec.submit(ctx, u, taskFunc(func() {
+				defer wg.Done()
+				if n := atomic.AddInt32(running[u], 1); n != 1 {
+					t.Errorf("user %s: %d tasks running concurrently", u, n)
+				}
+				if i%17 == 0 {
+					time.Sleep(time.Millisecond)
+				}
+				mu.Lock()
+				seen[u] = append(seen[u], i)
+				mu.Unlock()
+				atomic.AddInt32(running[u], -1)
+			}))
+		}
+	}
+	wg.Wait()
+
+	for _, u := range users {
+		if len(seen[u]) != perUser {
+			t.Fatalf("user %s: ran %d tasks, want %d", u, len(seen[u]), perUser)
+		}
+		for i, got := range seen[u] {
+			if got != i {
+				t.Fatalf("user %s: task %d ran at position %d", u, got, i)
+			}
+		}
+	}
+
+	// Drained users release their worker
+	if tasks, active := exec.pending(); tasks != 0 || active != 0 {
+		t.Errorf("pending() = %d tasks, %d users after drain, want 0, 0", tasks, active)
+	}
+}
+
+func TestSerialExecutorRunsUsersConcurrently(t *testing.T) {
+	exec := newSerialExecutor(0)
+	ctx := context.Background()
+	blocked := make(chan struct{})
+	done := make(chan struct{})
+
+	// A stalled user must not hold back another user's emails
+	exec.submit(ctx, uuid.New(), taskFunc(func() { <-blocked }))
+	exec.submit(ctx, uuid.New(), taskFunc(func() { close(done) }))
+
+	select {
+	case <-done:
+	case <-time.After(time.Second):
+		t.Fatal("task for second user did not run while first user was blocked")
+	}
+	close(blocked)
+}
+
+func TestSerialExecutorBoundsQueuedTasks(t *testing.T) {
+	exec := newSerialExecutor(2)
+	ctx := context.Background()
+	blocked := make(chan struct{})
+	user := uuid.New()
+
+	// A stalled task and one queued behind it fill the executor
+	for i := 0; i < 2; i++ {
+		if err := exec.submit(ctx, user, taskFunc(func() { <-blocked })); err != nil {
+			t.Fatalf("submit %d: %v", i, err)
+		}
+	}
+	timeout, cancel := context.WithTimeout(ctx, 20*time.Millisecond)
+	defer cancel()
+	if err := exec.submit(timeout, uuid.New(), taskFunc(func() {})); err == nil {
+		t.Fatal("submit to a full executor did not block")
+	}
+
+	// Room frees up as tasks complete
+	close(blocked)
+	done := make(chan struct{})
+	if err := exec.submit(ctx, uuid.New(), taskFunc(func() { close(done) })); err != nil {
+		t.Fatalf("submit after drain: %v", err)
+	}
+	<-done
+}
+
+// cursorRecorder stands in for users.last_email_received with an unconditional
+// update, so any out-of-order processing would show up as a regression
+type cursorRecorder struct {
+	mu      sync.Mutex
+	cursors map[uuid.UUID]time.Time
+	regress int
+}
+
+func (c *cursorRecorder) set(userID uuid.UUID, receivedAt time.Time) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if prev, ok := c.cursors[userID]; ok && receivedAt.Before(prev) {
+		c.regress++
+	}
+	c.cursors[userID] = receivedAt
+}
+
+func TestOrderedProcessingKeepsCursorMonotonic(t *testing.T) {
+	exec := newSerialExecutor(0)
+	ctx := context.Background()
+	rec := &cursorRecorder{cursors: make(map[uuid.UUID]time.Time)}
+	rng := rand.New(rand.NewSource(1))
+
+	users := make([]uuid.UUID, 16)
+	for i := range users {
+		users[i] = uuid.New()
+	}
+
+	// Each poll yields a user's emails in received_at order; processing takes a random time
+	var wg sync.WaitGroup
+	base := time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC)
+	for i := 0; i < 100; i++ {
+		for _, u := range users {
+			u := u
+			receivedAt := base.Add(time.Duration(i) * time.Second)
+			delay := time.Duration(rng.Intn(200)) * time.Microsecond
+			wg.Add(1)
+			exec.submit(ctx, u, taskFunc(func() {
+				defer wg.Done()
+				time.Sleep(delay) // storeEmail, analysis queue
+				rec.set(u, receivedAt)
+			}))
+		}
+	}
+	wg.Wait()
+
+	if rec.regress != 0 {
+		t.Errorf("cursor regressed %d times", rec.regress)
+	}
+	want := base.Add(99 * time.Second)
+	for _, u := range users {
+		if got := rec.cursors[u]; !got.Equal(want) {
+			t.Errorf("user %s cursor = %v, want %v", u, got, want)
+		}
+	}
+}
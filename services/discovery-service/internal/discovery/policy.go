@@ -0,0 +1,255 @@
+package discovery
+
+import (
+	"fmt"
+	"log"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/spf13/viper"
+	"github.com/stoik/vigil/internal/models"
+	"github.com/stoik/vigil/services/discovery-service/internal/provider"
+)
+
+const (
+	DefaultPolicyName     = "default"
+	MinPolicyPollInterval = 5 * time.Second
+)
+
+// PolicyMatch selects users by directory attributes
+// A user matches when every criterion that is set matches one of its values
+type PolicyMatch struct {
+	Groups   []string `mapstructure:"groups" json:"groups,omitempty"`       // Group email addresses
+	OrgUnits []string `mapstructure:"org_units" json:"org_units,omitempty"` // The org unit or any unit below it
+	Domains  []string `mapstructure:"domains" json:"domains,omitempty"`     // Mailbox address domains
+}
+
+func (m PolicyMatch) empty() bool {
+	return len(m.Groups) == 0 && len(m.OrgUnits) == 0 && len(m.Domains) == 0
+}
+
+func (m PolicyMatch) matches(user models.ProviderUser) bool {
+	if len(m.Groups) > 0 && !containsFold(m.Groups, user.Groups...) {
+		return false
+	}
+	if len(m.OrgUnits) > 0 {
+		inUnit := false
+		for _, unit := range m.OrgUnits {
+			unit = strings.TrimRight(unit, "/")
+			if strings.EqualFold(user.OrgUnit, unit) || strings.HasPrefix(strings.ToLower(user.OrgUnit), strings.ToLower(unit)+"/") {
+				inUnit = true
+				break
+			}
+		}
+		if !inUnit {
+			return false
+		}
+	}
+	return len(m.Domains) == 0 || containsFold(m.Domains, senderDomain(user.Email))
+}
+
+// containsFold reports whether any of values is in list, ignoring case
+func containsFold(list []string, values ...string) bool {
+	for _, v := range values {
+		for _, item := range list {
+			if strings.EqualFold(item, v) {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// PolicyConfig is one entry of the policies setting (usually in tenants/<tenant_id>.yaml)
+// Settings left unset fall back to the tenant's
+type PolicyConfig struct {
+	Name            string        `mapstructure:"name"`
+	Match           PolicyMatch   `mapstructure:"match"`
+	PollingInterval time.Duration `mapstructure:"polling_interval"`
+	BodyMode        string        `mapstructure:"body_mode"`
+	Redact          bool          `mapstructure:"redact"`
+	AlertRoute      string        `mapstructure:"alert_route"`
+}
+
+// Policy is the monitoring intensity of a population of users
+type Policy struct {
+	Name            string        `json:"name"`
+	Match           PolicyMatch   `json:"match"`
+	PollingInterval time.Duration `json:"polling_interval"`
+	BodyMode        BodyMode      `json:"body_mode"`
+	// Subject and snippet are not persisted, and analysis messages carry no content
+	Redact bool `json:"redact"`
+	// Passed to analysis with each message, to route the population's detections
+	AlertRoute string `json:"alert_route,omitempty"`
+}
+
+// payloadMode returns the analysis payload mode for the policy's users
+// Redacted users' messages carry no content, nor a URL to fetch it
+func (p *Policy) payloadMode(tenant PayloadMode) PayloadMode {
+	if p.Redact && tenant != PayloadMetadata {
+		return PayloadMetadata
+	}
+	return tenant
+}
+
+// policySet assigns users to the first policy they match, in configured order, when they are
+// added; users matching none get the tenant's settings (the default policy)
+type policySet struct {
+	policies []*Policy
+	fallback *Policy
+	assigned sync.Map // map[uuid.UUID]*Policy
+}
+
+// newPolicySet reads the policies setting; returns an error if a policy is invalid
+// Body modes the provider cannot fetch per user fall back to the tenant's
+func newPolicySet(tenantBodyMode BodyMode, p provider.Provider) (*policySet, error) {
+	set := &policySet{fallback: &Policy{Name: DefaultPolicyName, PollingInterval: PollingInterval, BodyMode: tenantBodyMode}}
+
+	var configs []PolicyConfig
+	if err := viper.UnmarshalKey("policies", &configs); err != nil {
+		return nil, fmt.Errorf("invalid policies: %w", err)
+	}
+	_, perUserFormat := p.(provider.FormatProvider)
+	names := map[string]bool{DefaultPolicyName: true}
+	for i, c := range configs {
+		policy, err := c.resolve(set.fallback)
+		if err == nil && names[policy.Name] {
+			err = fmt.Errorf("duplicate name %q", policy.Name)
+		}
+		if err != nil {
+			return nil, fmt.Errorf("invalid policy %d: %w", i+1, err)
+		}
+		if policy.BodyMode != tenantBodyMode && !perUserFormat {
+			log.Printf("Policy %q: the provider cannot fetch %s emails per user, using %s", policy.Name, policy.BodyMode, tenantBodyMode)
+			policy.BodyMode = tenantBodyMode
+		}
+		names[policy.Name] = true
+		set.policies = append(set.policies, policy)
+	}
+	return set, nil
+}
+
+// checkFingerprintMode returns an error if a policy fetches emails that cannot be fingerprinted
+// in the tenant-wide mode: body fingerprints need bodies. Fingerprints must not depend on the
+// policy, or the same email in two users' mailboxes would not match, nor change with the
+// policies, or stored emails would stop matching new deliveries (see Service.fingerprints).
+func (set *policySet) checkFingerprintMode(mode BodyMode) error {
+	if mode == BodyModeSnippet {
+		return nil // Both formats carry snippets
+	}
+	for _, p := range append(set.policies, set.fallback) {
+		if p.BodyMode == BodyModeSnippet {
+			return fmt.Errorf("policy %q fetches snippets, which cannot be fingerprinted in %s mode: set ingest.fingerprint_mode %s, with ingest.fingerprint_mode_previous %s until stored emails are no longer delivered again",
+				p.Name, mode, BodyModeSnippet, mode)
+		}
+	}
+	return nil
+}
+
+func (c PolicyConfig) resolve(fallback *Policy) (*Policy, error) {
+	if c.Name == "" {
+		return nil, fmt.Errorf("name is required")
+	}
+	if c.Match.empty() {
+		return nil, fmt.Errorf("policy %q matches every user, set match.groups, match.org_units or match.domains", c.Name)
+	}
+	policy := &Policy{Name: c.Name, Match: c.Match, PollingInterval: fallback.PollingInterval, BodyMode: fallback.BodyMode, Redact: c.Redact, AlertRoute: c.AlertRoute}
+	if c.PollingInterval != 0 {
+		if c.PollingInterval < MinPolicyPollInterval {
+			return nil, fmt.Errorf("policy %q: polling_interval must be at least %v", c.Name, MinPolicyPollInterval)
+		}
+		policy.PollingInterval = c.PollingInterval
+	}
+	if c.BodyMode != "" {
+		mode, err := ParseBodyMode(c.BodyMode)
+		if err != nil {
+			return nil, fmt.Errorf("policy %q: %w", c.Name, err)
+		}
+		policy.BodyMode = mode
+	}
+	return policy, nil
+}
+
+// match returns the first policy the user matches, or the default policy
+func (set *policySet) match(user models.ProviderUser) *Policy {
+	for _, p := range set.policies {
+		if p.Match.matches(user) {
+			return p
+		}
+	}
+	return set.fallback
+}
+
+// assign evaluates the policies for a user being added
+func (set *policySet) assign(user models.ProviderUser) {
+	if set == nil {
+		return
+	}
+	policy := set.match(user)
+	if previous, loaded := set.assigned.Swap(user.ID, policy); !loaded || previous.(*Policy) != policy {
+		if policy != set.fallback {
+			log.Printf("📋 User %s under policy %q (polling every %v, %s bodies)", user.ID, policy.Name, policy.PollingInterval, policy.BodyMode)
+		}
+	}
+}
+
+// forget drops a removed user's assignment
+func (set *policySet) forget(userID uuid.UUID) {
+	if set != nil {
+		set.assigned.Delete(userID)
+	}
+}
+
+// PolicyUsage is a policy and the number of users assigned to it
+type PolicyUsage struct {
+	Policy
+	Users int `json:"users"`
+}
+
+// usage lists the policies in evaluation order, then the default policy
+func (set *policySet) usage() []PolicyUsage {
+	if set == nil {
+		return []PolicyUsage{}
+	}
+	counts := make(map[*Policy]int)
+	set.assigned.Range(func(_, value any) bool {
+		counts[value.(*Policy)]++
+		return true
+	})
+	usage := make([]PolicyUsage, 0, len(set.policies)+1)
+	for _, p := range set.policies {
+		usage = append(usage, PolicyUsage{Policy: *p, Users: counts[p]})
+	}
+	usage = append(usage, PolicyUsage{Policy: *set.fallback, Users: counts[set.fallback]})
+	return usage
+}
+
+// policyFor returns the policy a user was assigned when added (the default policy otherwise)
+func (s *Service) policyFor(userID uuid.UUID) *Policy {
+	if s.policies == nil {
+		return &Policy{Name: DefaultPolicyName, PollingInterval: PollingInterval, BodyMode: s.bodyMode}
+	}
+	if p, ok := s.policies.assigned.Load(userID); ok {
+		return p.(*Policy)
+	}
+	return s.policies.fallback
+}
+
+// Policies returns the monitoring policies in evaluation order with their user counts
+func (s *Service) Policies() []PolicyUsage {
+	return s.policies.usage()
+}
+
+// fetchEmails polls a user's emails in the body mode of its policy
+func (s *Service) fetchEmails(userID uuid.UUID, receivedAfter time.Time, mode BodyMode) ([]models.ProviderEmail, error) {
+	if fp, ok := s.provider.(provider.FormatProvider); ok && mode != s.bodyMode {
+		format := provider.EmailFormatFull
+		if mode == BodyModeSnippet {
+			format = provider.EmailFormatMetadata
+		}
+		return fp.GetEmailsFormat(userID, receivedAfter, "received_at", format)
+	}
+	return s.provider.GetEmails(userID, receivedAfter, "received_at")
+}
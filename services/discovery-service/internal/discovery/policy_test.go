@@ -0,0 +1,105 @@
+package discovery
+
+import (
+	"testing"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/spf13/viper"
+	"github.com/stoik/vigil/internal/models"
+	"github.com/stoik/vigil/services/discovery-service/internal/provider"
+)
+
+// formatProvider fetches emails in any format (only the capability is used)
+type formatProvider struct{ provider.Provider }
+
+func (formatProvider) GetEmailsFormat(uuid.UUID, time.Time, string, string) ([]models.ProviderEmail, error) {
+	return nil, nil
+}
+
+func TestPolicySet(t *testing.T) {
+	viper.Set("policies", []map[string]any{
+		{"name": "executives", "match": map[string]any{"groups": []string{"vip@corp.example"}},
+			"polling_interval": "10s", "alert_route": "soc-priority"},
+		{"name": "finance", "match": map[string]any{"org_units": []string{"/Finance/"}, "domains": []string{"corp.example"}},
+			"body_mode": "snippet", "redact": true},
+	})
+	t.Cleanup(viper.Reset)
+
+	set, err := newPolicySet(BodyModeFull, formatProvider{})
+	if err != nil {
+		t.Fatalf("newPolicySet: %v", err)
+	}
+	if len(set.policies) != 2 {
+		t.Fatalf("%d policies, want executives and finance", len(set.policies))
+	}
+
+	users := []struct {
+		user   models.ProviderUser
+		policy string
+	}{
+		{models.ProviderUser{Email: "ceo@corp.example", OrgUnit: "/Finance", Groups: []string{"all@corp.example", "VIP@corp.example"}}, "executives"},
+		{models.ProviderUser{Email: "ap@corp.example", OrgUnit: "/Finance/Payables"}, "finance"},
+		{models.ProviderUser{Email: "ap@subsidiary.example", OrgUnit: "/Finance"}, DefaultPolicyName},
+		{models.ProviderUser{Email: "dev@corp.example", OrgUnit: "/FinanceTools"}, DefaultPolicyName},
+	}
+	for _, u := range users {
+		u.user.ID = uuid.New()
+		set.assign(u.user)
+		if got := set.match(u.user).Name; got != u.policy {
+			t.Errorf("%s in %s matched %q, want %q", u.user.Email, u.user.OrgUnit, got, u.policy)
+		}
+	}
+
+	executives, finance := set.policies[0], set.policies[1]
+	if executives.PollingInterval != 10*time.Second || executives.BodyMode != BodyModeFull || executives.AlertRoute != "soc-priority" {
+		t.Errorf("executives = %+v", executives)
+	}
+	if finance.PollingInterval != PollingInterval || finance.BodyMode != BodyModeSnippet || finance.payloadMode(PayloadFull) != PayloadMetadata || finance.payloadMode(PayloadReference) != PayloadMetadata {
+		t.Errorf("finance = %+v, want tenant polling, snippet bodies and redacted payloads without fetch URLs", finance)
+	}
+	if executives.payloadMode(PayloadReference) != PayloadReference {
+		t.Errorf("executives payload = %s, want the tenant's", executives.payloadMode(PayloadReference))
+	}
+	// A snippet policy is refused while emails are fingerprinted by body, rather than switching
+	// every fingerprint (stored emails would stop matching new deliveries)
+	if err := set.checkFingerprintMode(BodyModeFull); err == nil {
+		t.Error("checkFingerprintMode(full) accepted a snippet policy")
+	}
+	if err := set.checkFingerprintMode(BodyModeSnippet); err != nil {
+		t.Errorf("checkFingerprintMode(snippet): %v", err)
+	}
+	usage := set.usage()
+	if len(usage) != 3 || usage[0].Users != 1 || usage[1].Users != 1 || usage[2].Name != DefaultPolicyName || usage[2].Users != 2 {
+		t.Errorf("usage = %+v, want 1 executive, 1 finance, 2 default", usage)
+	}
+
+	// Without per-user formats, policies keep the tenant's body mode
+	set, err = newPolicySet(BodyModeFull, nil)
+	if err != nil || set.policies[1].BodyMode != BodyModeFull || set.checkFingerprintMode(BodyModeFull) != nil {
+		t.Errorf("finance body mode = %s without per-user formats (%v), want %s", set.policies[1].BodyMode, err, BodyModeFull)
+	}
+}
+
+func TestInvalidPolicies(t *testing.T) {
+	t.Cleanup(viper.Reset)
+	for name, policy := range map[string]map[string]any{
+		"too fast":  {"name": "too-fast", "match": map[string]any{"domains": []string{"corp.example"}}, "polling_interval": "1s"},
+		"no match":  {"name": "everyone"},
+		"no name":   {"match": map[string]any{"domains": []string{"corp.example"}}},
+		"body mode": {"name": "bodies", "match": map[string]any{"domains": []string{"corp.example"}}, "body_mode": "html"},
+		"duplicate": {"name": DefaultPolicyName, "match": map[string]any{"domains": []string{"corp.example"}}},
+	} {
+		viper.Set("policies", []map[string]any{policy})
+		if _, err := newPolicySet(BodyModeFull, formatProvider{}); err == nil {
+			t.Errorf("%s: newPolicySet accepted %v", name, policy)
+		}
+	}
+}
+
+func TestPolicyForUnassignedUser(t *testing.T) {
+	s := &Service{bodyMode: BodyModeSnippet}
+	if p := s.policyFor(uuid.New()); p.Name != DefaultPolicyName || p.BodyMode != BodyModeSnippet || p.PollingInterval != PollingInterval {
+		t.Errorf("policyFor() = %+v, want the tenant's settings", p)
+	}
+}
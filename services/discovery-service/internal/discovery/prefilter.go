@@ -0,0 +1,158 @@
+package discovery
+
+import (
+	"strings"
+	"sync"
+
+	"github.com/stoik/vigil/internal/models"
+)
+
+// Priority reasons reported by the prefilter
+const (
+	PriorityIOC       = "ioc"       // Sender or content references a known-bad domain
+	PriorityLookalike = "lookalike" // Sender domain imitates a protected domain
+)
+
+// homoglyphs maps characters commonly swapped in look-alike domains to their lookalike letter
+var homoglyphs = strings.NewReplacer("0", "o", "1", "l", "3", "e", "5", "s", "rn", "m", "vv", "w")
+
+// prefilter is a cheap classifier run on every email before processing, routing suspected
+// malicious mail to the priority lane. It is not a detector: false positives only cost
+// queue position, so rules favour recall.
+type prefilter struct {
+	iocDomains map[string]bool
+
+	mu        sync.RWMutex
+	protected map[string]bool // Tenant's own domains (configured + learned from user addresses)
+}
+
+func newPrefilter(iocDomains, protectedDomains []string) *prefilter {
+	p := &prefilter{
+		iocDomains: make(map[string]bool, len(iocDomains)),
+		protected:  make(map[string]bool, len(protectedDomains)),
+	}
+	for _, d := range iocDomains {
+		if d = strings.ToLower(strings.TrimSpace(d)); d != "" {
+			p.iocDomains[d] = true
+		}
+	}
+	for _, d := range protectedDomains {
+		p.protect(d)
+	}
+	return p
+}
+
+// protect adds a domain whose look-alikes are prioritized
+func (p *prefilter) protect(domain string) {
+	domain = strings.ToLower(strings.TrimSpace(domain))
+	if domain == "" {
+		return
+	}
+
+	p.mu.RLock()
+	known := p.protected[domain]
+	p.mu.RUnlock()
+	if known {
+		return
+	}
+
+	p.mu.Lock()
+	p.protected[domain] = true
+	p.mu.Unlock()
+}
+
+// match returns why an email should take the priority lane ("" for bulk)
+func (p *prefilter) match(email models.ProviderEmail) string {
+	domain := senderDomain(email.From)
+
+	if len(p.iocDomains) > 0 {
+		if p.isIOC(domain) {
+			return PriorityIOC
+		}
+		content := strings.ToLower(email.Subject + " " + email.Snippet)
+		for ioc := range p.iocDomains {
+			if strings.Contains(content, ioc) {
+				return PriorityIOC
+			}
+		}
+	}
+
+	if domain != "" && p.isLookalike(domain) {
+		return PriorityLookalike
+	}
+	return ""
+}
+
+// isIOC reports whether domain or one of its parent domains is a known-bad domain
+func (p *prefilter) isIOC(domain string) bool {
+	for domain != "" {
+		if p.iocDomains[domain] {
+			return true
+		}
+		dot := strings.Index(domain, ".")
+		if dot < 0 {
+			break
+		}
+		domain = domain[dot+1:]
+	}
+	return false
+}
+
+func (p *prefilter) isLookalike(domain string) bool {
+	p.mu.RLock()
+	defer p.mu.RUnlock()
+
+	if p.protected[domain] {
+		return false
+	}
+	for protected := range p.protected {
+		if lookalikeOf(domain, protected) {
+			return true
+		}
+	}
+	return false
+}
+
+// lookalikeOf reports whether domain imitates protected: homoglyph swaps (c0mpany.com),
+// small typos (companny.com, company.co) or the protected name embedded in another
+// domain (company-payments.com). Subdomains of protected are not look-alikes.
+func lookalikeOf(domain, protected string) bool {
+	if domain == protected || strings.HasSuffix(domain, "."+protected) {
+		return false
+	}
+	if homoglyphs.Replace(domain) == homoglyphs.Replace(protected) {
+		return true
+	}
+
+	name, _, _ := strings.Cut(protected, ".")
+	if len(name) < 5 {
+		// Too short for distance/containment checks without flooding the lane
+		return false
+	}
+	if editDistance(domain, protected) <= 2 {
+		return true
+	}
+	label, _, _ := strings.Cut(domain, ".")
+	return label != name && strings.Contains(label, name)
+}
+
+// editDistance is the Levenshtein distance between a and b
+func editDistance(a, b string) int {
+	prev := make([]int, len(b)+1)
+	curr := make([]int, len(b)+1)
+	for j := range prev {
+		prev[j] = j
+	}
+	for i := 1; i <= len(a); i++ {
+		curr[0] = i
+		for j := 1; j <= len(b); j++ {
+			cost := 1
+			if a[i-1] == b[j-1] {
+				cost = 0
+			}
+			curr[j] = min(prev[j]+1, curr[j-1]+1, prev[j-1]+cost)
+		}
+		prev, curr = curr, prev
+	}
+	return prev[len(b)]
+}
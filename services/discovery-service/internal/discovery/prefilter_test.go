@@ -0,0 +1,77 @@
+package discovery
+
+import (
+	"context"
+	"testing"
+
+	"github.com/stoik/vigil/internal/models"
+)
+
+func TestPrefilterMatch(t *testing.T) {
+	p := newPrefilter([]string{"evil.example", "Phish.net"}, []string{"company.com"})
+	p.protect("enterprise.net")
+
+	tests := []struct {
+		from, subject string
+		want          string
+	}{
+		{"ceo@company.com", "Budget review", ""},
+		{"alerts@mail.company.com", "Weekly report", ""},
+		{"news@dailybrief.io", "Top stories", ""},
+		{"a@evil.example", "hello", PriorityIOC},
+		{"a@login.phish.net", "hello", PriorityIOC},
+		{"a@partner.org", "Please see http://evil.example/invoice", PriorityIOC},
+		{"ceo@c0mpany.com", "Urgent wire transfer", PriorityLookalike},
+		{"accounts@companny.com", "Invoice", PriorityLookalike},
+		{"cfo@company.co", "Are you available?", PriorityLookalike},
+		{"finance@company-payments.com", "Updated bank details", PriorityLookalike},
+		{"it@enterprlse.net", "Password reset", PriorityLookalike},
+		{"Display Name <ceo@c0mpany.com>", "Hi", PriorityLookalike},
+	}
+	for _, tt := range tests {
+		got := p.match(models.ProviderEmail{From: tt.from, Subject: tt.subject})
+		if got != tt.want {
+			t.Errorf("match(%q, %q) = %q, want %q", tt.from, tt.subject, got, tt.want)
+		}
+	}
+}
+
+func TestEditDistance(t *testing.T) {
+	tests := []struct {
+		a, b string
+		want int
+	}{
+		{"", "", 0},
+		{"abc", "", 3},
+		{"company.com", "company.com", 0},
+		{"companny.com", "company.com", 1},
+		{"company.co", "company.com", 1},
+		{"kitten", "sitting", 3},
+	}
+	for _, tt := range tests {
+		if got := editDistance(tt.a, tt.b); got != tt.want {
+			t.Errorf("editDistance(%q, %q) = %d, want %d", tt.a, tt.b, got, tt.want)
+		}
+	}
+}
+
+func TestFanInGroupLanes(t *testing.T) {
+	p := newPrefilter(nil, []string{"company.com"})
+	group := newFanInGroup(func(ewu EmailWithUser) string { return p.match(ewu.Email) })
+
+	ch := make(chan EmailWithUser, 2)
+	ch <- EmailWithUser{Email: models.ProviderEmail{From: "a@company.com"}}
+	ch <- EmailWithUser{Email: models.ProviderEmail{From: "ceo@c0mpany.com"}}
+	close(ch)
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	group.sync(ctx, []<-chan EmailWithUser{ch})
+
+	if bulk := <-group.out; bulk.Priority != "" {
+		t.Errorf("bulk email has priority %q", bulk.Priority)
+	}
+	if urgent := <-group.priority; urgent.Priority != PriorityLookalike {
+		t.Errorf("priority = %q, want %q", urgent.Priority, PriorityLookalike)
+	}
+}
@@ -0,0 +1,114 @@
+package discovery
+
+import (
+	"context"
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/spf13/viper"
+)
+
+// PayloadMode controls how much of an email is put on the analysis queue
+type PayloadMode string
+
+const (
+	PayloadFull      PayloadMode = "full"      // Metadata, snippet, headers and body (if fetched)
+	PayloadMetadata  PayloadMode = "metadata"  // Sender, recipient, subject and headers, no content
+	PayloadReference PayloadMode = "reference" // Metadata plus a URL to fetch the content on demand
+)
+
+// AnalysisSchemaVersion is bumped on incompatible changes to AnalysisMessage
+const AnalysisSchemaVersion = 1
+
+// ParsePayloadMode parses a queue.payload value (empty means full)
+func ParsePayloadMode(value string) (PayloadMode, error) {
+	switch PayloadMode(value) {
+	case "", PayloadFull:
+		return PayloadFull, nil
+	case PayloadMetadata:
+		return PayloadMetadata, nil
+	case PayloadReference:
+		return PayloadReference, nil
+	default:
+		return "", fmt.Errorf("unknown payload mode %q (use %q, %q or %q)", value, PayloadFull, PayloadMetadata, PayloadReference)
+	}
+}
+
+// AnalysisMessage is the JSON envelope published to the analysis queue
+// Fields marked omitempty are absent when the payload mode excludes them:
+// snippet and body only in full mode, fetch_url only in reference mode
+// (body is also absent in full mode when ingest.body_mode is snippet).
+// idempotency_key identifies the email across mailboxes and replays; consumers may dedup on it
+type AnalysisMessage struct {
+	SchemaVersion  int                 `json:"schema_version"`
+	Payload        PayloadMode         `json:"payload"`
+	TenantID       uuid.UUID           `json:"tenant_id"`
+	UserID         uuid.UUID           `json:"user_id"`
+	MessageID      string              `json:"message_id"`
+	IdempotencyKey string              `json:"idempotency_key"` // Content fingerprint, same for every copy
+	ReceivedAt     time.Time           `json:"received_at"`
+	From           string              `json:"from"`
+	To             string              `json:"to"`
+	SenderDomain   string              `json:"sender_domain,omitempty"`
+	Subject        string              `json:"subject"`
+	Priority       string              `json:"priority,omitempty"`      // Prefilter match reason
+	AlertRoute     string              `json:"alert_route,omitempty"`   // Alert route of the user's policy
+	UserReported   bool                `json:"user_reported,omitempty"` // Submitted by the user (report-phish button)
+	ReportID       string              `json:"report_id,omitempty"`
+	Headers        map[string][]string `json:"headers,omitempty"`
+	Snippet        string              `json:"snippet,omitempty"`
+	Body           string              `json:"body,omitempty"`
+	FetchURL       string              `json:"fetch_url,omitempty"` // GET, requires an operator API token
+}
+
+// Publisher delivers analysis messages to the queue
+type Publisher interface {
+	Publish(ctx context.Context, msg AnalysisMessage) error
+}
+
+// discardPublisher drops messages; it stands in until a broker (Kafka/RabbitMQ/NATS) is wired in
+type discardPublisher struct{}
+
+func (discardPublisher) Publish(context.Context, AnalysisMessage) error { return nil }
+
+// newPublisher returns the analysis queue publisher, deduplicating within queue.dedup_window
+// before publishing to sink
+func newPublisher(sink Publisher) Publisher {
+	publisher := sink
+	if window := viper.GetDuration("queue.dedup_window"); window > 0 {
+		publisher = newDedupPublisher(publisher, pgDedupStore{}, window, viper.GetInt("queue.dedup_cache_size"))
+	}
+	return publisher
+}
+
+// buildAnalysisMessage shapes an email for the queue according to the payload mode
+// fetchBaseURL is the discovery API base URL used for reference payloads
+func buildAnalysisMessage(tenantID uuid.UUID, ewu *EmailWithUser, fingerprint string, mode PayloadMode, fetchBaseURL string) AnalysisMessage {
+	email := ewu.Email
+	msg := AnalysisMessage{
+		SchemaVersion:  AnalysisSchemaVersion,
+		Payload:        mode,
+		TenantID:       tenantID,
+		UserID:         ewu.UserID,
+		MessageID:      email.MessageID,
+		IdempotencyKey: fingerprint,
+		ReceivedAt:     email.ReceivedAt,
+		From:           email.From,
+		To:             email.To,
+		SenderDomain:   senderDomain(email.From),
+		Subject:        email.Subject,
+		Priority:       ewu.Priority,
+		Headers:        email.Headers,
+	}
+
+	switch mode {
+	case PayloadFull:
+		msg.Snippet = email.Snippet
+		msg.Body = email.Body
+	case PayloadReference:
+		msg.FetchURL = strings.TrimRight(fetchBaseURL, "/") + "/emails/" + email.MessageID + "/content"
+	}
+	return msg
+}
@@ -0,0 +1,95 @@
+package discovery
+
+import (
+	"encoding/json"
+	"testing"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/stoik/vigil/internal/models"
+)
+
+func TestParsePayloadMode(t *testing.T) {
+	tests := []struct {
+		value   string
+		want    PayloadMode
+		wantErr bool
+	}{
+		{"", PayloadFull, false},
+		{"full", PayloadFull, false},
+		{"metadata", PayloadMetadata, false},
+		{"reference", PayloadReference, false},
+		{"headers", "", true},
+	}
+	for _, tt := range tests {
+		got, err := ParsePayloadMode(tt.value)
+		if (err != nil) != tt.wantErr || got != tt.want {
+			t.Errorf("ParsePayloadMode(%q) = %q, %v; want %q, error %v", tt.value, got, err, tt.want, tt.wantErr)
+		}
+	}
+}
+
+func TestBuildAnalysisMessage(t *testing.T) {
+	tenantID := uuid.New()
+	ewu := EmailWithUser{
+		UserID: uuid.New(),
+		Email: models.ProviderEmail{
+			MessageID:  uuid.NewString(),
+			From:       "Billing <billing@example.com>",
+			To:         "alice@company.com",
+			Subject:    "Invoice",
+			Snippet:    "Please find attached",
+			Body:       "Please find attached the invoice.",
+			ReceivedAt: time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC),
+			Headers:    map[string][]string{"Message-ID": {"<1@example.com>"}},
+		},
+	}
+
+	tests := []struct {
+		mode    PayloadMode
+		present []string
+		absent  []string
+	}{
+		{PayloadFull, []string{"snippet", "body", "headers"}, []string{"fetch_url"}},
+		{PayloadMetadata, []string{"headers"}, []string{"snippet", "body", "fetch_url"}},
+		{PayloadReference, []string{"headers", "fetch_url"}, []string{"snippet", "body"}},
+	}
+
+	for _, tt := range tests {
+		t.Run(string(tt.mode), func(t *testing.T) {
+			msg := buildAnalysisMessage(tenantID, &ewu, "fp", tt.mode, "https://discovery.internal/")
+			raw, err := json.Marshal(msg)
+			if err != nil {
+				t.Fatal(err)
+			}
+			var fields map[string]any
+			if err := json.Unmarshal(raw, &fields); err != nil {
+				t.Fatal(err)
+			}
+
+			for _, key := range []string{"schema_version", "payload", "tenant_id", "user_id", "message_id", "idempotency_key", "received_at", "from", "to", "subject"} {
+				if _, ok := fields[key]; !ok {
+					t.Errorf("missing %q", key)
+				}
+			}
+			for _, key := range tt.present {
+				if _, ok := fields[key]; !ok {
+					t.Errorf("missing %q", key)
+				}
+			}
+			for _, key := range tt.absent {
+				if _, ok := fields[key]; ok {
+					t.Errorf("unexpected %q in %s payload", key, tt.mode)
+				}
+			}
+			if fields["payload"] != string(tt.mode) || fields["sender_domain"] != "example.com" {
+				t.Errorf("payload = %v, sender_domain = %v", fields["payload"], fields["sender_domain"])
+			}
+		})
+	}
+
+	msg := buildAnalysisMessage(tenantID, &ewu, "fp", PayloadReference, "https://discovery.internal/")
+	if want := "https://discovery.internal/emails/" + ewu.Email.MessageID + "/content"; msg.FetchURL != want {
+		t.Errorf("FetchURL = %q, want %q", msg.FetchURL, want)
+	}
+}
@@ -0,0 +1,288 @@
+package discovery
+
+import (
+	"context"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/google/uuid"
+)
+
+const DefaultProcessingCapacity = 256 // Emails processed concurrently across all tenants
+
+// TenantQuota limits a tenant's share of the processing stage
+type TenantQuota struct {
+	MaxInFlight     int     // Max emails processed concurrently (0 = fair share only)
+	EmailsPerSecond float64 // Sustained processing rate (0 = unlimited)
+	Weight          int     // Relative share of capacity under contention (min 1)
+}
+
+// TenantQuotaStats reports a tenant's scheduling state and throttling
+type TenantQuotaStats struct {
+	InFlight      int
+	Throttled     int64         // Acquisitions that had to wait
+	ThrottledTime time.Duration // Total time spent waiting
+}
+
+type tenantState struct {
+	quota    TenantQuota
+	bucket   *tokenBucket
+	inFlight int
+	waiting  int
+
+	throttled     int64 // atomic counter
+	throttledTime int64 // atomic, nanoseconds
+}
+
+// fairScheduler bounds concurrent email processing and shares capacity between tenants
+// by weight: when tenants contend, one at or above its weighted share yields to the others,
+// so a tenant whose mailbox volume spikes cannot starve the rest
+type fairScheduler struct {
+	mu       sync.Mutex
+	capacity int
+	inFlight int
+	tenants  map[uuid.UUID]*tenantState
+	changed  chan struct{} // Closed and replaced whenever a slot frees up
+
+	priorityWaiting int // Priority-lane acquisitions waiting; bulk waits until they are served
+}
+
+var (
+	sharedScheduler     *fairScheduler
+	sharedSchedulerOnce sync.Once
+)
+
+// processingScheduler returns the process-wide scheduler shared by all services
+// The capacity of the first caller wins
+func processingScheduler(capacity int) *fairScheduler {
+	sharedSchedulerOnce.Do(func() {
+		sharedScheduler = newFairScheduler(capacity)
+	})
+	return sharedScheduler
+}
+
+func newFairScheduler(capacity int) *fairScheduler {
+	if capacity < 1 {
+		capacity = DefaultProcessingCapacity
+	}
+	return &fairScheduler{
+		capacity: capacity,
+		tenants:  make(map[uuid.UUID]*tenantState),
+		changed:  make(chan struct{}),
+	}
+}
+
+// register sets (or updates) a tenant's quota
+func (f *fairScheduler) register(tenantID uuid.UUID, quota TenantQuota) {
+	if quota.Weight < 1 {
+		quota.Weight = 1
+	}
+
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	ts, ok := f.tenants[tenantID]
+	if !ok {
+		ts = &tenantState{}
+		f.tenants[tenantID] = ts
+	}
+	ts.quota = quota
+	ts.bucket = nil
+	if quota.EmailsPerSecond > 0 {
+		ts.bucket = newTokenBucket(quota.EmailsPerSecond)
+	}
+	f.notifyLocked()
+}
+
+// acquire blocks until the tenant may process one more email, or ctx is done
+// Priority acquisitions skip the tenant's rate and fair-share limits (not its
+// MaxInFlight or the global capacity) and are served before any waiting bulk work
+func (f *fairScheduler) acquire(ctx context.Context, tenantID uuid.UUID, priority bool) error {
+	f.mu.Lock()
+	ts, ok := f.tenants[tenantID]
+	if !ok {
+		ts = &tenantState{quota: TenantQuota{Weight: 1}}
+		f.tenants[tenantID] = ts
+	}
+	bucket := ts.bucket
+	f.mu.Unlock()
+
+	start := time.Now()
+	waited := false
+
+	// Throughput quota first, so rate-limited tenants don't hold a waiting slot
+	if bucket != nil && !priority {
+		if wait := bucket.reserve(); wait > 0 {
+			waited = true
+			select {
+			case <-time.After(wait):
+			case <-ctx.Done():
+				return ctx.Err()
+			}
+		}
+	}
+
+	f.mu.Lock()
+	ts.waiting++
+	if priority {
+		f.priorityWaiting++
+	}
+	for !f.canRunLocked(ts, priority) {
+		waited = true
+		changed := f.changed
+		f.mu.Unlock()
+
+		select {
+		case <-changed:
+		case <-ctx.Done():
+			f.mu.Lock()
+			ts.waiting--
+			if priority {
+				f.priorityWaiting--
+				f.notifyLocked()
+			}
+			f.mu.Unlock()
+			return ctx.Err()
+		}
+		f.mu.Lock()
+	}
+	ts.waiting--
+	if priority {
+		f.priorityWaiting--
+		if f.priorityWaiting == 0 {
+			// Bulk waiters held back for the priority lane may run now
+			f.notifyLocked()
+		}
+	}
+	ts.inFlight++
+	f.inFlight++
+	f.mu.Unlock()
+
+	if waited {
+		atomic.AddInt64(&ts.throttled, 1)
+		atomic.AddInt64(&ts.throttledTime, int64(time.Since(start)))
+	}
+	return nil
+}
+
+// release frees the slot taken by acquire
+func (f *fairScheduler) release(tenantID uuid.UUID) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	if ts, ok := f.tenants[tenantID]; ok && ts.inFlight > 0 {
+		ts.inFlight--
+		f.inFlight--
+	}
+	f.notifyLocked()
+}
+
+func (f *fairScheduler) notifyLocked() {
+	close(f.changed)
+	f.changed = make(chan struct{})
+}
+
+func (f *fairScheduler) canRunLocked(ts *tenantState, priority bool) bool {
+	if f.inFlight >= f.capacity {
+		return false
+	}
+	if ts.quota.MaxInFlight > 0 && ts.inFlight >= ts.quota.MaxInFlight {
+		return false
+	}
+	if priority {
+		return true
+	}
+	if f.priorityWaiting > 0 {
+		return false
+	}
+	// Above its fair share, a tenant only runs when nobody else could use the slot
+	if ts.inFlight >= f.fairShareLocked(ts) && f.othersRunnableLocked(ts) {
+		return false
+	}
+	return true
+}
+
+// fairShareLocked is the tenant's weighted share of capacity among tenants with work
+func (f *fairScheduler) fairShareLocked(ts *tenantState) int {
+	totalWeight := 0
+	for _, other := range f.tenants {
+		if other == ts || other.inFlight > 0 || other.waiting > 0 {
+			totalWeight += other.quota.Weight
+		}
+	}
+
+	share := f.capacity * ts.quota.Weight / totalWeight
+	if share < 1 {
+		share = 1
+	}
+	return share
+}
+
+// othersRunnableLocked reports whether another tenant is waiting and below its own limits
+func (f *fairScheduler) othersRunnableLocked(ts *tenantState) bool {
+	for _, other := range f.tenants {
+		if other == ts || other.waiting == 0 {
+			continue
+		}
+		if other.quota.MaxInFlight > 0 && other.inFlight >= other.quota.MaxInFlight {
+			continue
+		}
+		if other.inFlight < f.fairShareLocked(other) {
+			return true
+		}
+	}
+	return false
+}
+
+// stats returns the scheduling state of a tenant
+func (f *fairScheduler) stats(tenantID uuid.UUID) TenantQuotaStats {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	ts, ok := f.tenants[tenantID]
+	if !ok {
+		return TenantQuotaStats{}
+	}
+	return TenantQuotaStats{
+		InFlight:      ts.inFlight,
+		Throttled:     atomic.LoadInt64(&ts.throttled),
+		ThrottledTime: time.Duration(atomic.LoadInt64(&ts.throttledTime)),
+	}
+}
+
+// tokenBucket is a minimal rate limiter allowing bursts of up to one second of rate
+type tokenBucket struct {
+	mu     sync.Mutex
+	rate   float64 // Tokens per second
+	burst  float64
+	tokens float64
+	last   time.Time
+}
+
+func newTokenBucket(rate float64) *tokenBucket {
+	burst := rate
+	if burst < 1 {
+		burst = 1
+	}
+	return &tokenBucket{rate: rate, burst: burst, tokens: burst, last: time.Now()}
+}
+
+// reserve takes one token and returns how long the caller must wait before using it
+func (b *tokenBucket) reserve() time.Duration {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	now := time.Now()
+	b.tokens += now.Sub(b.last).Seconds() * b.rate
+	if b.tokens > b.burst {
+		b.tokens = b.burst
+	}
+	b.last = now
+
+	b.tokens--
+	if b.tokens >= 0 {
+		return 0
+	}
+	return time.Duration(-b.tokens / b.rate * float64(time.Second))
+}
@@ -0,0 +1,144 @@
+package discovery
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/google/uuid"
+)
+
+func TestFairSchedulerMaxInFlight(t *testing.T) {
+	f := newFairScheduler(10)
+	tenant := uuid.New()
+	f.register(tenant, TenantQuota{MaxInFlight: 2})
+
+	ctx := context.Background()
+	for i := 0; i < 2; i++ {
+		if err := f.acquire(ctx, tenant, false); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	// Third acquisition must wait for a release
+	timeoutCtx, cancel := context.WithTimeout(ctx, 20*time.Millisecond)
+	defer cancel()
+	if err := f.acquire(timeoutCtx, tenant, false); err == nil {
+		t.Fatal("acquire succeeded above MaxInFlight")
+	}
+
+	f.release(tenant)
+	if err := f.acquire(ctx, tenant, false); err != nil {
+		t.Fatalf("acquire after release: %v", err)
+	}
+	if got := f.stats(tenant); got.InFlight != 2 || got.Throttled != 0 {
+		t.Errorf("stats = %+v, want 2 in flight and 0 throttled", got)
+	}
+}
+
+func TestFairSchedulerSharesCapacity(t *testing.T) {
+	f := newFairScheduler(4)
+	noisy, quiet := uuid.New(), uuid.New()
+	f.register(noisy, TenantQuota{Weight: 1})
+	f.register(quiet, TenantQuota{Weight: 1})
+
+	ctx := context.Background()
+	// Noisy tenant grabs the whole capacity while alone
+	for i := 0; i < 4; i++ {
+		if err := f.acquire(ctx, noisy, false); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	// Both tenants queue up for the next free slot
+	quietDone := make(chan error, 1)
+	go func() { quietDone <- f.acquire(ctx, quiet, false) }()
+	noisyDone := make(chan error, 1)
+	go func() { noisyDone <- f.acquire(ctx, noisy, false) }()
+	time.Sleep(10 * time.Millisecond)
+
+	// Noisy tenant is above its fair share (2 of 4), so the freed slot goes to the quiet one
+	f.release(noisy)
+	select {
+	case err := <-quietDone:
+		if err != nil {
+			t.Fatal(err)
+		}
+	case <-time.After(time.Second):
+		t.Fatal("quiet tenant starved")
+	}
+
+	select {
+	case <-noisyDone:
+		t.Fatal("noisy tenant took a slot above its fair share while the quiet tenant was waiting")
+	case <-time.After(10 * time.Millisecond):
+	}
+
+	// Once the quiet tenant is done, the noisy one may use the slack again
+	f.release(quiet)
+	select {
+	case err := <-noisyDone:
+		if err != nil {
+			t.Fatal(err)
+		}
+	case <-time.After(time.Second):
+		t.Fatal("noisy tenant not resumed after contention ended")
+	}
+	if got := f.stats(noisy); got.InFlight != 4 || got.Throttled != 1 {
+		t.Errorf("noisy stats = %+v, want 4 in flight and 1 throttled", got)
+	}
+}
+
+func TestFairSchedulerPriorityFirst(t *testing.T) {
+	f := newFairScheduler(1)
+	tenant := uuid.New()
+	ctx := context.Background()
+	if err := f.acquire(ctx, tenant, true); err != nil {
+		t.Fatal(err)
+	}
+
+	// Bulk queues first, then priority; the freed slot must go to priority
+	bulkDone := make(chan error, 1)
+	go func() { bulkDone <- f.acquire(ctx, tenant, false) }()
+	time.Sleep(10 * time.Millisecond)
+	priorityDone := make(chan error, 1)
+	go func() { priorityDone <- f.acquire(ctx, tenant, true) }()
+	time.Sleep(10 * time.Millisecond)
+
+	f.release(tenant)
+	select {
+	case err := <-priorityDone:
+		if err != nil {
+			t.Fatal(err)
+		}
+	case <-time.After(time.Second):
+		t.Fatal("priority acquisition not served")
+	}
+	select {
+	case <-bulkDone:
+		t.Fatal("bulk acquisition took the slot ahead of priority")
+	case <-time.After(10 * time.Millisecond):
+	}
+
+	f.release(tenant)
+	select {
+	case err := <-bulkDone:
+		if err != nil {
+			t.Fatal(err)
+		}
+	case <-time.After(time.Second):
+		t.Fatal("bulk acquisition not served after priority")
+	}
+}
+
+func TestTokenBucket(t *testing.T) {
+	b := newTokenBucket(10)
+	for i := 0; i < 10; i++ {
+		if wait := b.reserve(); wait != 0 {
+			t.Fatalf("burst reservation %d waited %v", i, wait)
+		}
+	}
+	if wait := b.reserve(); wait <= 0 || wait > 150*time.Millisecond {
+		t.Errorf("reservation beyond burst waited %v, want ~100ms", wait)
+	}
+}
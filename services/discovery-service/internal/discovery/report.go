@@ -0,0 +1,208 @@
+package discovery
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log"
+	"strings"
+	"sync/atomic"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/jackc/pgx/v5"
+	"github.com/stoik/vigil/internal/models"
+	"github.com/stoik/vigil/services/discovery-service/internal/db"
+	"github.com/stoik/vigil/services/discovery-service/internal/events"
+	"github.com/stoik/vigil/services/discovery-service/internal/provider"
+)
+
+// PriorityUserReported marks emails submitted by a user (priority lane, user_reported in analysis)
+const PriorityUserReported = "user_reported"
+
+var (
+	// ErrReporterNotFound is returned when a report's reporter is not a monitored mailbox
+	ErrReporterNotFound = errors.New("reporter is not a monitored mailbox")
+	// ErrInvalidReport is returned when a report carries neither a message ID nor content
+	ErrInvalidReport = errors.New("invalid report")
+)
+
+// Report is an email submitted by a user, usually from a report-phish button or mailbox add-in
+//
+// Add-ins that know the provider message ID send it, and the email is fetched from the
+// reporter's mailbox; otherwise (or when the provider no longer has it) the submitted content
+// is analyzed.
+type Report struct {
+	Reporter  string         `json:"reporter"`             // Reporting mailbox address
+	MessageID string         `json:"message_id,omitempty"` // Provider message ID
+	Email     *ReportedEmail `json:"email,omitempty"`      // Submitted content
+	Source    string         `json:"source,omitempty"`     // e.g. "outlook-addin", "gmail-addon"
+	Comment   string         `json:"comment,omitempty"`    // Free text from the reporter
+}
+
+// ReportedEmail is the submitted content of a reported email
+type ReportedEmail struct {
+	From       string              `json:"from"`
+	To         string              `json:"to,omitempty"`
+	Subject    string              `json:"subject"`
+	ReceivedAt time.Time           `json:"received_at,omitempty"`
+	Headers    map[string][]string `json:"headers,omitempty"`
+	Snippet    string              `json:"snippet,omitempty"` // Provider snippet, when the add-in has it
+	Body       string              `json:"body,omitempty"`
+}
+
+// ReportResult is the outcome of a report, linked to the stored copy of the email
+type ReportResult struct {
+	ReportID uuid.UUID `json:"report_id"`
+	EmailID  uuid.UUID `json:"email_id"`
+	// The email was already stored (discovered by polling or reported before)
+	AlreadyDiscovered bool       `json:"already_discovered"`
+	ReceivedAt        time.Time  `json:"received_at"`
+	DetectedAt        *time.Time `json:"detected_at,omitempty"` // Set when analysis already flagged it
+	Recipients        int        `json:"recipients"`            // Monitored mailboxes holding a copy
+	Queued            bool       `json:"queued"`                // Sent to analysis as user_reported
+}
+
+// validate checks a report and returns the submitted content as a provider email
+func (r *Report) validate() (models.ProviderEmail, error) {
+	r.Reporter = strings.TrimSpace(r.Reporter)
+	if r.Reporter == "" {
+		return models.ProviderEmail{}, fmt.Errorf("%w: reporter is required", ErrInvalidReport)
+	}
+	if len(r.Source) > 64 {
+		return models.ProviderEmail{}, fmt.Errorf("%w: source is longer than 64 characters", ErrInvalidReport)
+	}
+	if r.Email == nil {
+		if r.MessageID == "" {
+			return models.ProviderEmail{}, fmt.Errorf("%w: message_id or email is required", ErrInvalidReport)
+		}
+		return models.ProviderEmail{}, nil
+	}
+	if r.Email.Body == "" && len(r.Email.Headers) == 0 {
+		return models.ProviderEmail{}, fmt.Errorf("%w: email needs a body or headers", ErrInvalidReport)
+	}
+	e := r.Email
+	email := models.ProviderEmail{From: e.From, To: e.To, Subject: e.Subject, Snippet: e.Snippet, ReceivedAt: e.ReceivedAt, Headers: e.Headers, Body: e.Body}
+	if email.ReceivedAt.IsZero() {
+		email.ReceivedAt = time.Now()
+	}
+	if email.To == "" {
+		email.To = r.Reporter
+	}
+	return email, nil
+}
+
+// SubmitReport runs a user-reported email through the pipeline
+//
+// The email is linked to the reporter (stored if it was never discovered) and sent to analysis
+// with the user_reported flag ahead of bulk traffic. It is queued even when its content was
+// analyzed before: a report is a signal of its own, so each report has its own idempotency key.
+// Cursors are not moved, so the reporter's polls are unaffected.
+func (s *Service) SubmitReport(ctx context.Context, r Report) (ReportResult, error) {
+	submitted, err := r.validate()
+	if err != nil {
+		return ReportResult{}, err
+	}
+
+	var userID uuid.UUID
+	err = db.ReadPool.QueryRow(ctx, `SELECT id FROM users WHERE LOWER(email) = LOWER($1)`, r.Reporter).Scan(&userID)
+	if errors.Is(err, pgx.ErrNoRows) {
+		return ReportResult{}, ErrReporterNotFound
+	}
+	if err != nil {
+		return ReportResult{}, fmt.Errorf("failed to get reporter: %w", err)
+	}
+
+	// The provider's copy is preferred: submitted content is whatever the add-in sent
+	email, fromProvider := submitted, false
+	if r.MessageID != "" {
+		fetched, err := s.provider.GetEmail(userID, r.MessageID)
+		switch {
+		case err == nil:
+			email, fromProvider = fetched, true
+		case r.Email == nil && errors.Is(err, provider.ErrEmailNotFound):
+			return ReportResult{}, ErrEmailNotFound
+		case r.Email == nil:
+			return ReportResult{}, fmt.Errorf("failed to fetch reported email: %w", err)
+		default:
+			log.Printf("Reported email %s not fetched from the provider, using the submitted content: %v", r.MessageID, err)
+		}
+	}
+
+	fingerprint := s.fingerprintAlg.FingerprintEmail(email, s.fingerprintMode)
+	fingerprints := s.fingerprints(email, fingerprint)
+
+	result := ReportResult{ReportID: uuid.New()}
+	err = db.Pool.QueryRow(ctx, `SELECT id, received_at, detected_at FROM emails WHERE fingerprint = ANY($1) LIMIT 1`, fingerprints).
+		Scan(&result.EmailID, &result.ReceivedAt, &result.DetectedAt)
+	switch {
+	case err == nil:
+		// Linked to the stored copy, whose ID is the provider message ID of the first copy seen
+		result.AlreadyDiscovered = true
+		email.MessageID = result.EmailID.String()
+	case errors.Is(err, pgx.ErrNoRows):
+		if !fromProvider {
+			// Never discovered and not fetchable: stored under an ID of its own
+			email.MessageID = uuid.NewString()
+		}
+	default:
+		return ReportResult{}, fmt.Errorf("failed to look up reported email: %w", err)
+	}
+
+	if _, err := s.storeEmail(ctx, email, fingerprint, userID); err != nil {
+		return ReportResult{}, err
+	}
+	if !result.AlreadyDiscovered {
+		if err := db.Pool.QueryRow(ctx, `SELECT id, received_at FROM emails WHERE fingerprint = ANY($1) LIMIT 1`, fingerprints).
+			Scan(&result.EmailID, &result.ReceivedAt); err != nil {
+			return ReportResult{}, fmt.Errorf("failed to get stored email: %w", err)
+		}
+	}
+	if err := db.Pool.QueryRow(ctx, `SELECT COUNT(*) FROM user_emails WHERE email_id = $1`, result.EmailID).Scan(&result.Recipients); err != nil {
+		return ReportResult{}, fmt.Errorf("failed to count recipients: %w", err)
+	}
+
+	if _, err := db.Pool.Exec(ctx,
+		`INSERT INTO reports (id, user_id, email_id, source, comment, already_discovered) VALUES ($1, $2, $3, NULLIF($4, ''), NULLIF($5, ''), $6)`,
+		result.ReportID, userID, result.EmailID, r.Source, r.Comment, result.AlreadyDiscovered,
+	); err != nil {
+		return ReportResult{}, fmt.Errorf("failed to store report: %w", err)
+	}
+	atomic.AddInt64(&s.emailsReported, 1)
+	log.Printf("📬 Email %s reported by user %s (source %q, already discovered: %t, %d recipients)",
+		result.EmailID, userID, r.Source, result.AlreadyDiscovered, result.Recipients)
+
+	data := map[string]any{"email": r.Reporter, "report_id": result.ReportID, "source": r.Source, "already_discovered": result.AlreadyDiscovered,
+		"received_at": result.ReceivedAt, "sender_domain": senderDomain(email.From)}
+	if err := events.Record(ctx, events.TypeEmailReported, &userID, &result.EmailID, data); err != nil {
+		log.Printf("Error recording %s event for email %s: %v", events.TypeEmailReported, result.EmailID, err)
+	}
+
+	result.Queued = s.queueReport(ctx, EmailWithUser{Email: email, UserID: userID, DiscoveredAt: time.Now(), Priority: PriorityUserReported},
+		fingerprint, result.ReportID, fromProvider || result.AlreadyDiscovered)
+	return result, nil
+}
+
+// queueReport sends a reported email to analysis through the priority lane
+// fetchable is false for submitted content the provider cannot return, sent in full rather than
+// by reference
+func (s *Service) queueReport(ctx context.Context, ewu EmailWithUser, fingerprint string, reportID uuid.UUID, fetchable bool) bool {
+	if s.scheduler != nil {
+		if err := s.scheduler.acquire(ctx, s.tenantID, true); err != nil {
+			return false
+		}
+		defer s.releaseSlot(ctx)
+		atomic.AddInt64(&s.emailsPrioritized, 1)
+	}
+
+	policy := s.policyFor(ewu.UserID)
+	mode := policy.payloadMode(s.payloadMode)
+	if mode == PayloadReference && !fetchable {
+		mode = PayloadFull
+	}
+	msg := buildAnalysisMessage(s.tenantID, &ewu, fingerprint, mode, s.fetchBaseURL)
+	msg.AlertRoute = policy.AlertRoute
+	msg.UserReported, msg.ReportID = true, reportID.String()
+	msg.IdempotencyKey = "report:" + reportID.String()
+	return s.publishAnalysis(ctx, msg) == nil
+}
@@ -0,0 +1,34 @@
+package discovery
+
+import (
+	"errors"
+	"testing"
+)
+
+func TestReportValidate(t *testing.T) {
+	invalid := []Report{
+		{MessageID: "4f1c"},
+		{Reporter: "  "},
+		{Reporter: "alice@corp.example"},
+		{Reporter: "alice@corp.example", Email: &ReportedEmail{From: "it@corp-helpdesk.example", Subject: "Password expiry"}},
+	}
+	for _, r := range invalid {
+		if _, err := r.validate(); !errors.Is(err, ErrInvalidReport) {
+			t.Errorf("validate(%+v) = %v, want ErrInvalidReport", r, err)
+		}
+	}
+
+	r := Report{Reporter: " alice@corp.example ", MessageID: "4f1c"}
+	if _, err := r.validate(); err != nil || r.Reporter != "alice@corp.example" {
+		t.Errorf("validate() = %v, reporter %q", err, r.Reporter)
+	}
+
+	r = Report{Reporter: "alice@corp.example", Email: &ReportedEmail{From: "it@corp-helpdesk.example", Subject: "Password expiry", Body: "Reset it here"}}
+	email, err := r.validate()
+	if err != nil {
+		t.Fatalf("validate() = %v", err)
+	}
+	if email.To != "alice@corp.example" || email.ReceivedAt.IsZero() || email.Body != "Reset it here" || email.MessageID != "" {
+		t.Errorf("submitted email = %+v, want the reporter as recipient, a received time and no message ID", email)
+	}
+}
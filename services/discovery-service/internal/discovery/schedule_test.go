@@ -0,0 +1,71 @@
+package discovery
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/stoik/vigil/internal/clock"
+	"github.com/stoik/vigil/internal/models"
+	discoverymodels "github.com/stoik/vigil/services/discovery-service/internal/models"
+)
+
+// pollRecorder is a provider that reports when each poll happened on its clock
+type pollRecorder struct {
+	clock clock.Clock
+	polls chan time.Time
+}
+
+func (p *pollRecorder) GetUsers(uuid.UUID) ([]models.ProviderUser, error) { return nil, nil }
+
+func (p *pollRecorder) GetEmails(uuid.UUID, time.Time, string) ([]models.ProviderEmail, error) {
+	p.polls <- p.clock.Now()
+	return nil, nil
+}
+
+func (p *pollRecorder) GetEmail(uuid.UUID, string) (models.ProviderEmail, error) {
+	return models.ProviderEmail{}, nil
+}
+
+func TestPollingSchedule(t *testing.T) {
+	epoch := time.Date(2024, 3, 1, 12, 0, 0, 0, time.UTC)
+	clk := clock.NewFake(epoch)
+	recorder := &pollRecorder{clock: clk, polls: make(chan time.Time, 1)}
+	s := &Service{clock: clk, provider: recorder, userCache: newUserCache(time.Hour)}
+	user := discoverymodels.User{ID: uuid.New(), Email: "user@example.com"}
+	s.userCache.put(user)
+
+	ctx, cancel := context.WithCancel(context.Background())
+	emailCh := s.discoverEmailsForUser(ctx, user, nil)
+
+	// First poll after the user's staggered delay
+	delay := s.calculateInitialDelay(user.ID)
+	clk.BlockUntil(1)
+	clk.Advance(delay - time.Nanosecond)
+	select {
+	case at := <-recorder.polls:
+		t.Fatalf("polled at %v, before the initial delay %v", at, delay)
+	default:
+	}
+	clk.Advance(time.Nanosecond)
+	if at := <-recorder.polls; !at.Equal(epoch.Add(delay)) {
+		t.Fatalf("first poll at %v, want after %v", at, delay)
+	}
+
+	// Then every polling interval
+	for i := 1; i <= 3; i++ {
+		clk.BlockUntil(1)
+		clk.Advance(PollingInterval)
+		if at, want := <-recorder.polls, epoch.Add(delay+time.Duration(i)*PollingInterval); !at.Equal(want) {
+			t.Fatalf("poll %d at %v, want %v", i+1, at, want)
+		}
+	}
+	if at, _ := s.lastPollAt.Load(user.ID); !at.(time.Time).Equal(epoch.Add(delay + 3*PollingInterval)) {
+		t.Errorf("last poll at %v, want the fake clock's time", at)
+	}
+
+	cancel()
+	for range emailCh {
+	}
+}
@@ -0,0 +1,249 @@
+package discovery
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"net/mail"
+	"strings"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/stoik/vigil/services/discovery-service/internal/db"
+)
+
+const (
+	DefaultSearchLimit = 50
+	MaxSearchLimit     = 500
+)
+
+// ErrInvalidCursor is returned when a search cursor cannot be decoded
+var ErrInvalidCursor = errors.New("invalid search cursor")
+
+// EmailQuery filters stored email metadata; zero-valued fields are ignored
+type EmailQuery struct {
+	User         string    // User ID or mailbox address
+	SenderDomain string    // Sender domain, case-insensitive
+	From         time.Time // received_at >= From
+	To           time.Time // received_at < To
+	HasDetection *bool     // Only emails with (true) or without (false) a detection
+	Fingerprint  string
+	Campaign     *uuid.UUID // Emails of a campaign
+	Subject      string     // Exact subject, matched by hash (only stored with storage.metadata)
+	Text         string     // Full-text query over subject and snippet (web search syntax)
+	Ascending    bool       // Sort by received_at oldest first (default newest first)
+	Limit        int        // Page size (default DefaultSearchLimit, max MaxSearchLimit)
+	Cursor       string     // NextCursor of the previous page
+}
+
+// EmailResult is one stored email matching a search
+type EmailResult struct {
+	ID           uuid.UUID  `json:"id"`
+	Fingerprint  string     `json:"fingerprint"`
+	ReceivedAt   time.Time  `json:"received_at"`
+	SenderDomain string     `json:"sender_domain,omitempty"`
+	DetectedAt   *time.Time `json:"detected_at,omitempty"`
+	Subject      string     `json:"subject,omitempty"` // Only stored with search.store_text
+	Snippet      string     `json:"snippet,omitempty"`
+	SubjectHash  string     `json:"subject_hash,omitempty"` // Only stored with storage.metadata
+	SizeBytes    *int       `json:"size_bytes,omitempty"`
+	CampaignID   *uuid.UUID `json:"campaign_id,omitempty"` // Set once clustered with similar emails
+	Users        []string   `json:"users"`                 // Mailboxes the email was delivered to
+}
+
+// EmailPage is a page of search results
+// NextCursor is empty on the last page
+type EmailPage struct {
+	Emails     []EmailResult `json:"emails"`
+	NextCursor string        `json:"next_cursor,omitempty"`
+}
+
+// SearchEmails queries stored email metadata on the read pool
+// Pagination is keyset-based on (received_at, id), so pages stay stable while emails are ingested
+func (s *Service) SearchEmails(ctx context.Context, q EmailQuery) (EmailPage, error) {
+	query, args, limit, err := buildSearchQuery(q)
+	if err != nil {
+		return EmailPage{}, err
+	}
+
+	rows, err := db.ReadPool.Query(ctx, query, args...)
+	if err != nil {
+		return EmailPage{}, fmt.Errorf("failed to search emails: %w", err)
+	}
+	defer rows.Close()
+
+	page := EmailPage{Emails: []EmailResult{}}
+	for rows.Next() {
+		var r EmailResult
+		var senderDomain, subject, snippet, subjectHash *string
+		if err := rows.Scan(&r.ID, &r.Fingerprint, &r.ReceivedAt, &senderDomain, &r.DetectedAt, &subject, &snippet,
+			&subjectHash, &r.SizeBytes, &r.CampaignID, &r.Users); err != nil {
+			return EmailPage{}, fmt.Errorf("failed to scan email: %w", err)
+		}
+		if senderDomain != nil {
+			r.SenderDomain = *senderDomain
+		}
+		if subject != nil {
+			r.Subject = *subject
+		}
+		if snippet != nil {
+			r.Snippet = *snippet
+		}
+		if subjectHash != nil {
+			r.SubjectHash = *subjectHash
+		}
+		page.Emails = append(page.Emails, r)
+	}
+	if err := rows.Err(); err != nil {
+		return EmailPage{}, fmt.Errorf("failed to search emails: %w", err)
+	}
+
+	// One extra row was fetched to know whether another page exists
+	if len(page.Emails) > limit {
+		page.Emails = page.Emails[:limit]
+		last := page.Emails[limit-1]
+		page.NextCursor = encodeSearchCursor(last.ReceivedAt, last.ID)
+	}
+	return page, nil
+}
+
+// buildSearchQuery returns the SQL, its arguments and the effective page size for q
+func buildSearchQuery(q EmailQuery) (string, []any, int, error) {
+	limit := q.Limit
+	if limit <= 0 {
+		limit = DefaultSearchLimit
+	}
+	if limit > MaxSearchLimit {
+		limit = MaxSearchLimit
+	}
+
+	var where []string
+	var args []any
+	arg := func(v any) string {
+		args = append(args, v)
+		return fmt.Sprintf("$%d", len(args))
+	}
+
+	if q.User != "" {
+		if userID, err := uuid.Parse(q.User); err == nil {
+			where = append(where, "EXISTS (SELECT 1 FROM user_emails f WHERE f.email_id = e.id AND f.user_id = "+arg(userID)+")")
+		} else {
+			where = append(where, "EXISTS (SELECT 1 FROM user_emails f JOIN users fu ON fu.id = f.user_id WHERE f.email_id = e.id AND fu.email = "+arg(strings.ToLower(q.User))+")")
+		}
+	}
+	if q.SenderDomain != "" {
+		where = append(where, "e.sender_domain = "+arg(strings.ToLower(q.SenderDomain)))
+	}
+	if !q.From.IsZero() {
+		where = append(where, "e.received_at >= "+arg(q.From))
+	}
+	if !q.To.IsZero() {
+		where = append(where, "e.received_at < "+arg(q.To))
+	}
+	if q.HasDetection != nil {
+		if *q.HasDetection {
+			where = append(where, "e.detected_at IS NOT NULL")
+		} else {
+			where = append(where, "e.detected_at IS NULL")
+		}
+	}
+	if q.Fingerprint != "" {
+		where = append(where, "e.fingerprint = "+arg(strings.ToLower(q.Fingerprint)))
+	}
+	if q.Campaign != nil {
+		where = append(where, "e.campaign_id = "+arg(*q.Campaign))
+	}
+	if q.Subject != "" {
+		where = append(where, "e.subject_hash = "+arg(SubjectHash(q.Subject)))
+	}
+	if q.Text != "" {
+		where = append(where, "e.search_vector @@ websearch_to_tsquery('english', "+arg(q.Text)+")")
+	}
+
+	order, cmp := "DESC", "<"
+	if q.Ascending {
+		order, cmp = "ASC", ">"
+	}
+	if q.Cursor != "" {
+		receivedAt, id, err := decodeSearchCursor(q.Cursor)
+		if err != nil {
+			return "", nil, 0, err
+		}
+		where = append(where, fmt.Sprintf("(e.received_at, e.id) %s (%s, %s)", cmp, arg(receivedAt), arg(id)))
+	}
+
+	var sb strings.Builder
+	sb.WriteString(`SELECT e.id, e.fingerprint, e.received_at, e.sender_domain, e.detected_at, e.subject, e.snippet, e.subject_hash, e.size_bytes, e.campaign_id,
+		COALESCE(ARRAY(SELECT u.email FROM user_emails ue JOIN users u ON u.id = ue.user_id WHERE ue.email_id = e.id ORDER BY u.email), '{}')
+		FROM emails e`)
+	if len(where) > 0 {
+		sb.WriteString("\n\t\tWHERE ")
+		sb.WriteString(strings.Join(where, "\n\t\t\tAND "))
+	}
+	fmt.Fprintf(&sb, "\n\t\tORDER BY e.received_at %s, e.id %s\n\t\tLIMIT %s", order, order, arg(limit+1))
+
+	return sb.String(), args, limit, nil
+}
+
+func encodeSearchCursor(receivedAt time.Time, id uuid.UUID) string {
+	raw := receivedAt.UTC().Format(time.RFC3339Nano) + "|" + id.String()
+	return base64.RawURLEncoding.EncodeToString([]byte(raw))
+}
+
+func decodeSearchCursor(cursor string) (time.Time, uuid.UUID, error) {
+	raw, err := base64.RawURLEncoding.DecodeString(cursor)
+	if err != nil {
+		return time.Time{}, uuid.Nil, ErrInvalidCursor
+	}
+	ts, idStr, ok := strings.Cut(string(raw), "|")
+	if !ok {
+		return time.Time{}, uuid.Nil, ErrInvalidCursor
+	}
+	receivedAt, err := time.Parse(time.RFC3339Nano, ts)
+	if err != nil {
+		return time.Time{}, uuid.Nil, ErrInvalidCursor
+	}
+	id, err := uuid.Parse(idStr)
+	if err != nil {
+		return time.Time{}, uuid.Nil, ErrInvalidCursor
+	}
+	return receivedAt, id, nil
+}
+
+// senderDomain extracts the lower-cased domain of a From header ("" if unparseable)
+// Only the domain is stored, keeping sender addresses out of the database
+func senderDomain(from string) string {
+	addr := from
+	if parsed, err := mail.ParseAddress(from); err == nil {
+		addr = parsed.Address
+	}
+	at := strings.LastIndex(addr, "@")
+	if at < 0 || at == len(addr)-1 {
+		return ""
+	}
+	return strings.ToLower(strings.TrimRight(addr[at+1:], ">"))
+}
+
+// subjectPrefixes are reply/forward markers stripped before hashing, so a thread shares one hash
+var subjectPrefixes = []string{"re:", "fw:", "fwd:"}
+
+// SubjectHash returns the hex SHA-256 of a normalized subject: lower-cased, whitespace collapsed,
+// reply/forward prefixes removed. It groups emails by subject without storing the subject itself;
+// short or common subjects can be guessed from their hash, so it is not a secrecy measure
+func SubjectHash(subject string) string {
+	s := strings.ToLower(strings.Join(strings.Fields(subject), " "))
+	for trimmed := true; trimmed; {
+		trimmed = false
+		for _, prefix := range subjectPrefixes {
+			if strings.HasPrefix(s, prefix) {
+				s = strings.TrimSpace(s[len(prefix):])
+				trimmed = true
+			}
+		}
+	}
+	sum := sha256.Sum256([]byte(s))
+	return hex.EncodeToString(sum[:])
+}
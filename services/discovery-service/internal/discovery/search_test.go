@@ -0,0 +1,105 @@
+package discovery
+
+import (
+	"errors"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/google/uuid"
+)
+
+func TestSearchCursorRoundTrip(t *testing.T) {
+	receivedAt := time.Date(2024, 3, 1, 12, 30, 0, 123456789, time.UTC)
+	id := uuid.New()
+
+	gotAt, gotID, err := decodeSearchCursor(encodeSearchCursor(receivedAt, id))
+	if err != nil {
+		t.Fatalf("decode: %v", err)
+	}
+	if !gotAt.Equal(receivedAt) || gotID != id {
+		t.Errorf("round trip = (%v, %v), want (%v, %v)", gotAt, gotID, receivedAt, id)
+	}
+
+	for _, bad := range []string{"!!!", "bm8tc2VwYXJhdG9y", encodeSearchCursor(receivedAt, id)[:10]} {
+		if _, _, err := decodeSearchCursor(bad); !errors.Is(err, ErrInvalidCursor) {
+			t.Errorf("decodeSearchCursor(%q) err = %v, want ErrInvalidCursor", bad, err)
+		}
+	}
+}
+
+func TestBuildSearchQuery(t *testing.T) {
+	hasDetection := true
+	q := EmailQuery{
+		User:         "Alice@Example.com",
+		SenderDomain: "Evil.COM",
+		From:         time.Now().Add(-time.Hour),
+		HasDetection: &hasDetection,
+		Text:         `"wire transfer" -newsletter`,
+		Ascending:    true,
+		Limit:        10,
+		Cursor:       encodeSearchCursor(time.Now(), uuid.New()),
+	}
+	query, args, limit, err := buildSearchQuery(q)
+	if err != nil {
+		t.Fatalf("buildSearchQuery: %v", err)
+	}
+	if limit != 10 {
+		t.Errorf("limit = %d, want 10", limit)
+	}
+	for _, want := range []string{"fu.email = $1", "e.sender_domain = $2", "e.received_at >= $3",
+		"e.detected_at IS NOT NULL", "websearch_to_tsquery('english', $4)", "(e.received_at, e.id) > ($5, $6)",
+		"ORDER BY e.received_at ASC", "LIMIT $7"} {
+		if !strings.Contains(query, want) {
+			t.Errorf("query missing %q:\n%s", want, query)
+		}
+	}
+	if len(args) != 7 || args[0] != "alice@example.com" || args[1] != "evil.com" || args[6] != 11 {
+		t.Errorf("args = %v", args)
+	}
+
+	if _, _, limit, _ := buildSearchQuery(EmailQuery{Limit: 1 << 20}); limit != MaxSearchLimit {
+		t.Errorf("limit = %d, want capped at %d", limit, MaxSearchLimit)
+	}
+	if _, _, _, err := buildSearchQuery(EmailQuery{Cursor: "garbage"}); !errors.Is(err, ErrInvalidCursor) {
+		t.Errorf("err = %v, want ErrInvalidCursor", err)
+	}
+}
+
+func TestSenderDomain(t *testing.T) {
+	tests := map[string]string{
+		"alice@example.com":             "example.com",
+		"Alice <Alice@Example.COM>":     "example.com",
+		`"Bob, Jr" <bob@mail.corp.org>`: "mail.corp.org",
+		"undisclosed-recipients":        "",
+		"":                              "",
+	}
+	for from, want := range tests {
+		if got := senderDomain(from); got != want {
+			t.Errorf("senderDomain(%q) = %q, want %q", from, got, want)
+		}
+	}
+}
+
+func TestSubjectHash(t *testing.T) {
+	want := SubjectHash("Invoice overdue")
+	for _, subject := range []string{"invoice overdue", "  Invoice   OVERDUE ", "Re: Invoice overdue", "RE: Fwd: re:Invoice overdue"} {
+		if got := SubjectHash(subject); got != want {
+			t.Errorf("SubjectHash(%q) = %s, want %s", subject, got, want)
+		}
+	}
+	if SubjectHash("Invoice paid") == want {
+		t.Error("different subjects hashed equal")
+	}
+	if len(want) != 64 {
+		t.Errorf("hash length = %d, want 64", len(want))
+	}
+
+	query, args, _, err := buildSearchQuery(EmailQuery{Subject: "Re: Invoice overdue"})
+	if err != nil {
+		t.Fatalf("buildSearchQuery: %v", err)
+	}
+	if !strings.Contains(query, "e.subject_hash = $1") || args[0] != want {
+		t.Errorf("subject filter not applied by hash: %s %v", query, args)
+	}
+}
@@ -2,7 +2,6 @@ package discovery
 
 import (
 	"context"
-	"crypto/sha256"
 	"encoding/binary"
 	"errors"
 	"fmt"
@@ -16,10 +15,17 @@ import (
 
 	"github.com/google/uuid"
 	"github.com/jackc/pgx/v5"
+	"github.com/jackc/pgx/v5/pgconn"
+	"github.com/spf13/viper"
+	"github.com/stoik/vigil/internal/clock"
 	"github.com/stoik/vigil/internal/models"
 	"github.com/stoik/vigil/services/discovery-service/internal/db"
+	"github.com/stoik/vigil/services/discovery-service/internal/events"
+	"github.com/stoik/vigil/services/discovery-service/internal/logging"
 	discoverymodels "github.com/stoik/vigil/services/discovery-service/internal/models"
+	"github.com/stoik/vigil/services/discovery-service/internal/privacy"
 	"github.com/stoik/vigil/services/discovery-service/internal/provider"
+	"github.com/stoik/vigil/services/discovery-service/internal/telemetry"
 )
 
 // UserMessage represents a message from user discovery to email discovery
@@ -30,6 +36,7 @@ type UserMessage struct {
 
 type Service struct {
 	provider provider.Provider
+	tenantID uuid.UUID
 	// Message channel for user discovery to communicate with email discovery
 	userMessages chan UserMessage
 	activeUsers  sync.Map // map[uuid.UUID]*userEmailDiscovery
@@ -42,15 +49,92 @@ type Service struct {
 	emailsPerUser    sync.Map // map[uuid.UUID]*int64 (atomic counter)
 	emailsToQueue    int64    // atomic counter
 	emailsDiscovered int64    // atomic counter
+	emailsPolled     int64    // atomic counter, emails fetched from the provider
+	emailsProcessed  int64    // atomic counter, emails through the processing stage
+	// Ingest throughput vs demand, saturation signals
+	capacity *capacityController
+	// Provider directory vs monitored mailboxes
+	coverage *coverageJob
+	// End-to-end ingest latency (provider received_at -> discovery/store/queue)
+	latency   *latencyTracker
+	ingestSLO time.Duration // p95 received_at -> queue latency objective
+	// How far behind the cursor each poll reaches to catch out-of-order deliveries
+	pollingLookback time.Duration
+	// Emails a poll may hand to processing; the rest is backfilled (see capPoll)
+	maxEmailsPerPoll int
+	backfillBatch    int
+	backfills        sync.Map // map[uuid.UUID]*backfill
+	pollsCapped      int64    // atomic counter
 	// WaitGroup to track active email processing goroutines
-	processingWg sync.WaitGroup
+	processingWg       sync.WaitGroup
+	processingInFlight int64 // atomic counter, mirrors processingWg depth
+	// Runs each user's emails one at a time in poll order, so cursors never regress
+	ordered *serialExecutor
+	// Diagnostics (see DumpState)
+	lastPollAt sync.Map // map[uuid.UUID]time.Time
+	// Last failed poll of each user, cleared by a successful poll (see coverage)
+	pollFailures sync.Map // map[uuid.UUID]pollFailure
+	fanInSize    int64    // atomic, number of channels in the current fan-in
+	// Time source of the polling schedule and metrics log (nil = clock.Real, tests inject a fake)
+	clock clock.Clock
+	// How often logMetrics runs (atomic, 0 = off), adjustable at runtime
+	metricsInterval        int64
+	metricsIntervalChanged chan struct{}
+	// User rows cached between polls
+	userCache *userCache
+	// Emails held while the database is unavailable, replayed once it recovers
+	spill *spillBuffer
+	// Emails pulled from the provider and not ingested yet, replayed after a crash (nil when off)
+	journal *ingestJournal
+	// Windows during which polling pauses or slows down
+	maintenance *maintenanceSchedule
+	// Whether bodies are fetched (the default policy's body mode)
+	bodyMode BodyMode
+	// Per-population polling interval, body mode, redaction and alert route
+	policies *policySet
+	// How every email is fingerprinted, whatever its user's policy (see policySet.checkFingerprintMode),
+	// and the mode emails were fingerprinted with before a switch, if still matched (see fingerprints)
+	fingerprintMode         BodyMode
+	previousFingerprintMode BodyMode
+	// Fingerprint hash, and earlier ones still found in the emails table (see fingerprints)
+	fingerprintAlg       FingerprintAlgorithm
+	previousFingerprints []FingerprintAlgorithm
+	// Whether subject and snippet are persisted for full-text search
+	storeText bool
+	// Whether a subject hash and message size are persisted alongside the sender domain
+	storeMetadata bool
+	// Analysis queue: how much of each email is published, and where
+	payloadMode  PayloadMode
+	fetchBaseURL string // Discovery API base URL for reference payloads
+	publisher    Publisher
+	batcher      *batchPublisher // nil when each message is published on its own (queue.batch_size 1)
+	// Analysis messages dropped as replays of an already published email
+	emailsDeduplicated int64 // atomic counter
+	// Deliveries that did not store a new email (see dedupStats)
+	emailsDuplicate    int64 // atomic counter, already stored for the user (re-polled, redelivered)
+	fingerprintMatches int64 // atomic counter, stored for another user and linked by fingerprint
+	// Routes suspected-malicious emails to the priority lane
+	prefilter         *prefilter
+	emailsPrioritized int64 // atomic counter
+	emailsReported    int64 // atomic counter, user-reported emails (see SubmitReport)
+	// Processing stage scheduler (shared across tenants in this process) and this tenant's quota
+	scheduler *fairScheduler
+	quota     TenantQuota
+	// Clusters similar emails into campaigns, nil when disabled
+	campaigns *campaignJob
+	// Admin bulk operations submitted to this instance
+	jobs *jobRunner
+	// Built-in alert rules (GET /alerts)
+	alerts *alertEvaluator
 }
 
 type userEmailDiscovery struct {
-	user    discoverymodels.User
-	ctx     context.Context
-	cancel  context.CancelFunc
-	channel <-chan EmailWithUser
+	user      discoverymodels.User
+	ctx       context.Context
+	cancel    context.CancelFunc
+	channel   <-chan EmailWithUser
+	pollNow   chan<- struct{} // Triggers a poll ahead of the next tick (see JobPollUsers)
+	startedAt time.Time
 }
 
 const (
@@ -59,14 +143,183 @@ const (
 	PollingInterval   = 30 * time.Second // Fixed 30 seconds for all users
 	ChannelBufferSize = 50               // Buffered channel size per user
 	PollingJitterMax  = 30 * time.Second // Maximum jitter to stagger initial polls
+	DefaultLookback   = 1 * time.Second  // Default cursor buffer for timing/clock skew
+	MaxPollBackoff    = 15 * time.Minute // Upper bound for rate-limit/unauthorized backoff
+
+	DefaultMetricsLogInterval = 5 * time.Second
 )
 
-func NewService() *Service {
-	return &Service{
-		provider:        provider.NewProvider(),
+// NewService creates a service polling the live provider configured in provider.*
+func NewService() (*Service, error) {
+	return NewServiceWithProvider(provider.NewProvider())
+}
+
+// NewServiceWithProvider creates a service polling p (e.g. a recording or a replayed session)
+// Returns an error when the configuration is invalid
+func NewServiceWithProvider(p provider.Provider) (*Service, error) {
+	bodyMode, err := ParseBodyMode(viper.GetString("ingest.body_mode"))
+	if err != nil {
+		log.Printf("Invalid ingest.body_mode, using %q: %v", BodyModeFull, err)
+		bodyMode = BodyModeFull
+	}
+
+	fingerprintAlg, err := ParseFingerprintAlgorithm(viper.GetString("ingest.fingerprint"))
+	if err != nil {
+		log.Printf("Invalid ingest.fingerprint, using %q: %v", FingerprintSHA256, err)
+		fingerprintAlg = FingerprintSHA256
+	}
+	var previousFingerprints []FingerprintAlgorithm
+	for _, value := range viper.GetStringSlice("ingest.fingerprint_previous") {
+		alg, err := ParseFingerprintAlgorithm(value)
+		if err != nil {
+			log.Printf("Ignoring ingest.fingerprint_previous entry: %v", err)
+			continue
+		}
+		if alg != fingerprintAlg {
+			previousFingerprints = append(previousFingerprints, alg)
+		}
+	}
+	// Defaults to the tenant's body mode, so policies never change it implicitly
+	fingerprintMode := bodyMode
+	if value := viper.GetString("ingest.fingerprint_mode"); value != "" {
+		if fingerprintMode, err = ParseBodyMode(value); err != nil {
+			return nil, fmt.Errorf("invalid ingest.fingerprint_mode: %w", err)
+		}
+	}
+	var previousFingerprintMode BodyMode
+	if value := viper.GetString("ingest.fingerprint_mode_previous"); value != "" {
+		previousFingerprintMode, err = ParseBodyMode(value)
+		if err != nil {
+			log.Printf("Ignoring ingest.fingerprint_mode_previous: %v", err)
+		}
+		if previousFingerprintMode == fingerprintMode {
+			previousFingerprintMode = ""
+		}
+	}
+
+	ingestSLO := viper.GetDuration("slo.ingest_p95")
+	if ingestSLO <= 0 {
+		ingestSLO = DefaultIngestSLO
+	}
+
+	userCacheTTL := viper.GetDuration("cache.user_ttl")
+	if userCacheTTL <= 0 {
+		userCacheTTL = DefaultUserCacheTTL
+	}
+
+	pollingLookback := viper.GetDuration("polling.lookback")
+	if pollingLookback <= 0 {
+		pollingLookback = DefaultLookback
+	}
+
+	maxEmailsPerPoll := viper.GetInt("polling.max_emails_per_poll")
+	if maxEmailsPerPoll < 0 {
+		maxEmailsPerPoll = DefaultMaxEmailsPerPoll
+	}
+	backfillBatch := viper.GetInt("polling.backfill_batch")
+	if backfillBatch <= 0 {
+		backfillBatch = DefaultBackfillBatch
+	}
+
+	payloadMode, err := ParsePayloadMode(viper.GetString("queue.payload"))
+	if err != nil {
+		log.Printf("Invalid queue.payload, using %q: %v", PayloadFull, err)
+		payloadMode = PayloadFull
+	}
+	fetchBaseURL := viper.GetString("queue.fetch_base_url")
+	if payloadMode == PayloadReference && fetchBaseURL == "" {
+		log.Printf("queue.payload %q requires queue.fetch_base_url, using %q", PayloadReference, PayloadMetadata)
+		payloadMode = PayloadMetadata
+	}
+
+	s := &Service{
+		provider:        p,
 		userMessages:    make(chan UserMessage), // Unbuffered channel
 		channelsChanged: make(chan struct{}),    // Unbuffered channel
+		latency:         newLatencyTracker(),
+		ingestSLO:       ingestSLO,
+		pollingLookback: pollingLookback,
+		userCache:       newUserCache(userCacheTTL),
+		bodyMode:        bodyMode,
+		storeText:       viper.GetBool("search.store_text"),
+		storeMetadata:   viper.GetBool("storage.metadata"),
+		payloadMode:     payloadMode,
+		fetchBaseURL:    fetchBaseURL,
+		prefilter:       newPrefilter(viper.GetStringSlice("priority.ioc_domains"), viper.GetStringSlice("priority.protected_domains")),
+		scheduler:       processingScheduler(viper.GetInt("processing.max_in_flight")),
+		quota: TenantQuota{
+			MaxInFlight:     viper.GetInt("quota.max_in_flight"),
+			EmailsPerSecond: viper.GetFloat64("quota.emails_per_second"),
+			Weight:          viper.GetInt("quota.weight"),
+		},
+	}
+	// A user's queued emails hold no processing slot, so the queue is much deeper than capacity
+	s.ordered = newSerialExecutor(processingQueueDepth * s.scheduler.capacity)
+	s.metricsInterval = int64(DefaultMetricsLogInterval)
+	if viper.IsSet("metrics.log_interval") {
+		s.metricsInterval = int64(max(viper.GetDuration("metrics.log_interval"), 0))
+	}
+	s.metricsIntervalChanged = make(chan struct{}, 1)
+	s.capacity = newCapacityController(s, newCapacityConfig())
+	s.coverage = newCoverageJob(s, newCoverageConfig())
+	s.maintenance = newMaintenanceSchedule()
+	s.maxEmailsPerPoll, s.backfillBatch = maxEmailsPerPoll, backfillBatch
+	s.fingerprintAlg, s.previousFingerprints = fingerprintAlg, previousFingerprints
+	if s.policies, err = newPolicySet(bodyMode, p); err != nil {
+		return nil, err
+	}
+	if err := s.policies.checkFingerprintMode(fingerprintMode); err != nil {
+		return nil, err
+	}
+	s.fingerprintMode, s.previousFingerprintMode = fingerprintMode, previousFingerprintMode
+	s.campaigns = newCampaignJob(newCampaignConfig())
+	s.jobs = newJobRunner(s)
+	s.alerts = newAlertEvaluator(s, newAlertConfig(ingestSLO))
+
+	var sink Publisher = discardPublisher{}
+	batchCfg, err := newBatchConfig()
+	if err != nil {
+		return nil, err
+	}
+	if batchCfg.size > 1 {
+		if s.batcher, err = newBatchPublisher(discardPublisher{}, batchCfg); err != nil {
+			return nil, err
+		}
+		s.batcher.publishers = func() int { return s.scheduler.stats(s.tenantID).InFlight }
+		sink = s.batcher
+	}
+	s.publisher = newPublisher(sink)
+
+	spillMax := viper.GetInt("storage.spill_max")
+	s.spill, err = newSpillBuffer(spillMax, viper.GetString("storage.spill_file"))
+	if err != nil {
+		log.Printf("Spilled emails will not survive a restart: %v", err)
+		s.spill, _ = newSpillBuffer(spillMax, "")
+	}
+	s.spill.ingest = s.replaySpilled
+	s.spill.submit = s.submitReplay
+	s.journal = newIngestJournal(viper.GetString("ingest.journal"), viper.GetBool("ingest.journal_sync"))
+	s.spill.ping = func(ctx context.Context) error { return db.Pool.Ping(ctx) }
+	return s, nil
+}
+
+// activeUserCount returns the number of users being polled
+func (s *Service) activeUserCount() int {
+	n := 0
+	s.activeUsers.Range(func(key, value interface{}) bool {
+		n++
+		return true
+	})
+	return n
+}
+
+// processingSlots returns how many emails the tenant may process concurrently
+func (s *Service) processingSlots() int {
+	slots := s.scheduler.capacity
+	if s.quota.MaxInFlight > 0 && s.quota.MaxInFlight < slots {
+		slots = s.quota.MaxInFlight
 	}
+	return slots
 }
 
 func (s *Service) Run(ctx context.Context, tenantIDStr string) error {
@@ -74,21 +327,79 @@ func (s *Service) Run(ctx context.Context, tenantIDStr string) error {
 	if err != nil {
 		return fmt.Errorf("invalid tenant_id: %w", err)
 	}
+	s.tenantID = tenantID
+	s.scheduler.register(tenantID, s.quota)
 
 	log.Printf("Starting discovery service for tenant: %s", tenantID)
 
+	// Emails pulled before a crash are ingested before polling resumes
+	recovered, err := s.journal.open()
+	if err != nil {
+		return err
+	}
+	s.recoverJournal(ctx, recovered)
+
+	// The service stops by itself on sustained saturation (capacity controller)
+	// or when the tenant is offboarded (user discovery); the cause is returned
+	ctx, cancel := context.WithCancelCause(ctx)
+	defer cancel(nil)
+	go func() {
+		if err := s.capacity.run(ctx); errors.Is(err, ErrSaturated) {
+			log.Printf("Stopping: %v (capacity.exit_on_saturation)", err)
+			cancel(err)
+		}
+	}()
+
 	// Start email discovery service (waits for messages and manages fan-in)
 	go s.emailDiscoveryService(ctx)
 
 	// Start user discovery service (sends messages)
-	go s.userDiscoveryService(ctx, tenantID)
+	go func() {
+		if err := s.userDiscoveryService(ctx, tenantID); errors.Is(err, ErrTenantOffboarded) {
+			log.Printf("Stopping: %v", err)
+			cancel(err)
+		}
+	}()
+
+	// Compare the provider directory with the mailboxes being polled
+	go s.coverage.run(ctx)
+
+	// Replay emails spilled while the database was unavailable
+	go s.spill.run(ctx)
+
+	// Group similar emails into campaigns (campaigns.enabled)
+	go s.campaigns.run(ctx)
+
+	// Run admin bulk operations (see SubmitJob)
+	go s.jobs.run(ctx)
+
+	// Evaluate the built-in alert rules
+	go s.alerts.run(ctx)
 
 	// Start performance metrics logger
 	go s.logPerformanceMetrics(ctx)
 
+	// Publish analysis message batches that have waited queue.batch_linger; the last one is
+	// published before shutdown completes
+	if s.batcher != nil {
+		s.processingWg.Add(1)
+		go func() {
+			defer s.processingWg.Done()
+			s.batcher.run(ctx)
+		}()
+	}
+
+	// Expire persisted queue dedup keys
+	if dedup, ok := s.publisher.(*dedupPublisher); ok {
+		go dedup.run(ctx)
+	}
+
 	// Start dynamic fan-in and process emails directly
 	s.dynamicFanInAndProcess(ctx)
 
+	if cause := context.Cause(ctx); errors.Is(cause, ErrSaturated) || errors.Is(cause, ErrTenantOffboarded) {
+		return cause
+	}
 	return nil
 }
 
@@ -105,9 +416,19 @@ func (s *Service) Shutdown(timeout time.Duration) bool {
 	}()
 
 	// Wait for either completion or timeout
+	graceful := false
+	defer func() { s.recordShutdown(graceful) }()
 	select {
 	case <-done:
+		graceful = true
 		log.Println("All processing goroutines completed successfully")
+		if n := s.spill.len(); n > 0 {
+			if s.spill.persistent() {
+				log.Printf("%d spilled emails kept in the spill file, replayed on the next start", n)
+			} else {
+				log.Printf("%d spilled emails not stored (the database was unavailable); they are polled again on the next start", n)
+			}
+		}
 		return true
 	case <-time.After(timeout):
 		log.Printf("Shutdown timeout (%v) reached, some processing may still be in progress", timeout)
@@ -115,28 +436,74 @@ func (s *Service) Shutdown(timeout time.Duration) bool {
 	}
 }
 
+// recordShutdown records a service.stopped event, so a shutdown that timed out raises
+// DiscoveryShutdownNotGraceful on the instances still running and after a restart
+func (s *Service) recordShutdown(graceful bool) {
+	ctx, cancel := context.WithTimeout(context.Background(), 2*time.Second)
+	defer cancel()
+	data := map[string]any{"graceful": graceful, "in_flight": atomic.LoadInt64(&s.processingInFlight)}
+	if err := events.Record(ctx, events.TypeServiceStopped, nil, nil, data); err != nil {
+		log.Printf("Error recording %s event: %v", events.TypeServiceStopped, err)
+	}
+}
+
 // userDiscoveryService periodically discovers users and sends ADD_USER/REMOVE_USER messages
-func (s *Service) userDiscoveryService(ctx context.Context, tenantID uuid.UUID) {
-	ticker := time.NewTicker(1 * time.Minute) // Discover users every minute
+// Returns ErrTenantOffboarded once the tenant has been offboarded (checked before each cycle)
+func (s *Service) userDiscoveryService(ctx context.Context, tenantID uuid.UUID) error {
+	ticker := s.clk().NewTicker(1 * time.Minute) // Discover users every minute
 	defer ticker.Stop()
 
 	// Initial discovery
-	if err := s.discoverUsersOnce(ctx, tenantID); err != nil {
-		log.Printf("Error in initial user discovery: %v", err)
+	if s.tenantOffboarded(ctx, tenantID) {
+		return ErrTenantOffboarded
+	}
+	if s.maintenance.paused() {
+		log.Printf("🔧 Maintenance window in progress | user discovery deferred")
+	} else if err := s.discoverUsersOnce(ctx, tenantID); err != nil {
+		logUserDiscoveryError("Error in initial user discovery", err)
 	}
 
 	for {
 		select {
 		case <-ctx.Done():
-			return
-		case <-ticker.C:
+			return nil
+		case <-ticker.C():
+			if s.tenantOffboarded(ctx, tenantID) {
+				return ErrTenantOffboarded
+			}
+			if s.maintenance.paused() {
+				continue
+			}
 			if err := s.discoverUsersOnce(ctx, tenantID); err != nil {
-				log.Printf("Error discovering users: %v", err)
+				logUserDiscoveryError("Error discovering users", err)
 			}
 		}
 	}
 }
 
+// ErrTenantOffboarded is returned by Run when the tenant was offboarded (discovery tenant remove)
+var ErrTenantOffboarded = errors.New("tenant offboarded")
+
+// tenantOffboarded reports whether the tenant was offboarded; errors keep the service running
+func (s *Service) tenantOffboarded(ctx context.Context, tenantID uuid.UUID) bool {
+	offboarded, err := privacy.IsOffboarded(ctx, tenantID)
+	if err != nil {
+		log.Printf("Error checking tenant status: %v", err)
+		return false
+	}
+	return offboarded
+}
+
+// logUserDiscoveryError logs a failed user discovery, alerting when credentials are rejected
+// Other kinds are retried on the next tick (1 minute is already gentler than any backoff)
+func logUserDiscoveryError(msg string, err error) {
+	if errors.Is(err, provider.ErrUnauthorized) {
+		log.Printf("🚨 %s, provider unauthorized: %v", msg, err)
+		return
+	}
+	log.Printf("%s: %v", msg, err)
+}
+
 func (s *Service) discoverUsersOnce(ctx context.Context, tenantID uuid.UUID) error {
 	// Get current users from provider
 	providerUsers, err := s.provider.GetUsers(tenantID)
@@ -171,8 +538,9 @@ func (s *Service) discoverUsersOnce(ctx context.Context, tenantID uuid.UUID) err
 		if err := s.upsertUser(ctx, pUser); err != nil {
 			log.Printf("Error upserting user %s: %v", pUser.ID, err)
 		}
-		// Collect users to add
+		// Collect users to add, under the policy they match
 		if _, exists := s.activeUsers.Load(pUser.ID); !exists {
+			s.policies.assign(pUser)
 			if isInitial {
 				// Batch mode: collect for batch addition
 				dbUser, err := s.getUserByID(ctx, pUser.ID)
@@ -194,22 +562,27 @@ func (s *Service) discoverUsersOnce(ctx context.Context, tenantID uuid.UUID) err
 			userCtx, cancel := context.WithCancel(ctx)
 
 			// Start email discovery for this user
-			emailCh := s.discoverEmailsForUser(userCtx, user)
+			pollNow := make(chan struct{}, 1)
+			emailCh := s.discoverEmailsForUser(userCtx, user, pollNow)
 
 			// Store the user discovery state
 			ued := &userEmailDiscovery{
-				user:    user,
-				ctx:     userCtx,
-				cancel:  cancel,
-				channel: emailCh,
+				user:      user,
+				ctx:       userCtx,
+				cancel:    cancel,
+				channel:   emailCh,
+				pollNow:   pollNow,
+				startedAt: s.clk().Now(),
 			}
 			s.activeUsers.Store(user.ID, ued)
+			s.prefilter.protect(senderDomain(user.Email))
 		}
 		log.Printf("Initial discovery: added %d users, notifying fan-in once", len(usersToAdd))
 		// Notify channels changed once after all additions
+		// Blocking: a dropped notification would leave the initial users out of the fan-in
 		select {
 		case s.channelsChanged <- struct{}{}:
-		default:
+		case <-ctx.Done():
 		}
 	}
 
@@ -234,12 +607,20 @@ func (s *Service) upsertUser(ctx context.Context, pUser models.ProviderUser) err
 		DO NOTHING
 	`
 
-	_, err := db.Pool.Exec(ctx, query,
+	tag, err := db.Pool.Exec(ctx, query,
 		pUser.ID,
 		pUser.Email,
 	)
+	if err != nil {
+		return err
+	}
 
-	return err
+	if tag.RowsAffected() == 1 {
+		if err := events.Record(ctx, events.TypeUserAdded, &pUser.ID, nil, map[string]string{"email": pUser.Email}); err != nil {
+			log.Printf("Error recording %s event for user %s: %v", events.TypeUserAdded, pUser.ID, err)
+		}
+	}
+	return nil
 }
 
 // emailDiscoveryService waits for messages and manages user email discovery goroutines
@@ -287,18 +668,22 @@ func (s *Service) handleAddUser(ctx context.Context, userID uuid.UUID) {
 	userCtx, cancel := context.WithCancel(ctx)
 
 	// Start email discovery for this user
-	emailCh := s.discoverEmailsForUser(userCtx, user)
+	pollNow := make(chan struct{}, 1)
+	emailCh := s.discoverEmailsForUser(userCtx, user, pollNow)
 
 	// Store the user discovery state
 	ued := &userEmailDiscovery{
-		user:    user,
-		ctx:     userCtx,
-		cancel:  cancel,
-		channel: emailCh,
+		user:      user,
+		ctx:       userCtx,
+		cancel:    cancel,
+		channel:   emailCh,
+		pollNow:   pollNow,
+		startedAt: s.clk().Now(),
 	}
 	s.activeUsers.Store(userID, ued)
+	s.prefilter.protect(senderDomain(user.Email))
 
-	log.Printf("Started email discovery for user %s (%s)", user.Email, userID)
+	log.Printf("Started email discovery for user %s (%s)", telemetry.Redact(user.Email), userID)
 
 	// Notify fan-in that channels have changed (for incremental additions)
 	s.channelsChanged <- struct{}{}
@@ -314,32 +699,55 @@ func (s *Service) handleRemoveUser(userID uuid.UUID) {
 	ued := value.(*userEmailDiscovery)
 	ued.cancel() // This will close the channel and trigger cleanup
 	s.activeUsers.Delete(userID)
+	s.lastPollAt.Delete(userID)
+	s.backfills.Delete(userID)
+	s.policies.forget(userID)
+	s.userCache.invalidate(userID)
 	log.Printf("Stopped email discovery for user %s", userID)
 
+	if err := events.Record(context.Background(), events.TypeUserRemoved, &userID, nil, map[string]string{"email": ued.user.Email}); err != nil {
+		log.Printf("Error recording %s event for user %s: %v", events.TypeUserRemoved, userID, err)
+	}
+
 	// Notify fan-in that channels have changed
 	s.channelsChanged <- struct{}{}
 }
 
 func (s *Service) getUserByID(ctx context.Context, userID uuid.UUID) (discoverymodels.User, error) {
-	query := `SELECT id, email, last_email_check, last_email_received 
+	query := `SELECT id, email, last_email_check, last_email_received, paused_at
 		FROM users WHERE id = $1`
 
 	var user discoverymodels.User
-	err := db.Pool.QueryRow(ctx, query, userID).Scan(
+	err := db.ReadPool.QueryRow(ctx, query, userID).Scan(
 		&user.ID,
 		&user.Email,
 		&user.LastEmailCheck,
 		&user.LastEmailReceived,
+		&user.PausedAt,
 	)
 
 	return user, err
 }
 
+// getUserCached returns the user row from the cache, loading it from the database on a miss
+func (s *Service) getUserCached(ctx context.Context, userID uuid.UUID) (discoverymodels.User, error) {
+	if user, ok := s.userCache.get(userID); ok {
+		return user, nil
+	}
+
+	user, err := s.getUserByID(ctx, userID)
+	if err != nil {
+		return user, err
+	}
+	s.userCache.put(user)
+	return user, nil
+}
+
 func (s *Service) getUsers(ctx context.Context) ([]discoverymodels.User, error) {
-	query := `SELECT id, email, last_email_check, last_email_received 
+	query := `SELECT id, email, last_email_check, last_email_received, paused_at
 		FROM users`
 
-	rows, err := db.Pool.Query(ctx, query)
+	rows, err := db.ReadPool.Query(ctx, query)
 	if err != nil {
 		return nil, err
 	}
@@ -353,6 +761,7 @@ func (s *Service) getUsers(ctx context.Context) ([]discoverymodels.User, error)
 			&user.Email,
 			&user.LastEmailCheck,
 			&user.LastEmailReceived,
+			&user.PausedAt,
 		); err != nil {
 			return nil, err
 		}
@@ -363,15 +772,18 @@ func (s *Service) getUsers(ctx context.Context) ([]discoverymodels.User, error)
 }
 
 type EmailWithUser struct {
-	Email  models.ProviderEmail // Full email from provider (for analysis queue)
-	UserID uuid.UUID
+	Email        models.ProviderEmail // Full email from provider (for analysis queue)
+	UserID       uuid.UUID
+	DiscoveredAt time.Time // When the email was fetched from the provider
+	Priority     string    // Prefilter match reason (priority lane), empty for bulk
 }
 
 // discoverEmailsForUser polls for emails for a single user with fixed 30-second interval
 // Returns a buffered channel (channel generator pattern)
 // Buffered to avoid blocking polling goroutine if processing is slow
 // Uses staggered initial polling to avoid thundering herd problem
-func (s *Service) discoverEmailsForUser(ctx context.Context, user discoverymodels.User) <-chan EmailWithUser {
+// A signal on pollNow polls right away (operator-forced poll)
+func (s *Service) discoverEmailsForUser(ctx context.Context, user discoverymodels.User, pollNow <-chan struct{}) <-chan EmailWithUser {
 	emailCh := make(chan EmailWithUser, ChannelBufferSize) // Buffered channel
 
 	go func() {
@@ -381,25 +793,69 @@ func (s *Service) discoverEmailsForUser(ctx context.Context, user discoverymodel
 		// This ensures users don't all poll at the same time
 		initialDelay := s.calculateInitialDelay(user.ID)
 
+		// poll runs one poll and applies the error policy, returns false when polling must stop
+		// Polls are skipped during maintenance windows
+		failures, skipped := 0, 0
+		poll := func() bool {
+			if !s.maintenance.allowPoll(&skipped) {
+				return true
+			}
+			err := s.pollEmailsForUser(user, emailCh)
+			if err == nil {
+				failures = 0
+				s.pollFailures.Delete(user.ID)
+				return true
+			}
+
+			failures++
+			s.pollFailures.Store(user.ID, pollFailure{at: s.clk().Now(), err: err.Error()})
+			backoff, keepPolling := s.handlePollError(ctx, user, err, failures)
+			if !keepPolling {
+				return false
+			}
+			if backoff > 0 {
+				timer := s.clk().NewTimer(backoff)
+				defer timer.Stop()
+				select {
+				case <-ctx.Done():
+					return false
+				case <-timer.C():
+				}
+			}
+			return true
+		}
+
 		// Wait for initial delay before first poll
+		delay := s.clk().NewTimer(initialDelay)
 		select {
 		case <-ctx.Done():
+			delay.Stop()
+			return
+		case <-delay.C():
+		case <-pollNow:
+			delay.Stop()
+		}
+		// Initial poll after staggered delay
+		if !poll() {
 			return
-		case <-time.After(initialDelay):
-			// Initial poll after staggered delay
-			s.pollEmailsForUser(user, emailCh)
 		}
 
-		// Create ticker for subsequent polls (every 30 seconds)
-		ticker := time.NewTicker(PollingInterval)
+		// Create ticker for subsequent polls (every 30 seconds unless the user's policy says otherwise)
+		ticker := s.clk().NewTicker(s.policyFor(user.ID).PollingInterval)
 		defer ticker.Stop()
 
 		for {
 			select {
 			case <-ctx.Done():
 				return
-			case <-ticker.C:
-				s.pollEmailsForUser(user, emailCh)
+			case <-ticker.C():
+				if !poll() {
+					return
+				}
+			case <-pollNow:
+				if !poll() {
+					return
+				}
 			}
 		}
 	}()
@@ -407,6 +863,14 @@ func (s *Service) discoverEmailsForUser(ctx context.Context, user discoverymodel
 	return emailCh
 }
 
+// clk returns the service's clock
+func (s *Service) clk() clock.Clock {
+	if s.clock == nil {
+		return clock.Real
+	}
+	return s.clock
+}
+
 // calculateInitialDelay calculates a deterministic but distributed delay for a user
 // based on their UUID. This ensures each user starts polling at a slightly different time
 // to avoid thundering herd, while being deterministic (same user = same delay).
@@ -420,111 +884,364 @@ func (s *Service) calculateInitialDelay(userID uuid.UUID) time.Duration {
 	return time.Duration(delayNanos)
 }
 
+// handlePollError applies the per-kind policy for a failed poll
+// Returns how long to back off before polling again, and false if polling must stop
+func (s *Service) handlePollError(ctx context.Context, user discoverymodels.User, err error, failures int) (time.Duration, bool) {
+	switch {
+	case errors.Is(err, provider.ErrUserNotFound):
+		// User deleted or suspended at the provider: deactivate instead of polling forever
+		log.Printf("User %s (%s) not found at provider, deactivating email discovery", telemetry.Redact(user.Email), user.ID)
+		go func() {
+			select {
+			case s.userMessages <- UserMessage{Type: MessageRemoveUser, UserID: user.ID}:
+			case <-ctx.Done():
+			}
+		}()
+		return 0, false
+	case errors.Is(err, provider.ErrRateLimited):
+		backoff := pollBackoff(failures, provider.RetryAfter(err))
+		log.Printf("Rate limited polling emails for user %s, backing off %v", user.ID, backoff)
+		return backoff, true
+	case errors.Is(err, provider.ErrUnauthorized):
+		// Credentials/scopes problem, needs an operator; keep polling slowly to detect the fix
+		backoff := pollBackoff(failures, 0)
+		log.Printf("🚨 Provider unauthorized polling emails for user %s (backing off %v): %v", user.ID, backoff, err)
+		return backoff, true
+	default:
+		// Transient or unclassified: retry on the next tick
+		log.Printf("Error getting emails for user %s: %v", user.ID, err)
+		return provider.RetryAfter(err), true
+	}
+}
+
+// pollBackoff computes an exponential backoff from the polling interval, capped at MaxPollBackoff
+// A provider-requested retryAfter is honoured when it is longer
+func pollBackoff(failures int, retryAfter time.Duration) time.Duration {
+	backoff := PollingInterval
+	for i := 1; i < failures && backoff < MaxPollBackoff; i++ {
+		backoff *= 2
+	}
+	if backoff > MaxPollBackoff {
+		backoff = MaxPollBackoff
+	}
+	if retryAfter > backoff {
+		backoff = retryAfter
+	}
+	return backoff
+}
+
 // pollEmailsForUser polls for emails and sends them to the channel
-func (s *Service) pollEmailsForUser(user discoverymodels.User, emailCh chan<- EmailWithUser) {
-	// Fetch fresh user data from DB to get latest last_email_check
+func (s *Service) pollEmailsForUser(user discoverymodels.User, emailCh chan<- EmailWithUser) error {
+	// Fetch fresh user data (cached between polls) to get latest last_email_check
 	ctx := context.Background()
-	freshUser, err := s.getUserByID(ctx, user.ID)
+	freshUser, err := s.getUserCached(ctx, user.ID)
 	if err != nil {
 		log.Printf("Error getting fresh user data for %s: %v", user.ID, err)
 		// Fall back to passed user data
 		freshUser = user
 	}
-
-	// Determine receivedAfter timestamp from fresh data
-	// Use last_email_received if available (more accurate than last_email_check)
-	// Otherwise fall back to last_email_check, or 24 hours ago if neither exists
-	// Subtract 1 second as a buffer to avoid missing emails due to timing/clock skew
-	var receivedAfter time.Time
-	if freshUser.LastEmailReceived != nil {
-		receivedAfter = freshUser.LastEmailReceived.Add(-1 * time.Second)
-	} else if freshUser.LastEmailCheck != nil {
-		receivedAfter = freshUser.LastEmailCheck.Add(-1 * time.Second)
-	} else {
-		// First time checking - go back 24 hours
-		receivedAfter = time.Now().Add(-24 * time.Hour)
+	if freshUser.PausedAt != nil {
+		return nil // Paused by an operator (see JobPauseUsers)
 	}
 
-	emails, err := s.provider.GetEmails(user.ID, receivedAfter, "received_at")
-	if err != nil {
-		log.Printf("Error getting emails for user %s: %v", user.ID, err)
-		return
+	// A user with a backfill gets its next batch instead of a poll
+	emails, backfilling := s.nextBackfillBatch(user.ID)
+	if !backfilling {
+		receivedAfter := receivedAfterFor(freshUser, s.pollingLookback, s.clk().Now())
+		emails, err = s.fetchEmails(user.ID, receivedAfter, s.policyFor(user.ID).BodyMode)
+		if err != nil {
+			return err
+		}
+		atomic.AddInt64(&s.emailsPolled, int64(len(emails)))
+		logging.Debugf("Polled user %s: %d emails received after %s", user.ID, len(emails), receivedAfter.Format(time.RFC3339))
+		emails = s.capPoll(ctx, freshUser, emails)
+	} else {
+		logging.Debugf("Backfill batch for user %s: %d emails", user.ID, len(emails))
 	}
 
 	// Send emails to channel with user context (full email for analysis queue)
 	// Metrics are updated in storeEmail() when emails are actually stored in DB
+	discoveredAt := s.clk().Now()
+	s.lastPollAt.Store(user.ID, discoveredAt)
 	for _, pEmail := range emails {
-		emailCh <- EmailWithUser{Email: pEmail, UserID: user.ID}
+		emailCh <- EmailWithUser{Email: pEmail, UserID: user.ID, DiscoveredAt: discoveredAt}
 	}
+	return nil
+}
+
+// receivedAfterFor computes the polling cursor for a user
+// Use last_email_received if available (more accurate than last_email_check)
+// Otherwise fall back to last_email_check, or 24 hours before now if neither exists
+// Subtract the lookback window so late-arriving (backdated) emails are still picked up;
+// emails re-fetched inside the window are absorbed by fingerprint dedup
+func receivedAfterFor(user discoverymodels.User, lookback time.Duration, now time.Time) time.Time {
+	if user.LastEmailReceived != nil {
+		return user.LastEmailReceived.Add(-lookback)
+	}
+	if user.LastEmailCheck != nil {
+		return user.LastEmailCheck.Add(-lookback)
+	}
+	// First time checking - go back 24 hours
+	return now.Add(-24 * time.Hour)
 }
 
 // processEmail processes a single email (called from fan-in loop)
 func (s *Service) processEmail(ctx context.Context, ewu EmailWithUser) {
-	// DB operations in goroutine to avoid blocking channel processing
+	// DB operations off the fan-in loop to avoid blocking channel processing
+	// Emails of the same user run serially in poll (received_at) order, other users concurrently;
+	// the fan-in blocks while the executor is full (backpressure)
 	s.processingWg.Add(1)
-	go func(ewu EmailWithUser) {
-		defer s.processingWg.Done()
+	atomic.AddInt64(&s.processingInFlight, 1)
+	task := emailTaskPool.Get().(*emailTask)
+	task.s, task.ctx, task.ewu, task.priority = s, ctx, ewu, ewu.Priority != ""
+	if err := s.ordered.submit(ctx, ewu.UserID, task); err != nil {
+		s.processingWg.Done()
+		atomic.AddInt64(&s.processingInFlight, -1)
+		*task = emailTask{}
+		emailTaskPool.Put(task)
+	}
+}
 
-		// Check if context is already cancelled before starting work
-		select {
-		case <-ctx.Done():
-			return
-		default:
+// emailTask is one email waiting in its user's serial queue
+// Tasks are pooled: at thousands of users polling every 30s, one allocation per email adds up
+type emailTask struct {
+	s        *Service
+	ctx      context.Context
+	ewu      EmailWithUser
+	priority bool
+}
+
+var emailTaskPool = sync.Pool{New: func() any { return new(emailTask) }}
+
+// run takes a processing slot once the email reaches the head of its user's queue, so emails
+// waiting behind a slow one hold none. Priority-lane emails are served ahead of other users'
+// bulk traffic, never ahead of their own user's earlier emails: the cursor must not pass them.
+func (t *emailTask) run() {
+	s := t.s
+	if err := s.scheduler.acquire(t.ctx, s.tenantID, t.priority); err == nil {
+		if t.priority {
+			atomic.AddInt64(&s.emailsPrioritized, 1)
 		}
+		s.handleEmail(t.ctx, &t.ewu, t.priority)
+		s.releaseSlot(t.ctx)
+	}
+	s.processingWg.Done()
+	atomic.AddInt64(&s.processingInFlight, -1)
+	*t = emailTask{} // Drop references to the email before pooling
+	emailTaskPool.Put(t)
+}
 
-		// Store minimal metadata in DB first to check if it's a new unique email
-		isNew, err := s.storeEmail(ctx, ewu.Email, ewu.UserID)
-		if err != nil {
-			log.Printf("Error storing email %s: %v", ewu.Email.MessageID, err)
+// releaseSlot frees a processing slot taken with s.scheduler.acquire, and publishes the pending
+// analysis batch if the ingests still holding one are all waiting on it
+func (s *Service) releaseSlot(ctx context.Context) {
+	s.scheduler.release(s.tenantID)
+	if s.batcher != nil {
+		s.batcher.settle(ctx)
+	}
+}
+
+// handleEmail stores an email, or spills it while the database is unavailable (see spillBuffer)
+func (s *Service) handleEmail(ctx context.Context, ewu *EmailWithUser, priority bool) {
+	defer atomic.AddInt64(&s.emailsProcessed, 1)
+
+	// Check if context is already cancelled before starting work
+	select {
+	case <-ctx.Done():
+		return
+	default:
+	}
+
+	// Journaled before anything is stored or queued (ingest.journal)
+	pending := newPendingEmail(*ewu)
+	s.journal.record(pending)
+
+	// Queued behind emails already waiting for the database, so the user's cursor never skips them
+	if active, spilled := s.spill.addIfActive(pending); active {
+		if !spilled {
+			// Dropped: the cursor stays before it, so it is polled again
+			s.journal.ack(pending.key())
+		}
+		return
+	}
+	err := s.ingestEmail(ctx, ewu, priority, false)
+	s.settleJournal(ctx, pending, err)
+	if err != nil && dbUnavailable(err) && ctx.Err() == nil {
+		if s.spill.add(pending) {
 			return
 		}
+		// Dropped: the cursor stays before it, so it is polled again
+		s.journal.ack(pending.key())
+	}
+	if err != nil {
+		log.Printf("Error storing email %s: %v", ewu.Email.MessageID, err)
+	}
+}
 
-		// Only send to analysis queue if it's a new unique email
-		if isNew {
-			s.sendToAnalysisQueue(ewu.Email)
-		}
+// submitReplay runs a user's spill replay in the user's serial executor, behind the user's
+// queued emails and ahead of later ones
+func (s *Service) submitReplay(ctx context.Context, userID uuid.UUID, replay func()) error {
+	s.processingWg.Add(1)
+	err := s.ordered.submit(ctx, userID, taskFunc(func() {
+		defer s.processingWg.Done()
+		replay()
+	}))
+	if err != nil {
+		s.processingWg.Done()
+	}
+	return err
+}
+
+// replaySpilled ingests a spilled email in a processing slot, as a polled one
+func (s *Service) replaySpilled(ctx context.Context, pending pendingEmail) error {
+	if err := s.scheduler.acquire(ctx, s.tenantID, pending.Priority != ""); err != nil {
+		return err
+	}
+	defer s.releaseSlot(ctx)
+	return s.ingestPending(ctx, pending)
+}
+
+// ingestPending ingests a spilled or journaled email
+func (s *Service) ingestPending(ctx context.Context, pending pendingEmail) error {
+	ewu := pending.emailWithUser()
+	err := s.ingestEmail(ctx, &ewu, pending.Priority != "", pending.Recovered)
+	s.settleJournal(ctx, pending, err)
+	return err
+}
 
-		// Update last_email_check (when email is processed from channel)
-		now := time.Now()
-		_, err = db.Pool.Exec(ctx,
-			"UPDATE users SET last_email_check = $1 WHERE id = $2",
-			now, ewu.UserID,
-		)
+// settleJournal acknowledges an email's journal record unless it must be retried
+// Emails interrupted by shutdown or failing on an unavailable database stay in the journal
+func (s *Service) settleJournal(ctx context.Context, pending pendingEmail, err error) {
+	if ctx.Err() == nil && !dbUnavailable(err) {
+		s.journal.ack(pending.key())
+	}
+}
+
+// recoverJournal ingests the emails left in the journal by a crash, in the order they were pulled
+// They are queued even if already stored: the crash may have happened before they were queued
+func (s *Service) recoverJournal(ctx context.Context, recovered []pendingEmail) {
+	if len(recovered) == 0 {
+		return
+	}
+	log.Printf("📋 Recovering %d emails from the ingest journal", len(recovered))
+	for _, pending := range recovered {
+		err := s.ingestPending(ctx, pending)
+		if dbUnavailable(err) && ctx.Err() == nil && s.spill.add(pending) {
+			continue
+		}
 		if err != nil {
-			log.Printf("Error updating last_email_check: %v", err)
-		}
-
-		// Update last_email_received only if this is a new email and it's newer
-		if isNew {
-			_, err = db.Pool.Exec(ctx,
-				`UPDATE users 
-				SET last_email_received = $1 
-				WHERE id = $2 
-					AND (last_email_received IS NULL OR $1 > last_email_received)`,
-				ewu.Email.ReceivedAt, ewu.UserID,
-			)
-			if err != nil {
-				log.Printf("Error updating last_email_received: %v", err)
-			}
+			log.Printf("Error storing recovered email %s: %v", pending.Email.MessageID, err)
 		}
-	}(ewu)
+	}
+	if n := s.journal.pendingCount(); n > 0 {
+		log.Printf("%d recovered emails still pending (spilled or interrupted)", n)
+	}
 }
 
-func (s *Service) storeEmail(ctx context.Context, pEmail models.ProviderEmail, userID uuid.UUID) (bool, error) {
+// ingestEmail stores an email, queues it for analysis if new and advances the user's cursors
+// A recovered email is queued even if already stored (the queue drops it if it was published)
+// Only a failure to store the email is returned; it leaves the cursors untouched
+func (s *Service) ingestEmail(ctx context.Context, ewu *EmailWithUser, priority, recovered bool) error {
+	// Fingerprinted once: used for dedup in storeEmail and as the queue idempotency key
+	fingerprint := s.fingerprintAlg.FingerprintEmail(ewu.Email, s.fingerprintMode)
+
+	// Store minimal metadata in DB first to check if it's a new unique email
+	isNew, err := s.storeEmail(ctx, ewu.Email, fingerprint, ewu.UserID)
+	if err != nil {
+		return err
+	}
+	storedAt := time.Now()
+	logging.Debugf("Stored email %s for user %s (new: %t, priority: %t)", ewu.Email.MessageID, ewu.UserID, isNew, priority)
+
+	// Only send to analysis queue if it's a new unique email
+	var queuedAt time.Time
+	if isNew || recovered {
+		s.sendToAnalysisQueue(ctx, ewu, fingerprint)
+		queuedAt = time.Now()
+	}
+	if isNew {
+		s.recordDiscoveredEvent(ctx, ewu)
+	}
+	s.latency.record(ewu.Email.ReceivedAt, ewu.DiscoveredAt, storedAt, queuedAt, priority)
+
+	// Update last_email_check (when email is processed from channel)
+	now := time.Now()
+	_, err = db.Pool.Exec(ctx,
+		"UPDATE users SET last_email_check = $1 WHERE id = $2",
+		now, ewu.UserID,
+	)
+	if err != nil {
+		log.Printf("Error updating last_email_check: %v", err)
+	}
+	s.userCache.invalidate(ewu.UserID)
+
+	// Update last_email_received only if this is a new email and it's newer
+	if isNew || recovered {
+		if err := advanceCursor(ctx, ewu.UserID, ewu.Email.ReceivedAt); err != nil {
+			log.Printf("Error updating last_email_received: %v", err)
+		}
+	}
+	return nil
+}
+
+// recordDiscoveredEvent publishes an email.discovered event for a new unique email
+func (s *Service) recordDiscoveredEvent(ctx context.Context, ewu *EmailWithUser) {
+	emailID, err := uuid.Parse(ewu.Email.MessageID)
+	if err != nil {
+		return
+	}
+	data := map[string]any{
+		"received_at":   ewu.Email.ReceivedAt,
+		"sender_domain": senderDomain(ewu.Email.From),
+	}
+	if err := events.Record(ctx, events.TypeEmailDiscovered, &ewu.UserID, &emailID, data); err != nil {
+		log.Printf("Error recording %s event for email %s: %v", events.TypeEmailDiscovered, emailID, err)
+	}
+}
+
+// advanceCursor moves a user's last_email_received forward to receivedAt
+// Emails of a user are processed in order (see serialExecutor); the conditional update
+// additionally keeps the cursor monotonic across overlapping polls and service instances
+func advanceCursor(ctx context.Context, userID uuid.UUID, receivedAt time.Time) error {
+	_, err := db.Pool.Exec(ctx,
+		`UPDATE users 
+		SET last_email_received = $1 
+		WHERE id = $2 
+			AND (last_email_received IS NULL OR $1 > last_email_received)`,
+		receivedAt, userID,
+	)
+	return err
+}
+
+// emailMetadata returns the optional subject hash and size stored with storage.metadata (nil when off)
+// Size is the body length in bytes, unknown when bodies are not fetched (ingest.body_mode snippet)
+func (s *Service) emailMetadata(pEmail models.ProviderEmail) (*string, *int) {
+	if !s.storeMetadata {
+		return nil, nil
+	}
+	subjectHash := SubjectHash(pEmail.Subject)
+	if pEmail.Body == "" {
+		return &subjectHash, nil
+	}
+	size := len(pEmail.Body)
+	return &subjectHash, &size
+}
+
+// storeEmail stores an email's metadata and links it to the user; fingerprint is FingerprintEmail's
+// Returns true if this is the first copy of the email (by message ID and fingerprint)
+func (s *Service) storeEmail(ctx context.Context, pEmail models.ProviderEmail, fingerprint string, userID uuid.UUID) (bool, error) {
 	// Parse message_id as UUID (it's already a UUID string from the provider)
 	emailID, err := uuid.Parse(pEmail.MessageID)
 	if err != nil {
 		return false, fmt.Errorf("invalid message_id format: %w", err)
 	}
 
-	// Generate fingerprint from email body/content (SHA256 hash)
-	fingerprint := fmt.Sprintf("%x", sha256.Sum256([]byte(pEmail.Body)))
-
 	// Insert or update email (minimal metadata only - zero copy principle)
-	// First, check if email with this fingerprint already exists
+	// First, check if email with this fingerprint (or one of an earlier algorithm) already exists
 	var existingEmailID uuid.UUID
-	checkQuery := `SELECT id FROM emails WHERE fingerprint = $1 LIMIT 1`
-	err = db.Pool.QueryRow(ctx, checkQuery, fingerprint).Scan(&existingEmailID)
+	policy := s.policyFor(userID)
+	fingerprints := s.fingerprints(pEmail, fingerprint)
+	checkQuery := `SELECT id FROM emails WHERE fingerprint = ANY($1) LIMIT 1`
+	err = db.Pool.QueryRow(ctx, checkQuery, fingerprints).Scan(&existingEmailID)
 
 	isNewEmail := false
 	if err == nil {
@@ -532,16 +1249,27 @@ func (s *Service) storeEmail(ctx context.Context, pEmail models.ProviderEmail, u
 		emailID = existingEmailID
 	} else if errors.Is(err, pgx.ErrNoRows) {
 		// No existing email, try to insert with the message_id
+		// DO NOTHING on id conflict: a concurrent insert of the same message already won,
+		// so this delivery must not be reported as new (it would be queued twice)
 		insertQuery := `
-			INSERT INTO emails (id, fingerprint, received_at)
-			VALUES ($1, $2, $3)
-			ON CONFLICT (id) DO UPDATE SET received_at = EXCLUDED.received_at
+			INSERT INTO emails (id, fingerprint, received_at, sender_domain, subject, snippet, subject_hash, size_bytes, minhash)
+			VALUES ($1, $2, $3, NULLIF($4, ''), $5, $6, $7, $8, $9)
+			ON CONFLICT (id) DO NOTHING
 		`
-		_, err = db.Pool.Exec(ctx, insertQuery, emailID, fingerprint, pEmail.ReceivedAt)
+		// Subject and snippet are only persisted when full-text search is enabled (and the user's
+		// policy does not redact them)
+		var subject, snippet *string
+		if s.storeText && !policy.Redact {
+			subject, snippet = &pEmail.Subject, &pEmail.Snippet
+		}
+		subjectHash, sizeBytes := s.emailMetadata(pEmail)
+		var tag pgconn.CommandTag
+		tag, err = db.Pool.Exec(ctx, insertQuery, emailID, fingerprint, pEmail.ReceivedAt, senderDomain(pEmail.From),
+			subject, snippet, subjectHash, sizeBytes, s.campaigns.signature(pEmail))
 		if err != nil {
 			// If fingerprint conflict, find existing email
 			if strings.Contains(err.Error(), "fingerprint") || strings.Contains(err.Error(), "23505") {
-				err = db.Pool.QueryRow(ctx, checkQuery, fingerprint).Scan(&existingEmailID)
+				err = db.Pool.QueryRow(ctx, checkQuery, fingerprints).Scan(&existingEmailID)
 				if err == nil {
 					emailID = existingEmailID
 				} else if errors.Is(err, pgx.ErrNoRows) {
@@ -552,7 +1280,7 @@ func (s *Service) storeEmail(ctx context.Context, pEmail models.ProviderEmail, u
 			} else {
 				return false, fmt.Errorf("failed to insert email: %w", err)
 			}
-		} else {
+		} else if tag.RowsAffected() == 1 {
 			// Successfully inserted a new email
 			isNewEmail = true
 		}
@@ -582,18 +1310,30 @@ func (s *Service) storeEmail(ctx context.Context, pEmail models.ProviderEmail, u
 		ON CONFLICT (user_id, email_id) DO NOTHING
 	`
 
-	_, err = db.Pool.Exec(ctx, linkQuery, userID, emailID)
+	tag, err := db.Pool.Exec(ctx, linkQuery, userID, emailID)
 	if err != nil {
 		return false, fmt.Errorf("failed to link email to user: %w", err)
 	}
+	switch {
+	case isNewEmail:
+	case tag.RowsAffected() == 1:
+		// Stored for another user: the fingerprint saved a row
+		atomic.AddInt64(&s.fingerprintMatches, 1)
+	default:
+		// Already stored for this user (re-polled or redelivered)
+		atomic.AddInt64(&s.emailsDuplicate, 1)
+	}
 
 	return isNewEmail, nil
 }
 
 // dynamicFanInAndProcess implements the fan-in pattern and processes emails directly
-// It recreates the fan-in whenever channels are added or removed
+// The fan-in is updated whenever channels are added or removed: new user channels get a
+// forwarder, removed users' channels close and their forwarders exit on their own.
+// Existing forwarders are never replaced, so no email already read from a user channel
+// can be stranded on an abandoned multiplexer.
 func (s *Service) dynamicFanInAndProcess(ctx context.Context) {
-	var currentFanIn <-chan EmailWithUser
+	group := newFanInGroup(func(ewu EmailWithUser) string { return s.prefilter.match(ewu.Email) })
 
 	// Helper function to collect all active channels
 	collectChannels := func() []<-chan EmailWithUser {
@@ -606,109 +1346,138 @@ func (s *Service) dynamicFanInAndProcess(ctx context.Context) {
 		return channels
 	}
 
-	// Helper function to recreate fan-in
-	recreateFanIn := func() {
+	// Helper function to sync the fan-in with the active channels
+	updateFanIn := func() {
 		channels := collectChannels()
+		added := group.sync(ctx, channels)
+		atomic.StoreInt64(&s.fanInSize, int64(len(channels)))
 		if len(channels) == 0 {
 			log.Println("No active user channels for fan-in")
-			currentFanIn = nil
 			return
 		}
-
-		log.Printf("Recreating fan-in with %d user channels", len(channels))
-		currentFanIn = fanIn(channels)
-	}
-
-	// Initial fan-in creation (wait for first channels)
-	select {
-	case <-s.channelsChanged:
-		recreateFanIn()
-	case <-ctx.Done():
-		return
+		log.Printf("Updated fan-in: %d user channels (%d new)", len(channels), added)
 	}
 
-	// Main loop: process emails directly from fan-in
+	// Main loop: process emails directly from fan-in, priority lane first
 	for {
-		if currentFanIn == nil {
-			// No channels, wait for change
-			select {
-			case <-s.channelsChanged:
-				recreateFanIn()
-			case <-ctx.Done():
-				return
-			}
+		select {
+		case email := <-group.priority:
+			s.processEmail(ctx, email)
 			continue
+		default:
 		}
 
 		select {
 		case <-ctx.Done():
 			return
 		case <-s.channelsChanged:
-			// Channels changed, recreate fan-in
-			recreateFanIn()
-		case email, ok := <-currentFanIn:
-			if !ok {
-				// Fan-in channel closed (all user channels closed), recreate
-				recreateFanIn()
-				continue
-			}
+			updateFanIn()
+		case email := <-group.priority:
+			s.processEmail(ctx, email)
+		case email := <-group.out:
 			// Process email directly (unbuffered = natural backpressure)
 			s.processEmail(ctx, email)
 		}
 	}
 }
 
-// fanIn combines multiple channels into a single channel (fan-in pattern)
+// fanInGroup combines multiple channels into a single channel (fan-in pattern)
 // Output is unbuffered for natural backpressure - if processing is slow, polling slows down
-func fanIn(channels []<-chan EmailWithUser) <-chan EmailWithUser {
-	multiplexer := make(chan EmailWithUser) // Unbuffered output channel
+// Channels are added incrementally, each forwarder lives until its channel closes
+// Forwarders classify emails into two lanes: priority (prefilter match) and bulk (out)
+type fanInGroup struct {
+	out       chan EmailWithUser
+	priority  chan EmailWithUser
+	classify  func(EmailWithUser) string    // Priority reason, empty for bulk (nil = all bulk)
+	forwarded map[<-chan EmailWithUser]bool // Only accessed by the fan-in loop goroutine
+}
 
-	// If no channels, close immediately
-	if len(channels) == 0 {
-		close(multiplexer)
-		return multiplexer
+func newFanInGroup(classify func(EmailWithUser) string) *fanInGroup {
+	return &fanInGroup{
+		out:       make(chan EmailWithUser), // Unbuffered output channels
+		priority:  make(chan EmailWithUser),
+		classify:  classify,
+		forwarded: make(map[<-chan EmailWithUser]bool),
 	}
+}
 
-	// Track when all channels are closed
-	var wg sync.WaitGroup
-	wg.Add(len(channels))
-
-	// Forward from each channel to multiplexer
+// sync starts forwarders for channels not yet in the group and forgets channels that are
+// no longer active (they are closed by their user's cancellation). Returns how many were added.
+func (g *fanInGroup) sync(ctx context.Context, channels []<-chan EmailWithUser) int {
+	active := make(map[<-chan EmailWithUser]bool, len(channels))
+	added := 0
 	for _, ch := range channels {
-		go func(c <-chan EmailWithUser) {
-			defer wg.Done()
-			for emailWithUser := range c {
-				multiplexer <- emailWithUser
-			}
-		}(ch)
+		active[ch] = true
+		if !g.forwarded[ch] {
+			g.forwarded[ch] = true
+			go g.forward(ctx, ch)
+			added++
+		}
 	}
 
-	// Close multiplexer when all channels are closed
-	go func() {
-		wg.Wait()
-		close(multiplexer)
-	}()
+	for ch := range g.forwarded {
+		if !active[ch] {
+			delete(g.forwarded, ch)
+		}
+	}
+	return added
+}
 
-	return multiplexer
+func (g *fanInGroup) forward(ctx context.Context, c <-chan EmailWithUser) {
+	for emailWithUser := range c {
+		lane := g.out
+		if g.classify != nil {
+			if emailWithUser.Priority = g.classify(emailWithUser); emailWithUser.Priority != "" {
+				lane = g.priority
+			}
+		}
+		select {
+		case lane <- emailWithUser:
+		case <-ctx.Done():
+			return
+		}
+	}
 }
 
-// logPerformanceMetrics logs aggregated performance metrics periodically
-// Uses jittered intervals to avoid synchronized log bursts
+// logPerformanceMetrics logs aggregated performance metrics every metrics log interval
+// Uses jittered intervals (±20%) to avoid synchronized log bursts; an interval changed at runtime
+// takes effect right away, and 0 stops logging until it is set again
 func (s *Service) logPerformanceMetrics(ctx context.Context) {
-	baseInterval := 5 * time.Second
-	jitterRange := 2 * time.Second // ±1 second jitter
-
 	for {
-		// Calculate jittered interval (4-6 seconds)
-		jitter := time.Duration(rand.Int63n(int64(jitterRange))) - jitterRange/2
-		interval := baseInterval + jitter
+		var due <-chan time.Time
+		var timer clock.Timer
+		if baseInterval := s.MetricsLogInterval(); baseInterval > 0 {
+			jitterRange := baseInterval * 2 / 5
+			jitter := time.Duration(rand.Int63n(int64(jitterRange)+1)) - jitterRange/2
+			timer = s.clk().NewTimer(baseInterval + jitter)
+			due = timer.C()
+		}
 
 		select {
 		case <-ctx.Done():
 			return
-		case <-time.After(interval):
+		case <-s.metricsIntervalChanged:
+		case <-due:
 			s.logMetrics()
 		}
+		if timer != nil {
+			timer.Stop()
+		}
+	}
+}
+
+// MetricsLogInterval returns how often pipeline metrics are logged (0 = not logged)
+func (s *Service) MetricsLogInterval() time.Duration {
+	return time.Duration(atomic.LoadInt64(&s.metricsInterval))
+}
+
+// SetMetricsLogInterval changes how often pipeline metrics are logged, without a restart
+// 0 (or less) disables the metrics log
+func (s *Service) SetMetricsLogInterval(d time.Duration) {
+	atomic.StoreInt64(&s.metricsInterval, int64(max(d, 0)))
+	select {
+	case s.metricsIntervalChanged <- struct{}{}:
+	default:
 	}
 }
 
@@ -747,9 +1516,33 @@ func (s *Service) logMetrics() {
 	// Get totals
 	totalDiscovered := atomic.LoadInt64(&s.emailsDiscovered)
 	totalToQueue := atomic.LoadInt64(&s.emailsToQueue)
+	cacheHits, cacheMisses := s.userCache.stats()
 
 	// Log performance summary (column-based format for readability)
-	log.Printf("📊 Metrics | Discovered: %d | Queued: %d", totalDiscovered, totalToQueue)
+	log.Printf("📊 Metrics | Discovered: %d | Queued: %d | Deduplicated: %d | Prioritized: %d | User cache hits: %d misses: %d",
+		totalDiscovered, totalToQueue, atomic.LoadInt64(&s.emailsDeduplicated), atomic.LoadInt64(&s.emailsPrioritized), cacheHits, cacheMisses)
+
+	dedup := s.dedupStats()
+	log.Printf("📊 Dedup | tenant=%s | Stored: %d | Duplicates skipped: %d | Cross-user fingerprint matches: %d | Queue replays dropped: %d | Dedup ratio: %.1f%%",
+		s.tenantID, dedup.Stored, dedup.Duplicates, dedup.FingerprintMatches, dedup.QueueDuplicates, dedup.Ratio*100)
+
+	if queue := s.queueStats(); queue.Batches > 0 {
+		log.Printf("📊 Queue | tenant=%s | Batches: %d (%d full, %d idle) | Mean size: %.1f | Max size: %d | Failed: %d | Compression: %s %.2f",
+			s.tenantID, queue.Batches, queue.FullBatches, queue.IdleBatches, queue.MeanBatchSize, queue.MaxBatchSize, queue.FailedBatches, queue.Compression, queue.CompressionRatio)
+	}
+
+	if sla := s.MailboxSLA(); sla.Breaching > 0 {
+		log.Printf("📊 Mailbox SLA | tenant=%s | %d of %d mailboxes not polled successfully within %v | longest: %v",
+			s.tenantID, sla.Breaching, sla.Mailboxes, time.Duration(sla.SLASeconds*float64(time.Second)), time.Duration(sla.MaxSinceSuccessSeconds*float64(time.Second)).Round(time.Second))
+	}
+
+	s.logLatencyMetrics()
+
+	quotaStats := s.scheduler.stats(s.tenantID)
+	if quotaStats.Throttled > 0 {
+		log.Printf("🚦 Quota | tenant=%s | in-flight: %d | throttled: %d (waited %v)",
+			s.tenantID, quotaStats.InFlight, quotaStats.Throttled, quotaStats.ThrottledTime.Round(time.Millisecond))
+	}
 
 	if len(stats) > 0 {
 		topN := 3 // Show top 3 users
@@ -759,15 +1552,63 @@ func (s *Service) logMetrics() {
 
 		// Display top users in column format
 		for i := 0; i < topN; i++ {
-			log.Printf("   %d. %-50s %d emails", i+1, stats[i].email, stats[i].count)
+			log.Printf("   %d. %-50s %d emails", i+1, telemetry.Redact(stats[i].email), stats[i].count)
+		}
+	}
+}
+
+// logLatencyMetrics logs ingest latency percentiles per stage and alerts on p95 SLO breach
+func (s *Service) logLatencyMetrics() {
+	for _, stage := range s.latency.stages() {
+		stats, ok := statsFor(stage.window)
+		if !ok {
+			continue
 		}
+		log.Printf("⏱️  Latency | tenant=%s stage=%-9s | p50: %v | p95: %v | p99: %v",
+			s.tenantID, stage.name,
+			stats.P50.Round(time.Millisecond), stats.P95.Round(time.Millisecond), stats.P99.Round(time.Millisecond))
+	}
+
+	// Ingest SLO is measured end to end: provider received_at -> queue publish
+	if stats, ok := statsFor(s.latency.queue); ok && stats.P95 > s.ingestSLO {
+		log.Printf("🚨 SLO breach | tenant=%s | p95 ingest latency %v exceeds SLO %v",
+			s.tenantID, stats.P95.Round(time.Millisecond), s.ingestSLO)
 	}
 }
 
-// sendToAnalysisQueue sends an email to the analysis queue for fraud detection.
-// This is a placeholder implementation that tracks metrics. In production, this would
-// integrate with a message queue (Kafka/RabbitMQ/NATS) to send emails to analysis workers.
-func (s *Service) sendToAnalysisQueue(email models.ProviderEmail) {
-	// TODO: Integrate with message queue (Kafka/RabbitMQ/NATS)
+// sendToAnalysisQueue sends an email to the analysis queue for fraud detection,
+// shaped by the tenant's payload mode (see AnalysisMessage for the envelope).
+// The default publisher discards messages and only tracks metrics; in production this
+// would integrate with a message queue (Kafka/RabbitMQ/NATS) to reach analysis workers.
+func (s *Service) sendToAnalysisQueue(ctx context.Context, ewu *EmailWithUser, fingerprint string) {
+	policy := s.policyFor(ewu.UserID)
+	msg := buildAnalysisMessage(s.tenantID, ewu, fingerprint, policy.payloadMode(s.payloadMode), s.fetchBaseURL)
+	msg.AlertRoute = policy.AlertRoute
+	if err := s.publishAnalysis(ctx, msg); err != nil && !errors.Is(err, ErrDuplicate) {
+		s.flagQueueFailed(ctx, msg.MessageID)
+	}
+}
+
+// flagQueueFailed flags an email whose analysis message was not published for requeueing
+// (see JobRequeueFailed); the email is stored under its message ID
+func (s *Service) flagQueueFailed(ctx context.Context, messageID string) {
+	if _, err := db.Pool.Exec(ctx, `UPDATE emails SET queue_failed_at = NOW() WHERE id = $1`, messageID); err != nil {
+		log.Printf("Error flagging email %s for requeueing: %v", messageID, err)
+	}
+}
+
+// publishAnalysis publishes an analysis message
+// Returns ErrDuplicate if it was dropped as a replay, or the publishing error
+func (s *Service) publishAnalysis(ctx context.Context, msg AnalysisMessage) error {
+	err := s.publisher.Publish(ctx, msg)
+	if errors.Is(err, ErrDuplicate) {
+		atomic.AddInt64(&s.emailsDeduplicated, 1)
+		return err
+	}
+	if err != nil {
+		log.Printf("Error publishing email %s to analysis queue: %v", msg.MessageID, err)
+		return err
+	}
 	atomic.AddInt64(&s.emailsToQueue, 1)
+	return nil
 }
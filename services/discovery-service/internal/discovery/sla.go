@@ -0,0 +1,108 @@
+package discovery
+
+import (
+	"sort"
+	"time"
+
+	"github.com/google/uuid"
+)
+
+// DefaultAlertMailboxSLA is how long a mailbox may go without a successful poll, longer
+// than the 15-minute maximum backoff of a poller that recovers on its next attempt
+const DefaultAlertMailboxSLA = 20 * time.Minute
+
+// MailboxSLA is the per-user discovery SLA: how long since each monitored mailbox was last
+// polled successfully, and the mailboxes past alerts.mailbox_sla. It catches what aggregate
+// metrics hide: a single wedged poller, or a mailbox failing every poll (e.g. a persistent 403).
+type MailboxSLA struct {
+	SLASeconds             float64 `json:"sla_seconds"`
+	Mailboxes              int     `json:"mailboxes"` // Pollers running
+	Breaching              int     `json:"breaching"`
+	MaxSinceSuccessSeconds float64 `json:"max_since_success_seconds"`
+	// Polls are paused by a maintenance window: breaches do not alert
+	PollingPaused bool        `json:"polling_paused"`
+	Breaches      []SLABreach `json:"breaches,omitempty"` // Longest first (GET /sla only, lists addresses)
+}
+
+// SLABreach is a monitored mailbox not polled successfully within the SLA
+type SLABreach struct {
+	UserID       uuid.UUID  `json:"user_id"`
+	Email        string     `json:"email"`
+	LastSuccess  *time.Time `json:"last_success,omitempty"` // Nil when not polled successfully since its poller started
+	SinceSeconds float64    `json:"since_seconds"`
+	LastError    string     `json:"last_error,omitempty"` // Of the last failed poll, if the last poll failed
+}
+
+// slaInput is one evaluation's snapshot of the pollers
+type slaInput struct {
+	now      time.Time
+	sla      time.Duration
+	paused   bool
+	pollers  map[uuid.UUID]pollerState
+	emails   map[uuid.UUID]string
+	failures map[uuid.UUID]pollFailure
+}
+
+// evaluateMailboxSLA measures every poller against the SLA; a poller that never polled
+// successfully counts from its start
+func evaluateMailboxSLA(in slaInput) MailboxSLA {
+	report := MailboxSLA{SLASeconds: in.sla.Seconds(), Mailboxes: len(in.pollers), PollingPaused: in.paused, Breaches: []SLABreach{}}
+	for id, p := range in.pollers {
+		since := p.lastPoll
+		if since.IsZero() {
+			since = p.startedAt
+		}
+		elapsed := in.now.Sub(since)
+		report.MaxSinceSuccessSeconds = max(report.MaxSinceSuccessSeconds, elapsed.Seconds())
+		if elapsed <= in.sla {
+			continue
+		}
+
+		breach := SLABreach{UserID: id, Email: in.emails[id], SinceSeconds: elapsed.Round(time.Second).Seconds()}
+		if !p.lastPoll.IsZero() {
+			lastPoll := p.lastPoll
+			breach.LastSuccess = &lastPoll
+		}
+		if failure, ok := in.failures[id]; ok {
+			breach.LastError = failure.err
+		}
+		report.Breaches = append(report.Breaches, breach)
+	}
+	report.Breaching = len(report.Breaches)
+	sort.Slice(report.Breaches, func(i, j int) bool {
+		if report.Breaches[i].SinceSeconds != report.Breaches[j].SinceSeconds {
+			return report.Breaches[i].SinceSeconds > report.Breaches[j].SinceSeconds
+		}
+		return report.Breaches[i].Email < report.Breaches[j].Email
+	})
+	return report
+}
+
+// mailboxSLA evaluates the SLA of the running pollers at now
+func (s *Service) mailboxSLA(now time.Time) MailboxSLA {
+	in := slaInput{
+		now:      now,
+		sla:      DefaultAlertMailboxSLA,
+		paused:   s.maintenance.paused(),
+		pollers:  s.pollerStates(),
+		emails:   make(map[uuid.UUID]string),
+		failures: make(map[uuid.UUID]pollFailure),
+	}
+	if s.alerts != nil {
+		in.sla = s.alerts.config.MailboxSLA
+	}
+	for id := range in.pollers {
+		if ued, ok := s.activeUsers.Load(id); ok {
+			in.emails[id] = ued.(*userEmailDiscovery).user.Email
+		}
+		if failure, ok := s.pollFailures.Load(id); ok {
+			in.failures[id] = failure.(pollFailure)
+		}
+	}
+	return evaluateMailboxSLA(in)
+}
+
+// MailboxSLA returns the mailboxes not polled successfully within alerts.mailbox_sla
+func (s *Service) MailboxSLA() MailboxSLA {
+	return s.mailboxSLA(s.clk().Now())
+}
@@ -0,0 +1,57 @@
+package discovery
+
+import (
+	"testing"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/stoik/vigil/internal/clock"
+	discoverymodels "github.com/stoik/vigil/services/discovery-service/internal/models"
+)
+
+func TestEvaluateMailboxSLA(t *testing.T) {
+	now := time.Date(2024, 3, 1, 12, 0, 0, 0, time.UTC)
+	started := now.Add(-time.Hour)
+	healthy, forbidden, wedged, fresh := uuid.New(), uuid.New(), uuid.New(), uuid.New()
+
+	report := evaluateMailboxSLA(slaInput{
+		now: now,
+		sla: 20 * time.Minute,
+		pollers: map[uuid.UUID]pollerState{
+			healthy:   {started, now.Add(-30 * time.Second)},
+			forbidden: {started, now.Add(-45 * time.Minute)},
+			wedged:    {started, time.Time{}},
+			fresh:     {now.Add(-time.Minute), time.Time{}},
+		},
+		emails:   map[uuid.UUID]string{forbidden: "forbidden@example.com", wedged: "wedged@example.com"},
+		failures: map[uuid.UUID]pollFailure{forbidden: {at: now.Add(-time.Minute), err: "403 forbidden"}},
+	})
+
+	if report.Mailboxes != 4 || report.Breaching != 2 || report.MaxSinceSuccessSeconds != time.Hour.Seconds() {
+		t.Fatalf("report = %+v, want 2 of 4 breaching, longest 1h", report)
+	}
+	// Longest first: never polled since its start an hour ago, then the failing one
+	if b := report.Breaches[0]; b.UserID != wedged || b.LastSuccess != nil || b.SinceSeconds != 3600 || b.LastError != "" {
+		t.Errorf("first breach = %+v, want the wedged poller", b)
+	}
+	if b := report.Breaches[1]; b.UserID != forbidden || b.LastSuccess == nil || b.SinceSeconds != 2700 || b.LastError != "403 forbidden" {
+		t.Errorf("second breach = %+v, want the failing poller with its error", b)
+	}
+}
+
+func TestServiceMailboxSLA(t *testing.T) {
+	clk := clock.NewFake(time.Date(2024, 3, 1, 12, 0, 0, 0, time.UTC))
+	s := &Service{clock: clk}
+	user := discoverymodels.User{ID: uuid.New(), Email: "user@example.com"}
+	s.activeUsers.Store(user.ID, &userEmailDiscovery{user: user, startedAt: clk.Now()})
+	s.lastPollAt.Store(user.ID, clk.Now())
+
+	clk.Advance(DefaultAlertMailboxSLA)
+	if sla := s.MailboxSLA(); sla.Breaching != 0 {
+		t.Fatalf("breaching at the SLA: %+v", sla)
+	}
+	clk.Advance(time.Second)
+	if sla := s.MailboxSLA(); sla.Breaching != 1 || sla.Breaches[0].Email != user.Email {
+		t.Fatalf("sla = %+v, want the user breaching", sla)
+	}
+}
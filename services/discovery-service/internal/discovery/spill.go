@@ -0,0 +1,469 @@
+package discovery
+
+import (
+	"bufio"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"net"
+	"os"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/jackc/pgx/v5/pgconn"
+	"github.com/stoik/vigil/internal/models"
+)
+
+const (
+	DefaultSpillMax    = 10000           // Emails held while the database is unavailable
+	SpillRetryInterval = 5 * time.Second // How often the database is checked and the spill buffer replayed
+	// Spill file size above which it is rewritten with only the emails not replayed yet, when
+	// they take less than half of it
+	DefaultSpillCompactSize = 1 << 20
+	spillPingTimeout        = 2 * time.Second
+)
+
+// dbUnavailable reports whether err means the database could not be reached (as opposed to a
+// query that failed): connection refused or reset, timeouts, and server shutdown or startup
+func dbUnavailable(err error) bool {
+	if err == nil {
+		return false
+	}
+	var pgErr *pgconn.PgError
+	if errors.As(err, &pgErr) {
+		// Class 08: connection exception; 57P01-57P03: admin/crash shutdown, cannot connect now
+		return strings.HasPrefix(pgErr.Code, "08") || pgErr.Code == "57P01" || pgErr.Code == "57P02" || pgErr.Code == "57P03"
+	}
+	var netErr net.Error
+	return errors.As(err, &netErr) ||
+		errors.Is(err, context.DeadlineExceeded) ||
+		errors.Is(err, io.EOF) || errors.Is(err, io.ErrUnexpectedEOF) ||
+		pgconn.SafeToRetry(err) || pgconn.Timeout(err)
+}
+
+// pendingEmail is an email not ingested yet, as held by the spill buffer and the ingest journal
+// (one JSON line of their files)
+type pendingEmail struct {
+	UserID       uuid.UUID            `json:"user_id"`
+	Email        models.ProviderEmail `json:"email"`
+	DiscoveredAt time.Time            `json:"discovered_at"`
+	Priority     string               `json:"priority,omitempty"`
+	// Recovered from the ingest journal: it may have been stored before a crash, so it is
+	// queued for analysis even if not new (the queue drops it if it was published)
+	Recovered bool `json:"recovered,omitempty"`
+}
+
+func newPendingEmail(ewu EmailWithUser) pendingEmail {
+	return pendingEmail{UserID: ewu.UserID, Email: ewu.Email, DiscoveredAt: ewu.DiscoveredAt, Priority: ewu.Priority}
+}
+
+func (e pendingEmail) emailWithUser() EmailWithUser {
+	return EmailWithUser{Email: e.Email, UserID: e.UserID, DiscoveredAt: e.DiscoveredAt, Priority: e.Priority}
+}
+
+// emailKey identifies a delivery of an email to a user
+type emailKey struct {
+	userID    uuid.UUID
+	messageID string
+}
+
+func (e pendingEmail) key() emailKey {
+	return emailKey{e.UserID, e.Email.MessageID}
+}
+
+// Readiness reports whether emails are being stored, or held until the database recovers
+type Readiness struct {
+	Status            string     `json:"status"` // "ready" or "degraded"
+	DatabaseAvailable bool       `json:"database_available"`
+	Spilled           int        `json:"spilled"`         // Emails waiting for the database
+	SpillDropped      int64      `json:"spill_dropped"`   // Deliveries dropped on a full buffer since start (polled again later)
+	Since             *time.Time `json:"since,omitempty"` // When the service became degraded
+}
+
+// spillBuffer holds emails that could not be stored while the database is unavailable, and
+// replays them in arrival order once it is back
+//
+// While the database is down, every new email is spilled. Once the health check passes, a
+// user's new emails are spilled only while that user still has spilled ones, so each user's
+// emails are still stored in poll order and a cursor never moves past a spilled email. Each
+// user's emails are replayed in that user's serial executor, like polled emails, so users
+// recover concurrently. Pollers keep reading from the old cursor meanwhile, so the same emails
+// arrive again every poll: entries are deduplicated by user and message ID. When the buffer is
+// full, emails are dropped, along with every later email of the same user until the buffer
+// drains; that user's cursor stays before the first dropped email, so they are polled again
+// after recovery.
+//
+// With a spill file, entries are also appended to it (it holds email content, mode 0600) and
+// reloaded at startup. It is compacted as entries are replayed and truncated once the buffer
+// is empty; a crash during a replay stores some emails twice, which storeEmail deduplicates.
+type spillBuffer struct {
+	max    int
+	ingest func(ctx context.Context, e pendingEmail) error
+	ping   func(ctx context.Context) error
+	// submit runs a user's replay behind the emails already queued for that user
+	submit func(ctx context.Context, userID uuid.UUID, replay func()) error
+
+	mu          sync.Mutex
+	queues      map[uuid.UUID][]*spilled // Per user, in arrival order
+	count       int                      // Emails in queues
+	keys        map[emailKey]bool
+	replaying   map[uuid.UUID]bool // Users whose replay is submitted and not finished
+	dropped     map[uuid.UUID]bool // Users with a dropped email, until the buffer drains
+	dbDown      bool               // Last check failed
+	since       time.Time          // Start of the degraded state, zero when ready
+	total       int64              // Deliveries dropped since start
+	replayed    int                // Emails replayed since the degraded state started
+	nextSeq     uint64
+	path        string
+	file        *os.File
+	size        int64 // Bytes of the spill file
+	live        int64 // Bytes of its entries not replayed yet
+	compactSize int64 // Rewrite the file above this size
+}
+
+// spilled is an entry of the spill buffer
+type spilled struct {
+	seq   uint64 // Arrival order
+	email pendingEmail
+	size  int64 // Bytes of its spill file line
+}
+
+// newSpillBuffer creates a buffer of up to max emails, persisted to path if set
+func newSpillBuffer(max int, path string) (*spillBuffer, error) {
+	if max <= 0 {
+		max = DefaultSpillMax
+	}
+	b := &spillBuffer{
+		max:         max,
+		queues:      make(map[uuid.UUID][]*spilled),
+		keys:        make(map[emailKey]bool),
+		replaying:   make(map[uuid.UUID]bool),
+		dropped:     make(map[uuid.UUID]bool),
+		compactSize: DefaultSpillCompactSize,
+	}
+	if path == "" {
+		return b, nil
+	}
+
+	f, err := os.OpenFile(path, os.O_RDWR|os.O_CREATE|os.O_APPEND, 0600)
+	if err != nil {
+		return nil, fmt.Errorf("failed to open spill file: %w", err)
+	}
+	scanner := bufio.NewScanner(f)
+	scanner.Buffer(make([]byte, 0, 64*1024), 64*1024*1024) // Entries hold bodies
+	for line := 1; scanner.Scan(); line++ {
+		size := int64(len(scanner.Bytes()) + 1)
+		b.size += size
+		var e pendingEmail
+		if err := json.Unmarshal(scanner.Bytes(), &e); err != nil {
+			// A line cut short by a crash; its email is polled again
+			log.Printf("Skipping spill file line %d: %v", line, err)
+			continue
+		}
+		if !b.keys[e.key()] && b.count < b.max {
+			b.push(e, size)
+		}
+	}
+	if err := scanner.Err(); err != nil {
+		f.Close()
+		return nil, fmt.Errorf("failed to read spill file: %w", err)
+	}
+	b.path, b.file = path, f
+	if b.count > 0 {
+		log.Printf("📋 Loaded %d spilled emails from %s, replaying once the database is available", b.count, path)
+		b.since = time.Now()
+	}
+	return b, nil
+}
+
+// push appends an entry of size bytes in the spill file; b.mu must be held (or b not shared yet)
+func (b *spillBuffer) push(e pendingEmail, size int64) {
+	b.nextSeq++
+	b.keys[e.key()] = true
+	b.queues[e.UserID] = append(b.queues[e.UserID], &spilled{seq: b.nextSeq, email: e, size: size})
+	b.count++
+	b.live += size
+}
+
+// add spills an email; false if it was dropped because the buffer is full
+func (b *spillBuffer) add(e pendingEmail) bool {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	return b.addLocked(e)
+}
+
+// addIfActive spills an email while the database is down, or if emails of the same user are
+// already waiting, so it is not stored ahead of them; a user with a dropped email keeps
+// dropping until the buffer drains, so their cursor never moves past the dropped one
+// Returns active false if the email should be stored directly, and spilled false if it was
+// dropped
+func (b *spillBuffer) addIfActive(e pendingEmail) (active, spilled bool) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	if !b.dbDown && len(b.queues[e.UserID]) == 0 && !b.dropped[e.UserID] {
+		return false, false
+	}
+	return true, b.addLocked(e)
+}
+
+func (b *spillBuffer) addLocked(e pendingEmail) bool {
+	key := e.key()
+	if b.keys[key] {
+		return true // Polled again from the same cursor
+	}
+	if b.dropped[e.UserID] || b.count >= b.max {
+		if !b.dropped[e.UserID] {
+			log.Printf("Spill buffer full (%d emails), dropping emails of user %s until it drains (polled again after recovery)", b.max, e.UserID)
+		}
+		b.dropped[e.UserID] = true
+		b.total++
+		return false
+	}
+
+	var size int64
+	if b.file != nil {
+		line, err := json.Marshal(e)
+		if err == nil {
+			_, err = b.file.Write(append(line, '\n'))
+		}
+		if err != nil {
+			log.Printf("Error writing spill file (email %s kept in memory only): %v", e.Email.MessageID, err)
+		} else {
+			size = int64(len(line) + 1)
+			b.size += size
+		}
+	}
+	b.push(e, size)
+	b.updateState()
+	return true
+}
+
+// setDBDown records the outcome of the last database check
+func (b *spillBuffer) setDBDown(down bool) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	b.dbDown = down
+	b.updateState()
+}
+
+// updateState tracks the start and end of the degraded state; b.mu must be held
+func (b *spillBuffer) updateState() {
+	degraded := b.dbDown || b.count > 0
+	switch {
+	case degraded && b.since.IsZero():
+		b.since = time.Now()
+		b.replayed = 0
+		log.Printf("🚨 Database unavailable | holding up to %d emails until it recovers", b.max)
+	case !degraded && !b.since.IsZero():
+		log.Printf("✓ Database available again after %v | replayed %d spilled emails, dropped %d (polled again)",
+			time.Since(b.since).Round(time.Second), b.replayed, b.total)
+		b.since = time.Time{}
+	}
+}
+
+// run checks the database every SpillRetryInterval and replays spilled emails once it answers
+func (b *spillBuffer) run(ctx context.Context) {
+	ticker := time.NewTicker(SpillRetryInterval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+			pingCtx, cancel := context.WithTimeout(ctx, spillPingTimeout)
+			err := b.ping(pingCtx)
+			cancel()
+			if ctx.Err() != nil {
+				return
+			}
+			b.setDBDown(err != nil)
+			if err == nil {
+				b.replay(ctx)
+			}
+		}
+	}
+}
+
+// replay submits the replay of every user with spilled emails and no replay under way
+func (b *spillBuffer) replay(ctx context.Context) {
+	b.mu.Lock()
+	var users []uuid.UUID
+	for userID := range b.queues {
+		if !b.replaying[userID] {
+			b.replaying[userID] = true
+			users = append(users, userID)
+		}
+	}
+	b.mu.Unlock()
+
+	for i, userID := range users {
+		userID := userID
+		if err := b.submit(ctx, userID, func() { b.replayUser(ctx, userID) }); err != nil {
+			b.mu.Lock()
+			for _, id := range users[i:] {
+				delete(b.replaying, id)
+			}
+			b.mu.Unlock()
+			return
+		}
+	}
+}
+
+// replayUser replays a user's spilled emails in order, until none are left or the database
+// fails again (the rest wait for the next successful check)
+func (b *spillBuffer) replayUser(ctx context.Context, userID uuid.UUID) {
+	for {
+		b.mu.Lock()
+		queue := b.queues[userID]
+		if len(queue) == 0 || b.dbDown || ctx.Err() != nil {
+			delete(b.replaying, userID)
+			b.mu.Unlock()
+			return
+		}
+		e := queue[0]
+		b.mu.Unlock()
+
+		err := b.ingest(ctx, e.email)
+		if ctx.Err() == nil && dbUnavailable(err) {
+			b.setDBDown(true)
+			continue
+		}
+		if ctx.Err() != nil {
+			continue
+		}
+		if err != nil {
+			log.Printf("Error storing spilled email %s: %v", e.email.Email.MessageID, err)
+		}
+		b.remove(e)
+	}
+}
+
+// remove drops a replayed entry from the head of its user's queue and from the spill file
+func (b *spillBuffer) remove(e *spilled) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	userID := e.email.UserID
+	queue := b.queues[userID]
+	queue[0] = nil
+	if len(queue) == 1 {
+		delete(b.queues, userID)
+	} else {
+		b.queues[userID] = queue[1:]
+	}
+	delete(b.keys, e.email.key())
+	b.count--
+	b.live -= e.size
+	b.replayed++
+
+	if b.count == 0 {
+		clear(b.dropped)
+		if b.file != nil {
+			if err := b.file.Truncate(0); err != nil {
+				log.Printf("Error truncating spill file: %v", err)
+			} else {
+				b.size, b.live = 0, 0
+			}
+		}
+	} else if b.file != nil && b.size > b.compactSize && b.live < b.size/2 {
+		if err := b.compact(); err != nil {
+			log.Printf("Error compacting spill file: %v", err)
+		}
+	}
+	b.updateState()
+}
+
+// compact rewrites the spill file with only the entries not replayed yet, in arrival order,
+// then swaps it in; b.mu must be held
+func (b *spillBuffer) compact() error {
+	entries := make([]*spilled, 0, b.count)
+	for _, queue := range b.queues {
+		entries = append(entries, queue...)
+	}
+	sort.Slice(entries, func(i, j int) bool { return entries[i].seq < entries[j].seq })
+
+	tmp := b.path + ".tmp"
+	f, err := os.OpenFile(tmp, os.O_WRONLY|os.O_CREATE|os.O_TRUNC, 0600)
+	if err != nil {
+		return err
+	}
+	renamed := false
+	defer func() {
+		if !renamed {
+			os.Remove(tmp)
+		}
+	}()
+	w := bufio.NewWriter(f)
+	sizes := make([]int64, len(entries))
+	var size int64
+	for i, e := range entries {
+		line, err := json.Marshal(e.email)
+		if err != nil {
+			f.Close()
+			return err
+		}
+		w.Write(append(line, '\n'))
+		sizes[i] = int64(len(line) + 1)
+		size += sizes[i]
+	}
+	if err := errors.Join(w.Flush(), f.Sync(), f.Close()); err != nil {
+		return err
+	}
+	if err := os.Rename(tmp, b.path); err != nil {
+		return err
+	}
+	renamed = true
+
+	reopened, err := os.OpenFile(b.path, os.O_RDWR|os.O_APPEND, 0600)
+	if err != nil {
+		return err
+	}
+	b.file.Close()
+	b.file = reopened
+	for i, e := range entries {
+		e.size = sizes[i]
+	}
+	b.size, b.live = size, size
+	return nil
+}
+
+// len returns the number of spilled emails
+func (b *spillBuffer) len() int {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	return b.count
+}
+
+// persistent reports whether spilled emails survive a restart
+func (b *spillBuffer) persistent() bool {
+	return b.file != nil
+}
+
+// readiness returns the buffer's view of the service's readiness
+func (b *spillBuffer) readiness() Readiness {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	r := Readiness{
+		Status:            "ready",
+		DatabaseAvailable: !b.dbDown,
+		Spilled:           b.count,
+		SpillDropped:      b.total,
+	}
+	if !b.since.IsZero() {
+		since := b.since
+		r.Status, r.Since = "degraded", &since
+	}
+	return r
+}
+
+// Readiness reports whether the service is storing emails ("ready") or holding them in the
+// spill buffer while the database is unavailable ("degraded")
+func (s *Service) Readiness() Readiness {
+	return s.spill.readiness()
+}
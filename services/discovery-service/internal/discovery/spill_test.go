@@ -0,0 +1,247 @@
+package discovery
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/google/uuid"
+	"github.com/jackc/pgx/v5/pgconn"
+	"github.com/stoik/vigil/internal/models"
+)
+
+func spilledEmail(userID uuid.UUID, i int) pendingEmail {
+	return pendingEmail{UserID: userID, Email: models.ProviderEmail{MessageID: fmt.Sprintf("m%d", i), Body: "body"}}
+}
+
+func TestDBUnavailable(t *testing.T) {
+	refused := &net.OpError{Op: "dial", Net: "tcp", Err: errors.New("connection refused")}
+	for _, tc := range []struct {
+		err  error
+		want bool
+	}{
+		{nil, false},
+		{fmt.Errorf("failed to insert email: %w", refused), true},
+		{fmt.Errorf("failed to check for existing email: %w", context.DeadlineExceeded), true},
+		{&pgconn.PgError{Code: "57P01"}, true}, // admin_shutdown
+		{&pgconn.PgError{Code: "08006"}, true}, // connection_failure
+		{&pgconn.PgError{Code: "23505"}, false},
+		{errors.New("invalid message_id format"), false},
+		{context.Canceled, false},
+	} {
+		if got := dbUnavailable(tc.err); got != tc.want {
+			t.Errorf("dbUnavailable(%v) = %v, want %v", tc.err, got, tc.want)
+		}
+	}
+}
+
+// heldReplays records the replays a spill buffer submits, to run them when the test chooses
+type heldReplays map[uuid.UUID]func()
+
+func (h heldReplays) submit(_ context.Context, userID uuid.UUID, replay func()) error {
+	h[userID] = replay
+	return nil
+}
+
+// run runs a user's held replay
+func (h heldReplays) run(t *testing.T, userID uuid.UUID) {
+	t.Helper()
+	replay, ok := h[userID]
+	if !ok {
+		t.Fatalf("no replay submitted for user %s", userID)
+	}
+	delete(h, userID)
+	replay()
+}
+
+func TestSpillBuffer(t *testing.T) {
+	alice, bob, carol, dave := uuid.New(), uuid.New(), uuid.New(), uuid.New()
+	b, _ := newSpillBuffer(3, "")
+	replays := heldReplays{}
+	b.submit = replays.submit
+
+	if active, _ := b.addIfActive(spilledEmail(alice, 0)); active {
+		t.Fatal("addIfActive spilled an email with nothing waiting")
+	}
+	b.add(spilledEmail(alice, 1))
+	b.addIfActive(spilledEmail(alice, 1)) // Polled again from the same cursor
+	if active, _ := b.addIfActive(spilledEmail(bob, 2)); active {
+		t.Fatal("addIfActive spilled an email of a user with nothing waiting while the database is up")
+	}
+	b.setDBDown(true)
+	b.addIfActive(spilledEmail(bob, 2))
+	if _, spilled := b.addIfActive(spilledEmail(alice, 3)); !spilled || b.len() != 3 {
+		t.Fatalf("len = %d, want 3 after a duplicate", b.len())
+	}
+	// Full: bob's emails are dropped until the buffer drains, even once there is room
+	if b.add(spilledEmail(bob, 4)) {
+		t.Error("add succeeded on a full buffer")
+	}
+	if r := b.readiness(); r.Status != "degraded" || r.Spilled != 3 || r.SpillDropped != 1 || r.Since == nil {
+		t.Errorf("readiness = %+v", r)
+	}
+	if active, spilled := b.addIfActive(spilledEmail(dave, 9)); !active || spilled {
+		t.Errorf("addIfActive on a full buffer = %v, %v; want active and dropped", active, spilled)
+	}
+
+	var replayed []string
+	down := true
+	b.ingest = func(_ context.Context, e pendingEmail) error {
+		if down {
+			return &pgconn.PgError{Code: "57P03"}
+		}
+		replayed = append(replayed, e.Email.MessageID)
+		if len(replayed) == 1 && b.add(spilledEmail(bob, 5)) {
+			t.Error("add accepted a later email of a user with a dropped one")
+		}
+		if len(replayed) == 2 {
+			if _, spilled := b.addIfActive(spilledEmail(alice, 7)); !spilled {
+				t.Error("addIfActive stored an email ahead of its user's spilled ones")
+			}
+		}
+		return nil
+	}
+
+	// Still down: the entry stays at the front of its user's queue
+	b.setDBDown(false)
+	b.replay(context.Background())
+	replays.run(t, alice)
+	if b.len() != 3 || b.queues[alice][0].email.Email.MessageID != "m1" || b.readiness().DatabaseAvailable {
+		t.Fatalf("after failed replay: len %d, readiness %+v", b.len(), b.readiness())
+	}
+	replays.run(t, bob) // Stops right away: the database is down
+	if b.len() != 3 {
+		t.Fatalf("len = %d after a replay while down, want 3", b.len())
+	}
+
+	// Back up: new emails of users with nothing spilled are stored directly, the others queue
+	// behind their user's spilled emails
+	down = false
+	b.setDBDown(false)
+	if active, _ := b.addIfActive(spilledEmail(carol, 6)); active {
+		t.Error("addIfActive spilled an email of a user with nothing waiting after recovery")
+	}
+	b.replay(context.Background())
+	b.replay(context.Background()) // Alice's replay is under way: not submitted twice
+	replays.run(t, alice)
+	if len(replays) != 1 {
+		t.Fatalf("replays pending = %d, want bob's", len(replays))
+	}
+	replays.run(t, bob)
+	if want := []string{"m1", "m3", "m7", "m2"}; fmt.Sprint(replayed) != fmt.Sprint(want) {
+		t.Errorf("replayed %v, want %v", replayed, want)
+	}
+	if r := b.readiness(); r.Status != "ready" || r.Spilled != 0 || r.Since != nil {
+		t.Errorf("readiness after drain = %+v", r)
+	}
+	if !b.add(spilledEmail(bob, 8)) {
+		t.Error("bob's emails are still dropped after the buffer drained")
+	}
+}
+
+func TestSpillFile(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "spill.jsonl")
+	alice, bob := uuid.New(), uuid.New()
+
+	b, err := newSpillBuffer(10, path)
+	if err != nil {
+		t.Fatalf("newSpillBuffer: %v", err)
+	}
+	b.add(spilledEmail(alice, 1))
+	b.add(spilledEmail(alice, 2))
+	if info, err := os.Stat(path); err != nil || info.Mode().Perm() != 0600 {
+		t.Fatalf("spill file = %v, %v; want mode 0600", info, err)
+	}
+
+	// A restart reloads the spilled emails, ignoring a line cut short by a crash
+	f, _ := os.OpenFile(path, os.O_APPEND|os.O_WRONLY, 0600)
+	f.WriteString(`{"user_id":"` + alice.String() + `","email":{"mess`)
+	f.Close()
+	reloaded, err := newSpillBuffer(10, path)
+	if err != nil {
+		t.Fatalf("reload: %v", err)
+	}
+	if reloaded.len() != 2 || reloaded.queues[alice][1].email.Email.MessageID != "m2" || reloaded.readiness().Status != "degraded" {
+		t.Fatalf("reloaded %d entries, readiness %+v", reloaded.len(), reloaded.readiness())
+	}
+	for i := 3; i <= 6; i++ {
+		reloaded.add(spilledEmail(bob, i))
+	}
+
+	// Replayed entries are compacted out of the file as the replay progresses
+	replays := heldReplays{}
+	reloaded.submit = replays.submit
+	reloaded.compactSize = 1
+	reloaded.ingest = func(context.Context, pendingEmail) error { return nil }
+	reloaded.replay(context.Background())
+	replays.run(t, bob)
+	compacted, err := newSpillBuffer(10, path)
+	if err != nil {
+		t.Fatalf("reload after compaction: %v", err)
+	}
+	// Compacted once less than half of it was left: alice's 2 and bob's last are kept
+	if compacted.len() != 3 || len(compacted.queues[bob]) != 1 || compacted.queues[bob][0].email.Email.MessageID != "m6" {
+		t.Errorf("spill file holds %d entries after bob's replay, want alice's 2 and m6", compacted.len())
+	}
+
+	replays.run(t, alice)
+	if info, _ := os.Stat(path); info.Size() != 0 {
+		t.Errorf("spill file is %d bytes after a full drain, want empty", info.Size())
+	}
+}
+
+func TestSpillDroppedUserAfterRecovery(t *testing.T) {
+	alice, bob := uuid.New(), uuid.New()
+	b, _ := newSpillBuffer(1, "")
+	replays := heldReplays{}
+	b.submit = replays.submit
+	b.ingest = func(context.Context, pendingEmail) error { return nil }
+	b.setDBDown(true)
+	b.add(spilledEmail(alice, 1))
+	b.add(spilledEmail(bob, 2)) // Dropped: full
+
+	// Back up, bob has nothing spilled but a dropped email: his next one is dropped too, or it
+	// would be stored and his cursor would move past the dropped one
+	b.setDBDown(false)
+	if active, spilled := b.addIfActive(spilledEmail(bob, 3)); !active || spilled {
+		t.Errorf("addIfActive for a user with only dropped emails = %v, %v; want active and dropped", active, spilled)
+	}
+
+	// Once the buffer drains, bob's emails are stored directly again (polled from his old cursor)
+	b.replay(context.Background())
+	replays.run(t, alice)
+	if active, _ := b.addIfActive(spilledEmail(bob, 4)); active {
+		t.Error("addIfActive still held bob's emails after the buffer drained")
+	}
+}
+
+func TestHandleEmailSpillFull(t *testing.T) {
+	alice, bob := uuid.New(), uuid.New()
+	b, _ := newSpillBuffer(1, "")
+	b.setDBDown(true)
+	b.add(spilledEmail(alice, 1))
+	j := newIngestJournal(filepath.Join(t.TempDir(), "journal.jsonl"), false)
+	if _, err := j.open(); err != nil {
+		t.Fatalf("open journal: %v", err)
+	}
+	s := &Service{spill: b, journal: j}
+
+	// Dropped while the database is down: acknowledged, as it is polled again after recovery
+	ewu := spilledEmail(bob, 2).emailWithUser()
+	s.handleEmail(context.Background(), &ewu, false)
+	if b.len() != 1 || j.pendingCount() != 0 {
+		t.Errorf("spilled %d, journal pending %d after a drop; want 1 and 0", b.len(), j.pendingCount())
+	}
+
+	// Spilled: kept in the journal until it is stored
+	b.max = 2
+	ewu = spilledEmail(alice, 3).emailWithUser()
+	s.handleEmail(context.Background(), &ewu, false)
+	if b.len() != 2 || j.pendingCount() != 1 {
+		t.Errorf("spilled %d, journal pending %d after a spill; want 2 and 1", b.len(), j.pendingCount())
+	}
+}
@@ -0,0 +1,188 @@
+package discovery
+
+import (
+	"context"
+	"fmt"
+	"math/rand"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/stoik/vigil/internal/models"
+	"github.com/stoik/vigil/services/discovery-service/internal/db"
+	"github.com/stoik/vigil/services/discovery-service/internal/db/dbtest"
+)
+
+// setupTestDB resets discovery tables (see dbtest.Open)
+func setupTestDB(t testing.TB) context.Context {
+	return dbtest.Open(t, "user_emails", "emails", "users")
+}
+
+func insertTestUsers(t testing.TB, ctx context.Context, n int) []uuid.UUID {
+	t.Helper()
+
+	ids := make([]uuid.UUID, n)
+	for i := range ids {
+		ids[i] = uuid.New()
+		if _, err := db.Pool.Exec(ctx, "INSERT INTO users (id, email) VALUES ($1, $2)",
+			ids[i], fmt.Sprintf("user%d@example.com", i)); err != nil {
+			t.Fatalf("failed to insert user: %v", err)
+		}
+	}
+	return ids
+}
+
+// TestStoreEmailDedupUnderConcurrency delivers a random mix of emails, duplicates with the same
+// message ID, and redeliveries under new message IDs from many goroutines at once, then checks
+// that each distinct body was reported as new exactly once and linked once per recipient.
+func TestStoreEmailDedupUnderConcurrency(t *testing.T) {
+	ctx := setupTestDB(t)
+
+	for seed := int64(1); seed <= 5; seed++ {
+		t.Run(fmt.Sprintf("seed=%d", seed), func(t *testing.T) {
+			if _, err := db.Pool.Exec(ctx, "TRUNCATE user_emails, emails, users CASCADE"); err != nil {
+				t.Fatalf("failed to reset tables: %v", err)
+			}
+			rng := rand.New(rand.NewSource(seed))
+			users := insertTestUsers(t, ctx, 4)
+
+			// A small pool of bodies guarantees collisions
+			type delivery struct {
+				email  models.ProviderEmail
+				userID uuid.UUID
+			}
+			bodies := make([]string, 20)
+			messageIDs := make([]string, len(bodies))
+			for i := range bodies {
+				bodies[i] = fmt.Sprintf("body %d seed %d", i, seed)
+				messageIDs[i] = uuid.NewString()
+			}
+
+			var deliveries []delivery
+			expectedLinks := make(map[string]map[uuid.UUID]bool) // fingerprint -> users
+			for i := 0; i < 200; i++ {
+				b := rng.Intn(len(bodies))
+				messageID := messageIDs[b]
+				if rng.Intn(3) == 0 {
+					// Redelivery under a fresh message ID, same content
+					messageID = uuid.NewString()
+				}
+				userID := users[rng.Intn(len(users))]
+				deliveries = append(deliveries, delivery{
+					email: models.ProviderEmail{
+						MessageID:  messageID,
+						Body:       bodies[b],
+						ReceivedAt: time.Now().Add(-time.Duration(rng.Intn(3600)) * time.Second),
+					},
+					userID: userID,
+				})
+				fp := Fingerprint(bodies[b])
+				if expectedLinks[fp] == nil {
+					expectedLinks[fp] = make(map[uuid.UUID]bool)
+				}
+				expectedLinks[fp][userID] = true
+			}
+
+			s := &Service{}
+			newCounts := make(map[string]*int64)
+			for _, body := range bodies {
+				newCounts[Fingerprint(body)] = new(int64)
+			}
+
+			var wg sync.WaitGroup
+			for _, d := range deliveries {
+				wg.Add(1)
+				go func(d delivery) {
+					defer wg.Done()
+					isNew, err := s.storeEmail(ctx, d.email, Fingerprint(d.email.Body), d.userID)
+					if err != nil {
+						t.Errorf("storeEmail: %v", err)
+						return
+					}
+					if isNew {
+						atomic.AddInt64(newCounts[Fingerprint(d.email.Body)], 1)
+					}
+				}(d)
+			}
+			wg.Wait()
+
+			for fp, links := range expectedLinks {
+				if n := atomic.LoadInt64(newCounts[fp]); n != 1 {
+					t.Errorf("fingerprint %s reported new %d times, want 1", fp[:12], n)
+				}
+
+				var rows, linked int
+				if err := db.Pool.QueryRow(ctx, "SELECT COUNT(*) FROM emails WHERE fingerprint = $1", fp).Scan(&rows); err != nil {
+					t.Fatal(err)
+				}
+				if rows != 1 {
+					t.Errorf("fingerprint %s stored %d times, want 1", fp[:12], rows)
+				}
+				if err := db.Pool.QueryRow(ctx,
+					`SELECT COUNT(*) FROM user_emails ue JOIN emails e ON e.id = ue.email_id WHERE e.fingerprint = $1`,
+					fp).Scan(&linked); err != nil {
+					t.Fatal(err)
+				}
+				if linked != len(links) {
+					t.Errorf("fingerprint %s linked to %d users, want %d", fp[:12], linked, len(links))
+				}
+			}
+
+			if got := atomic.LoadInt64(&s.emailsDiscovered); got != int64(len(expectedLinks)) {
+				t.Errorf("emailsDiscovered = %d, want %d", got, len(expectedLinks))
+			}
+			dedup := s.dedupStats()
+			if total := dedup.Stored + dedup.Duplicates + dedup.FingerprintMatches; total != int64(len(deliveries)) || dedup.FingerprintMatches == 0 {
+				t.Errorf("dedup stats %+v account for %d deliveries, want %d with cross-user matches", dedup, total, len(deliveries))
+			}
+		})
+	}
+}
+
+// TestAdvanceCursorMonotonic applies cursor updates in random order from many goroutines
+// and checks last_email_received ends at the latest timestamp, never an earlier one.
+func TestAdvanceCursorMonotonic(t *testing.T) {
+	ctx := setupTestDB(t)
+	userID := insertTestUsers(t, ctx, 1)[0]
+
+	base := time.Now().Truncate(time.Microsecond) // Postgres timestamp precision
+	times := make([]time.Time, 500)
+	for i := range times {
+		times[i] = base.Add(time.Duration(i) * time.Millisecond)
+	}
+	latest := times[len(times)-1]
+	rand.Shuffle(len(times), func(i, j int) { times[i], times[j] = times[j], times[i] })
+
+	var wg sync.WaitGroup
+	for _, ts := range times {
+		wg.Add(1)
+		go func(ts time.Time) {
+			defer wg.Done()
+			if err := advanceCursor(ctx, userID, ts); err != nil {
+				t.Errorf("advanceCursor: %v", err)
+			}
+		}(ts)
+	}
+	wg.Wait()
+
+	var got time.Time
+	if err := db.Pool.QueryRow(ctx, "SELECT last_email_received FROM users WHERE id = $1", userID).Scan(&got); err != nil {
+		t.Fatal(err)
+	}
+	if !got.Equal(latest) {
+		t.Errorf("last_email_received = %v, want %v", got, latest)
+	}
+
+	// Going backwards is a no-op
+	if err := advanceCursor(ctx, userID, base.Add(-time.Hour)); err != nil {
+		t.Fatal(err)
+	}
+	if err := db.Pool.QueryRow(ctx, "SELECT last_email_received FROM users WHERE id = $1", userID).Scan(&got); err != nil {
+		t.Fatal(err)
+	}
+	if !got.Equal(latest) {
+		t.Errorf("cursor moved backwards to %v", got)
+	}
+}
@@ -0,0 +1,148 @@
+package discovery
+
+import (
+	"context"
+	"fmt"
+	"slices"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/spf13/viper"
+	"github.com/stoik/vigil/services/discovery-service/internal/db"
+	discoverymodels "github.com/stoik/vigil/services/discovery-service/internal/models"
+)
+
+// UserVerification is the completeness audit result for one user
+type UserVerification struct {
+	UserID   uuid.UUID
+	Email    string
+	Provider int      // Emails the provider returned in the range
+	Stored   int      // Emails linked to the user in the range
+	Missing  []string // Provider message IDs with no stored fingerprint for the user
+	Extra    []string // Stored fingerprints the provider no longer returns
+}
+
+// OK reports whether the user's stored emails match the provider exactly
+func (v UserVerification) OK() bool {
+	return len(v.Missing) == 0 && len(v.Extra) == 0
+}
+
+// Verify re-queries the provider for every known user (or only userID when set) and
+// cross-checks the emails received in [from, to) against stored fingerprints/user_emails
+func (s *Service) Verify(ctx context.Context, from, to time.Time, userID *uuid.UUID) ([]UserVerification, error) {
+	var users []discoverymodels.User
+	if userID != nil {
+		user, err := s.getUserByID(ctx, *userID)
+		if err != nil {
+			return nil, fmt.Errorf("failed to get user %s: %w", *userID, err)
+		}
+		users = append(users, user)
+	} else {
+		var err error
+		if users, err = s.getUsers(ctx); err != nil {
+			return nil, fmt.Errorf("failed to get users: %w", err)
+		}
+	}
+
+	// Stored fingerprints depend on each user's policy
+	if s.policies != nil && len(s.policies.policies) > 0 {
+		tenantID := s.tenantID
+		if tenantID == uuid.Nil {
+			tenantID, _ = uuid.Parse(viper.GetString("tenant_id")) // Not running: the CLI's tenant
+		}
+		directory, err := s.provider.GetUsers(tenantID)
+		if err != nil {
+			return nil, fmt.Errorf("failed to get users from provider: %w", err)
+		}
+		for _, user := range directory {
+			s.policies.assign(user)
+		}
+	}
+
+	results := make([]UserVerification, 0, len(users))
+	for _, user := range users {
+		result, err := s.verifyUser(ctx, user, from, to)
+		if err != nil {
+			return results, fmt.Errorf("failed to verify user %s: %w", user.ID, err)
+		}
+		results = append(results, result)
+	}
+	return results, nil
+}
+
+func (s *Service) verifyUser(ctx context.Context, user discoverymodels.User, from, to time.Time) (UserVerification, error) {
+	result := UserVerification{UserID: user.ID, Email: user.Email}
+
+	// Provider filter is inclusive, receivedAfter = from
+	mode := s.policyFor(user.ID).BodyMode
+	emails, err := s.fetchEmails(user.ID, from, mode)
+	if err != nil {
+		return result, err
+	}
+
+	// Fingerprints of each email under the current and previous algorithms
+	candidates := make([][]string, len(emails))
+	providerFingerprints := make(map[string]bool)
+	for i, email := range emails {
+		if !email.ReceivedAt.Before(to) {
+			continue
+		}
+		candidates[i] = s.fingerprints(email, s.fingerprintAlg.FingerprintEmail(email, s.fingerprintMode))
+		if providerFingerprints[candidates[i][0]] {
+			// Duplicate delivery of the same content, counted once
+			continue
+		}
+		for _, fp := range candidates[i] {
+			providerFingerprints[fp] = true
+		}
+		result.Provider++
+	}
+
+	stored, err := storedFingerprints(ctx, user.ID, from, to)
+	if err != nil {
+		return result, err
+	}
+	result.Stored = len(stored)
+
+	reported := make(map[string]bool)
+	for i, email := range emails {
+		if !email.ReceivedAt.Before(to) || reported[candidates[i][0]] || slices.ContainsFunc(candidates[i], func(fp string) bool { return stored[fp] }) {
+			continue
+		}
+		// Report each missing content once, under the first message ID that carried it
+		result.Missing = append(result.Missing, email.MessageID)
+		reported[candidates[i][0]] = true
+	}
+	for fp := range stored {
+		if !providerFingerprints[fp] {
+			result.Extra = append(result.Extra, fp)
+		}
+	}
+
+	return result, nil
+}
+
+// storedFingerprints returns fingerprints linked to a user with received_at in [from, to)
+func storedFingerprints(ctx context.Context, userID uuid.UUID, from, to time.Time) (map[string]bool, error) {
+	rows, err := db.ReadPool.Query(ctx,
+		`SELECT e.fingerprint
+		FROM user_emails ue
+		JOIN emails e ON e.id = ue.email_id
+		WHERE ue.user_id = $1 AND e.received_at >= $2 AND e.received_at < $3`,
+		userID, from, to,
+	)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	fingerprints := make(map[string]bool)
+	for rows.Next() {
+		var fp string
+		if err := rows.Scan(&fp); err != nil {
+			return nil, err
+		}
+		fingerprints[fp] = true
+	}
+	return fingerprints, rows.Err()
+}
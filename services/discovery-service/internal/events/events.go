@@ -0,0 +1,172 @@
+package events
+
+import (
+	"context"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/stoik/vigil/services/discovery-service/internal/db"
+)
+
+// Event types
+const (
+	TypeEmailDiscovered = "email.discovered"
+	TypeEmailDetected   = "email.detected" // Recorded by analysis when an email is flagged
+	TypeUserAdded       = "user.added"
+	TypeUserRemoved     = "user.removed"
+	TypePollCapped      = "poll.capped"     // A poll returned more emails than polling.max_emails_per_poll
+	TypeEmailReported   = "email.reported"  // A user reported an email as suspicious
+	TypeServiceStopped  = "service.stopped" // An instance shut down; graceful is false if in-flight emails were cut off
+)
+
+const (
+	DefaultPageSize = 100
+	MaxPageSize     = 1000
+)
+
+// ErrInvalidCursor is returned when an events cursor cannot be decoded
+var ErrInvalidCursor = errors.New("invalid events cursor")
+
+// Event is a discovery or detection event
+type Event struct {
+	ID      int64           `json:"id"`
+	At      time.Time       `json:"at"`
+	Type    string          `json:"type"`
+	UserID  *uuid.UUID      `json:"user_id,omitempty"`
+	EmailID *uuid.UUID      `json:"email_id,omitempty"`
+	Data    json.RawMessage `json:"data,omitempty"`
+}
+
+// Page is a batch of events and the cursor to resume after it
+// Cursor is returned even when Events is empty, so consumers can keep polling with it
+type Page struct {
+	Events []Event `json:"events"`
+	Cursor string  `json:"cursor"`
+}
+
+// Record appends an event to the events table
+// data is marshalled to JSON (nil for none)
+func Record(ctx context.Context, eventType string, userID, emailID *uuid.UUID, data any) error {
+	var raw []byte
+	if data != nil {
+		var err error
+		if raw, err = json.Marshal(data); err != nil {
+			return fmt.Errorf("failed to marshal event data: %w", err)
+		}
+	}
+
+	_, err := db.Pool.Exec(ctx,
+		`INSERT INTO events (type, user_id, email_id, data) VALUES ($1, $2, $3, $4)`,
+		eventType, userID, emailID, raw,
+	)
+	return err
+}
+
+// List returns up to limit events after cursor ("" = from the beginning)
+//
+// Events are ordered by (inserting transaction, id) and only events from transactions older
+// than every transaction still in flight are returned. Once an event has been returned, no
+// event can later commit before it, so a consumer that persists the cursor sees each event
+// exactly once. Reads go to the primary: snapshot bounds are not meaningful on a replica.
+func List(ctx context.Context, cursor string, limit int) (Page, error) {
+	if limit <= 0 {
+		limit = DefaultPageSize
+	}
+	if limit > MaxPageSize {
+		limit = MaxPageSize
+	}
+
+	txid, id := "0", int64(0)
+	if cursor != "" {
+		var err error
+		if txid, id, err = decodeCursor(cursor); err != nil {
+			return Page{}, err
+		}
+	}
+
+	rows, err := db.Pool.Query(ctx, `
+		SELECT id, txid::text, at, type, user_id, email_id, data
+		FROM events
+		WHERE (txid, id) > ($1::xid8, $2)
+			AND txid < pg_snapshot_xmin(pg_current_snapshot())
+		ORDER BY txid, id
+		LIMIT $3`,
+		txid, id, limit,
+	)
+	if err != nil {
+		return Page{}, fmt.Errorf("failed to list events: %w", err)
+	}
+	defer rows.Close()
+
+	page := Page{Events: []Event{}, Cursor: cursor}
+	for rows.Next() {
+		var e Event
+		var eventTxid string
+		if err := rows.Scan(&e.ID, &eventTxid, &e.At, &e.Type, &e.UserID, &e.EmailID, &e.Data); err != nil {
+			return Page{}, fmt.Errorf("failed to scan event: %w", err)
+		}
+		page.Events = append(page.Events, e)
+		page.Cursor = encodeCursor(eventTxid, e.ID)
+	}
+	if err := rows.Err(); err != nil {
+		return Page{}, fmt.Errorf("failed to list events: %w", err)
+	}
+	return page, nil
+}
+
+// ForUser returns every event about a user, oldest first (data-subject access exports)
+func ForUser(ctx context.Context, userID uuid.UUID) ([]Event, error) {
+	rows, err := db.ReadPool.Query(ctx, `
+		SELECT id, at, type, user_id, email_id, data
+		FROM events
+		WHERE user_id = $1
+		ORDER BY id`,
+		userID,
+	)
+	if err != nil {
+		return nil, fmt.Errorf("failed to list user events: %w", err)
+	}
+	defer rows.Close()
+
+	userEvents := []Event{}
+	for rows.Next() {
+		var e Event
+		if err := rows.Scan(&e.ID, &e.At, &e.Type, &e.UserID, &e.EmailID, &e.Data); err != nil {
+			return nil, fmt.Errorf("failed to scan event: %w", err)
+		}
+		userEvents = append(userEvents, e)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, fmt.Errorf("failed to list user events: %w", err)
+	}
+	return userEvents, nil
+}
+
+func encodeCursor(txid string, id int64) string {
+	return base64.RawURLEncoding.EncodeToString([]byte(txid + "." + strconv.FormatInt(id, 10)))
+}
+
+func decodeCursor(cursor string) (string, int64, error) {
+	raw, err := base64.RawURLEncoding.DecodeString(cursor)
+	if err != nil {
+		return "", 0, ErrInvalidCursor
+	}
+	txid, idStr, ok := strings.Cut(string(raw), ".")
+	if !ok {
+		return "", 0, ErrInvalidCursor
+	}
+	if _, err := strconv.ParseUint(txid, 10, 64); err != nil {
+		return "", 0, ErrInvalidCursor
+	}
+	id, err := strconv.ParseInt(idStr, 10, 64)
+	if err != nil {
+		return "", 0, ErrInvalidCursor
+	}
+	return txid, id, nil
+}
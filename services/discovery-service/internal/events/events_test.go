@@ -0,0 +1,22 @@
+package events
+
+import (
+	"errors"
+	"testing"
+)
+
+func TestCursorRoundTrip(t *testing.T) {
+	txid, id, err := decodeCursor(encodeCursor("4294967302", 42))
+	if err != nil {
+		t.Fatalf("decode: %v", err)
+	}
+	if txid != "4294967302" || id != 42 {
+		t.Errorf("round trip = (%s, %d), want (4294967302, 42)", txid, id)
+	}
+
+	for _, bad := range []string{"!!!", "", encodeCursor("abc", 1), "MTIz"} {
+		if _, _, err := decodeCursor(bad); !errors.Is(err, ErrInvalidCursor) {
+			t.Errorf("decodeCursor(%q) err = %v, want ErrInvalidCursor", bad, err)
+		}
+	}
+}
@@ -0,0 +1,157 @@
+// Package fsck checks the database for inconsistencies left by crashes or manual interventions,
+// and optionally repairs them
+package fsck
+
+import (
+	"context"
+	"fmt"
+	"time"
+
+	"github.com/stoik/vigil/services/discovery-service/internal/db"
+)
+
+const (
+	DefaultBatchSize = 1000
+	DefaultGrace     = time.Hour       // Emails stored this recently may still be getting linked
+	DefaultSkew      = 5 * time.Minute // Tolerated provider clock skew on cursors
+	maxExamples      = 10
+)
+
+// Check names
+const (
+	CheckOrphanedUserEmails = "orphaned_user_emails" // Links to a missing user or email
+	CheckOrphanedReports    = "orphaned_reports"     // Reports of a missing user or email
+	CheckUnlinkedEmails     = "unlinked_emails"      // Emails no user holds
+	CheckFutureCursors      = "future_cursors"       // Cursors ahead of now: polls would skip emails
+)
+
+// Options configures a check
+type Options struct {
+	Repair    bool
+	BatchSize int           // Rows repaired per statement
+	Grace     time.Duration // Emails received more recently are not reported as unlinked
+	Skew      time.Duration // Cursors up to this far ahead of now are tolerated
+}
+
+// Finding is the result of one check
+type Finding struct {
+	Check       string   `json:"check"`
+	Description string   `json:"description"`
+	Found       int64    `json:"found"`
+	Repaired    int64    `json:"repaired"`
+	Examples    []string `json:"examples,omitempty"` // First rows found (IDs)
+	Repair      string   `json:"repair"`             // What repairing does
+}
+
+// Report is the result of a check of the whole database
+type Report struct {
+	At       time.Time `json:"at"`
+	Repair   bool      `json:"repair"`
+	Findings []Finding `json:"findings"`
+}
+
+// Problems returns the number of problems found and not repaired
+func (r Report) Problems() int64 {
+	var n int64
+	for _, f := range r.Findings {
+		n += f.Found - f.Repaired
+	}
+	return n
+}
+
+// check is one consistency rule: rows of table matching where (aliased t) are inconsistent
+type check struct {
+	name        string
+	description string
+	table       string
+	key         string // Row identifier, as text
+	where       string
+	repair      string // Statement taking the batch size as $1, affecting matching rows
+	repairDoc   string
+}
+
+func checks(opts Options) []check {
+	grace := fmt.Sprintf("make_interval(secs => %f)", opts.Grace.Seconds())
+	skew := fmt.Sprintf("make_interval(secs => %f)", opts.Skew.Seconds())
+	orphanedUserEmails := `NOT EXISTS (SELECT 1 FROM users u WHERE u.id = t.user_id) OR NOT EXISTS (SELECT 1 FROM emails e WHERE e.id = t.email_id)`
+	orphanedReports := `NOT EXISTS (SELECT 1 FROM users u WHERE u.id = t.user_id) OR NOT EXISTS (SELECT 1 FROM emails e WHERE e.id = t.email_id)`
+	unlinkedEmails := `NOT EXISTS (SELECT 1 FROM user_emails ue WHERE ue.email_id = t.id) AND t.received_at < NOW() - ` + grace
+	futureCursors := `t.last_email_received > NOW() + ` + skew + ` OR t.last_email_check > NOW() + ` + skew
+
+	// Links first: removing them can leave emails unlinked
+	return []check{
+		{
+			name: CheckOrphanedUserEmails, description: "user_emails rows whose user or email is missing",
+			table: "user_emails", key: `t.user_id::TEXT || '/' || t.email_id::TEXT`, where: orphanedUserEmails,
+			repair: `DELETE FROM user_emails WHERE (user_id, email_id) IN
+				(SELECT t.user_id, t.email_id FROM user_emails t WHERE ` + orphanedUserEmails + ` LIMIT $1)`,
+			repairDoc: "delete the rows",
+		},
+		{
+			name: CheckOrphanedReports, description: "reports whose reporting user or email is missing",
+			table: "reports", key: `t.id::TEXT`, where: orphanedReports,
+			repair:    `DELETE FROM reports WHERE id IN (SELECT t.id FROM reports t WHERE ` + orphanedReports + ` LIMIT $1)`,
+			repairDoc: "delete the rows",
+		},
+		{
+			name: CheckUnlinkedEmails, description: "emails no user holds (no user_emails row)",
+			table: "emails", key: `t.id::TEXT`, where: unlinkedEmails,
+			repair:    `DELETE FROM emails WHERE id IN (SELECT t.id FROM emails t WHERE ` + unlinkedEmails + ` LIMIT $1)`,
+			repairDoc: "delete the emails, with their reports and LSH bands",
+		},
+		{
+			name: CheckFutureCursors, description: "users whose polling cursor is in the future, so polls skip emails",
+			table: "users", key: `t.id::TEXT`, where: futureCursors,
+			repair: `UPDATE users u SET
+				last_email_received = (SELECT MAX(e.received_at) FROM user_emails ue JOIN emails e ON e.id = ue.email_id
+					WHERE ue.user_id = u.id AND e.received_at <= NOW()),
+				last_email_check = LEAST(u.last_email_check, NOW())
+				WHERE u.id IN (SELECT t.id FROM users t WHERE ` + futureCursors + ` LIMIT $1)`,
+			repairDoc: "move the cursor back to the user's latest stored email (or clear it: polls then start from last_email_check)",
+		},
+	}
+}
+
+// Run runs every check, and with opts.Repair repairs what it finds
+func Run(ctx context.Context, opts Options) (Report, error) {
+	if opts.BatchSize < 1 {
+		opts.BatchSize = DefaultBatchSize
+	}
+	report := Report{At: time.Now(), Repair: opts.Repair, Findings: []Finding{}}
+
+	for _, c := range checks(opts) {
+		f := Finding{Check: c.name, Description: c.description, Repair: c.repairDoc}
+		if err := db.ReadPool.QueryRow(ctx, `SELECT COUNT(*) FROM `+c.table+` t WHERE `+c.where).Scan(&f.Found); err != nil {
+			return report, fmt.Errorf("%s: %w", c.name, err)
+		}
+		if f.Found > 0 {
+			rows, err := db.ReadPool.Query(ctx, fmt.Sprintf(`SELECT %s FROM %s t WHERE %s LIMIT %d`, c.key, c.table, c.where, maxExamples))
+			if err != nil {
+				return report, fmt.Errorf("%s: %w", c.name, err)
+			}
+			for rows.Next() {
+				var example string
+				if err := rows.Scan(&example); err != nil {
+					rows.Close()
+					return report, fmt.Errorf("%s: %w", c.name, err)
+				}
+				f.Examples = append(f.Examples, example)
+			}
+			rows.Close()
+			if err := rows.Err(); err != nil {
+				return report, fmt.Errorf("%s: %w", c.name, err)
+			}
+		}
+
+		if opts.Repair && f.Found > 0 {
+			n, err := db.InBatches(ctx, opts.BatchSize, c.repair)
+			f.Repaired = min(n, f.Found) // Rows found since the count are repaired too
+			if err != nil {
+				report.Findings = append(report.Findings, f)
+				return report, fmt.Errorf("failed to repair %s: %w", c.name, err)
+			}
+		}
+		report.Findings = append(report.Findings, f)
+	}
+	return report, nil
+}
@@ -0,0 +1,83 @@
+package fsck
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/stoik/vigil/services/discovery-service/internal/db"
+	"github.com/stoik/vigil/services/discovery-service/internal/db/dbtest"
+)
+
+// setupTestDB resets the tables fsck checks (see dbtest.Open)
+func setupTestDB(t testing.TB) context.Context {
+	return dbtest.Open(t, "user_emails", "emails", "users", "reports")
+}
+
+func TestReportProblems(t *testing.T) {
+	r := Report{Findings: []Finding{{Found: 3, Repaired: 3}, {Found: 2}, {}}}
+	if got := r.Problems(); got != 2 {
+		t.Errorf("Problems() = %d, want 2", got)
+	}
+}
+
+func TestRun(t *testing.T) {
+	ctx := setupTestDB(t)
+
+	exec := func(query string, args ...any) {
+		t.Helper()
+		if _, err := db.Pool.Exec(ctx, query, args...); err != nil {
+			t.Fatalf("%s: %v", query, err)
+		}
+	}
+	now := time.Now()
+	userID, ok := uuid.New(), uuid.New()
+	exec(`INSERT INTO users (id, email, last_email_received) VALUES ($1, 'alice@example.com', $2)`, userID, now.Add(24*time.Hour))
+	exec(`INSERT INTO users (id, email, last_email_received) VALUES ($1, 'bob@example.com', $2)`, ok, now.Add(time.Minute))
+
+	linked, unlinked, recent := uuid.New(), uuid.New(), uuid.New()
+	for id, receivedAt := range map[uuid.UUID]time.Time{linked: now.Add(-3 * time.Hour), unlinked: now.Add(-2 * time.Hour), recent: now} {
+		exec(`INSERT INTO emails (id, fingerprint, received_at) VALUES ($1, $2, $3)`, id, id.String(), receivedAt)
+	}
+	exec(`INSERT INTO user_emails (user_id, email_id) VALUES ($1, $2)`, userID, linked)
+
+	opts := Options{BatchSize: 1, Grace: time.Hour, Skew: 5 * time.Minute}
+	report, err := Run(ctx, opts)
+	if err != nil {
+		t.Fatalf("Run: %v", err)
+	}
+	found := map[string]int64{}
+	for _, f := range report.Findings {
+		found[f.Check] = f.Found
+	}
+	want := map[string]int64{CheckOrphanedUserEmails: 0, CheckOrphanedReports: 0, CheckUnlinkedEmails: 1, CheckFutureCursors: 1}
+	for check, n := range want {
+		if found[check] != n {
+			t.Errorf("%s found %d, want %d", check, found[check], n)
+		}
+	}
+	if report.Problems() != 2 {
+		t.Errorf("Problems() = %d, want 2", report.Problems())
+	}
+
+	opts.Repair = true
+	if report, err = Run(ctx, opts); err != nil || report.Problems() != 0 {
+		t.Fatalf("Run with repair = %d problems, %v", report.Problems(), err)
+	}
+	var emails int
+	var cursor time.Time
+	db.Pool.QueryRow(ctx, `SELECT COUNT(*) FROM emails`).Scan(&emails)
+	db.Pool.QueryRow(ctx, `SELECT last_email_received FROM users WHERE id = $1`, userID).Scan(&cursor)
+	if emails != 2 {
+		t.Errorf("%d emails left, want the linked and the recent one", emails)
+	}
+	if !cursor.Equal(now.Add(-3 * time.Hour).Truncate(time.Microsecond)) {
+		t.Errorf("cursor = %v, want the latest stored email's received_at", cursor)
+	}
+
+	opts.Repair = false
+	if report, err = Run(ctx, opts); err != nil || report.Problems() != 0 {
+		t.Errorf("after repair: %d problems, %v", report.Problems(), err)
+	}
+}
@@ -0,0 +1,371 @@
+// Package jobs tracks long-running operations (bulk operations, purges, exports) in the jobs
+// table: status, progress, ETA and cancellation, readable and cancellable from any instance or
+// from the CLI
+package jobs
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"sync"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/jackc/pgx/v5"
+	"github.com/stoik/vigil/services/discovery-service/internal/db"
+)
+
+// Job statuses
+const (
+	StatusQueued      = "queued"
+	StatusRunning     = "running"
+	StatusDone        = "done"        // Every item was attempted; see failed and errors
+	StatusFailed      = "failed"      // Stopped before attempting every item; see error
+	StatusCancelled   = "cancelled"   // Cancelled by an operator
+	StatusInterrupted = "interrupted" // The process running it stopped
+)
+
+const (
+	MaxErrors    = 100 // Item errors kept per job
+	DefaultLimit = 50
+	MaxLimit     = 500
+
+	Heartbeat  = 5 * time.Second // How often progress is saved and cancellation checked
+	StaleAfter = 2 * time.Minute // A job not saved for this long was interrupted
+)
+
+var (
+	// ErrNotFound is returned when a job ID is unknown
+	ErrNotFound = errors.New("job not found")
+	// ErrFinished is returned when cancelling a job that is no longer queued or running
+	ErrFinished = errors.New("job already finished")
+	// ErrCancelled is returned by a job's work once an operator cancelled it
+	ErrCancelled = errors.New("job cancelled")
+)
+
+// Error is the failure of one item of a job
+type Error struct {
+	Item  string `json:"item"` // User, email, table...
+	Error string `json:"error"`
+}
+
+// Job is a long-running operation and its progress
+type Job struct {
+	ID                uuid.UUID       `json:"id"`
+	Operation         string          `json:"operation"`
+	Request           json.RawMessage `json:"request"`
+	Status            string          `json:"status"`
+	CreatedBy         string          `json:"created_by,omitempty"`
+	CreatedAt         time.Time       `json:"created_at"`
+	StartedAt         *time.Time      `json:"started_at,omitempty"`
+	FinishedAt        *time.Time      `json:"finished_at,omitempty"`
+	CancelRequestedAt *time.Time      `json:"cancel_requested_at,omitempty"`
+	Total             *int            `json:"total,omitempty"` // Items to process, once known
+	Processed         int             `json:"processed"`
+	Succeeded         int             `json:"succeeded"`
+	Skipped           int             `json:"skipped"` // Nothing to do (already paused, fingerprint unchanged...)
+	Failed            int             `json:"failed"`
+	Errors            []Error         `json:"errors"` // First MaxErrors item failures
+	Error             string          `json:"error,omitempty"`
+	// Estimated completion of a running job, from its rate so far
+	ETA *time.Time `json:"eta,omitempty"`
+}
+
+// Run is a job created by this process, and the progress recorded by its work
+// Its methods are safe for concurrent use; the progress methods are no-ops on a nil Run.
+type Run struct {
+	Job
+	mu sync.Mutex
+}
+
+// Item records the outcome of one item
+func (r *Run) Item(name string, skipped bool, err error) {
+	if r == nil {
+		return
+	}
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.Processed++
+	switch {
+	case err != nil:
+		r.Failed++
+		if len(r.Errors) < MaxErrors {
+			r.Errors = append(r.Errors, Error{Item: name, Error: err.Error()})
+		}
+	case skipped:
+		r.Skipped++
+	default:
+		r.Succeeded++
+	}
+}
+
+// SetTotal records the number of items, once known
+func (r *Run) SetTotal(total int) {
+	if r == nil {
+		return
+	}
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.Total = &total
+}
+
+// Snapshot returns a copy of the job and its progress
+func (r *Run) Snapshot() Job {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	job := r.Job
+	job.Errors = append([]Error{}, r.Errors...)
+	return job
+}
+
+// Create records a queued job; request is marshalled to JSON
+func Create(ctx context.Context, operation string, request any, createdBy string) (*Run, error) {
+	raw, err := json.Marshal(request)
+	if err != nil {
+		return nil, fmt.Errorf("failed to marshal job request: %w", err)
+	}
+
+	run := &Run{}
+	run.ID, run.Operation, run.Request, run.Status, run.CreatedBy = uuid.New(), operation, raw, StatusQueued, createdBy
+	run.Errors = []Error{}
+	if err := db.Pool.QueryRow(ctx, `
+		INSERT INTO jobs (id, operation, request, status, created_by) VALUES ($1, $2, $3, $4, NULLIF($5, ''))
+		RETURNING created_at`,
+		run.ID, run.Operation, raw, run.Status, createdBy,
+	).Scan(&run.CreatedAt); err != nil {
+		return nil, fmt.Errorf("failed to create job: %w", err)
+	}
+	return run, nil
+}
+
+// Do creates a job and executes it in this process, for commands run in the foreground
+func Do(ctx context.Context, operation string, request any, createdBy string, fn func(context.Context, *Run) error) (*Run, error) {
+	run, err := Create(ctx, operation, request, createdBy)
+	if err != nil {
+		return nil, err
+	}
+	return run, run.Execute(ctx, fn)
+}
+
+// Execute runs fn as the queued job and records its outcome
+// fn's context is cancelled with cause ErrCancelled when an operator cancels the job; progress is
+// saved every Heartbeat meanwhile. When ctx is cancelled (shutdown) the outcome is not recorded,
+// and the job is reported as interrupted once stale. Returns ErrCancelled if the job was
+// cancelled, fn's error otherwise.
+func (r *Run) Execute(ctx context.Context, fn func(context.Context, *Run) error) error {
+	var startedAt time.Time
+	err := db.Pool.QueryRow(ctx, `
+		UPDATE jobs SET status = $2, started_at = NOW(), updated_at = NOW()
+		WHERE id = $1 AND status = $3
+		RETURNING started_at`,
+		r.ID, StatusRunning, StatusQueued,
+	).Scan(&startedAt)
+	if errors.Is(err, pgx.ErrNoRows) {
+		return ErrCancelled // Cancelled while queued
+	}
+	if err != nil {
+		return fmt.Errorf("failed to start job: %w", err)
+	}
+	r.mu.Lock()
+	r.Status, r.StartedAt = StatusRunning, &startedAt
+	r.mu.Unlock()
+
+	jobCtx, cancel := context.WithCancelCause(ctx)
+	defer cancel(nil)
+	stop := make(chan struct{})
+	var wg sync.WaitGroup
+	wg.Add(1)
+	go func() {
+		defer wg.Done()
+		r.heartbeat(jobCtx, cancel, stop)
+	}()
+
+	err = fn(jobCtx, r)
+	close(stop)
+	wg.Wait()
+	if ctx.Err() != nil {
+		return err
+	}
+	if errors.Is(context.Cause(jobCtx), ErrCancelled) {
+		err = ErrCancelled
+	}
+	if saveErr := r.Finish(context.WithoutCancel(ctx), err); saveErr != nil {
+		log.Printf("Error saving job %s: %v", r.ID, saveErr)
+	}
+
+	job := r.Snapshot()
+	log.Printf("📋 Job %s %s: %s, %d succeeded, %d skipped, %d failed in %v", job.ID, job.Status, job.Operation,
+		job.Succeeded, job.Skipped, job.Failed, job.FinishedAt.Sub(*job.StartedAt).Round(time.Millisecond))
+	return err
+}
+
+// heartbeat saves progress until stop is closed, and cancels the job once cancellation is requested
+func (r *Run) heartbeat(ctx context.Context, cancel context.CancelCauseFunc, stop <-chan struct{}) {
+	ticker := time.NewTicker(Heartbeat)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-stop:
+			return
+		case <-ticker.C:
+		}
+		cancelled, err := r.Save(ctx)
+		if err != nil {
+			if ctx.Err() == nil {
+				log.Printf("Error saving progress of job %s: %v", r.ID, err)
+			}
+			continue
+		}
+		if cancelled {
+			log.Printf("📋 Job %s cancellation requested, stopping", r.ID)
+			cancel(ErrCancelled)
+		}
+	}
+}
+
+// Finish records the outcome of a job: done, failed with err, or cancelled
+func (r *Run) Finish(ctx context.Context, err error) error {
+	now := time.Now()
+	r.mu.Lock()
+	from := r.Status
+	r.Status, r.FinishedAt = StatusDone, &now
+	switch {
+	case errors.Is(err, ErrCancelled):
+		r.Status = StatusCancelled
+	case err != nil:
+		r.Status, r.Error = StatusFailed, err.Error()
+	}
+	r.mu.Unlock()
+	_, saveErr := r.save(ctx, from)
+	return saveErr
+}
+
+// Save writes the job's progress, keeping a queued job alive until it runs
+// Reports whether cancellation was requested.
+func (r *Run) Save(ctx context.Context) (bool, error) {
+	r.mu.Lock()
+	status := r.Status
+	r.mu.Unlock()
+	return r.save(ctx, status)
+}
+
+// save writes the job's status and progress if its row is still in status from, so a queued
+// job cancelled meanwhile stays cancelled; a row deleted meanwhile (tenant purge) is ignored
+func (r *Run) save(ctx context.Context, from string) (bool, error) {
+	r.mu.Lock()
+	errs, err := json.Marshal(r.Errors)
+	if err != nil {
+		r.mu.Unlock()
+		return false, err
+	}
+	args := []any{r.ID, from, r.Status, r.FinishedAt, r.Total, r.Processed, r.Succeeded, r.Skipped, r.Failed, errs, r.Error}
+	r.mu.Unlock()
+
+	var cancelled bool
+	err = db.Pool.QueryRow(ctx, `
+		UPDATE jobs SET status = $3, finished_at = $4, total = $5, processed = $6, succeeded = $7, skipped = $8,
+			failed = $9, errors = $10, error = NULLIF($11, ''), updated_at = NOW()
+		WHERE id = $1 AND status = $2
+		RETURNING cancel_requested_at IS NOT NULL`,
+		args...,
+	).Scan(&cancelled)
+	if errors.Is(err, pgx.ErrNoRows) {
+		return false, nil
+	}
+	return cancelled, err
+}
+
+// Cancel cancels a job: a queued job right away, a running one at its next heartbeat
+// Returns ErrFinished for a job that is no longer queued or running, including an interrupted one.
+func Cancel(ctx context.Context, id uuid.UUID) (Job, error) {
+	job, err := scan(db.Pool.QueryRow(ctx, `
+		UPDATE jobs SET cancel_requested_at = COALESCE(cancel_requested_at, NOW()),
+			status = CASE WHEN status = $2 THEN $4 ELSE status END,
+			finished_at = CASE WHEN status = $2 THEN NOW() ELSE finished_at END
+		WHERE id = $1 AND status IN ($2, $3) AND updated_at >= NOW() - make_interval(secs => $5)
+		RETURNING `+columns,
+		id, StatusQueued, StatusRunning, StatusCancelled, StaleAfter.Seconds(),
+	))
+	if errors.Is(err, pgx.ErrNoRows) {
+		if job, err := Get(ctx, id); err != nil {
+			return job, err
+		}
+		return job, ErrFinished
+	}
+	if err != nil {
+		return job, fmt.Errorf("failed to cancel job: %w", err)
+	}
+	return job, nil
+}
+
+const columns = `id, operation, request, status, COALESCE(created_by, ''), created_at, started_at, finished_at,
+	cancel_requested_at, total, processed, succeeded, skipped, failed, COALESCE(errors, '[]'), COALESCE(error, ''), updated_at`
+
+func scan(row pgx.Row) (Job, error) {
+	var j Job
+	var errs []byte
+	var updatedAt time.Time
+	if err := row.Scan(&j.ID, &j.Operation, &j.Request, &j.Status, &j.CreatedBy, &j.CreatedAt, &j.StartedAt, &j.FinishedAt,
+		&j.CancelRequestedAt, &j.Total, &j.Processed, &j.Succeeded, &j.Skipped, &j.Failed, &errs, &j.Error, &updatedAt); err != nil {
+		return j, err
+	}
+	if err := json.Unmarshal(errs, &j.Errors); err != nil {
+		return j, fmt.Errorf("invalid job errors: %w", err)
+	}
+	switch {
+	case (j.Status == StatusQueued || j.Status == StatusRunning) && time.Since(updatedAt) > StaleAfter:
+		j.Status = StatusInterrupted
+	case j.Status == StatusRunning && j.StartedAt != nil && j.Total != nil:
+		j.ETA = estimate(*j.StartedAt, updatedAt, j.Processed, *j.Total)
+	}
+	return j, nil
+}
+
+// estimate extrapolates the completion of a job that processed items of total between
+// startedAt and at; nil until an item is processed
+func estimate(startedAt, at time.Time, processed, total int) *time.Time {
+	if processed <= 0 || !at.After(startedAt) {
+		return nil
+	}
+	remaining := max(total-processed, 0)
+	eta := at.Add(time.Duration(float64(at.Sub(startedAt)) * float64(remaining) / float64(processed)))
+	return &eta
+}
+
+// Get returns a job and its progress
+func Get(ctx context.Context, id uuid.UUID) (Job, error) {
+	j, err := scan(db.ReadPool.QueryRow(ctx, `SELECT `+columns+` FROM jobs WHERE id = $1`, id))
+	if errors.Is(err, pgx.ErrNoRows) {
+		return j, ErrNotFound
+	}
+	if err != nil {
+		return j, fmt.Errorf("failed to get job: %w", err)
+	}
+	return j, nil
+}
+
+// List returns jobs, most recent first
+func List(ctx context.Context, limit int) ([]Job, error) {
+	if limit <= 0 {
+		limit = DefaultLimit
+	}
+	limit = min(limit, MaxLimit)
+
+	rows, err := db.ReadPool.Query(ctx, `SELECT `+columns+` FROM jobs ORDER BY created_at DESC, id LIMIT $1`, limit)
+	if err != nil {
+		return nil, fmt.Errorf("failed to list jobs: %w", err)
+	}
+	defer rows.Close()
+
+	jobs := []Job{}
+	for rows.Next() {
+		j, err := scan(rows)
+		if err != nil {
+			return nil, fmt.Errorf("failed to scan job: %w", err)
+		}
+		jobs = append(jobs, j)
+	}
+	return jobs, rows.Err()
+}
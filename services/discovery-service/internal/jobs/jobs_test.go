@@ -0,0 +1,117 @@
+package jobs
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/stoik/vigil/services/discovery-service/internal/db/dbtest"
+)
+
+func TestRunItem(t *testing.T) {
+	var nilRun *Run
+	nilRun.Item("a", false, nil) // Optional jobs are nil
+	nilRun.SetTotal(1)
+
+	run := &Run{}
+	run.SetTotal(MaxErrors + 3)
+	run.Item("a", false, nil)
+	run.Item("b", true, nil)
+	for i := 0; i < MaxErrors+1; i++ {
+		run.Item("c", false, errors.New("boom"))
+	}
+	job := run.Snapshot()
+	if job.Processed != MaxErrors+3 || job.Succeeded != 1 || job.Skipped != 1 || job.Failed != MaxErrors+1 || len(job.Errors) != MaxErrors {
+		t.Errorf("progress = %+v", job)
+	}
+}
+
+func TestEstimate(t *testing.T) {
+	start := time.Date(2024, 3, 1, 12, 0, 0, 0, time.UTC)
+	if eta := estimate(start, start.Add(time.Minute), 0, 100); eta != nil {
+		t.Errorf("ETA before any item = %v, want none", eta)
+	}
+	// 25 items in 1 minute: the remaining 75 take 3 more
+	if eta := estimate(start, start.Add(time.Minute), 25, 100); eta == nil || !eta.Equal(start.Add(4*time.Minute)) {
+		t.Errorf("ETA = %v, want 12:04", eta)
+	}
+	// More items than the total (emails added to a range meanwhile)
+	if eta := estimate(start, start.Add(time.Minute), 120, 100); eta == nil || !eta.Equal(start.Add(time.Minute)) {
+		t.Errorf("ETA past the total = %v, want now", eta)
+	}
+}
+
+// setupTestDB resets the jobs table (see dbtest.Open)
+func setupTestDB(t testing.TB) context.Context {
+	return dbtest.Open(t, "jobs")
+}
+
+func TestExecute(t *testing.T) {
+	ctx := setupTestDB(t)
+
+	run, err := Do(ctx, "export", map[string]string{"user": "alice@acme.com"}, "ops", func(ctx context.Context, run *Run) error {
+		run.SetTotal(2)
+		run.Item("a", false, nil)
+		run.Item("b", false, errors.New("boom"))
+		return nil
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	job, err := Get(ctx, run.ID)
+	if err != nil || job.Status != StatusDone || job.Processed != 2 || job.Failed != 1 || job.Errors[0].Item != "b" ||
+		job.CreatedBy != "ops" || string(job.Request) != `{"user": "alice@acme.com"}` {
+		t.Errorf("Get() = %+v, %v", job, err)
+	}
+	if _, err := Cancel(ctx, run.ID); !errors.Is(err, ErrFinished) {
+		t.Errorf("Cancel() of a finished job = %v, want ErrFinished", err)
+	}
+
+	failed, err := Do(ctx, "purge", nil, "", func(ctx context.Context, run *Run) error { return errors.New("db down") })
+	if job, _ := Get(ctx, failed.ID); err == nil || job.Status != StatusFailed || job.Error != "db down" {
+		t.Errorf("failed job = %+v", job)
+	}
+}
+
+func TestCancel(t *testing.T) {
+	ctx := setupTestDB(t)
+
+	// Queued: cancelled right away, and never runs
+	queued, err := Create(ctx, "refingerprint", nil, "ops")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if job, err := Cancel(ctx, queued.ID); err != nil || job.Status != StatusCancelled {
+		t.Fatalf("Cancel() of a queued job = %+v, %v", job, err)
+	}
+	if _, err := queued.Save(ctx); err != nil {
+		t.Fatal(err)
+	}
+	ran := false
+	if err := queued.Execute(ctx, func(context.Context, *Run) error { ran = true; return nil }); !errors.Is(err, ErrCancelled) || ran {
+		t.Errorf("Execute() of a cancelled job = %v, ran %v", err, ran)
+	}
+	if job, _ := Get(ctx, queued.ID); job.Status != StatusCancelled {
+		t.Errorf("status after Save = %s, want cancelled", job.Status)
+	}
+
+	// Running: its context is cancelled at the next heartbeat
+	if testing.Short() {
+		t.Skip("waits for a heartbeat")
+	}
+	running, err := Create(ctx, "refingerprint", nil, "ops")
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = running.Execute(ctx, func(ctx context.Context, run *Run) error {
+		if job, err := Cancel(ctx, run.ID); err != nil || job.Status != StatusRunning || job.CancelRequestedAt == nil {
+			t.Errorf("Cancel() of a running job = %+v, %v", job, err)
+		}
+		<-ctx.Done()
+		return ctx.Err()
+	})
+	if job, _ := Get(ctx, running.ID); !errors.Is(err, ErrCancelled) || job.Status != StatusCancelled {
+		t.Errorf("Execute() = %v, status %s; want cancelled", err, job.Status)
+	}
+}
@@ -0,0 +1,163 @@
+// Package logging filters the standard logger by level, adjustable at runtime
+package logging
+
+import (
+	"bytes"
+	"fmt"
+	"io"
+	"log"
+	"strings"
+	"sync/atomic"
+)
+
+// Level is a log verbosity: lines below the current level are dropped
+type Level int32
+
+const (
+	LevelDebug Level = iota
+	LevelInfo
+	LevelWarn
+	LevelError
+)
+
+var levelNames = []string{"debug", "info", "warn", "error"}
+
+func (l Level) String() string {
+	if l < LevelDebug || l > LevelError {
+		return fmt.Sprintf("level(%d)", int32(l))
+	}
+	return levelNames[l]
+}
+
+// ParseLevel parses a level name (empty means info)
+func ParseLevel(name string) (Level, error) {
+	name = strings.ToLower(strings.TrimSpace(name))
+	if name == "" {
+		return LevelInfo, nil
+	}
+	if name == "warning" {
+		return LevelWarn, nil
+	}
+	for i, n := range levelNames {
+		if n == name {
+			return Level(i), nil
+		}
+	}
+	return 0, fmt.Errorf("unknown log level %q (use debug, info, warn or error)", name)
+}
+
+var level atomic.Int32 // Level, info until set
+
+func init() {
+	level.Store(int32(LevelInfo))
+}
+
+// SetLevel changes the level, taking effect on the next line logged
+func SetLevel(l Level) {
+	level.Store(int32(l))
+}
+
+// SetLevelf changes the level and logs an info line about the change before or after it,
+// whichever level shows it
+func SetLevelf(l Level, format string, args ...any) {
+	msg := fmt.Sprintf(format, args...)
+	if l > CurrentLevel() {
+		log.Output(2, msg)
+		SetLevel(l)
+		return
+	}
+	SetLevel(l)
+	log.Output(2, msg)
+}
+
+// CurrentLevel returns the level in force
+func CurrentLevel() Level {
+	return Level(level.Load())
+}
+
+// debugMarker starts the message of lines logged with Debugf
+const debugMarker = "[debug] "
+
+// Debugf logs a line at debug level; arguments are not even formatted above it
+func Debugf(format string, args ...any) {
+	if CurrentLevel() > LevelDebug {
+		return
+	}
+	log.Output(2, debugMarker+fmt.Sprintf(format, args...))
+}
+
+// Install filters the standard logger's output by level before writing it to w
+//
+// The service logs through the standard logger without levels, so a line's level is told by how
+// its message starts: errors ("Error ...", "Failed ...", ❌ ✗), warnings ("Warning ...",
+// "Invalid ...", "Ignoring ...", "Unknown ...", 🚨), debug lines from Debugf, and info for the
+// rest (progress, 📊 metrics). Errors are never dropped.
+func Install(w io.Writer) {
+	log.SetOutput(&filter{w: w})
+}
+
+type filter struct {
+	w io.Writer
+}
+
+func (f *filter) Write(p []byte) (int, error) {
+	if lineLevel(message(p, log.Flags())) < CurrentLevel() {
+		return len(p), nil
+	}
+	return f.w.Write(p)
+}
+
+var (
+	errorPrefixes = []string{"Error", "Failed", "❌", "✗"}
+	warnPrefixes  = []string{"Warning", "Invalid", "Ignoring", "Unknown", "🚨"}
+)
+
+// lineLevel classifies a log message
+func lineLevel(msg []byte) Level {
+	switch {
+	case bytes.HasPrefix(msg, []byte(debugMarker)):
+		return LevelDebug
+	case hasAnyPrefix(msg, errorPrefixes):
+		return LevelError
+	case hasAnyPrefix(msg, warnPrefixes):
+		return LevelWarn
+	}
+	return LevelInfo
+}
+
+func hasAnyPrefix(msg []byte, prefixes []string) bool {
+	for _, prefix := range prefixes {
+		if bytes.HasPrefix(msg, []byte(prefix)) {
+			return true
+		}
+	}
+	return false
+}
+
+// message strips the header the standard logger writes with flags (date, time, prefix)
+func message(line []byte, flags int) []byte {
+	if flags&log.Lmsgprefix == 0 {
+		line = bytes.TrimPrefix(line, []byte(log.Prefix()))
+	}
+	n := 0
+	if flags&log.Ldate != 0 {
+		n += len("2006/01/02 ")
+	}
+	if flags&(log.Ltime|log.Lmicroseconds) != 0 {
+		n += len("15:04:05 ")
+		if flags&log.Lmicroseconds != 0 {
+			n += len(".000000")
+		}
+	}
+	if flags&(log.Lshortfile|log.Llongfile) != 0 {
+		// file:line: message
+		if i := bytes.Index(line[min(n, len(line)):], []byte(": ")); i >= 0 {
+			n += i + 2
+		}
+	}
+	line = line[min(n, len(line)):]
+	if flags&log.Lmsgprefix != 0 {
+		line = bytes.TrimPrefix(line, []byte(log.Prefix()))
+	}
+	return line
+}
@@ -0,0 +1,70 @@
+package logging
+
+import (
+	"bytes"
+	"log"
+	"os"
+	"strings"
+	"testing"
+)
+
+func TestParseLevel(t *testing.T) {
+	for name, want := range map[string]Level{"": LevelInfo, "debug": LevelDebug, "INFO": LevelInfo, "warning": LevelWarn, "error": LevelError} {
+		if got, err := ParseLevel(name); err != nil || got != want {
+			t.Errorf("ParseLevel(%q) = %v, %v; want %v", name, got, err, want)
+		}
+	}
+	if _, err := ParseLevel("trace"); err == nil {
+		t.Error("ParseLevel(trace) succeeded")
+	}
+}
+
+func TestFilter(t *testing.T) {
+	var out bytes.Buffer
+	flags := log.Flags()
+	t.Cleanup(func() {
+		log.SetOutput(os.Stderr)
+		log.SetFlags(flags)
+		SetLevel(LevelInfo)
+	})
+	Install(&out)
+
+	logAll := func() string {
+		out.Reset()
+		Debugf("polled %d emails", 3)
+		log.Printf("📊 Metrics | Discovered: %d", 10)
+		log.Printf("🚨 SLO breach")
+		log.Printf("Error storing email: %v", "boom")
+		return out.String()
+	}
+
+	for _, flags := range []int{log.LstdFlags, log.LstdFlags | log.Lmicroseconds | log.Lshortfile, 0} {
+		log.SetFlags(flags)
+		for level, want := range map[Level][]string{
+			LevelDebug: {"[debug] polled 3 emails", "📊", "🚨", "Error"},
+			LevelInfo:  {"📊", "🚨", "Error"},
+			LevelWarn:  {"🚨", "Error"},
+			LevelError: {"Error"},
+		} {
+			SetLevel(level)
+			got := logAll()
+			if lines := strings.Count(got, "\n"); lines != len(want) {
+				t.Errorf("flags %d, level %v: %d lines, want %d:\n%s", flags, level, lines, len(want), got)
+			}
+			for _, s := range want {
+				if !strings.Contains(got, s) {
+					t.Errorf("flags %d, level %v: %q missing:\n%s", flags, level, s, got)
+				}
+			}
+		}
+	}
+
+	// A change is logged whichever way it goes
+	SetLevel(LevelInfo)
+	out.Reset()
+	SetLevelf(LevelError, "quieter")
+	SetLevelf(LevelInfo, "louder")
+	if got := out.String(); !strings.Contains(got, "quieter") || !strings.Contains(got, "louder") {
+		t.Errorf("level changes logged %q, want both", got)
+	}
+}
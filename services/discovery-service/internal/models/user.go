@@ -12,5 +12,6 @@ type User struct {
 	Email            string     `db:"email"`
 	LastEmailCheck   *time.Time `db:"last_email_check"`
 	LastEmailReceived *time.Time `db:"last_email_received"`
+	PausedAt         *time.Time `db:"paused_at"` // Set while an operator has paused polling
 }
 
@@ -0,0 +1,183 @@
+package privacy
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/jackc/pgx/v5"
+	"github.com/stoik/vigil/services/discovery-service/internal/db"
+	"github.com/stoik/vigil/services/discovery-service/internal/events"
+)
+
+// ErrUserNotFound is returned when no user matches an export request
+var ErrUserNotFound = errors.New("user not found")
+
+// SubjectExport is everything vigil holds about a mailbox user (data-subject access request)
+// Email bodies are never stored, so none are included
+type SubjectExport struct {
+	GeneratedAt  time.Time      `json:"generated_at"`
+	User         ExportedUser   `json:"user"`
+	Emails       []ExportedMail `json:"emails"`
+	Detections   []Detection    `json:"detections"`
+	Reports      []Report       `json:"reports"` // Emails the user reported
+	Events       []events.Event `json:"events"`
+	AuditEntries []AuditEntry   `json:"audit_entries"` // Accesses to the user and their emails
+}
+
+// ExportedUser is the user row
+type ExportedUser struct {
+	ID                uuid.UUID  `json:"id"`
+	Email             string     `json:"email"`
+	LastEmailCheck    *time.Time `json:"last_email_check,omitempty"`
+	LastEmailReceived *time.Time `json:"last_email_received,omitempty"`
+}
+
+// ExportedMail is the stored metadata of one of the user's emails
+type ExportedMail struct {
+	ID           uuid.UUID  `json:"id"`
+	Fingerprint  string     `json:"fingerprint"`
+	ReceivedAt   time.Time  `json:"received_at"`
+	SenderDomain *string    `json:"sender_domain,omitempty"`
+	Subject      *string    `json:"subject,omitempty"` // Only stored with search.store_text
+	Snippet      *string    `json:"snippet,omitempty"`
+	SubjectHash  *string    `json:"subject_hash,omitempty"` // Only stored with storage.metadata
+	SizeBytes    *int       `json:"size_bytes,omitempty"`
+	DetectedAt   *time.Time `json:"detected_at,omitempty"`
+	RemediatedAt *time.Time `json:"remediated_at,omitempty"` // When the user's copy was remediated
+	Remediation  *string    `json:"remediation,omitempty"`
+}
+
+// Detection is an email of the user flagged by analysis
+type Detection struct {
+	EmailID    uuid.UUID `json:"email_id"`
+	DetectedAt time.Time `json:"detected_at"`
+}
+
+// Report is a reports row: an email the user submitted as suspicious
+type Report struct {
+	ID                uuid.UUID `json:"id"`
+	ReportedAt        time.Time `json:"reported_at"`
+	EmailID           uuid.UUID `json:"email_id"`
+	Source            *string   `json:"source,omitempty"`
+	Comment           *string   `json:"comment,omitempty"`
+	AlreadyDiscovered bool      `json:"already_discovered"`
+}
+
+// AuditEntry is an audit_log row
+type AuditEntry struct {
+	ID      int64     `json:"id"`
+	At      time.Time `json:"at"`
+	Actor   string    `json:"actor"`
+	Action  string    `json:"action"`
+	Target  string    `json:"target"`
+	Outcome string    `json:"outcome"`
+}
+
+// ExportUser collects the data held about a user, identified by ID or email address
+func ExportUser(ctx context.Context, user string) (SubjectExport, error) {
+	export := SubjectExport{
+		GeneratedAt:  time.Now(),
+		Emails:       []ExportedMail{},
+		Detections:   []Detection{},
+		Reports:      []Report{},
+		AuditEntries: []AuditEntry{},
+	}
+
+	query := `SELECT id, email, last_email_check, last_email_received FROM users WHERE email = $1`
+	var arg any = user
+	if id, err := uuid.Parse(user); err == nil {
+		query = `SELECT id, email, last_email_check, last_email_received FROM users WHERE id = $1`
+		arg = id
+	}
+	u := &export.User
+	err := db.ReadPool.QueryRow(ctx, query, arg).Scan(&u.ID, &u.Email, &u.LastEmailCheck, &u.LastEmailReceived)
+	if errors.Is(err, pgx.ErrNoRows) {
+		return export, ErrUserNotFound
+	}
+	if err != nil {
+		return export, fmt.Errorf("failed to get user: %w", err)
+	}
+
+	rows, err := db.ReadPool.Query(ctx, `
+		SELECT e.id, e.fingerprint, e.received_at, e.sender_domain, e.subject, e.snippet, e.subject_hash, e.size_bytes, e.detected_at, ue.remediated_at, ue.remediation
+		FROM emails e
+		JOIN user_emails ue ON ue.email_id = e.id
+		WHERE ue.user_id = $1
+		ORDER BY e.received_at, e.id`,
+		u.ID,
+	)
+	if err != nil {
+		return export, fmt.Errorf("failed to list user emails: %w", err)
+	}
+	targets := []string{u.ID.String(), u.Email}
+	for rows.Next() {
+		var m ExportedMail
+		if err := rows.Scan(&m.ID, &m.Fingerprint, &m.ReceivedAt, &m.SenderDomain, &m.Subject, &m.Snippet, &m.SubjectHash, &m.SizeBytes, &m.DetectedAt, &m.RemediatedAt, &m.Remediation); err != nil {
+			rows.Close()
+			return export, fmt.Errorf("failed to scan email: %w", err)
+		}
+		export.Emails = append(export.Emails, m)
+		if m.DetectedAt != nil {
+			export.Detections = append(export.Detections, Detection{EmailID: m.ID, DetectedAt: *m.DetectedAt})
+		}
+		targets = append(targets, m.ID.String())
+	}
+	rows.Close()
+	if err := rows.Err(); err != nil {
+		return export, fmt.Errorf("failed to list user emails: %w", err)
+	}
+
+	rows, err = db.ReadPool.Query(ctx, `
+		SELECT id, reported_at, email_id, source, comment, already_discovered
+		FROM reports
+		WHERE user_id = $1
+		ORDER BY reported_at, id`,
+		u.ID,
+	)
+	if err != nil {
+		return export, fmt.Errorf("failed to list reports: %w", err)
+	}
+	for rows.Next() {
+		var r Report
+		if err := rows.Scan(&r.ID, &r.ReportedAt, &r.EmailID, &r.Source, &r.Comment, &r.AlreadyDiscovered); err != nil {
+			rows.Close()
+			return export, fmt.Errorf("failed to scan report: %w", err)
+		}
+		export.Reports = append(export.Reports, r)
+	}
+	rows.Close()
+	if err := rows.Err(); err != nil {
+		return export, fmt.Errorf("failed to list reports: %w", err)
+	}
+
+	if export.Events, err = events.ForUser(ctx, u.ID); err != nil {
+		return export, err
+	}
+
+	// Audit targets are user IDs, addresses and email IDs (e.g. email.content)
+	rows, err = db.ReadPool.Query(ctx, `
+		SELECT id, at, actor, action, target, outcome
+		FROM audit_log
+		WHERE target = ANY($1)
+		ORDER BY id`,
+		targets,
+	)
+	if err != nil {
+		return export, fmt.Errorf("failed to list audit entries: %w", err)
+	}
+	defer rows.Close()
+	for rows.Next() {
+		var a AuditEntry
+		if err := rows.Scan(&a.ID, &a.At, &a.Actor, &a.Action, &a.Target, &a.Outcome); err != nil {
+			return export, fmt.Errorf("failed to scan audit entry: %w", err)
+		}
+		export.AuditEntries = append(export.AuditEntries, a)
+	}
+	if err := rows.Err(); err != nil {
+		return export, fmt.Errorf("failed to list audit entries: %w", err)
+	}
+	return export, nil
+}
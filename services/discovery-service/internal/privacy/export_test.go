@@ -0,0 +1,48 @@
+package privacy
+
+import (
+	"testing"
+
+	"github.com/google/uuid"
+	"github.com/stoik/vigil/services/discovery-service/internal/db"
+)
+
+func TestExportUser(t *testing.T) {
+	ctx := setupTestDB(t)
+
+	exec := func(query string, args ...any) {
+		t.Helper()
+		if _, err := db.Pool.Exec(ctx, query, args...); err != nil {
+			t.Fatalf("%s: %v", query, err)
+		}
+	}
+	userID, otherID := uuid.New(), uuid.New()
+	exec(`INSERT INTO users (id, email) VALUES ($1, 'jane@example.com'), ($2, 'john@example.com')`, userID, otherID)
+	for i, owner := range []uuid.UUID{userID, userID, otherID} {
+		emailID := uuid.New()
+		exec(`INSERT INTO emails (id, fingerprint, received_at, detected_at) VALUES ($1, $2, NOW(), CASE WHEN $3 THEN NOW() END)`,
+			emailID, emailID.String(), i == 0)
+		exec(`INSERT INTO user_emails (user_id, email_id) VALUES ($1, $2)`, owner, emailID)
+		exec(`INSERT INTO events (type, user_id, email_id) VALUES ('email.discovered', $1, $2)`, owner, emailID)
+		exec(`INSERT INTO audit_log (actor, action, target, outcome) VALUES ('alice', 'email.content', $1, 'success')`, emailID.String())
+	}
+
+	// By address and by ID
+	for _, user := range []string{"jane@example.com", userID.String()} {
+		export, err := ExportUser(ctx, user)
+		if err != nil {
+			t.Fatalf("ExportUser(%q): %v", user, err)
+		}
+		if export.User.ID != userID {
+			t.Errorf("exported user %s, want %s", export.User.ID, userID)
+		}
+		if len(export.Emails) != 2 || len(export.Detections) != 1 || len(export.Events) != 2 || len(export.AuditEntries) != 2 {
+			t.Errorf("exported %d emails, %d detections, %d events, %d audit entries; want 2, 1, 2, 2",
+				len(export.Emails), len(export.Detections), len(export.Events), len(export.AuditEntries))
+		}
+	}
+
+	if _, err := ExportUser(ctx, "nobody@example.com"); err != ErrUserNotFound {
+		t.Errorf("ExportUser(unknown) = %v, want ErrUserNotFound", err)
+	}
+}
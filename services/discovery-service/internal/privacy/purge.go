@@ -0,0 +1,175 @@
+// Package privacy implements tenant offboarding and data-subject requests
+package privacy
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/jackc/pgx/v5"
+	"github.com/stoik/vigil/services/discovery-service/internal/db"
+	"github.com/stoik/vigil/services/discovery-service/internal/jobs"
+)
+
+const DefaultBatchSize = 1000 // Rows deleted per statement, keeps locks and WAL bursts short
+
+// AuditMode selects what a purge does with audit_log entries
+type AuditMode string
+
+const (
+	AuditDelete    AuditMode = "delete"    // Delete audit entries
+	AuditAnonymize AuditMode = "anonymize" // Keep entries (action, time, outcome), redact actor and target
+)
+
+// Redacted replaces personal data in anonymized rows
+const Redacted = "redacted"
+
+// ParseAuditMode parses a --audit value (empty means delete)
+func ParseAuditMode(value string) (AuditMode, error) {
+	switch AuditMode(value) {
+	case "", AuditDelete:
+		return AuditDelete, nil
+	case AuditAnonymize:
+		return AuditAnonymize, nil
+	default:
+		return "", fmt.Errorf("unknown audit mode %q (use %q or %q)", value, AuditDelete, AuditAnonymize)
+	}
+}
+
+// PurgeOptions configures a tenant purge
+type PurgeOptions struct {
+	BatchSize int
+	Audit     AuditMode
+	Job       *jobs.Run // Optional, records one item per table
+}
+
+// PurgeReport is the deletion report of a tenant purge
+type PurgeReport struct {
+	TenantID   uuid.UUID        `json:"tenant_id"`
+	StartedAt  time.Time        `json:"started_at"`
+	FinishedAt time.Time        `json:"finished_at"`
+	Audit      AuditMode        `json:"audit"`
+	Deleted    map[string]int64 `json:"deleted"`              // Rows deleted per table
+	Anonymized map[string]int64 `json:"anonymized,omitempty"` // Rows redacted in place per table
+	Detections int64            `json:"detections"`           // Detected emails among the deleted emails
+}
+
+// ErrTenantNotFound is returned when the tenant row does not exist
+var ErrTenantNotFound = errors.New("tenant not found")
+
+// MarkOffboarded stops monitoring the tenant: running instances notice on their next
+// user discovery cycle and shut down, and new instances refuse to start
+func MarkOffboarded(ctx context.Context, tenantID uuid.UUID) error {
+	tag, err := db.Pool.Exec(ctx,
+		`UPDATE tenant SET offboarded_at = COALESCE(offboarded_at, NOW()) WHERE id = $1`,
+		tenantID,
+	)
+	if err != nil {
+		return fmt.Errorf("failed to mark tenant offboarded: %w", err)
+	}
+	if tag.RowsAffected() == 0 {
+		return ErrTenantNotFound
+	}
+	return nil
+}
+
+// IsOffboarded reports whether the tenant was offboarded (an unknown tenant is not)
+func IsOffboarded(ctx context.Context, tenantID uuid.UUID) (bool, error) {
+	var offboarded bool
+	err := db.Pool.QueryRow(ctx,
+		`SELECT offboarded_at IS NOT NULL FROM tenant WHERE id = $1`,
+		tenantID,
+	).Scan(&offboarded)
+	if errors.Is(err, pgx.ErrNoRows) {
+		return false, nil
+	}
+	return offboarded, err
+}
+
+// Purge erases the tenant's data in batches: email links, emails (and their detections),
+// events, users, publisher dedup keys, SIEM cursors, digest runs, jobs (the purge's own
+// included) and audit entries. The database holds a single tenant, so every row of the
+// per-tenant tables belongs to it. The tenant row is kept, without its name, so the tenant stays offboarded. Purge is
+// idempotent: an interrupted purge can be re-run and finishes the job.
+func Purge(ctx context.Context, tenantID uuid.UUID, opts PurgeOptions) (PurgeReport, error) {
+	if opts.BatchSize < 1 {
+		opts.BatchSize = DefaultBatchSize
+	}
+	if opts.Audit == "" {
+		opts.Audit = AuditDelete
+	}
+
+	report := PurgeReport{
+		TenantID:   tenantID,
+		StartedAt:  time.Now(),
+		Audit:      opts.Audit,
+		Deleted:    make(map[string]int64),
+		Anonymized: make(map[string]int64),
+	}
+
+	if err := MarkOffboarded(ctx, tenantID); err != nil {
+		return report, err
+	}
+
+	if err := db.Pool.QueryRow(ctx,
+		`SELECT COUNT(*) FROM emails WHERE detected_at IS NOT NULL`,
+	).Scan(&report.Detections); err != nil {
+		return report, fmt.Errorf("failed to count detections: %w", err)
+	}
+
+	// Links first so emails and users are deleted without long cascades
+	steps := []struct {
+		table string
+		query string
+		args  []any
+	}{
+		{"reports", `DELETE FROM reports WHERE id IN (SELECT id FROM reports LIMIT $1)`, nil},
+		{"user_emails", `DELETE FROM user_emails WHERE (user_id, email_id) IN (SELECT user_id, email_id FROM user_emails LIMIT $1)`, nil},
+		{"email_minhash_bands", `DELETE FROM email_minhash_bands WHERE (band_key, email_id) IN (SELECT band_key, email_id FROM email_minhash_bands LIMIT $1)`, nil},
+		{"emails", `DELETE FROM emails WHERE id IN (SELECT id FROM emails LIMIT $1)`, nil},
+		{"campaigns", `DELETE FROM campaigns WHERE id IN (SELECT id FROM campaigns LIMIT $1)`, nil},
+		{"events", `DELETE FROM events WHERE id IN (SELECT id FROM events LIMIT $1)`, nil},
+		{"users", `DELETE FROM users WHERE id IN (SELECT id FROM users LIMIT $1)`, nil},
+		{"queue_dedup", `DELETE FROM queue_dedup WHERE (tenant_id, idempotency_key) IN
+			(SELECT tenant_id, idempotency_key FROM queue_dedup WHERE tenant_id = $2 LIMIT $1)`, []any{tenantID}},
+		{"siem_cursors", `DELETE FROM siem_cursors WHERE name IN (SELECT name FROM siem_cursors LIMIT $1)`, nil},
+		{"digest_runs", `DELETE FROM digest_runs WHERE (period, period_start) IN (SELECT period, period_start FROM digest_runs LIMIT $1)`, nil},
+		{"jobs", `DELETE FROM jobs WHERE id IN (SELECT id FROM jobs LIMIT $1)`, nil},
+	}
+	opts.Job.SetTotal(len(steps) + 1) // And audit_log
+	for _, step := range steps {
+		n, err := db.InBatches(ctx, opts.BatchSize, step.query, step.args...)
+		report.Deleted[step.table] = n
+		opts.Job.Item(step.table, n == 0, err)
+		if err != nil {
+			return report, fmt.Errorf("failed to purge %s: %w", step.table, err)
+		}
+	}
+
+	var err error
+	switch opts.Audit {
+	case AuditAnonymize:
+		report.Anonymized["audit_log"], err = db.InBatches(ctx, opts.BatchSize,
+			`UPDATE audit_log SET actor = $2, target = $2
+			WHERE id IN (SELECT id FROM audit_log WHERE actor <> $2 OR target <> $2 LIMIT $1)`, Redacted)
+	default:
+		report.Deleted["audit_log"], err = db.InBatches(ctx, opts.BatchSize,
+			`DELETE FROM audit_log WHERE id IN (SELECT id FROM audit_log LIMIT $1)`)
+	}
+	opts.Job.Item("audit_log", false, err)
+	if err != nil {
+		return report, fmt.Errorf("failed to purge audit_log: %w", err)
+	}
+
+	if _, err := db.Pool.Exec(ctx,
+		`UPDATE tenant SET name = NULL, purged_at = NOW() WHERE id = $1`,
+		tenantID,
+	); err != nil {
+		return report, fmt.Errorf("failed to mark tenant purged: %w", err)
+	}
+
+	report.FinishedAt = time.Now()
+	return report, nil
+}
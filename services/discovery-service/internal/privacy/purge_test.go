@@ -0,0 +1,101 @@
+package privacy
+
+import (
+	"context"
+	"testing"
+
+	"github.com/google/uuid"
+	"github.com/stoik/vigil/services/discovery-service/internal/db"
+	"github.com/stoik/vigil/services/discovery-service/internal/db/dbtest"
+)
+
+func TestParseAuditMode(t *testing.T) {
+	tests := []struct {
+		value   string
+		want    AuditMode
+		wantErr bool
+	}{
+		{"", AuditDelete, false},
+		{"delete", AuditDelete, false},
+		{"anonymize", AuditAnonymize, false},
+		{"keep", "", true},
+	}
+	for _, tt := range tests {
+		got, err := ParseAuditMode(tt.value)
+		if (err != nil) != tt.wantErr || got != tt.want {
+			t.Errorf("ParseAuditMode(%q) = %q, %v; want %q, error %v", tt.value, got, err, tt.want, tt.wantErr)
+		}
+	}
+}
+
+// setupTestDB resets all tables (see dbtest.Open)
+func setupTestDB(t testing.TB) context.Context {
+	return dbtest.Open(t, "tenant", "user_emails", "emails", "users", "events", "audit_log", "queue_dedup", "siem_cursors", "digest_runs")
+}
+
+func TestPurge(t *testing.T) {
+	ctx := setupTestDB(t)
+	tenantID := uuid.New()
+
+	exec := func(query string, args ...any) {
+		t.Helper()
+		if _, err := db.Pool.Exec(ctx, query, args...); err != nil {
+			t.Fatalf("%s: %v", query, err)
+		}
+	}
+	exec(`INSERT INTO tenant (id, name, provider) VALUES ($1, 'ACME Corp.', 'GA')`, tenantID)
+	for i := 0; i < 5; i++ {
+		userID, emailID := uuid.New(), uuid.New()
+		exec(`INSERT INTO users (id, email) VALUES ($1, $2)`, userID, userID.String()+"@example.com")
+		exec(`INSERT INTO emails (id, fingerprint, received_at, detected_at) VALUES ($1, $2, NOW(), CASE WHEN $3 THEN NOW() END)`,
+			emailID, emailID.String(), i%2 == 0)
+		exec(`INSERT INTO user_emails (user_id, email_id) VALUES ($1, $2)`, userID, emailID)
+		exec(`INSERT INTO events (type, user_id, email_id) VALUES ('email.discovered', $1, $2)`, userID, emailID)
+		exec(`INSERT INTO audit_log (actor, action, target, outcome) VALUES ('alice', 'email.content', $1, 'success')`, emailID.String())
+	}
+
+	// Batches smaller than the data exercise the batch loop
+	report, err := Purge(ctx, tenantID, PurgeOptions{BatchSize: 2, Audit: AuditAnonymize})
+	if err != nil {
+		t.Fatalf("Purge: %v", err)
+	}
+	for _, table := range []string{"user_emails", "emails", "events", "users"} {
+		if report.Deleted[table] != 5 {
+			t.Errorf("deleted %d rows from %s, want 5", report.Deleted[table], table)
+		}
+	}
+	if report.Anonymized["audit_log"] != 5 || report.Detections != 3 {
+		t.Errorf("anonymized %d audit entries, %d detections; want 5, 3", report.Anonymized["audit_log"], report.Detections)
+	}
+
+	var remaining int
+	db.Pool.QueryRow(ctx, `SELECT (SELECT COUNT(*) FROM users) + (SELECT COUNT(*) FROM emails) + (SELECT COUNT(*) FROM events)`).Scan(&remaining)
+	if remaining != 0 {
+		t.Errorf("%d rows remain after purge", remaining)
+	}
+	var personal int
+	db.Pool.QueryRow(ctx, `SELECT COUNT(*) FROM audit_log WHERE actor <> $1 OR target <> $1`, Redacted).Scan(&personal)
+	if personal != 0 {
+		t.Errorf("%d audit entries not anonymized", personal)
+	}
+
+	if offboarded, err := IsOffboarded(ctx, tenantID); err != nil || !offboarded {
+		t.Errorf("IsOffboarded() = %v, %v; want true", offboarded, err)
+	}
+
+	// Re-running a finished purge is a no-op
+	again, err := Purge(ctx, tenantID, PurgeOptions{BatchSize: 2, Audit: AuditDelete})
+	if err != nil {
+		t.Fatalf("second Purge: %v", err)
+	}
+	if again.Deleted["users"] != 0 || again.Deleted["emails"] != 0 {
+		t.Errorf("second purge deleted rows: %+v", again.Deleted)
+	}
+}
+
+func TestMarkOffboardedUnknownTenant(t *testing.T) {
+	ctx := setupTestDB(t)
+	if err := MarkOffboarded(ctx, uuid.New()); err != ErrTenantNotFound {
+		t.Errorf("MarkOffboarded() = %v, want ErrTenantNotFound", err)
+	}
+}
@@ -0,0 +1,120 @@
+package privacy
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"time"
+
+	"github.com/spf13/viper"
+	"github.com/stoik/vigil/internal/clock"
+	"github.com/stoik/vigil/services/discovery-service/internal/db"
+	"github.com/stoik/vigil/services/discovery-service/internal/schedule"
+)
+
+// Retention deletes emails and events older than a number of days, once a day at a local time
+// Days are counted in the tenant's time zone: with 30 days, the purge keeps the last 30 local
+// calendar days and today, whatever time it runs. Every instance of the tenant runs it; the
+// deletes are idempotent.
+type Retention struct {
+	Days      int
+	At        schedule.Clock // Local time of day the purge runs
+	Loc       *time.Location
+	BatchSize int
+	Clock     clock.Clock // nil = clock.Real
+}
+
+// NewRetention reads the retention.* configuration
+// Returns nil when retention.days is not set
+func NewRetention() (*Retention, error) {
+	days := viper.GetInt("retention.days")
+	if days <= 0 {
+		return nil, nil
+	}
+	at, err := schedule.ParseClock(viper.GetString("retention.at"))
+	if err != nil {
+		return nil, fmt.Errorf("retention.at: %w", err)
+	}
+	loc, err := schedule.Location()
+	if err != nil {
+		return nil, err
+	}
+	return &Retention{Days: days, At: at, Loc: loc, BatchSize: DefaultBatchSize}, nil
+}
+
+// Cutoff returns the instant before which data is expired at now: local midnight Days days
+// before today
+func (r *Retention) Cutoff(now time.Time) time.Time {
+	return schedule.StartOfDay(schedule.StartOfDay(now, r.Loc).AddDate(0, 0, -r.Days), r.Loc)
+}
+
+// Run purges expired data daily at r.At until ctx is cancelled
+func (r *Retention) Run(ctx context.Context) {
+	log.Printf("🔧 Retention | keeping %d days, purging daily at %s %s", r.Days, r.At, r.Loc)
+
+	clk := r.Clock
+	if clk == nil {
+		clk = clock.Real
+	}
+	for {
+		now := clk.Now()
+		timer := clk.NewTimer(r.At.Next(now, r.Loc).Sub(now))
+		select {
+		case <-ctx.Done():
+			timer.Stop()
+			return
+		case <-timer.C():
+		}
+
+		cutoff := r.Cutoff(clk.Now())
+		deleted, err := PurgeBefore(ctx, cutoff, r.BatchSize)
+		if err != nil {
+			if ctx.Err() == nil {
+				log.Printf("Retention purge failed: %v", err)
+			}
+			continue
+		}
+		log.Printf("🔧 Retention purge | deleted data received before %s: %d emails, %d events",
+			cutoff.Format(time.RFC3339), deleted["emails"], deleted["events"])
+	}
+}
+
+// PurgeBefore deletes emails received before cutoff (with their links, reports and LSH bands),
+// events recorded before it and campaigns left without emails, in batches
+// Returns the rows deleted per table
+func PurgeBefore(ctx context.Context, cutoff time.Time, batchSize int) (map[string]int64, error) {
+	if batchSize < 1 {
+		batchSize = DefaultBatchSize
+	}
+
+	// Links first so emails are deleted without long cascades
+	steps := []struct {
+		table string
+		query string
+	}{
+		{"reports", `DELETE FROM reports WHERE id IN
+			(SELECT r.id FROM reports r JOIN emails e ON e.id = r.email_id WHERE e.received_at < $2 LIMIT $1)`},
+		{"user_emails", `DELETE FROM user_emails WHERE (user_id, email_id) IN
+			(SELECT ue.user_id, ue.email_id FROM user_emails ue JOIN emails e ON e.id = ue.email_id WHERE e.received_at < $2 LIMIT $1)`},
+		{"email_minhash_bands", `DELETE FROM email_minhash_bands WHERE (band_key, email_id) IN
+			(SELECT b.band_key, b.email_id FROM email_minhash_bands b JOIN emails e ON e.id = b.email_id WHERE e.received_at < $2 LIMIT $1)`},
+		{"emails", `DELETE FROM emails WHERE id IN (SELECT id FROM emails WHERE received_at < $2 LIMIT $1)`},
+		{"events", `DELETE FROM events WHERE id IN (SELECT id FROM events WHERE at < $2 LIMIT $1)`},
+	}
+	deleted := make(map[string]int64)
+	for _, step := range steps {
+		n, err := db.InBatches(ctx, batchSize, step.query, cutoff)
+		deleted[step.table] = n
+		if err != nil {
+			return deleted, fmt.Errorf("failed to purge %s: %w", step.table, err)
+		}
+	}
+
+	n, err := db.InBatches(ctx, batchSize, `DELETE FROM campaigns WHERE id IN
+		(SELECT c.id FROM campaigns c WHERE NOT EXISTS (SELECT 1 FROM emails e WHERE e.campaign_id = c.id) LIMIT $1)`)
+	deleted["campaigns"] = n
+	if err != nil {
+		return deleted, fmt.Errorf("failed to purge campaigns: %w", err)
+	}
+	return deleted, nil
+}
@@ -0,0 +1,64 @@
+package privacy
+
+import (
+	"testing"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/stoik/vigil/services/discovery-service/internal/db"
+)
+
+func TestRetentionCutoff(t *testing.T) {
+	paris, _ := time.LoadLocation("Europe/Paris")
+	r := &Retention{Days: 30, Loc: paris}
+
+	// 00:30 on April 1 in Paris (still March 31 in UTC): the last 30 local days are kept
+	now := time.Date(2024, 3, 31, 22, 30, 0, 0, time.UTC)
+	if got, want := r.Cutoff(now), time.Date(2024, 3, 2, 0, 0, 0, 0, paris); !got.Equal(want) {
+		t.Errorf("Cutoff = %v, want %v", got, want)
+	}
+	// Across the daylight saving change the cutoff stays at local midnight (CET, then CEST)
+	r.Days = 1
+	if got := r.Cutoff(now); !got.Equal(time.Date(2024, 3, 30, 23, 0, 0, 0, time.UTC)) {
+		t.Errorf("Cutoff = %v, want March 31 00:00 CET", got.UTC())
+	}
+	if got := r.Cutoff(now.Add(24 * time.Hour)); !got.Equal(time.Date(2024, 3, 31, 22, 0, 0, 0, time.UTC)) {
+		t.Errorf("Cutoff = %v, want April 1 00:00 CEST", got.UTC())
+	}
+}
+
+func TestPurgeBefore(t *testing.T) {
+	ctx := setupTestDB(t)
+
+	exec := func(query string, args ...any) {
+		t.Helper()
+		if _, err := db.Pool.Exec(ctx, query, args...); err != nil {
+			t.Fatalf("%s: %v", query, err)
+		}
+	}
+	cutoff := time.Now().Add(-24 * time.Hour)
+	userID := uuid.New()
+	exec(`INSERT INTO users (id, email) VALUES ($1, 'alice@example.com')`, userID)
+	for i, receivedAt := range []time.Time{cutoff.Add(-time.Hour), cutoff.Add(-time.Minute), cutoff.Add(time.Hour)} {
+		emailID := uuid.New()
+		exec(`INSERT INTO emails (id, fingerprint, received_at) VALUES ($1, $2, $3)`, emailID, emailID.String(), receivedAt)
+		exec(`INSERT INTO user_emails (user_id, email_id) VALUES ($1, $2)`, userID, emailID)
+		exec(`INSERT INTO events (type, at, user_id, email_id) VALUES ('email.discovered', $3, $1, $2)`, userID, emailID, receivedAt)
+		if i == 0 {
+			exec(`INSERT INTO reports (id, user_id, email_id, already_discovered) VALUES ($1, $2, $3, TRUE)`, uuid.New(), userID, emailID)
+		}
+	}
+
+	deleted, err := PurgeBefore(ctx, cutoff, 1)
+	if err != nil {
+		t.Fatalf("PurgeBefore: %v", err)
+	}
+	if deleted["emails"] != 2 || deleted["user_emails"] != 2 || deleted["events"] != 2 || deleted["reports"] != 1 {
+		t.Errorf("deleted = %v, want the 2 expired emails and their rows", deleted)
+	}
+	var emails int
+	db.Pool.QueryRow(ctx, `SELECT COUNT(*) FROM emails WHERE received_at >= $1`, cutoff).Scan(&emails)
+	if emails != 1 {
+		t.Errorf("%d recent emails left, want 1", emails)
+	}
+}
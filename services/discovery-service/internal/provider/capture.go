@@ -0,0 +1,177 @@
+package provider
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Redacted replaces secrets in captures
+const Redacted = "REDACTED"
+
+// secretHeaders are the request and response headers whose values are never captured
+var secretHeaders = map[string]bool{
+	"Authorization":       true,
+	"Proxy-Authorization": true,
+	"Cookie":              true,
+	"Set-Cookie":          true,
+	"X-Api-Key":           true,
+	"X-Goog-Api-Key":      true,
+}
+
+// secretParams are the query parameters whose values are never captured
+var secretParams = map[string]bool{
+	"key":           true,
+	"token":         true,
+	"access_token":  true,
+	"client_secret": true,
+	"code":          true,
+	"sig":           true,
+}
+
+// Exchange is one captured HTTP request and its response (one JSON line of a capture file)
+//
+// Unlike an Interaction, which records what a Provider method returned, an Exchange is the
+// provider's raw answer: status, headers and body as sent, before decoding, so quirks the client
+// papers over (odd encodings, extra fields, error bodies) are kept.
+type Exchange struct {
+	At              time.Time           `json:"at"`
+	Duration        time.Duration       `json:"duration"`
+	Method          string              `json:"method"`
+	URL             string              `json:"url"`
+	RequestHeaders  map[string][]string `json:"request_headers,omitempty"`
+	Status          int                 `json:"status,omitempty"`
+	ResponseHeaders map[string][]string `json:"response_headers,omitempty"`
+	Body            string              `json:"body,omitempty"`
+	Error           string              `json:"error,omitempty"` // Network failure, no response
+}
+
+// Capture is an http.RoundTripper writing every exchange, secrets redacted, to a capture file
+type Capture struct {
+	next http.RoundTripper
+
+	mu  sync.Mutex
+	enc *json.Encoder
+	err error // First write error
+}
+
+// NewCapture captures the exchanges sent through next (http.DefaultTransport if nil) as JSON
+// lines written to w
+func NewCapture(next http.RoundTripper, w io.Writer) *Capture {
+	if next == nil {
+		next = http.DefaultTransport
+	}
+	return &Capture{next: next, enc: json.NewEncoder(w)}
+}
+
+func (c *Capture) RoundTrip(req *http.Request) (*http.Response, error) {
+	ex := Exchange{At: time.Now(), Method: req.Method, URL: redactURL(req.URL), RequestHeaders: redactHeaders(req.Header)}
+	resp, err := c.next.RoundTrip(req)
+	if err != nil {
+		ex.Duration = time.Since(ex.At)
+		ex.Error = err.Error()
+		c.write(ex)
+		return nil, err
+	}
+
+	// The whole body is read to be captured, then handed to the client as if untouched
+	body, err := io.ReadAll(resp.Body)
+	resp.Body.Close()
+	resp.Body = io.NopCloser(bytes.NewReader(body))
+	ex.Duration = time.Since(ex.At)
+	ex.Status = resp.StatusCode
+	ex.ResponseHeaders = redactHeaders(resp.Header)
+	ex.Body = string(body)
+	if err != nil {
+		ex.Error = err.Error()
+	}
+	c.write(ex)
+	return resp, err
+}
+
+func (c *Capture) write(ex Exchange) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if werr := c.enc.Encode(ex); werr != nil && c.err == nil {
+		c.err = werr
+	}
+}
+
+// Err returns the first error writing the capture
+func (c *Capture) Err() error {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.err
+}
+
+// redactHeaders copies h without the values of secret headers
+func redactHeaders(h http.Header) map[string][]string {
+	if len(h) == 0 {
+		return nil
+	}
+	out := make(map[string][]string, len(h))
+	for name, values := range h {
+		if secretHeaders[http.CanonicalHeaderKey(name)] {
+			values = []string{Redacted}
+		}
+		out[name] = append([]string(nil), values...)
+	}
+	return out
+}
+
+// redactURL returns u without userinfo and with the values of secret query parameters replaced
+func redactURL(u *url.URL) string {
+	redacted := *u
+	redacted.User = nil
+	if q := u.Query(); len(q) > 0 {
+		for name := range q {
+			if secretParams[strings.ToLower(name)] {
+				q[name] = []string{Redacted}
+			}
+		}
+		redacted.RawQuery = q.Encode()
+	}
+	return redacted.String()
+}
+
+// openCapture wraps the HTTP client of a live provider so its exchanges are captured to a new
+// file <dir>/<provider type>-<time>.jsonl; closer closes the file
+func openCapture(p Provider, dir string) (closer func() error, err error) {
+	var pool *endpointPool
+	name := "google"
+	switch p := p.(type) {
+	case *GoogleProvider:
+		pool = p.endpoints
+	case *MicrosoftProvider:
+		pool, name = p.endpoints, "microsoft"
+	default:
+		return nil, fmt.Errorf("provider.capture_dir: %T does not make HTTP requests", p)
+	}
+
+	if err := os.MkdirAll(dir, 0700); err != nil {
+		return nil, fmt.Errorf("failed to create capture directory: %w", err)
+	}
+	path := filepath.Join(dir, fmt.Sprintf("%s-%s.jsonl", name, time.Now().UTC().Format("20060102T150405Z")))
+	// Secrets are redacted, but bodies still hold mailbox addresses and email content
+	f, err := os.OpenFile(path, os.O_WRONLY|os.O_CREATE|os.O_EXCL, 0600)
+	if err != nil {
+		return nil, fmt.Errorf("failed to create capture file: %w", err)
+	}
+	capture := NewCapture(pool.client.Transport, f)
+	pool.client.Transport = capture
+	return func() error {
+		// Holding the capture's lock, no exchange is half written
+		capture.mu.Lock()
+		defer capture.mu.Unlock()
+		return errors.Join(capture.err, f.Close())
+	}, nil
+}
@@ -0,0 +1,117 @@
+package provider
+
+import (
+	"bytes"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/spf13/viper"
+)
+
+func TestCaptureRedacts(t *testing.T) {
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		http.SetCookie(w, &http.Cookie{Name: "session", Value: "cookie-secret"})
+		w.Write([]byte(`{"ok":true}`))
+	}))
+	t.Cleanup(srv.Close)
+
+	var capture bytes.Buffer
+	client := &http.Client{Transport: NewCapture(nil, &capture)}
+	req, _ := http.NewRequest(http.MethodGet, srv.URL+"/users?key=api-secret&maxResults=2", nil)
+	req.Header.Set("Authorization", "Bearer token-secret")
+	resp, err := client.Do(req)
+	if err != nil {
+		t.Fatalf("GET: %v", err)
+	}
+	var body bytes.Buffer
+	body.ReadFrom(resp.Body)
+	resp.Body.Close()
+	if body.String() != `{"ok":true}` {
+		t.Errorf("client read %q, want the untouched body", body.String())
+	}
+
+	for _, secret := range []string{"api-secret", "token-secret", "cookie-secret"} {
+		if strings.Contains(capture.String(), secret) {
+			t.Errorf("capture leaks %s: %s", secret, capture.String())
+		}
+	}
+	exchanges, err := ReadCapture(&capture)
+	if err != nil || len(exchanges) != 1 {
+		t.Fatalf("ReadCapture = %d exchanges, %v", len(exchanges), err)
+	}
+	ex := exchanges[0]
+	if ex.Status != 200 || ex.Body != `{"ok":true}` || !strings.Contains(ex.URL, "maxResults=2") || ex.RequestHeaders["Authorization"][0] != Redacted {
+		t.Errorf("exchange = %+v", ex)
+	}
+}
+
+func TestSanitizeCaptureIntoFixtures(t *testing.T) {
+	s, url := newMockServer(t, 3)
+	viper.Set("provider.api_url", url)
+	t.Cleanup(viper.Reset)
+
+	var capture bytes.Buffer
+	live := NewGoogleProvider()
+	live.endpoints.client.Transport = NewCapture(nil, &capture)
+	users, err := live.GetUsers(s.TenantID())
+	if err != nil || len(users) != 3 {
+		t.Fatalf("GetUsers() = %d users, %v", len(users), err)
+	}
+	since := time.Now().Add(-time.Hour)
+	for len(s.GetGroundTruth(users[0].ID, since, time.Now().Add(time.Second)).Emails) == 0 {
+		s.GenerateEmails()
+	}
+	emails, err := live.GetEmails(users[0].ID, since, "received_at")
+	if err != nil || len(emails) == 0 {
+		t.Fatalf("GetEmails() = %d emails, %v", len(emails), err)
+	}
+
+	exchanges, err := ReadCapture(&capture)
+	if err != nil || len(exchanges) != 2 {
+		t.Fatalf("ReadCapture = %d exchanges, %v", len(exchanges), err)
+	}
+	sanitizer := NewSanitizer(SanitizeOptions{})
+	var fixtures []Fixture
+	for _, ex := range exchanges {
+		f, err := sanitizer.Fixture(ex)
+		if err != nil {
+			t.Fatalf("Fixture: %v", err)
+		}
+		for _, leak := range []string{users[0].Email, users[0].Name, emails[0].Subject, url} {
+			if strings.Contains(f.URL+f.Body, leak) {
+				t.Errorf("fixture %s leaks %q", f.URL, leak)
+			}
+		}
+		fixtures = append(fixtures, f)
+	}
+
+	dir := t.TempDir()
+	if err := WriteFixtures(dir, fixtures); err != nil {
+		t.Fatalf("WriteFixtures: %v", err)
+	}
+	loaded, err := LoadFixtures(dir)
+	if err != nil || len(loaded) != 2 {
+		t.Fatalf("LoadFixtures = %d fixtures, %v", len(loaded), err)
+	}
+
+	// The client decodes the fixtures as it did the live answers, with pseudonyms
+	replay := NewGoogleProvider()
+	replay.endpoints.client.Transport = NewFixtureTransport(loaded)
+	replayed, err := replay.GetUsers(s.TenantID())
+	if err != nil || len(replayed) != 3 || replayed[0].ID != users[0].ID {
+		t.Fatalf("replayed users = %v, %v", replayed, err)
+	}
+	if !strings.HasPrefix(replayed[0].Email, "user") || !strings.HasSuffix(replayed[0].Email, ".example") {
+		t.Errorf("replayed address = %q, want a pseudonym", replayed[0].Email)
+	}
+	got, err := replay.GetEmails(users[0].ID, time.Now(), "received_at")
+	if err != nil || len(got) != len(emails) || got[0].MessageID != emails[0].MessageID || got[0].To != replayed[0].Email {
+		t.Errorf("replayed emails = %d, %v; want %d addressed to the user's pseudonym", len(got), err, len(emails))
+	}
+	if _, err := replay.GetEmail(users[0].ID, emails[0].MessageID); err == nil {
+		t.Error("request without a fixture answered")
+	}
+}
@@ -3,8 +3,8 @@ package provider
 import (
 	"encoding/json"
 	"fmt"
-	"io"
 	"net/http"
+	"net/url"
 	"time"
 
 	"github.com/google/uuid"
@@ -14,38 +14,28 @@ import (
 
 // GoogleProvider implements the Provider interface for Google Workspace
 type GoogleProvider struct {
-	baseURL string
-	client  *http.Client
+	endpoints *endpointPool // provider.api_url, with failover between them
+	format    string        // Email format requested from the provider ("full" or "metadata")
 }
 
 // NewGoogleProvider creates a new Google provider client
 func NewGoogleProvider() *GoogleProvider {
-	baseURL := viper.GetString("provider.api_url")
-	if baseURL == "" {
-		baseURL = "http://localhost:8080"
-	}
-
 	return &GoogleProvider{
-		baseURL: baseURL,
-		client: &http.Client{
-			Timeout: 30 * time.Second,
-		},
+		endpoints: newEndpointPool(&http.Client{Timeout: 30 * time.Second}),
+		format:    emailFormat(),
 	}
 }
 
 // GetUsers implements Provider.GetUsers for Google Workspace
 func (g *GoogleProvider) GetUsers(tenantID uuid.UUID) ([]models.ProviderUser, error) {
-	url := fmt.Sprintf("%s/google/users/%s", g.baseURL, tenantID.String())
-	
-	resp, err := g.client.Get(url)
+	resp, err := g.endpoints.get("/google/users/"+tenantID.String(), nil)
 	if err != nil {
-		return nil, fmt.Errorf("failed to get users: %w", err)
+		return nil, networkError("failed to get users", err)
 	}
 	defer resp.Body.Close()
 
-	if resp.StatusCode != http.StatusOK {
-		body, _ := io.ReadAll(resp.Body)
-		return nil, fmt.Errorf("unexpected status %d: %s", resp.StatusCode, string(body))
+	if err := checkResponse(resp); err != nil {
+		return nil, err
 	}
 
 	var users []models.ProviderUser
@@ -58,27 +48,24 @@ func (g *GoogleProvider) GetUsers(tenantID uuid.UUID) ([]models.ProviderUser, er
 
 // GetEmails implements Provider.GetEmails for Google Workspace
 func (g *GoogleProvider) GetEmails(userID uuid.UUID, receivedAfter time.Time, orderBy string) ([]models.ProviderEmail, error) {
-	url := fmt.Sprintf("%s/google/emails/%s", g.baseURL, userID.String())
-	
-	req, err := http.NewRequest("GET", url, nil)
-	if err != nil {
-		return nil, fmt.Errorf("failed to create request: %w", err)
-	}
+	return g.GetEmailsFormat(userID, receivedAfter, orderBy, g.format)
+}
 
-	q := req.URL.Query()
+// GetEmailsFormat implements FormatProvider.GetEmailsFormat
+func (g *GoogleProvider) GetEmailsFormat(userID uuid.UUID, receivedAfter time.Time, orderBy, format string) ([]models.ProviderEmail, error) {
+	q := url.Values{}
 	q.Set("receivedAfter", receivedAfter.Format(time.RFC3339))
 	q.Set("orderBy", orderBy)
-	req.URL.RawQuery = q.Encode()
+	q.Set("format", format)
 
-	resp, err := g.client.Do(req)
+	resp, err := g.endpoints.get("/google/emails/"+userID.String(), q)
 	if err != nil {
-		return nil, fmt.Errorf("failed to get emails: %w", err)
+		return nil, networkError("failed to get emails", err)
 	}
 	defer resp.Body.Close()
 
-	if resp.StatusCode != http.StatusOK {
-		body, _ := io.ReadAll(resp.Body)
-		return nil, fmt.Errorf("unexpected status %d: %s", resp.StatusCode, string(body))
+	if err := checkResponse(resp); err != nil {
+		return nil, err
 	}
 
 	var emails []models.ProviderEmail
@@ -89,40 +76,51 @@ func (g *GoogleProvider) GetEmails(userID uuid.UUID, receivedAfter time.Time, or
 	return emails, nil
 }
 
+// GetEmail implements Provider.GetEmail for Google Workspace
+func (g *GoogleProvider) GetEmail(userID uuid.UUID, messageID string) (models.ProviderEmail, error) {
+	path := fmt.Sprintf("/google/emails/%s/%s", userID.String(), url.PathEscape(messageID))
+	resp, err := g.endpoints.get(path, url.Values{"format": {EmailFormatFull}})
+	if err != nil {
+		return models.ProviderEmail{}, networkError("failed to get email", err)
+	}
+	defer resp.Body.Close()
+
+	if err := checkResponse(resp); err != nil {
+		return models.ProviderEmail{}, emailNotFound(err)
+	}
+
+	var email models.ProviderEmail
+	if err := json.NewDecoder(resp.Body).Decode(&email); err != nil {
+		return models.ProviderEmail{}, fmt.Errorf("failed to decode response: %w", err)
+	}
+
+	return email, nil
+}
+
 // MicrosoftProvider implements the Provider interface for Microsoft O365
 type MicrosoftProvider struct {
-	baseURL string
-	client  *http.Client
+	endpoints *endpointPool // provider.api_url, with failover between them
+	format    string        // Email format requested from the provider ("full" or "metadata")
 }
 
 // NewMicrosoftProvider creates a new Microsoft provider client
 func NewMicrosoftProvider() *MicrosoftProvider {
-	baseURL := viper.GetString("provider.api_url")
-	if baseURL == "" {
-		baseURL = "http://localhost:8080"
-	}
-
 	return &MicrosoftProvider{
-		baseURL: baseURL,
-		client: &http.Client{
-			Timeout: 30 * time.Second,
-		},
+		endpoints: newEndpointPool(&http.Client{Timeout: 30 * time.Second}),
+		format:    emailFormat(),
 	}
 }
 
 // GetUsers implements Provider.GetUsers for Microsoft O365
 func (m *MicrosoftProvider) GetUsers(tenantID uuid.UUID) ([]models.ProviderUser, error) {
-	url := fmt.Sprintf("%s/microsoft/users/%s", m.baseURL, tenantID.String())
-	
-	resp, err := m.client.Get(url)
+	resp, err := m.endpoints.get("/microsoft/users/"+tenantID.String(), nil)
 	if err != nil {
-		return nil, fmt.Errorf("failed to get users: %w", err)
+		return nil, networkError("failed to get users", err)
 	}
 	defer resp.Body.Close()
 
-	if resp.StatusCode != http.StatusOK {
-		body, _ := io.ReadAll(resp.Body)
-		return nil, fmt.Errorf("unexpected status %d: %s", resp.StatusCode, string(body))
+	if err := checkResponse(resp); err != nil {
+		return nil, err
 	}
 
 	var users []models.ProviderUser
@@ -135,27 +133,24 @@ func (m *MicrosoftProvider) GetUsers(tenantID uuid.UUID) ([]models.ProviderUser,
 
 // GetEmails implements Provider.GetEmails for Microsoft O365
 func (m *MicrosoftProvider) GetEmails(userID uuid.UUID, receivedAfter time.Time, orderBy string) ([]models.ProviderEmail, error) {
-	url := fmt.Sprintf("%s/microsoft/emails/%s", m.baseURL, userID.String())
-	
-	req, err := http.NewRequest("GET", url, nil)
-	if err != nil {
-		return nil, fmt.Errorf("failed to create request: %w", err)
-	}
+	return m.GetEmailsFormat(userID, receivedAfter, orderBy, m.format)
+}
 
-	q := req.URL.Query()
+// GetEmailsFormat implements FormatProvider.GetEmailsFormat
+func (m *MicrosoftProvider) GetEmailsFormat(userID uuid.UUID, receivedAfter time.Time, orderBy, format string) ([]models.ProviderEmail, error) {
+	q := url.Values{}
 	q.Set("receivedAfter", receivedAfter.Format(time.RFC3339))
 	q.Set("orderBy", orderBy)
-	req.URL.RawQuery = q.Encode()
+	q.Set("format", format)
 
-	resp, err := m.client.Do(req)
+	resp, err := m.endpoints.get("/microsoft/emails/"+userID.String(), q)
 	if err != nil {
-		return nil, fmt.Errorf("failed to get emails: %w", err)
+		return nil, networkError("failed to get emails", err)
 	}
 	defer resp.Body.Close()
 
-	if resp.StatusCode != http.StatusOK {
-		body, _ := io.ReadAll(resp.Body)
-		return nil, fmt.Errorf("unexpected status %d: %s", resp.StatusCode, string(body))
+	if err := checkResponse(resp); err != nil {
+		return nil, err
 	}
 
 	var emails []models.ProviderEmail
@@ -166,6 +161,42 @@ func (m *MicrosoftProvider) GetEmails(userID uuid.UUID, receivedAfter time.Time,
 	return emails, nil
 }
 
+// GetEmail implements Provider.GetEmail for Microsoft O365
+func (m *MicrosoftProvider) GetEmail(userID uuid.UUID, messageID string) (models.ProviderEmail, error) {
+	path := fmt.Sprintf("/microsoft/emails/%s/%s", userID.String(), url.PathEscape(messageID))
+	resp, err := m.endpoints.get(path, url.Values{"format": {EmailFormatFull}})
+	if err != nil {
+		return models.ProviderEmail{}, networkError("failed to get email", err)
+	}
+	defer resp.Body.Close()
+
+	if err := checkResponse(resp); err != nil {
+		return models.ProviderEmail{}, emailNotFound(err)
+	}
+
+	var email models.ProviderEmail
+	if err := json.NewDecoder(resp.Body).Decode(&email); err != nil {
+		return models.ProviderEmail{}, fmt.Errorf("failed to decode response: %w", err)
+	}
+
+	return email, nil
+}
+
+// Email formats requested from providers
+const (
+	EmailFormatFull     = "full"     // Bodies included
+	EmailFormatMetadata = "metadata" // Headers, subject and snippet only
+)
+
+// emailFormat maps ingest.body_mode to the provider email format
+// Snippet mode never fetches bodies, reducing quota usage and data exposure
+func emailFormat() string {
+	if viper.GetString("ingest.body_mode") == "snippet" {
+		return EmailFormatMetadata
+	}
+	return EmailFormatFull
+}
+
 // NewProvider creates a provider instance based on configuration
 // provider.type can be "google" or "microsoft" (defaults to "google")
 func NewProvider() Provider {
@@ -0,0 +1,117 @@
+package provider
+
+import (
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"net/http/httputil"
+	"net/url"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/spf13/viper"
+	"github.com/stoik/vigil/services/mock-server/mock"
+)
+
+// newMockServer serves an isolated mock provider with users users
+func newMockServer(t *testing.T, users int) (*mock.Store, string) {
+	t.Helper()
+	router, s, err := mock.NewRouter(mock.Options{Users: users})
+	if err != nil {
+		t.Fatalf("NewRouter: %v", err)
+	}
+	srv := httptest.NewServer(router)
+	t.Cleanup(srv.Close)
+	return s, srv.URL
+}
+
+func TestGoogleProviderAgainstMock(t *testing.T) {
+	s, url := newMockServer(t, 5)
+	viper.Set("provider.api_url", url)
+	viper.Set("ingest.body_mode", "snippet")
+	t.Cleanup(viper.Reset)
+
+	client := NewGoogleProvider()
+	users, err := client.GetUsers(s.TenantID())
+	if err != nil || len(users) != 5 {
+		t.Fatalf("GetUsers() = %d users, %v; want 5", len(users), err)
+	}
+
+	since := time.Now().Add(-time.Hour)
+	for len(s.GetGroundTruth(users[0].ID, since, time.Now().Add(time.Second)).Emails) == 0 {
+		s.GenerateEmails()
+	}
+
+	emails, err := client.GetEmails(users[0].ID, since, "received_at")
+	if err != nil || len(emails) == 0 {
+		t.Fatalf("GetEmails() = %d emails, %v; want some", len(emails), err)
+	}
+	for i, email := range emails {
+		if email.Body != "" {
+			t.Fatalf("email %s has a body in snippet mode", email.MessageID)
+		}
+		if i > 0 && email.ReceivedAt.Before(emails[i-1].ReceivedAt) {
+			t.Fatalf("emails not ordered by received_at")
+		}
+	}
+
+	// Content fetches always get the body
+	full, err := client.GetEmail(users[0].ID, emails[0].MessageID)
+	if err != nil || full.Body == "" {
+		t.Errorf("GetEmail() body empty (%v)", err)
+	}
+	if _, err := client.GetEmail(users[0].ID, uuid.NewString()); !errors.Is(err, ErrEmailNotFound) {
+		t.Errorf("GetEmail(unknown) = %v, want ErrEmailNotFound", err)
+	}
+}
+
+func TestEndpointFailover(t *testing.T) {
+	s, mockURL := newMockServer(t, 2)
+	target, _ := url.Parse(mockURL)
+	proxy := httputil.NewSingleHostReverseProxy(target)
+
+	// The primary gateway forwards to the provider until its region goes down
+	var primaryDown atomic.Bool
+	var primaryHits atomic.Int64
+	primary := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if primaryDown.Load() {
+			w.WriteHeader(http.StatusServiceUnavailable)
+			return
+		}
+		primaryHits.Add(1)
+		proxy.ServeHTTP(w, r)
+	}))
+	defer primary.Close()
+
+	viper.Set("provider.api_url", primary.URL+", "+mockURL)
+	viper.Set("provider.health_interval", time.Millisecond)
+	t.Cleanup(viper.Reset)
+	client := NewGoogleProvider()
+
+	primaryDown.Store(true)
+	if users, err := client.GetUsers(s.TenantID()); err != nil || len(users) != 2 {
+		t.Fatalf("GetUsers() with the primary down = %d users, %v; want the secondary's answer", len(users), err)
+	}
+
+	// Traffic fails back once the primary's health probe succeeds
+	primaryDown.Store(false)
+	time.Sleep(2 * time.Millisecond)
+	if _, err := client.GetUsers(s.TenantID()); err != nil || primaryHits.Load() < 2 {
+		t.Errorf("GetUsers() after recovery = %v with %d primary hits, want the primary (probe + request)", err, primaryHits.Load())
+	}
+
+	// Provider answers are not endpoint failures: a missing email does not fail over
+	hits := primaryHits.Load()
+	if _, err := client.GetEmail(uuid.New(), uuid.NewString()); !errors.Is(err, ErrEmailNotFound) || primaryHits.Load() != hits+1 {
+		t.Errorf("GetEmail(unknown) = %v", err)
+	}
+
+	// Every endpoint down: the gateway error is returned as transient
+	viper.Set("provider.api_url", primary.URL)
+	primaryDown.Store(true)
+	if _, err := NewGoogleProvider().GetUsers(s.TenantID()); !errors.Is(err, ErrTransient) {
+		t.Errorf("GetUsers() with every endpoint down = %v, want ErrTransient", err)
+	}
+}
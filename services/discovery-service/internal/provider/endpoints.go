@@ -0,0 +1,178 @@
+package provider
+
+import (
+	"fmt"
+	"log"
+	"net/http"
+	"net/url"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/spf13/viper"
+)
+
+const (
+	DefaultAPIURL                = "http://localhost:8080"
+	DefaultEndpointCheckInterval = 30 * time.Second
+	endpointProbeTimeout         = 5 * time.Second
+)
+
+// endpoint is one provider API gateway (e.g. a region)
+type endpoint struct {
+	url       string
+	down      bool
+	downSince time.Time
+	checkedAt time.Time // Last failure or health probe while down
+}
+
+// endpointPool sends requests to the first healthy provider endpoint, in configured order
+//
+// An endpoint is marked down when a request to it fails at the network level or gets a gateway
+// error (502, 503, 504); the request is then retried on the next endpoint. Other statuses (rate
+// limits, unknown users...) come from the provider itself and are returned as they are. A down
+// endpoint is probed (GET /health) when a request comes in at least checkInterval after its last
+// failure, and takes traffic again once it answers, so traffic fails back to the primary. When
+// every endpoint is down, all of them are tried anyway.
+type endpointPool struct {
+	client        *http.Client
+	checkInterval time.Duration
+
+	mu        sync.Mutex
+	endpoints []*endpoint
+}
+
+// newEndpointPool creates a pool of the comma-separated provider.api_url endpoints
+func newEndpointPool(client *http.Client) *endpointPool {
+	p := &endpointPool{client: client, checkInterval: viper.GetDuration("provider.health_interval")}
+	if p.checkInterval <= 0 {
+		p.checkInterval = DefaultEndpointCheckInterval
+	}
+	for _, u := range strings.Split(viper.GetString("provider.api_url"), ",") {
+		if u = strings.TrimRight(strings.TrimSpace(u), "/"); u != "" {
+			p.endpoints = append(p.endpoints, &endpoint{url: u})
+		}
+	}
+	if len(p.endpoints) == 0 {
+		p.endpoints = []*endpoint{{url: DefaultAPIURL}}
+	}
+	return p
+}
+
+// gatewayFailure reports whether status means the endpoint, not the provider, is failing
+func gatewayFailure(status int) bool {
+	return status == http.StatusBadGateway || status == http.StatusServiceUnavailable || status == http.StatusGatewayTimeout
+}
+
+// get requests path (with query) from the first endpoint that answers
+// The last gateway error response is returned when every endpoint fails with one
+func (p *endpointPool) get(path string, query url.Values) (*http.Response, error) {
+	target := path
+	if len(query) > 0 {
+		target += "?" + query.Encode()
+	}
+
+	var lastResp *http.Response
+	var lastErr error
+	for _, e := range p.candidates() {
+		resp, err := p.client.Get(e.url + target)
+		if err == nil && !gatewayFailure(resp.StatusCode) {
+			if lastResp != nil {
+				lastResp.Body.Close()
+			}
+			p.markUp(e)
+			return resp, nil
+		}
+
+		if lastResp != nil {
+			lastResp.Body.Close()
+		}
+		lastResp, lastErr = nil, err
+		reason := ""
+		if err != nil {
+			reason = err.Error()
+		} else {
+			lastResp = resp
+			reason = fmt.Sprintf("status %d", resp.StatusCode)
+		}
+		p.markDown(e, reason)
+	}
+	if lastResp != nil {
+		return lastResp, nil
+	}
+	return nil, lastErr
+}
+
+// candidates returns the endpoints to try, in order: the healthy ones, and the down ones whose
+// health probe succeeds; every endpoint if none of them is up
+func (p *endpointPool) candidates() []*endpoint {
+	p.mu.Lock()
+	var probe []*endpoint
+	now := time.Now()
+	for _, e := range p.endpoints {
+		if e.down && now.Sub(e.checkedAt) >= p.checkInterval {
+			e.checkedAt = now // One probe at a time
+			probe = append(probe, e)
+		}
+	}
+	p.mu.Unlock()
+
+	for _, e := range probe {
+		if p.probe(e) {
+			p.markUp(e)
+		}
+	}
+
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	candidates := make([]*endpoint, 0, len(p.endpoints))
+	for _, e := range p.endpoints {
+		if !e.down {
+			candidates = append(candidates, e)
+		}
+	}
+	if len(candidates) == 0 {
+		candidates = append(candidates, p.endpoints...)
+	}
+	return candidates
+}
+
+// probe checks a down endpoint's health
+func (p *endpointPool) probe(e *endpoint) bool {
+	client := &http.Client{Transport: p.client.Transport, Timeout: endpointProbeTimeout}
+	resp, err := client.Get(e.url + "/health")
+	if err != nil {
+		return false
+	}
+	resp.Body.Close()
+	return resp.StatusCode == http.StatusOK
+}
+
+func (p *endpointPool) markUp(e *endpoint) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	if !e.down {
+		return
+	}
+	log.Printf("✓ Provider endpoint %s healthy again after %v", e.url, time.Since(e.downSince).Round(time.Second))
+	e.down = false
+}
+
+func (p *endpointPool) markDown(e *endpoint, reason string) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	now := time.Now()
+	e.checkedAt = now
+	if e.down {
+		return
+	}
+	e.down, e.downSince = true, now
+	next := "none left"
+	for _, other := range p.endpoints {
+		if !other.down {
+			next = "failing over to " + other.url
+			break
+		}
+	}
+	log.Printf("🚨 Provider endpoint %s down (%s), %s", e.url, reason, next)
+}
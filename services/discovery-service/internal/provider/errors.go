@@ -0,0 +1,117 @@
+package provider
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"strconv"
+	"time"
+)
+
+// Error kinds returned by provider implementations, matched with errors.Is
+// Each kind gets a distinct treatment from the scheduler:
+//   - ErrRateLimited: back off (honouring Retry-After when present)
+//   - ErrUnauthorized: alert, credentials or scopes need operator attention
+//   - ErrUserNotFound: deactivate the user's email discovery
+//   - ErrTransient: log and retry on the next poll
+var (
+	ErrRateLimited   = errors.New("provider rate limited")
+	ErrUnauthorized  = errors.New("provider unauthorized")
+	ErrUserNotFound  = errors.New("user not found at provider")
+	ErrEmailNotFound = errors.New("email not found at provider")
+	ErrTransient     = errors.New("transient provider failure")
+)
+
+// Error is a provider failure carrying its kind and HTTP details
+type Error struct {
+	Kind       error
+	StatusCode int           // 0 for network-level failures
+	RetryAfter time.Duration // From the Retry-After header, 0 if absent
+	RequestID  string        // From the X-Request-ID header, to correlate with provider-side logs
+	Message    string
+}
+
+func (e *Error) Error() string {
+	if e.StatusCode == 0 {
+		return fmt.Sprintf("%v: %s", e.Kind, e.Message)
+	}
+	if e.RequestID != "" {
+		return fmt.Sprintf("%v (status %d, request_id %s): %s", e.Kind, e.StatusCode, e.RequestID, e.Message)
+	}
+	return fmt.Sprintf("%v (status %d): %s", e.Kind, e.StatusCode, e.Message)
+}
+
+func (e *Error) Unwrap() error {
+	return e.Kind
+}
+
+// RetryAfter returns the provider-requested retry delay carried by err, if any
+func RetryAfter(err error) time.Duration {
+	var pErr *Error
+	if errors.As(err, &pErr) {
+		return pErr.RetryAfter
+	}
+	return 0
+}
+
+// networkError wraps a request failure (timeout, connection refused...) as transient
+func networkError(op string, err error) error {
+	return &Error{Kind: ErrTransient, Message: fmt.Sprintf("%s: %v", op, err)}
+}
+
+// emailNotFound re-labels a 404 on a single-email lookup: the message is missing, not the user
+func emailNotFound(err error) error {
+	var pErr *Error
+	if errors.As(err, &pErr) && pErr.Kind == ErrUserNotFound {
+		pErr.Kind = ErrEmailNotFound
+	}
+	return err
+}
+
+// checkResponse maps a non-200 provider response to a typed error
+func checkResponse(resp *http.Response) error {
+	if resp.StatusCode == http.StatusOK {
+		return nil
+	}
+
+	body, _ := io.ReadAll(resp.Body)
+	requestID := resp.Header.Get("X-Request-ID")
+	pErr := &Error{StatusCode: resp.StatusCode, RequestID: requestID, Message: string(body)}
+
+	switch {
+	case resp.StatusCode == http.StatusTooManyRequests:
+		pErr.Kind = ErrRateLimited
+		pErr.RetryAfter = parseRetryAfter(resp.Header.Get("Retry-After"))
+	case resp.StatusCode == http.StatusUnauthorized || resp.StatusCode == http.StatusForbidden:
+		pErr.Kind = ErrUnauthorized
+	case resp.StatusCode == http.StatusNotFound:
+		pErr.Kind = ErrUserNotFound
+	case resp.StatusCode >= 500:
+		pErr.Kind = ErrTransient
+		pErr.RetryAfter = parseRetryAfter(resp.Header.Get("Retry-After"))
+	default:
+		if requestID != "" {
+			return fmt.Errorf("unexpected status %d (request_id %s): %s", resp.StatusCode, requestID, string(body))
+		}
+		return fmt.Errorf("unexpected status %d: %s", resp.StatusCode, string(body))
+	}
+
+	return pErr
+}
+
+// parseRetryAfter parses a Retry-After header (delay-seconds or HTTP-date)
+func parseRetryAfter(value string) time.Duration {
+	if value == "" {
+		return 0
+	}
+	if seconds, err := strconv.Atoi(value); err == nil && seconds > 0 {
+		return time.Duration(seconds) * time.Second
+	}
+	if at, err := http.ParseTime(value); err == nil {
+		if d := time.Until(at); d > 0 {
+			return d
+		}
+	}
+	return 0
+}
@@ -0,0 +1,51 @@
+package provider
+
+import (
+	"errors"
+	"io"
+	"net/http"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestCheckResponse(t *testing.T) {
+	tests := []struct {
+		status     int
+		retryAfter string
+		want       error
+		wantRetry  time.Duration
+	}{
+		{http.StatusTooManyRequests, "30", ErrRateLimited, 30 * time.Second},
+		{http.StatusTooManyRequests, "", ErrRateLimited, 0},
+		{http.StatusUnauthorized, "", ErrUnauthorized, 0},
+		{http.StatusForbidden, "", ErrUnauthorized, 0},
+		{http.StatusNotFound, "", ErrUserNotFound, 0},
+		{http.StatusServiceUnavailable, "5", ErrTransient, 5 * time.Second},
+		{http.StatusBadGateway, "", ErrTransient, 0},
+	}
+
+	for _, tt := range tests {
+		resp := &http.Response{
+			StatusCode: tt.status,
+			Header:     http.Header{},
+			Body:       io.NopCloser(strings.NewReader("error body")),
+		}
+		if tt.retryAfter != "" {
+			resp.Header.Set("Retry-After", tt.retryAfter)
+		}
+
+		err := checkResponse(resp)
+		if !errors.Is(err, tt.want) {
+			t.Errorf("status %d: got %v, want %v", tt.status, err, tt.want)
+		}
+		if got := RetryAfter(err); got != tt.wantRetry {
+			t.Errorf("status %d: RetryAfter = %v, want %v", tt.status, got, tt.wantRetry)
+		}
+	}
+
+	ok := &http.Response{StatusCode: http.StatusOK, Body: io.NopCloser(strings.NewReader(""))}
+	if err := checkResponse(ok); err != nil {
+		t.Errorf("status 200: got %v, want nil", err)
+	}
+}
@@ -0,0 +1,313 @@
+package provider
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"os"
+	"path/filepath"
+	"regexp"
+	"slices"
+	"strings"
+	"sync"
+)
+
+// Fixtures
+//
+// Captures hold real mailbox addresses and email content, so they are sanitized into fixtures
+// before being checked in: secrets are dropped again (captures may come from elsewhere), email
+// addresses are replaced by stable pseudonyms (the same address always maps to the same
+// pseudonym within a run, so cross references between users, senders and managers survive),
+// and subjects, snippets, bodies and names are replaced unless kept explicitly. JSON structure,
+// status codes, headers and encodings are left as they are: they are the quirks the corpus is
+// for. FixtureTransport then answers a provider client from the fixtures in tests.
+
+// redactedContent replaces email content and names in fixtures
+const redactedContent = "[redacted]"
+
+var addressPattern = regexp.MustCompile(`[A-Za-z0-9._%+\-]+@[A-Za-z0-9.\-]+\.[A-Za-z]{2,}`)
+
+// contentKeys are the JSON fields holding email content or people's names
+var contentKeys = map[string]bool{
+	"subject":     true,
+	"snippet":     true,
+	"body":        true,
+	"bodypreview": true,
+	"content":     true,
+	"data":        true,
+	"raw":         true,
+	"name":        true,
+	"displayname": true,
+	"fullname":    true,
+	"givenname":   true,
+	"familyname":  true,
+	"surname":     true,
+	"title":       true,
+}
+
+// Fixture is a sanitized exchange, answered by FixtureTransport
+type Fixture struct {
+	Method          string              `json:"method"`
+	URL             string              `json:"url"` // Path and query, host-independent
+	Status          int                 `json:"status,omitempty"`
+	ResponseHeaders map[string][]string `json:"response_headers,omitempty"`
+	Body            string              `json:"body,omitempty"`
+	Error           string              `json:"error,omitempty"`
+}
+
+// SanitizeOptions tune how captures are sanitized
+type SanitizeOptions struct {
+	KeepDomains bool // Keep the domains of email addresses (local parts are always replaced)
+	KeepContent bool // Keep subjects, snippets, bodies and names
+}
+
+// Sanitizer turns captured exchanges into fixtures
+type Sanitizer struct {
+	opts      SanitizeOptions
+	addresses map[string]string
+	domains   map[string]string
+}
+
+// NewSanitizer creates a sanitizer; use one per corpus so pseudonyms are consistent across it
+func NewSanitizer(opts SanitizeOptions) *Sanitizer {
+	return &Sanitizer{opts: opts, addresses: make(map[string]string), domains: make(map[string]string)}
+}
+
+// Fixture sanitizes a captured exchange
+func (s *Sanitizer) Fixture(ex Exchange) (Fixture, error) {
+	u, err := url.Parse(ex.URL)
+	if err != nil {
+		return Fixture{}, fmt.Errorf("invalid URL %q: %w", ex.URL, err)
+	}
+	target := &url.URL{Path: s.pseudonymize(u.Path)}
+	if q := u.Query(); len(q) > 0 {
+		for _, name := range sortedKeys(q) {
+			values := q[name]
+			for i := range values {
+				values[i] = s.pseudonymize(values[i])
+			}
+			if secretParams[strings.ToLower(name)] {
+				q[name] = []string{Redacted}
+			}
+		}
+		target.RawQuery = q.Encode()
+	}
+
+	f := Fixture{Method: ex.Method, URL: target.String(), Status: ex.Status, Error: ex.Error}
+	if len(ex.ResponseHeaders) > 0 {
+		f.ResponseHeaders = make(map[string][]string, len(ex.ResponseHeaders))
+		for _, name := range sortedKeys(ex.ResponseHeaders) {
+			values := ex.ResponseHeaders[name]
+			if secretHeaders[http.CanonicalHeaderKey(name)] {
+				continue
+			}
+			for _, v := range values {
+				f.ResponseHeaders[name] = append(f.ResponseHeaders[name], s.pseudonymize(v))
+			}
+		}
+	}
+	f.Body = s.body(ex.Body)
+	return f, nil
+}
+
+// body sanitizes a response body: field by field for JSON, addresses only otherwise
+func (s *Sanitizer) body(body string) string {
+	dec := json.NewDecoder(strings.NewReader(body))
+	dec.UseNumber()
+	var v any
+	if err := dec.Decode(&v); err != nil || dec.More() {
+		return s.pseudonymize(body)
+	}
+	var out bytes.Buffer
+	enc := json.NewEncoder(&out)
+	enc.SetEscapeHTML(false)
+	if err := enc.Encode(s.value(v, false)); err != nil {
+		return s.pseudonymize(body)
+	}
+	return strings.TrimSuffix(out.String(), "\n")
+}
+
+// value sanitizes a decoded JSON value; redact replaces its strings (a content field's value)
+func (s *Sanitizer) value(v any, redact bool) any {
+	switch v := v.(type) {
+	case string:
+		if redact && v != "" {
+			return redactedContent
+		}
+		return s.pseudonymize(v)
+	case []any:
+		for i := range v {
+			v[i] = s.value(v[i], redact)
+		}
+	case map[string]any:
+		// A {"name": "Subject", "value": ...} header: the name is kept, the value follows it
+		if name, ok := v["name"].(string); ok {
+			if _, header := v["value"]; header {
+				v["value"] = s.value(v["value"], redact || s.redacts(name))
+				return v
+			}
+		}
+		for _, key := range sortedKeys(v) {
+			v[key] = s.value(v[key], redact || s.redacts(key))
+		}
+	}
+	return v
+}
+
+// redacts reports whether the content of a field is replaced
+func (s *Sanitizer) redacts(key string) bool {
+	return !s.opts.KeepContent && contentKeys[strings.ToLower(key)]
+}
+
+// sortedKeys returns the keys of m in order, so pseudonyms are numbered the same on every run
+func sortedKeys[V any](m map[string]V) []string {
+	keys := make([]string, 0, len(m))
+	for k := range m {
+		keys = append(keys, k)
+	}
+	slices.Sort(keys)
+	return keys
+}
+
+// pseudonymize replaces the email addresses in v
+func (s *Sanitizer) pseudonymize(v string) string {
+	return addressPattern.ReplaceAllStringFunc(v, func(address string) string {
+		address = strings.ToLower(address)
+		if p, ok := s.addresses[address]; ok {
+			return p
+		}
+		domain := address[strings.LastIndexByte(address, '@')+1:]
+		if !s.opts.KeepDomains {
+			if _, ok := s.domains[domain]; !ok {
+				s.domains[domain] = fmt.Sprintf("domain%d.example", len(s.domains)+1)
+			}
+			domain = s.domains[domain]
+		}
+		p := fmt.Sprintf("user%d@%s", len(s.addresses)+1, domain)
+		s.addresses[address] = p
+		return p
+	})
+}
+
+// ReadCapture reads the exchanges of a capture file
+func ReadCapture(r io.Reader) ([]Exchange, error) {
+	var exchanges []Exchange
+	dec := json.NewDecoder(r)
+	for {
+		var ex Exchange
+		if err := dec.Decode(&ex); errors.Is(err, io.EOF) {
+			return exchanges, nil
+		} else if err != nil {
+			return nil, fmt.Errorf("capture exchange %d: %w", len(exchanges)+1, err)
+		}
+		exchanges = append(exchanges, ex)
+	}
+}
+
+var slugPattern = regexp.MustCompile(`[^a-z0-9]+`)
+
+// WriteFixtures writes one indented JSON file per fixture to dir, named after its position and
+// request (0001-get-google-users.json), so a corpus diffs and reviews like code
+func WriteFixtures(dir string, fixtures []Fixture) error {
+	if err := os.MkdirAll(dir, 0755); err != nil {
+		return fmt.Errorf("failed to create fixture directory: %w", err)
+	}
+	for i, f := range fixtures {
+		path, _, _ := strings.Cut(f.URL, "?")
+		slug := strings.Trim(slugPattern.ReplaceAllString(strings.ToLower(f.Method+" "+path), "-"), "-")
+		if len(slug) > 60 {
+			slug = strings.TrimRight(slug[:60], "-")
+		}
+		data, err := json.MarshalIndent(f, "", "  ")
+		if err != nil {
+			return err
+		}
+		name := filepath.Join(dir, fmt.Sprintf("%04d-%s.json", i+1, slug))
+		if err := os.WriteFile(name, append(data, '\n'), 0644); err != nil {
+			return fmt.Errorf("failed to write fixture: %w", err)
+		}
+	}
+	return nil
+}
+
+// LoadFixtures reads the fixtures of dir, in file name order
+func LoadFixtures(dir string) ([]Fixture, error) {
+	names, err := filepath.Glob(filepath.Join(dir, "*.json"))
+	if err != nil {
+		return nil, err
+	}
+	fixtures := make([]Fixture, 0, len(names))
+	for _, name := range names { // Glob sorts
+		data, err := os.ReadFile(name)
+		if err != nil {
+			return nil, err
+		}
+		var f Fixture
+		if err := json.Unmarshal(data, &f); err != nil {
+			return nil, fmt.Errorf("fixture %s: %w", filepath.Base(name), err)
+		}
+		fixtures = append(fixtures, f)
+	}
+	return fixtures, nil
+}
+
+// FixtureTransport is an http.RoundTripper answering requests from fixtures
+//
+// Like the Replayer, it answers per request path in fixture order, whatever the query (polls
+// ask for a different cursor on every run); the last fixture of a path is repeated once the
+// others are used. A request without a fixture fails, so a test notices calls the corpus lacks.
+type FixtureTransport struct {
+	mu    sync.Mutex
+	paths map[string][]Fixture
+}
+
+// NewFixtureTransport answers requests with fixtures
+func NewFixtureTransport(fixtures []Fixture) *FixtureTransport {
+	t := &FixtureTransport{paths: make(map[string][]Fixture)}
+	for _, f := range fixtures {
+		path, _, _ := strings.Cut(f.URL, "?")
+		key := f.Method + " " + path
+		t.paths[key] = append(t.paths[key], f)
+	}
+	return t
+}
+
+func (t *FixtureTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	key := req.Method + " " + req.URL.Path
+	t.mu.Lock()
+	queue := t.paths[key]
+	if len(queue) == 0 {
+		t.mu.Unlock()
+		return nil, fmt.Errorf("no fixture for %s", key)
+	}
+	f := queue[0]
+	if len(queue) > 1 {
+		t.paths[key] = queue[1:]
+	}
+	t.mu.Unlock()
+
+	if f.Error != "" && f.Status == 0 {
+		return nil, errors.New(f.Error)
+	}
+	header := make(http.Header, len(f.ResponseHeaders))
+	for name, values := range f.ResponseHeaders {
+		header[name] = append([]string(nil), values...)
+	}
+	// Lengths of the original body no longer hold once sanitized
+	header.Del("Content-Length")
+	return &http.Response{
+		Status:        fmt.Sprintf("%d %s", f.Status, http.StatusText(f.Status)),
+		StatusCode:    f.Status,
+		Proto:         "HTTP/1.1",
+		ProtoMajor:    1,
+		ProtoMinor:    1,
+		Header:        header,
+		Body:          io.NopCloser(strings.NewReader(f.Body)),
+		ContentLength: int64(len(f.Body)),
+		Request:       req,
+	}, nil
+}
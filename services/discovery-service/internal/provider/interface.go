@@ -15,4 +15,13 @@ type Provider interface {
 	// GetEmails retrieves emails for a given user, filtered by receivedAfter timestamp
 	// orderBy specifies the sort order (e.g., "received_at")
 	GetEmails(userID uuid.UUID, receivedAfter time.Time, orderBy string) ([]models.ProviderEmail, error)
+
+	// GetEmail retrieves a single email with its full body, regardless of the ingest body mode
+	GetEmail(userID uuid.UUID, messageID string) (models.ProviderEmail, error)
+}
+
+// FormatProvider is implemented by providers that can fetch emails in another format than the
+// configured one (EmailFormatFull or EmailFormatMetadata), e.g. for users under a policy
+type FormatProvider interface {
+	GetEmailsFormat(userID uuid.UUID, receivedAfter time.Time, orderBy, format string) ([]models.ProviderEmail, error)
 }
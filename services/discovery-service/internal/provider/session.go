@@ -0,0 +1,300 @@
+package provider
+
+import (
+	"bufio"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"sync"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/spf13/viper"
+	"github.com/stoik/vigil/internal/models"
+)
+
+// Recorded operations
+const (
+	OpGetUsers  = "get_users"
+	OpGetEmails = "get_emails"
+	OpGetEmail  = "get_email"
+)
+
+// errorKinds maps error kinds to the names they are recorded under
+var errorKinds = map[error]string{
+	ErrRateLimited:   "rate_limited",
+	ErrUnauthorized:  "unauthorized",
+	ErrUserNotFound:  "user_not_found",
+	ErrEmailNotFound: "email_not_found",
+	ErrTransient:     "transient",
+}
+
+// Interaction is one recorded provider call and its outcome (one JSON line of a session file)
+type Interaction struct {
+	Offset        time.Duration          `json:"offset"` // When the call returned, since the session started
+	Op            string                 `json:"op"`
+	TenantID      *uuid.UUID             `json:"tenant_id,omitempty"`
+	UserID        *uuid.UUID             `json:"user_id,omitempty"`
+	ReceivedAfter *time.Time             `json:"received_after,omitempty"`
+	MessageID     string                 `json:"message_id,omitempty"`
+	Users         []models.ProviderUser  `json:"users,omitempty"`
+	Emails        []models.ProviderEmail `json:"emails,omitempty"`
+	Email         *models.ProviderEmail  `json:"email,omitempty"`
+	Error         *RecordedError         `json:"error,omitempty"`
+}
+
+// RecordedError is a provider failure as recorded; Kind is "" for untyped errors
+type RecordedError struct {
+	Kind       string        `json:"kind,omitempty"`
+	StatusCode int           `json:"status_code,omitempty"`
+	RetryAfter time.Duration `json:"retry_after,omitempty"`
+	Message    string        `json:"message"`
+}
+
+func recordError(err error) *RecordedError {
+	if err == nil {
+		return nil
+	}
+	var pErr *Error
+	if errors.As(err, &pErr) {
+		return &RecordedError{Kind: errorKinds[pErr.Kind], StatusCode: pErr.StatusCode, RetryAfter: pErr.RetryAfter, Message: pErr.Message}
+	}
+	return &RecordedError{Message: err.Error()}
+}
+
+// err rebuilds the error, so the scheduler applies the same policy as during the recording
+func (r *RecordedError) err() error {
+	if r == nil {
+		return nil
+	}
+	for kind, name := range errorKinds {
+		if name == r.Kind && name != "" {
+			return &Error{Kind: kind, StatusCode: r.StatusCode, RetryAfter: r.RetryAfter, Message: r.Message}
+		}
+	}
+	return errors.New(r.Message)
+}
+
+// Recorder wraps a provider and writes every call and its outcome to a session file
+type Recorder struct {
+	p       Provider
+	started time.Time
+
+	mu  sync.Mutex
+	enc *json.Encoder
+	err error // First write error
+}
+
+// NewRecorder records calls to p as JSON lines written to w
+func NewRecorder(p Provider, w io.Writer) *Recorder {
+	return &Recorder{p: p, started: time.Now(), enc: json.NewEncoder(w)}
+}
+
+func (r *Recorder) record(in Interaction, err error) {
+	in.Offset = time.Since(r.started)
+	in.Error = recordError(err)
+
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if werr := r.enc.Encode(in); werr != nil && r.err == nil {
+		r.err = werr
+	}
+}
+
+func (r *Recorder) GetUsers(tenantID uuid.UUID) ([]models.ProviderUser, error) {
+	users, err := r.p.GetUsers(tenantID)
+	r.record(Interaction{Op: OpGetUsers, TenantID: &tenantID, Users: users}, err)
+	return users, err
+}
+
+func (r *Recorder) GetEmails(userID uuid.UUID, receivedAfter time.Time, orderBy string) ([]models.ProviderEmail, error) {
+	emails, err := r.p.GetEmails(userID, receivedAfter, orderBy)
+	r.record(Interaction{Op: OpGetEmails, UserID: &userID, ReceivedAfter: &receivedAfter, Emails: emails}, err)
+	return emails, err
+}
+
+func (r *Recorder) GetEmail(userID uuid.UUID, messageID string) (models.ProviderEmail, error) {
+	email, err := r.p.GetEmail(userID, messageID)
+	in := Interaction{Op: OpGetEmail, UserID: &userID, MessageID: messageID}
+	if err == nil {
+		in.Email = &email
+	}
+	r.record(in, err)
+	return email, err
+}
+
+// ReplayOptions tune a replay
+type ReplayOptions struct {
+	// Speed replays at the recorded pace scaled by this factor (1 = real time, 10 = ten times faster)
+	// 0 answers every call immediately
+	Speed float64
+}
+
+// Replayer implements Provider by answering calls with a recorded session
+//
+// Responses are replayed per key in recorded order: each GetUsers call for a tenant gets the next
+// recorded directory (the last one once exhausted), each GetEmails call for a user gets the next
+// recorded page (an empty page once exhausted), whatever receivedAfter is asked for. Duplicates,
+// late arrivals and errors captured in the session therefore reach the scheduler and dedup
+// exactly as they did live. GetEmail returns the recorded email with that message ID.
+type Replayer struct {
+	speed   float64
+	started time.Time
+	sleep   func(time.Duration)
+
+	// GetEmail lookups by message ID, read-only after loading
+	fetched map[string]Interaction
+	polled  map[string]models.ProviderEmail
+
+	mu     sync.Mutex
+	users  map[uuid.UUID][]Interaction
+	emails map[uuid.UUID][]Interaction
+}
+
+// NewReplayer loads a session recorded by Recorder
+func NewReplayer(r io.Reader, opts ReplayOptions) (*Replayer, error) {
+	p := &Replayer{
+		speed:   opts.Speed,
+		sleep:   time.Sleep,
+		users:   make(map[uuid.UUID][]Interaction),
+		emails:  make(map[uuid.UUID][]Interaction),
+		fetched: make(map[string]Interaction),
+		polled:  make(map[string]models.ProviderEmail),
+	}
+
+	scanner := bufio.NewScanner(r)
+	scanner.Buffer(make([]byte, 0, 64*1024), 64*1024*1024) // Pages with bodies make long lines
+	for line := 1; scanner.Scan(); line++ {
+		if len(scanner.Bytes()) == 0 {
+			continue
+		}
+		var in Interaction
+		if err := json.Unmarshal(scanner.Bytes(), &in); err != nil {
+			return nil, fmt.Errorf("session line %d: %w", line, err)
+		}
+		switch {
+		case in.Op == OpGetUsers && in.TenantID != nil:
+			p.users[*in.TenantID] = append(p.users[*in.TenantID], in)
+		case in.Op == OpGetEmails && in.UserID != nil:
+			p.emails[*in.UserID] = append(p.emails[*in.UserID], in)
+			for _, email := range in.Emails {
+				p.polled[email.MessageID] = email
+			}
+		case in.Op == OpGetEmail && in.UserID != nil && (in.Email != nil || in.Error != nil):
+			p.fetched[in.MessageID] = in
+		default:
+			return nil, fmt.Errorf("session line %d: invalid interaction %q", line, in.Op)
+		}
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, fmt.Errorf("failed to read session: %w", err)
+	}
+
+	p.started = time.Now()
+	return p, nil
+}
+
+// pace waits until the recorded offset of in, scaled by speed
+func (p *Replayer) pace(in Interaction) {
+	if p.speed <= 0 {
+		return
+	}
+	due := p.started.Add(time.Duration(float64(in.Offset) / p.speed))
+	if wait := time.Until(due); wait > 0 {
+		p.sleep(wait)
+	}
+}
+
+func (p *Replayer) GetUsers(tenantID uuid.UUID) ([]models.ProviderUser, error) {
+	p.mu.Lock()
+	queue := p.users[tenantID]
+	if len(queue) == 0 {
+		p.mu.Unlock()
+		return nil, &Error{Kind: ErrUnauthorized, Message: fmt.Sprintf("tenant %s not in the replayed session", tenantID)}
+	}
+	in := queue[0]
+	if len(queue) > 1 {
+		p.users[tenantID] = queue[1:]
+	}
+	p.mu.Unlock()
+
+	p.pace(in)
+	return in.Users, in.Error.err()
+}
+
+func (p *Replayer) GetEmails(userID uuid.UUID, receivedAfter time.Time, orderBy string) ([]models.ProviderEmail, error) {
+	p.mu.Lock()
+	queue := p.emails[userID]
+	if len(queue) == 0 {
+		p.mu.Unlock()
+		return []models.ProviderEmail{}, nil
+	}
+	in := queue[0]
+	p.emails[userID] = queue[1:]
+	p.mu.Unlock()
+
+	p.pace(in)
+	return in.Emails, in.Error.err()
+}
+
+func (p *Replayer) GetEmail(userID uuid.UUID, messageID string) (models.ProviderEmail, error) {
+	if in, ok := p.fetched[messageID]; ok {
+		if in.Error != nil {
+			return models.ProviderEmail{}, in.Error.err()
+		}
+		return *in.Email, nil
+	}
+	// Not fetched during the recording: the polled copy is all there is
+	if email, ok := p.polled[messageID]; ok {
+		return email, nil
+	}
+	return models.ProviderEmail{}, &Error{Kind: ErrEmailNotFound, Message: fmt.Sprintf("message %s not in the replayed session", messageID)}
+}
+
+// Open creates the configured provider: a live client, or a Replayer with provider.type "replay"
+// (provider.replay_file, provider.replay_speed). With provider.record, calls are also recorded to
+// that file, and with provider.capture_dir a live client's HTTP exchanges are captured there;
+// closer flushes and closes the recording and capture.
+func Open() (p Provider, closer func() error, err error) {
+	closer = func() error { return nil }
+
+	if viper.GetString("provider.type") == "replay" {
+		f, err := os.Open(viper.GetString("provider.replay_file"))
+		if err != nil {
+			return nil, nil, fmt.Errorf("failed to open replay session: %w", err)
+		}
+		defer f.Close()
+		if p, err = NewReplayer(f, ReplayOptions{Speed: viper.GetFloat64("provider.replay_speed")}); err != nil {
+			return nil, nil, err
+		}
+	} else {
+		p = NewProvider()
+		if dir := viper.GetString("provider.capture_dir"); dir != "" {
+			if closer, err = openCapture(p, dir); err != nil {
+				return nil, nil, err
+			}
+		}
+	}
+
+	path := viper.GetString("provider.record")
+	if path == "" {
+		return p, closer, nil
+	}
+	// Sessions hold mailbox addresses and email content
+	f, err := os.OpenFile(path, os.O_WRONLY|os.O_CREATE|os.O_TRUNC, 0600)
+	if err != nil {
+		return nil, nil, fmt.Errorf("failed to create session recording: %w", err)
+	}
+	w := bufio.NewWriter(f)
+	recorder := NewRecorder(p, w)
+	closeCapture := closer
+	closer = func() error {
+		// Holding the recorder's lock, no record is half written
+		recorder.mu.Lock()
+		defer recorder.mu.Unlock()
+		return errors.Join(recorder.err, w.Flush(), f.Close(), closeCapture())
+	}
+	return recorder, closer, nil
+}
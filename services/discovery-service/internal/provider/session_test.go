@@ -0,0 +1,149 @@
+package provider
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/spf13/viper"
+	"github.com/stoik/vigil/internal/models"
+)
+
+func TestRecordAndReplay(t *testing.T) {
+	s, url := newMockServer(t, 3)
+	viper.Set("provider.api_url", url)
+	t.Cleanup(viper.Reset)
+
+	var session bytes.Buffer
+	recorder := NewRecorder(NewGoogleProvider(), &session)
+
+	users, err := recorder.GetUsers(s.TenantID())
+	if err != nil || len(users) != 3 {
+		t.Fatalf("GetUsers() = %d users, %v", len(users), err)
+	}
+	since := time.Now().Add(-time.Hour)
+	for len(s.GetGroundTruth(users[0].ID, since, time.Now().Add(time.Second)).Emails) == 0 {
+		s.GenerateEmails()
+	}
+	// The same page twice, as an overlapping poll would see it
+	first, _ := recorder.GetEmails(users[0].ID, since, "received_at")
+	second, _ := recorder.GetEmails(users[0].ID, since, "received_at")
+	full, _ := recorder.GetEmail(users[0].ID, first[0].MessageID)
+	// A throttled user, recorded into the same session
+	throttled := uuid.New()
+	rateLimited := &Error{Kind: ErrRateLimited, StatusCode: 429, RetryAfter: 30 * time.Second, Message: "slow down"}
+	NewRecorder(failingProvider{rateLimited}, &session).GetEmails(throttled, since, "received_at")
+
+	replay, err := NewReplayer(bytes.NewReader(session.Bytes()), ReplayOptions{})
+	if err != nil {
+		t.Fatalf("NewReplayer: %v", err)
+	}
+
+	// Answers come back in recorded order whatever cursor is asked for
+	for i, want := range [][]models.ProviderEmail{first, second, {}} {
+		got, err := replay.GetEmails(users[0].ID, time.Now(), "received_at")
+		if err != nil || len(got) != len(want) || (len(want) > 0 && !reflect.DeepEqual(got[0], want[0])) {
+			t.Errorf("replayed page %d = %d emails, %v; want %d", i, len(got), err, len(want))
+		}
+	}
+	replayedUsers, err := replay.GetUsers(s.TenantID())
+	if err != nil || !reflect.DeepEqual(replayedUsers, users) {
+		t.Errorf("replayed users = %v, %v", replayedUsers, err)
+	}
+	// The last directory stays current once the recorded ones are exhausted
+	if again, _ := replay.GetUsers(s.TenantID()); len(again) != 3 {
+		t.Errorf("exhausted GetUsers = %d users, want the last directory", len(again))
+	}
+	if _, err := replay.GetUsers(uuid.New()); !errors.Is(err, ErrUnauthorized) {
+		t.Errorf("GetUsers(unknown tenant) = %v, want ErrUnauthorized", err)
+	}
+
+	// Errors keep their kind, so the scheduler applies the same policy
+	if _, err := replay.GetEmails(throttled, time.Now(), "received_at"); !errors.Is(err, ErrRateLimited) || RetryAfter(err) != 30*time.Second {
+		t.Errorf("replayed error = %v (retry after %v), want ErrRateLimited after 30s", err, RetryAfter(err))
+	}
+
+	if got, err := replay.GetEmail(users[0].ID, first[0].MessageID); err != nil || got.Body != full.Body {
+		t.Errorf("replayed GetEmail = %v, %v", got.MessageID, err)
+	}
+	// Polled but never fetched: the polled copy is returned
+	if len(first) > 1 {
+		if got, err := replay.GetEmail(users[0].ID, first[1].MessageID); err != nil || got.MessageID != first[1].MessageID {
+			t.Errorf("replayed polled-only GetEmail = %v, %v", got.MessageID, err)
+		}
+	}
+	if _, err := replay.GetEmail(users[0].ID, uuid.NewString()); !errors.Is(err, ErrEmailNotFound) {
+		t.Errorf("GetEmail(unknown) = %v, want ErrEmailNotFound", err)
+	}
+}
+
+func TestReplayPacing(t *testing.T) {
+	userID := uuid.New()
+	var session bytes.Buffer
+	enc := json.NewEncoder(&session)
+	for _, offset := range []time.Duration{10 * time.Second, 30 * time.Second} {
+		enc.Encode(Interaction{Offset: offset, Op: OpGetEmails, UserID: &userID})
+	}
+
+	replay, err := NewReplayer(&session, ReplayOptions{Speed: 10})
+	if err != nil {
+		t.Fatalf("NewReplayer: %v", err)
+	}
+	var waits []time.Duration
+	replay.sleep = func(d time.Duration) { waits = append(waits, d) }
+
+	replay.GetEmails(userID, time.Time{}, "received_at")
+	replay.GetEmails(userID, time.Time{}, "received_at")
+	if len(waits) != 2 || waits[0] > time.Second || waits[0] < 900*time.Millisecond || waits[1] > 3*time.Second || waits[1] < 2900*time.Millisecond {
+		t.Errorf("waits = %v, want about 1s and 3s at 10x", waits)
+	}
+
+	if _, err := NewReplayer(strings.NewReader(`{"op":"delete_user"}`), ReplayOptions{}); err == nil {
+		t.Error("NewReplayer accepted an unknown operation")
+	}
+}
+
+func TestOpenRecordsAndReplays(t *testing.T) {
+	s, url := newMockServer(t, 2)
+	path := filepath.Join(t.TempDir(), "session.jsonl")
+	viper.Set("provider.api_url", url)
+	viper.Set("provider.record", path)
+	t.Cleanup(viper.Reset)
+
+	p, closer, err := Open()
+	if err != nil {
+		t.Fatalf("Open: %v", err)
+	}
+	users, _ := p.GetUsers(s.TenantID())
+	if err := closer(); err != nil {
+		t.Fatalf("close: %v", err)
+	}
+
+	viper.Set("provider.record", "")
+	viper.Set("provider.type", "replay")
+	viper.Set("provider.replay_file", path)
+	p, _, err = Open()
+	if err != nil {
+		t.Fatalf("Open replay: %v", err)
+	}
+	if replayed, err := p.GetUsers(s.TenantID()); err != nil || !reflect.DeepEqual(replayed, users) {
+		t.Errorf("replayed users = %v, %v; want %v", replayed, err, users)
+	}
+}
+
+// failingProvider fails every call with err
+type failingProvider struct{ err error }
+
+func (f failingProvider) GetUsers(uuid.UUID) ([]models.ProviderUser, error) { return nil, f.err }
+func (f failingProvider) GetEmails(uuid.UUID, time.Time, string) ([]models.ProviderEmail, error) {
+	return nil, f.err
+}
+func (f failingProvider) GetEmail(uuid.UUID, string) (models.ProviderEmail, error) {
+	return models.ProviderEmail{}, f.err
+}
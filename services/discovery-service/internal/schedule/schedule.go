@@ -0,0 +1,83 @@
+// Package schedule resolves the tenant's time zone and the local times of day scheduled jobs
+// run at (digests, retention purges, maintenance windows)
+package schedule
+
+import (
+	"fmt"
+	"time"
+	_ "time/tzdata" // Tenant time zones load in containers without a zoneinfo database
+
+	"github.com/spf13/viper"
+)
+
+// Location returns the tenant's time zone: the timezone setting (usually in
+// tenants/<tenant_id>.yaml), UTC when unset
+func Location() (*time.Location, error) {
+	return LoadLocation(viper.GetString("timezone"))
+}
+
+// LoadLocation loads an IANA time zone name ("Europe/Paris"); empty is UTC
+func LoadLocation(name string) (*time.Location, error) {
+	loc, err := time.LoadLocation(name)
+	if err != nil {
+		return nil, fmt.Errorf("invalid timezone %q (want an IANA name such as Europe/Paris): %w", name, err)
+	}
+	return loc, nil
+}
+
+// Clock is a local time of day
+type Clock struct {
+	Hour, Minute int
+}
+
+// ParseClock parses "HH:MM" (24-hour); empty is midnight
+func ParseClock(s string) (Clock, error) {
+	if s == "" {
+		return Clock{}, nil
+	}
+	t, err := time.Parse("15:04", s)
+	if err != nil {
+		return Clock{}, fmt.Errorf("invalid time of day %q (want HH:MM)", s)
+	}
+	return Clock{Hour: t.Hour(), Minute: t.Minute()}, nil
+}
+
+func (c Clock) String() string {
+	return fmt.Sprintf("%02d:%02d", c.Hour, c.Minute)
+}
+
+// On returns the clock time on t's calendar day in loc
+// On daylight saving changes, a time skipped by the clocks moving forward is moved forward by
+// the gap (02:30 becomes 03:30), and a time repeated by the clocks moving back is its later
+// occurrence, so it happens exactly once that day
+func (c Clock) On(t time.Time, loc *time.Location) time.Time {
+	t = t.In(loc)
+	at := time.Date(t.Year(), t.Month(), t.Day(), c.Hour, c.Minute, 0, 0, loc)
+	// time.Date may pick either occurrence of a repeated time: move to the later one, which
+	// follows the clocks moving back to a smaller offset
+	_, offset := at.Zone()
+	if _, after := at.Add(3 * time.Hour).Zone(); after < offset {
+		later := at.Add(time.Duration(offset-after) * time.Second).In(loc)
+		if later.Hour() == c.Hour && later.Minute() == c.Minute {
+			at = later
+		}
+	}
+	return at
+}
+
+// Next returns the first occurrence of the clock time after t
+func (c Clock) Next(t time.Time, loc *time.Location) time.Time {
+	at := c.On(t, loc)
+	if !at.After(t) {
+		day := t.In(loc)
+		// Noon is never skipped by a daylight saving change
+		at = c.On(time.Date(day.Year(), day.Month(), day.Day()+1, 12, 0, 0, 0, loc), loc)
+	}
+	return at
+}
+
+// StartOfDay returns the first instant of t's calendar day in loc
+func StartOfDay(t time.Time, loc *time.Location) time.Time {
+	t = t.In(loc)
+	return time.Date(t.Year(), t.Month(), t.Day(), 0, 0, 0, 0, loc)
+}
@@ -0,0 +1,100 @@
+package schedule
+
+import (
+	"testing"
+	"time"
+
+	"github.com/spf13/viper"
+)
+
+func TestLocation(t *testing.T) {
+	t.Cleanup(viper.Reset)
+
+	if loc, err := Location(); err != nil || loc != time.UTC {
+		t.Errorf("Location() = %v, %v; want UTC when unset", loc, err)
+	}
+	viper.Set("timezone", "Europe/Paris")
+	if loc, err := Location(); err != nil || loc.String() != "Europe/Paris" {
+		t.Errorf("Location() = %v, %v; want Europe/Paris", loc, err)
+	}
+	viper.Set("timezone", "Mars/Olympus")
+	if _, err := Location(); err == nil {
+		t.Error("Location() accepted an unknown time zone")
+	}
+}
+
+func TestParseClock(t *testing.T) {
+	for _, s := range []string{"", "07:00", "23:59"} {
+		if _, err := ParseClock(s); err != nil {
+			t.Errorf("ParseClock(%q): %v", s, err)
+		}
+	}
+	if c, _ := ParseClock("7:05"); c != (Clock{7, 5}) || c.String() != "07:05" {
+		t.Errorf("ParseClock(7:05) = %v", c)
+	}
+	for _, s := range []string{"24:00", "7am", "07:60"} {
+		if _, err := ParseClock(s); err == nil {
+			t.Errorf("ParseClock(%q) succeeded", s)
+		}
+	}
+}
+
+func TestClockDST(t *testing.T) {
+	paris, _ := LoadLocation("Europe/Paris")
+	newYork, _ := LoadLocation("America/New_York")
+	utc := func(s string) time.Time {
+		ts, err := time.Parse("2006-01-02 15:04", s)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return ts
+	}
+
+	tests := []struct {
+		name  string
+		clock Clock
+		day   string
+		loc   *time.Location
+		want  string // UTC
+	}{
+		{"winter", Clock{7, 0}, "2024-03-30 12:00", paris, "2024-03-30 06:00"},
+		{"summer", Clock{7, 0}, "2024-03-31 12:00", paris, "2024-03-31 05:00"},
+		{"skipped by spring forward", Clock{2, 30}, "2024-03-31 12:00", paris, "2024-03-31 01:30"},     // 03:30 CEST
+		{"repeated by fall back", Clock{2, 30}, "2024-10-27 12:00", paris, "2024-10-27 01:30"},         // 02:30 CET
+		{"repeated by fall back, west", Clock{1, 30}, "2024-11-03 12:00", newYork, "2024-11-03 06:30"}, // 01:30 EST
+		{"local day differs from UTC day", Clock{0, 30}, "2024-06-30 23:00", paris, "2024-06-30 22:30"},
+	}
+	for _, tt := range tests {
+		if got := tt.clock.On(utc(tt.day), tt.loc); !got.Equal(utc(tt.want)) {
+			t.Errorf("%s: %v on %s = %v, want %s UTC", tt.name, tt.clock, tt.day, got.UTC(), tt.want)
+		}
+	}
+
+	// Daily at 07:00 keeps the local time across the change, 23 hours apart
+	first := Clock{7, 0}.Next(utc("2024-03-30 06:00"), paris)
+	second := Clock{7, 0}.Next(first, paris)
+	if !first.Equal(utc("2024-03-31 05:00")) || second.Sub(first) != 24*time.Hour {
+		t.Errorf("Next = %v then %v", first.UTC(), second.UTC())
+	}
+	if next := (Clock{7, 0}).Next(utc("2024-03-30 05:59"), paris); !next.Equal(utc("2024-03-30 06:00")) {
+		t.Errorf("Next before 07:00 = %v, want the same day", next.UTC())
+	}
+	// A skipped time still happens once on the day of the change
+	if next := (Clock{2, 30}).Next(utc("2024-03-30 23:00"), paris); !next.Equal(utc("2024-03-31 01:30")) {
+		t.Errorf("Next 02:30 on spring forward = %v", next.UTC())
+	}
+}
+
+func TestStartOfDay(t *testing.T) {
+	paris, _ := LoadLocation("Europe/Paris")
+	// 00:30 in Paris is still the previous day in UTC
+	now := time.Date(2024, 3, 6, 23, 30, 0, 0, time.UTC)
+	if got := StartOfDay(now, paris); !got.Equal(time.Date(2024, 3, 6, 23, 0, 0, 0, time.UTC)) {
+		t.Errorf("StartOfDay = %v", got.UTC())
+	}
+	// The day clocks move back lasts 25 hours
+	day := StartOfDay(time.Date(2024, 10, 27, 12, 0, 0, 0, time.UTC), paris)
+	if next := StartOfDay(day.Add(25*time.Hour-time.Second), paris); !next.Equal(day) {
+		t.Errorf("StartOfDay at the end of a 25-hour day = %v, want %v", next, day)
+	}
+}
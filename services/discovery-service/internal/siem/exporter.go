@@ -0,0 +1,169 @@
+package siem
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log"
+	"net/http"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/jackc/pgx/v5"
+	"github.com/spf13/viper"
+	"github.com/stoik/vigil/services/discovery-service/internal/db"
+	"github.com/stoik/vigil/services/discovery-service/internal/events"
+)
+
+const (
+	DefaultInterval  = 10 * time.Second
+	DefaultBatchSize = 500
+)
+
+// Exporter tails the events table and ships events to the tenant's SIEM
+// The events cursor is persisted only after a batch is accepted, so delivery is at-least-once
+type Exporter struct {
+	tenantID  uuid.UUID
+	name      string
+	format    string
+	sink      Sink
+	interval  time.Duration
+	batchSize int
+}
+
+// NewExporter creates an exporter from the siem.* configuration
+// Returns nil when no SIEM sink is configured
+func NewExporter(tenantID uuid.UUID) (*Exporter, error) {
+	sinkType := viper.GetString("siem.sink")
+	if sinkType == "" {
+		return nil, nil
+	}
+
+	url := viper.GetString("siem.url")
+	if url == "" {
+		return nil, fmt.Errorf("siem.url is required for siem.sink %q", sinkType)
+	}
+
+	format := viper.GetString("siem.format")
+	if format != FormatECS && format != FormatCEF {
+		return nil, fmt.Errorf("invalid siem.format %q (want %q or %q)", format, FormatECS, FormatCEF)
+	}
+
+	client := &http.Client{Timeout: 30 * time.Second}
+	var sink Sink
+	switch sinkType {
+	case SinkElasticsearch:
+		sink = &elasticsearchSink{client: client, url: url, apiKey: viper.GetString("siem.token"), index: viper.GetString("siem.index")}
+	case SinkSplunk:
+		sink = &splunkSink{client: client, url: url, token: viper.GetString("siem.token"), index: viper.GetString("siem.index")}
+	default:
+		return nil, fmt.Errorf("invalid siem.sink %q (want %q or %q)", sinkType, SinkElasticsearch, SinkSplunk)
+	}
+
+	interval := viper.GetDuration("siem.interval")
+	if interval <= 0 {
+		interval = DefaultInterval
+	}
+	batchSize := viper.GetInt("siem.batch_size")
+	if batchSize <= 0 {
+		batchSize = DefaultBatchSize
+	}
+
+	return &Exporter{
+		tenantID:  tenantID,
+		name:      sinkType,
+		format:    format,
+		sink:      sink,
+		interval:  interval,
+		batchSize: batchSize,
+	}, nil
+}
+
+// Run exports events until ctx is cancelled
+func (e *Exporter) Run(ctx context.Context) {
+	log.Printf("SIEM export to %s (%s) started", e.name, e.format)
+
+	ticker := time.NewTicker(e.interval)
+	defer ticker.Stop()
+
+	for {
+		// Drain the backlog before waiting for the next tick
+		for {
+			n, err := e.exportOnce(ctx)
+			if err != nil {
+				if ctx.Err() == nil {
+					log.Printf("SIEM export to %s failed: %v", e.name, err)
+				}
+				break
+			}
+			if n < e.batchSize {
+				break
+			}
+		}
+
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+	}
+}
+
+// exportOnce ships one batch of events and returns how many were sent
+func (e *Exporter) exportOnce(ctx context.Context) (int, error) {
+	cursor, err := loadCursor(ctx, e.name)
+	if err != nil {
+		return 0, fmt.Errorf("failed to load cursor: %w", err)
+	}
+
+	page, err := events.List(ctx, cursor, e.batchSize)
+	if err != nil {
+		return 0, err
+	}
+	if len(page.Events) == 0 {
+		return 0, nil
+	}
+
+	records := make([]Record, len(page.Events))
+	for i, ev := range page.Events {
+		records[i] = e.record(ev)
+	}
+	if err := e.sink.Send(ctx, records); err != nil {
+		return 0, err
+	}
+
+	if err := saveCursor(ctx, e.name, page.Cursor); err != nil {
+		return 0, fmt.Errorf("failed to save cursor: %w", err)
+	}
+	return len(records), nil
+}
+
+// record formats an event for the sink
+func (e *Exporter) record(ev events.Event) Record {
+	r := Record{Time: float64(ev.At.UnixMilli()) / 1000}
+	if e.format == FormatCEF {
+		r.Doc = ToCEF(ev, e.tenantID)
+	} else {
+		r.Doc = ToECS(ev, e.tenantID)
+	}
+	return r
+}
+
+// loadCursor returns the persisted events cursor of an export ("" if none yet)
+func loadCursor(ctx context.Context, name string) (string, error) {
+	var cursor string
+	err := db.Pool.QueryRow(ctx, `SELECT cursor FROM siem_cursors WHERE name = $1`, name).Scan(&cursor)
+	if errors.Is(err, pgx.ErrNoRows) {
+		return "", nil
+	}
+	return cursor, err
+}
+
+func saveCursor(ctx context.Context, name, cursor string) error {
+	_, err := db.Pool.Exec(ctx, `
+		INSERT INTO siem_cursors (name, cursor, updated_at) VALUES ($1, $2, NOW())
+		ON CONFLICT (name) DO UPDATE SET cursor = EXCLUDED.cursor, updated_at = EXCLUDED.updated_at`,
+		name, cursor,
+	)
+	return err
+}
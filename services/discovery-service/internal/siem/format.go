@@ -0,0 +1,152 @@
+package siem
+
+import (
+	"encoding/json"
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/stoik/vigil/services/discovery-service/internal/events"
+)
+
+// Output formats
+const (
+	FormatECS = "ecs" // Elastic Common Schema JSON documents
+	FormatCEF = "cef" // ArcSight Common Event Format lines
+)
+
+const (
+	vendor  = "Stoik"
+	product = "Vigil"
+	version = "1.0"
+)
+
+// eventMeta describes how a vigil event type maps onto ECS categorization and CEF severity
+type eventMeta struct {
+	name     string
+	kind     string
+	category string
+	ecsType  string
+	severity int // CEF severity, 0-10
+}
+
+var eventMetas = map[string]eventMeta{
+	events.TypeEmailDiscovered: {name: "Email discovered", kind: "event", category: "email", ecsType: "info", severity: 1},
+	events.TypeEmailDetected:   {name: "Email detection", kind: "alert", category: "email", ecsType: "indicator", severity: 8},
+	events.TypeUserAdded:       {name: "Mailbox added", kind: "event", category: "iam", ecsType: "creation", severity: 3},
+	events.TypeUserRemoved:     {name: "Mailbox removed", kind: "event", category: "iam", ecsType: "deletion", severity: 3},
+	events.TypePollCapped:      {name: "Poll result capped", kind: "alert", category: "email", ecsType: "info", severity: 6},
+	events.TypeEmailReported:   {name: "Email reported by user", kind: "alert", category: "email", ecsType: "indicator", severity: 5},
+	events.TypeServiceStopped:  {name: "Discovery service stopped", kind: "event", category: "process", ecsType: "end", severity: 2},
+}
+
+func metaFor(eventType string) eventMeta {
+	if meta, ok := eventMetas[eventType]; ok {
+		return meta
+	}
+	return eventMeta{name: eventType, kind: "event", category: "email", ecsType: "info", severity: 1}
+}
+
+// eventData is the subset of event payload fields the mappings understand
+type eventData struct {
+	Email        string     `json:"email"`
+	ReceivedAt   *time.Time `json:"received_at"`
+	SenderDomain string     `json:"sender_domain"`
+}
+
+func parseData(e events.Event) eventData {
+	var data eventData
+	if len(e.Data) > 0 {
+		json.Unmarshal(e.Data, &data)
+	}
+	return data
+}
+
+// ToECS maps an event to an Elastic Common Schema document
+// Fields without an ECS equivalent are kept under the custom "vigil" namespace
+func ToECS(e events.Event, tenantID uuid.UUID) map[string]any {
+	meta := metaFor(e.Type)
+	data := parseData(e)
+
+	doc := map[string]any{
+		"@timestamp": e.At.UTC().Format(time.RFC3339Nano),
+		"ecs":        map[string]any{"version": "8.11.0"},
+		"event": map[string]any{
+			"id":       strconv.FormatInt(e.ID, 10),
+			"kind":     meta.kind,
+			"category": []string{meta.category},
+			"type":     []string{meta.ecsType},
+			"action":   e.Type,
+			"dataset":  "vigil.discovery",
+			"module":   "vigil",
+			"severity": meta.severity,
+		},
+		"observer":     map[string]any{"vendor": vendor, "product": product},
+		"organization": map[string]any{"id": tenantID.String()},
+	}
+
+	if e.UserID != nil {
+		user := map[string]any{"id": e.UserID.String()}
+		if data.Email != "" {
+			user["email"] = data.Email
+		}
+		doc["user"] = user
+	}
+	if e.EmailID != nil {
+		email := map[string]any{"message_id": e.EmailID.String()}
+		if data.ReceivedAt != nil {
+			email["delivery_timestamp"] = data.ReceivedAt.UTC().Format(time.RFC3339Nano)
+		}
+		doc["email"] = email
+	}
+	if len(e.Data) > 0 {
+		doc["vigil"] = map[string]any{"data": e.Data}
+	}
+	return doc
+}
+
+// ToCEF maps an event to a CEF line
+func ToCEF(e events.Event, tenantID uuid.UUID) string {
+	meta := metaFor(e.Type)
+	data := parseData(e)
+
+	ext := []string{
+		"rt=" + strconv.FormatInt(e.At.UnixMilli(), 10),
+		"externalId=" + strconv.FormatInt(e.ID, 10),
+		"cs1Label=tenantId",
+		"cs1=" + cefExtension(tenantID.String()),
+	}
+	if e.UserID != nil {
+		ext = append(ext, "suid="+e.UserID.String())
+	}
+	if data.Email != "" {
+		ext = append(ext, "suser="+cefExtension(data.Email))
+	}
+	if e.EmailID != nil {
+		ext = append(ext, "cs2Label=messageId", "cs2="+e.EmailID.String())
+	}
+	if data.SenderDomain != "" {
+		ext = append(ext, "sntdom="+cefExtension(data.SenderDomain))
+	}
+
+	return fmt.Sprintf("CEF:0|%s|%s|%s|%s|%s|%d|%s",
+		cefHeader(vendor), cefHeader(product), cefHeader(version),
+		cefHeader(e.Type), cefHeader(meta.name), meta.severity,
+		strings.Join(ext, " "))
+}
+
+// cefHeader escapes a CEF header field (backslash and pipe)
+func cefHeader(s string) string {
+	s = strings.ReplaceAll(s, `\`, `\\`)
+	return strings.ReplaceAll(s, "|", `\|`)
+}
+
+// cefExtension escapes a CEF extension value (backslash, equals sign and newlines)
+func cefExtension(s string) string {
+	s = strings.ReplaceAll(s, `\`, `\\`)
+	s = strings.ReplaceAll(s, "=", `\=`)
+	s = strings.ReplaceAll(s, "\r", `\r`)
+	return strings.ReplaceAll(s, "\n", `\n`)
+}
@@ -0,0 +1,153 @@
+package siem
+
+import (
+	"bufio"
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/stoik/vigil/services/discovery-service/internal/events"
+)
+
+func testEvent() events.Event {
+	userID, emailID := uuid.New(), uuid.New()
+	return events.Event{
+		ID:      7,
+		At:      time.Date(2024, 3, 1, 12, 0, 0, 0, time.UTC),
+		Type:    events.TypeEmailDiscovered,
+		UserID:  &userID,
+		EmailID: &emailID,
+		Data:    json.RawMessage(`{"received_at":"2024-03-01T11:59:00Z","sender_domain":"evil.com"}`),
+	}
+}
+
+func TestToECS(t *testing.T) {
+	tenantID := uuid.New()
+	ev := testEvent()
+	doc := ToECS(ev, tenantID)
+
+	if doc["@timestamp"] != "2024-03-01T12:00:00Z" {
+		t.Errorf("@timestamp = %v", doc["@timestamp"])
+	}
+	event := doc["event"].(map[string]any)
+	if event["action"] != events.TypeEmailDiscovered || event["kind"] != "event" || event["id"] != "7" {
+		t.Errorf("event = %v", event)
+	}
+	email := doc["email"].(map[string]any)
+	if email["message_id"] != ev.EmailID.String() || email["delivery_timestamp"] != "2024-03-01T11:59:00Z" {
+		t.Errorf("email = %v", email)
+	}
+	if doc["organization"].(map[string]any)["id"] != tenantID.String() {
+		t.Errorf("organization = %v", doc["organization"])
+	}
+
+	// Must serialize cleanly
+	if _, err := json.Marshal(doc); err != nil {
+		t.Fatalf("marshal: %v", err)
+	}
+
+	ev.Type = events.TypeEmailDetected
+	if kind := ToECS(ev, tenantID)["event"].(map[string]any)["kind"]; kind != "alert" {
+		t.Errorf("detection kind = %v, want alert", kind)
+	}
+}
+
+func TestToCEF(t *testing.T) {
+	tenantID := uuid.New()
+	ev := testEvent()
+	line := ToCEF(ev, tenantID)
+
+	prefix := "CEF:0|Stoik|Vigil|1.0|email.discovered|Email discovered|1|"
+	if !strings.HasPrefix(line, prefix) {
+		t.Fatalf("line = %q, want prefix %q", line, prefix)
+	}
+	for _, want := range []string{"rt=1709294400000", "externalId=7", "cs1=" + tenantID.String(), "cs2=" + ev.EmailID.String(), "sntdom=evil.com"} {
+		if !strings.Contains(line, want) {
+			t.Errorf("line missing %q: %s", want, line)
+		}
+	}
+}
+
+func TestCEFEscaping(t *testing.T) {
+	if got := cefHeader(`a|b\c`); got != `a\|b\\c` {
+		t.Errorf("cefHeader = %q", got)
+	}
+	if got := cefExtension("a=b\\c\nd"); got != `a\=b\\c\nd` {
+		t.Errorf("cefExtension = %q", got)
+	}
+}
+
+func TestElasticsearchSink(t *testing.T) {
+	var lines []string
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path != "/_bulk" || r.Header.Get("Authorization") != "ApiKey secret" {
+			t.Errorf("unexpected request %s auth=%q", r.URL.Path, r.Header.Get("Authorization"))
+		}
+		scanner := bufio.NewScanner(r.Body)
+		for scanner.Scan() {
+			lines = append(lines, scanner.Text())
+		}
+		w.Write([]byte(`{"errors":false}`))
+	}))
+	defer srv.Close()
+
+	sink := &elasticsearchSink{client: srv.Client(), url: srv.URL, apiKey: "secret", index: "vigil-events"}
+	records := []Record{{Doc: ToECS(testEvent(), uuid.New())}, {Doc: "CEF:0|..."}}
+	if err := sink.Send(context.Background(), records); err != nil {
+		t.Fatalf("Send: %v", err)
+	}
+	if len(lines) != 4 || !strings.Contains(lines[0], `"_index":"vigil-events"`) || !strings.Contains(lines[3], `"message":"CEF:0|..."`) {
+		t.Errorf("bulk body = %q", lines)
+	}
+}
+
+func TestElasticsearchSinkDocumentErrors(t *testing.T) {
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Write([]byte(`{"errors":true}`))
+	}))
+	defer srv.Close()
+
+	sink := &elasticsearchSink{client: srv.Client(), url: srv.URL, index: "vigil-events"}
+	if err := sink.Send(context.Background(), []Record{{Doc: map[string]any{}}}); err == nil {
+		t.Error("expected an error for a bulk response with document errors")
+	}
+}
+
+func TestSplunkSink(t *testing.T) {
+	var events []map[string]any
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path != "/services/collector/event" || r.Header.Get("Authorization") != "Splunk hec-token" {
+			t.Errorf("unexpected request %s auth=%q", r.URL.Path, r.Header.Get("Authorization"))
+		}
+		dec := json.NewDecoder(r.Body)
+		for dec.More() {
+			var e map[string]any
+			if err := dec.Decode(&e); err != nil {
+				t.Fatalf("decode: %v", err)
+			}
+			events = append(events, e)
+		}
+		w.Write([]byte(`{"text":"Success","code":0}`))
+	}))
+	defer srv.Close()
+
+	sink := &splunkSink{client: srv.Client(), url: srv.URL, token: "hec-token"}
+	if err := sink.Send(context.Background(), []Record{{Time: 1709294400, Doc: "CEF:0|Stoik"}}); err != nil {
+		t.Fatalf("Send: %v", err)
+	}
+	if len(events) != 1 || events[0]["sourcetype"] != "cef" || events[0]["event"] != "CEF:0|Stoik" {
+		t.Errorf("events = %v", events)
+	}
+
+	srv.Config.Handler = http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusForbidden)
+	})
+	if err := sink.Send(context.Background(), []Record{{Doc: "x"}}); err == nil {
+		t.Error("expected an error for a rejected HEC request")
+	}
+}
@@ -0,0 +1,131 @@
+package siem
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"strings"
+)
+
+// Sink types
+const (
+	SinkElasticsearch = "elasticsearch"
+	SinkSplunk        = "splunk"
+)
+
+// Record is one formatted event ready to ship
+// Doc is an ECS document (FormatECS) or a CEF line (FormatCEF)
+type Record struct {
+	Time float64 // Unix seconds
+	Doc  any
+}
+
+// Sink ships batches of formatted events to a SIEM
+type Sink interface {
+	Send(ctx context.Context, records []Record) error
+}
+
+// elasticsearchSink indexes documents through the _bulk API
+type elasticsearchSink struct {
+	client *http.Client
+	url    string
+	apiKey string
+	index  string
+}
+
+func (s *elasticsearchSink) Send(ctx context.Context, records []Record) error {
+	var body bytes.Buffer
+	enc := json.NewEncoder(&body)
+	for _, r := range records {
+		doc := r.Doc
+		if line, ok := doc.(string); ok {
+			// CEF lines are indexed as the document message
+			doc = map[string]any{"message": line}
+		}
+		enc.Encode(map[string]any{"create": map[string]any{"_index": s.index}})
+		enc.Encode(doc)
+	}
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, strings.TrimRight(s.url, "/")+"/_bulk", &body)
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", "application/x-ndjson")
+	if s.apiKey != "" {
+		req.Header.Set("Authorization", "ApiKey "+s.apiKey)
+	}
+
+	resp, err := s.client.Do(req)
+	if err != nil {
+		return fmt.Errorf("elasticsearch bulk request failed: %w", err)
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		msg, _ := io.ReadAll(io.LimitReader(resp.Body, 1024))
+		return fmt.Errorf("elasticsearch bulk request returned %d: %s", resp.StatusCode, msg)
+	}
+
+	// A 200 can still carry per-document failures
+	var result struct {
+		Errors bool `json:"errors"`
+	}
+	if err := json.NewDecoder(resp.Body).Decode(&result); err != nil {
+		return fmt.Errorf("failed to decode elasticsearch bulk response: %w", err)
+	}
+	if result.Errors {
+		return fmt.Errorf("elasticsearch bulk request had document errors")
+	}
+	return nil
+}
+
+// splunkSink posts events to a Splunk HTTP Event Collector
+type splunkSink struct {
+	client *http.Client
+	url    string
+	token  string
+	index  string
+}
+
+func (s *splunkSink) Send(ctx context.Context, records []Record) error {
+	var body bytes.Buffer
+	enc := json.NewEncoder(&body)
+	for _, r := range records {
+		sourcetype := "vigil:ecs"
+		if _, ok := r.Doc.(string); ok {
+			sourcetype = "cef"
+		}
+		event := map[string]any{
+			"time":       r.Time,
+			"source":     "vigil",
+			"sourcetype": sourcetype,
+			"event":      r.Doc,
+		}
+		if s.index != "" {
+			event["index"] = s.index
+		}
+		enc.Encode(event)
+	}
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, strings.TrimRight(s.url, "/")+"/services/collector/event", &body)
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set("Authorization", "Splunk "+s.token)
+
+	resp, err := s.client.Do(req)
+	if err != nil {
+		return fmt.Errorf("splunk HEC request failed: %w", err)
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		msg, _ := io.ReadAll(io.LimitReader(resp.Body, 1024))
+		return fmt.Errorf("splunk HEC request returned %d: %s", resp.StatusCode, msg)
+	}
+	return nil
+}
@@ -0,0 +1,56 @@
+// Package telemetry keeps customer PII out of logs and metric labels
+package telemetry
+
+import (
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"sync/atomic"
+)
+
+// Prefix marks anonymized values so they are not mistaken for real ones
+const Prefix = "anon:"
+
+// ErrNoKey is returned when anonymization is enabled without a key
+var ErrNoKey = errors.New("telemetry.hmac_key is required when telemetry.anonymize is enabled")
+
+var key atomic.Pointer[[]byte] // nil = anonymization off
+
+// Configure turns anonymization on or off. The key is per deployment: the same value
+// always maps to the same token within a deployment (so logs stay correlatable), and
+// tokens cannot be reversed or matched across deployments without the key.
+func Configure(anonymize bool, hmacKey string) error {
+	if !anonymize {
+		key.Store(nil)
+		return nil
+	}
+	if hmacKey == "" {
+		return ErrNoKey
+	}
+	k := []byte(hmacKey)
+	key.Store(&k)
+	return nil
+}
+
+// Enabled reports whether values are anonymized
+func Enabled() bool {
+	return key.Load() != nil
+}
+
+// Redact returns value as it may appear in logs and metric labels: unchanged when
+// anonymization is off, otherwise an HMAC token. Use it for email addresses and subjects.
+func Redact(value string) string {
+	k := key.Load()
+	if k == nil {
+		return value
+	}
+	return Hash(*k, value)
+}
+
+// Hash computes the token of value under hmacKey ("anon:" + 16 hex chars)
+func Hash(hmacKey []byte, value string) string {
+	mac := hmac.New(sha256.New, hmacKey)
+	mac.Write([]byte(value))
+	return Prefix + hex.EncodeToString(mac.Sum(nil)[:8])
+}
@@ -0,0 +1,40 @@
+package telemetry
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestRedact(t *testing.T) {
+	t.Cleanup(func() { Configure(false, "") })
+
+	if err := Configure(false, ""); err != nil {
+		t.Fatalf("Configure(off): %v", err)
+	}
+	if got := Redact("jane@example.com"); got != "jane@example.com" {
+		t.Errorf("Redact() with anonymization off = %q, want the value unchanged", got)
+	}
+
+	if err := Configure(true, ""); err != ErrNoKey {
+		t.Errorf("Configure(on, no key) = %v, want ErrNoKey", err)
+	}
+
+	if err := Configure(true, "deployment-a"); err != nil {
+		t.Fatalf("Configure(on): %v", err)
+	}
+	token := Redact("jane@example.com")
+	if !strings.HasPrefix(token, Prefix) || strings.Contains(token, "jane") || len(token) != len(Prefix)+16 {
+		t.Errorf("Redact() = %q, want an %s token", token, Prefix)
+	}
+	if again := Redact("jane@example.com"); again != token {
+		t.Errorf("Redact() not stable: %q then %q", token, again)
+	}
+	if other := Redact("john@example.com"); other == token {
+		t.Errorf("different values share token %q", token)
+	}
+
+	// Another deployment's key yields unrelated tokens
+	if other := Hash([]byte("deployment-b"), "jane@example.com"); other == token {
+		t.Errorf("tokens match across keys: %q", token)
+	}
+}
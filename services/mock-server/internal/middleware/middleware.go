@@ -0,0 +1,111 @@
+package middleware
+
+import (
+	"fmt"
+	"log"
+	"net/http"
+	"runtime/debug"
+	"strings"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/google/uuid"
+)
+
+const (
+	RequestIDHeader = "X-Request-ID"
+	requestIDKey    = "request_id"
+)
+
+// Verbosity controls which requests are access-logged
+type Verbosity int
+
+const (
+	VerbosityQuiet Verbosity = iota // No access logs
+	VerbosityError                  // Only 4xx/5xx responses
+	VerbosityInfo                   // Every request
+	VerbosityDebug                  // Every request, with query string and client IP
+)
+
+// ParseVerbosity parses a LOG_LEVEL value ("quiet", "error", "info", "debug")
+func ParseVerbosity(level string) (Verbosity, error) {
+	switch strings.ToLower(level) {
+	case "quiet":
+		return VerbosityQuiet, nil
+	case "error":
+		return VerbosityError, nil
+	case "", "info":
+		return VerbosityInfo, nil
+	case "debug":
+		return VerbosityDebug, nil
+	default:
+		return VerbosityInfo, fmt.Errorf("unknown log level %q (use quiet, error, info or debug)", level)
+	}
+}
+
+// RequestID reuses the caller's X-Request-ID or generates one, and echoes it in the response
+func RequestID() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		requestID := c.GetHeader(RequestIDHeader)
+		if requestID == "" {
+			requestID = uuid.NewString()
+		}
+		c.Set(requestIDKey, requestID)
+		c.Header(RequestIDHeader, requestID)
+		c.Next()
+	}
+}
+
+// GetRequestID returns the request ID assigned by the RequestID middleware
+func GetRequestID(c *gin.Context) string {
+	return c.GetString(requestIDKey)
+}
+
+// AccessLog writes one key=value line per request, filtered by verbosity
+func AccessLog(verbosity Verbosity) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		start := time.Now()
+		c.Next()
+
+		status := c.Writer.Status()
+		if verbosity == VerbosityQuiet || (verbosity == VerbosityError && status < http.StatusBadRequest) {
+			return
+		}
+
+		line := fmt.Sprintf("request_id=%s method=%s path=%s status=%d duration=%s bytes=%d",
+			GetRequestID(c), c.Request.Method, c.Request.URL.Path, status,
+			time.Since(start).Round(time.Microsecond), c.Writer.Size())
+		if verbosity == VerbosityDebug {
+			line += fmt.Sprintf(" query=%q client_ip=%s", c.Request.URL.RawQuery, c.ClientIP())
+		}
+		if len(c.Errors) > 0 {
+			line += fmt.Sprintf(" errors=%q", c.Errors.String())
+		}
+		log.Print(line)
+	}
+}
+
+// Recovery turns handler panics into 500 responses tagged with the request ID
+func Recovery() gin.HandlerFunc {
+	return gin.CustomRecoveryWithWriter(nil, func(c *gin.Context, recovered interface{}) {
+		requestID := GetRequestID(c)
+		log.Printf("request_id=%s panic=%q\n%s", requestID, fmt.Sprint(recovered), debug.Stack())
+		c.AbortWithStatusJSON(http.StatusInternalServerError, gin.H{
+			"error":      "internal server error",
+			"request_id": requestID,
+		})
+	})
+}
+
+// MaxBodySize rejects request bodies larger than limit bytes
+func MaxBodySize(limit int64) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		if c.Request.ContentLength > limit {
+			c.AbortWithStatusJSON(http.StatusRequestEntityTooLarge, gin.H{"error": fmt.Sprintf("request body exceeds %d bytes", limit)})
+			return
+		}
+		// Also enforced while reading, for chunked bodies without Content-Length
+		c.Request.Body = http.MaxBytesReader(c.Writer, c.Request.Body, limit)
+		c.Next()
+	}
+}
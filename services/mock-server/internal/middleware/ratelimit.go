@@ -0,0 +1,64 @@
+package middleware
+
+import (
+	"fmt"
+	"math"
+	"net/http"
+	"sync"
+	"time"
+
+	"github.com/gin-gonic/gin"
+)
+
+const (
+	rateLimitIdleTTL    = 10 * time.Minute // Idle clients are forgotten after this long
+	rateLimitMaxClients = 10000            // Pruning kicks in above this many tracked clients
+)
+
+type clientBucket struct {
+	tokens float64
+	last   time.Time
+}
+
+// RateLimit allows each client IP rps requests per second with bursts of up to burst
+// Excess requests get 429 with a Retry-After header
+func RateLimit(rps float64, burst int) gin.HandlerFunc {
+	var mu sync.Mutex
+	clients := make(map[string]*clientBucket)
+
+	return func(c *gin.Context) {
+		now := time.Now()
+		ip := c.ClientIP()
+
+		mu.Lock()
+		if len(clients) > rateLimitMaxClients {
+			for key, b := range clients {
+				if now.Sub(b.last) > rateLimitIdleTTL {
+					delete(clients, key)
+				}
+			}
+		}
+		b, ok := clients[ip]
+		if !ok {
+			b = &clientBucket{tokens: float64(burst), last: now}
+			clients[ip] = b
+		}
+		b.tokens = math.Min(float64(burst), b.tokens+now.Sub(b.last).Seconds()*rps)
+		b.last = now
+		allowed := b.tokens >= 1
+		var wait time.Duration
+		if allowed {
+			b.tokens--
+		} else {
+			wait = time.Duration((1 - b.tokens) / rps * float64(time.Second))
+		}
+		mu.Unlock()
+
+		if !allowed {
+			c.Header("Retry-After", fmt.Sprint(int(math.Ceil(wait.Seconds()))))
+			c.AbortWithStatusJSON(http.StatusTooManyRequests, gin.H{"error": "rate limit exceeded"})
+			return
+		}
+		c.Next()
+	}
+}
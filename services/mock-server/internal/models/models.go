@@ -7,4 +7,6 @@ import (
 // Re-export shared models
 type ProviderUser = models.ProviderUser
 type ProviderEmail = models.ProviderEmail
+type GroundTruthEmail = models.GroundTruthEmail
+type GroundTruth = models.GroundTruth
 
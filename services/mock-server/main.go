@@ -8,9 +8,8 @@ import (
 	"strconv"
 	"time"
 
-	"github.com/gin-gonic/gin"
-	"github.com/google/uuid"
-	"github.com/stoik/vigil/services/mock-server/internal/mock"
+	"github.com/stoik/vigil/services/mock-server/internal/middleware"
+	"github.com/stoik/vigil/services/mock-server/mock"
 )
 
 func main() {
@@ -19,113 +18,149 @@ func main() {
 		port = "8080"
 	}
 
-	r := gin.Default()
+	opts := mock.DefaultOptions()
+	var err error
 
-	// Health check
-	r.GET("/health", func(c *gin.Context) {
-		c.JSON(http.StatusOK, gin.H{"status": "ok"})
-	})
+	// Size and pace: small local runs and CI don't need 5000 users and 30s ticks
+	if opts.Users, err = envInt("INITIAL_USERS", mock.DefaultUsers); err != nil {
+		log.Fatalf("invalid INITIAL_USERS: %v", err)
+	}
+	if opts.GenerationInterval, err = envDuration("GENERATION_INTERVAL", mock.DefaultGenerationInterval); err != nil {
+		log.Fatalf("invalid GENERATION_INTERVAL: %v", err)
+	}
+	if opts.EmailsPerTick, err = envInt("EMAILS_PER_TICK", 0); err != nil {
+		log.Fatalf("invalid EMAILS_PER_TICK: %v", err)
+	}
 
-	// Google provider endpoints
-	google := r.Group("/google")
-	{
-		google.GET("/users/:tenantId", handleGetGoogleUsers)
-		google.GET("/emails/:userId", handleGetGoogleEmails)
+	// Large tenants: generate emails on request instead of storing them
+	if opts.Scale, err = envBool("SCALE_MODE", false); err != nil {
+		log.Fatalf("invalid SCALE_MODE: %v", err)
 	}
-	
-	// Admin endpoints for testing
-	admin := r.Group("/admin")
-	{
-		admin.POST("/users/add", handleAddUsers)
+
+	// Duplicate-delivery simulation (0 disables it)
+	if opts.DuplicateRate, err = envFloat("DUPLICATE_DELIVERY_RATE", 0); err != nil {
+		log.Fatalf("invalid DUPLICATE_DELIVERY_RATE: %v", err)
 	}
 
-	addr := fmt.Sprintf(":%s", port)
-	log.Printf("Starting Vigil Mock API server on %s", addr)
-	log.Fatal(http.ListenAndServe(addr, r))
-}
+	// Out-of-order timestamp simulation (0 disables it)
+	if opts.LateArrivalRate, err = envFloat("LATE_ARRIVAL_RATE", 0); err != nil {
+		log.Fatalf("invalid LATE_ARRIVAL_RATE: %v", err)
+	}
+	if opts.LateArrivalMaxDelay, err = envDuration("LATE_ARRIVAL_MAX_DELAY", 0); err != nil {
+		log.Fatalf("invalid LATE_ARRIVAL_MAX_DELAY: %v", err)
+	}
 
-func handleGetGoogleUsers(c *gin.Context) {
-	tenantIDStr := c.Param("tenantId")
-	tenantID, err := uuid.Parse(tenantIDStr)
-	if err != nil {
-		c.JSON(http.StatusBadRequest, gin.H{"error": "invalid tenant_id"})
-		return
+	// User churn simulation (0 disables it)
+	if opts.ChurnRate, err = envFloat("CHURN_RATE", 0); err != nil {
+		log.Fatalf("invalid CHURN_RATE: %v", err)
+	}
+	if opts.ChurnInterval, err = envDuration("CHURN_INTERVAL", 0); err != nil {
+		log.Fatalf("invalid CHURN_INTERVAL: %v", err)
 	}
 
-	users, err := mock.GetGoogleUsers(tenantID)
-	if err != nil {
-		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
-		return
+	// Admin endpoint protection: per-client request rate and body size
+	if opts.AdminRateLimit, err = envFloat("ADMIN_RATE_LIMIT", 10); err != nil || opts.AdminRateLimit <= 0 {
+		log.Fatalf("invalid ADMIN_RATE_LIMIT: must be a positive number")
+	}
+	if opts.AdminRateBurst, err = envInt("ADMIN_RATE_BURST", 20); err != nil || opts.AdminRateBurst <= 0 {
+		log.Fatalf("invalid ADMIN_RATE_BURST: must be a positive number")
 	}
+	adminMaxBody, err := envInt("ADMIN_MAX_BODY_BYTES", 64*1024)
+	if err != nil || adminMaxBody <= 0 {
+		log.Fatalf("invalid ADMIN_MAX_BODY_BYTES: must be a positive number")
+	}
+	opts.AdminMaxBodyBytes = int64(adminMaxBody)
 
-	c.JSON(http.StatusOK, users)
-}
+	// Bulk-mail campaign simulation (0 disables it)
+	if opts.CampaignRate, err = envFloat("CAMPAIGN_RATE", 0); err != nil {
+		log.Fatalf("invalid CAMPAIGN_RATE: %v", err)
+	}
+	if opts.CampaignMinSize, err = envInt("CAMPAIGN_MIN_SIZE", 0); err != nil {
+		log.Fatalf("invalid CAMPAIGN_MIN_SIZE: %v", err)
+	}
+	if opts.CampaignMaxSize, err = envInt("CAMPAIGN_MAX_SIZE", 0); err != nil {
+		log.Fatalf("invalid CAMPAIGN_MAX_SIZE: %v", err)
+	}
+
+	// Real provider API shapes served next to the native API
+	if opts.Compat, err = mock.ParseCompat(os.Getenv("COMPAT_PROFILE")); err != nil {
+		log.Fatalf("invalid COMPAT_PROFILE: %v", err)
+	}
 
-func handleGetGoogleEmails(c *gin.Context) {
-	userIDStr := c.Param("userId")
-	userID, err := uuid.Parse(userIDStr)
+	if opts.Verbosity, err = middleware.ParseVerbosity(os.Getenv("LOG_LEVEL")); err != nil {
+		log.Fatalf("invalid LOG_LEVEL: %v", err)
+	}
+
+	router, store, err := mock.NewRouter(opts)
 	if err != nil {
-		c.JSON(http.StatusBadRequest, gin.H{"error": "invalid user_id"})
-		return
+		log.Fatalf("invalid settings: %v", err)
+	}
+	logSimulations(store)
+
+	// Generate emails every generation interval and churn users for as long as the server runs
+	users, _ := store.GetGoogleUsers(store.TenantID())
+	log.Printf("Mock store ready: %d users, generation every %v", len(users), opts.GenerationInterval)
+	if opts.Scale {
+		log.Printf("Scale mode: emails are generated on request, not stored")
+	}
+	if opts.Compat != mock.CompatNative {
+		log.Printf("Compatibility profile: %s API served next to the native API", opts.Compat)
 	}
+	store.Start()
 
-	// Parse query parameters
-	receivedAfterStr := c.DefaultQuery("receivedAfter", "")
-	orderBy := c.DefaultQuery("orderBy", "received_at")
+	addr := fmt.Sprintf(":%s", port)
+	log.Printf("Starting Vigil Mock API server on %s", addr)
+	log.Fatal(http.ListenAndServe(addr, router))
+}
 
-	var receivedAfter time.Time
-	if receivedAfterStr == "" {
-		// Default to 24 hours ago
-		receivedAfter = time.Now().Add(-24 * time.Hour)
-	} else {
-		var err error
-		receivedAfter, err = time.Parse(time.RFC3339, receivedAfterStr)
-		if err != nil {
-			c.JSON(http.StatusBadRequest, gin.H{"error": "invalid receivedAfter format (use RFC3339)"})
-			return
-		}
+// logSimulations logs the simulations enabled at startup
+func logSimulations(store *mock.Store) {
+	if rate := store.GetDuplicateRate(); rate > 0 {
+		log.Printf("Duplicate-delivery simulation enabled (rate: %.2f)", rate)
+	}
+	if rate, maxDelay := store.GetLateArrival(); rate > 0 {
+		log.Printf("Late-arrival simulation enabled (rate: %.2f, max delay: %v)", rate, maxDelay)
+	}
+	if rate, interval := store.GetChurn(); rate > 0 {
+		log.Printf("User churn simulation enabled (rate: %.2f, interval: %v)", rate, interval)
 	}
+	if rate, minSize, maxSize := store.GetCampaigns(); rate > 0 {
+		log.Printf("Campaign simulation enabled (rate: %.2f, size: %d-%d)", rate, minSize, maxSize)
+	}
+}
 
-	emails, err := mock.GetGoogleEmails(userID, receivedAfter, orderBy)
-	if err != nil {
-		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
-		return
+// envFloat reads a numeric environment variable, returning def when it is unset
+func envFloat(name string, def float64) (float64, error) {
+	v := os.Getenv(name)
+	if v == "" {
+		return def, nil
 	}
+	return strconv.ParseFloat(v, 64)
+}
 
-	c.JSON(http.StatusOK, emails)
+// envBool reads a boolean environment variable, returning def when it is unset
+func envBool(name string, def bool) (bool, error) {
+	v := os.Getenv(name)
+	if v == "" {
+		return def, nil
+	}
+	return strconv.ParseBool(v)
 }
 
-func handleAddUsers(c *gin.Context) {
-	var req struct {
-		NumUsers int `json:"numUsers"`
-	}
-	
-	// Try JSON body first
-	if err := c.ShouldBindJSON(&req); err != nil {
-		// Fall back to query parameter
-		numUsersStr := c.DefaultQuery("numUsers", "1")
-		if num, err := strconv.Atoi(numUsersStr); err == nil {
-			req.NumUsers = num
-		} else {
-			req.NumUsers = 1
-		}
-	}
-	
-	// Default to 1 if not specified or invalid
-	if req.NumUsers < 1 {
-		req.NumUsers = 1
-	}
-	
-	totalUsers, err := mock.AddUsers(req.NumUsers)
-	if err != nil {
-		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
-		return
-	}
-	
-	c.JSON(http.StatusOK, gin.H{
-		"added": req.NumUsers,
-		"total":  totalUsers,
-		"message": fmt.Sprintf("Added %d user(s). Total users: %d", req.NumUsers, totalUsers),
-	})
+// envInt reads an integer environment variable, returning def when it is unset
+func envInt(name string, def int) (int, error) {
+	v := os.Getenv(name)
+	if v == "" {
+		return def, nil
+	}
+	return strconv.Atoi(v)
 }
 
+// envDuration reads a duration environment variable, returning def when it is unset
+func envDuration(name string, def time.Duration) (time.Duration, error) {
+	v := os.Getenv(name)
+	if v == "" {
+		return def, nil
+	}
+	return time.ParseDuration(v)
+}
@@ -0,0 +1,140 @@
+package mock
+
+import (
+	"bytes"
+	"encoding/base64"
+	"fmt"
+	"html"
+	"mime"
+	"mime/multipart"
+	"mime/quotedprintable"
+	"net/textproto"
+	"strings"
+	"time"
+
+	"github.com/google/uuid"
+)
+
+// internationalSubjects exercise non-ASCII handling (RFC 2047 encoded in headers)
+var internationalSubjects = []string{
+	"Réunion demain",
+	"Actualización del proyecto",
+	"Überprüfung des Budgets",
+	"会議のお知らせ",
+	"Отчёт за квартал",
+	"Ενημέρωση πελάτη",
+}
+
+var mailers = []string{
+	"Microsoft Outlook 16.0",
+	"Apple Mail (2.3731)",
+	"Mozilla Thunderbird 115.6",
+	"Gmail",
+}
+
+// renderedEmail is a generated message in wire form: header map plus encoded MIME body
+type renderedEmail struct {
+	headers map[string][]string
+	body    string
+}
+
+// pickSubject returns an ASCII or internationalized subject (roughly 1 in 4 is non-ASCII)
+func pickSubject(rng randSource) string {
+	if rng.Intn(4) == 0 {
+		return internationalSubjects[rng.Intn(len(internationalSubjects))]
+	}
+	return subjects[rng.Intn(len(subjects))]
+}
+
+// renderEmail builds production-like headers and body from plain text content
+// Two thirds of messages are multipart/alternative (quoted-printable text + base64 HTML),
+// the rest are single-part quoted-printable text. rng drives every random choice, the MIME
+// boundary included, so a seeded source renders the same bytes every time.
+func renderEmail(rng randSource, from, to, subject, text string, messageID uuid.UUID, receivedAt time.Time) renderedEmail {
+	headers := map[string][]string{
+		"From":         {from},
+		"To":           {to},
+		"Subject":      {mime.QEncoding.Encode("utf-8", subject)},
+		"Date":         {receivedAt.Format(time.RFC1123Z)},
+		"Message-ID":   {fmt.Sprintf("<%s@mock.vigil.local>", messageID)},
+		"MIME-Version": {"1.0"},
+		"X-Mailer":     {mailers[rng.Intn(len(mailers))]},
+		"Return-Path":  {fmt.Sprintf("<%s>", from)},
+		"Received": {
+			fmt.Sprintf("from mx%d.mock.vigil.local by mail.mock.vigil.local; %s",
+				rng.Intn(10), receivedAt.Format(time.RFC1123Z)),
+			fmt.Sprintf("from outbound.%s by mx.mock.vigil.local; %s",
+				domainOf(from), receivedAt.Add(-time.Duration(rng.Intn(5000))*time.Millisecond).Format(time.RFC1123Z)),
+		},
+		"Authentication-Results": {fmt.Sprintf("mx.mock.vigil.local; spf=pass smtp.mailfrom=%s; dkim=pass header.d=%s",
+			domainOf(from), domainOf(from))},
+	}
+
+	if rng.Intn(3) == 0 {
+		headers["Content-Type"] = []string{`text/plain; charset="utf-8"`}
+		headers["Content-Transfer-Encoding"] = []string{"quoted-printable"}
+		return renderedEmail{headers: headers, body: encodeQuotedPrintable(text)}
+	}
+
+	var buf bytes.Buffer
+	mw := multipart.NewWriter(&buf)
+	mw.SetBoundary(fmt.Sprintf("%016x%016x", rng.Int63(), rng.Int63()))
+
+	textPart, _ := mw.CreatePart(textproto.MIMEHeader{
+		"Content-Type":              {`text/plain; charset="utf-8"`},
+		"Content-Transfer-Encoding": {"quoted-printable"},
+	})
+	textPart.Write([]byte(encodeQuotedPrintable(text)))
+
+	htmlPart, _ := mw.CreatePart(textproto.MIMEHeader{
+		"Content-Type":              {`text/html; charset="utf-8"`},
+		"Content-Transfer-Encoding": {"base64"},
+	})
+	htmlPart.Write([]byte(encodeBase64Lines(textToHTML(subject, text))))
+	mw.Close()
+
+	headers["Content-Type"] = []string{fmt.Sprintf(`multipart/alternative; boundary="%s"`, mw.Boundary())}
+	return renderedEmail{headers: headers, body: buf.String()}
+}
+
+func encodeQuotedPrintable(text string) string {
+	var buf bytes.Buffer
+	w := quotedprintable.NewWriter(&buf)
+	w.Write([]byte(text))
+	w.Close()
+	return buf.String()
+}
+
+// encodeBase64Lines base64-encodes content wrapped at 76 characters (RFC 2045)
+func encodeBase64Lines(content string) string {
+	encoded := base64.StdEncoding.EncodeToString([]byte(content))
+	var b strings.Builder
+	for len(encoded) > 76 {
+		b.WriteString(encoded[:76])
+		b.WriteString("\r\n")
+		encoded = encoded[76:]
+	}
+	b.WriteString(encoded)
+	return b.String()
+}
+
+func textToHTML(subject, text string) string {
+	var b strings.Builder
+	b.WriteString("<!DOCTYPE html>\n<html><head><meta charset=\"utf-8\"><title>")
+	b.WriteString(html.EscapeString(subject))
+	b.WriteString("</title></head>\n<body style=\"font-family: Arial, sans-serif;\">\n")
+	for _, paragraph := range strings.Split(text, "\n\n") {
+		b.WriteString("<p>")
+		b.WriteString(strings.ReplaceAll(html.EscapeString(paragraph), "\n", "<br>\n"))
+		b.WriteString("</p>\n")
+	}
+	b.WriteString("</body></html>\n")
+	return b.String()
+}
+
+func domainOf(address string) string {
+	if i := strings.LastIndex(address, "@"); i >= 0 {
+		return address[i+1:]
+	}
+	return address
+}
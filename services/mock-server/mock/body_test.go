@@ -0,0 +1,78 @@
+package mock
+
+import (
+	"encoding/base64"
+	"io"
+	"mime"
+	"mime/multipart"
+	"mime/quotedprintable"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/google/uuid"
+)
+
+// TestRenderEmailRoundTrip checks generated messages decode back to their text content
+func TestRenderEmailRoundTrip(t *testing.T) {
+	text := "Dear Zoë,\n\nLe budget révisé est prêt — 会議は明日です.\n\nBest regards,\nThe Mock Server"
+	subject := "Réunion demain [3]"
+
+	for i := 0; i < 50; i++ {
+		rendered := renderEmail(globalRand{}, "sender1@example.com", "zoe@company.com", subject, text, uuid.New(), time.Now())
+
+		decodedSubject, err := new(mime.WordDecoder).DecodeHeader(rendered.headers["Subject"][0])
+		if err != nil || decodedSubject != subject {
+			t.Fatalf("subject = %q (%v), want %q", decodedSubject, err, subject)
+		}
+
+		mediaType, params, err := mime.ParseMediaType(rendered.headers["Content-Type"][0])
+		if err != nil {
+			t.Fatalf("invalid Content-Type: %v", err)
+		}
+
+		switch mediaType {
+		case "text/plain":
+			decoded, _ := io.ReadAll(quotedprintable.NewReader(strings.NewReader(rendered.body)))
+			if crlfToLF(decoded) != text {
+				t.Fatalf("plain body = %q, want %q", decoded, text)
+			}
+		case "multipart/alternative":
+			mr := multipart.NewReader(strings.NewReader(rendered.body), params["boundary"])
+			var parts []string
+			for {
+				part, err := mr.NextRawPart()
+				if err == io.EOF {
+					break
+				}
+				if err != nil {
+					t.Fatalf("invalid multipart body: %v", err)
+				}
+				raw, _ := io.ReadAll(part)
+				switch part.Header.Get("Content-Transfer-Encoding") {
+				case "quoted-printable":
+					decoded, _ := io.ReadAll(quotedprintable.NewReader(strings.NewReader(string(raw))))
+					if crlfToLF(decoded) != text {
+						t.Fatalf("text part = %q, want %q", decoded, text)
+					}
+				case "base64":
+					decoded, err := base64.StdEncoding.DecodeString(strings.ReplaceAll(string(raw), "\r\n", ""))
+					if err != nil || !strings.Contains(string(decoded), "<html>") {
+						t.Fatalf("html part not valid base64 HTML: %v", err)
+					}
+				}
+				parts = append(parts, part.Header.Get("Content-Type"))
+			}
+			if len(parts) != 2 {
+				t.Fatalf("got %d parts, want 2", len(parts))
+			}
+		default:
+			t.Fatalf("unexpected media type %s", mediaType)
+		}
+	}
+}
+
+// crlfToLF undoes the CRLF line endings quoted-printable uses on the wire
+func crlfToLF(b []byte) string {
+	return strings.ReplaceAll(string(b), "\r\n", "\n")
+}
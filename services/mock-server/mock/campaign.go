@@ -0,0 +1,175 @@
+package mock
+
+import (
+	"fmt"
+	"log"
+	"math/rand"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/stoik/vigil/services/mock-server/internal/models"
+)
+
+const maxRetainedCampaigns = 100
+
+var campaignSenders = []string{
+	"newsletter@bulkmailer.com",
+	"promotions@retailer.shop",
+	"security-alert@acc0unt-verify.com",
+	"hr-announcements@company.com",
+}
+
+var campaignSubjects = []string{
+	"Company all-hands: agenda",
+	"Action required: verify your account",
+	"Spring sale starts now",
+	"New benefits enrollment",
+}
+
+// Campaign is one bulk email delivered with an identical body to many users
+// Every recipient gets its own message ID, so discovery sees one fingerprint
+// linked to Size users
+type Campaign struct {
+	ID         string    `json:"id"`
+	From       string    `json:"from"`
+	Subject    string    `json:"subject"`
+	Size       int       `json:"size"`
+	LaunchedAt time.Time `json:"launched_at"`
+}
+
+// SetCampaigns sets the probability (0-1) that a generation cycle launches a campaign,
+// and the range of recipients per campaign
+func (s *Store) SetCampaigns(rate float64, minSize, maxSize int) error {
+	if rate < 0 || rate > 1 {
+		return fmt.Errorf("campaign rate must be between 0 and 1")
+	}
+	if minSize < 1 || maxSize < minSize {
+		return fmt.Errorf("campaign sizes must satisfy 1 <= minSize <= maxSize")
+	}
+
+	s.campaignMutex.Lock()
+	defer s.campaignMutex.Unlock()
+	s.campaignRate = rate
+	s.campaignMinSize = minSize
+	s.campaignMaxSize = maxSize
+	return nil
+}
+
+// GetCampaigns returns the campaign rate and size range
+func (s *Store) GetCampaigns() (float64, int, int) {
+	s.campaignMutex.RLock()
+	defer s.campaignMutex.RUnlock()
+	return s.campaignRate, s.campaignMinSize, s.campaignMaxSize
+}
+
+// ListCampaigns returns the recently launched campaigns
+func (s *Store) ListCampaigns() []Campaign {
+	s.campaignsMutex.RLock()
+	defer s.campaignsMutex.RUnlock()
+	result := make([]Campaign, len(s.campaigns))
+	copy(result, s.campaigns)
+	return result
+}
+
+// LaunchCampaign delivers a campaign to size random users right away
+func (s *Store) LaunchCampaign(size int) (Campaign, error) {
+	if size < 1 {
+		return Campaign{}, fmt.Errorf("campaign size must be at least 1")
+	}
+
+	s.userListMutex.RLock()
+	users := make([]models.ProviderUser, len(s.userList))
+	copy(users, s.userList)
+	s.userListMutex.RUnlock()
+
+	s.emailStoreMutex.Lock()
+	defer s.emailStoreMutex.Unlock()
+	return s.deliverCampaignLocked(users, size, s.now()), nil
+}
+
+// simulateCampaign launches a campaign with the configured probability
+// Called from the generation loop with emailStoreMutex held
+func (s *Store) simulateCampaign(users []models.ProviderUser, now time.Time) {
+	rate, minSize, maxSize := s.GetCampaigns()
+	if rate == 0 || rand.Float64() >= rate {
+		return
+	}
+	campaign := s.deliverCampaignLocked(users, minSize+rand.Intn(maxSize-minSize+1), now)
+	log.Printf("Campaign %s delivered to %d user(s)", campaign.ID, campaign.Size)
+}
+
+// deliverCampaignLocked renders one body and delivers it to up to size random users
+// Caller must hold emailStoreMutex
+func (s *Store) deliverCampaignLocked(users []models.ProviderUser, size int, now time.Time) Campaign {
+	if size > len(users) {
+		size = len(users)
+	}
+
+	campaignID := uuid.New()
+	from := campaignSenders[rand.Intn(len(campaignSenders))]
+	subject := campaignSubjects[rand.Intn(len(campaignSubjects))]
+	text := fmt.Sprintf(
+		"Hello,\n\n"+
+			"%s\n\n"+
+			"This message was sent to a distribution list.\n"+
+			"Campaign: %s\n\n"+
+			"To unsubscribe, click here.",
+		subject,
+		campaignID.String(),
+	)
+	// Rendered once: every recipient gets byte-identical body content
+	rendered := renderEmail(globalRand{}, from, "undisclosed-recipients:;", subject, text, campaignID, now)
+
+	for _, i := range rand.Perm(len(users))[:size] {
+		user := users[i]
+		if _, exists := s.emailStore[user.ID]; !exists {
+			continue
+		}
+
+		messageID := uuid.New()
+		receivedAt := now.Add(-time.Duration(rand.Int63n(int64(s.generationInterval))))
+		headers := make(map[string][]string, len(rendered.headers))
+		for k, v := range rendered.headers {
+			headers[k] = v
+		}
+		headers["To"] = []string{user.Email}
+		headers["Message-ID"] = []string{fmt.Sprintf("<%s@mock.vigil.local>", messageID)}
+		headers["Date"] = []string{receivedAt.Format(time.RFC1123Z)}
+
+		email := models.ProviderEmail{
+			MessageID:  messageID.String(),
+			UserID:     user.ID,
+			From:       from,
+			To:         user.Email,
+			Subject:    subject,
+			Snippet:    fmt.Sprintf("This is a snippet for: %s", subject),
+			ReceivedAt: receivedAt,
+			Body:       rendered.body,
+			Headers:    headers,
+		}
+		s.emailStore[user.ID] = append(s.emailStore[user.ID], email)
+		s.groundTruth[user.ID] = append(s.groundTruth[user.ID], models.GroundTruthEmail{
+			MessageID:   email.MessageID,
+			ReceivedAt:  email.ReceivedAt,
+			GeneratedAt: now,
+			CampaignID:  campaignID.String(),
+		})
+	}
+
+	campaign := Campaign{
+		ID:         campaignID.String(),
+		From:       from,
+		Subject:    subject,
+		Size:       size,
+		LaunchedAt: now,
+	}
+
+	s.campaignsMutex.Lock()
+	s.campaigns = append(s.campaigns, campaign)
+	if len(s.campaigns) > maxRetainedCampaigns {
+		s.campaigns = s.campaigns[len(s.campaigns)-maxRetainedCampaigns:]
+	}
+	s.campaignsMutex.Unlock()
+
+	return campaign
+}
@@ -0,0 +1,55 @@
+package mock
+
+import (
+	"testing"
+	"time"
+)
+
+func TestLaunchCampaign(t *testing.T) {
+	s := newTestStore(t, 100)
+
+	campaign, err := s.LaunchCampaign(25)
+	if err != nil {
+		t.Fatalf("LaunchCampaign: %v", err)
+	}
+	if campaign.Size != 25 {
+		t.Fatalf("size = %d, want 25", campaign.Size)
+	}
+
+	users, _ := s.GetGoogleUsers(s.TenantID())
+	bodies := make(map[string]bool)
+	messageIDs := make(map[string]bool)
+	recipients := 0
+	for _, user := range users {
+		for _, gt := range s.GetGroundTruth(user.ID, time.Time{}, time.Now().Add(time.Minute)).Emails {
+			if gt.CampaignID != campaign.ID {
+				continue
+			}
+			recipients++
+			messageIDs[gt.MessageID] = true
+
+			email, ok := s.GetGoogleEmail(user.ID, gt.MessageID)
+			if !ok {
+				t.Fatalf("campaign email %s missing from user %s mailbox", gt.MessageID, user.ID)
+			}
+			if email.To != user.Email || email.Headers["To"][0] != user.Email {
+				t.Errorf("email to %q, want %q", email.To, user.Email)
+			}
+			bodies[email.Body] = true
+		}
+	}
+
+	if recipients != 25 || len(messageIDs) != 25 {
+		t.Errorf("recipients = %d with %d message IDs, want 25 distinct", recipients, len(messageIDs))
+	}
+	if len(bodies) != 1 {
+		t.Errorf("campaign has %d distinct bodies, want 1", len(bodies))
+	}
+
+	if _, err := s.LaunchCampaign(0); err == nil {
+		t.Error("empty campaign accepted")
+	}
+	if err := s.SetCampaigns(0.5, 10, 5); err == nil {
+		t.Error("minSize > maxSize accepted")
+	}
+}
@@ -0,0 +1,636 @@
+package mock
+
+import (
+	"bytes"
+	"encoding/base64"
+	"fmt"
+	"io"
+	"mime"
+	"mime/multipart"
+	"mime/quotedprintable"
+	"net/http"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/stoik/vigil/services/mock-server/internal/middleware"
+	"github.com/stoik/vigil/services/mock-server/internal/models"
+)
+
+// Compatibility profiles: besides the native /google API, the mock can serve the users and
+// messages of its store at the real provider paths, with the real resource shapes (field
+// names, resource wrappers, paging envelopes and error bodies), so provider client code can
+// be developed against it before touching production APIs
+const (
+	CompatNative = ""      // Native API only
+	CompatGmail  = "gmail" // Gmail API v1 and Admin SDK Directory API users
+	CompatGraph  = "graph" // Microsoft Graph v1.0 users and messages
+)
+
+const graphBaseURL = "https://graph.microsoft.com/v1.0"
+
+var wordDecoder = new(mime.WordDecoder)
+
+// ParseCompat validates a compatibility profile name ("" or "native", "gmail", "graph")
+func ParseCompat(name string) (string, error) {
+	switch strings.ToLower(name) {
+	case "", "native":
+		return CompatNative, nil
+	case CompatGmail:
+		return CompatGmail, nil
+	case CompatGraph:
+		return CompatGraph, nil
+	default:
+		return "", fmt.Errorf("unknown compatibility profile %q (use native, gmail or graph)", name)
+	}
+}
+
+// mountCompat registers the routes of a compatibility profile
+func (s *Store) mountCompat(r *gin.Engine, compat string) {
+	switch compat {
+	case CompatGmail:
+		r.GET("/admin/directory/v1/users", s.handleGmailListUsers)
+		r.GET("/gmail/v1/users/:userId/messages", s.handleGmailListMessages)
+		r.GET("/gmail/v1/users/:userId/messages/:id", s.handleGmailGetMessage)
+	case CompatGraph:
+		r.GET("/v1.0/users", s.handleGraphListUsers)
+		r.GET("/v1.0/users/:userId/messages", s.handleGraphListMessages)
+		r.GET("/v1.0/users/:userId/messages/:id", s.handleGraphGetMessage)
+	}
+}
+
+// findUser returns a user by ID or mailbox address, as both APIs accept either
+func (s *Store) findUser(ref string) (models.ProviderUser, bool) {
+	s.userListMutex.RLock()
+	defer s.userListMutex.RUnlock()
+	for _, user := range s.userList {
+		if user.ID.String() == ref || strings.EqualFold(user.Email, ref) {
+			return user, true
+		}
+	}
+	return models.ProviderUser{}, false
+}
+
+// page returns the items of a page and the token of the next one ("" on the last page)
+// Tokens are opaque to clients: the offset of the next item
+func page[T any](items []T, token string, size int) ([]T, string, error) {
+	offset := 0
+	if token != "" {
+		decoded, err := base64.RawURLEncoding.DecodeString(token)
+		if err == nil {
+			offset, err = strconv.Atoi(string(decoded))
+		}
+		if err != nil || offset < 0 {
+			return nil, "", fmt.Errorf("invalid page token")
+		}
+	}
+	if offset >= len(items) {
+		return []T{}, "", nil
+	}
+	end := min(offset+size, len(items))
+	next := ""
+	if end < len(items) {
+		next = base64.RawURLEncoding.EncodeToString([]byte(strconv.Itoa(end)))
+	}
+	return items[offset:end], next, nil
+}
+
+// pageSize parses a page size parameter, bounded to [1, maxSize]
+func pageSize(value string, def, maxSize int) (int, error) {
+	if value == "" {
+		return def, nil
+	}
+	n, err := strconv.Atoi(value)
+	if err != nil || n < 1 {
+		return 0, fmt.Errorf("invalid page size %q", value)
+	}
+	return min(n, maxSize), nil
+}
+
+// mimePart is a decoded leaf part of an email
+type mimePart struct {
+	headers textHeaders
+	data    []byte
+}
+
+func (p mimePart) mimeType() string {
+	mediaType, _, _ := mime.ParseMediaType(p.headers.get("Content-Type"))
+	return mediaType
+}
+
+// textHeaders are MIME headers in a stable order
+type textHeaders []struct{ name, value string }
+
+func (h textHeaders) get(name string) string {
+	for _, header := range h {
+		if strings.EqualFold(header.name, name) {
+			return header.value
+		}
+	}
+	return ""
+}
+
+// sortedHeaders flattens a header map, sorted by name
+func sortedHeaders(m map[string][]string) textHeaders {
+	names := make([]string, 0, len(m))
+	for name := range m {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	var headers textHeaders
+	for _, name := range names {
+		for _, value := range m[name] {
+			headers = append(headers, struct{ name, value string }{name, value})
+		}
+	}
+	return headers
+}
+
+// decodeParts splits an email's body into its decoded leaf parts (one when not multipart)
+func decodeParts(email models.ProviderEmail) ([]mimePart, error) {
+	headers := sortedHeaders(email.Headers)
+	mediaType, params, err := mime.ParseMediaType(headers.get("Content-Type"))
+	if err != nil || !strings.HasPrefix(mediaType, "multipart/") {
+		data, err := decodeTransfer(headers.get("Content-Transfer-Encoding"), email.Body)
+		return []mimePart{{headers: headers, data: data}}, err
+	}
+
+	var parts []mimePart
+	mr := multipart.NewReader(strings.NewReader(email.Body), params["boundary"])
+	for {
+		part, err := mr.NextRawPart()
+		if err == io.EOF {
+			return parts, nil
+		}
+		if err != nil {
+			return nil, err
+		}
+		raw, err := io.ReadAll(part)
+		if err != nil {
+			return nil, err
+		}
+		data, err := decodeTransfer(part.Header.Get("Content-Transfer-Encoding"), string(raw))
+		if err != nil {
+			return nil, err
+		}
+		parts = append(parts, mimePart{headers: sortedHeaders(part.Header), data: data})
+	}
+}
+
+func decodeTransfer(encoding, content string) ([]byte, error) {
+	switch strings.ToLower(encoding) {
+	case "quoted-printable":
+		return io.ReadAll(quotedprintable.NewReader(strings.NewReader(content)))
+	case "base64":
+		return base64.StdEncoding.DecodeString(strings.NewReplacer("\r", "", "\n", "").Replace(content))
+	default:
+		return []byte(content), nil
+	}
+}
+
+// rawMessage returns the email in RFC 5322 wire form
+func rawMessage(email models.ProviderEmail) []byte {
+	var buf bytes.Buffer
+	for _, header := range sortedHeaders(email.Headers) {
+		fmt.Fprintf(&buf, "%s: %s\r\n", header.name, header.value)
+	}
+	buf.WriteString("\r\n")
+	buf.WriteString(email.Body)
+	return buf.Bytes()
+}
+
+// Gmail API
+
+type gmailHeader struct {
+	Name  string `json:"name"`
+	Value string `json:"value"`
+}
+
+type gmailBody struct {
+	Size int    `json:"size"`
+	Data string `json:"data,omitempty"` // base64url
+}
+
+type gmailPart struct {
+	PartID   string        `json:"partId"`
+	MimeType string        `json:"mimeType"`
+	Filename string        `json:"filename"`
+	Headers  []gmailHeader `json:"headers"`
+	Body     gmailBody     `json:"body"`
+	Parts    []gmailPart   `json:"parts,omitempty"`
+}
+
+type gmailMessage struct {
+	ID           string     `json:"id"`
+	ThreadID     string     `json:"threadId"`
+	LabelIDs     []string   `json:"labelIds,omitempty"`
+	Snippet      string     `json:"snippet,omitempty"`
+	HistoryID    string     `json:"historyId,omitempty"`
+	InternalDate string     `json:"internalDate,omitempty"` // Epoch milliseconds
+	SizeEstimate int        `json:"sizeEstimate,omitempty"`
+	Payload      *gmailPart `json:"payload,omitempty"`
+	Raw          string     `json:"raw,omitempty"` // base64url, format=raw
+}
+
+// gmailError writes a Google API error body
+func gmailError(c *gin.Context, code int, message string) {
+	status, reason := "INVALID_ARGUMENT", "invalidArgument"
+	if code == http.StatusNotFound {
+		status, reason = "NOT_FOUND", "notFound"
+	}
+	c.JSON(code, gin.H{"error": gin.H{
+		"code":    code,
+		"message": message,
+		"errors":  []gin.H{{"message": message, "domain": "global", "reason": reason}},
+		"status":  status,
+	}})
+}
+
+func gmailHeaders(headers textHeaders) []gmailHeader {
+	result := make([]gmailHeader, 0, len(headers))
+	for _, header := range headers {
+		value, err := wordDecoder.DecodeHeader(header.value)
+		if err != nil {
+			value = header.value
+		}
+		result = append(result, gmailHeader{Name: header.name, Value: value})
+	}
+	return result
+}
+
+// toGmailMessage converts an email to a Gmail message resource in the given format
+// (full, metadata, minimal or raw)
+func toGmailMessage(email models.ProviderEmail, format string) (gmailMessage, error) {
+	raw := rawMessage(email)
+	msg := gmailMessage{
+		ID:           email.MessageID,
+		ThreadID:     email.MessageID,
+		LabelIDs:     []string{"INBOX", "UNREAD"},
+		Snippet:      email.Snippet,
+		HistoryID:    strconv.FormatInt(email.ReceivedAt.UnixMicro(), 10),
+		InternalDate: strconv.FormatInt(email.ReceivedAt.UnixMilli(), 10),
+		SizeEstimate: len(raw),
+	}
+
+	headers := sortedHeaders(email.Headers)
+	mediaType, _, _ := mime.ParseMediaType(headers.get("Content-Type"))
+	switch format {
+	case "minimal":
+	case "raw":
+		msg.Raw = base64.RawURLEncoding.EncodeToString(raw)
+	case "metadata":
+		msg.Payload = &gmailPart{MimeType: mediaType, Headers: gmailHeaders(headers)}
+	case "full":
+		parts, err := decodeParts(email)
+		if err != nil {
+			return msg, err
+		}
+		payload := &gmailPart{MimeType: mediaType, Headers: gmailHeaders(headers)}
+		if !strings.HasPrefix(mediaType, "multipart/") {
+			payload.Body = gmailBody{Size: len(parts[0].data), Data: base64.RawURLEncoding.EncodeToString(parts[0].data)}
+		}
+		for i, part := range parts {
+			if strings.HasPrefix(mediaType, "multipart/") {
+				payload.Parts = append(payload.Parts, gmailPart{
+					PartID:   strconv.Itoa(i),
+					MimeType: part.mimeType(),
+					Headers:  gmailHeaders(part.headers),
+					Body:     gmailBody{Size: len(part.data), Data: base64.RawURLEncoding.EncodeToString(part.data)},
+				})
+			}
+		}
+		msg.Payload = payload
+	default:
+		return msg, fmt.Errorf("invalid format %q (use full, metadata, minimal or raw)", format)
+	}
+	return msg, nil
+}
+
+// gmailAfter matches the after: search operator (epoch seconds)
+var gmailAfter = regexp.MustCompile(`(?:^|\s)after:(\d+)(?:\s|$)`)
+
+func (s *Store) handleGmailListUsers(c *gin.Context) {
+	size, err := pageSize(c.Query("maxResults"), 100, 500)
+	if err != nil {
+		gmailError(c, http.StatusBadRequest, err.Error())
+		return
+	}
+	users, _ := s.GetGoogleUsers(s.tenantID)
+	users, next, err := page(users, c.Query("pageToken"), size)
+	if err != nil {
+		gmailError(c, http.StatusBadRequest, err.Error())
+		return
+	}
+
+	resources := make([]gin.H, 0, len(users))
+	for _, user := range users {
+		givenName, familyName, _ := strings.Cut(user.Name, " ")
+		resource := gin.H{
+			"kind":         "admin#directory#user",
+			"id":           user.ID.String(),
+			"primaryEmail": user.Email,
+			"name":         gin.H{"givenName": givenName, "familyName": familyName, "fullName": user.Name},
+			"isAdmin":      false,
+			"suspended":    !user.Active,
+			"creationTime": user.CreatedAt.UTC().Format(time.RFC3339Nano),
+			"orgUnitPath":  user.OrgUnit,
+		}
+		if user.Title != "" {
+			resource["organizations"] = []gin.H{{"title": user.Title, "primary": true}}
+		}
+		if user.Manager != "" {
+			resource["relations"] = []gin.H{{"value": user.Manager, "type": "manager"}}
+		}
+		resources = append(resources, resource)
+	}
+
+	body := gin.H{"kind": "admin#directory#users", "users": resources}
+	if next != "" {
+		body["nextPageToken"] = next
+	}
+	c.JSON(http.StatusOK, body)
+}
+
+func (s *Store) handleGmailListMessages(c *gin.Context) {
+	user, ok := s.findUser(c.Param("userId"))
+	if !ok {
+		gmailError(c, http.StatusNotFound, "Requested entity was not found.")
+		return
+	}
+	size, err := pageSize(c.Query("maxResults"), 100, 500)
+	if err != nil {
+		gmailError(c, http.StatusBadRequest, err.Error())
+		return
+	}
+	var after time.Time
+	if m := gmailAfter.FindStringSubmatch(c.Query("q")); m != nil {
+		seconds, _ := strconv.ParseInt(m[1], 10, 64)
+		after = time.Unix(seconds, 0)
+	}
+
+	// Newest first, as Gmail lists them
+	emails, _ := s.GetGoogleEmails(user.ID, after, "received_at desc")
+	total := len(emails)
+	emails, next, err := page(emails, c.Query("pageToken"), size)
+	if err != nil {
+		gmailError(c, http.StatusBadRequest, err.Error())
+		return
+	}
+
+	body := gin.H{"resultSizeEstimate": total}
+	if len(emails) > 0 {
+		messages := make([]gin.H, 0, len(emails))
+		for _, email := range emails {
+			messages = append(messages, gin.H{"id": email.MessageID, "threadId": email.MessageID})
+		}
+		body["messages"] = messages
+	}
+	if next != "" {
+		body["nextPageToken"] = next
+	}
+	c.JSON(http.StatusOK, body)
+}
+
+func (s *Store) handleGmailGetMessage(c *gin.Context) {
+	user, ok := s.findUser(c.Param("userId"))
+	if !ok {
+		gmailError(c, http.StatusNotFound, "Requested entity was not found.")
+		return
+	}
+	email, ok := s.GetGoogleEmail(user.ID, c.Param("id"))
+	if !ok {
+		gmailError(c, http.StatusNotFound, "Requested entity was not found.")
+		return
+	}
+	msg, err := toGmailMessage(email, c.DefaultQuery("format", "full"))
+	if err != nil {
+		gmailError(c, http.StatusBadRequest, err.Error())
+		return
+	}
+	c.JSON(http.StatusOK, msg)
+}
+
+// Microsoft Graph
+
+// graphError writes a Microsoft Graph error body
+func graphError(c *gin.Context, status int, code, message string) {
+	c.JSON(status, gin.H{"error": gin.H{
+		"code":    code,
+		"message": message,
+		"innerError": gin.H{
+			"date":       time.Now().UTC().Format("2006-01-02T15:04:05"),
+			"request-id": middleware.GetRequestID(c),
+		},
+	}})
+}
+
+// graphSelect keeps the $select properties of a resource (id is always kept), or its
+// default properties when there is no $select
+func graphSelect(resource map[string]any, selectParam string, defaults []string) map[string]any {
+	keep := defaults
+	if selectParam != "" {
+		keep = append(strings.Split(selectParam, ","), "id")
+	}
+	selected := make(map[string]any, len(keep))
+	for _, name := range keep {
+		name = strings.TrimSpace(name)
+		if value, ok := resource[name]; ok {
+			selected[name] = value
+		}
+	}
+	return selected
+}
+
+// graphNextLink returns the @odata.nextLink of a list, the request URL with $skiptoken
+func graphNextLink(c *gin.Context, token string) string {
+	q := c.Request.URL.Query()
+	q.Set("$skiptoken", token)
+	return fmt.Sprintf("http://%s%s?%s", c.Request.Host, c.Request.URL.Path, q.Encode())
+}
+
+var graphUserDefaults = []string{"businessPhones", "displayName", "givenName", "jobTitle", "mail", "mobilePhone",
+	"officeLocation", "preferredLanguage", "surname", "userPrincipalName", "id"}
+
+func toGraphUser(user models.ProviderUser) map[string]any {
+	givenName, surname, _ := strings.Cut(user.Name, " ")
+	return map[string]any{
+		"id":                user.ID.String(),
+		"businessPhones":    []string{},
+		"displayName":       user.Name,
+		"givenName":         givenName,
+		"surname":           surname,
+		"jobTitle":          user.Title,
+		"mail":              user.Email,
+		"mobilePhone":       nil,
+		"officeLocation":    nil,
+		"preferredLanguage": "en-US",
+		"userPrincipalName": user.Email,
+		"accountEnabled":    user.Active,
+		"createdDateTime":   user.CreatedAt.UTC().Format(time.RFC3339),
+		"department":        strings.TrimPrefix(user.OrgUnit, "/"),
+	}
+}
+
+// graphMessageDefaults are the properties returned without $select (internetMessageHeaders
+// must be selected)
+var graphMessageDefaults = []string{"id", "createdDateTime", "lastModifiedDateTime", "receivedDateTime", "sentDateTime",
+	"hasAttachments", "internetMessageId", "subject", "bodyPreview", "importance", "conversationId", "isRead",
+	"isDraft", "body", "sender", "from", "toRecipients", "ccRecipients", "bccRecipients", "replyTo"}
+
+func graphRecipient(address string) map[string]any {
+	return map[string]any{"emailAddress": map[string]any{"name": address, "address": address}}
+}
+
+func toGraphMessage(email models.ProviderEmail) (map[string]any, error) {
+	parts, err := decodeParts(email)
+	if err != nil {
+		return nil, err
+	}
+	// The HTML alternative when there is one, as Outlook shows it
+	body := map[string]any{"contentType": "text", "content": string(parts[0].data)}
+	for _, part := range parts {
+		if part.mimeType() == "text/html" {
+			body = map[string]any{"contentType": "html", "content": string(part.data)}
+		}
+	}
+
+	headers := make([]map[string]string, 0, len(email.Headers))
+	for _, header := range gmailHeaders(sortedHeaders(email.Headers)) {
+		headers = append(headers, map[string]string{"name": header.Name, "value": header.Value})
+	}
+	at := email.ReceivedAt.UTC().Format(time.RFC3339)
+	return map[string]any{
+		"id":                     email.MessageID,
+		"createdDateTime":        at,
+		"lastModifiedDateTime":   at,
+		"receivedDateTime":       at,
+		"sentDateTime":           at,
+		"hasAttachments":         false,
+		"internetMessageId":      sortedHeaders(email.Headers).get("Message-ID"),
+		"subject":                email.Subject,
+		"bodyPreview":            email.Snippet,
+		"importance":             "normal",
+		"conversationId":         email.MessageID,
+		"isRead":                 false,
+		"isDraft":                false,
+		"body":                   body,
+		"sender":                 graphRecipient(email.From),
+		"from":                   graphRecipient(email.From),
+		"toRecipients":           []map[string]any{graphRecipient(email.To)},
+		"ccRecipients":           []map[string]any{},
+		"bccRecipients":          []map[string]any{},
+		"replyTo":                []map[string]any{},
+		"internetMessageHeaders": headers,
+	}, nil
+}
+
+// graphReceivedFilter matches the supported $filter: receivedDateTime ge|gt <timestamp>
+var graphReceivedFilter = regexp.MustCompile(`^receivedDateTime\s+(ge|gt)\s+(\S+)$`)
+
+func (s *Store) handleGraphListUsers(c *gin.Context) {
+	size, err := pageSize(c.Query("$top"), 100, 999)
+	if err != nil {
+		graphError(c, http.StatusBadRequest, "Request_BadRequest", err.Error())
+		return
+	}
+	users, _ := s.GetGoogleUsers(s.tenantID)
+	users, next, err := page(users, c.Query("$skiptoken"), size)
+	if err != nil {
+		graphError(c, http.StatusBadRequest, "Request_BadRequest", err.Error())
+		return
+	}
+
+	value := make([]map[string]any, 0, len(users))
+	for _, user := range users {
+		value = append(value, graphSelect(toGraphUser(user), c.Query("$select"), graphUserDefaults))
+	}
+	body := gin.H{"@odata.context": graphBaseURL + "/$metadata#users", "value": value}
+	if next != "" {
+		body["@odata.nextLink"] = graphNextLink(c, next)
+	}
+	c.JSON(http.StatusOK, body)
+}
+
+func (s *Store) handleGraphListMessages(c *gin.Context) {
+	user, ok := s.findUser(c.Param("userId"))
+	if !ok {
+		graphError(c, http.StatusNotFound, "ErrorInvalidUser", fmt.Sprintf("The requested user '%s' is invalid.", c.Param("userId")))
+		return
+	}
+	size, err := pageSize(c.Query("$top"), 10, 1000)
+	if err != nil {
+		graphError(c, http.StatusBadRequest, "ErrorInvalidUrlQuery", err.Error())
+		return
+	}
+
+	var after time.Time
+	if filter := strings.TrimSpace(c.Query("$filter")); filter != "" {
+		m := graphReceivedFilter.FindStringSubmatch(filter)
+		if m == nil {
+			graphError(c, http.StatusBadRequest, "ErrorInvalidUrlQueryFilter", "The mock supports only 'receivedDateTime ge|gt <timestamp>' filters.")
+			return
+		}
+		if after, err = time.Parse(time.RFC3339, m[2]); err != nil {
+			graphError(c, http.StatusBadRequest, "ErrorInvalidUrlQueryFilter", "Invalid receivedDateTime.")
+			return
+		}
+		if m[1] == "gt" {
+			after = after.Add(time.Nanosecond)
+		}
+	}
+	orderBy := "received_at desc" // Graph's default order
+	switch strings.TrimSpace(c.Query("$orderby")) {
+	case "", "receivedDateTime desc", "receivedDateTime DESC":
+	case "receivedDateTime", "receivedDateTime asc", "receivedDateTime ASC":
+		orderBy = "received_at"
+	default:
+		graphError(c, http.StatusBadRequest, "ErrorInvalidUrlQuery", "The mock supports ordering by receivedDateTime only.")
+		return
+	}
+
+	emails, _ := s.GetGoogleEmails(user.ID, after, orderBy)
+	emails, next, err := page(emails, c.Query("$skiptoken"), size)
+	if err != nil {
+		graphError(c, http.StatusBadRequest, "ErrorInvalidUrlQuery", err.Error())
+		return
+	}
+
+	value := make([]map[string]any, 0, len(emails))
+	for _, email := range emails {
+		msg, err := toGraphMessage(email)
+		if err != nil {
+			graphError(c, http.StatusInternalServerError, "ErrorInternalServerError", err.Error())
+			return
+		}
+		value = append(value, graphSelect(msg, c.Query("$select"), graphMessageDefaults))
+	}
+	body := gin.H{"@odata.context": fmt.Sprintf("%s/$metadata#users('%s')/messages", graphBaseURL, user.ID), "value": value}
+	if next != "" {
+		body["@odata.nextLink"] = graphNextLink(c, next)
+	}
+	c.JSON(http.StatusOK, body)
+}
+
+func (s *Store) handleGraphGetMessage(c *gin.Context) {
+	user, ok := s.findUser(c.Param("userId"))
+	if !ok {
+		graphError(c, http.StatusNotFound, "ErrorInvalidUser", fmt.Sprintf("The requested user '%s' is invalid.", c.Param("userId")))
+		return
+	}
+	email, ok := s.GetGoogleEmail(user.ID, c.Param("id"))
+	if !ok {
+		graphError(c, http.StatusNotFound, "ErrorItemNotFound", "The specified object was not found in the store.")
+		return
+	}
+	msg, err := toGraphMessage(email)
+	if err != nil {
+		graphError(c, http.StatusInternalServerError, "ErrorInternalServerError", err.Error())
+		return
+	}
+	resource := graphSelect(msg, c.Query("$select"), graphMessageDefaults)
+	resource["@odata.context"] = fmt.Sprintf("%s/$metadata#users('%s')/messages/$entity", graphBaseURL, user.ID)
+	c.JSON(http.StatusOK, resource)
+}
@@ -0,0 +1,196 @@
+package mock
+
+import (
+	"encoding/base64"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+// newCompatServer serves a store of users users, with 2 emails each, in a compatibility profile
+func newCompatServer(t *testing.T, compat string, users int) (*httptest.Server, *Store) {
+	t.Helper()
+	router, s, err := NewRouter(Options{Users: users, EmailsPerTick: 2, Compat: compat})
+	if err != nil {
+		t.Fatalf("NewRouter: %v", err)
+	}
+	s.GenerateEmails()
+	srv := httptest.NewServer(router)
+	t.Cleanup(srv.Close)
+	return srv, s
+}
+
+// getJSON decodes the response to a GET into v (reset first), failing on any other status than want
+func getJSON(t *testing.T, rawURL string, want int, v any) {
+	t.Helper()
+	reflect.ValueOf(v).Elem().SetZero()
+	resp, err := http.Get(rawURL)
+	if err != nil {
+		t.Fatalf("GET %s: %v", rawURL, err)
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != want {
+		t.Fatalf("GET %s: status %d, want %d", rawURL, resp.StatusCode, want)
+	}
+	if err := json.NewDecoder(resp.Body).Decode(v); err != nil {
+		t.Fatalf("GET %s: %v", rawURL, err)
+	}
+}
+
+func TestParseCompat(t *testing.T) {
+	for name, want := range map[string]string{"": CompatNative, "native": CompatNative, "Gmail": CompatGmail, "graph": CompatGraph} {
+		if got, err := ParseCompat(name); err != nil || got != want {
+			t.Errorf("ParseCompat(%q) = %q, %v; want %q", name, got, err, want)
+		}
+	}
+	if _, _, err := NewRouter(Options{Compat: "exchange"}); err == nil {
+		t.Error("unknown compatibility profile accepted")
+	}
+}
+
+func TestGmailCompat(t *testing.T) {
+	srv, s := newCompatServer(t, CompatGmail, 3)
+
+	// Directory users, paged
+	var users struct {
+		Kind  string `json:"kind"`
+		Users []struct {
+			ID           string `json:"id"`
+			PrimaryEmail string `json:"primaryEmail"`
+			Name         struct {
+				FullName string `json:"fullName"`
+			} `json:"name"`
+		} `json:"users"`
+		NextPageToken string `json:"nextPageToken"`
+	}
+	getJSON(t, srv.URL+"/admin/directory/v1/users?customer=my_customer&maxResults=2", http.StatusOK, &users)
+	if users.Kind != "admin#directory#users" || len(users.Users) != 2 || users.NextPageToken == "" {
+		t.Fatalf("first page = %+v, want 2 users and a next page", users)
+	}
+	getJSON(t, srv.URL+"/admin/directory/v1/users?maxResults=2&pageToken="+users.NextPageToken, http.StatusOK, &users)
+	if len(users.Users) != 1 || users.NextPageToken != "" {
+		t.Fatalf("last page = %+v, want 1 user", users)
+	}
+
+	// Messages by mailbox address, then one in full format
+	mailbox := users.Users[0].PrimaryEmail
+	var list struct {
+		Messages []struct {
+			ID       string `json:"id"`
+			ThreadID string `json:"threadId"`
+		} `json:"messages"`
+		ResultSizeEstimate int `json:"resultSizeEstimate"`
+	}
+	getJSON(t, srv.URL+"/gmail/v1/users/"+url.PathEscape(mailbox)+"/messages?q=after:0", http.StatusOK, &list)
+	if len(list.Messages) != 2 || list.ResultSizeEstimate != 2 {
+		t.Fatalf("listed %+v, want 2 messages", list)
+	}
+
+	var msg gmailMessage
+	getJSON(t, srv.URL+"/gmail/v1/users/"+mailbox+"/messages/"+list.Messages[0].ID, http.StatusOK, &msg)
+	if msg.Payload == nil || msg.InternalDate == "" {
+		t.Fatalf("message = %+v, want a payload", msg)
+	}
+	text := msg.Payload.Body.Data
+	if len(msg.Payload.Parts) > 0 {
+		text = msg.Payload.Parts[0].Body.Data
+	}
+	decoded, err := base64.RawURLEncoding.DecodeString(text)
+	if err != nil || !strings.Contains(string(decoded), "Message ID: "+msg.ID) {
+		t.Errorf("text part = %q, %v; want the decoded body", decoded, err)
+	}
+
+	getJSON(t, srv.URL+"/gmail/v1/users/"+mailbox+"/messages/"+msg.ID+"?format=raw", http.StatusOK, &msg)
+	if raw, _ := base64.RawURLEncoding.DecodeString(msg.Raw); !strings.Contains(string(raw), "Message-ID: <"+msg.ID) {
+		t.Errorf("raw message lacks its Message-ID header")
+	}
+
+	var apiErr struct {
+		Error struct {
+			Code   int    `json:"code"`
+			Status string `json:"status"`
+		} `json:"error"`
+	}
+	getJSON(t, srv.URL+"/gmail/v1/users/"+mailbox+"/messages/nope", http.StatusNotFound, &apiErr)
+	if apiErr.Error.Code != 404 || apiErr.Error.Status != "NOT_FOUND" {
+		t.Errorf("error = %+v, want a Google API NOT_FOUND error", apiErr)
+	}
+
+	// The native API is unchanged
+	var native []map[string]any
+	getJSON(t, srv.URL+"/google/users/"+s.TenantID().String(), http.StatusOK, &native)
+	if len(native) != 3 || native[0]["email"] == nil {
+		t.Errorf("native users = %v", native)
+	}
+}
+
+func TestGraphCompat(t *testing.T) {
+	srv, _ := newCompatServer(t, CompatGraph, 3)
+
+	var users struct {
+		Context  string           `json:"@odata.context"`
+		Value    []map[string]any `json:"value"`
+		NextLink string           `json:"@odata.nextLink"`
+	}
+	getJSON(t, srv.URL+"/v1.0/users?$top=2", http.StatusOK, &users)
+	if !strings.HasSuffix(users.Context, "$metadata#users") || len(users.Value) != 2 || users.NextLink == "" {
+		t.Fatalf("first page = %+v, want 2 users and a next link", users)
+	}
+	if _, ok := users.Value[0]["accountEnabled"]; ok {
+		t.Error("accountEnabled returned without $select")
+	}
+	getJSON(t, users.NextLink, http.StatusOK, &users)
+	if len(users.Value) != 1 || users.NextLink != "" {
+		t.Fatalf("last page = %+v, want 1 user", users)
+	}
+	getJSON(t, srv.URL+"/v1.0/users?$select=id,accountEnabled", http.StatusOK, &users)
+	if len(users.Value[0]) != 2 || users.Value[0]["accountEnabled"] != true {
+		t.Errorf("selected user = %v, want id and accountEnabled", users.Value[0])
+	}
+
+	userID := users.Value[0]["id"].(string)
+	var messages struct {
+		Value []struct {
+			ID               string `json:"id"`
+			ReceivedDateTime string `json:"receivedDateTime"`
+			Body             struct {
+				ContentType string `json:"contentType"`
+				Content     string `json:"content"`
+			} `json:"body"`
+			From struct {
+				EmailAddress struct {
+					Address string `json:"address"`
+				} `json:"emailAddress"`
+			} `json:"from"`
+		} `json:"value"`
+	}
+	filter := url.QueryEscape("receivedDateTime ge 2000-01-01T00:00:00Z")
+	getJSON(t, srv.URL+"/v1.0/users/"+userID+"/messages?$filter="+filter+"&$orderby=receivedDateTime%20asc", http.StatusOK, &messages)
+	if len(messages.Value) != 2 || messages.Value[0].ReceivedDateTime > messages.Value[1].ReceivedDateTime {
+		t.Fatalf("listed %+v, want 2 messages oldest first", messages.Value)
+	}
+	first := messages.Value[0]
+	if first.From.EmailAddress.Address == "" || !strings.Contains(first.Body.Content, first.ID) {
+		t.Errorf("message = %+v, want a sender and the decoded body", first)
+	}
+
+	var msg map[string]any
+	getJSON(t, srv.URL+"/v1.0/users/"+userID+"/messages/"+first.ID+"?$select=internetMessageHeaders", http.StatusOK, &msg)
+	if msg["internetMessageHeaders"] == nil || msg["body"] != nil {
+		t.Errorf("selected message = %v, want its headers only", msg)
+	}
+
+	var apiErr struct {
+		Error struct {
+			Code string `json:"code"`
+		} `json:"error"`
+	}
+	getJSON(t, srv.URL+"/v1.0/users/"+userID+"/messages?$filter=isRead%20eq%20false", http.StatusBadRequest, &apiErr)
+	if apiErr.Error.Code != "ErrorInvalidUrlQueryFilter" {
+		t.Errorf("error = %+v, want a Graph error", apiErr)
+	}
+}
@@ -0,0 +1,60 @@
+package mock
+
+import (
+	"strings"
+
+	"github.com/stoik/vigil/services/mock-server/internal/models"
+)
+
+const (
+	executiveOU   = "/Executive"
+	groupDomain   = "company.com"
+	spanOfControl = 8 // Direct reports per manager in the generated org chart
+)
+
+// executiveTitles go to the first users, who form the top of the org chart (VIPs)
+var executiveTitles = []string{
+	"Chief Executive Officer",
+	"Chief Financial Officer",
+	"Chief Technology Officer",
+	"Chief Operating Officer",
+}
+
+var orgUnits = []string{"/Engineering", "/Sales", "/Finance", "/Marketing", "/HR", "/Legal"}
+
+var titlesByOU = map[string][]string{
+	"/Engineering": {"Software Engineer", "Senior Software Engineer", "Engineering Manager", "Site Reliability Engineer"},
+	"/Sales":       {"Account Executive", "Sales Development Representative", "Sales Manager"},
+	"/Finance":     {"Accountant", "Financial Analyst", "Controller", "Accounts Payable Specialist"},
+	"/Marketing":   {"Marketing Specialist", "Content Manager", "Product Marketing Manager"},
+	"/HR":          {"HR Business Partner", "Recruiter", "Payroll Specialist"},
+	"/Legal":       {"Legal Counsel", "Paralegal", "Compliance Officer"},
+}
+
+// applyDirectoryAttributes fills org unit, title, manager and groups for the user at index
+// The org chart is a tree: user i reports to user (i-1)/spanOfControl, so managers are
+// always generated before their reports and attributes are stable across restarts
+func applyDirectoryAttributes(user *models.ProviderUser, index int) {
+	if index < len(executiveTitles) {
+		user.OrgUnit = executiveOU
+		user.Title = executiveTitles[index]
+	} else {
+		user.OrgUnit = orgUnits[index%len(orgUnits)]
+		titles := titlesByOU[user.OrgUnit]
+		user.Title = titles[index%len(titles)]
+	}
+
+	if index > 0 {
+		user.Manager = userEmailFor((index - 1) / spanOfControl)
+	}
+
+	ouName := strings.ToLower(strings.TrimPrefix(user.OrgUnit, "/"))
+	user.Groups = []string{"all@" + groupDomain, ouName + "@" + groupDomain}
+	if user.OrgUnit == executiveOU {
+		user.Groups = append(user.Groups, "vip@"+groupDomain)
+	}
+	if user.OrgUnit == "/Finance" {
+		// Payment approvers are a classic BEC target
+		user.Groups = append(user.Groups, "payments-approvers@"+groupDomain)
+	}
+}
@@ -0,0 +1,138 @@
+package mock
+
+import (
+	"fmt"
+	"log"
+	"time"
+
+	"github.com/google/uuid"
+)
+
+const (
+	MaxUsersPerRequest = 1_000_000 // Largest numUsers accepted by one add request
+	MaxTotalUsers      = 2_000_000 // Upper bound on the user list
+	AsyncUsersAbove    = 10_000    // Additions above this size run as background jobs
+	addUsersChunk      = 1_000     // Users added per store lock acquisition in a job
+	maxRetainedJobs    = 100       // Finished jobs kept for status queries
+)
+
+// Job states
+const (
+	JobRunning   = "running"
+	JobSucceeded = "succeeded"
+	JobFailed    = "failed"
+)
+
+// Job is a background bulk operation
+type Job struct {
+	ID         string     `json:"id"`
+	Kind       string     `json:"kind"`
+	State      string     `json:"state"`
+	Total      int        `json:"total"`
+	Done       int        `json:"done"`
+	Error      string     `json:"error,omitempty"`
+	CreatedAt  time.Time  `json:"created_at"`
+	FinishedAt *time.Time `json:"finished_at,omitempty"`
+}
+
+// StartAddUsersJob adds numUsers users in the background, in chunks, so polls and other
+// admin calls are not blocked for the whole addition
+func (s *Store) StartAddUsersJob(numUsers int) (Job, error) {
+	if numUsers < 1 {
+		return Job{}, fmt.Errorf("numUsers must be at least 1")
+	}
+	if numUsers > MaxUsersPerRequest {
+		return Job{}, fmt.Errorf("numUsers must be at most %d", MaxUsersPerRequest)
+	}
+
+	s.userListMutex.RLock()
+	total := len(s.userList)
+	s.userListMutex.RUnlock()
+	if total+numUsers > MaxTotalUsers {
+		return Job{}, fmt.Errorf("adding %d users would exceed the limit of %d users", numUsers, MaxTotalUsers)
+	}
+
+	job := &Job{
+		ID:        uuid.NewString(),
+		Kind:      "add_users",
+		State:     JobRunning,
+		Total:     numUsers,
+		CreatedAt: s.now(),
+	}
+
+	s.jobsMutex.Lock()
+	s.jobs[job.ID] = job
+	s.jobOrder = append(s.jobOrder, job.ID)
+	s.evictJobsLocked()
+	snapshot := *job
+	s.jobsMutex.Unlock()
+
+	go s.runAddUsersJob(job)
+	return snapshot, nil
+}
+
+func (s *Store) runAddUsersJob(job *Job) {
+	for done := 0; done < job.Total; {
+		chunk := addUsersChunk
+		if remaining := job.Total - done; remaining < chunk {
+			chunk = remaining
+		}
+
+		total, err := s.AddUsers(chunk)
+		if err != nil {
+			s.finishJob(job, err)
+			log.Printf("Job %s failed after %d/%d users: %v", job.ID, done, job.Total, err)
+			return
+		}
+		done += chunk
+
+		s.jobsMutex.Lock()
+		job.Done = done
+		s.jobsMutex.Unlock()
+
+		if done == job.Total {
+			log.Printf("Job %s added %d users, total users: %d", job.ID, job.Total, total)
+		}
+	}
+	s.finishJob(job, nil)
+}
+
+func (s *Store) finishJob(job *Job, err error) {
+	s.jobsMutex.Lock()
+	defer s.jobsMutex.Unlock()
+
+	now := s.now()
+	job.FinishedAt = &now
+	job.State = JobSucceeded
+	if err != nil {
+		job.State = JobFailed
+		job.Error = err.Error()
+	}
+}
+
+// GetJob returns a snapshot of a job's status
+func (s *Store) GetJob(id string) (Job, bool) {
+	s.jobsMutex.RLock()
+	defer s.jobsMutex.RUnlock()
+
+	job, ok := s.jobs[id]
+	if !ok {
+		return Job{}, false
+	}
+	return *job, true
+}
+
+// evictJobsLocked drops the oldest finished jobs beyond maxRetainedJobs
+func (s *Store) evictJobsLocked() {
+	excess := len(s.jobOrder) - maxRetainedJobs
+	kept := s.jobOrder[:0]
+	for _, id := range s.jobOrder {
+		if excess > 0 && s.jobs[id].State != JobRunning {
+			delete(s.jobs, id)
+			excess--
+			continue
+		}
+		kept = append(kept, id)
+	}
+	s.jobOrder = kept
+}
@@ -0,0 +1,56 @@
+package mock
+
+import (
+	"testing"
+	"time"
+)
+
+func TestAddUsersBounds(t *testing.T) {
+	s := newTestStore(t, 0)
+
+	if _, err := s.AddUsers(MaxUsersPerRequest + 1); err == nil {
+		t.Error("AddUsers accepted more than MaxUsersPerRequest users")
+	}
+	if _, err := s.StartAddUsersJob(0); err == nil {
+		t.Error("StartAddUsersJob accepted 0 users")
+	}
+	if _, err := s.StartAddUsersJob(MaxUsersPerRequest + 1); err == nil {
+		t.Error("StartAddUsersJob accepted more than MaxUsersPerRequest users")
+	}
+}
+
+func TestAddUsersJob(t *testing.T) {
+	s := newTestStore(t, 10)
+	users, _ := s.GetGoogleUsers(s.TenantID())
+	before := len(users)
+
+	numUsers := 2*addUsersChunk + 7
+	job, err := s.StartAddUsersJob(numUsers)
+	if err != nil {
+		t.Fatalf("StartAddUsersJob: %v", err)
+	}
+	if job.State != JobRunning || job.Total != numUsers {
+		t.Errorf("job = %+v", job)
+	}
+
+	deadline := time.Now().Add(5 * time.Second)
+	for {
+		job, _ = s.GetJob(job.ID)
+		if job.State != JobRunning || time.Now().After(deadline) {
+			break
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+	if job.State != JobSucceeded || job.Done != numUsers || job.FinishedAt == nil {
+		t.Fatalf("job = %+v, want succeeded with %d users", job, numUsers)
+	}
+
+	users, _ = s.GetGoogleUsers(s.TenantID())
+	if len(users) != before+numUsers {
+		t.Errorf("users = %d, want %d", len(users), before+numUsers)
+	}
+
+	if _, ok := s.GetJob("missing"); ok {
+		t.Error("GetJob found a job that does not exist")
+	}
+}
@@ -0,0 +1,771 @@
+// Package mock is an in-memory mail provider for local runs and tests
+//
+// The standalone mock server serves it over HTTP, and other packages' tests can mount
+// the same API in an httptest.Server:
+//
+//	router, store, err := mock.NewRouter(mock.Options{Users: 10})
+//	srv := httptest.NewServer(router)
+//	store.GenerateEmails() // One generation cycle, no need to Start the store
+package mock
+
+import (
+	"fmt"
+	"log"
+	"math/rand"
+	"slices"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/stoik/vigil/internal/clock"
+	"github.com/stoik/vigil/services/mock-server/internal/middleware"
+	"github.com/stoik/vigil/services/mock-server/internal/models"
+)
+
+var (
+	firstNames = []string{"John", "Jane", "Bob", "Alice", "Charlie", "Diana", "Eve", "Frank"}
+	lastNames  = []string{"Smith", "Johnson", "Williams", "Brown", "Jones", "Garcia", "Miller", "Davis"}
+	domains    = []string{"example.com", "company.com", "business.org", "enterprise.net"}
+	subjects   = []string{
+		"Meeting tomorrow",
+		"Project update",
+		"Budget review",
+		"Team lunch",
+		"Quarterly report",
+		"Client feedback",
+		"Urgent: Action required",
+		"Follow up",
+	}
+
+	DefaultTenantID = uuid.MustParse("00000000-0000-0000-0000-000000000001")
+)
+
+const (
+	DefaultUsers              = 5000             // Users of the standalone server
+	DefaultGenerationInterval = 30 * time.Second // How often mailboxes receive new emails
+	maxEmailsPerTick          = 1000             // Per mailbox, keeps a typo from exhausting memory
+)
+
+// randSource is the randomness behind generated content: the shared math/rand source, or in
+// scale mode a source seeded per user and generation window, so an email is the same every
+// time it is generated
+type randSource interface {
+	Intn(n int) int
+	Int63() int64
+	Int63n(n int64) int64
+	Float64() float64
+}
+
+// globalRand is the shared math/rand source
+type globalRand struct{}
+
+func (globalRand) Intn(n int) int       { return rand.Intn(n) }
+func (globalRand) Int63() int64         { return rand.Int63() }
+func (globalRand) Int63n(n int64) int64 { return rand.Int63n(n) }
+func (globalRand) Float64() float64     { return rand.Float64() }
+
+// Options configures a mock store. The zero value is an empty, quiet store with every
+// simulation off; DefaultOptions matches the standalone server.
+type Options struct {
+	TenantID           uuid.UUID     // Tenant of generated users (default DefaultTenantID)
+	Users              int           // Users created up front
+	GenerationInterval time.Duration // Time between generation cycles once started (default 30s)
+	EmailsPerTick      int           // Emails every mailbox receives per cycle (0 = per its profile, 0-3 by default)
+	Clock              clock.Clock   // Timestamps and generation/churn cycles (default clock.Real)
+	Scale              bool          // Generate emails on request instead of storing them, see scale.go
+
+	DuplicateRate       float64       // See SetDuplicateRate
+	LateArrivalRate     float64       // See SetLateArrival
+	LateArrivalMaxDelay time.Duration // Default 10m
+	ChurnRate           float64       // See SetChurn
+	ChurnInterval       time.Duration // Default 1m
+	CampaignRate        float64       // See SetCampaigns
+	CampaignMinSize     int           // Default 50
+	CampaignMaxSize     int           // Default 500
+
+	// Admin endpoint protection (0 = 10 req/s, burst 20, 64KB bodies)
+	AdminRateLimit    float64
+	AdminRateBurst    int
+	AdminMaxBodyBytes int64
+
+	Verbosity middleware.Verbosity // Access logs (zero value: none)
+	Compat    string               // Also serve a real provider API, see ParseCompat (default: native only)
+}
+
+// DefaultOptions returns the options of the standalone server
+func DefaultOptions() Options {
+	return Options{Users: DefaultUsers, Verbosity: middleware.VerbosityInfo}
+}
+
+// Store is a mock mail provider: a user directory and mailboxes that receive generated
+// emails, with simulations of provider quirks (duplicate deliveries, late arrivals, user
+// churn, bulk campaigns). Stores share no state, so tests can run as many as they need.
+// Nothing runs in the background until Start.
+type Store struct {
+	tenantID           uuid.UUID
+	generationInterval time.Duration
+	emailsPerTick      int
+	clock              clock.Clock
+
+	// Scale mode: mailboxes hold campaign deliveries only, other emails are generated on request
+	// from generation window scaleFrom on. scaleUsers maps user IDs to their generation index.
+	// Guarded by emailStoreMutex
+	scale      bool
+	scaleFrom  int64
+	scaleUsers map[uuid.UUID]int
+
+	// Background generation and churn, between Start and Stop
+	lifecycleMutex sync.Mutex
+	stop           chan struct{}
+	stopped        sync.WaitGroup
+
+	// Static user list - maintained across calls
+	userList      []models.ProviderUser
+	userListMutex sync.RWMutex
+	userCounter   int // Counter for generating unique user names
+
+	// Email storage - maintained in memory per user
+	emailStore      map[uuid.UUID][]models.ProviderEmail
+	emailStoreMutex sync.RWMutex
+
+	// Ground truth: every email generated per user, kept even after the user churns out
+	// Guarded by emailStoreMutex
+	groundTruth map[uuid.UUID][]models.GroundTruthEmail
+
+	// Duplicate-delivery simulation (provider eventual-consistency quirks)
+	// Probability that a poll re-returns an email already served in a previous poll
+	duplicateRate      float64
+	duplicateRateMutex sync.RWMutex
+
+	// Out-of-order timestamp simulation (late arrivals, backdated messages)
+	// Probability that a generated email carries a received_at older than the last poll window
+	lateArrivalRate     float64
+	lateArrivalMaxDelay time.Duration
+	lateArrivalMutex    sync.RWMutex
+
+	// User churn simulation: fraction of users replaced every churnInterval
+	churnRate     float64
+	churnInterval time.Duration
+	churnMutex    sync.RWMutex
+
+	// Periodic campaign simulation: probability of a campaign per generation cycle, and its size range
+	campaignRate    float64
+	campaignMinSize int
+	campaignMaxSize int
+	campaignMutex   sync.RWMutex
+
+	// Recently launched campaigns, oldest first
+	campaigns      []Campaign
+	campaignsMutex sync.RWMutex
+
+	// Profile assignment per user, users not in the map use DefaultProfile
+	userProfiles      map[uuid.UUID]string
+	userProfilesMutex sync.RWMutex
+
+	// Background bulk operations
+	jobs      map[string]*Job
+	jobOrder  []string // Creation order, for evicting old finished jobs
+	jobsMutex sync.RWMutex
+}
+
+// NewStore creates a store with opts.Users users and empty mailboxes
+func NewStore(opts Options) (*Store, error) {
+	if opts.TenantID == uuid.Nil {
+		opts.TenantID = DefaultTenantID
+	}
+	if opts.Users < 0 || opts.Users > MaxTotalUsers {
+		return nil, fmt.Errorf("users must be between 0 and %d", MaxTotalUsers)
+	}
+	if opts.GenerationInterval == 0 {
+		opts.GenerationInterval = DefaultGenerationInterval
+	}
+	if opts.GenerationInterval < time.Millisecond {
+		return nil, fmt.Errorf("generation interval must be at least 1ms")
+	}
+	if opts.EmailsPerTick < 0 || opts.EmailsPerTick > maxEmailsPerTick {
+		return nil, fmt.Errorf("emails per tick must be between 0 and %d", maxEmailsPerTick)
+	}
+	if opts.Clock == nil {
+		opts.Clock = clock.Real
+	}
+	if opts.LateArrivalMaxDelay == 0 {
+		opts.LateArrivalMaxDelay = max(10*time.Minute, 2*opts.GenerationInterval)
+	}
+	if opts.ChurnInterval == 0 {
+		opts.ChurnInterval = time.Minute
+	}
+	if opts.CampaignMinSize == 0 {
+		opts.CampaignMinSize = 50
+	}
+	if opts.CampaignMaxSize == 0 {
+		opts.CampaignMaxSize = max(500, opts.CampaignMinSize)
+	}
+
+	s := &Store{
+		tenantID:           opts.TenantID,
+		generationInterval: opts.GenerationInterval,
+		emailsPerTick:      opts.EmailsPerTick,
+		clock:              opts.Clock,
+		scale:              opts.Scale,
+		scaleUsers:         make(map[uuid.UUID]int),
+		userList:           make([]models.ProviderUser, 0, opts.Users),
+		emailStore:         make(map[uuid.UUID][]models.ProviderEmail),
+		groundTruth:        make(map[uuid.UUID][]models.GroundTruthEmail),
+		userProfiles:       make(map[uuid.UUID]string),
+		jobs:               make(map[string]*Job),
+	}
+
+	// Setters validate the simulation settings
+	if err := s.SetDuplicateRate(opts.DuplicateRate); err != nil {
+		return nil, err
+	}
+	if err := s.SetLateArrival(opts.LateArrivalRate, opts.LateArrivalMaxDelay); err != nil {
+		return nil, err
+	}
+	if err := s.SetChurn(opts.ChurnRate, opts.ChurnInterval); err != nil {
+		return nil, err
+	}
+	if err := s.SetCampaigns(opts.CampaignRate, opts.CampaignMinSize, opts.CampaignMaxSize); err != nil {
+		return nil, err
+	}
+
+	if s.scale {
+		s.scaleFrom = s.windowOf(s.now())
+	}
+	for i := 0; i < opts.Users; i++ {
+		user := s.generateUser(i)
+		s.userList = append(s.userList, user)
+		s.addMailboxLocked(user.ID, i)
+	}
+	s.userCounter = opts.Users
+
+	return s, nil
+}
+
+// TenantID returns the tenant of the store's users
+func (s *Store) TenantID() uuid.UUID {
+	return s.tenantID
+}
+
+// now returns the time on the store's clock
+func (s *Store) now() time.Time {
+	return s.clock.Now()
+}
+
+// Start runs a generation cycle every generation interval and churns users (idle until
+// a churn rate is set) in the background, until Stop. Starting a started store is a no-op.
+func (s *Store) Start() {
+	s.lifecycleMutex.Lock()
+	defer s.lifecycleMutex.Unlock()
+	if s.stop != nil {
+		return
+	}
+
+	s.stop = make(chan struct{})
+	s.stopped.Add(2)
+	go func() {
+		defer s.stopped.Done()
+		s.generateEmailsPeriodically(s.stop)
+	}()
+	go func() {
+		defer s.stopped.Done()
+		s.churnUsersPeriodically(s.stop)
+	}()
+}
+
+// Stop stops background generation and churn and waits for them to exit
+// The store keeps its users and emails and can be started again.
+func (s *Store) Stop() {
+	s.lifecycleMutex.Lock()
+	defer s.lifecycleMutex.Unlock()
+	if s.stop == nil {
+		return
+	}
+
+	close(s.stop)
+	s.stopped.Wait()
+	s.stop = nil
+}
+
+func (s *Store) generateUser(index int) models.ProviderUser {
+	id := uuid.New()
+	if s.scale {
+		// Stable across restarts, so are the users' generated mailboxes
+		id = uuid.NewSHA1(s.tenantID, []byte(strconv.Itoa(index)))
+	}
+
+	user := models.ProviderUser{
+		ID:        id,
+		Email:     userEmailFor(index),
+		Name:      userNameFor(index),
+		TenantID:  s.tenantID,
+		Active:    true,
+		CreatedAt: s.now().Add(-time.Duration(rand.Intn(365)) * 24 * time.Hour),
+	}
+	applyDirectoryAttributes(&user, index)
+	return user
+}
+
+// addMailboxLocked creates the empty mailbox of the user generated at index
+// Caller must hold emailStoreMutex (or own the store, during NewStore)
+func (s *Store) addMailboxLocked(userID uuid.UUID, index int) {
+	s.emailStore[userID] = make([]models.ProviderEmail, 0)
+	if s.scale {
+		s.scaleUsers[userID] = index
+	}
+}
+
+// userNameFor returns the deterministic display name of the user at index
+func userNameFor(index int) string {
+	return fmt.Sprintf("%s %s", firstNames[index%len(firstNames)], lastNames[index%len(lastNames)])
+}
+
+// userEmailFor returns the deterministic email address of the user at index
+func userEmailFor(index int) string {
+	firstName := firstNames[index%len(firstNames)]
+	lastName := lastNames[index%len(lastNames)]
+	domain := domains[index%len(domains)]
+	return fmt.Sprintf("%s.%s.%d@%s", firstName, lastName, index, domain)
+}
+
+// GetGoogleUsers returns the static list of mocked Google users
+// Always returns the same list in the same order, regardless of tenantID
+func (s *Store) GetGoogleUsers(tenantID uuid.UUID) ([]models.ProviderUser, error) {
+	s.userListMutex.RLock()
+	defer s.userListMutex.RUnlock()
+
+	// Return a copy of the list to prevent external modification
+	users := make([]models.ProviderUser, len(s.userList))
+	copy(users, s.userList)
+
+	return users, nil
+}
+
+// AddUsers adds new users to the static list
+// Large additions should go through StartAddUsersJob, which does not hold the store locks throughout
+func (s *Store) AddUsers(numUsers int) (int, error) {
+	if numUsers < 1 {
+		return 0, fmt.Errorf("numUsers must be at least 1")
+	}
+	if numUsers > MaxUsersPerRequest {
+		return 0, fmt.Errorf("numUsers must be at most %d", MaxUsersPerRequest)
+	}
+
+	s.userListMutex.Lock()
+	s.emailStoreMutex.Lock()
+	defer s.userListMutex.Unlock()
+	defer s.emailStoreMutex.Unlock()
+
+	if len(s.userList)+numUsers > MaxTotalUsers {
+		return len(s.userList), fmt.Errorf("adding %d users would exceed the limit of %d users", numUsers, MaxTotalUsers)
+	}
+
+	for i := 0; i < numUsers; i++ {
+		user := s.generateUser(s.userCounter)
+		s.userList = append(s.userList, user)
+		s.addMailboxLocked(user.ID, s.userCounter)
+		s.userCounter++
+	}
+
+	return len(s.userList), nil
+}
+
+// ChurnUsers deactivates numUsers random users (removing them from the directory listing)
+// and creates as many new ones, returning the new total
+func (s *Store) ChurnUsers(numUsers int) (int, error) {
+	if numUsers < 1 {
+		return 0, fmt.Errorf("numUsers must be at least 1")
+	}
+
+	s.userListMutex.Lock()
+	s.emailStoreMutex.Lock()
+	defer s.userListMutex.Unlock()
+	defer s.emailStoreMutex.Unlock()
+
+	if numUsers > len(s.userList) {
+		numUsers = len(s.userList)
+	}
+
+	// Remove random users in place, keeping the listing order of the remaining ones
+	removed := make(map[int]bool, numUsers)
+	for _, i := range rand.Perm(len(s.userList))[:numUsers] {
+		removed[i] = true
+	}
+	kept := s.userList[:0]
+	for i, user := range s.userList {
+		if removed[i] {
+			delete(s.emailStore, user.ID)
+			delete(s.scaleUsers, user.ID)
+			s.clearUserProfile(user.ID)
+			continue
+		}
+		kept = append(kept, user)
+	}
+	s.userList = kept
+
+	for i := 0; i < numUsers; i++ {
+		user := s.generateUser(s.userCounter)
+		s.userList = append(s.userList, user)
+		s.addMailboxLocked(user.ID, s.userCounter)
+		s.userCounter++
+	}
+
+	return len(s.userList), nil
+}
+
+// SetChurn sets the fraction of users (0-1) replaced every interval (0 disables churn)
+func (s *Store) SetChurn(rate float64, interval time.Duration) error {
+	if rate < 0 || rate > 1 {
+		return fmt.Errorf("churn rate must be between 0 and 1")
+	}
+	if interval < time.Second {
+		return fmt.Errorf("churn interval must be at least 1s")
+	}
+
+	s.churnMutex.Lock()
+	defer s.churnMutex.Unlock()
+	s.churnRate = rate
+	s.churnInterval = interval
+	return nil
+}
+
+// GetChurn returns the current churn rate and interval
+func (s *Store) GetChurn() (float64, time.Duration) {
+	s.churnMutex.RLock()
+	defer s.churnMutex.RUnlock()
+	return s.churnRate, s.churnInterval
+}
+
+// churnUsersPeriodically replaces a fraction of users every churn interval
+// Settings are re-read every cycle so they can be changed at runtime
+func (s *Store) churnUsersPeriodically(stop <-chan struct{}) {
+	for {
+		rate, interval := s.GetChurn()
+		timer := s.clock.NewTimer(interval)
+		select {
+		case <-stop:
+			timer.Stop()
+			return
+		case <-timer.C():
+		}
+
+		if rate == 0 {
+			continue
+		}
+
+		s.userListMutex.RLock()
+		numUsers := int(float64(len(s.userList))*rate + 0.5)
+		s.userListMutex.RUnlock()
+		if numUsers < 1 {
+			numUsers = 1
+		}
+
+		total, err := s.ChurnUsers(numUsers)
+		if err != nil {
+			log.Printf("User churn failed: %v", err)
+			continue
+		}
+		log.Printf("User churn: replaced %d user(s), total users: %d", numUsers, total)
+	}
+}
+
+// generateEmailsPeriodically runs a generation cycle every generation interval
+func (s *Store) generateEmailsPeriodically(stop <-chan struct{}) {
+	ticker := s.clock.NewTicker(s.generationInterval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-stop:
+			return
+		case <-ticker.C():
+			s.GenerateEmails()
+		}
+	}
+}
+
+// GenerateEmails runs one generation cycle: every mailbox receives new emails
+// Volume and senders follow the user's mailbox profile (0-3 random emails by default),
+// unless the store has a fixed number of emails per tick. In scale mode mailboxes are
+// generated on request, so a cycle only launches campaigns.
+func (s *Store) GenerateEmails() {
+	s.userListMutex.RLock()
+	users := make([]models.ProviderUser, len(s.userList))
+	copy(users, s.userList)
+	s.userListMutex.RUnlock()
+
+	s.emailStoreMutex.Lock()
+	defer s.emailStoreMutex.Unlock()
+	now := s.now()
+	if s.scale {
+		s.simulateCampaign(users, now)
+		return
+	}
+
+	for _, user := range users {
+		profile := s.profileFor(user.ID)
+		numEmails := profile.emailsPerCycle(globalRand{})
+		if s.emailsPerTick > 0 {
+			numEmails = s.emailsPerTick
+		}
+
+		for i := 0; i < numEmails; i++ {
+			// Generate timestamp slightly before now (within the last generation interval)
+			// Spread them out a bit
+			receivedAt := now.Add(-time.Duration(rand.Int63n(int64(s.generationInterval))))
+			delay, backdated := s.simulateLateArrival(globalRand{})
+			if backdated {
+				receivedAt = now.Add(-delay)
+			}
+
+			// Get current email count for this user to use as unique identifier
+			emailCount := len(s.emailStore[user.ID])
+			from, subject := profile.pickSender(globalRand{})
+			email := generateEmail(globalRand{}, uuid.New(), user.ID, user.Email, user.Name, from, subject, receivedAt, emailCount, i)
+			s.emailStore[user.ID] = append(s.emailStore[user.ID], email)
+			s.groundTruth[user.ID] = append(s.groundTruth[user.ID], models.GroundTruthEmail{
+				MessageID:   email.MessageID,
+				ReceivedAt:  email.ReceivedAt,
+				GeneratedAt: now,
+				Backdated:   backdated,
+			})
+		}
+	}
+	s.simulateCampaign(users, now)
+}
+
+func generateEmail(rng randSource, messageID uuid.UUID, userID uuid.UUID, userEmail string, userName string, fromEmail string, subject string, receivedAt time.Time, emailIndex int, batchIndex int) models.ProviderEmail {
+	// Include recipient info in body to make emails unique per user
+	// Add multiple unique identifiers to ensure each email has a unique fingerprint
+	bodyContent := fmt.Sprintf(
+		"Dear %s (%s),\n\n"+
+			"Full email body for: %s\n\n"+
+			"This is mock content specifically for you.\n"+
+			"Received at: %s\n"+
+			"Message ID: %s\n"+
+			"Email index: %d\n"+
+			"Batch index: %d\n"+
+			"Random token: %d\n"+
+			"User ID: %s\n\n"+
+			"Best regards,\nThe Mock Server",
+		userName,
+		userEmail,
+		subject,
+		receivedAt.Format(time.RFC3339Nano), // Use nanosecond precision
+		messageID.String(),
+		emailIndex,
+		batchIndex,
+		rng.Intn(5000000), // Random token for extra uniqueness
+		userID.String(),
+	)
+
+	fullSubject := fmt.Sprintf("%s [%d]", subject, emailIndex) // Add index to subject too
+	rendered := renderEmail(rng, fromEmail, userEmail, fullSubject, bodyContent, messageID, receivedAt)
+
+	return models.ProviderEmail{
+		MessageID:  messageID.String(),
+		UserID:     userID,
+		From:       fromEmail,
+		To:         userEmail, // Send to the actual user
+		Subject:    fullSubject,
+		Snippet:    fmt.Sprintf("This is a snippet for: %s", subject),
+		ReceivedAt: receivedAt,
+		Body:       rendered.body,
+		Headers:    rendered.headers,
+	}
+}
+
+// SetDuplicateRate sets the probability (0-1) that a poll re-returns a previously served email
+func (s *Store) SetDuplicateRate(rate float64) error {
+	if rate < 0 || rate > 1 {
+		return fmt.Errorf("duplicate rate must be between 0 and 1")
+	}
+
+	s.duplicateRateMutex.Lock()
+	defer s.duplicateRateMutex.Unlock()
+	s.duplicateRate = rate
+	return nil
+}
+
+// GetDuplicateRate returns the current duplicate-delivery probability
+func (s *Store) GetDuplicateRate() float64 {
+	s.duplicateRateMutex.RLock()
+	defer s.duplicateRateMutex.RUnlock()
+	return s.duplicateRate
+}
+
+// simulateDuplicateDelivery re-returns an email older than receivedAfter (i.e. already
+// served by a previous poll), mimicking provider eventual-consistency quirks.
+// Half of the duplicates keep their message ID, the other half get a fresh one
+// with identical content, so both ID-based and fingerprint-based dedup are exercised.
+// profileRate adds the mailbox profile's own re-delivery probability to the global rate.
+func (s *Store) simulateDuplicateDelivery(userEmails []models.ProviderEmail, receivedAfter time.Time, profileRate float64) (models.ProviderEmail, bool) {
+	rate := 1 - (1-s.GetDuplicateRate())*(1-profileRate)
+	if rate == 0 || rand.Float64() >= rate {
+		return models.ProviderEmail{}, false
+	}
+
+	var served []models.ProviderEmail
+	for _, email := range userEmails {
+		if email.ReceivedAt.Before(receivedAfter) {
+			served = append(served, email)
+		}
+	}
+	if len(served) == 0 {
+		return models.ProviderEmail{}, false
+	}
+
+	duplicate := served[rand.Intn(len(served))]
+	if rand.Intn(2) == 0 {
+		duplicate.MessageID = uuid.New().String()
+	}
+	return duplicate, true
+}
+
+// SetLateArrival sets the probability (0-1) that a generated email is backdated,
+// and the maximum delay by which its received_at lags behind generation time
+func (s *Store) SetLateArrival(rate float64, maxDelay time.Duration) error {
+	if rate < 0 || rate > 1 {
+		return fmt.Errorf("late arrival rate must be between 0 and 1")
+	}
+	if maxDelay <= s.generationInterval {
+		return fmt.Errorf("late arrival max delay must be greater than the %v generation interval", s.generationInterval)
+	}
+
+	s.lateArrivalMutex.Lock()
+	defer s.lateArrivalMutex.Unlock()
+	s.lateArrivalRate = rate
+	s.lateArrivalMaxDelay = maxDelay
+	return nil
+}
+
+// GetLateArrival returns the current late arrival probability and maximum delay
+func (s *Store) GetLateArrival() (float64, time.Duration) {
+	s.lateArrivalMutex.RLock()
+	defer s.lateArrivalMutex.RUnlock()
+	return s.lateArrivalRate, s.lateArrivalMaxDelay
+}
+
+// simulateLateArrival decides whether the next generated email arrives late and by how much.
+// The delay is always beyond the generation window, so the email lands before the
+// cursor of a client that already polled past that point.
+func (s *Store) simulateLateArrival(rng randSource) (time.Duration, bool) {
+	rate, maxDelay := s.GetLateArrival()
+	if rate == 0 || rng.Float64() >= rate {
+		return 0, false
+	}
+
+	minDelay := s.generationInterval
+	return minDelay + time.Duration(rng.Int63n(int64(maxDelay-minDelay))), true
+}
+
+// GetGroundTruth returns the emails generated for a user with generation time in [from, to)
+// Duplicate re-deliveries are not included: they are not new emails
+func (s *Store) GetGroundTruth(userID uuid.UUID, from, to time.Time) models.GroundTruth {
+	s.emailStoreMutex.RLock()
+	defer s.emailStoreMutex.RUnlock()
+
+	result := models.GroundTruth{
+		UserID: userID,
+		From:   from,
+		To:     to,
+		Emails: make([]models.GroundTruthEmail, 0),
+	}
+	for _, email := range s.groundTruth[userID] {
+		if !email.GeneratedAt.Before(from) && email.GeneratedAt.Before(to) {
+			result.Emails = append(result.Emails, email)
+		}
+	}
+	if s.scale {
+		// Windows ending in [from, to), merged with the stored campaign deliveries
+		until := s.now()
+		if to.Before(until) {
+			until = to
+		}
+		for _, e := range s.generatedEmailsLocked(userID, from.Add(-s.generationInterval), until) {
+			if !e.generatedAt.Before(from) && e.generatedAt.Before(to) {
+				result.Emails = append(result.Emails, models.GroundTruthEmail{
+					MessageID:   e.messageID.String(),
+					ReceivedAt:  e.receivedAt,
+					GeneratedAt: e.generatedAt,
+					Backdated:   e.backdated,
+				})
+			}
+		}
+		slices.SortStableFunc(result.Emails, func(a, b models.GroundTruthEmail) int {
+			return a.GeneratedAt.Compare(b.GeneratedAt)
+		})
+	}
+	return result
+}
+
+// GetGoogleEmail returns a single email of a user by message ID
+func (s *Store) GetGoogleEmail(userID uuid.UUID, messageID string) (models.ProviderEmail, bool) {
+	s.emailStoreMutex.RLock()
+	defer s.emailStoreMutex.RUnlock()
+
+	for _, email := range s.emailStore[userID] {
+		if email.MessageID == messageID {
+			return email, true
+		}
+	}
+	if s.scale {
+		return s.findGeneratedLocked(userID, messageID)
+	}
+	return models.ProviderEmail{}, false
+}
+
+// GetGoogleEmails returns emails for a user, filtered by receivedAfter
+func (s *Store) GetGoogleEmails(userID uuid.UUID, receivedAfter time.Time, orderBy string) ([]models.ProviderEmail, error) {
+	s.emailStoreMutex.RLock()
+	defer s.emailStoreMutex.RUnlock()
+
+	userEmails, exists := s.emailStore[userID]
+	if !exists {
+		// User doesn't exist, return empty list
+		return []models.ProviderEmail{}, nil
+	}
+	if s.scale {
+		// The window before receivedAfter's is generated too: duplicates are picked among served emails
+		generated := s.generatedEmailsLocked(userID, receivedAfter.Add(-s.generationInterval), s.now())
+		userEmails = slices.Clip(userEmails)
+		for _, e := range generated {
+			userEmails = append(userEmails, s.renderGeneratedLocked(userID, e))
+		}
+	}
+
+	// Filter emails by receivedAfter, sized up front (count first, +1 for a simulated duplicate)
+	// Thousands of users poll every 30s, so growing the slice per email adds up
+	matching := 0
+	for i := range userEmails {
+		if !userEmails[i].ReceivedAt.Before(receivedAfter) {
+			matching++
+		}
+	}
+	filtered := make([]models.ProviderEmail, 0, matching+1)
+	for i := range userEmails {
+		if !userEmails[i].ReceivedAt.Before(receivedAfter) {
+			filtered = append(filtered, userEmails[i])
+		}
+	}
+
+	if duplicate, ok := s.simulateDuplicateDelivery(userEmails, receivedAfter, s.profileFor(userID).DuplicateRate); ok {
+		filtered = append(filtered, duplicate)
+	}
+
+	// Sort by received_at (slices.SortFunc avoids sort.Slice's reflection-based swapper)
+	if orderBy == "received_at" || orderBy == "" {
+		// Sort ascending
+		slices.SortFunc(filtered, func(a, b models.ProviderEmail) int {
+			return a.ReceivedAt.Compare(b.ReceivedAt)
+		})
+	} else if orderBy == "received_at desc" {
+		// Sort descending
+		slices.SortFunc(filtered, func(a, b models.ProviderEmail) int {
+			return b.ReceivedAt.Compare(a.ReceivedAt)
+		})
+	}
+
+	return filtered, nil
+}
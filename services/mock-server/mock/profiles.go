@@ -0,0 +1,236 @@
+package mock
+
+import (
+	"fmt"
+	"math/rand"
+	"sort"
+
+	"github.com/google/uuid"
+)
+
+const DefaultProfile = "default"
+
+// senderMix is a weighted source of senders and subjects within a profile
+type senderMix struct {
+	weight   int
+	senders  []string // Empty: random sender at a random domain
+	subjects []string // Empty: pickSubject()
+}
+
+// Profile describes how a mailbox behaves: volume, who writes to it, and how often
+// the provider re-delivers its emails
+type Profile struct {
+	Name          string  `json:"name"`
+	Description   string  `json:"description"`
+	Activity      float64 `json:"activity"`       // Probability of receiving anything in a generation cycle
+	MinEmails     int     `json:"min_emails"`     // Emails per active cycle
+	MaxEmails     int     `json:"max_emails"`     // Emails per active cycle
+	DuplicateRate float64 `json:"duplicate_rate"` // Extra re-delivery probability on top of the global rate
+	Users         int     `json:"users"`          // Users currently assigned (filled by ListProfiles)
+
+	mix []senderMix
+}
+
+var profiles = map[string]*Profile{
+	DefaultProfile: {
+		Name:        DefaultProfile,
+		Description: "Average mailbox: a few emails per cycle from random senders",
+		Activity:    1,
+		MinEmails:   0,
+		MaxEmails:   3,
+		mix:         []senderMix{{weight: 1}},
+	},
+	"quiet": {
+		Name:        "quiet",
+		Description: "Rarely used mailbox: an email every few cycles",
+		Activity:    0.25,
+		MinEmails:   0,
+		MaxEmails:   1,
+		mix:         []senderMix{{weight: 1}},
+	},
+	"newsletter": {
+		Name:          "newsletter",
+		Description:   "Subscribed to many newsletters: high volume, mostly bulk senders, occasional resends",
+		Activity:      1,
+		MinEmails:     2,
+		MaxEmails:     6,
+		DuplicateRate: 0.05,
+		mix: []senderMix{
+			{weight: 7,
+				senders:  []string{"news@dailybrief.io", "digest@techweekly.com", "noreply@deals.shop", "updates@saasproduct.com", "hello@marketingmail.net"},
+				subjects: []string{"Your weekly digest", "Top stories this week", "Exclusive offer inside", "Product update: what's new", "Last chance: 50% off"}},
+			{weight: 3},
+		},
+	},
+	"bec_target": {
+		Name:          "bec_target",
+		Description:   "Executive under business email compromise attack: lookalike-domain senders asking for payments",
+		Activity:      1,
+		MinEmails:     1,
+		MaxEmails:     4,
+		DuplicateRate: 0.02,
+		mix: []senderMix{
+			{weight: 1,
+				senders:  []string{"ceo@c0mpany.com", "finance@company-payments.com", "accounts@companny.com", "cfo@company.co", "vendor-billing@suppiier.com"},
+				subjects: []string{"Urgent wire transfer", "Updated bank details for invoice", "Are you available?", "Invoice payment overdue", "Confidential acquisition - keep this between us"}},
+			{weight: 2,
+				senders: []string{"assistant@company.com", "board@company.com", "legal@company.com"}},
+			{weight: 1},
+		},
+	},
+	"mailing_list": {
+		Name:          "mailing_list",
+		Description:   "Member of busy mailing lists: high volume, frequent re-deliveries through overlapping lists",
+		Activity:      1,
+		MinEmails:     3,
+		MaxEmails:     10,
+		DuplicateRate: 0.15,
+		mix: []senderMix{
+			{weight: 3,
+				senders:  []string{"dev-list@lists.company.com", "announce@lists.company.com", "golang-nuts@googlegroups.com", "users@lists.apache.org"},
+				subjects: []string{"[dev-list] Re: build failure on main", "[announce] Office closed Friday", "Re: proposal for new API", "[users] Question about configuration"}},
+			{weight: 2},
+		},
+	},
+}
+
+// ListProfiles returns the available profiles with their assigned user counts
+func (p *Store) ListProfiles() []Profile {
+	p.userProfilesMutex.RLock()
+	counts := make(map[string]int)
+	for _, name := range p.userProfiles {
+		counts[name]++
+	}
+	p.userProfilesMutex.RUnlock()
+
+	p.userListMutex.RLock()
+	total := len(p.userList)
+	p.userListMutex.RUnlock()
+
+	result := make([]Profile, 0, len(profiles))
+	assigned := 0
+	for name, prof := range profiles {
+		profile := *prof
+		profile.Users = counts[name]
+		assigned += counts[name]
+		result = append(result, profile)
+	}
+	for i := range result {
+		if result[i].Name == DefaultProfile {
+			result[i].Users = total - assigned + counts[DefaultProfile]
+		}
+	}
+	sort.Slice(result, func(i, j int) bool { return result[i].Name < result[j].Name })
+	return result
+}
+
+// SetUserProfile assigns a profile to a user
+func (s *Store) SetUserProfile(userID uuid.UUID, name string) error {
+	if _, ok := profiles[name]; !ok {
+		return fmt.Errorf("unknown profile %q", name)
+	}
+
+	s.emailStoreMutex.RLock()
+	_, exists := s.emailStore[userID]
+	s.emailStoreMutex.RUnlock()
+	if !exists {
+		return fmt.Errorf("user %s not found", userID)
+	}
+
+	s.userProfilesMutex.Lock()
+	defer s.userProfilesMutex.Unlock()
+	if name == DefaultProfile {
+		delete(s.userProfiles, userID)
+	} else {
+		s.userProfiles[userID] = name
+	}
+	return nil
+}
+
+// AssignProfile assigns a profile to up to count random users currently on the default
+// profile, returning how many were assigned
+func (s *Store) AssignProfile(name string, count int) (int, error) {
+	if _, ok := profiles[name]; !ok {
+		return 0, fmt.Errorf("unknown profile %q", name)
+	}
+	if count < 1 {
+		return 0, fmt.Errorf("count must be at least 1")
+	}
+
+	s.userListMutex.RLock()
+	candidates := make([]uuid.UUID, len(s.userList))
+	for i, user := range s.userList {
+		candidates[i] = user.ID
+	}
+	s.userListMutex.RUnlock()
+
+	s.userProfilesMutex.Lock()
+	defer s.userProfilesMutex.Unlock()
+
+	assigned := 0
+	for _, i := range rand.Perm(len(candidates)) {
+		if assigned == count {
+			break
+		}
+		if _, ok := s.userProfiles[candidates[i]]; ok {
+			continue
+		}
+		if name != DefaultProfile {
+			s.userProfiles[candidates[i]] = name
+		}
+		assigned++
+	}
+	return assigned, nil
+}
+
+// profileFor returns the profile assigned to a user
+func (s *Store) profileFor(userID uuid.UUID) *Profile {
+	s.userProfilesMutex.RLock()
+	defer s.userProfilesMutex.RUnlock()
+	if name, ok := s.userProfiles[userID]; ok {
+		return profiles[name]
+	}
+	return profiles[DefaultProfile]
+}
+
+// clearUserProfile drops the assignment of a removed user
+func (s *Store) clearUserProfile(userID uuid.UUID) {
+	s.userProfilesMutex.Lock()
+	defer s.userProfilesMutex.Unlock()
+	delete(s.userProfiles, userID)
+}
+
+// emailsPerCycle returns how many emails the mailbox receives in one generation cycle
+func (p *Profile) emailsPerCycle(rng randSource) int {
+	if rng.Float64() >= p.Activity {
+		return 0
+	}
+	return p.MinEmails + rng.Intn(p.MaxEmails-p.MinEmails+1)
+}
+
+// pickSender returns the sender address and subject of the next email
+func (p *Profile) pickSender(rng randSource) (string, string) {
+	total := 0
+	for _, m := range p.mix {
+		total += m.weight
+	}
+	n := rng.Intn(total)
+	mix := p.mix[len(p.mix)-1]
+	for _, m := range p.mix {
+		if n < m.weight {
+			mix = m
+			break
+		}
+		n -= m.weight
+	}
+
+	from := fmt.Sprintf("sender%d@%s", rng.Intn(50000), domains[rng.Intn(len(domains))])
+	if len(mix.senders) > 0 {
+		from = mix.senders[rng.Intn(len(mix.senders))]
+	}
+	subject := pickSubject(rng)
+	if len(mix.subjects) > 0 {
+		subject = mix.subjects[rng.Intn(len(mix.subjects))]
+	}
+	return from, subject
+}
@@ -0,0 +1,76 @@
+package mock
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/google/uuid"
+)
+
+func TestProfilesAreValid(t *testing.T) {
+	for name, p := range profiles {
+		if p.Name != name {
+			t.Errorf("profile %q has name %q", name, p.Name)
+		}
+		if p.MinEmails < 0 || p.MaxEmails < p.MinEmails || p.Activity <= 0 || p.Activity > 1 {
+			t.Errorf("profile %q has invalid volume settings: %+v", name, p)
+		}
+		if p.DuplicateRate < 0 || p.DuplicateRate > 1 || len(p.mix) == 0 {
+			t.Errorf("profile %q has invalid sender or duplicate settings", name)
+		}
+		for i := 0; i < 100; i++ {
+			if n := p.emailsPerCycle(globalRand{}); n < 0 || n > p.MaxEmails {
+				t.Fatalf("profile %q generated %d emails per cycle", name, n)
+			}
+			if from, subject := p.pickSender(globalRand{}); !strings.Contains(from, "@") || subject == "" {
+				t.Fatalf("profile %q picked sender %q subject %q", name, from, subject)
+			}
+		}
+	}
+}
+
+func TestSetUserProfile(t *testing.T) {
+	s := newTestStore(t, 10)
+	users, _ := s.GetGoogleUsers(s.TenantID())
+	userID := users[0].ID
+
+	if err := s.SetUserProfile(userID, "bec_target"); err != nil {
+		t.Fatalf("SetUserProfile: %v", err)
+	}
+	if prof := s.profileFor(userID); prof.Name != "bec_target" {
+		t.Errorf("profile = %s, want bec_target", prof.Name)
+	}
+	if err := s.SetUserProfile(userID, DefaultProfile); err != nil {
+		t.Fatalf("SetUserProfile: %v", err)
+	}
+	if prof := s.profileFor(userID); prof.Name != DefaultProfile {
+		t.Errorf("profile = %s, want %s", prof.Name, DefaultProfile)
+	}
+
+	if err := s.SetUserProfile(userID, "nope"); err == nil {
+		t.Error("unknown profile accepted")
+	}
+	if err := s.SetUserProfile(uuid.New(), "quiet"); err == nil {
+		t.Error("unknown user accepted")
+	}
+}
+
+func TestAssignProfile(t *testing.T) {
+	s := newTestStore(t, 100)
+
+	assigned, err := s.AssignProfile("newsletter", 10)
+	if err != nil || assigned != 10 {
+		t.Fatalf("AssignProfile = %d, %v; want 10", assigned, err)
+	}
+
+	counts := make(map[string]int)
+	total := 0
+	for _, prof := range s.ListProfiles() {
+		counts[prof.Name] = prof.Users
+		total += prof.Users
+	}
+	users, _ := s.GetGoogleUsers(s.TenantID())
+	if counts["newsletter"] != 10 || total != len(users) {
+		t.Errorf("profile counts = %v (total %d), want newsletter = 10 and total %d", counts, total, len(users))
+	}
+}
@@ -0,0 +1,392 @@
+package mock
+
+import (
+	"errors"
+	"fmt"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/google/uuid"
+	"github.com/stoik/vigil/services/mock-server/internal/middleware"
+)
+
+// NewRouter creates a store from opts and the gin router serving its API
+// The store's background generation only runs once it is started
+func NewRouter(opts Options) (*gin.Engine, *Store, error) {
+	compat, err := ParseCompat(opts.Compat)
+	if err != nil {
+		return nil, nil, err
+	}
+	s, err := NewStore(opts)
+	if err != nil {
+		return nil, nil, err
+	}
+	if opts.AdminRateLimit == 0 {
+		opts.AdminRateLimit = 10
+	}
+	if opts.AdminRateBurst == 0 {
+		opts.AdminRateBurst = 20
+	}
+	if opts.AdminMaxBodyBytes == 0 {
+		opts.AdminMaxBodyBytes = 64 * 1024
+	}
+
+	// Request ID first so access logs and recovered panics can reference it
+	r := gin.New()
+	r.Use(middleware.RequestID(), middleware.AccessLog(opts.Verbosity), middleware.Recovery())
+
+	// Health check
+	r.GET("/health", func(c *gin.Context) {
+		c.JSON(http.StatusOK, gin.H{"status": "ok"})
+	})
+
+	// Google provider endpoints
+	google := r.Group("/google")
+	{
+		google.GET("/users/:tenantId", s.handleGetGoogleUsers)
+		google.GET("/emails/:userId", s.handleGetGoogleEmails)
+		google.GET("/emails/:userId/:messageId", s.handleGetGoogleEmail)
+	}
+
+	// Admin endpoints for testing
+	admin := r.Group("/admin", middleware.RateLimit(opts.AdminRateLimit, opts.AdminRateBurst), middleware.MaxBodySize(opts.AdminMaxBodyBytes))
+	{
+		admin.POST("/users/add", s.handleAddUsers)
+		admin.GET("/jobs/:id", s.handleGetJob)
+		admin.GET("/profiles", s.handleListProfiles)
+		admin.POST("/profiles/:name/assign", s.handleAssignProfile)
+		admin.POST("/users/:userId/profile", s.handleSetUserProfile)
+		admin.POST("/simulation/duplicates", s.handleSetDuplicateRate)
+		admin.POST("/simulation/late-arrivals", s.handleSetLateArrival)
+		admin.POST("/simulation/churn", s.handleSetChurn)
+		admin.POST("/simulation/campaigns", s.handleSetCampaigns)
+		admin.POST("/campaigns", s.handleLaunchCampaign)
+		admin.GET("/campaigns", s.handleListCampaigns)
+		admin.GET("/ground-truth/:userId", s.handleGetGroundTruth)
+	}
+
+	// Real provider API shapes, for developing provider clients against the mock
+	s.mountCompat(r, compat)
+
+	return r, s, nil
+}
+
+func (s *Store) handleGetGoogleUsers(c *gin.Context) {
+	tenantIDStr := c.Param("tenantId")
+	tenantID, err := uuid.Parse(tenantIDStr)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "invalid tenant_id"})
+		return
+	}
+
+	users, err := s.GetGoogleUsers(tenantID)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+
+	c.JSON(http.StatusOK, users)
+}
+
+func (s *Store) handleGetGoogleEmails(c *gin.Context) {
+	userIDStr := c.Param("userId")
+	userID, err := uuid.Parse(userIDStr)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "invalid user_id"})
+		return
+	}
+
+	// Parse query parameters
+	receivedAfterStr := c.DefaultQuery("receivedAfter", "")
+	orderBy := c.DefaultQuery("orderBy", "received_at")
+
+	var receivedAfter time.Time
+	if receivedAfterStr == "" {
+		// Default to 24 hours ago
+		receivedAfter = s.now().Add(-24 * time.Hour)
+	} else {
+		var err error
+		receivedAfter, err = time.Parse(time.RFC3339, receivedAfterStr)
+		if err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "invalid receivedAfter format (use RFC3339)"})
+			return
+		}
+	}
+
+	emails, err := s.GetGoogleEmails(userID, receivedAfter, orderBy)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+
+	// format=metadata omits bodies (headers, subject and snippet only)
+	switch c.DefaultQuery("format", "full") {
+	case "full":
+	case "metadata":
+		for i := range emails {
+			emails[i].Body = ""
+		}
+	default:
+		c.JSON(http.StatusBadRequest, gin.H{"error": "invalid format (use full or metadata)"})
+		return
+	}
+
+	c.JSON(http.StatusOK, emails)
+}
+
+func (s *Store) handleGetGoogleEmail(c *gin.Context) {
+	userID, err := uuid.Parse(c.Param("userId"))
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "invalid user_id"})
+		return
+	}
+
+	email, ok := s.GetGoogleEmail(userID, c.Param("messageId"))
+	if !ok {
+		c.JSON(http.StatusNotFound, gin.H{"error": "email not found"})
+		return
+	}
+
+	if c.DefaultQuery("format", "full") == "metadata" {
+		email.Body = ""
+	}
+	c.JSON(http.StatusOK, email)
+}
+
+func (s *Store) handleAddUsers(c *gin.Context) {
+	var req struct {
+		NumUsers int `json:"numUsers"`
+	}
+
+	// Try JSON body first
+	if err := c.ShouldBindJSON(&req); err != nil {
+		var tooLarge *http.MaxBytesError
+		if errors.As(err, &tooLarge) {
+			c.JSON(http.StatusRequestEntityTooLarge, gin.H{"error": "request body too large"})
+			return
+		}
+		// Fall back to query parameter
+		numUsersStr := c.DefaultQuery("numUsers", "1")
+		if num, err := strconv.Atoi(numUsersStr); err == nil {
+			req.NumUsers = num
+		} else {
+			req.NumUsers = 1
+		}
+	}
+
+	// Default to 1 if not specified or invalid
+	if req.NumUsers < 1 {
+		req.NumUsers = 1
+	}
+	if req.NumUsers > MaxUsersPerRequest {
+		c.JSON(http.StatusBadRequest, gin.H{"error": fmt.Sprintf("numUsers must be at most %d", MaxUsersPerRequest)})
+		return
+	}
+
+	// Large additions run in the background, poll the job for progress
+	if req.NumUsers > AsyncUsersAbove {
+		job, err := s.StartAddUsersJob(req.NumUsers)
+		if err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+			return
+		}
+		c.Header("Location", "/admin/jobs/"+job.ID)
+		c.JSON(http.StatusAccepted, job)
+		return
+	}
+
+	totalUsers, err := s.AddUsers(req.NumUsers)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{
+		"added":   req.NumUsers,
+		"total":   totalUsers,
+		"message": fmt.Sprintf("Added %d user(s). Total users: %d", req.NumUsers, totalUsers),
+	})
+}
+
+func (s *Store) handleGetJob(c *gin.Context) {
+	job, ok := s.GetJob(c.Param("id"))
+	if !ok {
+		c.JSON(http.StatusNotFound, gin.H{"error": "job not found"})
+		return
+	}
+	c.JSON(http.StatusOK, job)
+}
+
+func (s *Store) handleListProfiles(c *gin.Context) {
+	c.JSON(http.StatusOK, s.ListProfiles())
+}
+
+func (s *Store) handleSetUserProfile(c *gin.Context) {
+	userID, err := uuid.Parse(c.Param("userId"))
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "invalid user_id"})
+		return
+	}
+
+	profile := c.Query("profile")
+	if err := s.SetUserProfile(userID, profile); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{"user_id": userID, "profile": profile})
+}
+
+func (s *Store) handleAssignProfile(c *gin.Context) {
+	count, err := strconv.Atoi(c.DefaultQuery("count", "1"))
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "invalid count"})
+		return
+	}
+
+	assigned, err := s.AssignProfile(c.Param("name"), count)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{"profile": c.Param("name"), "assigned": assigned})
+}
+
+func (s *Store) handleSetDuplicateRate(c *gin.Context) {
+	rate, err := strconv.ParseFloat(c.DefaultQuery("rate", "0"), 64)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "invalid rate"})
+		return
+	}
+
+	if err := s.SetDuplicateRate(rate); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{"duplicate_rate": rate})
+}
+
+func (s *Store) handleSetLateArrival(c *gin.Context) {
+	rate, err := strconv.ParseFloat(c.DefaultQuery("rate", "0"), 64)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "invalid rate"})
+		return
+	}
+
+	_, maxDelay := s.GetLateArrival()
+	if delayStr := c.Query("maxDelay"); delayStr != "" {
+		if maxDelay, err = time.ParseDuration(delayStr); err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "invalid maxDelay (use Go duration, e.g. 10m)"})
+			return
+		}
+	}
+
+	if err := s.SetLateArrival(rate, maxDelay); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{"late_arrival_rate": rate, "max_delay": maxDelay.String()})
+}
+
+func (s *Store) handleSetChurn(c *gin.Context) {
+	rate, err := strconv.ParseFloat(c.DefaultQuery("rate", "0"), 64)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "invalid rate"})
+		return
+	}
+
+	_, interval := s.GetChurn()
+	if intervalStr := c.Query("interval"); intervalStr != "" {
+		if interval, err = time.ParseDuration(intervalStr); err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "invalid interval (use Go duration, e.g. 1m)"})
+			return
+		}
+	}
+
+	if err := s.SetChurn(rate, interval); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{"churn_rate": rate, "interval": interval.String()})
+}
+
+func (s *Store) handleSetCampaigns(c *gin.Context) {
+	rate, err := strconv.ParseFloat(c.DefaultQuery("rate", "0"), 64)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "invalid rate"})
+		return
+	}
+
+	_, minSize, maxSize := s.GetCampaigns()
+	if v := c.Query("minSize"); v != "" {
+		if minSize, err = strconv.Atoi(v); err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "invalid minSize"})
+			return
+		}
+	}
+	if v := c.Query("maxSize"); v != "" {
+		if maxSize, err = strconv.Atoi(v); err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "invalid maxSize"})
+			return
+		}
+	}
+
+	if err := s.SetCampaigns(rate, minSize, maxSize); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{"campaign_rate": rate, "min_size": minSize, "max_size": maxSize})
+}
+
+func (s *Store) handleLaunchCampaign(c *gin.Context) {
+	size, err := strconv.Atoi(c.DefaultQuery("size", "100"))
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "invalid size"})
+		return
+	}
+
+	campaign, err := s.LaunchCampaign(size)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	c.JSON(http.StatusOK, campaign)
+}
+
+func (s *Store) handleListCampaigns(c *gin.Context) {
+	c.JSON(http.StatusOK, s.ListCampaigns())
+}
+
+func (s *Store) handleGetGroundTruth(c *gin.Context) {
+	userID, err := uuid.Parse(c.Param("userId"))
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "invalid user_id"})
+		return
+	}
+
+	// Default to everything generated so far
+	from := time.Time{}
+	to := s.now()
+	if fromStr := c.Query("from"); fromStr != "" {
+		if from, err = time.Parse(time.RFC3339, fromStr); err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "invalid from format (use RFC3339)"})
+			return
+		}
+	}
+	if toStr := c.Query("to"); toStr != "" {
+		if to, err = time.Parse(time.RFC3339, toStr); err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "invalid to format (use RFC3339)"})
+			return
+		}
+	}
+
+	c.JSON(http.StatusOK, s.GetGroundTruth(userID, from, to))
+}
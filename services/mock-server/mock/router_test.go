@@ -0,0 +1,175 @@
+package mock
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/stoik/vigil/internal/clock"
+	"github.com/stoik/vigil/services/mock-server/internal/models"
+)
+
+// newTestStore creates an isolated store with users users
+func newTestStore(t testing.TB, users int) *Store {
+	t.Helper()
+	s, err := NewStore(Options{Users: users})
+	if err != nil {
+		t.Fatalf("NewStore: %v", err)
+	}
+	return s
+}
+
+func TestNewValidatesOptions(t *testing.T) {
+	if _, err := NewStore(Options{DuplicateRate: 2}); err == nil {
+		t.Error("duplicate rate 2 accepted")
+	}
+	if _, err := NewStore(Options{Users: -1}); err == nil {
+		t.Error("negative user count accepted")
+	}
+	if _, err := NewStore(Options{CampaignMinSize: 10, CampaignMaxSize: 5}); err == nil {
+		t.Error("campaign minSize > maxSize accepted")
+	}
+}
+
+func TestStoresAreIsolated(t *testing.T) {
+	a, b := newTestStore(t, 3), newTestStore(t, 3)
+	if _, err := a.AddUsers(2); err != nil {
+		t.Fatalf("AddUsers: %v", err)
+	}
+	usersA, _ := a.GetGoogleUsers(a.TenantID())
+	usersB, _ := b.GetGoogleUsers(b.TenantID())
+	if len(usersA) != 5 || len(usersB) != 3 {
+		t.Errorf("users = %d and %d, want 5 and 3", len(usersA), len(usersB))
+	}
+}
+
+func TestRouterServesStore(t *testing.T) {
+	router, s, err := NewRouter(Options{Users: 4})
+	if err != nil {
+		t.Fatalf("NewRouter: %v", err)
+	}
+	srv := httptest.NewServer(router)
+	defer srv.Close()
+
+	get := func(path string, v any) {
+		t.Helper()
+		resp, err := http.Get(srv.URL + path)
+		if err != nil {
+			t.Fatalf("GET %s: %v", path, err)
+		}
+		defer resp.Body.Close()
+		if resp.StatusCode != http.StatusOK {
+			t.Fatalf("GET %s: status %d", path, resp.StatusCode)
+		}
+		if err := json.NewDecoder(resp.Body).Decode(v); err != nil {
+			t.Fatalf("GET %s: %v", path, err)
+		}
+	}
+
+	var users []models.ProviderUser
+	get("/google/users/"+s.TenantID().String(), &users)
+	if len(users) != 4 {
+		t.Fatalf("listed %d users, want 4", len(users))
+	}
+
+	// Generation is driven by the test, not a 30s ticker
+	before := time.Now().Add(-time.Hour)
+	s.GenerateEmails()
+	s.GenerateEmails()
+	generated := 0
+	for _, user := range users {
+		generated += len(s.GetGroundTruth(user.ID, before, time.Now().Add(time.Second)).Emails)
+	}
+
+	served := 0
+	for _, user := range users {
+		var emails []models.ProviderEmail
+		get(fmt.Sprintf("/google/emails/%s?receivedAfter=%s", user.ID, before.Format(time.RFC3339)), &emails)
+		served += len(emails)
+	}
+	if served != generated {
+		t.Errorf("served %d emails, want the %d generated", served, generated)
+	}
+}
+
+func TestStoreClock(t *testing.T) {
+	frozen := time.Date(2024, 3, 1, 12, 0, 0, 0, time.UTC)
+	s, err := NewStore(Options{Users: 20, Clock: clock.NewFake(frozen), GenerationInterval: time.Minute})
+	if err != nil {
+		t.Fatalf("NewStore: %v", err)
+	}
+	users, _ := s.GetGoogleUsers(s.TenantID())
+	for s.countEmails() == 0 {
+		s.GenerateEmails()
+	}
+	for _, user := range users {
+		for _, gt := range s.GetGroundTruth(user.ID, time.Time{}, frozen.Add(time.Second)).Emails {
+			if !gt.GeneratedAt.Equal(frozen) {
+				t.Fatalf("generated at %v, want the store clock %v", gt.GeneratedAt, frozen)
+			}
+			if gt.ReceivedAt.After(frozen) || gt.ReceivedAt.Before(frozen.Add(-time.Minute)) {
+				t.Fatalf("received at %v, want within the generation interval before %v", gt.ReceivedAt, frozen)
+			}
+		}
+	}
+}
+
+func TestStoreStartStop(t *testing.T) {
+	clk := clock.NewFake(time.Date(2024, 3, 1, 12, 0, 0, 0, time.UTC))
+	s, err := NewStore(Options{Users: 50, EmailsPerTick: 1, GenerationInterval: time.Minute, Clock: clk})
+	if err != nil {
+		t.Fatalf("NewStore: %v", err)
+	}
+
+	s.Start()
+	s.Start() // No-op
+	// Wait for the generation ticker and the churn timer
+	clk.BlockUntil(2)
+	if s.countEmails() != 0 {
+		t.Fatal("emails generated before the first cycle")
+	}
+	clk.Advance(time.Minute)
+	deadline := time.Now().Add(5 * time.Second)
+	for s.countEmails() < 50 && time.Now().Before(deadline) {
+		time.Sleep(time.Millisecond)
+	}
+	s.Stop()
+	s.Stop() // No-op
+
+	if n := s.countEmails(); n != 50 {
+		t.Fatalf("%d emails after one cycle, want 50", n)
+	}
+	clk.Advance(10 * time.Minute)
+	if n := s.countEmails(); n != 50 {
+		t.Errorf("emails went from 50 to %d after Stop", n)
+	}
+}
+
+// countEmails returns the number of emails in all mailboxes
+func (s *Store) countEmails() int {
+	s.emailStoreMutex.RLock()
+	defer s.emailStoreMutex.RUnlock()
+	n := 0
+	for _, emails := range s.emailStore {
+		n += len(emails)
+	}
+	return n
+}
+
+func TestEmailsPerTick(t *testing.T) {
+	s, err := NewStore(Options{Users: 10, EmailsPerTick: 3})
+	if err != nil {
+		t.Fatalf("NewStore: %v", err)
+	}
+	s.GenerateEmails()
+	if n := s.countEmails(); n != 30 {
+		t.Errorf("generated %d emails, want 3 per mailbox (30)", n)
+	}
+
+	if _, err := NewStore(Options{EmailsPerTick: -1}); err == nil {
+		t.Error("negative emails per tick accepted")
+	}
+}
@@ -0,0 +1,173 @@
+package mock
+
+import (
+	"encoding/binary"
+	"hash/fnv"
+	"math/rand"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/stoik/vigil/services/mock-server/internal/models"
+)
+
+// Scale mode
+//
+// Stored mailboxes grow by every email generated, which caps simulations at a few thousand
+// users. In scale mode emails are not stored: time is cut into generation windows (the
+// generation interval, aligned on the Unix epoch), and a user's emails of a window are a
+// deterministic function of the user ID and the window. Each request regenerates the windows
+// it covers, so memory only grows with the number of users, and 100k-user tenants fit on a
+// laptop. An email is visible once its window has ended, as if a generation cycle had
+// produced it then; history starts at the window the store was created in.
+//
+// Message IDs encode the user, window and position, so single-email lookups regenerate one
+// window. Profiles, emails per tick and late arrivals are read when a window is generated:
+// changing them also changes the past emails of windows not polled yet. Campaign deliveries
+// are still stored, and duplicates are picked among the emails of the windows polled.
+
+// generatedEmail is an email of a generation window, before rendering
+type generatedEmail struct {
+	messageID   uuid.UUID
+	window      int64
+	index       int // Position in the window
+	receivedAt  time.Time
+	generatedAt time.Time
+	backdated   bool
+}
+
+// windowOf returns the generation window containing t
+func (s *Store) windowOf(t time.Time) int64 {
+	return t.UnixNano() / int64(s.generationInterval)
+}
+
+// windowStart returns the start of a generation window
+func (s *Store) windowStart(window int64) time.Time {
+	return time.Unix(0, window*int64(s.generationInterval))
+}
+
+// windowEmails returns the emails a user received in a generation window, mirroring a
+// GenerateEmails cycle run at the end of the window
+func (s *Store) windowEmails(userID uuid.UUID, window int64) []generatedEmail {
+	rng := seededRand(userID, window, 0)
+	numEmails := s.profileFor(userID).emailsPerCycle(rng)
+	if s.emailsPerTick > 0 {
+		numEmails = s.emailsPerTick
+	}
+
+	generatedAt := s.windowStart(window + 1)
+	emails := make([]generatedEmail, numEmails)
+	for i := range emails {
+		receivedAt := generatedAt.Add(-time.Duration(rng.Int63n(int64(s.generationInterval))))
+		delay, backdated := s.simulateLateArrival(rng)
+		if backdated {
+			receivedAt = generatedAt.Add(-delay)
+		}
+		emails[i] = generatedEmail{
+			messageID:   generatedMessageID(userID, window, i),
+			window:      window,
+			index:       i,
+			receivedAt:  receivedAt,
+			generatedAt: generatedAt,
+			backdated:   backdated,
+		}
+	}
+	return emails
+}
+
+// generatedEmailsLocked returns a user's generated emails of the windows from the one
+// containing from to the last one ended by now
+// Caller must hold emailStoreMutex
+func (s *Store) generatedEmailsLocked(userID uuid.UUID, from, now time.Time) []generatedEmail {
+	if _, ok := s.scaleUsers[userID]; !ok {
+		return nil
+	}
+	// Compared as times first: the zero time is out of UnixNano's range
+	first := s.scaleFrom
+	if from.After(s.windowStart(first)) {
+		first = s.windowOf(from)
+	}
+	if !now.After(s.windowStart(first)) {
+		return nil
+	}
+	var emails []generatedEmail
+	for window := first; window < s.windowOf(now); window++ {
+		emails = append(emails, s.windowEmails(userID, window)...)
+	}
+	return emails
+}
+
+// renderGeneratedLocked renders a generated email of a user
+// Caller must hold emailStoreMutex
+func (s *Store) renderGeneratedLocked(userID uuid.UUID, e generatedEmail) models.ProviderEmail {
+	index := s.scaleUsers[userID]
+	rng := seededRand(userID, e.window, e.index+1)
+	from, subject := s.profileFor(userID).pickSender(rng)
+	return generateEmail(rng, e.messageID, userID, userEmailFor(index), userNameFor(index), from, subject, e.receivedAt, int(e.window), e.index)
+}
+
+// findGeneratedLocked regenerates a user's email from its message ID
+// Caller must hold emailStoreMutex
+func (s *Store) findGeneratedLocked(userID uuid.UUID, messageID string) (models.ProviderEmail, bool) {
+	id, err := uuid.Parse(messageID)
+	if err != nil {
+		return models.ProviderEmail{}, false
+	}
+	window, index, ok := parseGeneratedMessageID(userID, id)
+	if _, exists := s.scaleUsers[userID]; !ok || !exists || window < s.scaleFrom || window >= s.windowOf(s.now()) {
+		return models.ProviderEmail{}, false
+	}
+	emails := s.windowEmails(userID, window)
+	if index >= len(emails) {
+		return models.ProviderEmail{}, false
+	}
+	return s.renderGeneratedLocked(userID, emails[index]), true
+}
+
+// generatedMessageID encodes a user, window and position into a version 8 (custom) UUID:
+// 48 bits of the user ID, 12 bits of position and 62 bits of window
+func generatedMessageID(userID uuid.UUID, window int64, index int) uuid.UUID {
+	var id uuid.UUID
+	copy(id[:6], userID[:6])
+	id[6] = 0x80 | byte(index>>8)&0x0f
+	id[7] = byte(index)
+	binary.BigEndian.PutUint64(id[8:], uint64(window))
+	id[8] = 0x80 | id[8]&0x3f // RFC 4122 variant
+	return id
+}
+
+// parseGeneratedMessageID decodes a message ID from generatedMessageID for userID
+func parseGeneratedMessageID(userID uuid.UUID, id uuid.UUID) (int64, int, bool) {
+	if id.Version() != 8 || [6]byte(id[:6]) != [6]byte(userID[:6]) {
+		return 0, 0, false
+	}
+	window := int64(binary.BigEndian.Uint64(id[8:]) &^ (0xc0 << 56))
+	return window, int(id[6]&0x0f)<<8 | int(id[7]), true
+}
+
+// seededRand returns the random source of a user's window (n = 0) or of the window's
+// email n-1, so content generated later does not shift what comes before
+func seededRand(userID uuid.UUID, window int64, n int) *rand.Rand {
+	h := fnv.New64a()
+	h.Write(userID[:])
+	binary.Write(h, binary.BigEndian, window)
+	binary.Write(h, binary.BigEndian, int64(n))
+	return rand.New(&splitMix{state: h.Sum64()})
+}
+
+// splitMix is a SplitMix64 rand.Source: one word of state, so seeding a source per window
+// and per email costs nothing, unlike rand.NewSource
+type splitMix struct {
+	state uint64
+}
+
+func (r *splitMix) Uint64() uint64 {
+	r.state += 0x9e3779b97f4a7c15
+	z := r.state
+	z = (z ^ z>>30) * 0xbf58476d1ce4e5b9
+	z = (z ^ z>>27) * 0x94d049bb133111eb
+	return z ^ z>>31
+}
+
+func (r *splitMix) Int63() int64 { return int64(r.Uint64() >> 1) }
+
+func (r *splitMix) Seed(seed int64) { r.state = uint64(seed) }
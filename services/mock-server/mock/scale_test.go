@@ -0,0 +1,93 @@
+package mock
+
+import (
+	"reflect"
+	"testing"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/stoik/vigil/internal/clock"
+)
+
+func TestGeneratedMessageID(t *testing.T) {
+	userID := uuid.New()
+	window := int64(58_000_000)
+	id := generatedMessageID(userID, window, 1234)
+	if id.Version() != 8 || id.Variant() != uuid.RFC4122 {
+		t.Errorf("version %d variant %v, want a version 8 RFC 4122 UUID", id.Version(), id.Variant())
+	}
+	if w, i, ok := parseGeneratedMessageID(userID, id); !ok || w != window || i != 1234 {
+		t.Errorf("parse = %d, %d, %v; want %d, 1234", w, i, ok, window)
+	}
+	if _, _, ok := parseGeneratedMessageID(uuid.New(), id); ok {
+		t.Error("message ID parsed for another user")
+	}
+}
+
+func TestScaleMode(t *testing.T) {
+	clk := clock.NewFake(time.Date(2024, 3, 1, 12, 0, 0, 0, time.UTC))
+	newStore := func() *Store {
+		s, err := NewStore(Options{Users: 3, Scale: true, EmailsPerTick: 2, GenerationInterval: time.Minute, Clock: clk})
+		if err != nil {
+			t.Fatalf("NewStore: %v", err)
+		}
+		return s
+	}
+	s := newStore()
+	users, _ := s.GetGoogleUsers(s.TenantID())
+	userID := users[0].ID
+
+	if emails, _ := s.GetGoogleEmails(userID, time.Time{}, ""); len(emails) != 0 {
+		t.Errorf("%d emails before the first window ended, want none", len(emails))
+	}
+
+	// Windows end every minute: 10 minutes later, the 10 windows since creation have 2 emails each
+	clk.Advance(10*time.Minute + time.Second)
+	emails, _ := s.GetGoogleEmails(userID, time.Time{}, "")
+	if len(emails) != 20 {
+		t.Fatalf("%d emails after 10 windows, want 20", len(emails))
+	}
+	again, _ := s.GetGoogleEmails(userID, time.Time{}, "")
+	if !reflect.DeepEqual(emails, again) {
+		t.Error("emails differ between polls")
+	}
+	// Restarting (same users, same clock) generates the same mailboxes
+	restarted := newStore()
+	restarted.scaleFrom = s.scaleFrom
+	if other, _ := restarted.GetGoogleEmails(userID, time.Time{}, ""); !reflect.DeepEqual(emails, other) {
+		t.Error("emails differ after a restart")
+	}
+
+	since := emails[len(emails)-5].ReceivedAt
+	recent, _ := s.GetGoogleEmails(userID, since, "")
+	if len(recent) < 5 || len(recent) >= 20 {
+		t.Errorf("%d emails received since %v, want the last ones", len(recent), since)
+	}
+
+	email, ok := s.GetGoogleEmail(userID, emails[3].MessageID)
+	if !ok || !reflect.DeepEqual(email, emails[3]) {
+		t.Error("single email lookup differs from the listed email")
+	}
+	if _, ok := s.GetGoogleEmail(users[1].ID, emails[3].MessageID); ok {
+		t.Error("email found in another user's mailbox")
+	}
+
+	served := make(map[string]bool)
+	for _, email := range emails {
+		served[email.MessageID] = true
+	}
+	truth := s.GetGroundTruth(userID, time.Time{}, clk.Now())
+	if len(truth.Emails) != 20 {
+		t.Errorf("ground truth lists %d emails, want the 20 served", len(truth.Emails))
+	}
+	for _, email := range truth.Emails {
+		if !served[email.MessageID] {
+			t.Errorf("ground truth email %s was not served", email.MessageID)
+		}
+	}
+
+	// Mailboxes are not stored
+	if n := len(s.emailStore[userID]); n != 0 {
+		t.Errorf("%d emails stored, want none", n)
+	}
+}